@@ -0,0 +1,227 @@
+package peerdb
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+// Role determines which claims the caller can access.
+type Role int
+
+const (
+	// RolePublic cannot access claims of restricted properties.
+	RolePublic Role = iota
+	// RoleModerator can moderate annotations, but cannot access claims of restricted properties.
+	RoleModerator
+	// RoleElevated can access all claims and can moderate annotations.
+	RoleElevated
+)
+
+// roleContextKey is the context key for the role of the caller.
+var roleContextKey = &contextKey{"role"} //nolint:gochecknoglobals
+
+// getRole returns the role of the caller. Without the role in the context
+// (e.g., when used as a Go library) it returns RoleElevated.
+func getRole(ctx context.Context) Role {
+	role, ok := ctx.Value(roleContextKey).(Role)
+	if !ok {
+		return RoleElevated
+	}
+	return role
+}
+
+// requireElevated replies to the request with the 403 (forbidden) HTTP code
+// and returns false if the caller does not have the elevated role.
+func (s *Service) requireElevated(w http.ResponseWriter, req *http.Request) bool {
+	if getRole(req.Context()) != RoleElevated {
+		s.replyWithError(w, req, http.StatusForbidden, errors.New("elevated role required"))
+		return false
+	}
+	return true
+}
+
+// requireModerator replies to the request with the 403 (forbidden) HTTP code
+// and returns false if the caller does not have the moderator or the elevated role.
+func (s *Service) requireModerator(w http.ResponseWriter, req *http.Request) bool {
+	if role := getRole(req.Context()); role != RoleModerator && role != RoleElevated {
+		s.replyWithError(w, req, http.StatusForbidden, errors.New("moderator role required"))
+		return false
+	}
+	return true
+}
+
+// roleMiddleware determines the role of the caller and stores it into the request context.
+// If the role cannot be determined, the request is rejected.
+func (s *Service) roleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		role, errE := waf.MustGetSite[*Site](req.Context()).requestRole(req)
+		if errE != nil {
+			s.replyWithError(w, req, http.StatusUnauthorized, errE)
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(withRole(req.Context(), waf.MustGetSite[*Site](req.Context()), role)))
+	})
+}
+
+// withRole stores the role into the context. Unless the role is elevated, searches
+// in the returned context cannot access claims of site's restricted properties.
+func withRole(ctx context.Context, site *Site, role Role) context.Context {
+	ctx = context.WithValue(ctx, roleContextKey, role)
+	if role != RoleElevated {
+		ctx = search.WithRestricted(ctx, site.RestrictedProperties)
+	}
+	return ctx
+}
+
+// requestRole returns the role of the caller of the request.
+//
+// The caller has the elevated role if it provides one of site's elevated tokens as a bearer token
+// in the Authorization header, and the moderator role if it provides one of site's moderator tokens.
+// It is an error to provide an invalid token.
+func (s *Site) requestRole(req *http.Request) (Role, errors.E) {
+	return s.authorizationRole(req.Header.Get("Authorization"))
+}
+
+// authorizationRole returns the role of the caller based on the value of the Authorization header.
+func (s *Site) authorizationRole(authorization string) (Role, errors.E) {
+	if authorization == "" {
+		return RolePublic, nil
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return RolePublic, errors.New("unsupported authorization scheme")
+	}
+	settings := s.settings()
+	if isToken(settings.ElevatedTokens, token) {
+		return RoleElevated, nil
+	}
+	if isToken(settings.ModeratorTokens, token) {
+		return RoleModerator, nil
+	}
+	return RolePublic, errors.New("invalid token")
+}
+
+func (s *Site) isElevatedToken(token string) bool {
+	return isToken(s.settings().ElevatedTokens, token)
+}
+
+// isToken returns true if token is one of tokens, comparing them in constant time.
+func isToken(tokens []string, token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// isRestricted returns true if claims of the property cannot be accessed by the caller.
+func (s *Site) isRestricted(ctx context.Context, prop identifier.Identifier) bool {
+	return getRole(ctx) != RoleElevated && slices.Contains(s.RestrictedProperties, prop)
+}
+
+// checkRestrictedFilters returns search.ErrRestricted if filters use a property
+// whose claims cannot be accessed by the caller.
+func (s *Site) checkRestrictedFilters(ctx context.Context, filtersJSON string) errors.E {
+	if getRole(ctx) == RoleElevated {
+		return nil
+	}
+	return search.CheckRestrictedFilters(search.WithRestricted(ctx, s.RestrictedProperties), filtersJSON)
+}
+
+// removeRestrictedClaims removes claims (including meta claims) of properties in props.
+func removeRestrictedClaims(container document.ClaimsContainer, props []identifier.Identifier) {
+	for _, prop := range props {
+		container.Remove(prop)
+	}
+	for _, claim := range container.AllClaims() {
+		removeRestrictedClaims(claim, props)
+	}
+}
+
+// hasRestrictedClaims returns true if the container has claims (including meta claims) of properties in props.
+func hasRestrictedClaims(container document.ClaimsContainer, props []identifier.Identifier) bool {
+	for _, prop := range props {
+		if len(container.Get(prop)) > 0 {
+			return true
+		}
+	}
+	for _, claim := range container.AllClaims() {
+		if hasRestrictedClaims(claim, props) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRestrictedChanges returns search.ErrRestricted if changes of the document add claims of
+// properties whose claims cannot be accessed by the caller. Changes are first applied to the
+// document as seen by the caller, so changes of claims hidden from the caller fail in the same
+// way as changes of claims which do not exist, and the error of applying them is returned.
+func (s *Site) checkRestrictedChanges(ctx context.Context, data json.RawMessage, changes document.Changes) errors.E {
+	if getRole(ctx) == RoleElevated || len(s.RestrictedProperties) == 0 {
+		return nil
+	}
+	var doc document.D
+	errE := x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return errE
+	}
+	s.filterDocument(ctx, &doc)
+	errE = changes.Apply(&doc)
+	if errE != nil {
+		return errE
+	}
+	if hasRestrictedClaims(&doc, s.RestrictedProperties) {
+		return errors.WithMessage(search.ErrRestricted, "changes use a restricted property")
+	}
+	for i := range doc.Children {
+		if hasRestrictedClaims(&doc.Children[i], s.RestrictedProperties) {
+			return errors.WithMessage(search.ErrRestricted, "changes use a restricted property")
+		}
+	}
+	return nil
+}
+
+// filterDocument removes claims which the caller cannot access from the document.
+func (s *Site) filterDocument(ctx context.Context, doc *document.D) {
+	if getRole(ctx) == RoleElevated || len(s.RestrictedProperties) == 0 {
+		return
+	}
+	removeRestrictedClaims(doc, s.RestrictedProperties)
+	for i := range doc.Children {
+		removeRestrictedClaims(&doc.Children[i], s.RestrictedProperties)
+	}
+}
+
+// filterDocumentJSON is like filterDocument, but for JSON of the document.
+//
+// If there is nothing to filter, data is returned as-is.
+func (s *Site) filterDocumentJSON(ctx context.Context, data json.RawMessage) (json.RawMessage, errors.E) {
+	if getRole(ctx) == RoleElevated || len(s.RestrictedProperties) == 0 {
+		return data, nil
+	}
+	var doc document.D
+	errE := x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return nil, errE
+	}
+	s.filterDocument(ctx, &doc)
+	return x.MarshalWithoutEscapeHTML(doc)
+}
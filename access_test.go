@@ -0,0 +1,167 @@
+package peerdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestFilterDocument(t *testing.T) {
+	t.Parallel()
+
+	public := identifier.New()
+	restricted := identifier.New()
+
+	stringClaim := func(prop identifier.Identifier, value string) document.StringClaim {
+		return document.StringClaim{
+			CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence},
+			Prop:      document.Reference{ID: &prop},
+			String:    value,
+		}
+	}
+
+	newDoc := func() *document.D {
+		claim := stringClaim(public, "name")
+		claim.Meta = &document.ClaimTypes{
+			String: document.StringClaims{stringClaim(restricted, "meta email")},
+		}
+		return &document.D{
+			CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence},
+			Claims: &document.ClaimTypes{
+				String: document.StringClaims{claim, stringClaim(restricted, "email")},
+			},
+		}
+	}
+
+	site := &Site{RestrictedProperties: []identifier.Identifier{restricted}} //nolint:exhaustruct
+
+	doc := newDoc()
+	site.filterDocument(context.WithValue(context.Background(), roleContextKey, RoleElevated), doc)
+	assert.Len(t, doc.Get(restricted), 1)
+	assert.Len(t, doc.Get(public)[0].Get(restricted), 1)
+
+	doc = newDoc()
+	site.filterDocument(context.WithValue(context.Background(), roleContextKey, RolePublic), doc)
+	assert.Empty(t, doc.Get(restricted))
+	require.Len(t, doc.Get(public), 1)
+	assert.Empty(t, doc.Get(public)[0].Get(restricted))
+}
+
+func TestCheckRestrictedChanges(t *testing.T) {
+	t.Parallel()
+
+	public := identifier.New()
+	restricted := identifier.New()
+	publicClaimID := identifier.New()
+	restrictedClaimID := identifier.New()
+
+	doc := &document.D{
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence},
+		Claims: &document.ClaimTypes{
+			String: document.StringClaims{
+				{
+					CoreClaim: document.CoreClaim{ID: publicClaimID, Confidence: document.HighConfidence},
+					Prop:      document.Reference{ID: &public},
+					String:    "name",
+				},
+				{
+					CoreClaim: document.CoreClaim{ID: restrictedClaimID, Confidence: document.HighConfidence},
+					Prop:      document.Reference{ID: &restricted},
+					String:    "email",
+				},
+			},
+		},
+	}
+	data, errE := x.MarshalWithoutEscapeHTML(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	value := "value"
+	confidence := document.Confidence(document.HighConfidence)
+	stringPatch := func(prop identifier.Identifier) document.StringClaimPatch {
+		return document.StringClaimPatch{Confidence: &confidence, Prop: &prop, String: &value}
+	}
+
+	site := &Site{RestrictedProperties: []identifier.Identifier{restricted}} //nolint:exhaustruct
+	publicCtx := context.WithValue(context.Background(), roleContextKey, RolePublic)
+	elevatedCtx := context.WithValue(context.Background(), roleContextKey, RoleElevated)
+
+	// Changing claims of public properties is allowed.
+	errE = site.checkRestrictedChanges(publicCtx, data, document.Changes{
+		document.SetClaimChange{ID: publicClaimID, Patch: document.StringClaimPatch{String: &value}}, //nolint:exhaustruct
+		document.AddClaimChange{Under: &publicClaimID, ID: identifier.New(), Patch: stringPatch(public)},
+	})
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	// Changing a hidden claim fails in the same way as changing a claim which does not exist.
+	for _, change := range []document.Change{
+		document.SetClaimChange{ID: restrictedClaimID, Patch: document.StringClaimPatch{String: &value}}, //nolint:exhaustruct
+		document.RemoveClaimChange{ID: restrictedClaimID},
+		document.AddClaimChange{Under: &restrictedClaimID, ID: identifier.New(), Patch: stringPatch(public)},
+	} {
+		errE = site.checkRestrictedChanges(publicCtx, data, document.Changes{change})
+		assert.EqualError(t, errE, `claim with ID "`+restrictedClaimID.String()+`" not found`)
+		assert.NotErrorIs(t, errE, search.ErrRestricted)
+	}
+
+	// Adding claims (or meta claims) of restricted properties or changing a claim to one is not allowed.
+	for _, change := range []document.Change{
+		document.AddClaimChange{Under: nil, ID: identifier.New(), Patch: stringPatch(restricted)},
+		document.AddClaimChange{Under: &publicClaimID, ID: identifier.New(), Patch: stringPatch(restricted)},
+		document.SetClaimChange{ID: publicClaimID, Patch: document.StringClaimPatch{Prop: &restricted}}, //nolint:exhaustruct
+	} {
+		errE = site.checkRestrictedChanges(publicCtx, data, document.Changes{change})
+		assert.ErrorIs(t, errE, search.ErrRestricted)
+	}
+
+	// The elevated role can change all claims.
+	errE = site.checkRestrictedChanges(elevatedCtx, data, document.Changes{
+		document.RemoveClaimChange{ID: restrictedClaimID},
+		document.AddClaimChange{Under: nil, ID: identifier.New(), Patch: stringPatch(restricted)},
+	})
+	assert.NoError(t, errE, "% -+#.1v", errE)
+}
+
+func TestRequestRole(t *testing.T) {
+	t.Parallel()
+
+	site := &Site{ElevatedTokens: []string{"secret"}, ModeratorTokens: []string{"moderator"}} //nolint:exhaustruct
+
+	tests := []struct {
+		authorization string
+		role          Role
+		valid         bool
+	}{
+		{"", RolePublic, true},
+		{"Bearer secret", RoleElevated, true},
+		{"Bearer moderator", RoleModerator, true},
+		{"Bearer wrong", RolePublic, false},
+		{"Bearer ", RolePublic, false},
+		{"Basic secret", RolePublic, false},
+	}
+	for _, test := range tests {
+		t.Run(test.authorization, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			role, errE := site.requestRole(req)
+			if test.valid {
+				assert.NoError(t, errE, "% -+#.1v", errE)
+			} else {
+				assert.Error(t, errE)
+			}
+			assert.Equal(t, test.role, role)
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package peerdb
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/annotations"
+	"gitlab.com/peerdb/peerdb/document"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+type annotationCreateRequest struct {
+	Claim   *identifier.Identifier `json:"claim,omitempty"`
+	Flag    string                 `json:"flag,omitempty"`
+	Comment string                 `json:"comment,omitempty"`
+}
+
+type annotationCreateResponse struct {
+	ID identifier.Identifier `json:"id"`
+}
+
+type annotationResolveRequest struct {
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// getAnnotatedDocument returns the document with the ID, with claims which the caller cannot
+// access removed, or replies with an error.
+func (s *Service) getAnnotatedDocument(w http.ResponseWriter, req *http.Request, id identifier.Identifier) (*document.D, bool) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+	site := waf.MustGetSite[*Site](ctx)
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	data, _, _, errE := site.store.GetLatest(ctx, id)
+	m.Stop()
+	if errors.Is(errE, store.ErrValueNotFound) {
+		s.NotFoundWithError(w, req, errE)
+		return nil, false
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return nil, false
+	}
+
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return nil, false
+	}
+	site.filterDocument(ctx, &doc)
+	return &doc, true
+}
+
+// filterAnnotations removes annotations of claims which the caller cannot access.
+// Documents of annotations are loaded only if there are restricted properties.
+func (s *Site) filterAnnotations(ctx context.Context, list []annotations.Annotation) ([]annotations.Annotation, errors.E) {
+	if getRole(ctx) == RoleElevated || len(s.RestrictedProperties) == 0 {
+		return list, nil
+	}
+	docs := map[identifier.Identifier]*document.D{}
+	filtered := make([]annotations.Annotation, 0, len(list))
+	for _, a := range list {
+		if a.Claim == nil {
+			filtered = append(filtered, a)
+			continue
+		}
+		doc, ok := docs[a.Document]
+		if !ok {
+			data, _, _, errE := s.store.GetLatest(ctx, a.Document)
+			if errors.Is(errE, store.ErrValueNotFound) {
+				// Document has been deleted, we do not know if the claim was restricted.
+				docs[a.Document] = nil
+				continue
+			} else if errE != nil {
+				return nil, errE
+			}
+			doc = new(document.D)
+			errE = x.UnmarshalWithoutUnknownFields(data, doc)
+			if errE != nil {
+				errors.Details(errE)["id"] = a.Document.String()
+				return nil, errE
+			}
+			s.filterDocument(ctx, doc)
+			docs[a.Document] = doc
+		}
+		if doc != nil && doc.GetByID(*a.Claim) != nil {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered, nil
+}
+
+// parseAnnotationsQuery parses optional "claim" and "status" parameters, or replies with an error.
+func (s *Service) parseAnnotationsQuery(w http.ResponseWriter, req *http.Request) (annotations.Query, bool) {
+	query := annotations.Query{Document: nil, Claim: nil, Status: annotations.StatusAll}
+	if req.Form.Has("claim") {
+		claim, errE := identifier.FromString(req.Form.Get("claim"))
+		if errE != nil {
+			s.BadRequestWithError(w, req, errors.WithMessage(errE, `"claim" is not a valid identifier`))
+			return query, false
+		}
+		query.Claim = &claim
+	}
+	status, errE := annotations.ParseStatus(req.Form.Get("status"))
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return query, false
+	}
+	query.Status = status
+	return query, true
+}
+
+// DocumentAnnotationsGet is a GET/HEAD HTTP request handler which returns annotations
+// (comments and flags) of the document with the ID given as a parameter, oldest first.
+// Optional "claim" parameter limits annotations to those of the claim and optional "status"
+// parameter to "unresolved" or "resolved" annotations.
+func (s *Service) DocumentAnnotationsGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"id" is not a valid identifier`))
+		return
+	}
+
+	query, ok := s.parseAnnotationsQuery(w, req)
+	if !ok {
+		return
+	}
+	query.Document = &id
+
+	site := waf.MustGetSite[*Site](ctx)
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	list, errE := site.annotations.List(ctx, query)
+	if errE == nil {
+		list, errE = site.filterAnnotations(ctx, list)
+	}
+	m.Stop()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, list, nil)
+}
+
+// DocumentAnnotationsPost is a POST HTTP request handler which creates an annotation
+// of the document with the ID given as a parameter or, if "claim" is set in the request body,
+// of its claim. The annotation is a flag (e.g., "incorrect value"), a comment, or both.
+// The author of the annotation is the caller, identified by its API key or, without it,
+// by its IP address (see clientKey). Callers can create a limited number of annotations per hour.
+func (s *Service) DocumentAnnotationsPost(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"id" is not a valid identifier`))
+		return
+	}
+
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	if !s.validateJSON(w, req, "annotationCreateRequest", buffer) {
+		return
+	}
+
+	var r annotationCreateRequest
+	errE = x.UnmarshalWithoutUnknownFields(buffer, &r)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	annotation := &annotations.Annotation{ //nolint:exhaustruct
+		Document: id,
+		Claim:    r.Claim,
+		Flag:     r.Flag,
+		Comment:  r.Comment,
+		Author:   clientKey(req),
+	}
+	errE = annotation.Validate()
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	errE = s.annotationsLimiter.Allow(annotation.Author)
+	if errE != nil {
+		s.replyWithError(w, req, http.StatusTooManyRequests, errE)
+		return
+	}
+
+	doc, ok := s.getAnnotatedDocument(w, req, id)
+	if !ok {
+		return
+	}
+	if r.Claim != nil && doc.GetByID(*r.Claim) == nil {
+		errE := errors.New("claim not found")
+		errors.Details(errE)["claim"] = r.Claim.String()
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	errE = waf.MustGetSite[*Site](ctx).annotations.Create(ctx, annotation)
+	m.Stop()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, annotationCreateResponse{ID: annotation.ID}, nil)
+}
+
+// DocumentResolveAnnotationPost is a POST HTTP request handler which resolves the annotation
+// with the ID given as a parameter, with an optional resolution note. It requires the moderator role.
+func (s *Service) DocumentResolveAnnotationPost(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireModerator(w, req) {
+		return
+	}
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	id, errE := identifier.FromString(params["annotation"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"annotation" is not a valid identifier`))
+		return
+	}
+
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	if !s.validateJSON(w, req, "annotationResolveRequest", buffer) {
+		return
+	}
+
+	var r annotationResolveRequest
+	errE = x.UnmarshalWithoutUnknownFields(buffer, &r)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	annotation, errE := waf.MustGetSite[*Site](ctx).annotations.Resolve(ctx, id, apiKey(req), r.Resolution)
+	m.Stop()
+	if errors.Is(errE, annotations.ErrNotFound) {
+		s.NotFoundWithError(w, req, errE)
+		return
+	} else if errors.Is(errE, annotations.ErrAlreadyResolved) {
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errors.Is(errE, annotations.ErrInvalidArgument) {
+		s.BadRequestWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, annotation, nil)
+}
+
+// AdminAnnotationsGet is a GET/HEAD HTTP request handler which returns annotations of
+// all documents of the site, oldest first, as a moderation queue. By default only unresolved
+// annotations are returned, optional "status" parameter can be set to "resolved" or "all".
+// It requires the moderator role.
+func (s *Service) AdminAnnotationsGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	if !s.requireModerator(w, req) {
+		return
+	}
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	query := annotations.Query{Document: nil, Claim: nil, Status: annotations.StatusUnresolved}
+	switch status := req.Form.Get("status"); status {
+	case "":
+	case "all":
+		query.Status = annotations.StatusAll
+	default:
+		var errE errors.E
+		query.Status, errE = annotations.ParseStatus(status)
+		if errE != nil {
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
+	}
+
+	site := waf.MustGetSite[*Site](ctx)
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	list, errE := site.annotations.List(ctx, query)
+	if errE == nil {
+		list, errE = site.filterAnnotations(ctx, list)
+	}
+	m.Stop()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, list, nil)
+}
@@ -0,0 +1,338 @@
+// Package annotations provides user annotations (comments and flags) of documents and
+// their claims, stored in PostgreSQL, for collaborative curation of (imported) data.
+package annotations
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+const (
+	// MaxCommentLength is the maximum length of comments and resolution notes, in characters.
+	MaxCommentLength = 4000
+
+	// MaxListSize is the maximum number of annotations returned by List.
+	MaxListSize = 1000
+)
+
+// Flags which can be used to mark a document or a claim.
+const (
+	FlagIncorrectValue = "incorrect value"
+	FlagMissingValue   = "missing value"
+	FlagDuplicate      = "duplicate"
+	FlagOutdated       = "outdated"
+	FlagInappropriate  = "inappropriate"
+)
+
+//nolint:gochecknoglobals
+var flags = []string{FlagIncorrectValue, FlagMissingValue, FlagDuplicate, FlagOutdated, FlagInappropriate}
+
+// Annotation is a comment or a flag (optionally with a comment) attached to a document
+// or to a specific claim of the document.
+type Annotation struct {
+	ID       identifier.Identifier  `json:"id"`
+	Document identifier.Identifier  `json:"document"`
+	Claim    *identifier.Identifier `json:"claim,omitempty"`
+	Flag     string                 `json:"flag,omitempty"`
+	Comment  string                 `json:"comment,omitempty"`
+
+	// Author identifies the caller which created the annotation.
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+
+	// Resolved is set when a moderator resolved the annotation, with an optional resolution note.
+	Resolved   *time.Time `json:"resolved,omitempty"`
+	ResolvedBy string     `json:"resolvedBy,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+}
+
+// Validate validates fields of the annotation provided by its author.
+func (a *Annotation) Validate() errors.E {
+	if a.Flag == "" && a.Comment == "" {
+		return errors.WithMessage(ErrInvalidArgument, "flag or comment has to be set")
+	}
+	if a.Flag != "" && !slices.Contains(flags, a.Flag) {
+		errE := errors.WithMessage(ErrInvalidArgument, "unknown flag")
+		errors.Details(errE)["flag"] = a.Flag
+		return errE
+	}
+	if utf8.RuneCountInString(a.Comment) > MaxCommentLength {
+		errE := errors.WithMessage(ErrInvalidArgument, "comment too long")
+		errors.Details(errE)["max"] = MaxCommentLength
+		return errE
+	}
+	if a.Author == "" {
+		return errors.WithMessage(ErrInvalidArgument, "author has to be set")
+	}
+	return nil
+}
+
+// Status limits which annotations are listed.
+type Status string
+
+const (
+	StatusAll        Status = ""
+	StatusUnresolved Status = "unresolved"
+	StatusResolved   Status = "resolved"
+)
+
+// ParseStatus parses the status, which can be empty (all annotations).
+func ParseStatus(s string) (Status, errors.E) {
+	switch status := Status(s); status {
+	case StatusAll, StatusUnresolved, StatusResolved:
+		return status, nil
+	default:
+		errE := errors.WithMessage(ErrInvalidArgument, "unknown status")
+		errors.Details(errE)["status"] = s
+		return "", errE
+	}
+}
+
+// Query limits annotations returned by List. All set fields have to match.
+type Query struct {
+	Document *identifier.Identifier
+	Claim    *identifier.Identifier
+	Status   Status
+}
+
+// Annotations stores annotations in PostgreSQL.
+type Annotations struct {
+	// Prefix to use when initializing PostgreSQL objects used by annotations.
+	Prefix string
+
+	dbpool *pgxpool.Pool
+}
+
+// Init initializes the Annotations.
+//
+// It creates and configures the PostgreSQL table if it does not already exist.
+func (a *Annotations) Init(ctx context.Context, dbpool *pgxpool.Pool) errors.E {
+	if a.dbpool != nil {
+		return errors.New("already initialized")
+	}
+
+	// TODO: Use schema management/migration instead.
+	errE := internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		_, err := tx.Exec(ctx, `
+			CREATE TABLE "`+a.Prefix+`Annotations" (
+				"id" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				-- ID of the annotated document.
+				"document" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				-- ID of the annotated claim, if the annotation is about a claim.
+				"claim" text STORAGE PLAIN COLLATE "C",
+				"flag" text,
+				"comment" text NOT NULL,
+				"author" text NOT NULL,
+				"created" timestamptz NOT NULL,
+				"resolved" timestamptz,
+				"resolvedBy" text,
+				"resolution" text,
+				PRIMARY KEY ("id")
+			);
+			CREATE INDEX ON "`+a.Prefix+`Annotations" USING btree ("document", "created", "id");
+			CREATE INDEX ON "`+a.Prefix+`Annotations" USING btree ("created", "id") WHERE "resolved" IS NULL;
+		`)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+
+		return nil
+	}, nil)
+	if errE != nil {
+		var pgError *pgconn.PgError
+		if errors.As(errE, &pgError) {
+			switch pgError.Code {
+			case internal.ErrorCodeUniqueViolation:
+				// Nothing.
+			case internal.ErrorCodeDuplicateTable:
+				// Nothing.
+			case internal.ErrorCodeReadOnlyTransaction:
+				// In read-only mode, tables have to be created by another instance.
+			default:
+				return errE
+			}
+		} else {
+			return errE
+		}
+	}
+
+	a.dbpool = dbpool
+
+	return nil
+}
+
+const columns = `"id", "document", "claim", "flag", "comment", "author", "created", "resolved", "resolvedBy", "resolution"`
+
+func scanAnnotation(row pgx.Row) (Annotation, error) {
+	var a Annotation
+	var id, document string
+	var claim, flag, resolvedBy, resolution *string
+	err := row.Scan(&id, &document, &claim, &flag, &a.Comment, &a.Author, &a.Created, &a.Resolved, &resolvedBy, &resolution)
+	if err != nil {
+		return a, err
+	}
+	a.ID = identifier.MustFromString(id)
+	a.Document = identifier.MustFromString(document)
+	if claim != nil {
+		c := identifier.MustFromString(*claim)
+		a.Claim = &c
+	}
+	if flag != nil {
+		a.Flag = *flag
+	}
+	if resolvedBy != nil {
+		a.ResolvedBy = *resolvedBy
+	}
+	if resolution != nil {
+		a.Resolution = *resolution
+	}
+	return a, nil
+}
+
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Create stores a new annotation. ID and creation time of the annotation are set by Create
+// and resolution fields are ignored. The annotation should be valid (see Annotation.Validate).
+func (a *Annotations) Create(ctx context.Context, annotation *Annotation) errors.E {
+	id := identifier.New()
+	var claim *string
+	if annotation.Claim != nil {
+		c := annotation.Claim.String()
+		claim = &c
+	}
+	arguments := []any{
+		id.String(), annotation.Document.String(), claim, nullString(annotation.Flag), annotation.Comment, annotation.Author,
+	}
+	var created time.Time
+	errE := internal.RetryTransaction(ctx, a.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO "`+a.Prefix+`Annotations" VALUES ($1, $2, $3, $4, $5, $6, now(), NULL, NULL, NULL)
+				RETURNING "created"
+		`, arguments...).Scan(&created)
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["document"] = annotation.Document.String()
+		return errE
+	}
+	annotation.ID = id
+	annotation.Created = created
+	annotation.Resolved = nil
+	annotation.ResolvedBy = ""
+	annotation.Resolution = ""
+	return nil
+}
+
+// Get returns the annotation with the ID.
+func (a *Annotations) Get(ctx context.Context, id identifier.Identifier) (*Annotation, errors.E) {
+	var annotation Annotation
+	errE := internal.RetryTransaction(ctx, a.dbpool, pgx.ReadOnly, func(ctx context.Context, tx pgx.Tx) errors.E {
+		var err error
+		annotation, err = scanAnnotation(tx.QueryRow(ctx, `SELECT `+columns+` FROM "`+a.Prefix+`Annotations" WHERE "id"=$1`, id.String()))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.WithStack(ErrNotFound)
+		}
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["id"] = id.String()
+		return nil, errE
+	}
+	return &annotation, nil
+}
+
+// List returns up to MaxListSize annotations matching the query, oldest first.
+func (a *Annotations) List(ctx context.Context, query Query) ([]Annotation, errors.E) {
+	sql := `SELECT ` + columns + ` FROM "` + a.Prefix + `Annotations" WHERE TRUE`
+	arguments := []any{}
+	if query.Document != nil {
+		arguments = append(arguments, query.Document.String())
+		sql += ` AND "document"=$1`
+	}
+	if query.Claim != nil {
+		arguments = append(arguments, query.Claim.String())
+		sql += ` AND "claim"=$` + strconv.Itoa(len(arguments))
+	}
+	switch query.Status {
+	case StatusUnresolved:
+		sql += ` AND "resolved" IS NULL`
+	case StatusResolved:
+		sql += ` AND "resolved" IS NOT NULL`
+	case StatusAll:
+	}
+	arguments = append(arguments, MaxListSize)
+	sql += ` ORDER BY "created", "id" LIMIT $` + strconv.Itoa(len(arguments))
+
+	var annotations []Annotation
+	errE := internal.RetryTransaction(ctx, a.dbpool, pgx.ReadOnly, func(ctx context.Context, tx pgx.Tx) errors.E {
+		rows, err := tx.Query(ctx, sql, arguments...)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+		annotations, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Annotation, error) {
+			return scanAnnotation(row)
+		})
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		return nil, errE
+	}
+	if annotations == nil {
+		annotations = []Annotation{}
+	}
+	return annotations, nil
+}
+
+// Resolve marks the annotation as resolved by the moderator, with an optional resolution note.
+// It returns ErrAlreadyResolved if the annotation has already been resolved.
+func (a *Annotations) Resolve(ctx context.Context, id identifier.Identifier, by, resolution string) (*Annotation, errors.E) {
+	if utf8.RuneCountInString(resolution) > MaxCommentLength {
+		errE := errors.WithMessage(ErrInvalidArgument, "resolution too long")
+		errors.Details(errE)["max"] = MaxCommentLength
+		return nil, errE
+	}
+
+	var annotation Annotation
+	errE := internal.RetryTransaction(ctx, a.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		var err error
+		annotation, err = scanAnnotation(tx.QueryRow(ctx, `
+			UPDATE "`+a.Prefix+`Annotations" SET "resolved"=now(), "resolvedBy"=$2, "resolution"=$3
+				WHERE "id"=$1 AND "resolved" IS NULL
+				RETURNING `+columns+`
+		`, id.String(), by, nullString(resolution)))
+		if errors.Is(err, pgx.ErrNoRows) {
+			var resolved *time.Time
+			err = tx.QueryRow(ctx, `SELECT "resolved" FROM "`+a.Prefix+`Annotations" WHERE "id"=$1`, id.String()).Scan(&resolved)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return errors.WithStack(ErrNotFound)
+			} else if err != nil {
+				return internal.WithPgxError(err)
+			}
+			errE := errors.WithStack(ErrAlreadyResolved)
+			errors.Details(errE)["resolved"] = resolved
+			return errE
+		}
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["id"] = id.String()
+		return nil, errE
+	}
+	return &annotation, nil
+}
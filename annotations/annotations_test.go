@@ -0,0 +1,155 @@
+package annotations_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/annotations"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+func initDatabase(t *testing.T) (context.Context, *pgxpool.Pool, string) {
+	t.Helper()
+
+	if os.Getenv("POSTGRES") == "" {
+		t.Skip("POSTGRES is not available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	schema := identifier.New().String()
+	prefix := identifier.New().String() + "_"
+
+	dbpool, errE := internal.InitPostgres(ctx, os.Getenv("POSTGRES"), logger, func(context.Context) (string, string) {
+		return schema, "tests"
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		return internal.EnsureSchema(ctx, tx, schema)
+	}, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	return ctx, dbpool, prefix
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	claim := identifier.New()
+
+	for _, tt := range []struct {
+		annotation annotations.Annotation
+		err        string
+	}{
+		{annotations.Annotation{Document: identifier.New(), Claim: &claim, Flag: annotations.FlagIncorrectValue, Author: "public"}, ""},                          //nolint:exhaustruct
+		{annotations.Annotation{Document: identifier.New(), Comment: "Foobar.", Author: "public"}, ""},                                                           //nolint:exhaustruct
+		{annotations.Annotation{Document: identifier.New(), Author: "public"}, "flag or comment has to be set"},                                                  //nolint:exhaustruct
+		{annotations.Annotation{Document: identifier.New(), Flag: "unknown", Author: "public"}, "unknown flag"},                                                  //nolint:exhaustruct
+		{annotations.Annotation{Document: identifier.New(), Comment: strings.Repeat("x", annotations.MaxCommentLength+1), Author: "public"}, "comment too long"}, //nolint:exhaustruct,lll
+		{annotations.Annotation{Document: identifier.New(), Comment: "Foobar."}, "author has to be set"},                                                         //nolint:exhaustruct
+	} {
+		errE := tt.annotation.Validate()
+		if tt.err == "" {
+			assert.NoError(t, errE, "% -+#.1v", errE)
+		} else {
+			assert.EqualError(t, errE, tt.err+": invalid argument")
+			assert.ErrorIs(t, errE, annotations.ErrInvalidArgument)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"", "unresolved", "resolved"} {
+		status, errE := annotations.ParseStatus(s)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, annotations.Status(s), status)
+	}
+
+	_, errE := annotations.ParseStatus("closed")
+	assert.ErrorIs(t, errE, annotations.ErrInvalidArgument)
+}
+
+func TestAnnotations(t *testing.T) {
+	t.Parallel()
+
+	ctx, dbpool, prefix := initDatabase(t)
+
+	a := &annotations.Annotations{Prefix: prefix}
+	errE := a.Init(ctx, dbpool)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	doc := identifier.New()
+	claim := identifier.New()
+
+	flag := &annotations.Annotation{Document: doc, Claim: &claim, Flag: annotations.FlagOutdated, Author: "public"} //nolint:exhaustruct
+	errE = a.Create(ctx, flag)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotEmpty(t, flag.ID)
+	assert.False(t, flag.Created.IsZero())
+
+	comment := &annotations.Annotation{Document: doc, Comment: "Foobar.", Author: "public"} //nolint:exhaustruct
+	errE = a.Create(ctx, comment)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	other := &annotations.Annotation{Document: identifier.New(), Comment: "Other.", Author: "public"} //nolint:exhaustruct
+	errE = a.Create(ctx, other)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	got, errE := a.Get(ctx, flag.ID)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, flag.ID, got.ID)
+	assert.Equal(t, claim, *got.Claim)
+	assert.Equal(t, annotations.FlagOutdated, got.Flag)
+
+	_, errE = a.Get(ctx, identifier.New())
+	assert.ErrorIs(t, errE, annotations.ErrNotFound)
+
+	list, errE := a.List(ctx, annotations.Query{Document: &doc, Claim: nil, Status: annotations.StatusAll})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, list, 2)
+	assert.Equal(t, flag.ID, list[0].ID)
+	assert.Equal(t, comment.ID, list[1].ID)
+
+	list, errE = a.List(ctx, annotations.Query{Document: &doc, Claim: &claim, Status: annotations.StatusAll})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, list, 1)
+	assert.Equal(t, flag.ID, list[0].ID)
+
+	resolved, errE := a.Resolve(ctx, flag.ID, "moderator", "Updated.")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotNil(t, resolved.Resolved)
+	assert.Equal(t, "moderator", resolved.ResolvedBy)
+	assert.Equal(t, "Updated.", resolved.Resolution)
+
+	_, errE = a.Resolve(ctx, flag.ID, "moderator", "")
+	assert.ErrorIs(t, errE, annotations.ErrAlreadyResolved)
+
+	_, errE = a.Resolve(ctx, identifier.New(), "moderator", "")
+	assert.ErrorIs(t, errE, annotations.ErrNotFound)
+
+	list, errE = a.List(ctx, annotations.Query{Document: nil, Claim: nil, Status: annotations.StatusUnresolved})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, list, 2)
+	assert.Equal(t, comment.ID, list[0].ID)
+	assert.Equal(t, other.ID, list[1].ID)
+
+	list, errE = a.List(ctx, annotations.Query{Document: &doc, Claim: nil, Status: annotations.StatusResolved})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, list, 1)
+	assert.Equal(t, flag.ID, list[0].ID)
+}
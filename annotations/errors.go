@@ -0,0 +1,9 @@
+package annotations
+
+import "gitlab.com/tozd/go/errors"
+
+var (
+	ErrInvalidArgument = errors.Base("invalid argument")
+	ErrNotFound        = errors.Base("annotation not found")
+	ErrAlreadyResolved = errors.Base("annotation already resolved")
+)
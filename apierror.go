@@ -0,0 +1,242 @@
+package peerdb
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/coordinator"
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/storage"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// ErrorCode is a machine-readable code of an error returned by the API.
+//
+// Error codes are part of the public API so API clients can branch on them.
+// Existing error codes should not be changed.
+type ErrorCode string
+
+const (
+	ErrorCodeBadRequest        ErrorCode = "bad_request"
+	ErrorCodeInvalidIdentifier ErrorCode = "invalid_identifier"
+	ErrorCodeInvalidArgument   ErrorCode = "invalid_argument"
+	ErrorCodeLimitExceeded     ErrorCode = "limit_exceeded"
+	ErrorCodeInvalidBody       ErrorCode = "invalid_body"
+	ErrorCodeMalformedQuery    ErrorCode = "malformed_query"
+	ErrorCodeInvalidSynonyms   ErrorCode = "invalid_synonyms"
+	ErrorCodeInvalidUnit       ErrorCode = "invalid_unit"
+	ErrorCodeInvalidChunk      ErrorCode = "invalid_chunk"
+	ErrorCodeUnauthorized      ErrorCode = "unauthorized"
+	ErrorCodeForbidden         ErrorCode = "forbidden"
+	ErrorCodeNotFound          ErrorCode = "not_found"
+	ErrorCodeDeleted           ErrorCode = "deleted"
+	ErrorCodeConflict          ErrorCode = "conflict"
+	ErrorCodeNotReady          ErrorCode = "not_ready"
+	ErrorCodeAlreadyEnded      ErrorCode = "already_ended"
+	ErrorCodeSessionExpired    ErrorCode = "session_expired"
+	ErrorCodeBudgetExceeded    ErrorCode = "budget_exceeded"
+	ErrorCodeRateLimited       ErrorCode = "rate_limited"
+	ErrorCodeQuotaExceeded     ErrorCode = "quota_exceeded"
+	ErrorCodeRequestTimeout    ErrorCode = "request_timeout"
+	ErrorCodeOverloaded        ErrorCode = "overloaded"
+	ErrorCodeReadOnly          ErrorCode = "read_only"
+	ErrorCodeInternal          ErrorCode = "internal_error"
+)
+
+// errInvalidBody is used for request bodies which fail JSON Schema validation.
+var errInvalidBody = errors.Base("invalid body")
+
+// errorCodes maps known errors to error codes. The first error matching
+// with errors.Is determines the error code, so more specific errors come first.
+//
+//nolint:gochecknoglobals
+var errorCodes = []struct {
+	Err  error
+	Code ErrorCode
+}{
+	{context.Canceled, ErrorCodeRequestTimeout},
+	{context.DeadlineExceeded, ErrorCodeRequestTimeout},
+	{identifier.ErrInvalidIdentifier, ErrorCodeInvalidIdentifier},
+	{errInvalidBody, ErrorCodeInvalidBody},
+	{search.ErrMalformedQuery, ErrorCodeMalformedQuery},
+	{search.ErrInvalidSynonyms, ErrorCodeInvalidSynonyms},
+	{search.ErrLimitExceeded, ErrorCodeLimitExceeded},
+	{search.ErrInvalidArgument, ErrorCodeInvalidArgument},
+	{document.ErrInvalidUnit, ErrorCodeInvalidUnit},
+	{storage.ErrInvalidChunk, ErrorCodeInvalidChunk},
+	{store.ErrValueDeleted, ErrorCodeDeleted},
+	{store.ErrValueNotFound, ErrorCodeNotFound},
+	{store.ErrConflict, ErrorCodeConflict},
+	{store.ErrParentInvalid, ErrorCodeConflict},
+	{coordinator.ErrConflict, ErrorCodeConflict},
+	{coordinator.ErrAlreadyEnded, ErrorCodeAlreadyEnded},
+	{search.ErrNotReady, ErrorCodeNotReady},
+	{search.ErrSessionExpired, ErrorCodeSessionExpired},
+	{search.ErrBudgetExceeded, ErrorCodeBudgetExceeded},
+	{search.ErrRateLimited, ErrorCodeRateLimited},
+	{es.ErrQuotaExceeded, ErrorCodeQuotaExceeded},
+	{search.ErrQueueFull, ErrorCodeOverloaded},
+	{errReadOnly, ErrorCodeReadOnly},
+}
+
+// statusErrorCodes maps HTTP codes to error codes used when the error is not known.
+//
+//nolint:gochecknoglobals
+var statusErrorCodes = map[int]ErrorCode{
+	http.StatusBadRequest:          ErrorCodeBadRequest,
+	http.StatusUnauthorized:        ErrorCodeUnauthorized,
+	http.StatusForbidden:           ErrorCodeForbidden,
+	http.StatusNotFound:            ErrorCodeNotFound,
+	http.StatusRequestTimeout:      ErrorCodeRequestTimeout,
+	http.StatusConflict:            ErrorCodeConflict,
+	http.StatusGone:                ErrorCodeSessionExpired,
+	http.StatusTooManyRequests:     ErrorCodeBudgetExceeded,
+	http.StatusInternalServerError: ErrorCodeInternal,
+	http.StatusServiceUnavailable:  ErrorCodeOverloaded,
+}
+
+// apiError is a structured error returned by the API.
+type apiError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// Details are provided only for client errors because details
+	// of server errors might expose internal information.
+	Details map[string]interface{} `json:"details,omitempty"`
+	// CorrelationID is the request ID, also available in the Request-Id response header,
+	// which can be used to find the request in logs.
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// errorCode returns the error code for the error err and HTTP code status.
+func errorCode(status int, err errors.E) ErrorCode {
+	if err != nil {
+		for _, c := range errorCodes {
+			if errors.Is(err, c.Err) {
+				return c.Code
+			}
+		}
+	}
+	if code, ok := statusErrorCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return ErrorCodeInternal
+	}
+	return ErrorCodeBadRequest
+}
+
+// newAPIError returns the HTTP code and the structured error for the error err (which can be nil)
+// and HTTP code status. Canceled requests and exceeded deadlines use the 408 (request timeout) HTTP code.
+func newAPIError(ctx context.Context, status int, err errors.E) (int, apiError) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusRequestTimeout
+	}
+
+	e := apiError{
+		Code:          errorCode(status, err),
+		Message:       http.StatusText(status),
+		Details:       nil,
+		CorrelationID: "",
+	}
+	if requestID, ok := waf.RequestID(ctx); ok {
+		e.CorrelationID = requestID.String()
+	}
+	if err != nil && status < http.StatusInternalServerError && status != http.StatusRequestTimeout {
+		e.Message = err.Error()
+		details := errors.AllDetails(err)
+		if len(details) > 0 {
+			e.Details = details
+		}
+	}
+
+	return status, e
+}
+
+// isAPIRequest returns true if the request is for an API route.
+func isAPIRequest(req *http.Request) bool {
+	return req.URL.Path == "/api" || strings.HasPrefix(req.URL.Path, "/api/")
+}
+
+// replyWithError replies to the request with the HTTP code status and a structured error
+// as JSON, unless the request is not for an API route in which case it replies
+// with the corresponding error message only. Error err (which can be nil) is logged
+// to the canonical log line.
+//
+// It does not otherwise end the request; the caller should ensure no further
+// writes are done to w.
+func (s *Service) replyWithError(w http.ResponseWriter, req *http.Request, status int, err errors.E) {
+	if err != nil {
+		s.WithError(req.Context(), err)
+	}
+
+	status, e := newAPIError(req.Context(), status, err)
+
+	if !isAPIRequest(req) {
+		waf.Error(w, req, status)
+		return
+	}
+
+	encoded, errE := x.MarshalWithoutEscapeHTML(apiErrorResponse{Error: e})
+	if errE != nil && e.Details != nil {
+		// Details might not be possible to marshal, so we try without them.
+		e.Details = nil
+		encoded, errE = x.MarshalWithoutEscapeHTML(apiErrorResponse{Error: e})
+	}
+	if errE != nil {
+		s.replyWithError(w, req, http.StatusInternalServerError, errE)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(status)
+	_, _ = w.Write(encoded)
+}
+
+// NotFound replies to the request with the 404 (not found) HTTP code and the structured error.
+func (s *Service) NotFound(w http.ResponseWriter, req *http.Request) {
+	s.replyWithError(w, req, http.StatusNotFound, nil)
+}
+
+// NotFoundWithError replies to the request with the 404 (not found) HTTP code and
+// the structured error for err. Error err is logged to the canonical log line.
+func (s *Service) NotFoundWithError(w http.ResponseWriter, req *http.Request, err errors.E) {
+	s.replyWithError(w, req, http.StatusNotFound, err)
+}
+
+// BadRequest replies to the request with the 400 (bad request) HTTP code and the structured error.
+func (s *Service) BadRequest(w http.ResponseWriter, req *http.Request) {
+	s.replyWithError(w, req, http.StatusBadRequest, nil)
+}
+
+// BadRequestWithError replies to the request with the 400 (bad request) HTTP code and
+// the structured error for err. Error err is logged to the canonical log line.
+func (s *Service) BadRequestWithError(w http.ResponseWriter, req *http.Request, err errors.E) {
+	s.replyWithError(w, req, http.StatusBadRequest, err)
+}
+
+// InternalServerError replies to the request with the 500 (internal server error) HTTP code
+// and the structured error.
+func (s *Service) InternalServerError(w http.ResponseWriter, req *http.Request) {
+	s.replyWithError(w, req, http.StatusInternalServerError, nil)
+}
+
+// InternalServerErrorWithError replies to the request with the 500 (internal server error) HTTP code
+// and the structured error. Error err is logged to the canonical log line.
+func (s *Service) InternalServerErrorWithError(w http.ResponseWriter, req *http.Request, err errors.E) {
+	s.replyWithError(w, req, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,79 @@
+package peerdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+func TestErrorCode(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, ErrorCodeNotFound, errorCode(http.StatusNotFound, nil))
+	assert.Equal(t, ErrorCodeForbidden, errorCode(http.StatusForbidden, errors.New("test")))
+	assert.Equal(t, ErrorCodeBadRequest, errorCode(http.StatusRequestEntityTooLarge, nil))
+	assert.Equal(t, ErrorCodeInternal, errorCode(http.StatusBadGateway, nil))
+	assert.Equal(t, ErrorCodeDeleted, errorCode(http.StatusNotFound, errors.WithStack(store.ErrValueDeleted)))
+	assert.Equal(t, ErrorCodeNotFound, errorCode(http.StatusNotFound, errors.WithStack(store.ErrValueNotFound)))
+	assert.Equal(t, ErrorCodeMalformedQuery, errorCode(http.StatusBadRequest, errors.WithStack(search.ErrMalformedQuery)))
+	assert.Equal(t, ErrorCodeInvalidArgument, errorCode(http.StatusBadRequest, errors.WithStack(search.ErrInvalidArgument)))
+	assert.Equal(t, ErrorCodeLimitExceeded, errorCode(http.StatusBadRequest, errors.WithStack(search.ErrLimitExceeded)))
+	assert.Equal(t, ErrorCodeRequestTimeout, errorCode(http.StatusInternalServerError, errors.WithStack(context.Canceled)))
+	assert.Equal(t, ErrorCodeOverloaded, errorCode(http.StatusServiceUnavailable, errors.WithStack(search.ErrQueueFull)))
+}
+
+func TestNewAPIError(t *testing.T) {
+	t.Parallel()
+
+	errE := errors.New("test")
+	errors.Details(errE)["foo"] = "bar"
+
+	status, e := newAPIError(context.Background(), http.StatusBadRequest, errE)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, apiError{
+		Code:          ErrorCodeBadRequest,
+		Message:       "test",
+		Details:       map[string]interface{}{"foo": "bar"},
+		CorrelationID: "",
+	}, e)
+
+	// Details of server errors are not exposed.
+	status, e = newAPIError(context.Background(), http.StatusInternalServerError, errE)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, apiError{
+		Code:          ErrorCodeInternal,
+		Message:       "Internal Server Error",
+		Details:       nil,
+		CorrelationID: "",
+	}, e)
+
+	status, e = newAPIError(context.Background(), http.StatusNotFound, errors.WithStack(context.DeadlineExceeded))
+	assert.Equal(t, http.StatusRequestTimeout, status)
+	assert.Equal(t, ErrorCodeRequestTimeout, e.Code)
+}
+
+func TestReplyWithError(t *testing.T) {
+	t.Parallel()
+
+	s := &Service{} //nolint:exhaustruct
+
+	req := httptest.NewRequest(http.MethodGet, "/api/d/foo", nil)
+	w := httptest.NewRecorder()
+	s.NotFoundWithError(w, req, errors.WithStack(store.ErrValueNotFound))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":{"code":"not_found","message":"value not found"}}`, w.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/d/foo", nil)
+	w = httptest.NewRecorder()
+	s.NotFoundWithError(w, req, errors.WithStack(store.ErrValueNotFound))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Not Found\n", w.Body.String())
+}
@@ -0,0 +1,487 @@
+package peerdb
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/olivere/elastic/v7"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+const (
+	// BackupFormatVersion is the version of the backup archive format.
+	// It should be increased when the format changes in an incompatible way.
+	BackupFormatVersion = 1
+
+	backupManifestName = "manifest.json"
+	backupFileMode     = 0o644
+)
+
+type backupSite struct {
+	Schema    string `json:"schema"`
+	Index     string `json:"index"`
+	SizeField bool   `json:"sizeField,omitempty"`
+
+	// RestrictedProperties are not stored in the manifest, they come from site configuration.
+	RestrictedProperties []identifier.Identifier `json:"-"`
+}
+
+type backupManifest struct {
+	Format         int        `json:"format"`
+	DocumentFormat int        `json:"documentFormat"`
+	CreatedAt      types.Time `json:"createdAt"`
+	// IndexConfiguration is informational only because indices are rebuilt when documents are restored.
+	IndexConfiguration string       `json:"indexConfiguration"`
+	Sites              []backupSite `json:"sites"`
+}
+
+// backupSites returns sites to backup or restore. If no sites are configured,
+// it returns a site based on global configuration.
+func backupSites(globals *Globals) []backupSite {
+	if len(globals.Sites) == 0 {
+		return []backupSite{{
+			Schema:               globals.Postgres.Schema,
+			Index:                globals.Elastic.Index,
+			SizeField:            globals.Elastic.SizeField,
+			RestrictedProperties: nil,
+		}}
+	}
+
+	sites := make([]backupSite, 0, len(globals.Sites))
+	for _, site := range globals.Sites {
+		sites = append(sites, backupSite{
+			Schema:               site.Schema,
+			Index:                site.Index,
+			SizeField:            site.SizeField,
+			RestrictedProperties: site.RestrictedProperties,
+		})
+	}
+	return sites
+}
+
+// backupStore is the store of a site together with what is needed to index its documents.
+type backupStore struct {
+	store       *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes]
+	esProcessor *elastic.BulkProcessor
+	references  *es.References
+	generations *es.Generations
+	bridgeSync  *es.BridgeSync
+}
+
+// initBackup connects to PostgreSQL and ElasticSearch and initializes stores for all sites.
+func initBackup(ctx context.Context, globals *Globals, sites []backupSite, requestID string) (*elastic.Client, map[string]*backupStore, errors.E) {
+	dbpool, errE := internal.InitPostgres(ctx, string(globals.Postgres.URL), globals.Logger, getRequestWithFallback(globals.Logger))
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), globals.Logger, globals.Elastic.URL)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	stores := map[string]*backupStore{}
+	for _, site := range sites {
+		s, errE := initBackupSite(ctx, globals.Logger, dbpool, esClient, site, requestID)
+		if errE != nil {
+			return nil, nil, errE
+		}
+		stores[site.Schema] = s
+	}
+
+	return esClient, stores, nil
+}
+
+func initBackupSite(
+	ctx context.Context, logger zerolog.Logger, dbpool *pgxpool.Pool, esClient *elastic.Client, site backupSite, requestID string,
+) (*backupStore, errors.E) {
+	// We set fallback context values which are used to set application name on PostgreSQL connections.
+	ctx = context.WithValue(ctx, requestIDContextKey, requestID)
+	ctx = context.WithValue(ctx, schemaContextKey, site.Schema)
+
+	s, _, _, esProcessor, references, generations, bridgeSync, errE := es.InitForSite(
+		ctx, logger, dbpool, esClient, site.Schema, site.Index, site.SizeField, site.RestrictedProperties,
+	)
+	if errE != nil {
+		return nil, errE
+	}
+	return &backupStore{
+		store:       s,
+		esProcessor: esProcessor,
+		references:  references,
+		generations: generations,
+		bridgeSync:  bridgeSync,
+	}, nil
+}
+
+// BackupCommand writes the latest version of all documents of all sites into a single archive.
+//
+// The archive is a gzip compressed tar file. The first file in the archive is a manifest
+// with the archive format version, the document format version, and a hash of the ElasticSearch
+// index configuration, followed by one JSON file per document, stored under a directory named after site's schema.
+// ElasticSearch indices are not stored in the archive as they are populated from the store
+// and are rebuilt when documents are restored.
+type BackupCommand struct {
+	Output string `arg:"" help:"Path of the archive to write." placeholder:"PATH" type:"path"`
+}
+
+func (c *BackupCommand) Run(globals *Globals) (errE errors.E) { //nolint:nonamedreturns
+	// We stop the backup gracefully on ctrl-c and TERM signal.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sites := backupSites(globals)
+
+	_, stores, errE := initBackup(ctx, globals, sites, "backup")
+	if errE != nil {
+		return errE
+	}
+	for _, s := range stores {
+		defer s.esProcessor.Close()
+	}
+
+	file, err := os.Create(c.Output)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		errE = errors.Join(errE, file.Close())
+	}()
+
+	gzipWriter := gzip.NewWriter(file)
+	defer func() {
+		errE = errors.Join(errE, gzipWriter.Close())
+	}()
+
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer func() {
+		errE = errors.Join(errE, tarWriter.Close())
+	}()
+
+	now := time.Now().UTC()
+
+	manifest, errE := x.MarshalWithoutEscapeHTML(backupManifest{
+		Format:             BackupFormatVersion,
+		DocumentFormat:     document.FormatVersion,
+		CreatedAt:          types.Time(now),
+		IndexConfiguration: es.IndexConfigurationHash(),
+		Sites:              sites,
+	})
+	if errE != nil {
+		return errE
+	}
+	errE = writeBackupFile(tarWriter, backupManifestName, manifest, now)
+	if errE != nil {
+		return errE
+	}
+
+	for _, site := range sites {
+		count, errE := c.backupSite(ctx, tarWriter, stores[site.Schema].store, site, now)
+		if errE != nil {
+			errors.Details(errE)["schema"] = site.Schema
+			return errE
+		}
+		globals.Logger.Info().Str("schema", site.Schema).Int64("count", count).Msg("backed up documents")
+	}
+
+	globals.Logger.Info().Msg("Done.")
+
+	return nil
+}
+
+func (c *BackupCommand) backupSite(
+	ctx context.Context, tarWriter *tar.Writer,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	site backupSite, now time.Time,
+) (int64, errors.E) {
+	count := int64(0)
+	var after *identifier.Identifier
+	for {
+		ids, errE := s.List(ctx, after)
+		if errE != nil {
+			return count, errE
+		}
+		if len(ids) == 0 {
+			return count, nil
+		}
+
+		for _, id := range ids {
+			data, _, _, errE := s.GetLatest(ctx, id)
+			if errors.Is(errE, store.ErrValueDeleted) {
+				continue
+			} else if errE != nil {
+				errors.Details(errE)["doc"] = id.String()
+				return count, errE
+			}
+
+			errE = writeBackupFile(tarWriter, path.Join(site.Schema, id.String()+".json"), data, now)
+			if errE != nil {
+				errors.Details(errE)["doc"] = id.String()
+				return count, errE
+			}
+			count++
+		}
+
+		after = &ids[len(ids)-1]
+	}
+}
+
+func writeBackupFile(tarWriter *tar.Writer, name string, data []byte, modTime time.Time) errors.E {
+	err := tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(data)),
+		Mode:     backupFileMode,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tarWriter.Write(data)
+	return errors.WithStack(err)
+}
+
+// RestoreCommand restores documents from an archive made by BackupCommand.
+//
+// Archive format version and document format version have to match the current ones.
+// ElasticSearch index configuration can differ because restored documents are reindexed.
+// Documents are restored into sites with the same schema as they were backed up from and
+// existing documents with the same ID are replaced. Restored documents are reindexed into ElasticSearch.
+type RestoreCommand struct {
+	Input string   `arg:""                                                                                                         help:"Path of the archive to restore from." placeholder:"PATH" type:"path"`
+	Types []string `help:"Restore only documents with a TYPE claim to this document. Mnemonic or ID. Can be provided multiple times." name:"type"                                placeholder:"TYPE"`
+}
+
+func (c *RestoreCommand) typeIDs() []identifier.Identifier {
+	ids := make([]identifier.Identifier, 0, len(c.Types))
+	for _, t := range c.Types {
+		id, errE := identifier.FromString(t)
+		if errE != nil {
+			id = document.GetCorePropertyID(t)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// hasType returns true if the document has a TYPE claim to any of the provided documents.
+func hasType(doc *document.D, typeIDs []identifier.Identifier) bool {
+	for _, claim := range doc.Get(document.GetCorePropertyID("TYPE")) {
+		relation, ok := claim.(*document.RelationClaim)
+		if !ok || relation.To.ID == nil {
+			continue
+		}
+		for _, t := range typeIDs {
+			if *relation.To.ID == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *RestoreCommand) Run(globals *Globals) (errE errors.E) { //nolint:nonamedreturns
+	// We stop the restore gracefully on ctrl-c and TERM signal.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	file, err := os.Open(c.Input)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		errE = errors.Join(errE, file.Close())
+	}()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		errE = errors.Join(errE, gzipReader.Close())
+	}()
+
+	tarReader := tar.NewReader(gzipReader)
+
+	manifest, errE := readBackupManifest(tarReader)
+	if errE != nil {
+		return errE
+	}
+	if manifest.IndexConfiguration != es.IndexConfigurationHash() {
+		globals.Logger.Warn().Str("indexConfiguration", manifest.IndexConfiguration).Str("expected", es.IndexConfigurationHash()).
+			Msg("archive was made with a different index configuration, documents are reindexed with the current one")
+	}
+
+	sites := backupSites(globals)
+	configured := map[string]bool{}
+	for _, site := range sites {
+		configured[site.Schema] = true
+	}
+	for _, site := range manifest.Sites {
+		if !configured[site.Schema] {
+			errE := errors.New("site from the archive is not configured")
+			errors.Details(errE)["schema"] = site.Schema
+			return errE
+		}
+	}
+
+	esClient, stores, errE := initBackup(ctx, globals, sites, "restore")
+	if errE != nil {
+		return errE
+	}
+	for _, s := range stores {
+		defer s.esProcessor.Close()
+	}
+
+	typeIDs := c.typeIDs()
+	counts := map[string]int64{}
+
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		schema, name := path.Split(header.Name)
+		schema = strings.TrimSuffix(schema, "/")
+		s, ok := stores[schema]
+		if !ok || !strings.HasSuffix(name, ".json") {
+			errE := errors.New("unexpected file in the archive")
+			errors.Details(errE)["file"] = header.Name
+			return errE
+		}
+
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		restored, errE := c.restoreDocument(ctx, s, data, typeIDs)
+		if errE != nil {
+			errors.Details(errE)["file"] = header.Name
+			return errE
+		}
+		if restored {
+			counts[schema]++
+		}
+	}
+
+	for _, site := range sites {
+		// Make sure all just restored documents are available for search.
+		errE := stores[site.Schema].bridgeSync.Wait(ctx)
+		if errE != nil {
+			return errE
+		}
+		err := stores[site.Schema].esProcessor.Flush()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = esClient.Refresh(site.Index).Do(ctx)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		globals.Logger.Info().Str("schema", site.Schema).Int64("count", counts[site.Schema]).Msg("restored documents")
+	}
+
+	globals.Logger.Info().Msg("Done.")
+
+	return nil
+}
+
+// restoreDocument restores the document. Changed documents are reindexed by the bridge,
+// while unchanged documents are reindexed here, because the index might not contain them.
+func (c *RestoreCommand) restoreDocument(ctx context.Context, s *backupStore, data []byte, typeIDs []identifier.Identifier) (bool, errors.E) {
+	var doc document.D
+	errE := x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return false, errE
+	}
+
+	if len(typeIDs) > 0 && !hasType(&doc, typeIDs) {
+		return false, nil
+	}
+
+	changed, errE := upsertDocument(ctx, s.store, &doc)
+	if errE != nil {
+		errors.Details(errE)["doc"] = doc.ID.String()
+		return false, errE
+	}
+
+	if !changed {
+		for _, index := range s.generations.WriteIndices() {
+			_, errE := reindexDocument(ctx, s.store, s.esProcessor, s.references, s.generations, index, doc.ID)
+			if errE != nil {
+				return false, errE
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func readBackupManifest(tarReader *tar.Reader) (*backupManifest, errors.E) {
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, errors.WithMessage(err, "unable to read manifest")
+	}
+	if header.Name != backupManifestName {
+		errE := errors.New("archive does not start with a manifest")
+		errors.Details(errE)["file"] = header.Name
+		return nil, errE
+	}
+
+	data, err := io.ReadAll(tarReader)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var manifest backupManifest
+	errE := x.UnmarshalWithoutUnknownFields(data, &manifest)
+	if errE != nil {
+		return nil, errE
+	}
+
+	if manifest.Format != BackupFormatVersion {
+		errE := errors.New("unsupported archive format version")
+		errors.Details(errE)["format"] = manifest.Format
+		errors.Details(errE)["expected"] = BackupFormatVersion
+		return nil, errE
+	}
+	if manifest.DocumentFormat != document.FormatVersion {
+		errE := errors.New("unsupported document format version")
+		errors.Details(errE)["documentFormat"] = manifest.DocumentFormat
+		errors.Details(errE)["expected"] = document.FormatVersion
+		return nil, errE
+	}
+
+	return &manifest, nil
+}
@@ -0,0 +1,147 @@
+package peerdb_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	z "gitlab.com/tozd/go/zerolog"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+)
+
+func writeTestArchive(t *testing.T, manifest string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "backup.tar.gz")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err = tarWriter.WriteHeader(&tar.Header{ //nolint:exhaustruct
+		Typeflag: tar.TypeReg,
+		Name:     "manifest.json",
+		Size:     int64(len(manifest)),
+		Mode:     0o644,
+	})
+	require.NoError(t, err)
+	_, err = tarWriter.Write([]byte(manifest))
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	require.NoError(t, file.Close())
+	return path
+}
+
+func TestRestoreManifest(t *testing.T) {
+	t.Parallel()
+
+	globals := &peerdb.Globals{ //nolint:exhaustruct
+		Postgres: peerdb.PostgresConfig{
+			URL:    []byte("postgres://invalid"),
+			Schema: "docs",
+		},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{
+			"archive format",
+			`{"format":2,"documentFormat":1,"createdAt":"2024-01-01T00:00:00.000Z","indexConfiguration":"","sites":[]}`,
+			"unsupported archive format version",
+		},
+		{
+			"document format",
+			`{"format":1,"documentFormat":0,"createdAt":"2024-01-01T00:00:00.000Z","indexConfiguration":"","sites":[]}`,
+			"unsupported document format version",
+		},
+		{
+			// A different index configuration is accepted, so restore continues to check sites.
+			"index configuration",
+			`{"format":1,"documentFormat":1,"createdAt":"2024-01-01T00:00:00.000Z","indexConfiguration":"other","sites":[{"schema":"other","index":"other"}]}`,
+			"site from the archive is not configured",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			restore := peerdb.RestoreCommand{Input: writeTestArchive(t, tt.manifest)} //nolint:exhaustruct
+			errE := restore.Run(globals)
+			assert.EqualError(t, errE, tt.err)
+		})
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("ELASTIC") == "" {
+		t.Skip("ELASTIC is not available")
+	}
+	if os.Getenv("POSTGRES") == "" {
+		t.Skip("POSTGRES is not available")
+	}
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+
+	globals := &peerdb.Globals{ //nolint:exhaustruct
+		LoggingConfig: z.LoggingConfig{ //nolint:exhaustruct
+			Logger: logger,
+		},
+		Postgres: peerdb.PostgresConfig{
+			URL:    []byte(os.Getenv("POSTGRES")),
+			Schema: identifier.New().String(),
+		},
+		Elastic: peerdb.ElasticConfig{
+			URL:       os.Getenv("ELASTIC"),
+			Index:     strings.ToLower(identifier.New().String()),
+			SizeField: false,
+		},
+	}
+
+	populate := peerdb.PopulateCommand{}
+	errE := populate.Run(globals)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	path := filepath.Join(t.TempDir(), "backup.tar.gz")
+	backup := peerdb.BackupCommand{Output: path}
+	errE = backup.Run(globals)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// We restore into a new index, so all documents have to be reindexed from the archive.
+	globals.Elastic.Index = strings.ToLower(identifier.New().String())
+	restore := peerdb.RestoreCommand{Input: path} //nolint:exhaustruct
+	errE = restore.Run(globals)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), logger, globals.Elastic.URL)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	count, err := esClient.Count(globals.Elastic.Index).Do(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(document.CoreProperties)), count)
+
+	// Restoring only documents of a type restores only properties.
+	globals.Elastic.Index = strings.ToLower(identifier.New().String())
+	restore = peerdb.RestoreCommand{Input: path, Types: []string{"PROPERTY"}}
+	errE = restore.Run(globals)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	count, err = esClient.Count(globals.Elastic.Index).Do(context.Background())
+	require.NoError(t, err)
+	assert.Positive(t, count)
+	assert.LessOrEqual(t, count, int64(len(document.CoreProperties)))
+}
@@ -0,0 +1,104 @@
+package peerdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/fun"
+	"gitlab.com/tozd/waf"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+// publicAPIKey is the API key of callers without an API key.
+const publicAPIKey = "public"
+
+// apiKey returns the API key of the caller of the request.
+//
+// The bearer token itself is not used because API keys are exposed through the admin API.
+// Callers without a bearer token share publicAPIKey.
+func apiKey(req *http.Request) string {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return publicAPIKey
+	}
+	hash := sha256.Sum256([]byte(token))
+	return "key-" + hex.EncodeToString(hash[:8])
+}
+
+// clientKey returns the key used for accounting of the caller of the request.
+//
+// Callers with an API key are accounted by their API key (see apiKey). Other callers are
+// accounted by their IP address, hashed because keys are exposed through the admin API.
+func clientKey(req *http.Request) string {
+	key := apiKey(req)
+	if key != publicAPIKey {
+		return key
+	}
+	hash := sha256.Sum256([]byte(getHost(req.RemoteAddr)))
+	return "ip-" + hex.EncodeToString(hash[:8])
+}
+
+// checkLLMBudget replies to the request with the 429 (too many requests) HTTP code
+// and returns false if the caller has exceeded its monthly LLM budget.
+//
+// In read-only mode LLM usage cannot be recorded, so it replies with the 405 (method not allowed)
+// HTTP code and returns false, so that prompts are not parsed there.
+func (s *Service) checkLLMBudget(w http.ResponseWriter, req *http.Request) bool {
+	if internal.IsReadOnly(req.Context()) {
+		errE := errors.WithMessage(errReadOnly, "prompts cannot be parsed")
+		s.replyWithError(w, req, http.StatusMethodNotAllowed, errE)
+		return false
+	}
+
+	errE := waf.MustGetSite[*Site](req.Context()).llmBudget.Allow(req.Context(), clientKey(req))
+	if errors.Is(errE, search.ErrBudgetExceeded) {
+		s.replyWithError(w, req, http.StatusTooManyRequests, errE)
+		return false
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return false
+	}
+	return true
+}
+
+// recordLLMUsageClosure returns a function which records LLM usage for the caller of the request.
+// The function can be called after the request has finished, so errors cannot be returned to
+// the caller. Usage which could not be recorded is recorded again on the next budget check of
+// the caller, which fails if recording fails again (see search.LLMBudget.Allow).
+func (s *Service) recordLLMUsageClosure(req *http.Request) func([]fun.TextRecorderCall) {
+	ctx := context.WithoutCancel(req.Context())
+	budget := waf.MustGetSite[*Site](ctx).llmBudget
+	key := clientKey(req)
+	return func(calls []fun.TextRecorderCall) {
+		errE := budget.Record(ctx, key, calls)
+		if errE != nil {
+			zerolog.Ctx(ctx).Error().Err(errE).Str("key", key).Msg("recording LLM usage failed")
+		}
+	}
+}
+
+// AdminLLMUsageGet is a GET/HEAD HTTP request handler which returns LLM usage for
+// the current month per API key (or per hashed IP address for callers without one).
+// It requires the elevated role.
+func (s *Service) AdminLLMUsageGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	usage, errE := waf.MustGetSite[*Site](req.Context()).llmBudget.Usage(req.Context())
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, usage, nil)
+}
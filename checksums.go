@@ -0,0 +1,339 @@
+package peerdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/olivere/elastic/v7"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/storage"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+const (
+	// maxDuplicateFiles is the maximum number of groups of duplicate files returned.
+	maxDuplicateFiles = 100
+	// maxDuplicateFileDocs is the maximum number of documents returned for a group of duplicate files.
+	maxDuplicateFileDocs = 100
+)
+
+// ChecksumsCommand computes checksums of files of all documents.
+//
+// SHA-256 checksums of file contents are stored on file claims and indexed, so that
+// the same file referenced from multiple documents (possibly at different URLs) can be found.
+// With deduplication enabled, file claims with the same checksum are rewritten to use the
+// same (canonical) file record: URL, media type, preview, and blurhash of the first file
+// claim with that checksum. The command can be run periodically to process newly added files.
+type ChecksumsCommand struct {
+	BaseURL string `default:"${defaultBaseURL}" help:"Base URL of the site used to recognize files in site's storage when sites are not configured. Default: ${defaultBaseURL}." placeholder:"URL" yaml:"baseURL"`
+	Force   bool   `                            help:"Recompute checksums of files which already have them."                                                                                       yaml:"force"`
+	Dedup   bool   `                            help:"Rewrite file claims with the same checksum to the canonical file record."                                                                    yaml:"dedup"`
+}
+
+type checksumsStats struct {
+	Computed     int64
+	Deduplicated int64
+	Skipped      int64
+	Failed       int64
+}
+
+// canonicalFile is the file record to which file claims with the same checksum are rewritten.
+type canonicalFile struct {
+	MediaType string
+	URL       string
+	Preview   []string
+	Blurhash  string
+}
+
+func (c *ChecksumsCommand) Run(globals *Globals) errors.E {
+	// We stop gracefully on ctrl-c and TERM signal.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbpool, errE := internal.InitPostgres(ctx, string(globals.Postgres.URL), globals.Logger, getRequestWithFallback(globals.Logger))
+	if errE != nil {
+		return errE
+	}
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), globals.Logger, globals.Elastic.URL)
+	if errE != nil {
+		return errE
+	}
+
+	httpClient := cleanhttp.DefaultPooledClient()
+
+	for _, site := range fileSites(globals, c.BaseURL) {
+		// We set fallback context values which are used to set application name on PostgreSQL connections.
+		siteCtx := context.WithValue(ctx, requestIDContextKey, "checksums")
+		siteCtx = context.WithValue(siteCtx, schemaContextKey, site.Schema)
+
+		s, _, siteStorage, esProcessor, _, _, _, errE := es.InitForSite(siteCtx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField, site.RestrictedProperties)
+		if errE != nil {
+			return errE
+		}
+
+		stats, errE := c.runSite(siteCtx, globals.Logger, httpClient, s, siteStorage, site)
+		esProcessor.Close()
+		if errE != nil {
+			errors.Details(errE)["schema"] = site.Schema
+			return errE
+		}
+
+		globals.Logger.Info().Str("schema", site.Schema).
+			Int64("computed", stats.Computed).Int64("deduplicated", stats.Deduplicated).
+			Int64("skipped", stats.Skipped).Int64("failed", stats.Failed).
+			Msg("computed checksums")
+	}
+
+	globals.Logger.Info().Msg("Done.")
+
+	return nil
+}
+
+func (c *ChecksumsCommand) runSite(
+	ctx context.Context, logger zerolog.Logger, httpClient *http.Client,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	siteStorage *storage.Storage, site previewsSite,
+) (checksumsStats, errors.E) {
+	stats := checksumsStats{}
+	// Documents are listed in a stable order, so the canonical file record
+	// of a checksum is the same between runs.
+	canonicals := map[string]canonicalFile{}
+	var after *identifier.Identifier
+	for {
+		ids, errE := s.List(ctx, after)
+		if errE != nil {
+			return stats, errE
+		}
+		if len(ids) == 0 {
+			return stats, nil
+		}
+
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return stats, errors.WithStack(ctx.Err())
+			}
+
+			errE := c.processDocument(ctx, logger, httpClient, s, siteStorage, site, id, canonicals, &stats)
+			if errE != nil {
+				errors.Details(errE)["doc"] = id.String()
+				return stats, errE
+			}
+		}
+
+		after = &ids[len(ids)-1]
+	}
+}
+
+func (c *ChecksumsCommand) processDocument(
+	ctx context.Context, logger zerolog.Logger, httpClient *http.Client,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	siteStorage *storage.Storage, site previewsSite, id identifier.Identifier, canonicals map[string]canonicalFile, stats *checksumsStats,
+) errors.E {
+	data, _, version, errE := s.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueDeleted) {
+		return nil
+	} else if errE != nil {
+		return errE
+	}
+
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return errE
+	}
+
+	changes := document.Changes{}
+	for _, claim := range doc.AllClaims() {
+		fileClaim, ok := claim.(*document.FileClaim)
+		if !ok {
+			continue
+		}
+
+		patch := document.FileClaimPatch{}
+		changed := false
+
+		checksum := fileClaim.Checksum
+		if c.Force || checksum == "" {
+			checksum, errE = computeChecksum(ctx, httpClient, siteStorage, site, fileClaim.URL)
+			if errE != nil {
+				// Failing to compute a checksum for one file should not stop processing of others.
+				logger.Warn().Err(errE).Str("doc", id.String()).Str("claim", fileClaim.ID.String()).Str("url", fileClaim.URL).
+					Msg("unable to compute checksum")
+				stats.Failed++
+				continue
+			}
+			if checksum != fileClaim.Checksum {
+				patch.Checksum = &checksum
+				changed = true
+			}
+			stats.Computed++
+		}
+
+		if c.Dedup {
+			if canonical, ok := canonicals[checksum]; !ok {
+				canonicals[checksum] = canonicalFile{
+					MediaType: fileClaim.MediaType,
+					URL:       fileClaim.URL,
+					Preview:   fileClaim.Preview,
+					Blurhash:  fileClaim.Blurhash,
+				}
+			} else if canonical.URL != fileClaim.URL {
+				preview := canonical.Preview
+				if preview == nil {
+					preview = []string{}
+				}
+				patch.MediaType = &canonical.MediaType
+				patch.URL = &canonical.URL
+				patch.Preview = preview
+				patch.Blurhash = &canonical.Blurhash
+				changed = true
+				stats.Deduplicated++
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		change := document.SetClaimChange{ID: fileClaim.ID, Patch: patch}
+		errE = change.Apply(&doc)
+		if errE != nil {
+			return errE
+		}
+		changes = append(changes, change)
+	}
+
+	if len(changes) == 0 {
+		stats.Skipped++
+		return nil
+	}
+
+	return UpdateDocumentWithChanges(ctx, s, &doc, version, changes)
+}
+
+// computeChecksum returns hex encoded SHA-256 checksum of contents of the file at the URL.
+func computeChecksum(ctx context.Context, httpClient *http.Client, siteStorage *storage.Storage, site previewsSite, url string) (string, errors.E) {
+	body, errE := openFile(ctx, httpClient, siteStorage, site, url)
+	if errE != nil {
+		return "", errE
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	_, err := io.Copy(hash, body)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = url
+		return "", errE
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type duplicateFiles struct {
+	Checksum string   `json:"checksum"`
+	Docs     []string `json:"docs"`
+}
+
+type duplicateFilesAggregation struct {
+	Checksums struct {
+		Buckets []struct {
+			Key  string `json:"key"`
+			Docs struct {
+				IDs struct {
+					Buckets []struct {
+						Key string `json:"key"`
+					} `json:"buckets"`
+				} `json:"ids"`
+			} `json:"docs"`
+		} `json:"buckets"`
+	} `json:"checksums"`
+}
+
+// AdminFileDuplicatesGet is a GET/HEAD HTTP request handler which returns files referenced
+// from file claims of multiple documents, grouped by their checksums, together with IDs of
+// those documents. Groups with the most documents are returned first. Only files with
+// computed checksums are considered (see ChecksumsCommand). It requires the elevated role.
+func (s *Service) AdminFileDuplicatesGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+	site := waf.MustGetSite[*Site](ctx)
+
+	aggregation := elastic.NewNestedAggregation().Path("claims.file").SubAggregation(
+		"checksums",
+		elastic.NewTermsAggregation().Field("claims.file.checksum").MinDocCount(2).Size(maxDuplicateFiles).OrderByAggregation("docs", false).SubAggregation( //nolint:mnd
+			"docs",
+			elastic.NewReverseNestedAggregation().SubAggregation(
+				"ids",
+				elastic.NewTermsAggregation().Field("id").Size(maxDuplicateFileDocs),
+			),
+		),
+	)
+
+	searchService, _ := s.getSearchService(req)
+	searchService = searchService.Size(0).Query(elastic.NewMatchAllQuery()).Aggregation("files", aggregation)
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	res, err := searchService.Do(ctx)
+	m.Stop()
+	if err != nil {
+		s.InternalServerErrorWithError(w, req, errors.WithStack(err))
+		return
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
+
+	m = metrics.Duration(internal.MetricJSONUnmarshal).Start()
+	var files duplicateFilesAggregation
+	errE := x.Unmarshal(res.Aggregations["files"], &files)
+	m.Stop()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	results := []duplicateFiles{}
+	for _, bucket := range files.Checksums.Buckets {
+		// The same file can be referenced multiple times from the same document,
+		// and documents redirected to the same canonical document are the same document.
+		docs := []string{}
+		seen := map[string]bool{}
+		for _, doc := range bucket.Docs.IDs.Buckets {
+			id := site.redirectMap.resolveString(doc.Key)
+			if !seen[id] {
+				seen[id] = true
+				docs = append(docs, id)
+			}
+		}
+		if len(docs) < 2 { //nolint:mnd
+			continue
+		}
+		results = append(results, duplicateFiles{Checksum: bucket.Key, Docs: docs})
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, results, nil)
+}
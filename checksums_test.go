@@ -0,0 +1,33 @@
+package peerdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeChecksum(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(ts.Close)
+
+	site := previewsSite{BaseURL: "https://example.com"} //nolint:exhaustruct
+
+	checksum, errE := computeChecksum(context.Background(), ts.Client(), nil, site, ts.URL+"/file.txt")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", checksum)
+
+	_, errE = computeChecksum(context.Background(), ts.Client(), nil, site, ts.URL+"/missing")
+	assert.Error(t, errE)
+}
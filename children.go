@@ -0,0 +1,100 @@
+package peerdb
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+// resolveChild returns the ID of the document which embeds the child document with
+// the ID given as "id" parameter. Otherwise it replies to the request and returns false.
+func (s *Service) resolveChild(w http.ResponseWriter, req *http.Request, params waf.Params) (string, bool) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+	site := waf.MustGetSite[*Site](ctx)
+
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"id" is not a valid identifier`))
+		return "", false
+	}
+
+	query := elastic.NewNestedQuery("children", elastic.NewTermQuery("children.id", id))
+
+	searchService, _ := s.getSearchService(req)
+	searchService = searchService.From(0).Size(maxIdentifierMatches).Query(query)
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	res, err := searchService.Do(ctx)
+	m.Stop()
+	if err != nil {
+		s.InternalServerErrorWithError(w, req, errors.WithStack(err))
+		return "", false
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
+
+	// Documents redirected to the same canonical document are the same document.
+	ids := []string{}
+	for _, hit := range res.Hits.Hits {
+		id := site.redirectMap.resolveString(hit.Id)
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		s.NotFound(w, req)
+		return "", false
+	case 1:
+		return ids[0], true
+	default:
+		errE := errors.New("child is embedded in multiple documents")
+		errors.Details(errE)["id"] = id.String()
+		errors.Details(errE)["docs"] = ids
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return "", false
+	}
+}
+
+// DocumentChildGet is a GET/HEAD HTTP request handler which redirects to the document
+// API endpoint of the document embedding the child document with the ID given as a parameter,
+// so that the child is retrieved together with its parent and siblings. Query string is preserved.
+func (s *Service) DocumentChildGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	id, ok := s.resolveChild(w, req, params)
+	if !ok {
+		return
+	}
+
+	path, errE := s.ReverseAPI("DocumentGet", waf.Params{"id": id}, req.URL.Query())
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.TemporaryRedirectSameMethod(w, req, path)
+}
+
+// DocumentChild is a GET/HEAD HTTP request handler which redirects to the page of
+// the document embedding the child document. See DocumentChildGet.
+func (s *Service) DocumentChild(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	id, ok := s.resolveChild(w, req, params)
+	if !ok {
+		return
+	}
+
+	path, errE := s.Reverse("DocumentGet", waf.Params{"id": id}, req.URL.Query())
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.TemporaryRedirectSameMethod(w, req, path)
+}
@@ -89,7 +89,8 @@ var claimTypes = []claimType{ //nolint:gochecknoglobals
 					"properties": {
 						"en": {
 							"type": "text",
-							"analyzer": "english_html"
+							"analyzer": "english_html",
+							"search_analyzer": "english_html_search"
 						}
 					}
 				}`,
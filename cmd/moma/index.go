@@ -7,9 +7,6 @@ import (
 	"html"
 	"io"
 	"net/http"
-	"os"
-	"path"
-	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
@@ -20,18 +17,15 @@ import (
 	"github.com/foolin/pagser"
 	"github.com/google/uuid"
 	"github.com/hashicorp/go-retryablehttp"
-	"github.com/rs/zerolog"
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/x"
 
-	"gitlab.com/peerdb/peerdb"
 	"gitlab.com/peerdb/peerdb/document"
 	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/internal/importer"
 )
 
 const (
-	progressPrintRate = 30 * time.Second
-
 	centimetreToMetre = 0.01
 )
 
@@ -42,6 +36,10 @@ var (
 	mediaRegex     = regexp.MustCompile(`^(?:/media|/d/assets)/([^./]+)(?:/.+)?.(jpg|png)(?:\?sha=\w+)?$`)
 	resizeRegex    = regexp.MustCompile(`-resize (\d+)x(\d+)`)
 	srcSetSepRegex = regexp.MustCompile(`,\s+`)
+
+	// The dataset uses artists like "Unidentified photographer" or "Unknown Artist"
+	// as placeholders for artists which are not known.
+	placeholderArtistRegex = regexp.MustCompile(`(?i)^(?:unidentified|unknown|anonymous)\b`)
 )
 
 type picture struct {
@@ -289,88 +287,56 @@ func extractData[T any](in io.Reader) (T, errors.E) { //nolint:ireturn
 	return data, nil
 }
 
-func getPathAndURL(cacheDir, url string) (string, string) {
-	_ = os.MkdirAll(cacheDir, 0o755) //nolint:mnd
-	_, err := os.Stat(url)
-	if os.IsNotExist(err) {
-		return filepath.Join(cacheDir, path.Base(url)), url
-	}
-	return url, ""
-}
-
 func structName(name string) string {
 	i := strings.LastIndex(name, ".")
 	return strings.ToLower(name[i+1:])
 }
 
-func getJSON[T any](ctx context.Context, httpClient *retryablehttp.Client, logger zerolog.Logger, cacheDir, url string) ([]T, errors.E) {
-	cachedPath, url := getPathAndURL(cacheDir, url)
-
-	var cachedReader io.Reader
-	var cachedSize int64
+func getJSON[T any](ctx context.Context, imp *importer.Importer, config *Config, url string) ([]T, errors.E) {
+	reader, errE := importer.Download(
+		ctx, imp.HTTPClient, &config.Config, url, structName(fmt.Sprintf("%T", *new(T)))+" download progress",
+	)
+	if errE != nil {
+		return nil, errE
+	}
+	defer reader.Close()
 
-	cachedFile, err := os.Open(cachedPath)
-	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, errors.WithStack(err)
-		}
-		// File does not exists. Continue.
-	} else {
-		defer cachedFile.Close()
-		cachedReader = cachedFile
-		cachedSize, err = cachedFile.Seek(0, io.SeekEnd)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		_, err = cachedFile.Seek(0, io.SeekStart)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
+	var result []T
+	errE = x.DecodeJSONWithoutUnknownFields(reader, &result)
+	if errE != nil {
+		return nil, errE
 	}
+	return result, nil
+}
 
-	if cachedReader == nil {
-		// File does not already exist. We download the file and optionally save it.
-		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		downloadReader, errE := x.NewRetryableResponse(httpClient, req)
-		if errE != nil {
-			return nil, errE
-		}
-		defer downloadReader.Close()
-		cachedSize = downloadReader.Size()
-		cachedFile, err := os.Create(cachedPath)
-		if err != nil {
-			return nil, errors.WithStack(err)
+// isPlaceholderArtist returns true if the artist is a placeholder for unidentified or unknown artists.
+func isPlaceholderArtist(artist Artist) bool {
+	return placeholderArtistRegex.MatchString(strings.TrimSpace(artist.DisplayName))
+}
+
+// artworkArtists returns constituent IDs of artists of the artwork, in the order
+// they are credited, without duplicates.
+func artworkArtists(artwork Artwork) []int {
+	// The dataset sometimes lists the same artist multiple times.
+	// See: https://github.com/MuseumofModernArt/collection/issues/25
+	result := []int{}
+	for _, constituentID := range artwork.ConstituentID {
+		if !slices.Contains(result, constituentID) {
+			result = append(result, constituentID)
 		}
-		defer func() {
-			info, err := os.Stat(cachedPath)
-			if err != nil || downloadReader.Size() != info.Size() {
-				// Incomplete file. Delete.
-				_ = os.Remove(cachedPath)
-			}
-		}()
-		defer cachedFile.Close()
-		cachedReader = io.TeeReader(downloadReader, cachedFile)
 	}
+	return result
+}
 
-	progress := es.Progress(logger, nil, nil, nil, structName(fmt.Sprintf("%T", *new(T)))+" download progress")
-	countingReader := &x.CountingReader{Reader: cachedReader}
-	ticker := x.NewTicker(ctx, countingReader, cachedSize, progressPrintRate)
-	defer ticker.Stop()
-	go func() {
-		for p := range ticker.C {
-			progress(ctx, p)
+// artistsArtworks returns object IDs of artworks of every artist, in the order of artworks.
+func artistsArtworks(artworks []Artwork) map[int][]int {
+	result := map[int][]int{}
+	for _, artwork := range artworks {
+		for _, constituentID := range artworkArtists(artwork) {
+			result[constituentID] = append(result[constituentID], artwork.ObjectID)
 		}
-	}()
-
-	var result []T
-	errE := x.DecodeJSONWithoutUnknownFields(countingReader, &result)
-	if errE != nil {
-		return nil, errE
 	}
-	return result, nil
+	return result
 }
 
 func getArtistReference(artistsMap map[int]document.D, constituentID int) (document.Reference, errors.E) {
@@ -422,39 +388,36 @@ func getArtwork(ctx context.Context, httpClient *retryablehttp.Client, objectID
 }
 
 func index(config *Config) errors.E { //nolint:maintidx
-	ctx, stop, httpClient, store, esClient, esProcessor, errE := es.Standalone(
-		config.Logger, string(config.Postgres.URL), config.Elastic.URL, config.Postgres.Schema, config.Elastic.Index, config.Elastic.SizeField,
-	)
+	ctx, stop, imp, errE := importer.New(&config.Config, config.Validate, "moma")
 	if errE != nil {
 		return errE
 	}
 	defer stop()
 
-	artists, errE := getJSON[Artist](ctx, httpClient, config.Logger, config.CacheDir, config.ArtistsURL)
+	httpClient := imp.HTTPClient
+
+	artists, errE := getJSON[Artist](ctx, imp, config, config.ArtistsURL)
 	if errE != nil {
 		return errE
 	}
 
-	artworks, errE := getJSON[Artwork](ctx, httpClient, config.Logger, config.CacheDir, config.ArtworksURL)
+	artworks, errE := getJSON[Artwork](ctx, imp, config, config.ArtworksURL)
 	if errE != nil {
 		return errE
 	}
 
-	count := x.Counter(0)
-	progress := es.Progress(config.Logger, esProcessor, nil, nil, "indexing")
-	ticker := x.NewTicker(ctx, &count, int64(len(document.CoreProperties))+int64(len(artists))+int64(len(artworks)), progressPrintRate)
-	defer ticker.Stop()
-	go func() {
-		for p := range ticker.C {
-			progress(ctx, p)
-		}
-	}()
+	stopProgress := imp.Progress(ctx, int64(len(artists))+int64(len(artworks)))
+	defer stopProgress()
 
-	errE = peerdb.SaveCoreProperties(ctx, config.Logger, store, esClient, esProcessor, config.Elastic.Index)
+	errE = imp.SaveCoreProperties(ctx)
 	if errE != nil {
 		return errE
 	}
 
+	// Artists are imported before artworks, so we determine artworks of every artist upfront.
+	// With website data, artworks not found on the website are skipped later on, so some
+	// of these relations might point to documents which do not exist.
+	artistArtworks := artistsArtworks(artworks)
 	artistsMap := map[int]document.D{}
 
 	for _, artist := range artists {
@@ -630,12 +593,43 @@ func index(config *Config) errors.E { //nolint:maintidx
 			}
 		}
 
+		if isPlaceholderArtist(artist) {
+			// Placeholder artists are credited for many unrelated artworks,
+			// so we do not link them to artworks but only label them.
+			errE = doc.Add(&document.RelationClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceMoMA, "ARTIST", artist.ConstituentID, "LABEL", 0, "PLACEHOLDER_ARTIST", 0),
+					Confidence: document.HighConfidence,
+				},
+				Prop: document.GetCorePropertyReference("LABEL"),
+				To:   document.GetCorePropertyReference("PLACEHOLDER_ARTIST"),
+			})
+			if errE != nil {
+				return errE
+			}
+		} else {
+			for _, objectID := range artistArtworks[artist.ConstituentID] {
+				to := document.GetID(NameSpaceMoMA, "ARTWORK", objectID)
+				errE = doc.Add(&document.RelationClaim{
+					CoreClaim: document.CoreClaim{
+						ID:         document.GetID(NameSpaceMoMA, "ARTIST", artist.ConstituentID, "HAS_ARTWORK", 0, objectID),
+						Confidence: document.HighConfidence,
+					},
+					Prop: document.GetCorePropertyReference("HAS_ARTWORK"),
+					To:   document.Reference{ID: &to},
+				})
+				if errE != nil {
+					return errE
+				}
+			}
+		}
+
 		if config.WebsiteData { //nolint:dupl,nestif
 			data, errE := getArtist(ctx, httpClient, artist.ConstituentID) //nolint:govet
 			if errE != nil {
 				if errors.AllDetails(errE)["code"] == http.StatusNotFound {
 					config.Logger.Warn().Str("doc", doc.ID.String()).Int("constituentID", artist.ConstituentID).Msg("artist not found, skipping")
-					count.Increment()
+					imp.Skip()
 					continue
 				}
 				config.Logger.Warn().Err(errE).Str("doc", doc.ID.String()).Int("constituentID", artist.ConstituentID).Msg("error getting artist data")
@@ -692,16 +686,15 @@ func index(config *Config) errors.E { //nolint:maintidx
 
 		artistsMap[artist.ConstituentID] = doc
 
-		count.Increment()
-
-		config.Logger.Debug().Str("doc", doc.ID.String()).Msg("saving document")
-		errE = peerdb.InsertOrReplaceDocument(ctx, store, &doc)
+		errE = imp.Save(ctx, &doc)
 		if errE != nil {
 			return errE
 		}
 	}
 
 	artworksMap := map[int]document.D{}
+	// Copyright status of artworks depends on the current year.
+	year := time.Now().UTC().Year()
 
 	for _, artwork := range artworks {
 		if ctx.Err() != nil {
@@ -766,7 +759,7 @@ func index(config *Config) errors.E { //nolint:maintidx
 			if errE != nil {
 				if errors.AllDetails(errE)["code"] == http.StatusNotFound {
 					config.Logger.Warn().Str("doc", doc.ID.String()).Int("objectID", artwork.ObjectID).Msg("artwork not found, skipping")
-					count.Increment()
+					imp.Skip()
 					continue
 				}
 				config.Logger.Warn().Err(errE).Str("doc", doc.ID.String()).Int("objectID", artwork.ObjectID).Msg("error getting artwork data")
@@ -839,27 +832,30 @@ func index(config *Config) errors.E { //nolint:maintidx
 			}
 		}
 
-		processedConstituentIDs := map[int]bool{}
-		for _, constituentID := range artwork.ConstituentID {
-			// Skip duplicate artists.
-			// See: https://github.com/MuseumofModernArt/collection/issues/25
-			if processedConstituentIDs[constituentID] {
-				continue
-			}
-			processedConstituentIDs[constituentID] = true
+		constituentIDs := artworkArtists(artwork)
+		// For multi-artist works we store artists as an ordered list to preserve the credit order.
+		artistsList := document.GetID(NameSpaceMoMA, "ARTWORK", artwork.ObjectID, "BY_ARTIST", "LIST")
+		for i, constituentID := range constituentIDs {
 			to, errE := getArtistReference(artistsMap, constituentID) //nolint:govet
 			if errE != nil {
 				config.Logger.Warn().Err(errE).Str("doc", doc.ID.String()).Int("objectID", artwork.ObjectID).Send()
 				continue
 			}
-			errE = doc.Add(&document.RelationClaim{
+			claim := &document.RelationClaim{
 				CoreClaim: document.CoreClaim{
 					ID:         document.GetID(NameSpaceMoMA, "ARTWORK", artwork.ObjectID, "BY_ARTIST", 0, constituentID),
 					Confidence: document.HighConfidence,
 				},
 				Prop: document.GetCorePropertyReference("BY_ARTIST"),
 				To:   to,
-			})
+			}
+			if len(constituentIDs) > 1 {
+				errE = document.AddToList(claim, artistsList, i)
+				if errE != nil {
+					return errE
+				}
+			}
+			errE = doc.Add(claim)
 			if errE != nil {
 				return errE
 			}
@@ -917,6 +913,33 @@ func index(config *Config) errors.E { //nolint:maintidx
 				return errE
 			}
 		}
+		if status := artworkCopyrightStatus(artwork, year); status != "" {
+			errE = doc.Add(&document.StringClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceMoMA, "ARTWORK", artwork.ObjectID, "COPYRIGHT_STATUS", 0),
+					Confidence: document.MediumConfidence,
+				},
+				Prop:   document.GetCorePropertyReference("COPYRIGHT_STATUS"),
+				String: status,
+			})
+			if errE != nil {
+				return errE
+			}
+			if status == copyrightStatusPublicDomain && twoDimensionalClassifications[artwork.Classification] &&
+				len(doc.Get(document.GetCorePropertyID("IMAGE"))) > 0 {
+				errE = doc.Add(&document.RelationClaim{
+					CoreClaim: document.CoreClaim{
+						ID:         document.GetID(NameSpaceMoMA, "ARTWORK", artwork.ObjectID, "LABEL", 0, "PUBLIC_DOMAIN_IMAGE", 0),
+						Confidence: document.MediumConfidence,
+					},
+					Prop: document.GetCorePropertyReference("LABEL"),
+					To:   document.GetCorePropertyReference("PUBLIC_DOMAIN_IMAGE"),
+				})
+				if errE != nil {
+					return errE
+				}
+			}
+		}
 		if artwork.AccessionNumber != "" {
 			errE = doc.Add(&document.IdentifierClaim{
 				CoreClaim: document.CoreClaim{
@@ -1111,10 +1134,7 @@ func index(config *Config) errors.E { //nolint:maintidx
 
 		artworksMap[artwork.ObjectID] = doc
 
-		count.Increment()
-
-		config.Logger.Debug().Str("doc", doc.ID.String()).Msg("saving document")
-		errE = peerdb.InsertOrReplaceDocument(ctx, store, &doc)
+		errE = imp.Save(ctx, &doc)
 		if errE != nil {
 			return errE
 		}
@@ -1125,19 +1145,5 @@ func index(config *Config) errors.E { //nolint:maintidx
 	}
 
 	// We wait for everything to be indexed into ElasticSearch.
-	// TODO: Improve this to not have a busy wait.
-	for {
-		err := esProcessor.Flush()
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		stats := esProcessor.Stats()
-		c := count.Count()
-		if c <= stats.Indexed {
-			break
-		}
-		time.Sleep(time.Second)
-	}
-
-	return nil
+	return imp.Wait(ctx)
 }
@@ -72,3 +72,69 @@ func TestExtractData(t *testing.T) {
 		testExtractData[momaArtwork](t, "artwork")
 	})
 }
+
+func TestIsPlaceholderArtist(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"Unidentified photographer", "Unknown Artist", "unknown", "Anonymous"} {
+		assert.True(t, isPlaceholderArtist(Artist{DisplayName: name}), name) //nolint:exhaustruct
+	}
+	for _, name := range []string{"Pablo Picasso", "Unknownia Studio", ""} {
+		assert.False(t, isPlaceholderArtist(Artist{DisplayName: name}), name) //nolint:exhaustruct
+	}
+}
+
+func TestArtistsArtworks(t *testing.T) {
+	t.Parallel()
+
+	artworks := []Artwork{ //nolint:exhaustruct
+		{ObjectID: 1, ConstituentID: []int{10}},
+		{ObjectID: 2, ConstituentID: []int{20, 10, 20}},
+		{ObjectID: 3, ConstituentID: nil},
+	}
+
+	assert.Equal(t, []int{20, 10}, artworkArtists(artworks[1]))
+	assert.Equal(t, map[int][]int{
+		10: {1, 2},
+		20: {2},
+	}, artistsArtworks(artworks))
+}
+
+func TestArtworkYear(t *testing.T) {
+	t.Parallel()
+
+	for date, year := range map[string]int{
+		"1896":                    1896,
+		"c. 1905, printed 1940s":  1949,
+		"1976-77":                 1976,
+		"September 12, 1931-1933": 1933,
+		"n.d.":                    0,
+		"":                        0,
+	} {
+		assert.Equal(t, year, artworkYear(date), date)
+	}
+}
+
+func TestArtworkCopyrightStatus(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name    string
+		artwork Artwork
+		status  string
+	}{
+		{"artist died long ago", Artwork{Date: "1889", ConstituentID: []int{1}, Artist: []string{"Vincent van Gogh"}, EndDate: []int{1890}}, copyrightStatusPublicDomain},    //nolint:exhaustruct
+		{"recent artwork", Artwork{Date: "1950", ConstituentID: []int{1}, Artist: []string{"Artist"}, EndDate: []int{1900}}, copyrightStatusInCopyright},                     //nolint:exhaustruct
+		{"artist died recently", Artwork{Date: "1920", ConstituentID: []int{1, 2}, Artist: []string{"A", "B"}, EndDate: []int{1900, 1970}}, copyrightStatusInCopyright},      //nolint:exhaustruct
+		{"year of death unknown", Artwork{Date: "1920", ConstituentID: []int{1}, Artist: []string{"Artist"}, EndDate: []int{0}}, ""},                                         //nolint:exhaustruct
+		{"unknown artist", Artwork{Date: "c. 1890", ConstituentID: []int{1}, Artist: []string{"Unidentified photographer"}, EndDate: []int{0}}, copyrightStatusPublicDomain}, //nolint:exhaustruct
+		{"unknown artist, not old enough", Artwork{Date: "1920", ConstituentID: nil, Artist: nil, EndDate: nil}, ""},                                                         //nolint:exhaustruct
+		{"no date", Artwork{Date: "n.d.", ConstituentID: []int{1}, Artist: []string{"Artist"}, EndDate: []int{1890}}, ""},                                                    //nolint:exhaustruct
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.status, artworkCopyrightStatus(tt.artwork, 2026))
+		})
+	}
+}
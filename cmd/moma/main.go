@@ -5,19 +5,15 @@ import (
 	"gitlab.com/tozd/go/cli"
 	"gitlab.com/tozd/go/errors"
 
-	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/internal/importer"
 )
 
 func main() {
 	var config Config
-	cli.Run(&config, kong.Vars{
-		"defaultCacheDir":    DefaultCacheDir,
-		"defaultElastic":     peerdb.DefaultElastic,
-		"defaultIndex":       peerdb.DefaultIndex,
-		"defaultSchema":      peerdb.DefaultSchema,
+	cli.Run(&config, importer.Vars(kong.Vars{
 		"defaultArtistsURL":  DefaultArtistsURL,
 		"defaultArtworksURL": DefaultArtworksURL,
-	}, func(_ *kong.Context) errors.E {
+	}), func(_ *kong.Context) errors.E {
 		return index(&config)
 	})
 }
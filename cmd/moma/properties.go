@@ -29,6 +29,18 @@ var momaProperties = []struct {
 		"An artist who made an artwork.",
 		[]string{`"relation" claim type`},
 	},
+	{
+		"has artwork",
+		[]string{"artworks by artist", "works"},
+		"An artwork made by an artist.",
+		[]string{`"relation" claim type`},
+	},
+	{
+		"placeholder artist",
+		[]string{"unidentified artist", "unknown artist"},
+		"A label that an artist is a placeholder for unidentified or unknown artists of artworks.",
+		nil,
+	},
 	{
 		"MoMA constituent id",
 		nil,
@@ -69,13 +81,13 @@ var momaProperties = []struct {
 		"date of birth",
 		[]string{"begin date", "birth date", "year of birth", "born", "time of birth", "DOB", "birthday", "birthdate", "birth", "b."},
 		`When was an artist born.`,
-		[]string{`"time" claim type`},
+		[]string{`"time" claim type`, `single value`},
 	},
 	{
 		"date of death",
 		[]string{"end date", "death date", "year of death", "death", "time of death", "DOD", "died on"},
 		`When did an artist die.`,
-		[]string{`"time" claim type`},
+		[]string{`"time" claim type`, `single value`},
 	},
 	{
 		"Wikidata item id",
@@ -125,6 +137,18 @@ var momaProperties = []struct {
 		`From where or how was an artwork acquired.`,
 		[]string{`"string" claim type`},
 	},
+	{
+		"copyright status",
+		[]string{"copyright", "rights", "rights status"},
+		`Copyright status of an artwork: "public domain" or "in copyright", determined from the year it was created and years of death of its artists.`,
+		[]string{`"string" claim type`, `single value`},
+	},
+	{
+		"public domain image",
+		[]string{"public domain images", "free image", "PD image"},
+		`A label that a two-dimensional artwork is in the public domain and has an image, which is then in the public domain, too.`,
+		nil,
+	},
 	{
 		"MoMA accession number",
 		nil,
@@ -164,5 +188,9 @@ var momaProperties = []struct {
 }
 
 func init() { //nolint:gochecknoinits
-	document.GenerateCoreProperties(momaProperties)
+	document.GenerateNamespacedCoreProperties("MOMA", momaProperties)
+	document.DeclareExpectedProperties("MOMA:ARTIST", "MOMA:NATIONALITY", "MOMA:GENDER", "MOMA:DATE_OF_BIRTH")
+	document.DeclareExpectedProperties(
+		"MOMA:ARTWORK", "MOMA:BY_ARTIST", "MOMA:DATE_CREATED", "MOMA:MEDIUM", "MOMA:DIMENSIONS", "MOMA:CLASSIFICATION",
+	)
 }
@@ -1,23 +1,44 @@
 package main
 
 import (
+	"strconv"
+
 	"github.com/alecthomas/kong"
 	"gitlab.com/tozd/go/cli"
 	"gitlab.com/tozd/go/errors"
 
 	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/tasks"
 )
 
 func main() {
 	var config peerdb.Config
 	cli.Run(&config, kong.Vars{
-		"defaultProxyTo":      peerdb.DefaultProxyTo,
-		"defaultTLSCache":     peerdb.DefaultTLSCache,
-		"defaultElastic":      peerdb.DefaultElastic,
-		"defaultSchema":       peerdb.DefaultSchema,
-		"defaultIndex":        peerdb.DefaultIndex,
-		"defaultTitle":        peerdb.DefaultTitle,
-		"developmentModeHelp": " Proxy unknown requests.",
+		"defaultProxyTo":             peerdb.DefaultProxyTo,
+		"defaultTLSCache":            peerdb.DefaultTLSCache,
+		"defaultElastic":             peerdb.DefaultElastic,
+		"defaultSchema":              peerdb.DefaultSchema,
+		"defaultIndex":               peerdb.DefaultIndex,
+		"defaultTitle":               peerdb.DefaultTitle,
+		"defaultBaseURL":             peerdb.DefaultBaseURL,
+		"defaultSitemapInterval":     peerdb.DefaultSitemapInterval.String(),
+		"developmentModeHelp":        " Proxy unknown requests.",
+		"defaultPaginationKeepAlive": search.DefaultPaginationKeepAlive.String(),
+		"defaultLLMPromptPrice":      strconv.FormatFloat(search.DefaultLLMPromptPrice, 'f', -1, 64),
+		"defaultLLMResponsePrice":    strconv.FormatFloat(search.DefaultLLMResponsePrice, 'f', -1, 64),
+		"defaultLLMConcurrency":      strconv.Itoa(search.DefaultLLMConcurrency),
+		"defaultLLMPrimary":          search.DefaultLLMPrimary,
+		"defaultLLMLatencySLO":       search.DefaultLLMLatencySLO.String(),
+		"defaultExportConcurrency":   strconv.Itoa(search.DefaultExportConcurrency),
+		"defaultFiltersConcurrency":  strconv.Itoa(search.DefaultFiltersConcurrency),
+		"defaultQueueLength":         strconv.Itoa(search.DefaultQueueLength),
+		"defaultAnnotationsPerHour":  strconv.Itoa(search.DefaultAnnotationsPerHour),
+		"defaultTaskWorkers":         strconv.Itoa(tasks.DefaultWorkers),
+		"defaultSlowQueries":         strconv.Itoa(peerdb.DefaultSlowQueries),
+		"defaultTrendingWindow":      search.DefaultViewsWindow.String(),
+		"defaultViewsPerHour":        strconv.Itoa(search.DefaultViewsPerHour),
+		"defaultRelevanceDepth":      strconv.Itoa(search.DefaultRelevanceDepth),
 	}, func(ctx *kong.Context) errors.E {
 		return errors.WithStack(ctx.Run(&config.Globals))
 	})
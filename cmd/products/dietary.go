@@ -0,0 +1,247 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// ingredientRule matches ingredients of a food product.
+type ingredientRule struct {
+	// Name is a mnemonic of the item the rule is about (e.g., an allergen).
+	Name string
+	// Keywords are words or phrases in ingredients which match the rule.
+	Keywords []string
+	// Exceptions are phrases which contain keywords but do not match the rule.
+	Exceptions []string
+}
+
+// allergenRules match common allergens. Food products matching them get ALLERGEN claims.
+//
+//nolint:gochecknoglobals
+var allergenRules = []ingredientRule{
+	{
+		"GLUTEN",
+		[]string{
+			"wheat", "barley", "rye", "spelt", "malt", "semolina", "durum", "farina",
+			"triticale", "seitan", "bulgur", "couscous", "gluten",
+		},
+		[]string{"buckwheat", "gluten free"},
+	},
+	{
+		"PEANUTS",
+		[]string{"peanut", "peanuts", "groundnut", "groundnuts"},
+		nil,
+	},
+	{
+		"TREE_NUTS",
+		[]string{
+			"almond", "almonds", "cashew", "cashews", "walnut", "walnuts", "pecan", "pecans",
+			"hazelnut", "hazelnuts", "pistachio", "pistachios", "macadamia", "brazil nut", "brazil nuts", "tree nuts",
+		},
+		nil,
+	},
+	{
+		"DAIRY",
+		[]string{
+			"milk", "butter", "buttermilk", "cheese", "cream", "whey", "casein", "caseinate",
+			"lactose", "yogurt", "yoghurt", "ghee", "curd", "curds",
+		},
+		[]string{
+			"coconut milk", "almond milk", "soy milk", "oat milk", "rice milk", "coconut cream", "cream of tartar",
+			"cocoa butter", "peanut butter", "almond butter", "shea butter", "nut butter",
+		},
+	},
+	{
+		"EGGS",
+		[]string{"egg", "eggs", "albumen", "albumin", "mayonnaise"},
+		nil,
+	},
+	{
+		"SOY",
+		[]string{"soy", "soya", "soybean", "soybeans", "tofu", "edamame"},
+		nil,
+	},
+	{
+		"FISH",
+		[]string{"fish", "anchovy", "anchovies", "cod", "salmon", "tuna", "sardine", "sardines", "tilapia", "pollock", "haddock"},
+		nil,
+	},
+	{
+		"SHELLFISH",
+		[]string{"shrimp", "shrimps", "prawn", "prawns", "crab", "lobster", "crayfish", "shellfish", "clam", "clams", "mussel", "mussels", "oyster", "oysters", "scallop", "scallops"},
+		[]string{"oyster mushroom", "oyster mushrooms"},
+	},
+	{
+		"SESAME",
+		[]string{"sesame", "tahini"},
+		nil,
+	},
+}
+
+// animalRules match ingredients of animal origin which are not allergens.
+// They are used only by dietary rules.
+//
+//nolint:gochecknoglobals
+var animalRules = []ingredientRule{
+	{
+		"MEAT",
+		[]string{
+			"meat", "beef", "pork", "chicken", "turkey", "lamb", "veal", "mutton", "bacon", "ham", "sausage",
+			"gelatin", "gelatine", "lard", "tallow", "collagen", "duck", "venison", "broth",
+		},
+		[]string{"vegetable broth"},
+	},
+	{
+		"ANIMAL_PRODUCTS",
+		[]string{"honey", "beeswax", "carmine", "cochineal", "shellac", "lanolin", "royal jelly"},
+		nil,
+	},
+}
+
+// dietaryRule describes a dietary category. Food products with known ingredients
+// which do not match any of excluded rules get DIETARY_CATEGORY claims.
+type dietaryRule struct {
+	// Name is a mnemonic of the dietary category.
+	Name string
+	// Excludes are names of ingredient rules the dietary category excludes.
+	Excludes []string
+	// ExcludesNutrients are mnemonics of nutrient properties which have to be zero (if known).
+	ExcludesNutrients []string
+}
+
+//nolint:gochecknoglobals
+var dietaryRules = []dietaryRule{
+	{
+		"VEGETARIAN",
+		[]string{"MEAT", "FISH", "SHELLFISH"},
+		nil,
+	},
+	{
+		"VEGAN",
+		[]string{"MEAT", "FISH", "SHELLFISH", "DAIRY", "EGGS", "ANIMAL_PRODUCTS"},
+		// Only foods of animal origin contain cholesterol.
+		[]string{"CHOLESTEROL"},
+	},
+}
+
+var (
+	// Statements about possible cross-contact are not about ingredients.
+	crossContactRegexp = regexp.MustCompile(`(?:may contain|(?:processed|manufactured|produced|made|packaged) (?:in|on) (?:a )?(?:facility|plant|equipment))[^.;]*`)
+	nonLetterRegexp    = regexp.MustCompile(`[^\p{L}]+`)
+)
+
+// normalizeIngredients returns ingredients as lowercase words separated (and surrounded)
+// by single spaces, so that words and phrases can be matched by searching for them
+// surrounded by spaces.
+func normalizeIngredients(ingredients string) string {
+	ingredients = strings.ToLower(ingredients)
+	ingredients = crossContactRegexp.ReplaceAllString(ingredients, " ")
+	ingredients = nonLetterRegexp.ReplaceAllString(ingredients, " ")
+	return " " + strings.TrimSpace(ingredients) + " "
+}
+
+// matches returns true if any of rule's keywords is in normalized ingredients,
+// not counting those which are part of exceptions.
+func (r ingredientRule) matches(ingredients string) bool {
+	for _, exception := range r.Exceptions {
+		ingredients = strings.ReplaceAll(ingredients, " "+exception+" ", "  ")
+	}
+	for _, keyword := range r.Keywords {
+		if strings.Contains(ingredients, " "+keyword+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// ingredientNames returns names of all (also nested) parsed ingredients.
+func ingredientNames(ingredients []Ingredient) []string {
+	names := []string{}
+	for _, ingredient := range ingredients {
+		if s := strings.TrimSpace(ingredient.Name); s != "" {
+			names = append(names, s)
+		}
+		names = append(names, ingredientNames(ingredient.Ingredients)...)
+	}
+	return names
+}
+
+// dietaryFlags returns mnemonics of allergens and dietary categories of the food derived
+// from its ingredients and nutrients using allergenRules and dietaryRules. Both are
+// empty if ingredients are not known.
+func dietaryFlags(food BrandedFood, ingredients Ingredients) ([]string, []string) {
+	names := ingredientNames(ingredients.Ingredients)
+	if strings.TrimSpace(food.Ingredients) == "" && len(names) == 0 {
+		return nil, nil
+	}
+	// Each ingredient name is its own sentence so that cross-contact statements do not span them.
+	text := normalizeIngredients(food.Ingredients + ". " + strings.Join(names, ". "))
+
+	matched := map[string]bool{}
+	allergens := []string{}
+	for _, rule := range allergenRules {
+		if rule.matches(text) {
+			matched[rule.Name] = true
+			allergens = append(allergens, rule.Name)
+		}
+	}
+	for _, rule := range animalRules {
+		if rule.matches(text) {
+			matched[rule.Name] = true
+		}
+	}
+
+	present := map[string]bool{}
+	for _, nutrient := range food.FoodNutrients {
+		if mnemonic, ok := nutrientProperties[nutrient.Nutrient.Number]; ok && nutrient.Amount > 0 {
+			present[mnemonic] = true
+		}
+	}
+
+	categories := []string{}
+RULES:
+	for _, rule := range dietaryRules {
+		for _, name := range rule.Excludes {
+			if matched[name] {
+				continue RULES
+			}
+		}
+		for _, mnemonic := range rule.ExcludesNutrients {
+			if present[mnemonic] {
+				continue RULES
+			}
+		}
+		categories = append(categories, rule.Name)
+	}
+
+	return allergens, categories
+}
+
+// addDietaryFlags adds ALLERGEN and DIETARY_CATEGORY relation claims derived from ingredients
+// and nutrients of the food. They are derived heuristically, so they have medium confidence.
+func addDietaryFlags(doc *document.D, food BrandedFood, ingredients Ingredients) errors.E {
+	allergens, categories := dietaryFlags(food, ingredients)
+	for _, flags := range []struct {
+		Prop string
+		To   []string
+	}{{"ALLERGEN", allergens}, {"DIETARY_CATEGORY", categories}} {
+		for i, to := range flags.To {
+			errE := doc.Add(&document.RelationClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceProducts, "BRANDED_FOOD", food.FDCID, flags.Prop, i),
+					Confidence: document.MediumConfidence,
+				},
+				Prop: document.GetCorePropertyReference(flags.Prop),
+				To:   document.GetCorePropertyReference(to),
+			})
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+	return nil
+}
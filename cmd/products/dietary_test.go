@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDietaryFlags(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		ingredients string
+		parsed      []Ingredient
+		cholesterol float64
+		allergens   []string
+		categories  []string
+	}{
+		{"", nil, 0, nil, nil},
+		{
+			"WATER, WHEAT FLOUR, SUGAR, SALT.",
+			nil, 0,
+			[]string{"GLUTEN"},
+			[]string{"VEGETARIAN", "VEGAN"},
+		},
+		{
+			"MILK CHOCOLATE (SUGAR, COCOA BUTTER, MILK), ALMONDS. MAY CONTAIN PEANUTS AND WHEAT.",
+			nil, 10,
+			[]string{"TREE_NUTS", "DAIRY"},
+			[]string{"VEGETARIAN"},
+		},
+		{
+			"COCONUT MILK, COCOA BUTTER, BUCKWHEAT, OYSTER MUSHROOMS, VEGETABLE BROTH",
+			nil, 0,
+			[]string{},
+			[]string{"VEGETARIAN", "VEGAN"},
+		},
+		{
+			"RICE, SALT",
+			nil, 5,
+			[]string{},
+			[]string{"VEGETARIAN"},
+		},
+		{
+			"",
+			[]Ingredient{{Name: "sauce", Ingredients: []Ingredient{{Name: "anchovies"}, {Name: "sesame oil"}}}}, //nolint:exhaustruct
+			0,
+			[]string{"FISH", "SESAME"},
+			[]string{},
+		},
+		{
+			"CHICKEN BROTH, HONEY, EGG WHITES",
+			nil, 0,
+			[]string{"EGGS"},
+			[]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ingredients, func(t *testing.T) {
+			t.Parallel()
+
+			food := BrandedFood{ //nolint:exhaustruct
+				Ingredients: test.ingredients,
+				FoodNutrients: []FoodNutrient{
+					{Nutrient: Nutrient{Number: "601", UnitName: "MG"}, Amount: test.cholesterol}, //nolint:exhaustruct
+				},
+			}
+			allergens, categories := dietaryFlags(food, Ingredients{Ingredients: test.parsed}) //nolint:exhaustruct
+			assert.Equal(t, test.allergens, allergens)
+			assert.Equal(t, test.categories, categories)
+		})
+	}
+}
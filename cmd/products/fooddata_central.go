@@ -2,30 +2,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"html"
 	"io"
 	"io/fs"
-	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/hashicorp/go-retryablehttp"
 	"github.com/krolaw/zipstream"
-	"github.com/rs/zerolog"
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/x"
 
-	"gitlab.com/peerdb/peerdb"
 	"gitlab.com/peerdb/peerdb/document"
-	"gitlab.com/peerdb/peerdb/internal/es"
-	"gitlab.com/peerdb/peerdb/internal/types"
-	"gitlab.com/peerdb/peerdb/store"
+	"gitlab.com/peerdb/peerdb/internal/importer"
 )
 
 const (
@@ -168,83 +160,21 @@ type Ingredients struct {
 	Meta        []string     `json:"meta,omitempty"`
 }
 
-func getPathAndURL(cacheDir, url string) (string, string) {
-	_ = os.MkdirAll(cacheDir, 0o755) //nolint:mnd
-	_, err := os.Stat(url)
-	if os.IsNotExist(err) {
-		return filepath.Join(cacheDir, path.Base(url)), url
-	}
-	return url, ""
-}
-
 func structName(name string) string {
 	i := strings.LastIndex(name, ".")
 	return strings.ToLower(name[i+1:])
 }
 
-func getFoods(ctx context.Context, httpClient *retryablehttp.Client, logger zerolog.Logger, cacheDir, url string) ([]BrandedFood, errors.E) {
-	cachedPath, url := getPathAndURL(cacheDir, url)
-
-	var cachedReader io.Reader
-	var cachedSize int64
-
-	cachedFile, err := os.Open(cachedPath)
-	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return nil, errors.WithStack(err)
-		}
-		// File does not exists. Continue.
-	} else {
-		defer cachedFile.Close()
-		cachedReader = cachedFile
-		cachedSize, err = cachedFile.Seek(0, io.SeekEnd)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		_, err = cachedFile.Seek(0, io.SeekStart)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-	}
-
-	if cachedReader == nil {
-		// File does not already exist. We download the file and optionally save it.
-		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		downloadReader, errE := x.NewRetryableResponse(httpClient, req)
-		if errE != nil {
-			return nil, errE
-		}
-		defer downloadReader.Close()
-		cachedSize = downloadReader.Size()
-		cachedFile, err := os.Create(cachedPath)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		defer func() {
-			info, err := os.Stat(cachedPath)
-			if err != nil || downloadReader.Size() != info.Size() {
-				// Incomplete file. Delete.
-				_ = os.Remove(cachedPath)
-			}
-		}()
-		defer cachedFile.Close()
-		cachedReader = io.TeeReader(downloadReader, cachedFile)
+func getFoods(ctx context.Context, imp *importer.Importer, config *Config, url string) ([]BrandedFood, errors.E) {
+	reader, errE := importer.Download(
+		ctx, imp.HTTPClient, &config.Config, url, structName(fmt.Sprintf("%T", BrandedFood{}))+" download progress", //nolint:exhaustruct
+	)
+	if errE != nil {
+		return nil, errE
 	}
+	defer reader.Close()
 
-	progress := es.Progress(logger, nil, nil, nil, structName(fmt.Sprintf("%T", BrandedFood{}))+" download progress") //nolint:exhaustruct
-	countingReader := &x.CountingReader{Reader: cachedReader}
-	ticker := x.NewTicker(ctx, countingReader, cachedSize, progressPrintRate)
-	defer ticker.Stop()
-	go func() {
-		for p := range ticker.C {
-			progress(ctx, p)
-		}
-	}()
-
-	zipReader := zipstream.NewReader(countingReader)
+	zipReader := zipstream.NewReader(reader)
 	for file, err := zipReader.Next(); !errors.Is(err, io.EOF); file, err = zipReader.Next() {
 		if file.Name == "brandedDownload.json" {
 			var result struct {
@@ -310,7 +240,7 @@ func addIngredients(doc *document.D, fdcid, i int, ingredients []Ingredient) (in
 	return i, nil
 }
 
-func makeDoc(food BrandedFood, ingredients Ingredients) (document.D, errors.E) { //nolint:maintidx
+func makeDoc(food BrandedFood, ingredients Ingredients, units document.UnitRegistry) (document.D, errors.E) { //nolint:maintidx
 	doc := document.D{
 		CoreDocument: document.CoreDocument{
 			ID:    document.GetID(NameSpaceProducts, "BRANDED_FOOD", food.FDCID),
@@ -370,6 +300,18 @@ func makeDoc(food BrandedFood, ingredients Ingredients) (document.D, errors.E) {
 		if errE != nil {
 			return doc, errE
 		}
+		categoryID := getBrandedFoodCategoryID(s)
+		errE = doc.Add(&document.RelationClaim{
+			CoreClaim: document.CoreClaim{
+				ID:         document.GetID(NameSpaceProducts, "BRANDED_FOOD", food.FDCID, "IN_CATEGORY", 0),
+				Confidence: document.HighConfidence,
+			},
+			Prop: document.GetCorePropertyReference("IN_CATEGORY"),
+			To:   document.Reference{ID: &categoryID}, //nolint:exhaustruct
+		})
+		if errE != nil {
+			return doc, errE
+		}
 	}
 
 	if s := strings.TrimSpace(food.PublicationDate); s != "" {
@@ -598,18 +540,23 @@ func makeDoc(food BrandedFood, ingredients Ingredients) (document.D, errors.E) {
 	}
 
 	var unit document.AmountUnit
-	var factor float64
+	var amount float64
 	switch food.ServingSizeUnit {
 	case "g", "GM", "GRM", "MC", "IU", "MG": // Gram.
 		unit = document.AmountUnitKilogram
-		factor = 0.001
+		amount = 0.001 * food.ServingSize
 	case "ml", "MLT": // Millilitre.
 		unit = document.AmountUnitLitre
-		factor = 0.001
+		amount = 0.001 * food.ServingSize
 	default:
-		errE := errors.New("unsupported serving size unit")
-		errors.Details(errE)["unit"] = food.ServingSizeUnit
-		return doc, errE
+		u, ok := units.Lookup(food.ServingSizeUnit)
+		if !ok {
+			errE := errors.New("unsupported serving size unit")
+			errors.Details(errE)["unit"] = food.ServingSizeUnit
+			return doc, errE
+		}
+		unit = u.Canonical
+		amount = u.ConvertFloat64(food.ServingSize)
 	}
 
 	errE := doc.Add(&document.AmountClaim{
@@ -618,7 +565,7 @@ func makeDoc(food BrandedFood, ingredients Ingredients) (document.D, errors.E) {
 			Confidence: document.HighConfidence,
 		},
 		Prop:   document.GetCorePropertyReference("SERVING_SIZE"),
-		Amount: factor * food.ServingSize,
+		Amount: amount,
 		Unit:   unit,
 	})
 	if errE != nil {
@@ -639,36 +586,49 @@ func makeDoc(food BrandedFood, ingredients Ingredients) (document.D, errors.E) {
 		}
 	}
 
+	// Nutrient amounts are per 100 g or 100 ml, so we need the serving size in grams or millilitres.
+	var servingSize float64
+	if unit == document.AmountUnitKilogram || unit == document.AmountUnitLitre {
+		servingSize = 1000 * amount //nolint:mnd
+	}
+
+	errE = addNutrients(&doc, food, servingSize, units)
+	if errE != nil {
+		return doc, errE
+	}
+
 	_, errE = addIngredients(&doc, food.FDCID, 0, ingredients.Ingredients)
 	if errE != nil {
 		return doc, errE
 	}
 
+	errE = addDietaryFlags(&doc, food, ingredients)
+	if errE != nil {
+		return doc, errE
+	}
+
 	return doc, nil
 }
 
 func (f FoodDataCentral) Run(
-	ctx context.Context, config *Config, httpClient *retryablehttp.Client,
-	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	progress func(ctx context.Context, p x.Progress),
+	ctx context.Context, config *Config, imp *importer.Importer, prices priceFeeds, priceValidity time.Duration, taxonomy *categoryTaxonomy,
 ) errors.E {
 	if f.Disabled {
 		return nil
 	}
 
-	foods, errE := getFoods(ctx, httpClient, config.Logger, config.CacheDir, f.DataURL)
+	foods, errE := getFoods(ctx, imp, config, f.DataURL)
 	if errE != nil {
 		return errE
 	}
 
-	count := x.Counter(0)
-	ticker := x.NewTicker(ctx, &count, int64(len(foods)), progressPrintRate)
-	defer ticker.Stop()
-	go func() {
-		for p := range ticker.C {
-			progress(ctx, p)
-		}
-	}()
+	errE = saveBrandedFoodCategories(ctx, imp, foods, taxonomy)
+	if errE != nil {
+		return errE
+	}
+
+	stopProgress := imp.Progress(ctx, int64(len(foods)))
+	defer stopProgress()
 
 	for _, food := range foods {
 		if ctx.Err() != nil {
@@ -681,16 +641,19 @@ func (f FoodDataCentral) Run(
 			return errE
 		}
 
-		doc, errE := makeDoc(food, ingredients)
+		doc, errE := makeDoc(food, ingredients, imp.Units)
 		if errE != nil {
 			errors.Details(errE)["id"] = food.FDCID
 			return errE
 		}
 
-		count.Increment()
+		errE = addPrices(&doc, food.FDCID, prices[gtinKey(food.GTIN)], priceValidity)
+		if errE != nil {
+			errors.Details(errE)["id"] = food.FDCID
+			return errE
+		}
 
-		config.Logger.Debug().Str("doc", doc.ID.String()).Msg("saving document")
-		errE = peerdb.InsertOrReplaceDocument(ctx, store, &doc)
+		errE = imp.Save(ctx, &doc)
 		if errE != nil {
 			errors.Details(errE)["id"] = food.FDCID
 			return errE
@@ -699,3 +662,29 @@ func (f FoodDataCentral) Run(
 
 	return nil
 }
+
+// saveBrandedFoodCategories saves documents for all distinct categories of branded foods,
+// linking them to matching categories of the taxonomy (if available).
+func saveBrandedFoodCategories(ctx context.Context, imp *importer.Importer, foods []BrandedFood, taxonomy *categoryTaxonomy) errors.E {
+	seen := map[string]bool{}
+	for _, food := range foods {
+		name := strings.TrimSpace(food.BrandedFoodCategory)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		doc, errE := makeBrandedFoodCategoryDoc(name, taxonomy)
+		if errE != nil {
+			errors.Details(errE)["category"] = name
+			return errE
+		}
+
+		errE = imp.Save(ctx, &doc)
+		if errE != nil {
+			errors.Details(errE)["category"] = name
+			return errE
+		}
+	}
+	return nil
+}
@@ -1,43 +1,52 @@
 package main
 
 import (
-	"time"
-
 	"github.com/google/uuid"
 	"gitlab.com/tozd/go/errors"
 	"golang.org/x/sync/errgroup"
 
-	"gitlab.com/peerdb/peerdb"
-	"gitlab.com/peerdb/peerdb/internal/es"
-)
-
-const (
-	progressPrintRate = 30 * time.Second
+	"gitlab.com/peerdb/peerdb/internal/importer"
 )
 
 //nolint:gochecknoglobals
 var NameSpaceProducts = uuid.MustParse("55945768-34e9-4584-9310-cf78602a4aa7")
 
 func index(config *Config) errors.E {
-	ctx, stop, httpClient, store, esClient, esProcessor, errE := es.Standalone(
-		config.Logger, string(config.Postgres.URL), config.Elastic.URL, config.Postgres.Schema, config.Elastic.Index, config.Elastic.SizeField,
-	)
+	ctx, stop, imp, errE := importer.New(&config.Config, config.Validate, "products")
 	if errE != nil {
 		return errE
 	}
 	defer stop()
 
-	progress := es.Progress(config.Logger, esProcessor, nil, nil, "indexing")
+	prices, errE := config.Prices.Load(ctx, config, imp)
+	if errE != nil {
+		return errE
+	}
+
+	taxonomy, errE := config.Taxonomy.Load(ctx, config, imp)
+	if errE != nil {
+		return errE
+	}
 
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
-		return peerdb.SaveCoreProperties(ctx, config.Logger, store, esClient, esProcessor, config.Elastic.Index)
+		return imp.SaveCoreProperties(ctx)
 	})
 
 	g.Go(func() error {
-		return config.FoodDataCentral.Run(ctx, config, httpClient, store, progress)
+		return config.Taxonomy.Run(ctx, imp, taxonomy)
 	})
 
-	return errors.WithStack(g.Wait())
+	g.Go(func() error {
+		return config.FoodDataCentral.Run(ctx, config, imp, prices, config.Prices.Validity, taxonomy)
+	})
+
+	err := g.Wait()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// We wait for everything to be indexed into ElasticSearch.
+	return imp.Wait(ctx)
 }
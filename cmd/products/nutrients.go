@@ -0,0 +1,108 @@
+package main
+
+import (
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// nutrientProperties maps FoodData Central nutrient numbers to mnemonics of properties.
+//
+//nolint:gochecknoglobals
+var nutrientProperties = map[string]string{
+	"208": "ENERGY",
+	"203": "PROTEIN",
+	"204": "TOTAL_FAT",
+	"606": "SATURATED_FAT",
+	"605": "TRANS_FAT",
+	"601": "CHOLESTEROL",
+	"205": "CARBOHYDRATES",
+	"291": "DIETARY_FIBER",
+	"269": "TOTAL_SUGARS",
+	"539": "ADDED_SUGARS",
+	"307": "SODIUM",
+	"301": "CALCIUM",
+	"303": "IRON",
+	"306": "POTASSIUM",
+	"328": "VITAMIN_D",
+}
+
+// nutrientUnits maps FoodData Central nutrient unit names to unit symbols.
+//
+//nolint:gochecknoglobals
+var nutrientUnits = map[string]string{
+	"G":    "g",
+	"MG":   "mg",
+	"UG":   "µg",
+	"KCAL": "kcal",
+	"kJ":   "kJ",
+}
+
+// nutrientBasisClaim returns a meta claim for the nutrient amount claim
+// which says for which quantity of the food product the amount is.
+func nutrientBasisClaim(claim document.Claim, basis string) *document.RelationClaim {
+	return &document.RelationClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         document.GetID(NameSpaceProducts, claim.GetID(), "NUTRIENT_BASIS", 0),
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("NUTRIENT_BASIS"),
+		To:   document.GetCorePropertyReference(basis),
+	}
+}
+
+// addNutrients adds amount claims for nutrients of the food. FoodData Central provides
+// nutrient amounts per 100 g (or 100 ml) and those are added with PER_100_G meta claims.
+// If servingSize (in grams or millilitres) is known, amounts per serving are derived
+// and added with PER_SERVING meta claims, so that documents can be filtered by either.
+func addNutrients(doc *document.D, food BrandedFood, servingSize float64, units document.UnitRegistry) errors.E {
+	counts := map[string]int{}
+	for _, nutrient := range food.FoodNutrients {
+		mnemonic, ok := nutrientProperties[nutrient.Nutrient.Number]
+		if !ok {
+			continue
+		}
+		symbol, ok := nutrientUnits[nutrient.Nutrient.UnitName]
+		if !ok {
+			// For example, IU (international units) cannot be converted.
+			continue
+		}
+		unit, ok := units.Lookup(symbol)
+		if !ok {
+			errE := errors.New("unsupported nutrient unit")
+			errors.Details(errE)["unit"] = nutrient.Nutrient.UnitName
+			return errE
+		}
+
+		type basisAmount struct {
+			Basis  string
+			Amount float64
+		}
+		amounts := []basisAmount{{"PER_100_G", nutrient.Amount}}
+		if servingSize > 0 {
+			amounts = append(amounts, basisAmount{"PER_SERVING", nutrient.Amount * servingSize / 100}) //nolint:mnd
+		}
+
+		for _, a := range amounts {
+			claim := &document.AmountClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceProducts, "BRANDED_FOOD", food.FDCID, mnemonic, counts[mnemonic]),
+					Confidence: document.HighConfidence,
+				},
+				Prop:   document.GetCorePropertyReference(mnemonic),
+				Amount: unit.ConvertFloat64(a.Amount),
+				Unit:   unit.Canonical,
+			}
+			counts[mnemonic]++
+			errE := claim.Add(nutrientBasisClaim(claim, a.Basis))
+			if errE != nil {
+				return errE
+			}
+			errE = doc.Add(claim)
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/importer"
+)
+
+const (
+	availabilityInStock    = "IN_STOCK"
+	availabilityOutOfStock = "OUT_OF_STOCK"
+)
+
+//nolint:lll
+type Prices struct {
+	Feeds    []string      `               help:"URLs of price and availability feeds (CSV or JSON, by extension) to attach to products. They can be local file paths, too." name:"feed" placeholder:"URL"`
+	Validity time.Duration `default:"720h" help:"How long the latest price or availability of a product is valid if it is not superseded by a later one. Default: 720h."`
+}
+
+// PriceRecord is a record of a price and availability feed.
+//
+// CSV feeds have a header row with (a subset of) the same column names as JSON field names.
+// Price and currency are optional if availability is set. Timestamp is in RFC 3339 format
+// or a date (YYYY-MM-DD).
+type PriceRecord struct {
+	GTIN         string   `json:"gtin"`
+	Price        *float64 `json:"price,omitempty"`
+	Currency     string   `json:"currency,omitempty"`
+	Timestamp    string   `json:"timestamp"`
+	Availability string   `json:"availability,omitempty"`
+}
+
+type price struct {
+	Amount       *float64
+	Unit         document.AmountUnit
+	Time         time.Time
+	Availability string
+}
+
+// priceFeeds maps GTINs (without leading zeros) to their prices.
+type priceFeeds map[string][]price
+
+// gtinKey returns the key under which prices of the GTIN are stored. GTINs are
+// stored without leading zeros so that GTIN-12 (UPC) and GTIN-14 codes of the same
+// product match.
+func gtinKey(gtin string) string {
+	return strings.TrimLeft(strings.NewReplacer(" ", "", "-", "").Replace(gtin), "0")
+}
+
+func parseAvailability(s string) (string, errors.E) {
+	// Schema.org availability URLs (e.g., "https://schema.org/InStock") are supported as well.
+	s = s[strings.LastIndex(s, "/")+1:]
+	switch strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(s)) {
+	case "instock":
+		return availabilityInStock, nil
+	case "outofstock":
+		return availabilityOutOfStock, nil
+	default:
+		errE := errors.New("unknown availability")
+		errors.Details(errE)["availability"] = s
+		return "", errE
+	}
+}
+
+func (r PriceRecord) parse() (price, errors.E) {
+	p := price{Amount: r.Price} //nolint:exhaustruct
+
+	if gtinKey(r.GTIN) == "" {
+		return p, errors.New("missing GTIN")
+	}
+
+	t, err := time.Parse(time.RFC3339, r.Timestamp)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, r.Timestamp)
+		if err != nil {
+			errE := errors.WithMessage(err, "error parsing timestamp")
+			errors.Details(errE)["timestamp"] = r.Timestamp
+			return p, errE
+		}
+	}
+	p.Time = t.UTC().Truncate(time.Second)
+
+	if r.Price != nil {
+		unit, errE := document.ParseCurrency(r.Currency)
+		if errE != nil {
+			return p, errE
+		}
+		p.Unit = unit
+	}
+
+	if r.Availability != "" {
+		availability, errE := parseAvailability(r.Availability)
+		if errE != nil {
+			return p, errE
+		}
+		p.Availability = availability
+	}
+
+	if p.Amount == nil && p.Availability == "" {
+		return p, errors.New("price or availability has to be set")
+	}
+
+	return p, nil
+}
+
+func readPriceRecordsCSV(reader io.Reader) ([]PriceRecord, errors.E) {
+	r := csv.NewReader(reader)
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"gtin", "timestamp"} {
+		if _, ok := columns[name]; !ok {
+			errE := errors.New("missing column")
+			errors.Details(errE)["column"] = name
+			return nil, errE
+		}
+	}
+	get := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	records := make([]PriceRecord, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		record := PriceRecord{
+			GTIN:         get(row, "gtin"),
+			Price:        nil,
+			Currency:     get(row, "currency"),
+			Timestamp:    get(row, "timestamp"),
+			Availability: get(row, "availability"),
+		}
+		if s := get(row, "price"); s != "" {
+			amount, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				errE := errors.WithMessage(err, "error parsing price")
+				errors.Details(errE)["row"] = i + 1
+				return nil, errE
+			}
+			record.Price = &amount
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// readPriceRecords reads records of a price and availability feed,
+// in CSV or JSON format as determined by the extension.
+func readPriceRecords(reader io.Reader, name string) ([]PriceRecord, errors.E) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return readPriceRecordsCSV(reader)
+	case ".json":
+		var records []PriceRecord
+		errE := x.DecodeJSONWithoutUnknownFields(reader, &records)
+		if errE != nil {
+			return nil, errE
+		}
+		return records, nil
+	default:
+		errE := errors.New("unsupported feed format")
+		errors.Details(errE)["feed"] = name
+		return nil, errE
+	}
+}
+
+// add parses records and adds them to price feeds.
+func (f priceFeeds) add(records []PriceRecord) errors.E {
+	for i, record := range records {
+		p, errE := record.parse()
+		if errE != nil {
+			errors.Details(errE)["record"] = i
+			errors.Details(errE)["gtin"] = record.GTIN
+			return errE
+		}
+		key := gtinKey(record.GTIN)
+		f[key] = append(f[key], p)
+	}
+	return nil
+}
+
+// Load downloads and parses all configured price and availability feeds.
+func (p Prices) Load(ctx context.Context, config *Config, imp *importer.Importer) (priceFeeds, errors.E) {
+	feeds := priceFeeds{}
+	for _, url := range p.Feeds {
+		reader, errE := importer.Download(ctx, imp.HTTPClient, &config.Config, url, "price feed download progress")
+		if errE != nil {
+			errors.Details(errE)["feed"] = url
+			return nil, errE
+		}
+		records, errE := readPriceRecords(reader, url)
+		reader.Close()
+		if errE != nil {
+			errors.Details(errE)["feed"] = url
+			return nil, errE
+		}
+		errE = feeds.add(records)
+		if errE != nil {
+			errors.Details(errE)["feed"] = url
+			return nil, errE
+		}
+	}
+	return feeds, nil
+}
+
+// validityUntil returns, for every time of the sorted list of times, until when it is valid (inclusive, at
+// second precision): until the next time or, for the latest time, for the validity duration.
+func validityUntil(times []time.Time, validity time.Duration) []time.Time {
+	until := make([]time.Time, len(times))
+	for i, t := range times {
+		if i+1 < len(times) {
+			until[i] = times[i+1].Add(-time.Second)
+		} else {
+			until[i] = t.Add(validity - time.Second)
+		}
+	}
+	return until
+}
+
+func validityClaim(lower, upper time.Time, id ...interface{}) *document.TimeRangeClaim {
+	return &document.TimeRangeClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         document.GetID(NameSpaceProducts, append(slices.Clone(id), "VALIDITY", 0)...),
+			Confidence: document.HighConfidence,
+		},
+		Prop:      document.GetCorePropertyReference("VALIDITY"),
+		Lower:     document.Timestamp(lower),
+		Upper:     document.Timestamp(upper),
+		Precision: document.TimePrecisionSecond,
+	}
+}
+
+// addPrices adds prices and availabilities as claims to the document. Every claim has a validity
+// from the time of its record until the time of the next record of the same currency (for prices)
+// or availability, so that claims valid at a given time can be searched for.
+func addPrices(doc *document.D, fdcid int, prices []price, validity time.Duration) errors.E {
+	prices = slices.Clone(prices)
+	slices.SortStableFunc(prices, func(a, b price) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	byUnit := map[document.AmountUnit][]price{}
+	availabilities := []price{}
+	for _, p := range prices {
+		if p.Amount != nil {
+			// A later record with the same time replaces an earlier one.
+			if l := len(byUnit[p.Unit]); l > 0 && byUnit[p.Unit][l-1].Time.Equal(p.Time) {
+				byUnit[p.Unit][l-1] = p
+			} else {
+				byUnit[p.Unit] = append(byUnit[p.Unit], p)
+			}
+		}
+		if p.Availability != "" {
+			if l := len(availabilities); l > 0 && availabilities[l-1].Time.Equal(p.Time) {
+				availabilities[l-1] = p
+			} else {
+				availabilities = append(availabilities, p)
+			}
+		}
+	}
+
+	for _, unit := range slices.Sorted(maps.Keys(byUnit)) {
+		ps := byUnit[unit]
+		times := make([]time.Time, len(ps))
+		for i, p := range ps {
+			times[i] = p.Time
+		}
+		until := validityUntil(times, validity)
+		for i, p := range ps {
+			id := []interface{}{"BRANDED_FOOD", fdcid, "PRICE", unit.String(), p.Time.Unix()}
+			claim := &document.AmountClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceProducts, id...),
+					Confidence: document.HighConfidence,
+				},
+				Prop:   document.GetCorePropertyReference("PRICE"),
+				Amount: *p.Amount,
+				Unit:   unit,
+			}
+			// Meta claims have to be added before the claim is added to the document, because claims are copied.
+			errE := claim.Add(validityClaim(p.Time, until[i], id...))
+			if errE != nil {
+				return errE
+			}
+			errE = doc.Add(claim)
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+
+	times := make([]time.Time, len(availabilities))
+	for i, p := range availabilities {
+		times[i] = p.Time
+	}
+	until := validityUntil(times, validity)
+	for i, p := range availabilities {
+		id := []interface{}{"BRANDED_FOOD", fdcid, "AVAILABILITY", p.Time.Unix()}
+		claim := &document.RelationClaim{
+			CoreClaim: document.CoreClaim{
+				ID:         document.GetID(NameSpaceProducts, append(slices.Clone(id), p.Availability, 0)...),
+				Confidence: document.HighConfidence,
+			},
+			Prop: document.GetCorePropertyReference("AVAILABILITY"),
+			To:   document.GetCorePropertyReference(p.Availability),
+		}
+		errE := claim.Add(validityClaim(p.Time, until[i], id...))
+		if errE != nil {
+			return errE
+		}
+		errE = doc.Add(claim)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestReadPriceRecords(t *testing.T) {
+	t.Parallel()
+
+	csvRecords, errE := readPriceRecords(strings.NewReader(
+		"GTIN, price, currency, timestamp, availability\n"+
+			"00012345678905, 2.49, USD, 2024-01-01, InStock\n"+
+			"012345678905, , , 2024-02-01T10:00:00Z, out of stock\n",
+	), "feed.csv")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	jsonRecords, errE := readPriceRecords(strings.NewReader(`[
+		{"gtin": "00012345678905", "price": 2.49, "currency": "USD", "timestamp": "2024-01-01", "availability": "InStock"},
+		{"gtin": "012345678905", "timestamp": "2024-02-01T10:00:00Z", "availability": "out of stock"}
+	]`), "https://example.com/feed.json")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.Equal(t, csvRecords, jsonRecords)
+
+	feeds := priceFeeds{}
+	errE = feeds.add(csvRecords)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, feeds["12345678905"], 2)
+	assert.Equal(t, document.AmountUnitDollar, feeds["12345678905"][0].Unit)
+	assert.Equal(t, availabilityInStock, feeds["12345678905"][0].Availability)
+	assert.Nil(t, feeds["12345678905"][1].Amount)
+	assert.Equal(t, availabilityOutOfStock, feeds["12345678905"][1].Availability)
+
+	_, errE = readPriceRecords(strings.NewReader(""), "feed.xml")
+	assert.EqualError(t, errE, "unsupported feed format")
+
+	for _, record := range []PriceRecord{
+		{GTIN: "123", Price: nil, Currency: "", Timestamp: "2024-01-01", Availability: ""},
+		{GTIN: "123", Price: new(float64), Currency: "XTS", Timestamp: "2024-01-01", Availability: ""},
+		{GTIN: "123", Price: new(float64), Currency: "EUR", Timestamp: "yesterday", Availability: ""},
+		{GTIN: "000", Price: new(float64), Currency: "EUR", Timestamp: "2024-01-01", Availability: ""},
+	} {
+		errE = priceFeeds{}.add([]PriceRecord{record})
+		assert.Error(t, errE, record)
+	}
+}
+
+func TestAddPrices(t *testing.T) {
+	t.Parallel()
+
+	amount1 := 2.49
+	amount2 := 2.99
+	amount3 := 2.29
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	doc := document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+	errE := addPrices(&doc, 1, []price{
+		{Amount: &amount2, Unit: document.AmountUnitDollar, Time: day(10), Availability: ""},
+		{Amount: &amount1, Unit: document.AmountUnitDollar, Time: day(1), Availability: availabilityInStock},
+		{Amount: &amount3, Unit: document.AmountUnitEuro, Time: day(5), Availability: ""},
+	}, 24*time.Hour)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	prices := doc.Get(document.GetCorePropertyID("PRICE"))
+	require.Len(t, prices, 3)
+
+	for _, tt := range []struct {
+		asOf     time.Time
+		expected []float64
+	}{
+		{day(1), []float64{amount1}},
+		{day(5).Add(12 * time.Hour), []float64{amount1, amount3}},
+		{day(9).Add(23 * time.Hour), []float64{amount1}},
+		{day(10), []float64{amount2}},
+		{day(11), nil},
+	} {
+		var valid []float64
+		for _, claim := range prices {
+			if document.ValidAt(claim, document.Timestamp(tt.asOf)) {
+				valid = append(valid, claim.(*document.AmountClaim).Amount) //nolint:forcetypeassert,errcheck
+			}
+		}
+		assert.ElementsMatch(t, tt.expected, valid, tt.asOf)
+	}
+
+	availabilities := doc.Get(document.GetCorePropertyID("AVAILABILITY"))
+	require.Len(t, availabilities, 1)
+	assert.Equal(t, document.GetCorePropertyID(availabilityInStock), *availabilities[0].(*document.RelationClaim).To.ID) //nolint:forcetypeassert,errcheck
+}
@@ -41,6 +41,24 @@ var productsProperties = []struct {
 		"A branded food product category.",
 		[]string{`"string" claim type`},
 	},
+	{
+		"food category",
+		nil,
+		"A document is about a category of food products.",
+		[]string{`item`},
+	},
+	{
+		"in category",
+		nil,
+		"A food product belongs to a food category. Filtering by a category matches products in its subcategories as well.",
+		[]string{`"relation" claim type`},
+	},
+	{
+		"Open Food Facts category ID",
+		nil,
+		`An Open Food Facts category taxonomy identifier.`,
+		[]string{`"identifier" claim type`},
+	},
 	{
 		"publication date",
 		nil,
@@ -71,6 +89,84 @@ var productsProperties = []struct {
 		"An ingredient a food contains.",
 		[]string{`"string" claim type`},
 	},
+	{
+		"allergen",
+		nil,
+		`A common allergen a food product contains, derived from its ingredients.`,
+		[]string{`"relation" claim type`},
+	},
+	{
+		"gluten",
+		nil,
+		`Gluten, found in wheat, barley, rye, and related grains.`,
+		[]string{`item`},
+	},
+	{
+		"peanuts",
+		nil,
+		`Peanuts.`,
+		[]string{`item`},
+	},
+	{
+		"tree nuts",
+		nil,
+		`Tree nuts, e.g., almonds, cashews, walnuts, and hazelnuts.`,
+		[]string{`item`},
+	},
+	{
+		"dairy",
+		nil,
+		`Milk and products made from milk.`,
+		[]string{`item`},
+	},
+	{
+		"eggs",
+		nil,
+		`Eggs.`,
+		[]string{`item`},
+	},
+	{
+		"soy",
+		nil,
+		`Soybeans and products made from them.`,
+		[]string{`item`},
+	},
+	{
+		"fish",
+		nil,
+		`Fish.`,
+		[]string{`item`},
+	},
+	{
+		"shellfish",
+		nil,
+		`Crustaceans and molluscs, e.g., shrimps, crabs, and mussels.`,
+		[]string{`item`},
+	},
+	{
+		"sesame",
+		nil,
+		`Sesame seeds and products made from them.`,
+		[]string{`item`},
+	},
+	{
+		"dietary category",
+		nil,
+		`A dietary category a food product is suitable for, derived from its ingredients and nutrients.`,
+		[]string{`"relation" claim type`},
+	},
+	{
+		"vegetarian",
+		nil,
+		`Suitable for a vegetarian diet: without meat, fish, and shellfish.`,
+		[]string{`item`},
+	},
+	{
+		"vegan",
+		nil,
+		`Suitable for a vegan diet: without ingredients of animal origin.`,
+		[]string{`item`},
+	},
 	{
 		"ingredients",
 		nil,
@@ -125,8 +221,140 @@ var productsProperties = []struct {
 		`A description of suggested serving seize of a branded food product.`,
 		[]string{`"amount" claim type`},
 	},
+	{
+		"nutrient basis",
+		nil,
+		`Quantity of a food product a nutrient amount is for.`,
+		[]string{`"relation" claim type`},
+	},
+	{
+		"per 100 g",
+		[]string{"per 100 ml"},
+		`A nutrient amount is for 100 g (or 100 ml for liquids) of a food product.`,
+		[]string{`item`},
+	},
+	{
+		"per serving",
+		nil,
+		`A nutrient amount is for a suggested serving size of a food product.`,
+		[]string{`item`},
+	},
+	{
+		"energy",
+		[]string{"calories"},
+		`Energy content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"protein",
+		nil,
+		`Protein content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"total fat",
+		[]string{"fat"},
+		`Total fat (lipid) content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"saturated fat",
+		nil,
+		`Saturated fatty acids content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"trans fat",
+		nil,
+		`Trans fatty acids content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"cholesterol",
+		nil,
+		`Cholesterol content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"carbohydrates",
+		nil,
+		`Carbohydrates content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"dietary fiber",
+		[]string{"fiber"},
+		`Dietary fiber content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"total sugars",
+		[]string{"sugars"},
+		`Total sugars content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"added sugars",
+		nil,
+		`Added sugars content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"sodium",
+		nil,
+		`Sodium content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"calcium",
+		nil,
+		`Calcium content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"iron",
+		nil,
+		`Iron content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"potassium",
+		nil,
+		`Potassium content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"vitamin D",
+		nil,
+		`Vitamin D content of a food product.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"price",
+		nil,
+		`Price of a product, as observed in a price feed.`,
+		[]string{`"amount" claim type`},
+	},
+	{
+		"availability",
+		nil,
+		`Availability of a product, as observed in an availability feed.`,
+		[]string{`"relation" claim type`},
+	},
+	{
+		"in stock",
+		nil,
+		`A product is available.`,
+		[]string{`item`},
+	},
+	{
+		"out of stock",
+		nil,
+		`A product is not available.`,
+		[]string{`item`},
+	},
 }
 
 func init() { //nolint:gochecknoinits
-	document.GenerateCoreProperties(productsProperties)
+	document.GenerateNamespacedCoreProperties("PRODUCTS", productsProperties)
 }
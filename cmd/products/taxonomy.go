@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"html"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/importer"
+)
+
+const (
+	DefaultTaxonomyDataURL = "https://static.openfoodfacts.org/data/taxonomies/categories.txt"
+)
+
+//nolint:gochecknoglobals
+var (
+	// Property lines have a property name and a language, e.g., "wikidata:en: Q185217".
+	taxonomyPropertyRegexp = regexp.MustCompile(`^[a-z0-9_-]+:[a-z]{2,3}:`)
+	// Entry lines have a language and comma-separated names, e.g., "en:Yogurts, Yoghurts".
+	taxonomyEntryRegexp = regexp.MustCompile(`^([a-z]{2,3}(?:_[a-z]{2})?):\s*(.*)$`)
+	// Parent lines reference a parent entry by one of its names, e.g., "< en:Dairies".
+	taxonomyParentRegexp = regexp.MustCompile(`^<\s*([a-z]{2,3}):\s*(.*)$`)
+)
+
+//nolint:lll
+type Taxonomy struct {
+	Disabled bool   `default:"false"                     help:"Do not import Open Food Facts category taxonomy. Default: false."`
+	DataURL  string `default:"${defaultTaxonomyDataURL}" help:"URL of Open Food Facts category taxonomy to use. It can be a local file path, too. Default: ${defaultTaxonomyDataURL}." name:"data" placeholder:"URL"`
+}
+
+// Category is an entry of the Open Food Facts category taxonomy.
+type Category struct {
+	// ID is the canonical ID of the category, e.g., "en:yogurts".
+	ID string
+	// Names maps languages to names of the category. The first name is the main one.
+	Names map[string][]string
+	// Parents are IDs of broader categories.
+	Parents []string
+}
+
+// categoryTaxonomy is the parsed Open Food Facts category taxonomy.
+type categoryTaxonomy struct {
+	Categories []*Category
+	// byName maps normalized English names of categories to their IDs.
+	byName map[string]string
+}
+
+// taxonomyID normalizes a name in a language into a taxonomy ID, the same way
+// Open Food Facts does it: lowercase, with runs of other characters than letters and
+// digits replaced with a dash.
+func taxonomyID(language, name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		} else {
+			dash = true
+		}
+	}
+	return language + ":" + b.String()
+}
+
+func splitTaxonomyNames(names string) []string {
+	result := []string{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// parseTaxonomy parses the Open Food Facts taxonomy text format: entries are separated by
+// blank lines, each entry has optional parent lines ("< en:Dairies"), followed by lines with
+// names in languages ("en:Yogurts, Yoghurts"), and optional property lines. Stopwords and
+// synonyms entries, comments, and properties are skipped. Parents which are not defined
+// in the taxonomy are skipped as well.
+func parseTaxonomy(reader io.Reader) (*categoryTaxonomy, errors.E) {
+	categories := []*Category{}
+	// IDs of all names of categories map to canonical IDs.
+	ids := map[string]string{}
+	// Parent references as they are in the taxonomy, resolved once all entries are parsed.
+	parents := map[*Category][]string{}
+
+	var current *Category
+	var currentParents []string
+	skip := false
+	finish := func() {
+		if current != nil {
+			categories = append(categories, current)
+			parents[current] = currentParents
+		}
+		current = nil
+		currentParents = nil
+		skip = false
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 1024*1024) //nolint:mnd
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			finish()
+		case skip, strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "stopwords:"), strings.HasPrefix(line, "synonyms:"):
+			skip = true
+		case strings.HasPrefix(line, "<"):
+			match := taxonomyParentRegexp.FindStringSubmatch(line)
+			if match == nil {
+				errE := errors.New("invalid parent line")
+				errors.Details(errE)["line"] = line
+				return nil, errE
+			}
+			currentParents = append(currentParents, taxonomyID(match[1], match[2]))
+		case taxonomyPropertyRegexp.MatchString(line):
+		default:
+			match := taxonomyEntryRegexp.FindStringSubmatch(line)
+			if match == nil {
+				errE := errors.New("invalid line")
+				errors.Details(errE)["line"] = line
+				return nil, errE
+			}
+			names := splitTaxonomyNames(match[2])
+			if len(names) == 0 {
+				continue
+			}
+			if current == nil {
+				current = &Category{
+					ID:      taxonomyID(match[1], names[0]),
+					Names:   map[string][]string{},
+					Parents: nil,
+				}
+			}
+			current.Names[match[1]] = append(current.Names[match[1]], names...)
+			for _, name := range names {
+				if _, ok := ids[taxonomyID(match[1], name)]; !ok {
+					ids[taxonomyID(match[1], name)] = current.ID
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	finish()
+
+	byName := map[string]string{}
+	for _, category := range categories {
+		for _, parent := range parents[category] {
+			if id, ok := ids[parent]; ok && id != category.ID && !slices.Contains(category.Parents, id) {
+				category.Parents = append(category.Parents, id)
+			}
+		}
+		for _, name := range category.Names["en"] {
+			if _, ok := byName[taxonomyID("en", name)]; !ok {
+				byName[taxonomyID("en", name)] = category.ID
+			}
+		}
+	}
+
+	return &categoryTaxonomy{
+		Categories: categories,
+		byName:     byName,
+	}, nil
+}
+
+// match returns IDs of categories matching a FoodData Central category name. If the whole
+// name does not match an English name of a category (as is or in plural), its parts
+// (e.g., "Ice Cream & Frozen Yogurt") are matched separately.
+func (t *categoryTaxonomy) match(name string) []string {
+	if t == nil {
+		return nil
+	}
+	get := func(n string) (string, bool) {
+		id := taxonomyID("en", n)
+		if categoryID, ok := t.byName[id]; ok {
+			return categoryID, true
+		}
+		categoryID, ok := t.byName[id+"s"]
+		return categoryID, ok
+	}
+	if id, ok := get(name); ok {
+		return []string{id}
+	}
+	result := []string{}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '&' || r == ',' || r == '/' }) {
+		part = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(part), "and "))
+		if id, ok := get(part); ok && !slices.Contains(result, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func getCategoryID(id string) identifier.Identifier {
+	return document.GetID(NameSpaceProducts, "FOOD_CATEGORY", id)
+}
+
+func getBrandedFoodCategoryID(name string) identifier.Identifier {
+	return document.GetID(NameSpaceProducts, "BRANDED_FOOD_CATEGORY", name)
+}
+
+func addCategoryBroader(doc *document.D, i int, broader identifier.Identifier) errors.E {
+	return doc.Add(&document.RelationClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         document.GetID(NameSpaceProducts, "FOOD_CATEGORY", doc.ID, "BROADER", i),
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("BROADER"),
+		To:   document.Reference{ID: &broader}, //nolint:exhaustruct
+	})
+}
+
+func newCategoryDoc(id identifier.Identifier) document.D {
+	return document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    id,
+			Score: document.LowConfidence,
+		},
+		Claims: &document.ClaimTypes{
+			Relation: document.RelationClaims{
+				{
+					CoreClaim: document.CoreClaim{
+						ID:         document.GetID(NameSpaceProducts, "FOOD_CATEGORY", id, "TYPE", 0, "FOOD_CATEGORY", 0),
+						Confidence: document.HighConfidence,
+					},
+					Prop: document.GetCorePropertyReference("TYPE"),
+					To:   document.GetCorePropertyReference("FOOD_CATEGORY"),
+				},
+			},
+		},
+	}
+}
+
+// makeCategoryDoc makes a document for the Open Food Facts category. English names are used
+// if available, otherwise names in the first language of the category.
+func makeCategoryDoc(category *Category) (document.D, errors.E) {
+	doc := newCategoryDoc(getCategoryID(category.ID))
+
+	errE := doc.Add(&document.IdentifierClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         document.GetID(NameSpaceProducts, "FOOD_CATEGORY", doc.ID, "OPEN_FOOD_FACTS_CATEGORY_ID", 0),
+			Confidence: document.HighConfidence,
+		},
+		Prop:  document.GetCorePropertyReference("OPEN_FOOD_FACTS_CATEGORY_ID"),
+		Value: category.ID,
+	})
+	if errE != nil {
+		return doc, errE
+	}
+
+	language := "en"
+	if _, ok := category.Names[language]; !ok {
+		language, _, _ = strings.Cut(category.ID, ":")
+	}
+	for i, name := range category.Names[language] {
+		errE := doc.Add(&document.TextClaim{
+			CoreClaim: document.CoreClaim{
+				ID:         document.GetID(NameSpaceProducts, "FOOD_CATEGORY", doc.ID, "NAME", i),
+				Confidence: document.HighConfidence,
+			},
+			Prop: document.GetCorePropertyReference("NAME"),
+			HTML: document.TranslatableHTMLString{language: html.EscapeString(name)},
+		})
+		if errE != nil {
+			return doc, errE
+		}
+	}
+
+	for i, parent := range category.Parents {
+		errE := addCategoryBroader(&doc, i, getCategoryID(parent))
+		if errE != nil {
+			return doc, errE
+		}
+	}
+
+	return doc, nil
+}
+
+// makeBrandedFoodCategoryDoc makes a document for the FoodData Central branded food category,
+// which is narrower than Open Food Facts categories it matches.
+func makeBrandedFoodCategoryDoc(name string, taxonomy *categoryTaxonomy) (document.D, errors.E) {
+	doc := newCategoryDoc(getBrandedFoodCategoryID(name))
+
+	errE := doc.Add(&document.TextClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         document.GetID(NameSpaceProducts, "FOOD_CATEGORY", doc.ID, "NAME", 0),
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("NAME"),
+		HTML: document.TranslatableHTMLString{"en": html.EscapeString(name)},
+	})
+	if errE != nil {
+		return doc, errE
+	}
+
+	for i, id := range taxonomy.match(name) {
+		errE := addCategoryBroader(&doc, i, getCategoryID(id))
+		if errE != nil {
+			return doc, errE
+		}
+	}
+
+	return doc, nil
+}
+
+// Load downloads and parses the category taxonomy. It returns nil if the taxonomy is disabled.
+func (t Taxonomy) Load(ctx context.Context, config *Config, imp *importer.Importer) (*categoryTaxonomy, errors.E) {
+	if t.Disabled {
+		return nil, nil //nolint:nilnil
+	}
+
+	reader, errE := importer.Download(ctx, imp.HTTPClient, &config.Config, t.DataURL, "category taxonomy download progress")
+	if errE != nil {
+		return nil, errE
+	}
+	defer reader.Close()
+
+	return parseTaxonomy(reader)
+}
+
+// Run saves documents for all categories of the taxonomy. Progress is not reported separately
+// because it runs concurrently with FoodDataCentral import.
+func (t Taxonomy) Run(ctx context.Context, imp *importer.Importer, taxonomy *categoryTaxonomy) errors.E {
+	if taxonomy == nil {
+		return nil
+	}
+
+	for _, category := range taxonomy.Categories {
+		if ctx.Err() != nil {
+			break
+		}
+
+		doc, errE := makeCategoryDoc(category)
+		if errE != nil {
+			errors.Details(errE)["id"] = category.ID
+			return errE
+		}
+
+		errE = imp.Save(ctx, &doc)
+		if errE != nil {
+			errors.Details(errE)["id"] = category.ID
+			return errE
+		}
+	}
+
+	return nil
+}
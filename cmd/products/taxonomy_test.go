@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const testTaxonomy = `# Comment.
+stopwords:en: the, of
+
+synonyms:en: yogurt, yoghurt
+
+en:Dairies, Dairy products
+fr:Produits laitiers
+wikidata:en: Q185217
+
+< en:Dairy products
+en:Yogurts, Yoghurts
+fr:Yaourts
+
+< en:dairies
+en:Cheeses
+
+< en:Unknown
+fr:Glaces
+en:Ice creams
+
+< fr:glaces
+en:Frozen yogurts
+`
+
+func TestParseTaxonomy(t *testing.T) {
+	t.Parallel()
+
+	taxonomy, errE := parseTaxonomy(strings.NewReader(testTaxonomy))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.Equal(t, []*Category{
+		{ID: "en:dairies", Names: map[string][]string{"en": {"Dairies", "Dairy products"}, "fr": {"Produits laitiers"}}, Parents: nil},
+		{ID: "en:yogurts", Names: map[string][]string{"en": {"Yogurts", "Yoghurts"}, "fr": {"Yaourts"}}, Parents: []string{"en:dairies"}},
+		{ID: "en:cheeses", Names: map[string][]string{"en": {"Cheeses"}}, Parents: []string{"en:dairies"}},
+		{ID: "fr:glaces", Names: map[string][]string{"fr": {"Glaces"}, "en": {"Ice creams"}}, Parents: nil},
+		{ID: "en:frozen-yogurts", Names: map[string][]string{"en": {"Frozen yogurts"}}, Parents: []string{"fr:glaces"}},
+	}, taxonomy.Categories)
+
+	assert.Equal(t, []string{"en:cheeses"}, taxonomy.match("Cheese"))
+	assert.Equal(t, []string{"en:yogurts"}, taxonomy.match("Yogurt"))
+	assert.Equal(t, []string{"fr:glaces", "en:frozen-yogurts"}, taxonomy.match("Ice Cream & Frozen Yogurt"))
+	assert.Empty(t, taxonomy.match("Candy"))
+
+	doc, errE := makeBrandedFoodCategoryDoc("Yogurt", taxonomy)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	broader := doc.Get(document.GetCorePropertyID("BROADER"))
+	if assert.Len(t, broader, 1) {
+		claim, ok := broader[0].(*document.RelationClaim)
+		require.True(t, ok)
+		assert.Equal(t, getCategoryID("en:yogurts"), *claim.To.ID)
+	}
+
+	doc, errE = makeCategoryDoc(taxonomy.Categories[3])
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, doc.Get(document.GetCorePropertyID("NAME")), 1)
+}
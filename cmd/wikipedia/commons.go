@@ -13,7 +13,6 @@ import (
 	"gitlab.com/tozd/go/mediawiki"
 	"gitlab.com/tozd/go/x"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/time/rate"
 
 	"gitlab.com/peerdb/peerdb"
 	"gitlab.com/peerdb/peerdb/document"
@@ -106,7 +105,7 @@ func (c *CommonsCommand) processEntity(
 		return nil
 	}
 
-	additionalDocument, errE := wikipedia.ConvertEntity(ctx, globals.Logger, store, cache, wikipedia.NameSpaceWikimediaCommonsFile, entity)
+	additionalDocument, errE := wikipedia.ConvertEntity(ctx, globals.Logger, store, cache, wikipedia.NameSpaceWikimediaCommonsFile, entity, false)
 	if errE != nil {
 		if errors.Is(errE, wikipedia.ErrSilentSkipped) {
 			globals.Logger.Debug().Str("doc", document.ID.String()).Str("file", filename).Err(errE).Str("entity", entity.ID).Send()
@@ -219,13 +218,13 @@ func (c *CommonsFileDescriptionsCommand) Run(globals *Globals) errors.E {
 	defer esProcessor.Close()
 
 	pages := make(chan wikipedia.AllPagesPage, wikipedia.APILimit)
-	rateLimit := wikipediaRESTRateLimit / wikipediaRESTRatePeriod.Seconds()
-	limiter := rate.NewLimiter(rate.Limit(rateLimit), wikipediaRESTRateLimit)
+	// Rate of requests is limited by the HTTP client, we just make enough workers to use it.
+	workers := int(wikipediaRESTRateLimit / wikipediaRESTRatePeriod.Seconds())
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
 		defer close(pages)
-		return wikipedia.ListAllPages(ctx, httpClient, []int{filesWikipediaNamespace}, "commons.wikimedia.org", limiter, pages)
+		return wikipedia.ListAllPages(ctx, httpClient, []int{filesWikipediaNamespace}, "commons.wikimedia.org", pages)
 	})
 
 	count := x.Counter(0)
@@ -241,16 +240,10 @@ func (c *CommonsFileDescriptionsCommand) Run(globals *Globals) errors.E {
 		}
 	}()
 
-	for range int(rateLimit) {
+	for range workers {
 		g.Go(func() error {
 			// Loop ends when pages is closed, which happens when context is cancelled, too.
 			for page := range pages {
-				err := limiter.Wait(ctx)
-				if err != nil {
-					// Context has been canceled.
-					return errors.WithStack(err)
-				}
-
 				html, errE := wikipedia.GetPageHTML(ctx, httpClient, "commons.wikimedia.org", page.Title)
 				if errE != nil {
 					globals.Logger.Error().Err(errE).Send()
@@ -389,13 +382,13 @@ func (c *CommonsCategoriesCommand) Run(globals *Globals) errors.E {
 	defer esProcessor.Close()
 
 	pages := make(chan wikipedia.AllPagesPage, wikipedia.APILimit)
-	rateLimit := wikipediaRESTRateLimit / wikipediaRESTRatePeriod.Seconds()
-	limiter := rate.NewLimiter(rate.Limit(rateLimit), wikipediaRESTRateLimit)
+	// Rate of requests is limited by the HTTP client, we just make enough workers to use it.
+	workers := int(wikipediaRESTRateLimit / wikipediaRESTRatePeriod.Seconds())
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
 		defer close(pages)
-		return wikipedia.ListAllPages(ctx, httpClient, []int{categoriesWikipediaNamespace}, "commons.wikimedia.org", limiter, pages)
+		return wikipedia.ListAllPages(ctx, httpClient, []int{categoriesWikipediaNamespace}, "commons.wikimedia.org", pages)
 	})
 
 	count := x.Counter(0)
@@ -411,7 +404,7 @@ func (c *CommonsCategoriesCommand) Run(globals *Globals) errors.E {
 		}
 	}()
 
-	for range int(rateLimit) {
+	for range workers {
 		g.Go(func() error {
 			// Loop ends when pages is closed, which happens when context is cancelled, too.
 			for page := range pages {
@@ -420,12 +413,6 @@ func (c *CommonsCategoriesCommand) Run(globals *Globals) errors.E {
 					continue
 				}
 
-				err := limiter.Wait(ctx)
-				if err != nil {
-					// Context has been canceled.
-					return errors.WithStack(err)
-				}
-
 				html, errE := wikipedia.GetPageHTML(ctx, httpClient, "commons.wikimedia.org", page.Title)
 				if errE != nil {
 					globals.Logger.Error().Err(errE).Send()
@@ -3,41 +3,27 @@ package main
 import (
 	"reflect"
 
-	"github.com/alecthomas/kong"
 	"gitlab.com/tozd/go/errors"
-	"gitlab.com/tozd/go/zerolog"
+
+	"gitlab.com/peerdb/peerdb/internal/importer"
 )
 
 const (
-	DefaultAPILimit = "50"
-	DefaultCacheDir = ".cache"
+	DefaultAPILimit         = "50"
+	DefaultEditions         = "en"
+	DefaultDeadLinksRecheck = "720h"
+	DefaultDeadLinksTimeout = "30s"
 )
 
-//nolint:lll
-type PostgresConfig struct {
-	URL    kong.FileContentFlag `                           env:"URL_PATH" help:"File with PostgreSQL database URL. Environment variable: ${env}." placeholder:"PATH" required:"" short:"d"`
-	Schema string               `default:"${defaultSchema}"                help:"Name of PostgreSQL schema to use. Default: ${defaultSchema}."     placeholder:"NAME"             short:"s"`
-}
-
-type ElasticConfig struct {
-	URL       string `default:"${defaultElastic}" help:"URL of the ElasticSearch instance. Default: ${defaultElastic}."                       placeholder:"URL"  short:"e"`
-	Index     string `default:"${defaultIndex}"   help:"Name of ElasticSearch index to use. Default: ${defaultIndex}."                        placeholder:"NAME" short:"i"`
-	SizeField bool   `                            help:"Enable size field on documents. Requires mapper-size ElasticSearch plugin installed."`
-}
-
 // Globals describes top-level (global) flags.
 //
 //nolint:lll
 type Globals struct {
-	zerolog.LoggingConfig
-
-	Version                kong.VersionFlag `                                                            help:"Show program's version and exit."                                                                                                              short:"V"`
-	CacheDir               string           `default:"${defaultCacheDir}"                                help:"Where to cache files to. Default: ${defaultCacheDir}."                                       name:"cache" placeholder:"DIR"                    short:"C" type:"path"`
-	Postgres               PostgresConfig   `                             embed:"" envprefix:"POSTGRES_"                                                                                                                                   prefix:"postgres."`
-	Elastic                ElasticConfig    `                             embed:"" envprefix:"ELASTIC_"                                                                                                                                    prefix:"elastic."`
-	DecompressionThreads   int              `default:"0"                                                 help:"The number of threads used for decompression. Defaults to the number of available cores."                 placeholder:"INT"`
-	DecodingThreads        int              `default:"0"                                                 help:"The number of threads used for decoding. Defaults to the number of available cores."                      placeholder:"INT"`
-	ItemsProcessingThreads int              `default:"0"                                                 help:"The number of threads used for items processing. Defaults to the number of available cores."              placeholder:"INT"`
+	importer.Config
+
+	DecompressionThreads   int `default:"0" help:"The number of threads used for decompression. Defaults to the number of available cores."    placeholder:"INT"`
+	DecodingThreads        int `default:"0" help:"The number of threads used for decoding. Defaults to the number of available cores."         placeholder:"INT"`
+	ItemsProcessingThreads int `default:"0" help:"The number of threads used for items processing. Defaults to the number of available cores." placeholder:"INT"`
 }
 
 // Config provides configuration.
@@ -57,6 +43,7 @@ type Config struct {
 	WikipediaArticles         WikipediaArticlesCommand         `cmd:"" help:"Populate search with Wikipedia articles HTML dump."          name:"wikipedia-articles"`
 	WikipediaFileDescriptions WikipediaFileDescriptionsCommand `cmd:"" help:"Populate search with Wikipedia file descriptions HTML dump." name:"wikipedia-file-descriptions"`
 	WikipediaCategories       WikipediaCategoriesCommand       `cmd:"" help:"Populate search with Wikipedia categories HTML dump."        name:"wikipedia-categories"`
+	WikipediaTalkPages        WikipediaTalkPagesCommand        `cmd:"" help:"Populate search with Wikipedia talk pages HTML dump."        name:"wikipedia-talk-pages"`
 
 	// Not everything is available as dumps, so we fetch using API.
 	WikipediaTemplates      WikipediaTemplatesCommand      `cmd:"" help:"Populate search with Wikipedia templates using API."                 name:"wikipedia-templates"`
@@ -67,6 +54,15 @@ type Config struct {
 	Prepare  PrepareCommand  `cmd:"" help:"Prepare populated data for search."`
 	Optimize OptimizeCommand `cmd:"" help:"Optimize search data."`
 
+	// Afterwards, documents can be kept up to date using daily dumps.
+	WikidataIncremental WikidataIncrementalCommand `cmd:"" help:"Update search with changed entities from Wikidata incremental dump." name:"wikidata-incremental"`
+
+	// Documents can be validated against constraints of Wikidata properties.
+	WikidataConstraints WikidataConstraintsCommand `cmd:"" help:"Report violations of Wikidata property constraints." name:"wikidata-constraints"`
+
+	// Links of reference claims can be checked for liveness.
+	DeadLinks DeadLinksCommand `cmd:"" help:"Check links of reference claims and label dead links." name:"dead-links"`
+
 	All AllCommand `cmd:"" default:"" help:"Run all passes in order using latest dumps. Default command."`
 }
 
@@ -76,24 +72,32 @@ type runner interface {
 
 //nolint:lll
 type AllCommand struct {
-	WikidataSaveSkipped          string `help:"Save IDs of skipped Wikidata entities."                                                                                                          placeholder:"PATH" type:"path"`
-	CommonsSaveSkipped           string `help:"Save filenames of skipped Wikimedia Commons files."                                                                                              placeholder:"PATH" type:"path"`
-	WikipediaSaveSkipped         string `help:"Save filenames of skipped Wikipedia files."                                                                                                      placeholder:"PATH" type:"path"`
-	WikidataURL                  string `help:"URL of Wikidata entities JSON dump to use. It can be a local file path, too. Default: the latest."            name:"wikidata"                    placeholder:"URL"`
-	CommonsFilesURL              string `help:"URL of Wikimedia Commons image table SQL dump to use. It can be a local file path, too. Default: the latest." name:"commons-files"               placeholder:"URL"`
-	WikipediaFilesURL            string `help:"URL of Wikipedia image table SQL dump to use. It can be a local file path, too. Default: the latest."         name:"wikipedia-files"             placeholder:"URL"`
-	CommonsURL                   string `help:"URL of Wikimedia Commons entities JSON dump to use. It can be a local file path, too. Default: the latest."   name:"commons"                     placeholder:"URL"`
-	WikipediaArticlesURL         string `help:"URL of Wikipedia articles HTML dump to use. It can be a local file path, too. Default: the latest."           name:"wikipedia-articles"          placeholder:"URL"`
-	WikipediaFileDescriptionsURL string `help:"URL of Wikipedia file descriptions HTML dump to use. It can be a local file path, too. Default: the latest."  name:"wikipedia-file-descriptions" placeholder:"URL"`
-	WikipediaCategoriesURL       string `help:"URL of Wikipedia articles HTML dump to use. It can be a local file path, too. Default: the latest."           name:"wikipedia-categories"        placeholder:"URL"`
+	WikidataSaveSkipped          string   `                             help:"Save IDs of skipped Wikidata entities."                                                                                                                  placeholder:"PATH" type:"path"`
+	CommonsSaveSkipped           string   `                             help:"Save filenames of skipped Wikimedia Commons files."                                                                                                      placeholder:"PATH" type:"path"`
+	WikipediaSaveSkipped         string   `                             help:"Save filenames of skipped Wikipedia files."                                                                                                              placeholder:"PATH" type:"path"`
+	WikidataSaveConstraints      string   `                             help:"Save constraints of Wikidata properties and report their violations."                                                                                    placeholder:"PATH" type:"path"`
+	WikidataURL                  string   `                             help:"URL of Wikidata entities JSON dump to use. It can be a local file path, too. Default: the latest."                    name:"wikidata"                    placeholder:"URL"`
+	WikidataSkipDeprecated       bool     `                             help:"Skip Wikidata statements with deprecated rank instead of converting them to claims with no confidence."`
+	CommonsFilesURL              string   `                             help:"URL of Wikimedia Commons image table SQL dump to use. It can be a local file path, too. Default: the latest."         name:"commons-files"               placeholder:"URL"`
+	WikipediaFilesURL            string   `                             help:"URL of Wikipedia image table SQL dump to use. It can be a local file path, too. Default: the latest."                 name:"wikipedia-files"             placeholder:"URL"`
+	CommonsURL                   string   `                             help:"URL of Wikimedia Commons entities JSON dump to use. It can be a local file path, too. Default: the latest."           name:"commons"                     placeholder:"URL"`
+	WikipediaArticlesURL         string   `                             help:"URL of Wikipedia articles HTML dump to use. It can be a local file path, too. Default: the latest."                   name:"wikipedia-articles"          placeholder:"URL"`
+	WikipediaFileDescriptionsURL string   `                             help:"URL of Wikipedia file descriptions HTML dump to use. It can be a local file path, too. Default: the latest."          name:"wikipedia-file-descriptions" placeholder:"URL"`
+	WikipediaCategoriesURL       string   `                             help:"URL of Wikipedia articles HTML dump to use. It can be a local file path, too. Default: the latest."                   name:"wikipedia-categories"        placeholder:"URL"`
+	WikipediaTalkPagesURL        string   `                             help:"URL of Wikipedia talk pages HTML dump to use. It can be a local file path, too. Default: the latest."                 name:"wikipedia-talk-pages"        placeholder:"URL"`
+	WikipediaEditions            []string `default:"${defaultEditions}" help:"Language codes of Wikipedia editions to import articles from, the first one is primary. Default: ${defaultEditions}."                                    placeholder:"LANG"`
+	WikipediaCleanup             string   `                             help:"Load YAML configuration of additional cleanup of Wikipedia article HTML, with overrides per edition."                                                    placeholder:"PATH" type:"path"`
+	CheckDeadLinks               bool     `                             help:"Check links of reference claims and label dead links."`
 }
 
 func (c *AllCommand) Run(globals *Globals) errors.E {
 	//nolint:exhaustruct
 	allCommands := []runner{
 		&WikidataCommand{
-			SaveSkipped: c.WikidataSaveSkipped,
-			URL:         c.WikidataURL,
+			SaveSkipped:     c.WikidataSaveSkipped,
+			SaveConstraints: c.WikidataSaveConstraints,
+			URL:             c.WikidataURL,
+			SkipDeprecated:  c.WikidataSkipDeprecated,
 		},
 		&CommonsFilesCommand{
 			SaveSkipped: c.CommonsSaveSkipped,
@@ -107,7 +111,9 @@ func (c *AllCommand) Run(globals *Globals) errors.E {
 			URL: c.CommonsURL,
 		},
 		&WikipediaArticlesCommand{
-			URL: c.WikipediaArticlesURL,
+			URL:      c.WikipediaArticlesURL,
+			Editions: c.WikipediaEditions,
+			Cleanup:  c.WikipediaCleanup,
 		},
 		&WikipediaFileDescriptionsCommand{
 			URL: c.WikipediaFileDescriptionsURL,
@@ -115,14 +121,31 @@ func (c *AllCommand) Run(globals *Globals) errors.E {
 		&WikipediaCategoriesCommand{
 			URL: c.WikipediaCategoriesURL,
 		},
+		&WikipediaTalkPagesCommand{
+			URL: c.WikipediaTalkPagesURL,
+		},
 		&WikipediaTemplatesCommand{},
 		&CommonsFileDescriptionsCommand{},
 		&CommonsCategoriesCommand{},
 		&CommonsTemplatesCommand{},
 		&PrepareCommand{},
-		&OptimizeCommand{},
 	}
 
+	if c.WikidataSaveConstraints != "" {
+		allCommands = append(allCommands, &WikidataConstraintsCommand{
+			Constraints: c.WikidataSaveConstraints,
+		})
+	}
+
+	if c.CheckDeadLinks {
+		allCommands = append(allCommands, &DeadLinksCommand{
+			Recheck: defaultDeadLinksRecheck,
+			Timeout: defaultDeadLinksTimeout,
+		})
+	}
+
+	allCommands = append(allCommands, &OptimizeCommand{})
+
 	for _, command := range allCommands {
 		globals.Logger.Info().Msgf("running command %s", reflect.TypeOf(command).Elem().Name())
 		err := command.Run(globals)
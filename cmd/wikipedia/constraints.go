@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/internal/wikipedia"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+//nolint:gochecknoglobals
+var (
+	// Map between Wikidata property IDs and their constraints.
+	wikidataPropertyConstraints = sync.Map{}
+)
+
+func savePropertyConstraints(path string, constraintsMap *sync.Map) errors.E {
+	if path == "" {
+		return nil
+	}
+
+	constraints := []wikipedia.PropertyConstraints{}
+	constraintsMap.Range(func(_, value interface{}) bool {
+		constraints = append(constraints, *value.(*wikipedia.PropertyConstraints)) //nolint:forcetypeassert
+		return true
+	})
+	sort.Slice(constraints, func(i, j int) bool {
+		return constraints[i].Property < constraints[j].Property
+	})
+
+	data, errE := x.MarshalWithoutEscapeHTML(constraints)
+	if errE != nil {
+		return errE
+	}
+	err := os.WriteFile(path, data, 0o644) //nolint:mnd,gosec
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return errE
+	}
+	return nil
+}
+
+func loadPropertyConstraints(path string) ([]wikipedia.PropertyConstraints, errors.E) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	var constraints []wikipedia.PropertyConstraints
+	errE := x.UnmarshalWithoutUnknownFields(data, &constraints)
+	if errE != nil {
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	return constraints, nil
+}
+
+// WikidataConstraintsCommand validates all documents against constraints of Wikidata properties
+// saved by WikidataCommand. Supported are value type, format, and single value constraints.
+//
+// Violations are only reported and not rejected: they are recorded as WIKIDATA_CONSTRAINT_VIOLATION
+// meta claims of violating claims and as WIKIDATA_CONSTRAINT_VIOLATION claims of documents, so that
+// documents with violations can be found using search. Previously recorded violations which are
+// not violations anymore are removed. It should be run after PrepareCommand.
+type WikidataConstraintsCommand struct {
+	Constraints string `help:"Load constraints of Wikidata properties." placeholder:"PATH" required:"" type:"existingfile"`
+}
+
+func (c *WikidataConstraintsCommand) Run(globals *Globals) errors.E {
+	constraints, errE := loadPropertyConstraints(c.Constraints)
+	if errE != nil {
+		return errE
+	}
+	globals.Logger.Info().Int("count", len(constraints)).Msg("loaded property constraints")
+
+	ctx, stop, _, store, esClient, esProcessor, cache, errE := initializeElasticSearch(globals)
+	if errE != nil {
+		return errE
+	}
+	defer stop()
+	defer esProcessor.Close()
+
+	checker := wikipedia.NewConstraintChecker(globals.Logger, store, cache, constraints)
+
+	var violations x.Counter
+	errE = processDocuments(ctx, globals, store, esClient, cache, func(ctx context.Context, id identifier.Identifier) errors.E {
+		c.checkDocument(ctx, globals, store, checker, &violations, id)
+		return nil
+	})
+	if errE != nil {
+		return errE
+	}
+
+	globals.Logger.Info().Int64("violations", violations.Count()).Msg("done")
+
+	return nil
+}
+
+func (c *WikidataConstraintsCommand) checkDocument(
+	ctx context.Context, globals *Globals,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	checker *wikipedia.ConstraintChecker, violationsCount *x.Counter, id identifier.Identifier,
+) {
+	data, _, version, errE := s.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueDeleted) {
+		return
+	} else if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		globals.Logger.Error().Err(errE).Send()
+		return
+	}
+
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		globals.Logger.Error().Err(errE).Send()
+		return
+	}
+
+	violations, errE := checker.Check(ctx, &doc)
+	if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		globals.Logger.Error().Err(errE).Msg("checking constraints failed")
+		return
+	}
+	violationsCount.Add(int64(len(violations)))
+
+	changed, errE := wikipedia.SetConstraintViolations(&doc, violations)
+	if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		globals.Logger.Error().Err(errE).Msg("recording constraint violations failed")
+		return
+	}
+
+	if changed {
+		globals.Logger.Debug().Str("doc", id.String()).Int("violations", len(violations)).Msg("updating document")
+		errE = peerdb.UpdateDocument(ctx, s, &doc, version)
+		if errE != nil {
+			errors.Details(errE)["doc"] = id.String()
+			globals.Logger.Error().Err(errE).Msg("updating document failed")
+			return
+		}
+	}
+}
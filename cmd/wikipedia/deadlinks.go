@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/internal/wikipedia"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+//nolint:gochecknoglobals
+var (
+	defaultDeadLinksRecheck = mustParseDuration(DefaultDeadLinksRecheck)
+	defaultDeadLinksTimeout = mustParseDuration(DefaultDeadLinksTimeout)
+)
+
+func mustParseDuration(s string) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		panic(errors.WithStack(err))
+	}
+	return d
+}
+
+// DeadLinksCommand checks liveness of links of reference claims of all documents using HEAD requests,
+// rate limited per host by HTTP client configuration.
+//
+// Results are recorded as LINK_LAST_CHECKED and LINK_HTTP_STATUS meta claims of reference claims and
+// dead links (404 and 410 HTTP status codes or non-existent hosts) are labeled with DEAD_LINK, both as meta
+// claims and as claims of documents, so that documents with dead links can be found using search.
+// Links checked recently are not checked again. Optionally, dead links are rewritten to their
+// archive.org snapshots. It should be run after PrepareCommand.
+type DeadLinksCommand struct {
+	Recheck time.Duration `default:"${defaultDeadLinksRecheck}" help:"Check again links checked longer than this duration ago. Default: ${defaultDeadLinksRecheck}." placeholder:"DURATION"`
+	Timeout time.Duration `default:"${defaultDeadLinksTimeout}" help:"Timeout for checking a link. Default: ${defaultDeadLinksTimeout}."                              placeholder:"DURATION"`
+	Archive bool          `                                     help:"Rewrite dead links to their archive.org snapshots, when available."`
+}
+
+func (c *DeadLinksCommand) Run(globals *Globals) errors.E {
+	ctx, stop, httpClient, store, esClient, esProcessor, cache, errE := initializeElasticSearch(globals)
+	if errE != nil {
+		return errE
+	}
+	defer stop()
+	defer esProcessor.Close()
+
+	checker := &wikipedia.DeadLinkChecker{ //nolint:exhaustruct
+		// We do not want retries when checking links, only rate limiting.
+		Client: &http.Client{ //nolint:exhaustruct
+			Transport: httpClient.HTTPClient.Transport,
+			Timeout:   c.Timeout,
+		},
+		Recheck: c.Recheck,
+	}
+	if c.Archive {
+		checker.ArchiveClient = httpClient.StandardClient()
+	}
+
+	var updated x.Counter
+	errE = processDocuments(ctx, globals, store, esClient, cache, func(ctx context.Context, id identifier.Identifier) errors.E {
+		c.checkDocument(ctx, globals, store, checker, &updated, id)
+		return nil
+	})
+	if errE != nil {
+		return errE
+	}
+
+	globals.Logger.Info().Int64("updated", updated.Count()).Msg("done")
+
+	return nil
+}
+
+func (c *DeadLinksCommand) checkDocument(
+	ctx context.Context, globals *Globals,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	checker *wikipedia.DeadLinkChecker, updatedCount *x.Counter, id identifier.Identifier,
+) {
+	data, _, version, errE := s.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueDeleted) {
+		return
+	} else if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		globals.Logger.Error().Err(errE).Send()
+		return
+	}
+
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		globals.Logger.Error().Err(errE).Send()
+		return
+	}
+
+	changed, errE := checker.CheckDocument(ctx, &doc)
+	if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		globals.Logger.Error().Err(errE).Msg("checking links failed")
+		return
+	}
+
+	if changed {
+		globals.Logger.Debug().Str("doc", id.String()).Msg("updating document")
+		errE = peerdb.UpdateDocument(ctx, s, &doc, version)
+		if errE != nil {
+			errors.Details(errE)["doc"] = id.String()
+			globals.Logger.Error().Err(errE).Msg("updating document failed")
+			return
+		}
+		updatedCount.Increment()
+	}
+}
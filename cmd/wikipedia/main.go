@@ -1,22 +1,25 @@
 package main
 
 import (
+	"strconv"
+
 	"github.com/alecthomas/kong"
 	"gitlab.com/tozd/go/cli"
 	"gitlab.com/tozd/go/errors"
 
-	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/internal/importer"
 )
 
 func main() {
 	var config Config
-	cli.Run(&config, kong.Vars{
-		"defaultAPILimit": DefaultAPILimit,
-		"defaultCacheDir": DefaultCacheDir,
-		"defaultElastic":  peerdb.DefaultElastic,
-		"defaultIndex":    peerdb.DefaultIndex,
-		"defaultSchema":   peerdb.DefaultSchema,
-	}, func(ctx *kong.Context) errors.E {
+	cli.Run(&config, importer.Vars(kong.Vars{
+		"defaultAPILimit":         DefaultAPILimit,
+		"defaultEditions":         DefaultEditions,
+		"defaultDeadLinksRecheck": DefaultDeadLinksRecheck,
+		"defaultDeadLinksTimeout": DefaultDeadLinksTimeout,
+		// Wikimedia REST API limits the rate of requests per client.
+		"defaultHTTPRateLimit": strconv.FormatFloat(wikipediaRESTRateLimit/wikipediaRESTRatePeriod.Seconds(), 'f', -1, 64),
+	}), func(ctx *kong.Context) errors.E {
 		return errors.WithStack(ctx.Run(&config.Globals))
 	})
 }
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"runtime"
 	"time"
 
 	"github.com/olivere/elastic/v7"
@@ -11,7 +10,6 @@ import (
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/x"
 	"gitlab.com/tozd/identifier"
-	"golang.org/x/sync/errgroup"
 
 	"gitlab.com/peerdb/peerdb"
 	"gitlab.com/peerdb/peerdb/document"
@@ -71,69 +69,9 @@ func (c *PrepareCommand) updateEmbeddedDocuments(
 	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	esClient *elastic.Client, cache *es.Cache,
 ) errors.E {
-	// TODO: Make configurable.
-	documentProcessingThreads := runtime.GOMAXPROCS(0)
-
-	total, err := esClient.Count(globals.Elastic.Index).Do(ctx)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	g, ctx := errgroup.WithContext(ctx)
-
-	count := x.Counter(0)
-	progress := es.Progress(globals.Logger, nil, cache, nil, "")
-	ticker := x.NewTicker(ctx, &count, total, progressPrintRate)
-	defer ticker.Stop()
-	go func() {
-		for p := range ticker.C {
-			progress(ctx, p)
-		}
-	}()
-
-	documents := make(chan identifier.Identifier, documentProcessingThreads)
-	g.Go(func() error {
-		defer close(documents)
-
-		var after *identifier.Identifier
-		for {
-			docs, errE := s.List(ctx, after)
-			if errE != nil {
-				return errE
-			}
-
-			for _, d := range docs {
-				select {
-				case documents <- d:
-				case <-ctx.Done():
-					return errors.WithStack(ctx.Err())
-				}
-				after = &d
-			}
-		}
+	return processDocuments(ctx, globals, s, esClient, cache, func(ctx context.Context, id identifier.Identifier) errors.E {
+		return c.updateEmbeddedDocumentsOne(ctx, globals.Elastic.Index, globals.Logger, s, esClient, cache, id)
 	})
-
-	for range documentProcessingThreads {
-		g.Go(func() error {
-			for {
-				select {
-				case d, ok := <-documents:
-					if !ok {
-						return nil
-					}
-					err := c.updateEmbeddedDocumentsOne(ctx, globals.Elastic.Index, globals.Logger, s, esClient, cache, d)
-					if err != nil {
-						return err
-					}
-					count.Increment()
-				case <-ctx.Done():
-					return errors.WithStack(ctx.Err())
-				}
-			}
-		})
-	}
-
-	return errors.WithStack(g.Wait())
 }
 
 func (c *PrepareCommand) updateEmbeddedDocumentsOne(
@@ -8,8 +8,7 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"path"
-	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
@@ -20,15 +19,16 @@ import (
 	"github.com/olivere/elastic/v7"
 	"github.com/rs/zerolog"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/time/rate"
 
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/mediawiki"
 	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
 
 	"gitlab.com/peerdb/peerdb"
 	"gitlab.com/peerdb/peerdb/document"
 	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/internal/importer"
 	"gitlab.com/peerdb/peerdb/internal/types"
 	"gitlab.com/peerdb/peerdb/internal/wikipedia"
 	"gitlab.com/peerdb/peerdb/store"
@@ -106,8 +106,13 @@ func initializeElasticSearch(globals *Globals) (
 	*store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	*elastic.Client, *elastic.BulkProcessor, *es.Cache, errors.E,
 ) {
-	ctx, stop, httpClient, store, esClient, esProcessor, errE := es.Standalone(
-		globals.Logger, string(globals.Postgres.URL), globals.Elastic.URL, globals.Postgres.Schema, globals.Elastic.Index, globals.Elastic.SizeField,
+	httpClient, errE := importer.NewHTTPClient(globals.Logger, &globals.HTTP)
+	if errE != nil {
+		return nil, nil, nil, nil, nil, nil, nil, errE
+	}
+
+	ctx, stop, store, esClient, esProcessor, errE := es.Standalone(
+		globals.Logger, string(globals.Postgres.URL), globals.Elastic.URL, globals.Postgres.Schema, globals.Elastic.Index, globals.Elastic.SizeField, globals.Elastic.RestrictedProperties, "wikipedia",
 	)
 	if errE != nil {
 		return nil, nil, nil, nil, nil, nil, nil, errE
@@ -121,6 +126,80 @@ func initializeElasticSearch(globals *Globals) (
 	return ctx, stop, httpClient, store, esClient, esProcessor, cache, errE
 }
 
+// processDocuments calls process for every document in the store, concurrently, while logging progress.
+func processDocuments(
+	ctx context.Context, globals *Globals,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	esClient *elastic.Client, cache *es.Cache, process func(ctx context.Context, id identifier.Identifier) errors.E,
+) errors.E {
+	// TODO: Make configurable.
+	documentProcessingThreads := runtime.GOMAXPROCS(0)
+
+	total, err := esClient.Count(globals.Elastic.Index).Do(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	count := x.Counter(0)
+	progress := es.Progress(globals.Logger, nil, cache, nil, "")
+	ticker := x.NewTicker(ctx, &count, total, progressPrintRate)
+	defer ticker.Stop()
+	go func() {
+		for p := range ticker.C {
+			progress(ctx, p)
+		}
+	}()
+
+	documents := make(chan identifier.Identifier, documentProcessingThreads)
+	g.Go(func() error {
+		defer close(documents)
+
+		var after *identifier.Identifier
+		for {
+			docs, errE := s.List(ctx, after)
+			if errE != nil {
+				return errE
+			}
+			if len(docs) == 0 {
+				return nil
+			}
+
+			for _, d := range docs {
+				select {
+				case documents <- d:
+				case <-ctx.Done():
+					return errors.WithStack(ctx.Err())
+				}
+				after = &d
+			}
+		}
+	})
+
+	for range documentProcessingThreads {
+		g.Go(func() error {
+			for {
+				select {
+				case d, ok := <-documents:
+					if !ok {
+						return nil
+					}
+					err := process(ctx, d)
+					if err != nil {
+						return err
+					}
+					count.Increment()
+				case <-ctx.Done():
+					return errors.WithStack(ctx.Err())
+				}
+			}
+		})
+	}
+
+	return errors.WithStack(g.Wait())
+}
+
 func initializeRun(
 	globals *Globals,
 	urlFunc func(context.Context, *retryablehttp.Client) (string, errors.E),
@@ -141,14 +220,14 @@ func initializeRun(
 			return nil, nil, nil, nil, nil, nil, nil, nil, errE
 		}
 
-		// Is URL in fact a path to a local file?
-		var dumpPath string
-		_, err := os.Stat(url)
-		if os.IsNotExist(err) {
-			dumpPath = filepath.Join(globals.CacheDir, path.Base(url))
-		} else {
-			dumpPath = url
-			url = ""
+		dumpPath, url := importer.GetPathAndURL(globals.CacheDir, url)
+		if url != "" && globals.RemoteCache != "" {
+			// Dump processing does not support the remote cache, so we first make sure the dump
+			// is in the cache directory, fetching it from the remote cache or downloading it.
+			dumpPath, errE = importer.Fetch(ctx, httpClient, &globals.Config, url, "dump download")
+			if errE != nil {
+				return nil, nil, nil, nil, nil, nil, nil, nil, errE
+			}
 		}
 
 		return ctx, stop, httpClient, store, esClient, esProcessor, cache, &mediawiki.ProcessDumpConfig{
@@ -179,13 +258,13 @@ func templatesCommandRun(globals *Globals, site, skippedWikidataEntitiesPath, mn
 	defer esProcessor.Close()
 
 	pages := make(chan wikipedia.AllPagesPage, wikipedia.APILimit)
-	rateLimit := wikipediaRESTRateLimit / wikipediaRESTRatePeriod.Seconds()
-	limiter := rate.NewLimiter(rate.Limit(rateLimit), wikipediaRESTRateLimit)
+	// Rate of requests is limited by the HTTP client, we just make enough workers to use it.
+	workers := int(wikipediaRESTRateLimit / wikipediaRESTRatePeriod.Seconds())
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
 		defer close(pages)
-		return wikipedia.ListAllPages(ctx, httpClient, []int{templatesWikipediaNamespace, modulesWikipediaNamespace}, site, limiter, pages)
+		return wikipedia.ListAllPages(ctx, httpClient, []int{templatesWikipediaNamespace, modulesWikipediaNamespace}, site, pages)
 	})
 
 	count := x.Counter(0)
@@ -201,7 +280,7 @@ func templatesCommandRun(globals *Globals, site, skippedWikidataEntitiesPath, mn
 		}
 	}()
 
-	for range int(rateLimit) {
+	for range workers {
 		g.Go(func() error {
 			// Loop ends when pages is closed, which happens when context is cancelled, too.
 			for page := range pages {
@@ -210,12 +289,6 @@ func templatesCommandRun(globals *Globals, site, skippedWikidataEntitiesPath, mn
 					continue
 				}
 
-				err := limiter.Wait(ctx)
-				if err != nil {
-					// Context has been canceled.
-					return errors.WithStack(err)
-				}
-
 				// First we try to get "/doc".
 				html, errE := wikipedia.GetPageHTML(ctx, httpClient, site, page.Title+"/doc")
 				if errE != nil {
@@ -224,12 +297,6 @@ func templatesCommandRun(globals *Globals, site, skippedWikidataEntitiesPath, mn
 						continue
 					}
 
-					err := limiter.Wait(ctx)
-					if err != nil {
-						// Context has been canceled.
-						return errors.WithStack(err)
-					}
-
 					// And if it does not exist, without "/doc".
 					html, errE = wikipedia.GetPageHTML(ctx, httpClient, site, page.Title)
 					if errE != nil {
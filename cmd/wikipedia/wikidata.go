@@ -1,18 +1,25 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/olivere/elastic/v7"
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/go/x"
 
 	"gitlab.com/peerdb/peerdb"
 	"gitlab.com/peerdb/peerdb/document"
 	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/internal/importer"
 	"gitlab.com/peerdb/peerdb/internal/types"
 	"gitlab.com/peerdb/peerdb/internal/wikipedia"
 	"gitlab.com/peerdb/peerdb/store"
@@ -39,11 +46,49 @@ var (
 // This is because the order of entities in a dump is arbitrary so we first insert all documents and then in PrepareCommand do another
 // pass, checking all references and setting true IDs (having Wikidata ID is useful for debugging when reference is invalid).
 // References to Wikimedia Commons files are done in a similar fashion, but with a meta claim.
+//
+// External identifiers are also resolved to IRIs (stored as reference claims with the same property) using
+// formatter URLs of their properties, but only if the property has already been imported.
+//
+// Statements with preferred rank are converted to claims with high confidence, with normal rank to claims with
+// medium confidence, and with deprecated rank to claims with no confidence (or they are skipped). Claims from
+// statements with preferred and deprecated rank are labeled with their rank using meta claims.
+//
+// Supported constraints of properties can be saved to be later used by WikidataConstraintsCommand.
+//
+// Progress (entities processed per second, percent of the dump consumed, ETA, and counts of errors by category)
+// is periodically reported in the human or JSON format and can be served over HTTP as well.
+//
+// With Sample set, only a small but connected sample is imported (e.g., for development and CI): the first Sample
+// items of the dump (read sequentially so that they are always the same for the same dump) and entities they are
+// related to (see wikipedia.RelatedWikidataEntities) up to SampleDepth, fetched using Wikidata API at their current
+// revisions. Related entities are then imported in sorted batches, so the sample is deterministic as long as those
+// entities do not change.
+//
+//nolint:lll
 type WikidataCommand struct {
-	SaveSkipped string `help:"Save IDs of skipped Wikidata entities."                                                            placeholder:"PATH" type:"path"`
-	URL         string `help:"URL of Wikidata entities JSON dump to use. It can be a local file path, too. Default: the latest." placeholder:"URL"`
+	SaveSkipped     string `                                  help:"Save IDs of skipped Wikidata entities."                                                                      placeholder:"PATH"   type:"path"`
+	SaveConstraints string `                                  help:"Save constraints of Wikidata properties."                                                                    placeholder:"PATH"   type:"path"`
+	URL             string `                                  help:"URL of Wikidata entities JSON dump to use. It can be a local file path, too. Default: the latest."           placeholder:"URL"`
+	StatusFormat    string `default:"human" enum:"human,json" help:"Format of progress output: human (logged) or json (written to stdout, one object per line). Default: human." placeholder:"FORMAT"`
+	StatusPort      int    `                                  help:"Serve progress status as JSON over HTTP on the port."                                                        placeholder:"PORT"`
+	SkipDeprecated  bool   `                                  help:"Skip statements with deprecated rank instead of converting them to claims with no confidence."`
+	Sample          int    `                                  help:"Import only a sample: the first N items of the dump and entities related to them."                           placeholder:"N"`
+	SampleDepth     int    `default:"1"                       help:"Depth of related entities to include in the sample. Default: 1."                                             placeholder:"INT"`
 }
 
+// errSampleComplete is used to stop processing the dump once enough items for the sample are processed.
+var errSampleComplete = errors.Base("sample complete")
+
+// Categories of errors when converting Wikidata entities.
+const (
+	wikidataErrorSkipped          = "skipped"
+	wikidataErrorSilentlySkipped  = "silentlySkipped"
+	wikidataErrorConversionFailed = "conversionFailed"
+	wikidataErrorTruncationFailed = "truncationFailed"
+	wikidataErrorSavingFailed     = "savingFailed"
+)
+
 func (c *WikidataCommand) Run(globals *Globals) errors.E {
 	var urlFunc func(_ context.Context, _ *retryablehttp.Client) (string, errors.E)
 	if c.URL != "" {
@@ -54,16 +99,48 @@ func (c *WikidataCommand) Run(globals *Globals) errors.E {
 		urlFunc = mediawiki.LatestWikidataEntitiesRun
 	}
 
-	ctx, stop, _, store, _, esProcessor, cache, config, errE := initializeRun(globals, urlFunc, &skippedWikidataEntitiesCount)
+	ctx, stop, httpClient, store, _, esProcessor, cache, config, errE := initializeRun(globals, urlFunc, &skippedWikidataEntitiesCount)
 	if errE != nil {
 		return errE
 	}
 	defer stop()
 	defer esProcessor.Close()
 
-	errE = mediawiki.ProcessWikidataDump(ctx, config, func(ctx context.Context, entity mediawiki.Entity) errors.E {
-		return c.processEntity(ctx, globals, store, cache, entity)
-	})
+	truncator := importer.NewTruncator(&globals.Config)
+
+	status := importer.NewStatusReporter(
+		globals.Logger, "wikidata", c.StatusFormat, os.Stdout,
+		[]string{
+			wikidataErrorSkipped, wikidataErrorSilentlySkipped, wikidataErrorConversionFailed,
+			wikidataErrorTruncationFailed, wikidataErrorSavingFailed,
+		},
+		esProcessor, cache,
+	)
+	config.Progress = status.Progress
+	if c.StatusPort != 0 {
+		stopStatus, errE := status.Serve(ctx, c.StatusPort)
+		if errE != nil {
+			return errE
+		}
+		defer stopStatus()
+	}
+
+	if c.Sample > 0 {
+		errE = c.processSample(ctx, globals, httpClient, store, cache, truncator, status, config)
+	} else {
+		errE = mediawiki.ProcessWikidataDump(ctx, config, func(ctx context.Context, entity mediawiki.Entity) errors.E {
+			defer status.Processed()
+			return c.processEntity(ctx, globals, store, cache, truncator, status, entity)
+		})
+	}
+	if errE != nil {
+		return errE
+	}
+
+	final := status.Status()
+	globals.Logger.Info().Interface("errors", final.Errors).Msg(final.String())
+
+	errE = truncator.WriteReport()
 	if errE != nil {
 		return errE
 	}
@@ -73,22 +150,96 @@ func (c *WikidataCommand) Run(globals *Globals) errors.E {
 		return errE
 	}
 
+	errE = savePropertyConstraints(c.SaveConstraints, &wikidataPropertyConstraints)
+	if errE != nil {
+		return errE
+	}
+
+	return nil
+}
+
+// processSample processes the first c.Sample items of the dump and then entities related
+// to them up to c.SampleDepth, fetching them using Wikidata API.
+func (c *WikidataCommand) processSample(
+	ctx context.Context, globals *Globals, httpClient *retryablehttp.Client,
+	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	cache *es.Cache, truncator *importer.Truncator, status *importer.StatusReporter, config *mediawiki.ProcessDumpConfig,
+) errors.E {
+	// We process the dump sequentially so that the first items are always the same.
+	config.DecodingThreads = 1
+	config.ItemsProcessingThreads = 1
+
+	processed := map[string]bool{}
+	related := []string{}
+	seeds := 0
+	errE := mediawiki.ProcessWikidataDump(ctx, config, func(ctx context.Context, entity mediawiki.Entity) errors.E {
+		if entity.Type != mediawiki.Item {
+			return nil
+		}
+		if seeds >= c.Sample {
+			return errors.WithStack(errSampleComplete)
+		}
+		seeds++
+		defer status.Processed()
+		processed[entity.ID] = true
+		related = append(related, wikipedia.RelatedWikidataEntities(entity)...)
+		return c.processEntity(ctx, globals, store, cache, truncator, status, entity)
+	})
+	if errE != nil && !errors.Is(errE, errSampleComplete) {
+		return errE
+	}
+
+	for depth := 1; depth <= c.SampleDepth; depth++ {
+		ids := []string{}
+		for _, id := range related {
+			if !processed[id] {
+				processed[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			break
+		}
+		slices.Sort(ids)
+		globals.Logger.Info().Int("depth", depth).Int("count", len(ids)).Msg("processing related entities")
+
+		related = []string{}
+		for start := 0; start < len(ids); start += wikipedia.WikidataEntitiesAPILimit {
+			batch := ids[start:min(start+wikipedia.WikidataEntitiesAPILimit, len(ids))]
+			entities, errE := wikipedia.GetWikidataEntities(ctx, httpClient, batch)
+			if errE != nil {
+				return errE
+			}
+			for _, entity := range entities {
+				related = append(related, wikipedia.RelatedWikidataEntities(entity)...)
+				errE := c.processEntity(ctx, globals, store, cache, truncator, status, entity)
+				status.Processed()
+				if errE != nil {
+					return errE
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
 func (c *WikidataCommand) processEntity(
 	ctx context.Context, globals *Globals,
 	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	cache *es.Cache, entity mediawiki.Entity,
+	cache *es.Cache, truncator *importer.Truncator, status *importer.StatusReporter, entity mediawiki.Entity,
 ) errors.E {
-	document, errE := wikipedia.ConvertEntity(ctx, globals.Logger, store, cache, wikipedia.NameSpaceWikimediaCommonsFile, entity)
+	document, errE := wikipedia.ConvertEntity(ctx, globals.Logger, store, cache, wikipedia.NameSpaceWikimediaCommonsFile, entity, c.SkipDeprecated)
 	if errE != nil {
 		if errors.Is(errE, wikipedia.ErrSilentSkipped) {
 			globals.Logger.Debug().Str("entity", entity.ID).Err(errE).Send()
+			status.Error(wikidataErrorSilentlySkipped)
 		} else if errors.Is(errE, wikipedia.ErrSkipped) {
 			globals.Logger.Warn().Str("entity", entity.ID).Err(errE).Send()
+			status.Error(wikidataErrorSkipped)
 		} else {
 			globals.Logger.Error().Str("entity", entity.ID).Err(errE).Send()
+			status.Error(wikidataErrorConversionFailed)
 		}
 		id := wikipedia.GetWikidataDocumentID(entity.ID)
 		_, loaded := skippedWikidataEntities.LoadOrStore(id.String(), true)
@@ -98,12 +249,231 @@ func (c *WikidataCommand) processEntity(
 		return nil
 	}
 
+	if c.SaveConstraints != "" {
+		if constraints := wikipedia.ExtractPropertyConstraints(entity); constraints != nil {
+			wikidataPropertyConstraints.Store(constraints.Property, constraints)
+		}
+	}
+
+	errE = truncator.Truncate(document)
+	if errE != nil {
+		globals.Logger.Error().Str("entity", entity.ID).Err(errE).Send()
+		status.Error(wikidataErrorTruncationFailed)
+		return nil
+	}
+
 	globals.Logger.Debug().Str("doc", document.ID.String()).Str("entity", entity.ID).Msg("saving document")
 	errE = peerdb.InsertOrReplaceDocument(ctx, store, document)
 	if errE != nil {
 		globals.Logger.Error().Str("entity", entity.ID).Err(errE).Send()
+		status.Error(wikidataErrorSavingFailed)
 		return nil
 	}
 
 	return nil
 }
+
+// WikidataIncrementalCommand uses Wikidata incremental (daily) stubs dump to determine which entities changed,
+// fetches their current versions using Wikidata API, and updates existing documents with claim-level changes.
+//
+// Entities are converted in the same way as in WikidataCommand and references are resolved in the same way as in
+// PrepareCommand. Only claims made from entities are changed, claims added to documents by other commands are kept.
+// Documents for new entities are inserted.
+type WikidataIncrementalCommand struct {
+	SkippedWikidataEntities      string `help:"Load IDs of skipped Wikidata entities."                                                                       placeholder:"PATH"     type:"path"`
+	SkippedWikimediaCommonsFiles string `help:"Load filenames of skipped Wikimedia Commons files."                                                           placeholder:"PATH"     type:"path"`
+	Date                         string `help:"Date of Wikidata incremental dump to use. Default: yesterday."                                                placeholder:"YYYYMMDD"`
+	URL                          string `help:"URL of Wikidata incremental stubs XML dump to use. It can be a local file path, too. Default: based on date." placeholder:"URL"`
+	SkipDeprecated               bool   `help:"Skip statements with deprecated rank instead of converting them to claims with no confidence."`
+}
+
+type wikidataIncrementalStats struct {
+	Inserted  x.Counter
+	Updated   x.Counter
+	Unchanged x.Counter
+	Skipped   x.Counter
+	Failed    x.Counter
+}
+
+func (c *WikidataIncrementalCommand) Run(globals *Globals) errors.E {
+	errE := populateSkippedMap(c.SkippedWikidataEntities, &skippedWikidataEntities, &skippedWikidataEntitiesCount)
+	if errE != nil {
+		return errE
+	}
+
+	errE = populateSkippedMap(c.SkippedWikimediaCommonsFiles, &skippedWikimediaCommonsFiles, &skippedWikimediaCommonsFilesCount)
+	if errE != nil {
+		return errE
+	}
+
+	url := c.URL
+	if url == "" {
+		date := time.Now().UTC().AddDate(0, 0, -1)
+		if c.Date != "" {
+			var err error
+			date, err = time.Parse("20060102", c.Date)
+			if err != nil {
+				errE := errors.WithMessage(err, "invalid date")
+				errors.Details(errE)["date"] = c.Date
+				return errE
+			}
+		}
+		url = wikipedia.WikidataIncrementalDumpURL(date)
+	}
+
+	ctx, stop, httpClient, store, esClient, esProcessor, cache, errE := initializeElasticSearch(globals)
+	if errE != nil {
+		return errE
+	}
+	defer stop()
+	defer esProcessor.Close()
+
+	ids, errE := c.changedEntities(ctx, globals, httpClient, url)
+	if errE != nil {
+		return errE
+	}
+	globals.Logger.Info().Int("count", len(ids)).Msg("changed entities")
+
+	truncator := importer.NewTruncator(&globals.Config)
+
+	var stats wikidataIncrementalStats
+	ticker := x.NewTicker(ctx, &stats.Updated, 0, progressPrintRate)
+	defer ticker.Stop()
+	go func() {
+		for p := range ticker.C {
+			globals.Logger.Info().
+				Int64("inserted", stats.Inserted.Count()).Int64("updated", p.Count).Int64("unchanged", stats.Unchanged.Count()).
+				Int64("skipped", stats.Skipped.Count()).Int64("failed", stats.Failed.Count()).
+				Str("elapsed", p.Elapsed.Truncate(time.Second).String()).
+				Send()
+		}
+	}()
+
+	for start := 0; start < len(ids); start += wikipedia.WikidataEntitiesAPILimit {
+		batch := ids[start:min(start+wikipedia.WikidataEntitiesAPILimit, len(ids))]
+		entities, errE := wikipedia.GetWikidataEntities(ctx, httpClient, batch)
+		if errE != nil {
+			return errE
+		}
+		for _, entity := range entities {
+			c.processEntity(ctx, globals, store, esClient, cache, truncator, &stats, entity)
+		}
+	}
+
+	globals.Logger.Info().
+		Int64("inserted", stats.Inserted.Count()).Int64("updated", stats.Updated.Count()).Int64("unchanged", stats.Unchanged.Count()).
+		Int64("skipped", stats.Skipped.Count()).Int64("failed", stats.Failed.Count()).
+		Msg("done")
+
+	return truncator.WriteReport()
+}
+
+func (c *WikidataIncrementalCommand) changedEntities(
+	ctx context.Context, globals *Globals, httpClient *retryablehttp.Client, url string,
+) ([]string, errors.E) {
+	reader, errE := importer.Download(ctx, httpClient, &globals.Config, url, "Wikidata incremental dump")
+	if errE != nil {
+		return nil, errE
+	}
+	defer reader.Close()
+
+	decompressed, err := gzip.NewReader(reader)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = url
+		return nil, errE
+	}
+	defer decompressed.Close()
+
+	ids, errE := wikipedia.ChangedWikidataEntities(decompressed)
+	if errE != nil {
+		errors.Details(errE)["url"] = url
+		return nil, errE
+	}
+	return ids, nil
+}
+
+func (c *WikidataIncrementalCommand) processEntity(
+	ctx context.Context, globals *Globals,
+	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	esClient *elastic.Client, cache *es.Cache, truncator *importer.Truncator, stats *wikidataIncrementalStats, entity mediawiki.Entity,
+) {
+	converted, errE := wikipedia.ConvertEntity(ctx, globals.Logger, store, cache, wikipedia.NameSpaceWikimediaCommonsFile, entity, c.SkipDeprecated)
+	if errE != nil {
+		if errors.Is(errE, wikipedia.ErrSilentSkipped) {
+			globals.Logger.Debug().Str("entity", entity.ID).Err(errE).Send()
+		} else if errors.Is(errE, wikipedia.ErrSkipped) {
+			globals.Logger.Warn().Str("entity", entity.ID).Err(errE).Send()
+		} else {
+			globals.Logger.Error().Str("entity", entity.ID).Err(errE).Send()
+		}
+		stats.Skipped.Increment()
+		return
+	}
+
+	errE = truncator.Truncate(converted)
+	if errE != nil {
+		errors.Details(errE)["entity"] = entity.ID
+		globals.Logger.Error().Err(errE).Send()
+		stats.Failed.Increment()
+		return
+	}
+
+	_, errE = wikipedia.UpdateEmbeddedDocuments(
+		ctx, globals.Logger, store, globals.Elastic.Index, esClient, cache,
+		&skippedWikidataEntities, &skippedWikimediaCommonsFiles,
+		converted,
+	)
+	if errE != nil {
+		errors.Details(errE)["entity"] = entity.ID
+		globals.Logger.Error().Err(errE).Msg("updating embedded documents failed")
+		stats.Failed.Increment()
+		return
+	}
+
+	existing, version, errE := wikipedia.GetWikidataEntityDocument(ctx, store, entity.ID)
+	if errors.Is(errE, wikipedia.ErrNotFound) {
+		globals.Logger.Debug().Str("doc", converted.ID.String()).Str("entity", entity.ID).Msg("saving document")
+		errE = peerdb.InsertOrReplaceDocument(ctx, store, converted)
+		if errE != nil {
+			errors.Details(errE)["entity"] = entity.ID
+			globals.Logger.Error().Err(errE).Send()
+			stats.Failed.Increment()
+			return
+		}
+		stats.Inserted.Increment()
+		return
+	} else if errE != nil {
+		globals.Logger.Error().Err(errE).Send()
+		stats.Failed.Increment()
+		return
+	}
+
+	changes, updated, errE := wikipedia.DiffWikidataEntity(ctx, store, cache, existing, converted)
+	if errE != nil {
+		details := errors.Details(errE)
+		details["doc"] = existing.ID.String()
+		details["entity"] = entity.ID
+		globals.Logger.Error().Err(errE).Msg("diffing document failed")
+		stats.Failed.Increment()
+		return
+	}
+
+	if len(changes) == 0 {
+		stats.Unchanged.Increment()
+		return
+	}
+
+	globals.Logger.Debug().Str("doc", updated.ID.String()).Str("entity", entity.ID).Int("changes", len(changes)).Msg("updating document")
+	errE = peerdb.UpdateDocumentWithChanges(ctx, store, updated, version, changes)
+	if errE != nil {
+		details := errors.Details(errE)
+		details["doc"] = updated.ID.String()
+		details["entity"] = entity.ID
+		globals.Logger.Error().Err(errE).Msg("updating document failed")
+		stats.Failed.Increment()
+		return
+	}
+	cache.Add(updated.ID, updated)
+	stats.Updated.Increment()
+}
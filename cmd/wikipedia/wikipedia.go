@@ -22,6 +22,7 @@ import (
 
 const (
 	articlesWikipediaNamespace   = 0
+	talkWikipediaNamespace       = 1
 	filesWikipediaNamespace      = 6
 	templatesWikipediaNamespace  = 10
 	categoriesWikipediaNamespace = 14
@@ -225,7 +226,7 @@ func (c *WikipediaFileDescriptionsCommand) processArticle(
 		return nil
 	}
 
-	errE = wikipedia.ConvertArticleRedirects(globals.Logger, wikipedia.NameSpaceWikipediaFile, filename, article, document)
+	errE = wikipedia.ConvertArticleRedirects(globals.Logger, wikipedia.NameSpaceWikipediaFile, wikipedia.EnglishEdition.Language, filename, article, document)
 	if errE != nil {
 		details := errors.Details(errE)
 		details["doc"] = document.ID.String()
@@ -250,8 +251,8 @@ func (c *WikipediaFileDescriptionsCommand) processArticle(
 }
 
 func wikipediaArticlesRun(
-	globals *Globals, skippedWikidataEntitiesPath, url string, namespace int,
-	convertArticle func(string, string, *document.D) errors.E,
+	globals *Globals, skippedWikidataEntitiesPath, url string, namespace int, edition wikipedia.Edition,
+	convertArticle func(string, mediawiki.Article, *document.D) errors.E,
 ) errors.E {
 	errE := populateSkippedMap(skippedWikidataEntitiesPath, &skippedWikidataEntities, &skippedWikidataEntitiesCount)
 	if errE != nil {
@@ -265,7 +266,7 @@ func wikipediaArticlesRun(
 		}
 	} else {
 		urlFunc = func(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
-			return mediawiki.LatestWikipediaRun(ctx, client, "enwiki", namespace)
+			return mediawiki.LatestWikipediaRun(ctx, client, edition.Wiki(), namespace)
 		}
 	}
 
@@ -277,7 +278,7 @@ func wikipediaArticlesRun(
 	defer esProcessor.Close()
 
 	errE = mediawiki.ProcessWikipediaDump(ctx, config, func(ctx context.Context, article mediawiki.Article) errors.E {
-		return wikipediaArticlesProcessArticle(ctx, globals, store, esClient, article, convertArticle)
+		return wikipediaArticlesProcessArticle(ctx, globals, store, esClient, edition, article, convertArticle)
 	})
 	if errE != nil {
 		return errE
@@ -289,7 +290,7 @@ func wikipediaArticlesRun(
 func wikipediaArticlesProcessArticle(
 	ctx context.Context, globals *Globals,
 	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	esClient *elastic.Client, article mediawiki.Article, convertArticle func(string, string, *document.D) errors.E,
+	esClient *elastic.Client, edition wikipedia.Edition, article mediawiki.Article, convertArticle func(string, mediawiki.Article, *document.D) errors.E,
 ) errors.E {
 	if article.MainEntity == nil {
 		if redirectRegex.MatchString(article.ArticleBody.WikiText) {
@@ -329,7 +330,7 @@ func wikipediaArticlesProcessArticle(
 
 	id := article.MainEntity.Identifier
 
-	errE = wikipedia.SetPageID(wikipedia.NameSpaceWikidata, "ENGLISH_WIKIPEDIA", id, article.Identifier, document)
+	errE = wikipedia.SetPageID(wikipedia.NameSpaceWikidata, edition.MnemonicPrefix(), id, article.Identifier, document)
 	if errE != nil {
 		details := errors.Details(errE)
 		details["doc"] = document.ID.String()
@@ -339,7 +340,7 @@ func wikipediaArticlesProcessArticle(
 		return nil
 	}
 
-	errE = convertArticle(id, article.ArticleBody.HTML, document)
+	errE = convertArticle(id, article, document)
 	if errE != nil {
 		details := errors.Details(errE)
 		details["doc"] = document.ID.String()
@@ -349,27 +350,30 @@ func wikipediaArticlesProcessArticle(
 		return nil
 	}
 
-	errE = wikipedia.ConvertArticleInCategories(globals.Logger, wikipedia.NameSpaceWikidata, "ENGLISH_WIKIPEDIA", id, article, document)
-	if errE != nil {
-		details := errors.Details(errE)
-		details["doc"] = document.ID.String()
-		details["entity"] = id
-		details["title"] = article.Name
-		globals.Logger.Error().Err(errE).Send()
-		return nil
-	}
+	// Only documents for English Wikipedia categories and templates exist.
+	if edition == wikipedia.EnglishEdition {
+		errE = wikipedia.ConvertArticleInCategories(globals.Logger, wikipedia.NameSpaceWikidata, edition.MnemonicPrefix(), id, article, document)
+		if errE != nil {
+			details := errors.Details(errE)
+			details["doc"] = document.ID.String()
+			details["entity"] = id
+			details["title"] = article.Name
+			globals.Logger.Error().Err(errE).Send()
+			return nil
+		}
 
-	errE = wikipedia.ConvertArticleUsedTemplates(globals.Logger, wikipedia.NameSpaceWikidata, "ENGLISH_WIKIPEDIA", id, article, document)
-	if errE != nil {
-		details := errors.Details(errE)
-		details["doc"] = document.ID.String()
-		details["entity"] = id
-		details["title"] = article.Name
-		globals.Logger.Error().Err(errE).Send()
-		return nil
+		errE = wikipedia.ConvertArticleUsedTemplates(globals.Logger, wikipedia.NameSpaceWikidata, edition.MnemonicPrefix(), id, article, document)
+		if errE != nil {
+			details := errors.Details(errE)
+			details["doc"] = document.ID.String()
+			details["entity"] = id
+			details["title"] = article.Name
+			globals.Logger.Error().Err(errE).Send()
+			return nil
+		}
 	}
 
-	errE = wikipedia.ConvertArticleRedirects(globals.Logger, wikipedia.NameSpaceWikidata, id, article, document)
+	errE = wikipedia.ConvertArticleRedirects(globals.Logger, wikipedia.NameSpaceWikidata, edition.Language, id, article, document)
 	if errE != nil {
 		details := errors.Details(errE)
 		details["doc"] = document.ID.String()
@@ -398,27 +402,72 @@ func wikipediaArticlesProcessArticle(
 //
 // It expects documents populated by WikidataCommand.
 //
+// Multiple Wikipedia language editions can be imported, one after the other in the order given. Articles about the same
+// Wikidata entity are merged into the same document: ARTICLE and DESCRIPTION claims contain text in languages of all editions,
+// while page IDs and citations use edition-specific properties (e.g., GERMAN_WIKIPEDIA_PAGE_ID). Categories and templates
+// are added only from the English edition. Editions can disagree about the lead image of the article (WIKIPEDIA_ARTICLE_IMAGE_URL).
+// The first edition is the primary one and its image has high confidence, while images from other editions which disagree
+// with it have low confidence.
+//
 // Most Wikidata entities do not have Wikipedia articles, but many do and this command adds a HTML body of the article to each of them,
 // serving as the main field to do full-text search on. It does some heavy processing of the HTML itself so that HTML can be directly displayed
 // alongside other content. Use of Wikipedia's CSS nor Javascript is not needed after processing. It removes infoboxes and banners as the
 // intend is that the same information is available through structured data (although this is not yet true). It removes references, citations,
 // and inline comments (e.g., "citation needed") as the intend is that they are exposed through annotations (pending as well). From the body of
 // the article it extracts also a summary (generally few paragraphs at the beginning of the article).
+// Navigation boxes, reference markers, edit links, and sections like "See also" and "References" are removed as well. What else is
+// removed can be configured with a YAML file, also per edition (see wikipedia.CleanupConfig).
 //
 // Internal links inside HTML are not yet converted to links to PeerDB documents. This is done in PrepareCommand.
 //
 // It accesses existing documents in ElasticSearch to load corresponding Wikidata entity's document which is then updated with claims with the
 // following properties: ARTICLE (body of the article), HAS_ARTICLE (a label), ENGLISH_WIKIPEDIA_PAGE_ID (internal page ID of the article),
 // DESCRIPTION (a summary, with higher confidence than Wikidata's description), NAME (from redirects pointing to the article),
-// IN_ENGLISH_WIKIPEDIA_CATEGORY (for categories the article is in), USES_ENGLISH_WIKIPEDIA_TEMPLATE (for templates used).
+// IN_ENGLISH_WIKIPEDIA_CATEGORY (for categories the article is in), USES_ENGLISH_WIKIPEDIA_TEMPLATE (for templates used),
+// WIKIPEDIA_ARTICLE_IMAGE_URL (lead image of the article).
 type WikipediaArticlesCommand struct {
-	SkippedEntities string `help:"Load IDs of skipped Wikidata entities."                                                             placeholder:"PATH" type:"path"`
-	URL             string `help:"URL of Wikipedia articles HTML dump to use. It can be a local file path, too. Default: the latest." placeholder:"URL"`
+	SkippedEntities string   `                             help:"Load IDs of skipped Wikidata entities."                                                                                    placeholder:"PATH" type:"path"` //nolint:lll
+	URL             string   `                             help:"URL of Wikipedia articles HTML dump to use. It can be a local file path, too. Only with one edition. Default: the latest." placeholder:"URL"`              //nolint:lll
+	Editions        []string `default:"${defaultEditions}" help:"Language codes of Wikipedia editions to import, the first one is primary. Default: ${defaultEditions}."                    placeholder:"LANG"`             //nolint:lll
+	Cleanup         string   `                             help:"Load YAML configuration of additional cleanup of article HTML, with overrides per edition."                                placeholder:"PATH" type:"path"` //nolint:lll
 }
 
 func (c *WikipediaArticlesCommand) Run(globals *Globals) errors.E {
-	// TODO: Skip disambiguation pages (remove corresponding document if we already have it).
-	return wikipediaArticlesRun(globals, c.SkippedEntities, c.URL, articlesWikipediaNamespace, wikipedia.ConvertWikipediaArticle)
+	editions, errE := wikipedia.ParseEditions(c.Editions)
+	if errE != nil {
+		return errE
+	}
+	if c.URL != "" && len(editions) > 1 {
+		return errors.New("URL can be used only with one edition")
+	}
+
+	var cleanup *wikipedia.CleanupConfig
+	if c.Cleanup != "" {
+		cleanup, errE = wikipedia.LoadCleanupConfig(c.Cleanup)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	for i, edition := range editions {
+		primary := i == 0
+		// TODO: Skip disambiguation pages (remove corresponding document if we already have it).
+		errE := wikipediaArticlesRun(globals, c.SkippedEntities, c.URL, articlesWikipediaNamespace, edition,
+			func(id string, article mediawiki.Article, doc *document.D) errors.E {
+				errE := wikipedia.ConvertWikipediaArticle(edition, cleanup.For(edition), id, article.ArticleBody.HTML, doc)
+				if errE != nil {
+					return errE
+				}
+				return wikipedia.ConvertArticleImage(globals.Logger, edition, primary, id, article, doc)
+			},
+		)
+		if errE != nil {
+			errors.Details(errE)["edition"] = edition.Language
+			return errE
+		}
+	}
+
+	return nil
 }
 
 // WikipediaCategoriesCommand uses Wikipedia categories HTML dump (namespace 14) as input and extracts descriptions from their Wikipedia articles and
@@ -442,11 +491,118 @@ type WikipediaCategoriesCommand struct {
 }
 
 func (c *WikipediaCategoriesCommand) Run(globals *Globals) errors.E {
-	return wikipediaArticlesRun(globals, c.SkippedEntities, c.URL, categoriesWikipediaNamespace, func(id, html string, doc *document.D) errors.E {
-		return wikipedia.ConvertCategoryDescription(id, "FROM_ENGLISH_WIKIPEDIA", html, doc)
+	return wikipediaArticlesRun(
+		globals, c.SkippedEntities, c.URL, categoriesWikipediaNamespace, wikipedia.EnglishEdition,
+		func(id string, article mediawiki.Article, doc *document.D) errors.E {
+			return wikipedia.ConvertCategoryDescription(id, "FROM_ENGLISH_WIKIPEDIA", article.ArticleBody.HTML, doc)
+		},
+	)
+}
+
+// WikipediaTalkPagesCommand uses Wikipedia talk pages HTML dump (namespace 1) as input and adds WikiProject assessment
+// of the article (quality class and importance) to a corresponding Wikidata entity.
+//
+// It expects documents populated by WikidataCommand.
+//
+// Assessments are parsed from WikiProject banner templates in the wikitext of the talk page. The quality class set in the
+// banner shell takes precedence, otherwise the highest class set by any WikiProject is used. The highest importance set by
+// any WikiProject is used. Talk pages are matched to articles by their titles.
+//
+// It accesses existing documents in ElasticSearch to load corresponding Wikidata entity's document which is then updated with claims with the
+// following properties: ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_CLASS (e.g., "FA"), ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_RANK (from 1 for "Stub"
+// to 7 for "FA" and "FL"), ENGLISH_WIKIPEDIA_ARTICLE_IMPORTANCE (e.g., "High").
+type WikipediaTalkPagesCommand struct {
+	SkippedEntities string `help:"Load IDs of skipped Wikidata entities."                                                               placeholder:"PATH" type:"path"`
+	URL             string `help:"URL of Wikipedia talk pages HTML dump to use. It can be a local file path, too. Default: the latest." placeholder:"URL"`
+}
+
+func (c *WikipediaTalkPagesCommand) Run(globals *Globals) errors.E {
+	errE := populateSkippedMap(c.SkippedEntities, &skippedWikidataEntities, &skippedWikidataEntitiesCount)
+	if errE != nil {
+		return errE
+	}
+
+	var urlFunc func(_ context.Context, _ *retryablehttp.Client) (string, errors.E)
+	if c.URL != "" {
+		urlFunc = func(_ context.Context, _ *retryablehttp.Client) (string, errors.E) {
+			return c.URL, nil
+		}
+	} else {
+		urlFunc = func(ctx context.Context, client *retryablehttp.Client) (string, errors.E) {
+			return mediawiki.LatestWikipediaRun(ctx, client, wikipedia.EnglishEdition.Wiki(), talkWikipediaNamespace)
+		}
+	}
+
+	ctx, stop, _, store, esClient, esProcessor, _, config, errE := initializeRun(globals, urlFunc, nil)
+	if errE != nil {
+		return errE
+	}
+	defer stop()
+	defer esProcessor.Close()
+
+	return mediawiki.ProcessWikipediaDump(ctx, config, func(ctx context.Context, article mediawiki.Article) errors.E {
+		return wikipediaTalkPagesProcessArticle(ctx, globals, store, esClient, article)
 	})
 }
 
+func wikipediaTalkPagesProcessArticle(
+	ctx context.Context, globals *Globals,
+	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	esClient *elastic.Client, article mediawiki.Article,
+) errors.E {
+	title, ok := strings.CutPrefix(article.Name, "Talk:")
+	if !ok {
+		globals.Logger.Warn().Str("title", article.Name).Msg("not a talk page")
+		return nil
+	}
+
+	assessment := wikipedia.ParseAssessment(article.ArticleBody.WikiText)
+	if assessment.Class == "" && assessment.Importance == "" {
+		globals.Logger.Debug().Str("title", article.Name).Msg("talk page without assessment")
+		return nil
+	}
+
+	document, version, id, errE := wikipedia.GetEnglishWikipediaArticle(ctx, store, globals.Elastic.Index, esClient, title)
+	if errE != nil {
+		details := errors.Details(errE)
+		details["title"] = article.Name
+		if errors.Is(errE, wikipedia.ErrNotFound) {
+			globals.Logger.Debug().Err(errE).Send()
+		} else {
+			globals.Logger.Error().Err(errE).Send()
+		}
+		return nil
+	}
+
+	if _, ok := skippedWikidataEntities.Load(wikipedia.GetWikidataDocumentID(id).String()); ok {
+		globals.Logger.Debug().Str("entity", id).Str("title", article.Name).Msg("skipped entity")
+		return nil
+	}
+
+	errE = wikipedia.ConvertAssessment(id, assessment, document)
+	if errE != nil {
+		details := errors.Details(errE)
+		details["doc"] = document.ID.String()
+		details["entity"] = id
+		details["title"] = article.Name
+		globals.Logger.Error().Err(errE).Send()
+		return nil
+	}
+
+	globals.Logger.Debug().Str("doc", document.ID.String()).Str("entity", id).Str("title", article.Name).Msg("updating document")
+	errE = peerdb.UpdateDocument(ctx, store, document, version)
+	if errE != nil {
+		details := errors.Details(errE)
+		details["doc"] = document.ID.String()
+		details["entity"] = id
+		details["title"] = article.Name
+		globals.Logger.Error().Err(errE).Send()
+		return nil
+	}
+
+	return nil
+}
+
 // WikipediaTemplatesCommand uses Wikipedia API as input to obtain and extract descriptions for templates (namespace 10) and modules (namespace 828)
 // from their documentation and adds template's or module's description to a corresponding Wikidata entity.
 //
@@ -1,12 +1,16 @@
 package peerdb
 
 import (
+	"time"
+
 	"github.com/alecthomas/kong"
 	mapset "github.com/deckarep/golang-set/v2"
 	"gitlab.com/tozd/go/cli"
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/zerolog"
 	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/search"
 )
 
 const (
@@ -43,7 +47,7 @@ type Globals struct {
 	Postgres PostgresConfig `embed:"" envprefix:"POSTGRES_" prefix:"postgres." yaml:"postgres"`
 	Elastic  ElasticConfig  `embed:"" envprefix:"ELASTIC_"  prefix:"elastic."  yaml:"elastic"`
 
-	Sites []Site `help:"Site configuration as JSON or YAML with fields \"domain\", \"index\", \"schema\", \"title\", \"cert\", \"key\", and \"sizeField\". Can be provided multiple times." name:"site" placeholder:"SITE" sep:"none" short:"s" yaml:"sites"`
+	Sites []Site `help:"Site configuration as JSON or YAML with fields \"domain\", \"index\", \"schema\", \"title\", \"cert\", \"key\", \"sizeField\", \"restrictedProperties\", \"elevatedTokens\", \"cors\", \"scoring\", and \"sitemap\". Can be provided multiple times." name:"site" placeholder:"SITE" sep:"none" short:"s" yaml:"sites"`
 }
 
 func (g *Globals) Validate() error {
@@ -58,6 +62,14 @@ func (g *Globals) Validate() error {
 		if err := site.Validate(); err != nil {
 			return errors.WithStack(err)
 		}
+		if err := site.validateSettings(); err != nil {
+			return err
+		}
+		if site.Theme != nil {
+			if err := site.Theme.Validate(); err != nil {
+				return errors.Errorf(`invalid theme configuration for site "%s": %w`, site.Domain, err)
+			}
+		}
 
 		// We cannot use kong to set these defaults, so we do it here.
 		if site.Index == "" {
@@ -80,8 +92,16 @@ func (g *Globals) Validate() error {
 type Config struct {
 	Globals `yaml:"globals"`
 
-	Serve    ServeCommand    `cmd:"" default:"withargs" help:"Run PeerDB server. Default command."                    yaml:"serve"`
-	Populate PopulateCommand `cmd:""                    help:"Populate search index or indices with core properties." yaml:"populate"`
+	Serve         ServeCommand         `cmd:"" default:"withargs" help:"Run PeerDB server. Default command."                           yaml:"serve"`
+	Populate      PopulateCommand      `cmd:""                    help:"Populate search index or indices with core properties."        yaml:"populate"`
+	Backup        BackupCommand        `cmd:""                    help:"Backup documents of all sites into an archive."                yaml:"backup"`
+	Restore       RestoreCommand       `cmd:""                    help:"Restore documents from an archive."                            yaml:"restore"`
+	Previews      PreviewsCommand      `cmd:""                    help:"Generate previews for files of documents."                     yaml:"previews"`
+	Checksums     ChecksumsCommand     `cmd:""                    help:"Compute checksums of files of documents and deduplicate them." yaml:"checksums"`
+	Fsck          FsckCommand          `cmd:""                    help:"Check integrity of documents and optionally fix problems."     yaml:"fsck"`
+	RelevanceTest RelevanceTestCommand `cmd:""                    help:"Evaluate relevance of search results for a corpus of queries." yaml:"relevanceTest"`
+	Doctor        DoctorCommand        `cmd:""                    help:"Validate configuration and environment."                       yaml:"doctor"`
+	SortKeys      SortKeysCommand      `cmd:""                    help:"Backfill materialized sort keys of existing documents."        yaml:"sortKeys"`
 }
 
 //nolint:lll
@@ -90,6 +110,46 @@ type ServeCommand struct {
 
 	Domain string `                          group:"Let's Encrypt:" help:"Domain name to request for Let's Encrypt's certificate when sites are not configured."   name:"tls.domain" placeholder:"STRING"           yaml:"domain"`
 	Title  string `default:"${defaultTitle}"                        help:"Title to be shown to the users when sites are not configured. Default: ${defaultTitle}."                   placeholder:"NAME"   short:"T" yaml:"title"`
+
+	PaginationKeepAlive time.Duration `default:"${defaultPaginationKeepAlive}" help:"How long to keep search results pagination sessions alive between requests. Default: ${defaultPaginationKeepAlive}." placeholder:"DURATION" yaml:"paginationKeepAlive"`
+
+	LLMMonthlyBudget float64 `                                  help:"Monthly budget in USD for LLM usage per site and per API key. Callers without an API key have a budget per IP address. Zero disables the limit." placeholder:"USD" yaml:"llmMonthlyBudget"`
+	LLMPromptPrice   float64 `default:"${defaultLLMPromptPrice}"   help:"Price in USD per million prompt tokens, used to estimate LLM cost. Default: ${defaultLLMPromptPrice}."                  placeholder:"USD" yaml:"llmPromptPrice"`
+	LLMResponsePrice float64 `default:"${defaultLLMResponsePrice}" help:"Price in USD per million response tokens, used to estimate LLM cost. Default: ${defaultLLMResponsePrice}."              placeholder:"USD" yaml:"llmResponsePrice"`
+
+	LLMAPIKeyFile string `help:"File with the API key of the LLM provider, used instead of ANTHROPIC_API_KEY environment variable. It is read again when configuration is reloaded." name:"llm-api-key-file" placeholder:"PATH" yaml:"llmApiKeyFile"`
+
+	LLMPrimary    string        `default:"${defaultLLMPrimary}"    help:"Primary LLM provider used to parse prompts, as <type>:<model>. Types: anthropic, openai, groq, ollama. Default: ${defaultLLMPrimary}."                  placeholder:"PROVIDER" yaml:"llmPrimary"`
+	LLMFallback   string        `                                  help:"Fallback LLM provider used when the primary provider fails or exceeds the latency SLO, as <type>:<model>."                                             placeholder:"PROVIDER" yaml:"llmFallback"`
+	LLMLatencySLO time.Duration `default:"${defaultLLMLatencySLO}" help:"Latency after which parsing with the primary LLM provider is abandoned for the fallback provider. Zero disables it. Default: ${defaultLLMLatencySLO}." placeholder:"DURATION" yaml:"llmLatencySLO"`
+
+	LLMConcurrency     int `default:"${defaultLLMConcurrency}"     help:"Maximum number of prompts parsed concurrently. Zero disables the limit. Default: ${defaultLLMConcurrency}."                           placeholder:"INT" yaml:"llmConcurrency"`
+	ExportConcurrency  int `default:"${defaultExportConcurrency}"  help:"Maximum number of concurrent exports of search results. Zero disables the limit. Default: ${defaultExportConcurrency}."         placeholder:"INT" yaml:"exportConcurrency"`
+	FiltersConcurrency int `default:"${defaultFiltersConcurrency}" help:"Maximum number of concurrent search filter requests. Zero disables the limit. Default: ${defaultFiltersConcurrency}."           placeholder:"INT" yaml:"filtersConcurrency"`
+	QueueLength        int `default:"${defaultQueueLength}"        help:"Maximum number of requests waiting for their turn, per limit. Further requests are rejected. Default: ${defaultQueueLength}." placeholder:"INT" yaml:"queueLength"`
+
+	AnnotationsPerHour int `default:"${defaultAnnotationsPerHour}" help:"Maximum number of annotations a caller can create per hour, per API key or, without it, per IP address. Zero disables the limit. Default: ${defaultAnnotationsPerHour}." placeholder:"INT" yaml:"annotationsPerHour"`
+
+	SecretFile string `help:"File with the secret used to sign search results pagination session tokens and personalization cookies. It has to be the same for all instances serving the same sites. If not set, a random secret is generated at startup and sessions do not survive restarts." placeholder:"PATH" yaml:"secretFile"`
+
+	SynonymsDir string `help:"Directory into which synonym files are written, so that synonyms can be updated without closing indices. It has to be available to all ElasticSearch nodes as \"peerdb-synonyms\" directory inside their config directory." placeholder:"PATH" type:"path" yaml:"synonymsDir"`
+
+	TaskWorkers int `default:"${defaultTaskWorkers}" help:"Maximum number of long-running tasks (e.g., reindexing) run concurrently per site. Further tasks wait in a queue. Default: ${defaultTaskWorkers}." placeholder:"INT" yaml:"taskWorkers"`
+
+	SlowQueries int `default:"${defaultSlowQueries}" help:"Number of slowest search requests to keep per site for inspection by administrators. Zero disables it. Default: ${defaultSlowQueries}." placeholder:"INT" yaml:"slowQueries"`
+
+	TrendingWindow time.Duration `default:"${defaultTrendingWindow}" help:"Sliding window over which trending documents are computed from tracked document views. Default: ${defaultTrendingWindow}." placeholder:"DURATION" yaml:"trendingWindow"`
+
+	ViewsPerHour int `default:"${defaultViewsPerHour}" help:"Maximum number of document views a caller can track per hour, per API key or, without it, per IP address. Zero disables the limit. Default: ${defaultViewsPerHour}." placeholder:"INT" yaml:"viewsPerHour"`
+
+	Personalization bool `help:"Personalize search results of callers with an API key based on types and properties of documents they recently viewed." yaml:"personalization"`
+
+	GRPCListen string `help:"Address to listen on for gRPC API requests (e.g., localhost:9090). Requests are not encrypted, so it should be reachable only by internal services. Disabled by default." name:"grpc-listen" placeholder:"ADDR" yaml:"grpcListen"`
+
+	ReadOnly bool `help:"Run as a read-only search replica: endpoints which write are disabled and nothing is written to the database nor the index, so read-only credentials are enough. Another instance has to initialize them." yaml:"readOnly"`
+
+	Sitemap         bool          `                                    help:"Generate sitemaps for all documents when sites are not configured."                                                          yaml:"sitemap"`
+	SitemapInterval time.Duration `default:"${defaultSitemapInterval}" help:"How often to regenerate sitemaps of sites with sitemaps enabled. Default: ${defaultSitemapInterval}." placeholder:"DURATION" yaml:"sitemapInterval"`
 }
 
 func (c *ServeCommand) Validate() error {
@@ -103,7 +163,31 @@ func (c *ServeCommand) Validate() error {
 		return errors.New("contact e-mail is required for Let's Encrypt's certificate")
 	}
 
+	if _, errE := c.llmProviders(); errE != nil {
+		return errE
+	}
+
 	return nil
 }
 
+// llmProviders returns LLM providers configured with LLMPrimary, LLMFallback, and LLMLatencySLO.
+func (c *ServeCommand) llmProviders() (*search.LLMProviders, errors.E) {
+	primary, errE := search.ParseLLMProvider(c.LLMPrimary)
+	if errE != nil {
+		return nil, errE
+	}
+	var fallback *search.LLMProvider
+	if c.LLMFallback != "" {
+		fallback, errE = search.ParseLLMProvider(c.LLMFallback)
+		if errE != nil {
+			return nil, errE
+		}
+	}
+	return &search.LLMProviders{
+		Primary:    *primary,
+		Fallback:   fallback,
+		LatencySLO: c.LLMLatencySLO,
+	}, nil
+}
+
 type PopulateCommand struct{}
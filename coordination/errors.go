@@ -0,0 +1,8 @@
+package coordination
+
+import "gitlab.com/tozd/go/errors"
+
+var (
+	ErrLeaseHeld = errors.Base("lease held by another owner")
+	ErrLeaseLost = errors.Base("lease lost")
+)
@@ -0,0 +1,228 @@
+// Package coordination provides leases stored in PostgreSQL so that only one
+// of multiple running instances runs a given job at a time.
+package coordination
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+// DefaultTTL is the default duration after which a lease which is not renewed expires.
+const DefaultTTL = time.Minute
+
+// Leases manages leases on jobs. An owner holding a lease on a job has to renew it
+// before it expires. Once it expires, another owner can acquire it (take it over).
+//
+// Times are taken from the database so that clocks of instances do not have to be in sync.
+type Leases struct {
+	// Prefix to use when initializing PostgreSQL objects used by leases.
+	Prefix string
+
+	// Owner identifies this instance. If empty, a random owner is generated during Init.
+	Owner string
+
+	// TTL is the duration after which a lease which is not renewed expires.
+	// If zero, DefaultTTL is used.
+	TTL time.Duration
+
+	dbpool *pgxpool.Pool
+}
+
+// Init initializes the Leases.
+//
+// It creates and configures the PostgreSQL table if it does not already exist.
+func (l *Leases) Init(ctx context.Context, dbpool *pgxpool.Pool) errors.E {
+	if l.dbpool != nil {
+		return errors.New("already initialized")
+	}
+
+	if l.Owner == "" {
+		l.Owner = identifier.New().String()
+	}
+	if l.TTL == 0 {
+		l.TTL = DefaultTTL
+	}
+
+	// TODO: Use schema management/migration instead.
+	errE := internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		_, err := tx.Exec(ctx, `
+			CREATE TABLE "`+l.Prefix+`Leases" (
+				-- Name of the job.
+				"job" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				-- Owner currently holding the lease.
+				"owner" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				"acquired" timestamptz NOT NULL,
+				"expires" timestamptz NOT NULL,
+				PRIMARY KEY ("job")
+			);
+		`)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+
+		return nil
+	}, nil)
+	if errE != nil {
+		var pgError *pgconn.PgError
+		if errors.As(errE, &pgError) {
+			switch pgError.Code {
+			case internal.ErrorCodeUniqueViolation:
+				// Nothing.
+			case internal.ErrorCodeDuplicateTable:
+				// Nothing.
+			default:
+				return errE
+			}
+		} else {
+			return errE
+		}
+	}
+
+	l.dbpool = dbpool
+
+	return nil
+}
+
+// Acquire acquires the lease on the job. It succeeds if the lease is not held,
+// if it has expired, or if it is already held by the same owner (in which case
+// it is renewed). Otherwise ErrLeaseHeld is returned.
+func (l *Leases) Acquire(ctx context.Context, job string) (*Lease, errors.E) {
+	arguments := []any{
+		job, l.Owner, l.TTL,
+	}
+	var expires time.Time
+	errE := internal.RetryTransaction(ctx, l.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO "`+l.Prefix+`Leases" VALUES ($1, $2, now(), now() + $3::interval)
+				ON CONFLICT ("job") DO UPDATE SET "owner"=EXCLUDED."owner", "acquired"=EXCLUDED."acquired", "expires"=EXCLUDED."expires"
+				WHERE "`+l.Prefix+`Leases"."expires"<now() OR "`+l.Prefix+`Leases"."owner"=EXCLUDED."owner"
+				RETURNING "expires"
+		`, arguments...).Scan(&expires)
+		if errors.Is(err, pgx.ErrNoRows) {
+			var owner string
+			err = tx.QueryRow(ctx, `SELECT "owner", "expires" FROM "`+l.Prefix+`Leases" WHERE "job"=$1`, job).Scan(&owner, &expires)
+			if err != nil {
+				return internal.WithPgxError(err)
+			}
+			errE := errors.WithStack(ErrLeaseHeld)
+			errors.Details(errE)["owner"] = owner
+			errors.Details(errE)["expires"] = expires
+			return errE
+		}
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["job"] = job
+		return nil, errE
+	}
+	return &Lease{
+		Job:     job,
+		Expires: expires,
+		leases:  l,
+	}, nil
+}
+
+// Lease is a lease on a job held by the owner of Leases.
+type Lease struct {
+	Job string
+
+	// Expires is when the lease expires if it is not renewed.
+	Expires time.Time
+
+	leases *Leases
+}
+
+// Renew extends the lease for another TTL. If the lease has expired
+// or has been taken over by another owner, ErrLeaseLost is returned.
+func (l *Lease) Renew(ctx context.Context) errors.E {
+	arguments := []any{
+		l.Job, l.leases.Owner, l.leases.TTL,
+	}
+	var expires time.Time
+	errE := internal.RetryTransaction(ctx, l.leases.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		err := tx.QueryRow(ctx, `
+			UPDATE "`+l.leases.Prefix+`Leases" SET "expires"=now() + $3::interval
+				WHERE "job"=$1 AND "owner"=$2 AND "expires">=now()
+				RETURNING "expires"
+		`, arguments...).Scan(&expires)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.WithStack(ErrLeaseLost)
+		}
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["job"] = l.Job
+		return errE
+	}
+	l.Expires = expires
+	return nil
+}
+
+// Release releases the lease so that another owner can acquire it without waiting
+// for it to expire. Releasing a lease which has been lost is not an error.
+func (l *Lease) Release(ctx context.Context) errors.E {
+	arguments := []any{
+		l.Job, l.leases.Owner,
+	}
+	errE := internal.RetryTransaction(ctx, l.leases.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		_, err := tx.Exec(ctx, `DELETE FROM "`+l.leases.Prefix+`Leases" WHERE "job"=$1 AND "owner"=$2`, arguments...)
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["job"] = l.Job
+		return errE
+	}
+	return nil
+}
+
+// Hold acquires the lease on the job and keeps renewing it in the background.
+//
+// The returned context is canceled (with ErrLeaseLost as the cause) if the lease is lost,
+// so that the job stops when another owner takes it over. The returned function stops
+// renewing and releases the lease. It has to be called once the job is done.
+func (l *Leases) Hold(ctx context.Context, job string) (context.Context, func(), errors.E) {
+	lease, errE := l.Acquire(ctx, job)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		// We renew the lease few times per TTL so that a failed renewal can be retried before it expires.
+		ticker := time.NewTicker(l.TTL / 3) //nolint:mnd
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+				errE := lease.Renew(leaseCtx)
+				if errors.Is(errE, ErrLeaseLost) {
+					cancel(errE)
+					return
+				}
+				// Other errors are ignored and renewal is retried at the next tick.
+			}
+		}
+	}()
+
+	return leaseCtx, func() {
+		cancel(nil)
+		<-done
+		// Lease expires anyway, so we do not fail if releasing fails.
+		_ = lease.Release(context.WithoutCancel(ctx))
+	}, nil
+}
@@ -0,0 +1,135 @@
+package coordination_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/coordination"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+func initDatabase(t *testing.T) (context.Context, *pgxpool.Pool, string) {
+	t.Helper()
+
+	if os.Getenv("POSTGRES") == "" {
+		t.Skip("POSTGRES is not available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	schema := identifier.New().String()
+	prefix := identifier.New().String() + "_"
+
+	dbpool, errE := internal.InitPostgres(ctx, os.Getenv("POSTGRES"), logger, func(context.Context) (string, string) {
+		return schema, "tests"
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		return internal.EnsureSchema(ctx, tx, schema)
+	}, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	return ctx, dbpool, prefix
+}
+
+func newLeases(ctx context.Context, t *testing.T, dbpool *pgxpool.Pool, prefix string, ttl time.Duration) *coordination.Leases {
+	t.Helper()
+
+	l := &coordination.Leases{
+		Prefix: prefix,
+		Owner:  "",
+		TTL:    ttl,
+	}
+	errE := l.Init(ctx, dbpool)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotEmpty(t, l.Owner)
+	return l
+}
+
+func TestLeases(t *testing.T) {
+	t.Parallel()
+
+	ctx, dbpool, prefix := initDatabase(t)
+
+	first := newLeases(ctx, t, dbpool, prefix, time.Second)
+	second := newLeases(ctx, t, dbpool, prefix, time.Second)
+
+	lease, errE := first.Acquire(ctx, "job")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// The same owner can acquire the lease again.
+	_, errE = first.Acquire(ctx, "job")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	_, errE = second.Acquire(ctx, "job")
+	assert.ErrorIs(t, errE, coordination.ErrLeaseHeld)
+	assert.Equal(t, first.Owner, errors.Details(errE)["owner"])
+
+	// Other jobs are independent.
+	_, errE = second.Acquire(ctx, "other")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = lease.Renew(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = lease.Release(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	lease, errE = second.Acquire(ctx, "job")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// After the lease expires, it can be taken over.
+	time.Sleep(1500 * time.Millisecond)
+
+	_, errE = first.Acquire(ctx, "job")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = lease.Renew(ctx)
+	assert.ErrorIs(t, errE, coordination.ErrLeaseLost)
+
+	// Releasing a lost lease does not release the lease of the new owner.
+	errE = lease.Release(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	_, errE = second.Acquire(ctx, "job")
+	assert.ErrorIs(t, errE, coordination.ErrLeaseHeld)
+}
+
+func TestLeasesHold(t *testing.T) {
+	t.Parallel()
+
+	ctx, dbpool, prefix := initDatabase(t)
+
+	first := newLeases(ctx, t, dbpool, prefix, 300*time.Millisecond)
+	second := newLeases(ctx, t, dbpool, prefix, 300*time.Millisecond)
+
+	leaseCtx, release, errE := first.Hold(ctx, "job")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// The lease is kept renewed past its TTL.
+	time.Sleep(time.Second)
+	require.NoError(t, leaseCtx.Err())
+
+	_, _, errE = second.Hold(ctx, "job")
+	assert.ErrorIs(t, errE, coordination.ErrLeaseHeld)
+
+	release()
+	assert.Error(t, leaseCtx.Err())
+
+	_, release, errE = second.Hold(ctx, "job")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	release()
+}
@@ -153,6 +153,8 @@ func (c *Coordinator[Data, BeginMetadata, EndMetadata, OperationMetadata]) Init(
 				// Nothing.
 			case internal.ErrorCodeDuplicateTable:
 				// Nothing.
+			case internal.ErrorCodeReadOnlyTransaction:
+				// In read-only mode, tables have to be created by another instance.
 			default:
 				return errE
 			}
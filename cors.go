@@ -0,0 +1,149 @@
+package peerdb
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/rs/cors"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/waf"
+)
+
+// embeddableRoutes are API routes (with their HTTP methods) which can be accessed
+// cross-origin from sites allowed by site's CORS configuration.
+//
+// They are a restricted, read-only API surface needed to embed search into other sites.
+//
+//nolint:gochecknoglobals
+var embeddableRoutes = map[string][]string{
+	"SearchCreate":       {http.MethodPost},
+	"SearchGet":          {http.MethodGet, http.MethodHead},
+	"SearchResults":      {http.MethodGet, http.MethodHead},
+	"SearchFilters":      {http.MethodGet, http.MethodHead},
+	"SearchRelFilter":    {http.MethodGet, http.MethodHead},
+	"SearchAmountFilter": {http.MethodGet, http.MethodHead},
+	"SearchTimeFilter":   {http.MethodGet, http.MethodHead},
+	"SearchStringFilter": {http.MethodGet, http.MethodHead},
+	"SearchIndexFilter":  {http.MethodGet, http.MethodHead},
+	"SearchSizeFilter":   {http.MethodGet, http.MethodHead},
+	"SearchClusters":     {http.MethodGet, http.MethodHead},
+	"DocumentGet":        {http.MethodGet, http.MethodHead},
+	"SiteConfig":         {http.MethodGet, http.MethodHead},
+}
+
+// CORSConfig is per-site configuration of cross-origin access to the embeddable API
+// and of which sites can embed the search widget.
+type CORSConfig struct {
+	// AllowedOrigins are origins (e.g., "https://example.com") allowed to access the API
+	// and to embed the search widget. It can contain "*" to allow any origin.
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	// AllowedHeaders are non-simple request headers allowed in cross-origin requests.
+	AllowedHeaders []string `yaml:"allowedHeaders,omitempty"`
+	// AllowCredentials allows cross-origin requests with credentials (e.g., Authorization header).
+	AllowCredentials bool `yaml:"allowCredentials,omitempty"`
+	// MaxAge is for how many seconds the results of a preflight request can be cached.
+	MaxAge int `yaml:"maxAge,omitempty"`
+}
+
+// Validate validates the CORS configuration.
+func (c *CORSConfig) Validate() error {
+	if len(c.AllowedOrigins) == 0 {
+		return errors.New("at least one allowed origin is required")
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return errors.New(`credentials cannot be allowed for any origin "*"`)
+			}
+			continue
+		}
+		if !strings.HasPrefix(origin, "https://") && !strings.HasPrefix(origin, "http://") {
+			return errors.Errorf(`invalid origin "%s"`, origin)
+		}
+		if strings.HasSuffix(origin, "/") {
+			return errors.Errorf(`origin "%s" must not end with "/"`, origin)
+		}
+	}
+	return nil
+}
+
+// newCORS returns CORS handler for the configuration, or nil if cfg is nil.
+func newCORS(cfg *CORSConfig) *cors.Cors {
+	if cfg == nil {
+		return nil
+	}
+
+	methods := []string{}
+	for _, m := range embeddableRoutes {
+		for _, method := range m {
+			if !slices.Contains(methods, method) {
+				methods = append(methods, method)
+			}
+		}
+	}
+	slices.Sort(methods)
+
+	return cors.New(cors.Options{ //nolint:exhaustruct
+		AllowedOrigins: cfg.AllowedOrigins,
+		AllowedMethods: methods,
+		AllowedHeaders: cfg.AllowedHeaders,
+		// Search results metadata (e.g., total) is returned in the Metadata header.
+		ExposedHeaders:   []string{"Metadata"},
+		MaxAge:           cfg.MaxAge,
+		AllowCredentials: cfg.AllowCredentials,
+	})
+}
+
+// frameAncestors returns the value for the frame-ancestors directive of the
+// Content-Security-Policy header for pages which can be embedded.
+func (c *CORSConfig) frameAncestors() string {
+	if c == nil {
+		return "'self'"
+	}
+	if slices.Contains(c.AllowedOrigins, "*") {
+		return "*"
+	}
+	return strings.Join(append([]string{"'self'"}, c.AllowedOrigins...), " ")
+}
+
+// isEmbeddable returns true if the route with the HTTP method can be accessed cross-origin.
+func isEmbeddable(route, method string) bool {
+	return slices.Contains(embeddableRoutes[route], method)
+}
+
+// corsMiddleware handles cross-origin requests to the embeddable API of sites with CORS configured.
+func (s *Service) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := waf.MustGetSite[*Site](req.Context()).settings().cors
+		s.corsHandler(c, next).ServeHTTP(w, req)
+	})
+}
+
+// corsHandler returns a handler which handles cross-origin requests to the embeddable API
+// using CORS handler c (which can be nil) and passes them on to next.
+//
+// Cross-origin requests to other routes are passed through without CORS headers,
+// so browsers do not allow them.
+func (s *Service) corsHandler(c *cors.Cors, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if c == nil || req.Header.Get("Origin") == "" || !strings.HasPrefix(req.URL.Path, "/api/") {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		method := req.Method
+		if method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			// Preflight request.
+			method = strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+		}
+
+		route, errE := s.router.Get(req.URL.Path, method)
+		if errE != nil || !isEmbeddable(route.Name, method) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		c.Handler(next).ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,142 @@
+package peerdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/waf"
+)
+
+func TestCORSConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		config CORSConfig
+		valid  bool
+	}{
+		{CORSConfig{AllowedOrigins: []string{"https://example.com"}}, true},
+		{CORSConfig{AllowedOrigins: []string{"*"}}, true},
+		{CORSConfig{AllowedOrigins: []string{"https://example.com"}, AllowCredentials: true}, true},
+		{CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, false},
+		{CORSConfig{AllowedOrigins: []string{}}, false},
+		{CORSConfig{AllowedOrigins: []string{"example.com"}}, false},
+		{CORSConfig{AllowedOrigins: []string{"https://example.com/"}}, false},
+	} {
+		err := tt.config.Validate()
+		if tt.valid {
+			assert.NoError(t, err, "%v", tt.config.AllowedOrigins)
+		} else {
+			assert.Error(t, err, "%v", tt.config.AllowedOrigins)
+		}
+	}
+}
+
+func TestFrameAncestors(t *testing.T) {
+	t.Parallel()
+
+	var config *CORSConfig
+	assert.Equal(t, "'self'", config.frameAncestors())
+	assert.Equal(t, "'self' https://example.com https://example.org", (&CORSConfig{AllowedOrigins: []string{"https://example.com", "https://example.org"}}).frameAncestors()) //nolint:exhaustruct
+	assert.Equal(t, "*", (&CORSConfig{AllowedOrigins: []string{"*"}}).frameAncestors())                                                                                       //nolint:exhaustruct
+}
+
+func TestIsEmbeddable(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isEmbeddable("SearchCreate", http.MethodPost))
+	assert.True(t, isEmbeddable("DocumentGet", http.MethodGet))
+	assert.False(t, isEmbeddable("DocumentGet", http.MethodPost))
+	assert.False(t, isEmbeddable("DocumentCreate", http.MethodPost))
+	assert.False(t, isEmbeddable("StorageBeginUpload", http.MethodPost))
+}
+
+func TestNewCORS(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, newCORS(nil))
+
+	c := newCORS(&CORSConfig{AllowedOrigins: []string{"https://example.com"}}) //nolint:exhaustruct
+	handler := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/s/create", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.MethodPost, w.Header().Get("Access-Control-Allow-Methods"))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/d/foo", nil)
+	req.Header.Set("Origin", "https://example.org")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSHandler(t *testing.T) {
+	t.Parallel()
+
+	ok := func(w http.ResponseWriter, _ *http.Request, _ waf.Params) {
+		w.WriteHeader(http.StatusOK)
+	}
+	router := new(waf.Router)
+	errE := router.Handle("SearchCreate", http.MethodPost, "/s/create", true, ok)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = router.Handle("DocumentCreate", http.MethodPost, "/d/create", true, ok)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	s := &Service{router: router} //nolint:exhaustruct
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := s.corsHandler(newCORS(&CORSConfig{AllowedOrigins: []string{"https://example.com"}}), next) //nolint:exhaustruct
+
+	for _, tt := range []struct {
+		name      string
+		path      string
+		origin    string
+		preflight bool
+		status    int
+		allowed   string
+	}{
+		{"preflight", "/api/s/create", "https://example.com", true, http.StatusNoContent, "https://example.com"},
+		{"preflight disallowed origin", "/api/s/create", "https://example.org", true, http.StatusNoContent, ""},
+		{"request", "/api/s/create", "https://example.com", false, http.StatusOK, "https://example.com"},
+		// Requests to non-embeddable routes are passed through without CORS headers.
+		{"preflight non-embeddable", "/api/d/create", "https://example.com", true, http.StatusOK, ""},
+		{"request non-embeddable", "/api/d/create", "https://example.com", false, http.StatusOK, ""},
+		{"unknown route", "/api/unknown", "https://example.com", false, http.StatusOK, ""},
+		{"not API", "/s/create", "https://example.com", false, http.StatusOK, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.preflight {
+				req = httptest.NewRequest(http.MethodOptions, tt.path, nil)
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			req.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.allowed, w.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+
+	// Without CORS configuration, requests are passed through without CORS headers.
+	req := httptest.NewRequest(http.MethodOptions, "/api/s/create", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+	s.corsHandler(nil, next).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,239 @@
+package peerdb
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const csvMediaType = "text/csv"
+
+// csvValuesSeparator separates values of multiple claims with the same property in one cell.
+const csvValuesSeparator = "; "
+
+// csvColumn maps a property to a CSV column.
+type csvColumn struct {
+	Name string
+	Prop identifier.Identifier
+}
+
+// wantsCSV returns true if the client requested CSV instead of JSON, either
+// using "format" parameter or the Accept header. When the Accept header is used,
+// it adds Accept to the Vary header of the response so that caches store
+// CSV and JSON responses separately.
+func wantsCSV(w http.ResponseWriter, req *http.Request) bool {
+	if req.Form.Has("format") {
+		return req.Form.Get("format") == "csv"
+	}
+	w.Header().Add("Vary", "Accept")
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == csvMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCSVColumns parses "columns" parameter values. Each value is a property ID,
+// optionally prefixed with a column name and a colon (e.g., "name:<prop ID>").
+// Without a column name, the property ID is used as the column name.
+func parseCSVColumns(values []string) ([]csvColumn, errors.E) {
+	columns := make([]csvColumn, 0, len(values))
+	for _, value := range values {
+		name, p, ok := strings.Cut(value, ":")
+		if !ok {
+			p = value
+			name = value
+		}
+		prop, errE := identifier.FromString(p)
+		if errE != nil {
+			errE = errors.WithMessage(errE, `"columns" contains an invalid property`)
+			errors.Details(errE)["column"] = value
+			return nil, errE
+		}
+		columns = append(columns, csvColumn{Name: name, Prop: prop})
+	}
+	return columns, nil
+}
+
+// amountUnitString returns the unit to append to an amount, prefixed with a space.
+func amountUnitString(unit document.AmountUnit) string {
+	// Custom units are stored as meta claims which we do not export.
+	if unit == document.AmountUnitNone || unit == document.AmountUnitCustom {
+		return ""
+	}
+	data, err := unit.MarshalJSON()
+	if err != nil {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(data, &s) != nil {
+		return ""
+	}
+	return " " + s
+}
+
+func htmlToText(html string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return html
+	}
+	return strings.TrimSpace(doc.Text())
+}
+
+// claimValueString returns a string representation of the claim's value
+// suitable for a CSV cell.
+func claimValueString(claim document.Claim) string {
+	switch c := claim.(type) {
+	case *document.IdentifierClaim:
+		return c.Value
+	case *document.ReferenceClaim:
+		return c.IRI
+	case *document.TextClaim:
+		return htmlToText(c.HTML["en"])
+	case *document.StringClaim:
+		return c.String
+	case *document.AmountClaim:
+		amount := c.Decimal
+		if amount == "" {
+			amount = strconv.FormatFloat(c.Amount, 'f', -1, 64)
+		}
+		return amount + amountUnitString(c.Unit)
+	case *document.AmountRangeClaim:
+		return strconv.FormatFloat(c.Lower, 'f', -1, 64) + "-" + strconv.FormatFloat(c.Upper, 'f', -1, 64) + amountUnitString(c.Unit)
+	case *document.RelationClaim:
+		if c.To.ID != nil {
+			return c.To.ID.String()
+		}
+		return ""
+	case *document.FileClaim:
+		return c.URL
+	case *document.NoValueClaim:
+		return "none"
+	case *document.UnknownValueClaim:
+		return "unknown"
+	case *document.TimeClaim:
+		return c.Timestamp.String()
+	case *document.TimeRangeClaim:
+		return c.Lower.String() + "/" + c.Upper.String()
+	default:
+		return ""
+	}
+}
+
+// csvHeader returns the header row for the columns. The first column is always the document ID.
+func csvHeader(columns []csvColumn) []string {
+	header := make([]string, 0, len(columns)+1)
+	header = append(header, "id")
+	for _, column := range columns {
+		header = append(header, column.Name)
+	}
+	return header
+}
+
+// csvRow returns the row for the latest version of the document with the ID,
+// with values of its claims for the columns.
+func (s *Site) csvRow(ctx context.Context, id string, columns []csvColumn) ([]string, errors.E) {
+	row := make([]string, 0, len(columns)+1)
+	row = append(row, id)
+
+	if len(columns) == 0 {
+		return row, nil
+	}
+
+	docID, errE := identifier.FromString(id)
+	if errE != nil {
+		return nil, errE
+	}
+	data, _, _, errE := s.store.GetLatest(ctx, docID)
+	if errE != nil {
+		errors.Details(errE)["id"] = id
+		return nil, errE
+	}
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		errors.Details(errE)["id"] = id
+		return nil, errE
+	}
+	s.filterDocument(ctx, &doc)
+	s.redirectMap.rewriteRelations(&doc)
+	for _, column := range columns {
+		values := []string{}
+		for _, claim := range doc.Get(column.Prop) {
+			values = append(values, claimValueString(claim))
+		}
+		row = append(row, strings.Join(values, csvValuesSeparator))
+	}
+	return row, nil
+}
+
+// writeCSV writes documents with the given IDs as CSV rows to the response, streaming
+// them as they are loaded. The first column is always the document ID.
+//
+// Once the first row is written, errors cannot be reported to the client anymore,
+// so they are only logged and the response is truncated.
+func (s *Service) writeCSV(
+	w http.ResponseWriter, req *http.Request, ids []string, columns []csvColumn, metadata map[string]interface{},
+) {
+	ctx := req.Context()
+	site := waf.MustGetSite[*Site](ctx)
+
+	_, errE := s.AddMetadata(w, req, metadata)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Content-Type", csvMediaType+"; charset=utf-8; header=present")
+	w.Header().Set("Content-Disposition", `attachment; filename="results.csv"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	err := writer.Write(csvHeader(columns))
+	if err != nil {
+		s.WithError(ctx, errors.WithStack(err))
+		return
+	}
+
+	for _, id := range ids {
+		row, errE := site.csvRow(ctx, id, columns)
+		if errE != nil {
+			s.WithError(ctx, errE)
+			return
+		}
+
+		err := writer.Write(row)
+		if err != nil {
+			s.WithError(ctx, errors.WithStack(err))
+			return
+		}
+		// We flush after every row so that rows are streamed to the client.
+		writer.Flush()
+	}
+
+	err = writer.Error()
+	if err != nil {
+		s.WithError(ctx, errors.WithStack(err))
+	}
+}
@@ -0,0 +1,79 @@
+package peerdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestWantsCSV(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		form   url.Values
+		accept string
+		want   bool
+		vary   []string
+	}{
+		{url.Values{}, "", false, []string{"Accept"}},
+		{url.Values{}, "application/json", false, []string{"Accept"}},
+		{url.Values{}, "text/csv", true, []string{"Accept"}},
+		{url.Values{}, "application/json;q=0.9, text/csv; charset=utf-8", true, []string{"Accept"}},
+		{url.Values{"format": {"csv"}}, "", true, nil},
+		{url.Values{"format": {"json"}}, "text/csv", false, nil},
+	}
+	for _, test := range tests {
+		req := &http.Request{Form: test.form, Header: http.Header{}}
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		assert.Equal(t, test.want, wantsCSV(w, req), "%v %s", test.form, test.accept)
+		assert.Equal(t, test.vary, w.Header().Values("Vary"), "%v %s", test.form, test.accept)
+	}
+}
+
+func TestParseCSVColumns(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+
+	columns, errE := parseCSVColumns([]string{prop.String(), "name:" + prop.String()})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []csvColumn{
+		{Name: prop.String(), Prop: prop},
+		{Name: "name", Prop: prop},
+	}, columns)
+	assert.Equal(t, []string{"id", prop.String(), "name"}, csvHeader(columns))
+
+	_, errE = parseCSVColumns([]string{"name:invalid"})
+	assert.Error(t, errE)
+}
+
+func TestClaimValueString(t *testing.T) {
+	t.Parallel()
+
+	to := identifier.New()
+
+	tests := []struct {
+		claim    document.Claim
+		expected string
+	}{
+		{&document.TextClaim{HTML: document.TranslatableHTMLString{"en": "<p>Hello <b>world</b></p>"}}, "Hello world"},
+		{&document.AmountClaim{Amount: 1.5, Unit: document.AmountUnitKilogram}, "1.5 kg"},
+		{&document.AmountClaim{Amount: 0.1, Decimal: "0.1", Unit: document.AmountUnitNone}, "0.1"},
+		{&document.AmountRangeClaim{Lower: 1, Upper: 2, Unit: document.AmountUnitMetre}, "1-2 m"},
+		{&document.RelationClaim{To: document.Reference{ID: &to}}, to.String()},
+		{&document.UnknownValueClaim{}, "unknown"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, claimValueString(test.claim))
+	}
+}
@@ -0,0 +1,189 @@
+package peerdb
+
+import (
+	"context"
+	"io"
+	"maps"
+	"sync/atomic"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// propertyRegistryBatchSize is how many property documents are listed at once when populating registries.
+const propertyRegistryBatchSize = 1000
+
+// siteProperties has data types and names of properties of a site. It is never modified
+// after it is stored, so it can be used concurrently.
+type siteProperties struct {
+	registry document.PropertyRegistry
+	names    search.PropertyNames
+}
+
+// properties returns current data types and names of properties of the site.
+func (s *Site) properties() *siteProperties {
+	if s.loadedProperties == nil {
+		// Site has not been initialized by ServeCommand or library (e.g., in tests).
+		return &siteProperties{
+			registry: document.NewPropertyRegistry(document.CoreProperties),
+			names:    search.NewPropertyNames(document.CoreProperties),
+		}
+	}
+	return s.loadedProperties.Load()
+}
+
+// setProperties replaces data types and names of properties of the site.
+func (s *Site) setProperties(registry document.PropertyRegistry, names search.PropertyNames) {
+	if s.loadedProperties == nil {
+		s.loadedProperties = &atomic.Pointer[siteProperties]{}
+	}
+	s.loadedProperties.Store(&siteProperties{registry: registry, names: names})
+}
+
+// populatePropertyRegistries populates registries of data types and names of properties of all sites with
+// core properties and property documents in their indices.
+func (s *Service) populatePropertyRegistries(ctx context.Context) errors.E {
+	for _, site := range s.Sites {
+		registry, names, errE := s.loadPropertyRegistry(ctx, site)
+		if errE != nil {
+			errors.Details(errE)["index"] = site.Index
+			return errE
+		}
+		site.setProperties(registry, names)
+	}
+	return nil
+}
+
+func (s *Service) loadPropertyRegistry(ctx context.Context, site *Site) (document.PropertyRegistry, search.PropertyNames, errors.E) {
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+	names := search.NewPropertyNames(document.CoreProperties)
+
+	boolQuery := elastic.NewBoolQuery().Must(
+		elastic.NewTermQuery("claims.rel.prop.id", document.GetCorePropertyID("TYPE")),
+		elastic.NewTermQuery("claims.rel.to.id", document.GetCorePropertyID("PROPERTY")),
+	)
+	scroll := s.esClient.Scroll(site.Index).Query(elastic.NewNestedQuery("claims.rel", boolQuery)).
+		FetchSource(false).Size(propertyRegistryBatchSize)
+	defer scroll.Clear(context.Background()) //nolint:errcheck,contextcheck
+
+	for {
+		res, err := scroll.Do(ctx)
+		if errors.Is(err, io.EOF) {
+			return registry, names, nil
+		} else if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+
+		for _, hit := range res.Hits.Hits {
+			id, errE := identifier.FromString(hit.Id)
+			if errE != nil {
+				errors.Details(errE)["id"] = hit.Id
+				return nil, nil, errE
+			}
+			if _, ok := document.CoreProperties[id]; ok {
+				continue
+			}
+			data, _, _, errE := site.store.GetLatest(ctx, id)
+			if errE != nil {
+				errors.Details(errE)["id"] = hit.Id
+				return nil, nil, errE
+			}
+			var property document.D
+			errE = x.UnmarshalWithoutUnknownFields(data, &property)
+			if errE != nil {
+				errors.Details(errE)["id"] = hit.Id
+				return nil, nil, errE
+			}
+			registry.Add(&property)
+			names.Add(&property)
+		}
+	}
+}
+
+// addProperty adds the property document with the ID to data types and names of properties of the site,
+// if the document exists and it is a property. It is used for properties added to the index after
+// properties have been loaded. It returns true if the property has been added.
+func (s *Site) addProperty(ctx context.Context, id identifier.Identifier) (bool, errors.E) {
+	if s.loadedProperties == nil || s.store == nil {
+		return false, nil
+	}
+
+	data, _, _, errE := s.store.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueNotFound) {
+		return false, nil
+	} else if errE != nil {
+		errors.Details(errE)["id"] = id.String()
+		return false, errE
+	}
+	var property document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &property)
+	if errE != nil {
+		errors.Details(errE)["id"] = id.String()
+		return false, errE
+	}
+	if !hasType(&property, []identifier.Identifier{document.GetCorePropertyID("PROPERTY")}) {
+		return false, nil
+	}
+
+	// Stored properties are never modified, so we add the property to copies
+	// and retry if properties have been replaced in the meantime.
+	for {
+		current := s.loadedProperties.Load()
+		registry := maps.Clone(current.registry)
+		registry.Add(&property)
+		names := maps.Clone(current.names)
+		names.Add(&property)
+		if s.loadedProperties.CompareAndSwap(current, &siteProperties{registry: registry, names: names}) {
+			return true, nil
+		}
+	}
+}
+
+// checkProperties calls check with current data types and names of properties of the site.
+// If check fails because of an unknown property which is in the index (because it has been
+// added after properties have been loaded), the property is added and check is called again.
+func (s *Site) checkProperties(
+	ctx context.Context, check func(registry document.PropertyRegistry, names search.PropertyNames) errors.E,
+) errors.E {
+	for {
+		properties := s.properties()
+		checkErrE := check(properties.registry, properties.names)
+		if !errors.Is(checkErrE, search.ErrUnknownProperty) {
+			return checkErrE
+		}
+		prop, _ := errors.AllDetails(checkErrE)["prop"].(string)
+		id, errE := identifier.FromString(prop)
+		if errE != nil {
+			return checkErrE
+		}
+		if _, ok := properties.names[id]; ok {
+			// The property is already known, so adding it again would not change anything.
+			return checkErrE
+		}
+		added, errE := s.addProperty(ctx, id)
+		if errE != nil {
+			return errE
+		}
+		if !added {
+			return checkErrE
+		}
+	}
+}
+
+// checkFilters checks that filters do not exceed site's limits and that they
+// use known properties and match their data types.
+func (s *Site) checkFilters(ctx context.Context, filtersJSON string) errors.E {
+	errE := s.settings().Limits.CheckFilters(filtersJSON)
+	if errE != nil {
+		return errE
+	}
+	return s.checkProperties(ctx, func(registry document.PropertyRegistry, names search.PropertyNames) errors.E {
+		return search.CheckFilterProperties(filtersJSON, registry, names)
+	})
+}
@@ -0,0 +1,53 @@
+package peerdb
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestCheckFiltersProperties(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	property := identifier.New()
+	filters := `{"rel": {"prop": "` + property.String() + `", "none": true}}`
+
+	site := &Site{} //nolint:exhaustruct
+	// Without initialization, core properties are known.
+	errE := site.checkFilters(ctx, `{"rel": {"prop": "`+document.GetCorePropertyID("TYPE").String()+`", "none": true}}`)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+	errE = site.checkFilters(ctx, filters)
+	assert.ErrorIs(t, errE, search.ErrUnknownProperty)
+
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+	names := search.NewPropertyNames(document.CoreProperties)
+	site.setProperties(registry, names)
+	errE = site.checkFilters(ctx, filters)
+	assert.ErrorIs(t, errE, search.ErrUnknownProperty)
+
+	// Properties loaded again (e.g., on configuration reload) are used by later checks.
+	names = maps.Clone(names)
+	names[property] = search.PropertyName{Mnemonic: "", Name: "new property"}
+	site.setProperties(registry, names)
+	errE = site.checkFilters(ctx, filters)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	// A property which is not in the index is not added and check is not repeated.
+	unknown := `{"rel": {"prop": "` + identifier.New().String() + `", "none": true}}`
+	calls := 0
+	errE = site.checkProperties(ctx, func(registry document.PropertyRegistry, names search.PropertyNames) errors.E {
+		calls++
+		return search.CheckFilterProperties(unknown, registry, names)
+	})
+	require.ErrorIs(t, errE, search.ErrUnknownProperty)
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,179 @@
+package peerdb
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/waf"
+)
+
+// devServerCheckInterval is how often availability of the development server is checked.
+const devServerCheckInterval = 2 * time.Second
+
+// devServerDownPage is served instead of HTML pages while the development server is not available.
+// It reloads itself so that the page is shown as soon as the development server is available again.
+//
+//nolint:gochecknoglobals
+var devServerDownPage = template.Must(template.New("devServerDown").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <meta http-equiv="refresh" content="2" />
+    <title>{{ .Title }}</title>
+  </head>
+  <body>
+    <p>Frontend development server at {{ .URL }} is not available. This page will reload once it is.</p>
+  </body>
+</html>
+`))
+
+// devServer tracks availability of the frontend development server (e.g., Vite)
+// to which requests are proxied during development.
+type devServer struct {
+	URL    string
+	Client *http.Client
+
+	up atomic.Bool
+}
+
+func newDevServer(url string) *devServer {
+	return &devServer{
+		URL:    url,
+		Client: cleanhttp.DefaultPooledClient(),
+		up:     atomic.Bool{},
+	}
+}
+
+func (d *devServer) setUp(logger zerolog.Logger, up bool) {
+	if d.up.Swap(up) == up {
+		return
+	}
+	if up {
+		logger.Info().Str("url", d.URL).Msg("development server is available")
+	} else {
+		logger.Warn().Str("url", d.URL).Msg("development server is not available")
+	}
+}
+
+func (d *devServer) check(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, devServerCheckInterval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, d.URL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return true
+}
+
+// watch periodically checks availability of the development server until ctx is canceled.
+func (d *devServer) watch(ctx context.Context, logger zerolog.Logger) {
+	ticker := time.NewTicker(devServerCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		d.setUp(logger, d.check(ctx))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch fetches the HTML page for the request from the development server.
+func (d *devServer) fetch(req *http.Request) ([]byte, errors.E) {
+	devReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, d.URL+req.URL.RequestURI(), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Vite serves index.html for all paths only when HTML is accepted.
+	devReq.Header.Set("Accept", "text/html")
+
+	resp, err := d.Client.Do(devReq)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errE := errors.New("unexpected status code")
+		errors.Details(errE)["code"] = resp.StatusCode
+		return nil, errE
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+// serveDevelopmentHTML serves the HTML page for the request from the development server.
+//
+// The page is rendered as a template with the site, the same as HTML static files are rendered
+// in production, so that the site configuration (e.g., title) is available during development as well.
+// If the development server is not available, a page which reloads itself is served instead.
+func (s *Service) serveDevelopmentHTML(w http.ResponseWriter, req *http.Request) {
+	site := waf.MustGetSite[*Site](req.Context())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	data, errE := s.devServer.fetch(req)
+	if errE != nil {
+		s.devServer.setUp(s.Logger, false)
+		s.WithError(req.Context(), errE)
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = devServerDownPage.Execute(w, struct {
+			Title string
+			URL   string
+		}{site.Title, s.devServer.URL})
+		return
+	}
+	s.devServer.setUp(s.Logger, true)
+
+	out, errE := renderHTML(req.URL.Path, data, site)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	if req.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(out)
+}
+
+// renderHTML renders HTML as a template with the site as data.
+func renderHTML(name string, data []byte, site *Site) ([]byte, errors.E) {
+	t, err := template.New(name).Parse(string(data))
+	if err != nil {
+		errE := errors.WithMessage(err, "unable to parse HTML template")
+		errors.Details(errE)["name"] = name
+		return nil, errE
+	}
+	var out bytes.Buffer
+	err = t.Execute(&out, site)
+	if err != nil {
+		errE := errors.WithMessage(err, "unable to render HTML template")
+		errors.Details(errE)["name"] = name
+		return nil, errE
+	}
+	return out.Bytes(), nil
+}
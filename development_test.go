@@ -0,0 +1,60 @@
+package peerdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderHTML(t *testing.T) {
+	t.Parallel()
+
+	site := &Site{Title: "Test & site"} //nolint:exhaustruct
+
+	out, errE := renderHTML("/", []byte(`<title>{{ .Title }}</title><script type="module" src="/@vite/client"></script>`), site)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, `<title>Test &amp; site</title><script type="module" src="/@vite/client"></script>`, string(out))
+
+	_, errE = renderHTML("/", []byte(`<title>{{ .Title </title>`), site)
+	assert.Error(t, errE)
+}
+
+func TestDevServer(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Method == http.MethodGet {
+			assert.Equal(t, "text/html", req.Header.Get("Accept"))
+		}
+		_, _ = w.Write([]byte("<p>" + req.URL.RequestURI() + "</p>"))
+	}))
+
+	d := newDevServer(ts.URL)
+	d.Client = ts.Client()
+
+	assert.True(t, d.check(context.Background()))
+	d.setUp(zerolog.Nop(), true)
+	assert.True(t, d.up.Load())
+
+	data, errE := d.fetch(httptest.NewRequest(http.MethodGet, "/d/123?x=1", nil))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "<p>/d/123?x=1</p>", string(data))
+
+	_, errE = d.fetch(httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Error(t, errE)
+
+	ts.Close()
+
+	assert.False(t, d.check(context.Background()))
+	_, errE = d.fetch(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Error(t, errE)
+}
@@ -0,0 +1,372 @@
+package peerdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+// Statuses of checks of DoctorCommand.
+const (
+	DoctorPass = "pass"
+	DoctorFail = "fail"
+	DoctorSkip = "skip"
+)
+
+const (
+	// doctorElasticMajorVersion is the major version of ElasticSearch PeerDB supports.
+	doctorElasticMajorVersion = "7"
+
+	doctorLLMTimeout = 30 * time.Second
+	// doctorLLMURL is the endpoint used to check the API key of the LLM provider.
+	// Listing models is free and requires a valid API key.
+	doctorLLMURL = "https://api.anthropic.com/v1/models"
+)
+
+// doctorTables are PostgreSQL tables (created by stores, coordinators, and
+// reference counting) which have to exist in the schema of every site.
+//
+//nolint:gochecknoglobals
+var doctorTables = []string{
+	"docsChanges",
+	"docsCommittedChangesets",
+	"docsCommittedValues",
+	"docsCurrentChanges",
+	"docsCurrentCommittedChangesets",
+	"docsCurrentViews",
+	"docsOperations",
+	"docsReferences",
+	"docsSessions",
+	"docsViews",
+	"storageChanges",
+	"storageCommittedChangesets",
+	"storageCommittedValues",
+	"storageCurrentChanges",
+	"storageCurrentCommittedChangesets",
+	"storageCurrentViews",
+	"storageOperations",
+	"storageSessions",
+	"storageViews",
+}
+
+// DoctorCommand validates configuration and environment before PeerDB is started.
+//
+// Configuration is validated when it is loaded. Then it checks that ElasticSearch is reachable,
+// is of a supported version, and has required plugins installed, that mappings of indices of all
+// sites match the mapping new indices are created with, that PostgreSQL is reachable and tables of
+// all sites exist, and that the LLM provider accepts the API key (if it is configured). It does not
+// create or change anything. The report is written as one JSON object per check per line.
+// The command fails if any check fails.
+type DoctorCommand struct {
+	Report        string `default:"-" help:"Path of the report to write. Default: standard output."                                                  placeholder:"PATH"                             yaml:"report"`
+	LLMAPIKeyFile string `            help:"File with the API key of the LLM provider, used instead of ANTHROPIC_API_KEY environment variable." name:"llm-api-key-file" placeholder:"PATH" yaml:"llmApiKeyFile"`
+}
+
+type doctorCheck struct {
+	Check   string `json:"check"`
+	Schema  string `json:"schema,omitempty"`
+	Index   string `json:"index,omitempty"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type doctorReport struct {
+	writer io.Writer
+	failed int
+}
+
+func (r *doctorReport) add(check doctorCheck) errors.E {
+	if check.Status == DoctorFail {
+		r.failed++
+	}
+	data, errE := x.MarshalWithoutEscapeHTML(check)
+	if errE != nil {
+		return errE
+	}
+	_, err := r.writer.Write(append(data, '\n'))
+	return errors.WithStack(err)
+}
+
+// result returns the check with its status and message based on errE.
+func (c doctorCheck) result(message string, errE errors.E) doctorCheck {
+	if errE != nil {
+		c.Status = DoctorFail
+		c.Message = errE.Error()
+	} else {
+		c.Status = DoctorPass
+		c.Message = message
+	}
+	return c
+}
+
+func (c *DoctorCommand) Run(globals *Globals) (errE errors.E) { //nolint:nonamedreturns
+	// We stop gracefully on ctrl-c and TERM signal.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var writer io.Writer = os.Stdout
+	if c.Report != "-" {
+		file, err := os.Create(c.Report)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer func() {
+			errE = errors.Join(errE, file.Close())
+		}()
+		writer = file
+	}
+	report := &doctorReport{writer: writer, failed: 0}
+
+	sites := backupSites(globals)
+
+	// Configuration has been validated when it was loaded, otherwise we would not get here.
+	errE = report.add(doctorCheck{Check: "config"}.result(fmt.Sprintf("%d site(s)", len(sites)), nil)) //nolint:exhaustruct
+	if errE != nil {
+		return errE
+	}
+
+	errE = c.checkElastic(ctx, globals, sites, report)
+	if errE != nil {
+		return errE
+	}
+
+	errE = c.checkPostgres(ctx, globals, sites, report)
+	if errE != nil {
+		return errE
+	}
+
+	errE = report.add(c.checkLLM(ctx, cleanhttp.DefaultPooledClient(), doctorLLMURL))
+	if errE != nil {
+		return errE
+	}
+
+	if report.failed > 0 {
+		errE := errors.New("checks failed")
+		errors.Details(errE)["failed"] = report.failed
+		return errE
+	}
+
+	globals.Logger.Info().Msg("All checks passed.")
+
+	return nil
+}
+
+func (c *DoctorCommand) checkElastic(ctx context.Context, globals *Globals, sites []backupSite, report *doctorReport) errors.E {
+	check := doctorCheck{Check: "elastic"} //nolint:exhaustruct
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), globals.Logger, globals.Elastic.URL)
+	if errE != nil {
+		return report.add(check.result("", errE))
+	}
+
+	version, err := esClient.ElasticsearchVersion(strings.TrimSpace(globals.Elastic.URL))
+	if err != nil {
+		return report.add(check.result("", errors.WithStack(err)))
+	}
+	if major, _, _ := strings.Cut(version, "."); major != doctorElasticMajorVersion {
+		errE = errors.Errorf("unsupported ElasticSearch version %s, version %s.x is required", version, doctorElasticMajorVersion)
+	}
+	errE = report.add(check.result("version "+version, errE))
+	if errE != nil {
+		return errE
+	}
+
+	errE = report.add(checkElasticPlugins(ctx, esClient, sites))
+	if errE != nil {
+		return errE
+	}
+
+	for _, site := range sites {
+		errE = report.add(checkElasticMapping(ctx, esClient, site))
+		if errE != nil {
+			return errE
+		}
+	}
+
+	return nil
+}
+
+func checkElasticPlugins(ctx context.Context, esClient *elastic.Client, sites []backupSite) doctorCheck {
+	check := doctorCheck{Check: "elasticPlugins"} //nolint:exhaustruct
+
+	required := []string{}
+	for _, site := range sites {
+		if site.SizeField && !slices.Contains(required, "mapper-size") {
+			required = append(required, "mapper-size")
+		}
+	}
+	if len(required) == 0 {
+		check.Status = DoctorSkip
+		check.Message = "no plugins required"
+		return check
+	}
+
+	stats, err := esClient.ClusterStats().Do(ctx)
+	if err != nil {
+		return check.result("", errors.WithStack(err))
+	}
+	installed := []string{}
+	if stats.Nodes != nil {
+		for _, plugin := range stats.Nodes.Plugins {
+			installed = append(installed, plugin.Name)
+		}
+	}
+	for _, plugin := range required {
+		if !slices.Contains(installed, plugin) {
+			errE := errors.Errorf(`plugin "%s" is not installed`, plugin)
+			return check.result("", errE)
+		}
+	}
+	return check.result("installed "+strings.Join(required, ", "), nil)
+}
+
+func checkElasticMapping(ctx context.Context, esClient *elastic.Client, site backupSite) doctorCheck {
+	check := doctorCheck{Check: "elasticMapping", Schema: site.Schema, Index: site.Index} //nolint:exhaustruct
+
+	exists, err := esClient.IndexExists(site.Index).Do(ctx)
+	if err != nil {
+		return check.result("", errors.WithStack(err))
+	}
+	if !exists {
+		// Index is created when PeerDB starts or when it is populated.
+		check.Status = DoctorSkip
+		check.Message = "index does not exist"
+		return check
+	}
+
+	differences, errE := es.IndexMappingDifferences(ctx, esClient, site.Index, site.SizeField)
+	if errE != nil {
+		return check.result("", errE)
+	}
+	if len(differences) > 0 {
+		return check.result("", errors.Errorf("mapping differs (the index has to be recreated): %s", strings.Join(differences, "; ")))
+	}
+	return check.result("mapping matches", nil)
+}
+
+func (c *DoctorCommand) checkPostgres(ctx context.Context, globals *Globals, sites []backupSite, report *doctorReport) errors.E {
+	check := doctorCheck{Check: "postgres"} //nolint:exhaustruct
+
+	ctx = context.WithValue(ctx, requestIDContextKey, "doctor")
+
+	dbpool, errE := internal.InitPostgres(ctx, string(globals.Postgres.URL), globals.Logger, getRequestWithFallback(globals.Logger))
+	if errE != nil {
+		return report.add(check.result("", errE))
+	}
+	defer dbpool.Close()
+
+	var version string
+	err := dbpool.QueryRow(ctx, `SHOW server_version`).Scan(&version)
+	if err != nil {
+		return report.add(check.result("", internal.WithPgxError(err)))
+	}
+	errE = report.add(check.result("version "+version, nil))
+	if errE != nil {
+		return errE
+	}
+
+	for _, site := range sites {
+		errE = report.add(checkPostgresTables(ctx, dbpool, site))
+		if errE != nil {
+			return errE
+		}
+	}
+
+	return nil
+}
+
+func checkPostgresTables(ctx context.Context, dbpool *pgxpool.Pool, site backupSite) doctorCheck {
+	check := doctorCheck{Check: "postgresTables", Schema: site.Schema} //nolint:exhaustruct
+
+	rows, err := dbpool.Query(ctx, `SELECT table_name FROM information_schema.tables WHERE table_schema=$1`, site.Schema)
+	if err != nil {
+		return check.result("", internal.WithPgxError(err))
+	}
+	tables := []string{}
+	for rows.Next() {
+		var table string
+		err = rows.Scan(&table)
+		if err != nil {
+			rows.Close()
+			return check.result("", internal.WithPgxError(err))
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return check.result("", internal.WithPgxError(err))
+	}
+
+	if len(tables) == 0 {
+		// Tables are created when PeerDB starts or when it is populated.
+		check.Status = DoctorSkip
+		check.Message = "schema is not initialized"
+		return check
+	}
+
+	missing := []string{}
+	for _, table := range doctorTables {
+		if !slices.Contains(tables, table) {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		return check.result("", errors.Errorf("missing tables: %s", strings.Join(missing, ", ")))
+	}
+	return check.result("all tables exist", nil)
+}
+
+// checkLLM checks that the LLM provider accepts the API key by listing models at url.
+func (c *DoctorCommand) checkLLM(ctx context.Context, httpClient *http.Client, url string) doctorCheck {
+	check := doctorCheck{Check: "llm"} //nolint:exhaustruct
+
+	if c.LLMAPIKeyFile != "" {
+		errE := loadLLMAPIKey(c.LLMAPIKeyFile)
+		if errE != nil {
+			return check.result("", errE)
+		}
+	}
+	key := os.Getenv("ANTHROPIC_API_KEY")
+	if key == "" {
+		check.Status = DoctorSkip
+		check.Message = "API key is not configured"
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, doctorLLMTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return check.result("", errors.WithStack(err))
+	}
+	req.Header.Set("X-Api-Key", key)
+	req.Header.Set("Anthropic-Version", "2023-06-01")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return check.result("", errors.WithStack(err))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return check.result("", errors.Errorf("API key rejected with status %d", resp.StatusCode))
+	}
+	return check.result("API key accepted", nil)
+}
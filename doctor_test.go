@@ -0,0 +1,38 @@
+package peerdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//nolint:paralleltest
+func TestDoctorCheckLLM(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Api-Key") != "valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "2023-06-01", req.Header.Get("Anthropic-Version"))
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	command := &DoctorCommand{} //nolint:exhaustruct
+
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	check := command.checkLLM(context.Background(), server.Client(), server.URL)
+	assert.Equal(t, DoctorSkip, check.Status)
+
+	t.Setenv("ANTHROPIC_API_KEY", "valid")
+	check = command.checkLLM(context.Background(), server.Client(), server.URL)
+	assert.Equal(t, DoctorPass, check.Status)
+
+	t.Setenv("ANTHROPIC_API_KEY", "invalid")
+	check = command.checkLLM(context.Background(), server.Client(), server.URL)
+	assert.Equal(t, DoctorFail, check.Status)
+	assert.Equal(t, "API key rejected with status 401", check.Message)
+}
@@ -15,6 +15,7 @@ import (
 
 	"gitlab.com/peerdb/peerdb/coordinator"
 	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
 	internal "gitlab.com/peerdb/peerdb/internal/store"
 	"gitlab.com/peerdb/peerdb/internal/types"
 	"gitlab.com/peerdb/peerdb/search"
@@ -38,7 +39,7 @@ func (s *Service) DocumentGet(w http.ResponseWriter, req *http.Request, params w
 	// We validate the "s" parameter.
 	if req.Form.Has("s") {
 		m := metrics.Duration(internal.MetricSearchState).Start()
-		sh := search.GetState(req.Form.Get("s"))
+		sh := search.GetState(req.Context(), req.Form.Get("s"))
 		m.Stop()
 		if sh == nil {
 			// Something was not OK, so we redirect to the URL without "s".
@@ -65,6 +66,11 @@ func (s *Service) DocumentGet(w http.ResponseWriter, req *http.Request, params w
 		reqVersion = &v
 	}
 
+	// Versions are of the document itself, so we redirect only when requesting the latest version.
+	if reqVersion == nil && s.redirectToCanonical(w, req, id, false) {
+		return
+	}
+
 	// TODO: If "s" is provided, should we validate that id is really part of search? Currently we do on the frontend.
 
 	site := waf.MustGetSite[*Site](req.Context())
@@ -87,6 +93,14 @@ func (s *Service) DocumentGet(w http.ResponseWriter, req *http.Request, params w
 		return
 	}
 
+	// We do not set Last-Modified header because the response is the HTML frontend
+	// which does not change with the document (and is cached using its etag).
+	errE = s.setCanonicalHeaders(w, site, id, nil)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
 	s.Home(w, req, nil)
 }
 
@@ -115,18 +129,34 @@ func (s *Service) DocumentGetGet(w http.ResponseWriter, req *http.Request, param
 		reqVersion = &v
 	}
 
+	var asOf *document.Timestamp
+	if req.Form.Has("asOf") {
+		t, errE := document.ParsePartialTimestamp(req.Form.Get("asOf")) //nolint:govet
+		if errE != nil {
+			s.BadRequestWithError(w, req, errors.WithMessage(errE, `"asOf" is not a valid timestamp`))
+			return
+		}
+		asOf = &t
+	}
+
+	// Versions are of the document itself, so we redirect only when requesting the latest version.
+	if reqVersion == nil && s.redirectToCanonical(w, req, id, true) {
+		return
+	}
+
 	site := waf.MustGetSite[*Site](req.Context())
 
 	var dataJSON json.RawMessage
+	var metadata *types.DocumentMetadata
 	var version store.Version
 
 	m := metrics.Duration(internal.MetricDatabase).Start()
 	// TODO: To support "omni" instances, allow getting across multiple schemas.
 	if reqVersion != nil {
 		version = *reqVersion
-		dataJSON, _, errE = site.store.Get(ctx, id, *reqVersion)
+		dataJSON, metadata, errE = site.store.Get(ctx, id, *reqVersion)
 	} else {
-		dataJSON, _, version, errE = site.store.GetLatest(ctx, id)
+		dataJSON, metadata, version, errE = site.store.GetLatest(ctx, id)
 	}
 	m.Stop()
 
@@ -138,14 +168,120 @@ func (s *Service) DocumentGetGet(w http.ResponseWriter, req *http.Request, param
 		return
 	}
 
+	if asOf != nil {
+		var valid bool
+		dataJSON, valid, errE = documentAsOfJSON(dataJSON, *asOf)
+		if errE != nil {
+			s.InternalServerErrorWithError(w, req, errE)
+			return
+		} else if !valid {
+			s.NotFoundWithError(w, req, errors.New("document not valid at the given time"))
+			return
+		}
+	}
+
+	dataJSON, errE = site.filterDocumentJSON(ctx, dataJSON)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	dataJSON, errE = site.redirectMap.rewriteRelationsJSON(dataJSON)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	errE = s.recordDocumentView(req, dataJSON)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
 	w.Header().Set("Version", version.String())
 
-	// TODO: Requesting with version should be cached long, while without version it should be no-cache.
-	w.Header().Set("Cache-Control", "max-age=604800")
+	m = metrics.Duration(internal.MetricDatabase).Start()
+	references, errE := site.references.Count(ctx, id)
+	m.Stop()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+	// Number of other documents with relation claims pointing to the document.
+	w.Header().Set("Referenced-By", strconv.FormatInt(references, 10))
+
+	var lastModified *time.Time
+	if metadata != nil {
+		t := time.Time(metadata.At)
+		lastModified = &t
+	}
+	errE = s.setCanonicalHeaders(w, site, id, lastModified)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	// Even a version of the document is not immutable: the Referenced-By header and relations
+	// rewritten to follow redirects change with other documents, so responses are always revalidated.
+	w.Header().Set("Cache-Control", "no-cache")
+	if len(site.RestrictedProperties) > 0 {
+		// Response depends on the role of the caller.
+		w.Header().Add("Vary", "Authorization")
+	}
 
 	s.WriteJSON(w, req, dataJSON, nil)
 }
 
+// documentAsOfJSON removes claims of the document not valid at the given time.
+// It returns false if the document itself is not valid at the given time.
+func documentAsOfJSON(data json.RawMessage, at document.Timestamp) (json.RawMessage, bool, errors.E) {
+	var doc document.D
+	errE := x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return nil, false, errE
+	}
+	if !doc.AsOf(at) {
+		return nil, false, nil
+	}
+	data, errE = x.MarshalWithoutEscapeHTML(doc)
+	if errE != nil {
+		return nil, false, errE
+	}
+	return data, true, nil
+}
+
+// DocumentIncomingGet is a GET/HEAD HTTP request handler which returns IDs of documents
+// which have a relation claim pointing to the document given its ID as a parameter.
+// Optional "property" parameter limits relation claims to those with the given property.
+func (s *Service) DocumentIncomingGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"id" is not a valid identifier`))
+		return
+	}
+
+	var prop *identifier.Identifier
+	if req.Form.Has("property") {
+		p, errE := identifier.FromString(req.Form.Get("property")) //nolint:govet
+		if errE != nil {
+			s.BadRequestWithError(w, req, errors.WithMessage(errE, `"property" is not a valid identifier`))
+			return
+		}
+		prop = &p
+	}
+
+	// Relations pointing to documents redirected to this document are incoming relations, too.
+	ids := append([]identifier.Identifier{id}, waf.MustGetSite[*Site](req.Context()).redirectMap.sources(id)...)
+
+	data, metadata, errE := search.IncomingGet(req.Context(), s.getSearchServiceClosure(req), ids, prop)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, data, metadata)
+}
+
 type documentCreateResponse struct {
 	ID identifier.Identifier `json:"id"`
 }
@@ -156,10 +292,11 @@ func (s *Service) DocumentCreatePost(w http.ResponseWriter, req *http.Request, _
 
 	ctx := req.Context()
 
-	var ea emptyRequest
-	errE := x.DecodeJSONWithoutUnknownFields(req.Body, &ea)
-	if errE != nil {
-		s.BadRequestWithError(w, req, errE)
+	if !s.validateEmptyRequest(w, req) {
+		return
+	}
+
+	if !s.checkQuota(w, req) {
 		return
 	}
 
@@ -206,10 +343,11 @@ func (s *Service) DocumentBeginEditPost(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	var ea emptyRequest
-	errE = x.DecodeJSONWithoutUnknownFields(req.Body, &ea)
-	if errE != nil {
-		s.BadRequestWithError(w, req, errE)
+	if !s.validateEmptyRequest(w, req) {
+		return
+	}
+
+	if !s.checkQuota(w, req) {
 		return
 	}
 
@@ -279,7 +417,10 @@ func (s *Service) DocumentSaveChangePost(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	// TODO: Validate the change.
+	if !s.validateJSON(w, req, "change", buffer) {
+		return
+	}
+
 	_, errE = document.ChangeUnmarshalJSON(buffer)
 	if errE != nil {
 		s.BadRequestWithError(w, req, errE)
@@ -300,7 +441,7 @@ func (s *Service) DocumentSaveChangePost(w http.ResponseWriter, req *http.Reques
 		s.NotFoundWithError(w, req, errE)
 		return
 	} else if errors.Is(errE, coordinator.ErrConflict) {
-		waf.Error(w, req, http.StatusConflict)
+		s.replyWithError(w, req, http.StatusConflict, errE)
 		return
 	} else if errE != nil {
 		s.InternalServerErrorWithError(w, req, errE)
@@ -396,15 +537,31 @@ func (s *Service) documentEndEdit(w http.ResponseWriter, req *http.Request, para
 		return
 	}
 
-	var ea emptyRequest
-	errE = x.DecodeJSONWithoutUnknownFields(req.Body, &ea)
-	if errE != nil {
-		s.BadRequestWithError(w, req, errE)
+	if !s.validateEmptyRequest(w, req) {
 		return
 	}
 
 	site := waf.MustGetSite[*Site](ctx)
 
+	if !discard {
+		_, dataJSON, changes, errE := es.DocumentSession(ctx, site.store, site.coordinator, session)
+		if errors.Is(errE, coordinator.ErrSessionNotFound) {
+			s.NotFoundWithError(w, req, errE)
+			return
+		} else if errE != nil {
+			s.InternalServerErrorWithError(w, req, errE)
+			return
+		}
+		errE = site.checkRestrictedChanges(ctx, dataJSON, changes)
+		if errors.Is(errE, search.ErrRestricted) {
+			s.replyWithError(w, req, http.StatusForbidden, errE)
+			return
+		} else if errE != nil {
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
+	}
+
 	metadata := &types.DocumentEndMetadata{
 		At:        types.Time(time.Now().UTC()),
 		Discarded: discard,
@@ -434,6 +591,137 @@ func (s *Service) documentEndEdit(w http.ResponseWriter, req *http.Request, para
 	}, nil)
 }
 
+type documentUpdateRequest struct {
+	Version store.Version     `json:"version"`
+	Changes []json.RawMessage `json:"changes"`
+}
+
+type documentUpdateResponse struct {
+	Version store.Version `json:"version"`
+}
+
+// DocumentUpdatePatch is a PATCH HTTP request handler which applies changes to the document
+// given its ID as a parameter, without an edit session.
+//
+// The request has to provide the version of the document the changes are based on. If the document
+// has been changed since then, it replies with the 409 (conflict) HTTP code. Changes are recorded
+// in the changeset of the new version. If the changed document has claims which do not match data
+// types of their properties (claim types, units, and bounds), it replies with the 400 (bad request) HTTP code.
+func (s *Service) DocumentUpdatePatch(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	ctx := req.Context()
+
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	if !s.validateJSON(w, req, "documentUpdateRequest", buffer) {
+		return
+	}
+
+	var payload documentUpdateRequest
+	errE = x.UnmarshalWithoutUnknownFields(buffer, &payload)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	changes := make(document.Changes, 0, len(payload.Changes))
+	for i, data := range payload.Changes {
+		change, errE := document.ChangeUnmarshalJSON(data) //nolint:govet
+		if errE != nil {
+			errors.Details(errE)["change"] = i
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
+		changes = append(changes, change)
+	}
+
+	if !s.checkQuota(w, req) {
+		return
+	}
+
+	site := waf.MustGetSite[*Site](ctx)
+
+	dataJSON, _, version, errE := site.store.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueNotFound) {
+		s.NotFoundWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	if version != payload.Version {
+		errE = errors.WithStack(store.ErrConflict)
+		errors.Details(errE)["version"] = payload.Version.String()
+		errors.Details(errE)["latest"] = version.String()
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	}
+
+	errE = site.checkRestrictedChanges(ctx, dataJSON, changes)
+	if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
+	} else if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(dataJSON, &doc)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	errE = changes.Apply(&doc)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	errE = site.properties().registry.Validate(&doc)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	// Elements of lists are stored in order.
+	doc.SortLists()
+
+	dataJSON, errE = x.MarshalWithoutEscapeHTML(doc)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	version, errE = site.store.Update(ctx, id, version.Changeset, dataJSON, changes, &types.DocumentMetadata{
+		At: types.Time(time.Now().UTC()),
+	}, &types.NoMetadata{})
+	if errors.Is(errE, store.ErrConflict) || errors.Is(errE, store.ErrParentInvalid) {
+		// The document has been changed concurrently.
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, documentUpdateResponse{Version: version}, nil)
+}
+
 func (s *Service) DocumentEdit(w http.ResponseWriter, req *http.Request, params waf.Params) {
 	ctx := req.Context()
 
@@ -0,0 +1,98 @@
+package document
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// CanonicalJSON returns a stable JSON serialization of the document.
+//
+// Object keys are sorted, HTML characters are not escaped, and claims
+// (including meta claims) are sorted by their IDs, so that documents with
+// the same claims serialize the same regardless of the order in which
+// claims have been added.
+func CanonicalJSON(d *D) ([]byte, errors.E) {
+	return canonicalJSON(d)
+}
+
+// Hash returns a SHA-256 hash of the canonical JSON serialization of the document,
+// encoded with URL-safe base64 without padding.
+//
+// Documents with equal hashes have equal content, so the hash can be used
+// for change detection, ETags, and deduplication.
+func Hash(d *D) (string, errors.E) {
+	data, errE := CanonicalJSON(d)
+	if errE != nil {
+		return "", errE
+	}
+	hash := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+func canonicalJSON(v interface{}) ([]byte, errors.E) {
+	data, errE := x.MarshalWithoutEscapeHTML(v)
+	if errE != nil {
+		return nil, errE
+	}
+
+	// We decode into generic values so that we can sort claims. We use json.Number
+	// so that numbers are re-encoded exactly as they were encoded.
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	value = canonicalizeValue(value, false)
+
+	// Maps are encoded with sorted keys.
+	return x.MarshalWithoutEscapeHTML(value)
+}
+
+// canonicalizeValue sorts claims in claim types objects (values of "claims" and "meta" fields).
+func canonicalizeValue(value interface{}, claimTypes bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			if claimTypes {
+				claims, ok := val.([]interface{})
+				if ok {
+					for i, claim := range claims {
+						claims[i] = canonicalizeValue(claim, false)
+					}
+					slices.SortStableFunc(claims, func(a, b interface{}) int {
+						return strings.Compare(claimID(a), claimID(b))
+					})
+					continue
+				}
+			}
+			v[key] = canonicalizeValue(val, key == "claims" || key == "meta")
+		}
+		return v
+	case []interface{}:
+		for i, val := range v {
+			v[i] = canonicalizeValue(val, false)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func claimID(claim interface{}) string {
+	c, ok := claim.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	id, _ := c["id"].(string)
+	return id
+}
@@ -0,0 +1,79 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	t.Parallel()
+
+	docID := identifier.MustFromString("CAfaL1ZZs6L4uyFdrJZ2wN")
+	prop := identifier.MustFromString("5SoFeEFk5aWXUYFC1EZFec")
+	claim1 := identifier.MustFromString("3EL2nZdWVbw85XG1zTH2o5")
+	claim2 := identifier.MustFromString("MpGZyd7grTBPYhMhETAuHV")
+	meta1 := identifier.MustFromString("Hx7j5vh8pW3NpCaEQuw7eo")
+	meta2 := identifier.MustFromString("9R1vqF4tyqpRUyKNqnZhVo")
+
+	newDoc := func(claimIDs, metaIDs []identifier.Identifier) *document.D {
+		doc := &document.D{
+			CoreDocument: document.CoreDocument{ID: docID, Score: document.LowConfidence},
+		}
+		for _, id := range claimIDs {
+			claim := &document.TextClaim{
+				CoreClaim: document.CoreClaim{ID: id, Confidence: document.HighConfidence},
+				Prop:      document.Reference{ID: &prop},
+				HTML:      document.TranslatableHTMLString{"en": "<b>" + id.String() + "</b>", "de": "x"},
+			}
+			for _, metaID := range metaIDs {
+				require.NoError(t, claim.Add(&document.AmountClaim{
+					CoreClaim: document.CoreClaim{ID: metaID, Confidence: document.MediumConfidence},
+					Prop:      document.Reference{ID: &prop},
+					Amount:    0.1,
+					Unit:      document.AmountUnitMetre,
+				}))
+			}
+			require.NoError(t, doc.Add(claim))
+		}
+		return doc
+	}
+
+	a := newDoc([]identifier.Identifier{claim1, claim2}, []identifier.Identifier{meta1, meta2})
+	b := newDoc([]identifier.Identifier{claim2, claim1}, []identifier.Identifier{meta2, meta1})
+
+	aJSON, errE := document.CanonicalJSON(a)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	bJSON, errE := document.CanonicalJSON(b)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, string(aJSON), string(bJSON))
+	assert.Equal(
+		t,
+		`{"claims":{"text":[`+
+			`{"confidence":1,"html":{"de":"x","en":"<b>3EL2nZdWVbw85XG1zTH2o5</b>"},"id":"3EL2nZdWVbw85XG1zTH2o5","meta":{"amount":[`+
+			`{"amount":0.1,"confidence":0.75,"id":"9R1vqF4tyqpRUyKNqnZhVo","prop":{"id":"5SoFeEFk5aWXUYFC1EZFec"},"unit":"m"},`+
+			`{"amount":0.1,"confidence":0.75,"id":"Hx7j5vh8pW3NpCaEQuw7eo","prop":{"id":"5SoFeEFk5aWXUYFC1EZFec"},"unit":"m"}`+
+			`]},"prop":{"id":"5SoFeEFk5aWXUYFC1EZFec"}},`+
+			`{"confidence":1,"html":{"de":"x","en":"<b>MpGZyd7grTBPYhMhETAuHV</b>"},"id":"MpGZyd7grTBPYhMhETAuHV","meta":{"amount":[`+
+			`{"amount":0.1,"confidence":0.75,"id":"9R1vqF4tyqpRUyKNqnZhVo","prop":{"id":"5SoFeEFk5aWXUYFC1EZFec"},"unit":"m"},`+
+			`{"amount":0.1,"confidence":0.75,"id":"Hx7j5vh8pW3NpCaEQuw7eo","prop":{"id":"5SoFeEFk5aWXUYFC1EZFec"},"unit":"m"}`+
+			`]},"prop":{"id":"5SoFeEFk5aWXUYFC1EZFec"}}`+
+			`]},"id":"CAfaL1ZZs6L4uyFdrJZ2wN","score":0.5}`,
+		string(aJSON),
+	)
+
+	aHash, errE := document.Hash(a)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	bHash, errE := document.Hash(b)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, aHash, bHash)
+
+	c := newDoc([]identifier.Identifier{claim1}, nil)
+	cHash, errE := document.Hash(c)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.NotEqual(t, aHash, cHash)
+}
@@ -0,0 +1,79 @@
+package document
+
+import (
+	"slices"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+var ErrTooManyClaims = errors.Base("too many claims")
+
+// SingleValueProperties is a set of properties which are declared to have a single value:
+// at most one claim with the property per document (and per meta claims of a claim).
+//
+// A property is declared to have a single value by a TYPE relation claim of its property
+// document pointing to the SINGLE_VALUE core property. Properties without it can have
+// multiple values.
+type SingleValueProperties map[identifier.Identifier]bool
+
+// NewSingleValueProperties returns a set populated from the given property documents.
+func NewSingleValueProperties(properties map[identifier.Identifier]D) SingleValueProperties {
+	s := SingleValueProperties{}
+	for _, property := range properties {
+		s.Add(&property)
+	}
+	return s
+}
+
+// Add adds the property document to the set if it declares a single value.
+func (s SingleValueProperties) Add(property *D) {
+	singleValue := GetCorePropertyID("SINGLE_VALUE")
+	for _, claim := range property.Get(GetCorePropertyID("TYPE")) {
+		relation, ok := claim.(*RelationClaim)
+		if ok && relation.To.ID != nil && *relation.To.ID == singleValue {
+			s[property.ID] = true
+			return
+		}
+	}
+}
+
+// Check checks that the document (and every claim's meta claims) has at most one claim
+// for every property in the set. Claims with negation or no confidence do not count
+// because they do not assert a value.
+func (s SingleValueProperties) Check(doc *D) errors.E {
+	// We iterate in a deterministic order so that the same violation is always reported first.
+	props := make([]identifier.Identifier, 0, len(s))
+	for prop := range s {
+		props = append(props, prop)
+	}
+	slices.SortFunc(props, func(a, b identifier.Identifier) int {
+		return slices.Compare(a[:], b[:])
+	})
+	return checkSingleValue(doc, props)
+}
+
+func checkSingleValue(container ClaimsContainer, props []identifier.Identifier) errors.E {
+	for _, prop := range props {
+		claims := []string{}
+		for _, claim := range container.Get(prop) {
+			if claim.GetConfidence() > 0 {
+				claims = append(claims, claim.GetID().String())
+			}
+		}
+		if len(claims) > 1 {
+			errE := errors.WithStack(ErrTooManyClaims)
+			errors.Details(errE)["container"] = container.GetID().String()
+			errors.Details(errE)["prop"] = prop.String()
+			errors.Details(errE)["claims"] = claims
+			return errE
+		}
+	}
+	for _, claim := range container.AllClaims() {
+		errE := checkSingleValue(claim, props)
+		if errE != nil {
+			return errE
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestSingleValueProperties(t *testing.T) {
+	t.Parallel()
+
+	singleValue := document.NewSingleValueProperties(document.CoreProperties)
+	assert.True(t, singleValue[document.GetCorePropertyID("ORDER")])
+	assert.False(t, singleValue[document.GetCorePropertyID("NAME")])
+
+	for _, property := range document.CoreProperties {
+		errE := singleValue.Check(&property)
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	doc := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+	amount := func(confidence document.Confidence) *document.AmountClaim {
+		return &document.AmountClaim{
+			CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: confidence}, //nolint:exhaustruct
+			Prop:      document.GetCorePropertyReference("ORDER"),
+			Amount:    1,
+			Unit:      document.AmountUnitNone,
+		}
+	}
+
+	// Multiple claims for a property which can have multiple values.
+	require.NoError(t, doc.Add(stringClaim("NAME", "a")))
+	require.NoError(t, doc.Add(stringClaim("NAME", "b")))
+	require.NoError(t, doc.Add(amount(document.HighConfidence)))
+	// Negations do not count.
+	require.NoError(t, doc.Add(amount(document.HighNegationConfidence)))
+	errE := singleValue.Check(doc)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	// Meta claims are checked, too.
+	name := doc.Claims.String[0].ID
+	require.NoError(t, doc.GetByID(name).Add(amount(document.HighConfidence)))
+	errE = singleValue.Check(doc)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+	require.NoError(t, doc.GetByID(name).Add(amount(document.MediumConfidence)))
+	errE = singleValue.Check(doc)
+	assert.ErrorIs(t, errE, document.ErrTooManyClaims)
+
+	doc.GetByID(name).Remove(document.GetCorePropertyID("ORDER"))
+	require.NoError(t, doc.Add(amount(document.LowConfidence)))
+	errE = singleValue.Check(doc)
+	assert.ErrorIs(t, errE, document.ErrTooManyClaims)
+}
@@ -0,0 +1,187 @@
+package document
+
+import (
+	"strconv"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+// Types of integrity problems found by Check.
+const (
+	// ProblemDanglingRelation is a relation claim pointing to a document which does not exist
+	// or which is not pointing to any document (e.g., an unresolved temporary reference).
+	ProblemDanglingRelation = "danglingRelation"
+	// ProblemUnknownProperty is a claim with a property which does not exist or
+	// without a property ID (e.g., an unresolved temporary reference).
+	ProblemUnknownProperty = "unknownProperty"
+	// ProblemInvalidUnit is an amount or amount range claim with an unknown unit.
+	ProblemInvalidUnit = "invalidUnit"
+	// ProblemMissingID is a claim without an ID.
+	ProblemMissingID = "missingID"
+	// ProblemDuplicateClaimID is a claim with the same ID as another claim of the document.
+	ProblemDuplicateClaimID = "duplicateClaimID"
+)
+
+// Problem is an integrity problem of a claim.
+type Problem struct {
+	Type  string                `json:"type"`
+	Claim identifier.Identifier `json:"claim"`
+	// Parent is the claim this claim is a meta claim of, if it is a meta claim.
+	Parent *identifier.Identifier `json:"parent,omitempty"`
+	Prop   *identifier.Identifier `json:"prop,omitempty"`
+	To     *identifier.Identifier `json:"to,omitempty"`
+	Unit   string                 `json:"unit,omitempty"`
+}
+
+// Check returns integrity problems of all claims (including meta claims) of the document.
+//
+// exists should report whether a document with the given ID exists. It is used to check
+// properties of claims and documents relation claims point to.
+func Check(doc *D, exists func(id identifier.Identifier) (bool, errors.E)) ([]Problem, errors.E) {
+	v := checkVisitor{
+		exists:   exists,
+		parent:   nil,
+		seen:     map[identifier.Identifier]bool{},
+		problems: []Problem{},
+	}
+	errE := doc.Visit(&v)
+	if errE != nil {
+		return nil, errE
+	}
+	return v.problems, nil
+}
+
+type checkVisitor struct {
+	exists func(id identifier.Identifier) (bool, errors.E)
+	parent *identifier.Identifier
+	seen   map[identifier.Identifier]bool
+
+	problems []Problem
+}
+
+var _ Visitor = (*checkVisitor)(nil)
+
+func (v *checkVisitor) problem(claim Claim, problemType string) *Problem {
+	v.problems = append(v.problems, Problem{
+		Type:   problemType,
+		Claim:  claim.GetID(),
+		Parent: v.parent,
+		Prop:   nil,
+		To:     nil,
+		Unit:   "",
+	})
+	return &v.problems[len(v.problems)-1]
+}
+
+// reference checks that the reference has an ID of an existing document and
+// records a problem of problemType otherwise.
+func (v *checkVisitor) reference(claim Claim, ref Reference, problemType string) (*Problem, errors.E) {
+	if ref.ID == nil {
+		return v.problem(claim, problemType), nil
+	}
+	ok, errE := v.exists(*ref.ID)
+	if errE != nil {
+		errors.Details(errE)["claim"] = claim.GetID().String()
+		return nil, errE
+	}
+	if !ok {
+		return v.problem(claim, problemType), nil
+	}
+	return nil, nil //nolint:nilnil
+}
+
+func (v *checkVisitor) unit(claim Claim, prop Reference, unit AmountUnit) {
+	if unit < 0 || unit >= AmountUnitsTotal {
+		p := v.problem(claim, ProblemInvalidUnit)
+		p.Prop = prop.ID
+		p.Unit = strconv.Itoa(int(unit))
+	}
+}
+
+func (v *checkVisitor) check(claim Claim, prop Reference) (VisitResult, errors.E) {
+	id := claim.GetID()
+	if id == (identifier.Identifier{}) {
+		v.problem(claim, ProblemMissingID)
+	} else if v.seen[id] {
+		v.problem(claim, ProblemDuplicateClaimID)
+	} else {
+		v.seen[id] = true
+	}
+
+	p, errE := v.reference(claim, prop, ProblemUnknownProperty)
+	if errE != nil {
+		return Keep, errE
+	}
+	if p != nil {
+		p.Prop = prop.ID
+	}
+
+	// We check meta claims with this claim as their parent.
+	parent := v.parent
+	v.parent = &id
+	errE = claim.Visit(v)
+	v.parent = parent
+	if errE != nil {
+		return Keep, errE
+	}
+	return Keep, nil
+}
+
+func (v *checkVisitor) VisitIdentifier(claim *IdentifierClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitReference(claim *ReferenceClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitText(claim *TextClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitString(claim *StringClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitAmount(claim *AmountClaim) (VisitResult, errors.E) {
+	v.unit(claim, claim.Prop, claim.Unit)
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitAmountRange(claim *AmountRangeClaim) (VisitResult, errors.E) {
+	v.unit(claim, claim.Prop, claim.Unit)
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitRelation(claim *RelationClaim) (VisitResult, errors.E) {
+	p, errE := v.reference(claim, claim.To, ProblemDanglingRelation)
+	if errE != nil {
+		return Keep, errE
+	}
+	if p != nil {
+		p.Prop = claim.Prop.ID
+		p.To = claim.To.ID
+	}
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitFile(claim *FileClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitNoValue(claim *NoValueClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitUnknownValue(claim *UnknownValueClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitTime(claim *TimeClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
+
+func (v *checkVisitor) VisitTimeRange(claim *TimeRangeClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop)
+}
@@ -0,0 +1,112 @@
+package document_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	unknownProp := identifier.New()
+	existing := identifier.New()
+	missing := identifier.New()
+
+	exists := func(id identifier.Identifier) (bool, errors.E) {
+		return id == prop || id == existing, nil
+	}
+
+	validRelation := &document.RelationClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &prop},                                                 //nolint:exhaustruct
+		To:        document.Reference{ID: &existing},                                             //nolint:exhaustruct
+	}
+	danglingRelation := &document.RelationClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &prop},                                                 //nolint:exhaustruct
+		To:        document.Reference{ID: &missing},                                              //nolint:exhaustruct
+	}
+	unknownProperty := &document.StringClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &unknownProp},                                          //nolint:exhaustruct
+		String:    "foo",
+	}
+	invalidUnit := &document.AmountClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &prop},                                                 //nolint:exhaustruct
+		Amount:    1,
+		Unit:      document.AmountUnitsTotal,
+	}
+	unresolved := &document.RelationClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &prop},                                                 //nolint:exhaustruct
+		To:        document.Reference{ID: nil, Temporary: []string{"foo"}},
+	}
+
+	// Meta claims have to be added before claims are added to the document, because claims are copied.
+	errE := validRelation.Add(danglingRelation)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	doc := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+	for _, claim := range []document.Claim{validRelation, unknownProperty, invalidUnit, unresolved} {
+		errE = doc.Add(claim)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	problems, errE := document.Check(doc, exists)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	validRelationID := validRelation.ID
+	assert.ElementsMatch(t, []document.Problem{
+		{Type: document.ProblemDanglingRelation, Claim: danglingRelation.ID, Parent: &validRelationID, Prop: &prop, To: &missing, Unit: ""},
+		{Type: document.ProblemUnknownProperty, Claim: unknownProperty.ID, Parent: nil, Prop: &unknownProp, To: nil, Unit: ""},
+		{Type: document.ProblemInvalidUnit, Claim: invalidUnit.ID, Parent: nil, Prop: &prop, To: nil, Unit: "25"},
+		{Type: document.ProblemDanglingRelation, Claim: unresolved.ID, Parent: nil, Prop: &prop, To: nil, Unit: ""},
+	}, problems)
+}
+
+func TestCheckDuplicateClaimID(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	exists := func(_ identifier.Identifier) (bool, errors.E) {
+		return true, nil
+	}
+
+	id := identifier.New()
+	doc := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+		Claims: &document.ClaimTypes{ //nolint:exhaustruct
+			String: document.StringClaims{
+				{CoreClaim: document.CoreClaim{ID: id, Confidence: document.HighConfidence}, Prop: document.Reference{ID: &prop}, String: "foo"}, //nolint:exhaustruct
+				{CoreClaim: document.CoreClaim{ID: id, Confidence: document.HighConfidence}, Prop: document.Reference{ID: &prop}, String: "bar"}, //nolint:exhaustruct
+				{CoreClaim: document.CoreClaim{Confidence: document.HighConfidence}, Prop: document.Reference{ID: &prop}, String: "baz"},         //nolint:exhaustruct
+			},
+		},
+	}
+
+	problems, errE := document.Check(doc, exists)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []document.Problem{
+		{Type: document.ProblemDuplicateClaimID, Claim: id, Parent: nil, Prop: nil, To: nil, Unit: ""},
+		{Type: document.ProblemMissingID, Claim: identifier.Identifier{}, Parent: nil, Prop: nil, To: nil, Unit: ""},
+	}, problems)
+}
+
+func TestUnknownAmountUnit(t *testing.T) {
+	t.Parallel()
+
+	var unit document.AmountUnit
+	err := json.Unmarshal([]byte(`"foo"`), &unit)
+	assert.ErrorIs(t, err, document.ErrInvalidUnit)
+}
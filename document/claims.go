@@ -3,6 +3,8 @@ package document
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/big"
 	"regexp"
 	"strconv"
 	"time"
@@ -364,121 +366,152 @@ const (
 	AmountUnitByte
 	AmountUnitPixel
 	AmountUnitSecond
+	AmountUnitEuro
+	AmountUnitPound
+	AmountUnitYen
 
 	// Count of the number of possible values.
 	AmountUnitsTotal
 )
 
-func (u AmountUnit) MarshalJSON() ([]byte, error) {
-	buffer := bytes.NewBufferString(`"`)
+// String returns the symbol of the amount unit.
+func (u AmountUnit) String() string {
 	switch u {
 	case AmountUnitCustom:
-		buffer.WriteString("@")
+		return "@"
 	case AmountUnitNone:
-		buffer.WriteString("1")
+		return "1"
 	case AmountUnitRatio:
-		buffer.WriteString("/")
+		return "/"
 	case AmountUnitLitre:
-		buffer.WriteString("l")
+		return "l"
 	case AmountUnitKilogramPerKilogram:
-		buffer.WriteString("kg/kg")
+		return "kg/kg"
 	case AmountUnitKilogram:
-		buffer.WriteString("kg")
+		return "kg"
 	case AmountUnitKilogramPerCubicMetre:
-		buffer.WriteString("kg/m³")
+		return "kg/m³"
 	case AmountUnitMetre:
-		buffer.WriteString("m")
+		return "m"
 	case AmountUnitSquareMetre:
-		buffer.WriteString("m²")
+		return "m²"
 	case AmountUnitMetrePerSecond:
-		buffer.WriteString("m/s")
+		return "m/s"
 	case AmountUnitVolt:
-		buffer.WriteString("V")
+		return "V"
 	case AmountUnitWatt:
-		buffer.WriteString("W")
+		return "W"
 	case AmountUnitPascal:
-		buffer.WriteString("Pa")
+		return "Pa"
 	case AmountUnitCoulomb:
-		buffer.WriteString("C")
+		return "C"
 	case AmountUnitJoule:
-		buffer.WriteString("J")
+		return "J"
 	case AmountUnitCelsius:
-		buffer.WriteString("°C")
+		return "°C"
 	case AmountUnitRadian:
-		buffer.WriteString("rad")
+		return "rad"
 	case AmountUnitHertz:
-		buffer.WriteString("Hz")
+		return "Hz"
 	case AmountUnitDollar:
-		buffer.WriteString("$")
+		return "$"
 	case AmountUnitByte:
-		buffer.WriteString("B")
+		return "B"
 	case AmountUnitPixel:
-		buffer.WriteString("px")
+		return "px"
 	case AmountUnitSecond:
-		buffer.WriteString("s")
+		return "s"
+	case AmountUnitEuro:
+		return "€"
+	case AmountUnitPound:
+		return "£"
+	case AmountUnitYen:
+		return "¥"
 	case AmountUnitsTotal:
 		fallthrough
 	default:
 		panic(errors.Errorf("invalid AmountUnit value: %d", u))
 	}
+}
+
+func (u AmountUnit) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString(`"`)
+	buffer.WriteString(u.String())
 	buffer.WriteString(`"`)
 	return buffer.Bytes(), nil
 }
 
-func (u *AmountUnit) UnmarshalJSON(b []byte) error {
-	var s string
-	errE := x.UnmarshalWithoutUnknownFields(b, &s)
-	if errE != nil {
-		return errE
-	}
+// ParseAmountUnit returns the amount unit with the symbol.
+func ParseAmountUnit(s string) (AmountUnit, errors.E) {
 	switch s {
 	case "@":
-		*u = AmountUnitCustom
+		return AmountUnitCustom, nil
 	case "1":
-		*u = AmountUnitNone
+		return AmountUnitNone, nil
 	case "/":
-		*u = AmountUnitRatio
+		return AmountUnitRatio, nil
 	case "l":
-		*u = AmountUnitLitre
+		return AmountUnitLitre, nil
 	case "kg/kg":
-		*u = AmountUnitKilogramPerKilogram
+		return AmountUnitKilogramPerKilogram, nil
 	case "kg":
-		*u = AmountUnitKilogram
+		return AmountUnitKilogram, nil
 	case "kg/m³":
-		*u = AmountUnitKilogramPerCubicMetre
+		return AmountUnitKilogramPerCubicMetre, nil
 	case "m":
-		*u = AmountUnitMetre
+		return AmountUnitMetre, nil
 	case "m²":
-		*u = AmountUnitSquareMetre
+		return AmountUnitSquareMetre, nil
 	case "m/s":
-		*u = AmountUnitMetrePerSecond
+		return AmountUnitMetrePerSecond, nil
 	case "V":
-		*u = AmountUnitVolt
+		return AmountUnitVolt, nil
 	case "W":
-		*u = AmountUnitWatt
+		return AmountUnitWatt, nil
 	case "Pa":
-		*u = AmountUnitPascal
+		return AmountUnitPascal, nil
 	case "C":
-		*u = AmountUnitCoulomb
+		return AmountUnitCoulomb, nil
 	case "J":
-		*u = AmountUnitJoule
+		return AmountUnitJoule, nil
 	case "°C":
-		*u = AmountUnitCelsius
+		return AmountUnitCelsius, nil
 	case "rad":
-		*u = AmountUnitRadian
+		return AmountUnitRadian, nil
 	case "Hz":
-		*u = AmountUnitHertz
+		return AmountUnitHertz, nil
 	case "$":
-		*u = AmountUnitDollar
+		return AmountUnitDollar, nil
 	case "B":
-		*u = AmountUnitByte
+		return AmountUnitByte, nil
 	case "px":
-		*u = AmountUnitPixel
+		return AmountUnitPixel, nil
 	case "s":
-		*u = AmountUnitSecond
+		return AmountUnitSecond, nil
+	case "€":
+		return AmountUnitEuro, nil
+	case "£":
+		return AmountUnitPound, nil
+	case "¥":
+		return AmountUnitYen, nil
 	default:
-		return errors.Errorf("unknown amount unit: %s", s)
+		errE := errors.WithMessage(ErrInvalidUnit, "unknown amount unit")
+		errors.Details(errE)["unit"] = s
+		return 0, errE
+	}
+}
+
+func (u *AmountUnit) UnmarshalJSON(b []byte) error {
+	var s string
+	errE := x.UnmarshalWithoutUnknownFields(b, &s)
+	if errE != nil {
+		return errE
+	}
+	unit, errE := ParseAmountUnit(s)
+	if errE != nil {
+		return errE
 	}
+	*u = unit
 	return nil
 }
 
@@ -498,6 +531,47 @@ type AmountClaim struct {
 	Prop   Reference  `json:"prop"`
 	Amount float64    `json:"amount"`
 	Unit   AmountUnit `json:"unit"`
+
+	// Decimal is an optional exact decimal representation of the amount,
+	// used when the amount cannot be exactly represented by float64.
+	// Amount is then its closest float64 value.
+	Decimal string `exhaustruct:"optional" json:"decimal,omitempty"`
+}
+
+// DecimalAmount returns the closest float64 value to the rational number and,
+// if the float64 value is not exact, also its exact decimal representation.
+//
+// For rational numbers without a finite decimal representation, the decimal
+// representation is rounded to the precision of float64.
+func DecimalAmount(rat *big.Rat) (float64, string) {
+	amount, exact := rat.Float64()
+	if exact {
+		return amount, ""
+	}
+	n, finite := rat.FloatPrec()
+	if !finite {
+		// Rational number does not have a finite decimal representation.
+		return amount, strconv.FormatFloat(amount, 'f', -1, 64)
+	}
+	return amount, rat.FloatString(n)
+}
+
+// ParseDecimalAmount parses a decimal string into its closest float64 value and, if the float64 value
+// is not exact, also its normalized exact decimal representation.
+func ParseDecimalAmount(decimal string) (float64, string, errors.E) {
+	rat, ok := new(big.Rat).SetString(decimal)
+	if !ok {
+		errE := errors.New("invalid decimal")
+		errors.Details(errE)["decimal"] = decimal
+		return 0, "", errE
+	}
+	amount, d := DecimalAmount(rat)
+	if math.IsInf(amount, 0) {
+		errE := errors.New("decimal cannot be represented by float64")
+		errors.Details(errE)["decimal"] = decimal
+		return 0, "", errE
+	}
+	return amount, d, nil
 }
 
 type AmountRangeClaim struct {
@@ -523,6 +597,8 @@ type FileClaim struct {
 	MediaType string    `json:"mediaType"`
 	URL       string    `json:"url"`
 	Preview   []string  `json:"preview,omitempty"`
+	Blurhash  string    `json:"blurhash,omitempty"`
+	Checksum  string    `json:"checksum,omitempty"`
 }
 
 type NoValueClaim struct {
@@ -0,0 +1,267 @@
+package document
+
+import (
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+// Completeness computes completeness scores of documents, which help curators find
+// poorly-described documents.
+//
+// A type (a document to which TYPE relation claims point, e.g., ARTWORK) declares properties
+// which documents of the type are expected to have with EXPECTED_PROPERTY relation claims of
+// its document pointing to them (see DeclareExpectedProperties). A property is declared to
+// provide provenance of a claim (i.e., to cite its source) when used as a meta claim by a TYPE
+// relation claim of its property document pointing to the CITATION core property. Similarly,
+// claims of a property are declared to require a citation by a TYPE relation claim pointing
+// to the CITATION_REQUIRED core property.
+type Completeness struct {
+	// Expected maps types to properties documents of the type are expected to have.
+	Expected map[identifier.Identifier][]identifier.Identifier
+	// Citations is a set of properties which cite sources of claims.
+	Citations map[identifier.Identifier]bool
+	// CitationRequired is a set of properties whose claims require a citation.
+	CitationRequired map[identifier.Identifier]bool
+}
+
+// NewCompleteness returns completeness populated from the given property documents.
+func NewCompleteness(properties map[identifier.Identifier]D) *Completeness {
+	c := &Completeness{
+		Expected:         map[identifier.Identifier][]identifier.Identifier{},
+		Citations:        map[identifier.Identifier]bool{},
+		CitationRequired: map[identifier.Identifier]bool{},
+	}
+	for _, property := range properties {
+		c.Add(&property)
+	}
+	return c
+}
+
+// Add adds declarations of the property document.
+func (c *Completeness) Add(property *D) {
+	for _, claim := range property.Get(GetCorePropertyID("EXPECTED_PROPERTY")) {
+		relation, ok := claim.(*RelationClaim)
+		if ok && relation.To.ID != nil && relation.Confidence > 0 {
+			c.Expected[property.ID] = append(c.Expected[property.ID], *relation.To.ID)
+		}
+	}
+	for _, claim := range property.Get(GetCorePropertyID("TYPE")) {
+		relation, ok := claim.(*RelationClaim)
+		if !ok || relation.To.ID == nil {
+			continue
+		}
+		switch *relation.To.ID {
+		case GetCorePropertyID("CITATION"):
+			c.Citations[property.ID] = true
+		case GetCorePropertyID("CITATION_REQUIRED"):
+			c.CitationRequired[property.ID] = true
+		}
+	}
+}
+
+// Score returns the completeness score of the document, between 0 and 1.
+//
+// The score is the average of the share of properties expected for types of the document which
+// the document has claims for, and the share of claims requiring a citation which have one.
+// When the document has no expected properties, only the latter is used, and vice versa.
+// When it has neither, false is returned. Claims with negation or no confidence are not counted.
+func (c *Completeness) Score(doc *D) (float64, bool, errors.E) {
+	claims, errE := propClaims(doc)
+	if errE != nil {
+		return 0, false, errE
+	}
+
+	has := map[identifier.Identifier]bool{}
+	expected := map[identifier.Identifier]bool{}
+	required := 0
+	cited := 0
+	for _, claim := range claims {
+		if claim.claim.GetConfidence() <= 0 {
+			continue
+		}
+		has[claim.prop] = true
+		if relation, ok := claim.claim.(*RelationClaim); ok && claim.prop == GetCorePropertyID("TYPE") && relation.To.ID != nil {
+			for _, prop := range c.Expected[*relation.To.ID] {
+				expected[prop] = true
+			}
+		}
+		if c.CitationRequired[claim.prop] {
+			required++
+			hasCitation, errE := c.hasCitation(claim.claim)
+			if errE != nil {
+				return 0, false, errE
+			}
+			if hasCitation {
+				cited++
+			}
+		}
+	}
+
+	scores := []float64{}
+	if len(expected) > 0 {
+		covered := 0
+		for prop := range expected {
+			if has[prop] {
+				covered++
+			}
+		}
+		scores = append(scores, float64(covered)/float64(len(expected)))
+	}
+	if required > 0 {
+		scores = append(scores, float64(cited)/float64(required))
+	}
+	if len(scores) == 0 {
+		return 0, false, nil
+	}
+
+	sum := 0.0
+	for _, score := range scores {
+		sum += score
+	}
+	return sum / float64(len(scores)), true, nil
+}
+
+// hasCitation returns true if the claim has a meta claim citing its source.
+func (c *Completeness) hasCitation(claim Claim) (bool, errors.E) {
+	metaClaims, errE := propClaims(claim)
+	if errE != nil {
+		return false, errE
+	}
+	for _, meta := range metaClaims {
+		if meta.claim.GetConfidence() > 0 && c.Citations[meta.prop] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Set sets the COMPLETENESS amount claim of the document to its completeness score
+// (see Score), replacing any existing one. If the document has no score, the claim is removed.
+func (c *Completeness) Set(doc *D) errors.E {
+	doc.Remove(GetCorePropertyID("COMPLETENESS"))
+
+	score, ok, errE := c.Score(doc)
+	if errE != nil {
+		errors.Details(errE)["doc"] = doc.ID.String()
+		return errE
+	}
+	if !ok {
+		return nil
+	}
+
+	return doc.Add(&AmountClaim{
+		CoreClaim: CoreClaim{
+			ID:         GetID(nameSpaceCoreProperties, doc.ID, "COMPLETENESS"),
+			Confidence: HighConfidence,
+		},
+		Prop:   GetCorePropertyReference("COMPLETENESS"),
+		Amount: score,
+		Unit:   AmountUnitRatio,
+	})
+}
+
+// DeclareExpectedProperties declares that documents of the type (a core property, e.g., "ARTWORK")
+// are expected to have claims with the properties, by adding EXPECTED_PROPERTY relation
+// claims to the core property document of the type. Mnemonics can be namespaced.
+func DeclareExpectedProperties(typeMnemonic string, propertyMnemonics ...string) {
+	typeID, errE := ResolveMnemonic(typeMnemonic)
+	if errE != nil {
+		panic(errE)
+	}
+	for _, propertyMnemonic := range propertyMnemonics {
+		propertyID, errE := ResolveMnemonic(propertyMnemonic)
+		if errE != nil {
+			panic(errE)
+		}
+		CoreProperties[typeID].Claims.Relation = append(CoreProperties[typeID].Claims.Relation, RelationClaim{
+			CoreClaim: CoreClaim{
+				ID:         getPropertyClaimID(typeMnemonic, "EXPECTED_PROPERTY", 0, propertyMnemonic, 0),
+				Confidence: 1.0,
+			},
+			Prop: Reference{
+				ID: getPointer(GetCorePropertyID("EXPECTED_PROPERTY")),
+			},
+			To: Reference{
+				ID: getPointer(propertyID),
+			},
+		})
+	}
+}
+
+type propClaim struct {
+	prop  identifier.Identifier
+	claim Claim
+}
+
+// propClaims returns claims of the container (without their meta claims) together
+// with their properties. Claims with unresolved properties are skipped.
+func propClaims(container ClaimsContainer) ([]propClaim, errors.E) {
+	v := &propClaimsVisitor{claims: []propClaim{}}
+	errE := container.Visit(v)
+	if errE != nil {
+		return nil, errE
+	}
+	return v.claims, nil
+}
+
+var _ Visitor = (*propClaimsVisitor)(nil)
+
+// propClaimsVisitor does not recurse into meta claims.
+type propClaimsVisitor struct {
+	claims []propClaim
+}
+
+func (v *propClaimsVisitor) visit(claim Claim, prop Reference) (VisitResult, errors.E) {
+	if prop.ID != nil {
+		v.claims = append(v.claims, propClaim{prop: *prop.ID, claim: claim})
+	}
+	return Keep, nil
+}
+
+func (v *propClaimsVisitor) VisitIdentifier(claim *IdentifierClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitReference(claim *ReferenceClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitText(claim *TextClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitString(claim *StringClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitAmount(claim *AmountClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitAmountRange(claim *AmountRangeClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitRelation(claim *RelationClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitFile(claim *FileClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitNoValue(claim *NoValueClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitUnknownValue(claim *UnknownValueClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitTime(claim *TimeClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
+
+func (v *propClaimsVisitor) VisitTimeRange(claim *TimeRangeClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop)
+}
@@ -0,0 +1,92 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestCompleteness(t *testing.T) {
+	t.Parallel()
+
+	completeness := document.NewCompleteness(document.CoreProperties)
+	assert.True(t, completeness.Citations[document.GetCorePropertyID("SOURCE_URL")])
+	assert.False(t, completeness.Citations[document.GetCorePropertyID("NAME")])
+
+	item := document.GetCorePropertyID("ITEM")
+	completeness.Expected[item] = []identifier.Identifier{
+		document.GetCorePropertyID("MEDIA_TYPE"),
+		document.GetCorePropertyID("LENGTH"),
+	}
+	completeness.CitationRequired[document.GetCorePropertyID("MEDIA_TYPE")] = true
+
+	doc := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+
+	// No expected properties and no claims requiring a citation.
+	require.NoError(t, doc.Add(stringClaim("NAME", "foo")))
+	_, ok, errE := completeness.Score(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.False(t, ok)
+
+	require.NoError(t, doc.Add(&document.RelationClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.GetCorePropertyReference("TYPE"),
+		To:        document.Reference{ID: &item}, //nolint:exhaustruct
+	}))
+	score, ok, errE := completeness.Score(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.0, score, 0.0001)
+
+	mediaType := stringClaim("MEDIA_TYPE", "text/plain")
+	require.NoError(t, doc.Add(mediaType))
+	// Half of expected properties, no claims with a citation.
+	score, _, errE = completeness.Score(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.InDelta(t, 0.25, score, 0.0001)
+
+	// Negations do not count as citations.
+	require.NoError(t, doc.GetByID(mediaType.ID).Add(&document.ReferenceClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighNegationConfidence}, //nolint:exhaustruct
+		Prop:      document.GetCorePropertyReference("SOURCE_URL"),
+		IRI:       "https://example.com/",
+	}))
+	score, _, errE = completeness.Score(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.InDelta(t, 0.25, score, 0.0001)
+
+	require.NoError(t, doc.GetByID(mediaType.ID).Add(&document.ReferenceClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.GetCorePropertyReference("SOURCE_URL"),
+		IRI:       "https://example.com/",
+	}))
+	score, _, errE = completeness.Score(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.InDelta(t, 0.75, score, 0.0001)
+
+	// Setting the score again replaces the claim.
+	for range 2 {
+		errE = completeness.Set(doc)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		claims := doc.Get(document.GetCorePropertyID("COMPLETENESS"))
+		if assert.Len(t, claims, 1) {
+			amount, ok := claims[0].(*document.AmountClaim)
+			require.True(t, ok)
+			assert.InDelta(t, 0.75, amount.Amount, 0.0001)
+			assert.Equal(t, document.AmountUnitRatio, amount.Unit)
+		}
+	}
+
+	// The claim is removed when the document has no score anymore.
+	doc.Remove(document.GetCorePropertyID("TYPE"))
+	doc.Remove(document.GetCorePropertyID("MEDIA_TYPE"))
+	errE = completeness.Set(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Empty(t, doc.Get(document.GetCorePropertyID("COMPLETENESS")))
+}
@@ -0,0 +1,234 @@
+package document
+
+import (
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+// PropertySchema describes how documents use a property with a claim type.
+type PropertySchema struct {
+	Prop identifier.Identifier `json:"prop"`
+	// Mnemonic is set for core properties.
+	Mnemonic string `json:"mnemonic,omitempty"`
+	// Type is the claim type, e.g., "string" or "amount range".
+	Type string `json:"type"`
+	// Documents is the number of documents with at least one such claim.
+	Documents int64 `json:"documents"`
+	// MinClaims is the minimum number of such claims per document. It is 0
+	// if some described documents do not have such claims.
+	MinClaims int `json:"minClaims"`
+	// MaxClaims is the maximum number of such claims per document.
+	MaxClaims int `json:"maxClaims"`
+	// Units are units used by amount and amount range claims.
+	Units []string `json:"units,omitempty"`
+}
+
+// Schema describes documents of a dataset: properties and claim types used by
+// their (top-level) claims, cardinalities, and units.
+//
+// It is deterministic for the same documents so it can be stored and diffed to detect
+// changes in how importers map source data, or published as dataset documentation.
+type Schema struct {
+	Documents  int64            `json:"documents"`
+	Properties []PropertySchema `json:"properties"`
+}
+
+type schemaKey struct {
+	Prop identifier.Identifier
+	Type string
+}
+
+// Describer builds a schema of documents added to it.
+//
+// It is not safe for concurrent use.
+type Describer struct {
+	documents  int64
+	properties map[schemaKey]*PropertySchema
+	// Maps core property IDs to their mnemonics.
+	mnemonics map[identifier.Identifier]string
+}
+
+// NewDescriber returns a new Describer.
+func NewDescriber() *Describer {
+	mnemonics := make(map[identifier.Identifier]string, len(CoreProperties))
+	for id, property := range CoreProperties {
+		mnemonics[id] = string(property.Mnemonic)
+	}
+	return &Describer{
+		documents:  0,
+		properties: map[schemaKey]*PropertySchema{},
+		mnemonics:  mnemonics,
+	}
+}
+
+// Add adds the document to the schema.
+func (d *Describer) Add(doc *D) errors.E {
+	v := &describeVisitor{counts: map[schemaKey]int{}, units: map[schemaKey][]string{}}
+	errE := doc.Visit(v)
+	if errE != nil {
+		return errE
+	}
+
+	for key, count := range v.counts {
+		p, ok := d.properties[key]
+		if !ok {
+			p = &PropertySchema{
+				Prop:      key.Prop,
+				Mnemonic:  d.mnemonics[key.Prop],
+				Type:      key.Type,
+				Documents: 0,
+				// Documents described before this one did not have such claims.
+				MinClaims: count,
+				MaxClaims: count,
+				Units:     nil,
+			}
+			if d.documents > 0 {
+				p.MinClaims = 0
+			}
+			d.properties[key] = p
+		}
+		p.Documents++
+		p.MinClaims = min(p.MinClaims, count)
+		p.MaxClaims = max(p.MaxClaims, count)
+		for _, unit := range v.units[key] {
+			if !slices.Contains(p.Units, unit) {
+				p.Units = append(p.Units, unit)
+			}
+		}
+	}
+	for key, p := range d.properties {
+		if _, ok := v.counts[key]; !ok {
+			p.MinClaims = 0
+		}
+	}
+	d.documents++
+
+	return nil
+}
+
+// Schema returns the schema of all documents added so far.
+// Properties are sorted by their mnemonics (if any), IDs, and claim types.
+func (d *Describer) Schema() Schema {
+	properties := make([]PropertySchema, 0, len(d.properties))
+	for _, p := range d.properties {
+		property := *p
+		property.Units = slices.Clone(p.Units)
+		slices.Sort(property.Units)
+		properties = append(properties, property)
+	}
+	slices.SortFunc(properties, func(a, b PropertySchema) int {
+		// Core properties (with mnemonics) come first.
+		if (a.Mnemonic == "") != (b.Mnemonic == "") {
+			if a.Mnemonic != "" {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(a.Mnemonic, b.Mnemonic); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Prop.String(), b.Prop.String()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Type, b.Type)
+	})
+	return Schema{
+		Documents:  d.documents,
+		Properties: properties,
+	}
+}
+
+// Describe returns the schema of documents.
+func Describe(docs ...*D) (Schema, errors.E) {
+	d := NewDescriber()
+	for _, doc := range docs {
+		errE := d.Add(doc)
+		if errE != nil {
+			errors.Details(errE)["doc"] = doc.ID.String()
+			return Schema{}, errE //nolint:exhaustruct
+		}
+	}
+	return d.Schema(), nil
+}
+
+type describeVisitor struct {
+	counts map[schemaKey]int
+	units  map[schemaKey][]string
+}
+
+var _ Visitor = (*describeVisitor)(nil)
+
+// count counts the claim. Meta claims are not visited.
+func (v *describeVisitor) count(prop Reference, claimType string) schemaKey {
+	if prop.ID == nil {
+		return schemaKey{} //nolint:exhaustruct
+	}
+	key := schemaKey{Prop: *prop.ID, Type: claimType}
+	v.counts[key]++
+	return key
+}
+
+func (v *describeVisitor) VisitIdentifier(claim *IdentifierClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "identifier")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitReference(claim *ReferenceClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "reference")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitText(claim *TextClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "text")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitString(claim *StringClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "string")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitAmount(claim *AmountClaim) (VisitResult, errors.E) {
+	key := v.count(claim.Prop, "amount")
+	v.units[key] = append(v.units[key], claim.Unit.String())
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitAmountRange(claim *AmountRangeClaim) (VisitResult, errors.E) {
+	key := v.count(claim.Prop, "amount range")
+	v.units[key] = append(v.units[key], claim.Unit.String())
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitRelation(claim *RelationClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "relation")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitFile(claim *FileClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "file")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitNoValue(claim *NoValueClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "none")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitUnknownValue(claim *UnknownValueClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "unknown")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitTime(claim *TimeClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "time")
+	return Keep, nil
+}
+
+func (v *describeVisitor) VisitTimeRange(claim *TimeRangeClaim) (VisitResult, errors.E) {
+	v.count(claim.Prop, "time range")
+	return Keep, nil
+}
@@ -0,0 +1,76 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestDescribe(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+
+	newDoc := func(names int, amount bool) *document.D {
+		doc := &document.D{
+			CoreDocument: document.CoreDocument{
+				ID:    identifier.New(),
+				Score: document.HighConfidence,
+			},
+		}
+		for range names {
+			errE := doc.Add(&document.StringClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         identifier.New(),
+					Confidence: document.HighConfidence,
+				},
+				Prop:   document.GetCorePropertyReference("NAME"),
+				String: "foobar",
+			})
+			require.NoError(t, errE, "% -+#.1v", errE)
+		}
+		if amount {
+			errE := doc.Add(&document.AmountClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         identifier.New(),
+					Confidence: document.HighConfidence,
+				},
+				Prop:   document.Reference{ID: &prop},
+				Amount: 42,
+				Unit:   document.AmountUnitKilogram,
+			})
+			require.NoError(t, errE, "% -+#.1v", errE)
+		}
+		return doc
+	}
+
+	schema, errE := document.Describe(newDoc(1, false), newDoc(2, true), newDoc(1, false))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, document.Schema{
+		Documents: 3,
+		Properties: []document.PropertySchema{
+			{
+				Prop:      document.GetCorePropertyID("NAME"),
+				Mnemonic:  "NAME",
+				Type:      "string",
+				Documents: 3,
+				MinClaims: 1,
+				MaxClaims: 2,
+				Units:     nil,
+			},
+			{
+				Prop:      prop,
+				Mnemonic:  "",
+				Type:      "amount",
+				Documents: 1,
+				MinClaims: 0,
+				MaxClaims: 1,
+				Units:     []string{"kg"},
+			},
+		},
+	}, schema)
+}
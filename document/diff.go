@@ -0,0 +1,195 @@
+package document
+
+import (
+	"bytes"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+func propID(claim Claim, ref Reference) (*identifier.Identifier, errors.E) {
+	if ref.ID == nil {
+		errE := errors.New("unresolved reference")
+		errors.Details(errE)["claim"] = claim.GetID().String()
+		return nil, errE
+	}
+	return ref.ID, nil
+}
+
+// ClaimToPatch returns a claim patch which creates the claim (without its meta claims)
+// when used with AddClaimChange.
+//
+// All references of the claim have to be resolved.
+func ClaimToPatch(claim Claim) (ClaimPatch, errors.E) { //nolint:ireturn,maintidx
+	confidence := claim.GetConfidence()
+
+	switch c := claim.(type) {
+	case *IdentifierClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return IdentifierClaimPatch{Confidence: &confidence, Prop: prop, Value: &c.Value}, nil
+	case *ReferenceClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return ReferenceClaimPatch{Confidence: &confidence, Prop: prop, IRI: &c.IRI}, nil
+	case *TextClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return TextClaimPatch{Confidence: &confidence, Prop: prop, HTML: c.HTML}, nil
+	case *StringClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return StringClaimPatch{Confidence: &confidence, Prop: prop, String: &c.String}, nil
+	case *AmountClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		if c.Decimal != "" {
+			return AmountClaimPatch{Confidence: &confidence, Prop: prop, Decimal: &c.Decimal, Unit: &c.Unit}, nil
+		}
+		return AmountClaimPatch{Confidence: &confidence, Prop: prop, Amount: &c.Amount, Unit: &c.Unit}, nil
+	case *AmountRangeClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return AmountRangeClaimPatch{Confidence: &confidence, Prop: prop, Lower: &c.Lower, Upper: &c.Upper, Unit: &c.Unit}, nil
+	case *RelationClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		to, errE := propID(c, c.To)
+		if errE != nil {
+			return nil, errE
+		}
+		return RelationClaimPatch{Confidence: &confidence, Prop: prop, To: to}, nil
+	case *FileClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		preview := c.Preview
+		if preview == nil {
+			preview = []string{}
+		}
+		patch := FileClaimPatch{Confidence: &confidence, Prop: prop, MediaType: &c.MediaType, URL: &c.URL, Preview: preview}
+		if c.Blurhash != "" {
+			patch.Blurhash = &c.Blurhash
+		}
+		if c.Checksum != "" {
+			patch.Checksum = &c.Checksum
+		}
+		return patch, nil
+	case *NoValueClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return NoValueClaimPatch{Confidence: &confidence, Prop: prop}, nil
+	case *UnknownValueClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return UnknownValueClaimPatch{Confidence: &confidence, Prop: prop}, nil
+	case *TimeClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return TimeClaimPatch{Confidence: &confidence, Prop: prop, Timestamp: &c.Timestamp, Precision: &c.Precision}, nil
+	case *TimeRangeClaim:
+		prop, errE := propID(c, c.Prop)
+		if errE != nil {
+			return nil, errE
+		}
+		return TimeRangeClaimPatch{Confidence: &confidence, Prop: prop, Lower: &c.Lower, Upper: &c.Upper, Precision: &c.Precision}, nil
+	}
+	return nil, errors.Errorf(`claim of type %T is not supported`, claim)
+}
+
+// addClaimChanges returns changes which add the claim together with all its meta claims.
+func addClaimChanges(claim Claim, under *identifier.Identifier) (Changes, errors.E) {
+	patch, errE := ClaimToPatch(claim)
+	if errE != nil {
+		return nil, errE
+	}
+
+	id := claim.GetID()
+	changes := Changes{AddClaimChange{Under: under, ID: id, Patch: patch}}
+	for _, meta := range claim.AllClaims() {
+		c, errE := addClaimChanges(meta, &id)
+		if errE != nil {
+			return nil, errE
+		}
+		changes = append(changes, c...)
+	}
+	return changes, nil
+}
+
+func claimsEqual(a, b Claim) (bool, errors.E) {
+	// We compare canonical JSON so that the order of meta claims does not matter.
+	aJSON, errE := canonicalJSON(a)
+	if errE != nil {
+		return false, errE
+	}
+	bJSON, errE := canonicalJSON(b)
+	if errE != nil {
+		return false, errE
+	}
+	return bytes.Equal(aJSON, bJSON), nil
+}
+
+// Diff returns claim-level changes which transform claims of document from into claims of document to.
+//
+// Top-level claims are matched by their IDs. Claims which are only in to are added and claims
+// which differ (including their meta claims) are removed and added again with the same ID.
+// Claims which are only in from are removed only if owned returns true for them, so that
+// claims of from which have not been made by the same source as to are kept.
+// If owned is nil, all such claims are removed.
+//
+// The order of returned changes is deterministic for the same inputs.
+func Diff(from, to *D, owned func(Claim) bool) (Changes, errors.E) {
+	changes := Changes{}
+
+	for _, claim := range from.AllClaims() {
+		if to.GetByID(claim.GetID()) != nil {
+			continue
+		}
+		if owned != nil && !owned(claim) {
+			continue
+		}
+		changes = append(changes, RemoveClaimChange{ID: claim.GetID()})
+	}
+
+	for _, claim := range to.AllClaims() {
+		existing := from.GetByID(claim.GetID())
+		if existing != nil {
+			equal, errE := claimsEqual(existing, claim)
+			if errE != nil {
+				return nil, errE
+			}
+			if equal {
+				continue
+			}
+			changes = append(changes, RemoveClaimChange{ID: claim.GetID()})
+		}
+		c, errE := addClaimChanges(claim, nil)
+		if errE != nil {
+			return nil, errE
+		}
+		changes = append(changes, c...)
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,96 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	docID := identifier.New()
+	prop := identifier.New()
+	otherProp := identifier.New()
+	kept := identifier.New()
+	removed := identifier.New()
+	foreign := identifier.New()
+	changed := identifier.New()
+	added := identifier.New()
+	meta := identifier.New()
+
+	stringClaim := func(id, prop identifier.Identifier, value string) *document.StringClaim {
+		return &document.StringClaim{
+			CoreClaim: document.CoreClaim{ID: id, Confidence: document.HighConfidence},
+			Prop:      document.Reference{ID: &prop},
+			String:    value,
+		}
+	}
+
+	newDoc := func(claims ...document.Claim) *document.D {
+		doc := &document.D{
+			CoreDocument: document.CoreDocument{ID: docID, Score: document.LowConfidence},
+		}
+		for _, claim := range claims {
+			require.NoError(t, doc.Add(claim))
+		}
+		return doc
+	}
+
+	changedTo := stringClaim(changed, prop, "new")
+	require.NoError(t, changedTo.Add(stringClaim(meta, prop, "meta")))
+
+	from := newDoc(
+		stringClaim(kept, prop, "kept"),
+		stringClaim(removed, prop, "removed"),
+		stringClaim(foreign, otherProp, "foreign"),
+		stringClaim(changed, prop, "old"),
+	)
+	to := newDoc(
+		stringClaim(kept, prop, "kept"),
+		changedTo,
+		stringClaim(added, prop, "added"),
+	)
+
+	changes, errE := document.Diff(from, to, func(claim document.Claim) bool {
+		return *claim.(*document.StringClaim).Prop.ID == prop //nolint:forcetypeassert
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, changes, 5)
+
+	errE = changes.Apply(from)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	expected := newDoc(
+		stringClaim(kept, prop, "kept"),
+		stringClaim(foreign, otherProp, "foreign"),
+	)
+	require.NoError(t, expected.Add(changedTo))
+	require.NoError(t, expected.Add(stringClaim(added, prop, "added")))
+
+	for _, claim := range expected.AllClaims() {
+		got := from.GetByID(claim.GetID())
+		if assert.NotNil(t, got, claim.GetID().String()) {
+			assert.Equal(t, claim, got)
+		}
+	}
+	assert.Equal(t, expected.Size(), from.Size())
+
+	// Claims round-trip through JSON, so changes can be stored.
+	out, errE := x.MarshalWithoutEscapeHTML(changes)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	var changes2 document.Changes
+	errE = x.UnmarshalWithoutUnknownFields(out, &changes2)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, changes, changes2)
+
+	// There are no changes between equal documents.
+	changes, errE = document.Diff(to, to, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Empty(t, changes)
+}
@@ -5,11 +5,20 @@ import (
 	"gitlab.com/tozd/identifier"
 )
 
+// FormatVersion is the version of the JSON format of documents as they are stored.
+// It should be increased when the format changes in an incompatible way.
+const FormatVersion = 1
+
 type D struct {
 	CoreDocument
 
 	Mnemonic Mnemonic    `exhaustruct:"optional" json:"mnemonic,omitempty"`
 	Claims   *ClaimTypes `exhaustruct:"optional" json:"claims,omitempty"`
+
+	// Children are child documents embedded in the document (e.g., chapters of a book
+	// or questions of a survey). They are stored, retrieved, and indexed together with
+	// the document. Children cannot have children themselves.
+	Children []D `exhaustruct:"optional" json:"children,omitempty"`
 }
 
 type ClaimsContainer interface {
@@ -126,3 +135,41 @@ func (d *D) MergeFrom(other ...*D) errors.E {
 	d.Scores = nil
 	return nil
 }
+
+// GetChild returns the embedded child document with the ID, or nil if there is none.
+func (d *D) GetChild(id identifier.Identifier) *D {
+	for i := range d.Children {
+		if d.Children[i].ID == id {
+			return &d.Children[i]
+		}
+	}
+	return nil
+}
+
+// AddChild embeds the child document into the document.
+func (d *D) AddChild(child D) errors.E {
+	if child.ID == d.ID || d.GetChild(child.ID) != nil {
+		return errors.Errorf(`child with ID "%s" already exists`, child.ID)
+	}
+	if len(child.Children) > 0 {
+		return errors.Errorf(`child with ID "%s" has children`, child.ID)
+	}
+	d.Children = append(d.Children, child)
+	return nil
+}
+
+// ValidateChildren checks that embedded child documents have unique IDs
+// (different from the ID of the document) and that they do not have children.
+func (d *D) ValidateChildren() errors.E {
+	ids := map[identifier.Identifier]bool{d.ID: true}
+	for _, child := range d.Children {
+		if ids[child.ID] {
+			return errors.Errorf(`child with ID "%s" already exists`, child.ID)
+		}
+		ids[child.ID] = true
+		if len(child.Children) > 0 {
+			return errors.Errorf(`child with ID "%s" has children`, child.ID)
+		}
+	}
+	return nil
+}
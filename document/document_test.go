@@ -1,6 +1,7 @@
 package document_test
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -28,6 +29,8 @@ func TestTimestampMarshal(t *testing.T) {
 		{`"-0206-12-04T12:34:45Z"`, -68638706715},
 		{`"-2006-12-04T12:34:45Z"`, -125441263515},
 		{`"-20006-12-04T12:34:45Z"`, -693466399515},
+		{`"-9999-01-01T00:00:00Z"`, -377705116800},
+		{`"999999-12-31T23:59:59Z"`, 31494784780799},
 		{`"-239999999-01-01T00:00:00Z"`, -7573730615596800},
 	}
 	for _, test := range tests {
@@ -46,6 +49,36 @@ func TestTimestampMarshal(t *testing.T) {
 	}
 }
 
+func TestParseDecimalAmount(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		decimal  string
+		amount   float64
+		expected string
+	}{
+		{"42", 42, ""},
+		{"0.5", 0.5, ""},
+		{"0.1", 0.1, "0.1"},
+		{"-19.99", -19.99, "-19.99"},
+		{"+1.10", 1.1, "1.1"},
+		{"12345678901234567890.123", 12345678901234567890.123, "12345678901234567890.123"},
+	}
+	for _, test := range tests {
+		t.Run(test.decimal, func(t *testing.T) {
+			t.Parallel()
+
+			amount, decimal, errE := document.ParseDecimalAmount(test.decimal)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, test.amount, amount) //nolint:testifylint
+			assert.Equal(t, test.expected, decimal)
+		})
+	}
+
+	_, _, errE := document.ParseDecimalAmount("abc")
+	assert.Error(t, errE)
+}
+
 func TestDocument(t *testing.T) {
 	t.Parallel()
 
@@ -155,3 +188,228 @@ func TestDocument(t *testing.T) {
 		Prop: document.GetCorePropertyReference("ARTICLE"),
 	}, claim)
 }
+
+func TestRemoveMetaClaimByID(t *testing.T) {
+	t.Parallel()
+
+	id := identifier.New()
+	metaID := identifier.New()
+
+	claim := &document.NoValueClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         id,
+			Confidence: 1.0,
+		},
+		Prop: document.GetCorePropertyReference("ARTICLE"),
+	}
+	err := claim.Add(&document.UnknownValueClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         metaID,
+			Confidence: 1.0,
+		},
+		Prop: document.GetCorePropertyReference("ARTICLE"),
+	})
+	require.NoError(t, err)
+
+	doc := document.D{} //nolint:exhaustruct
+	err = doc.Add(claim)
+	require.NoError(t, err)
+
+	metaClaim := doc.RemoveByID(metaID)
+	assert.NotNil(t, metaClaim)
+	// Only the meta claim is removed, not the claim it belongs to.
+	assert.Equal(t, &document.NoValueClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         id,
+			Confidence: 1.0,
+		},
+		Prop: document.GetCorePropertyReference("ARTICLE"),
+	}, doc.GetByID(id))
+}
+
+func TestPropertyRegistryValidate(t *testing.T) {
+	t.Parallel()
+
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+
+	for _, property := range document.CoreProperties {
+		errE := registry.Validate(&property)
+		assert.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	doc := document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: 1.0,
+		},
+		Claims: &document.ClaimTypes{
+			String: document.StringClaims{
+				{
+					CoreClaim: document.CoreClaim{
+						ID:         identifier.New(),
+						Confidence: 1.0,
+					},
+					Prop:   document.GetCorePropertyReference("DURATION"),
+					String: "1h",
+				},
+			},
+		},
+	}
+	errE := registry.Validate(&doc)
+	assert.ErrorIs(t, errE, document.ErrInvalidClaimType)
+
+	doc.Claims.String[0].Prop = document.GetCorePropertyReference("MEDIA_TYPE")
+	errE = registry.Validate(&doc)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+}
+
+func TestPropertyRegistryDataTypes(t *testing.T) {
+	t.Parallel()
+
+	minimum := 0.0
+	property := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+	require.NoError(t, property.Add(&document.RelationClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.GetCorePropertyReference("TYPE"),
+		To:        document.GetCorePropertyReference("AMOUNT_CLAIM_TYPE"),
+	}))
+	require.NoError(t, property.Add(stringClaim("UNIT", "m")))
+	require.NoError(t, property.Add(&document.AmountClaim{ //nolint:exhaustruct
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.GetCorePropertyReference("MINIMUM_VALUE"),
+		Amount:    minimum,
+		Unit:      document.AmountUnitNone,
+	}))
+
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+	registry.Add(property)
+
+	unit := document.AmountUnitMetre
+	dataType, ok := registry.Lookup(property.ID)
+	require.True(t, ok)
+	assert.Equal(t, document.PropertyDataType{
+		ClaimTypes: map[string]bool{"amount": true},
+		Unit:       &unit,
+		Minimum:    &minimum,
+		Maximum:    nil,
+	}, dataType)
+
+	errE := registry.CheckClaimType(property.ID, "string")
+	assert.ErrorIs(t, errE, document.ErrInvalidClaimType)
+	assert.EqualError(t, errE, `property does not have "string" claim type: invalid claim type`)
+	assert.NoError(t, registry.CheckClaimType(property.ID, "amount"))
+	assert.NoError(t, registry.CheckClaimType(identifier.New(), "string"))
+
+	newDoc := func(value string) *document.D {
+		doc := &document.D{ //nolint:exhaustruct
+			CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+		}
+		claim := stringClaim("NAME", value)
+		claim.Prop = property.Reference()
+		require.NoError(t, doc.Add(claim))
+		// Identifier claims are coerced to string claims for properties with string claim type.
+		require.NoError(t, doc.Add(&document.IdentifierClaim{
+			CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+			Prop:      document.GetCorePropertyReference("MEDIA_TYPE"),
+			Value:     "image/png",
+		}))
+		return doc
+	}
+
+	doc := newDoc(" 12.5 ")
+	assert.ErrorIs(t, registry.Validate(doc), document.ErrInvalidClaimType)
+	coerced, errE := registry.Coerce(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 2, coerced)
+	require.Len(t, doc.Claims.Amount, 1)
+	assert.Equal(t, 12.5, doc.Claims.Amount[0].Amount) //nolint:testifylint
+	assert.Equal(t, document.AmountUnitMetre, doc.Claims.Amount[0].Unit)
+	require.Len(t, doc.Claims.String, 1)
+	assert.Equal(t, "image/png", doc.Claims.String[0].String)
+	assert.Empty(t, doc.Claims.Identifier)
+	errE = registry.Validate(doc)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	// Values out of bounds are rejected.
+	doc = newDoc("-1")
+	_, errE = registry.Coerce(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.ErrorIs(t, registry.Validate(doc), document.ErrValueOutOfRange)
+
+	// Amounts in other units are rejected.
+	doc = newDoc("1")
+	_, errE = registry.Coerce(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	doc.Claims.Amount[0].Unit = document.AmountUnitKilogram
+	assert.ErrorIs(t, registry.Validate(doc), document.ErrInvalidUnit)
+
+	// Strings which are not numbers are not coerced.
+	doc = newDoc("long")
+	coerced, errE = registry.Coerce(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 1, coerced)
+	assert.ErrorIs(t, registry.Validate(doc), document.ErrInvalidClaimType)
+}
+
+func TestChildren(t *testing.T) {
+	t.Parallel()
+
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+
+	doc := document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: 1.0,
+		},
+	}
+
+	child := document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: 1.0,
+		},
+		Claims: &document.ClaimTypes{
+			String: document.StringClaims{
+				{
+					CoreClaim: document.CoreClaim{
+						ID:         identifier.New(),
+						Confidence: 1.0,
+					},
+					Prop:   document.GetCorePropertyReference("DURATION"),
+					String: "1h",
+				},
+			},
+		},
+	}
+
+	errE := doc.AddChild(child)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, &doc.Children[0], doc.GetChild(child.ID))
+	assert.Nil(t, doc.GetChild(identifier.New()))
+
+	errE = doc.AddChild(child)
+	assert.EqualError(t, errE, fmt.Sprintf(`child with ID "%s" already exists`, child.ID))
+
+	// Claims of children are validated, too.
+	errE = registry.Validate(&doc)
+	assert.ErrorIs(t, errE, document.ErrInvalidClaimType)
+
+	doc.Children[0].Claims.String[0].Prop = document.GetCorePropertyReference("MEDIA_TYPE")
+	errE = registry.Validate(&doc)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	// Children cannot have children.
+	doc.Children[0].Children = []document.D{{CoreDocument: document.CoreDocument{ID: identifier.New(), Score: 1.0}}}
+	errE = registry.Validate(&doc)
+	assert.EqualError(t, errE, fmt.Sprintf(`child with ID "%s" has children`, child.ID))
+
+	doc.Children[0].Children = nil
+	data, errE := x.MarshalWithoutEscapeHTML(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	var d document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &d)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, doc, d)
+}
@@ -0,0 +1,100 @@
+package document
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"golang.org/x/net/idna"
+)
+
+// allowedIRISchemes maps schemes allowed by URLToIRI to their default ports.
+var allowedIRISchemes = map[string]string{ //nolint:gochecknoglobals
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+}
+
+func normalizeHost(host string) (string, errors.E) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	normalized, err := idna.Lookup.ToASCII(strings.ToLower(host))
+	if err != nil {
+		errE := errors.WithMessage(err, "invalid host")
+		errors.Details(errE)["host"] = host
+		return "", errE
+	}
+	return normalized, nil
+}
+
+// URLToIRI validates and normalizes the URL so that it can be used as an IRI of a ReferenceClaim.
+//
+// The URL has to be absolute, with a host and with http, https, or ftp scheme.
+// The scheme and the host are lower-cased, internationalized domain names are
+// converted to their ASCII form, and the default port for the scheme is removed.
+func URLToIRI(u *url.URL) (string, errors.E) {
+	if u == nil {
+		return "", errors.New("URL is missing")
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	defaultPort, ok := allowedIRISchemes[scheme]
+	if !ok {
+		errE := errors.New("unsupported URL scheme")
+		errors.Details(errE)["url"] = u.String()
+		return "", errE
+	}
+	if u.Opaque != "" || u.Hostname() == "" {
+		errE := errors.New("URL without host")
+		errors.Details(errE)["url"] = u.String()
+		return "", errE
+	}
+
+	host, errE := normalizeHost(u.Hostname())
+	if errE != nil {
+		errors.Details(errE)["url"] = u.String()
+		return "", errE
+	}
+	if port := u.Port(); port != "" && port != defaultPort {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 addresses have to be in brackets.
+		host = "[" + host + "]"
+	}
+
+	normalized := *u
+	normalized.Scheme = scheme
+	normalized.Host = host
+	return normalized.String(), nil
+}
+
+// EmailToIRI validates and normalizes the e-mail address and returns it as a mailto IRI
+// which can be used as a value of an IdentifierClaim.
+//
+// Address can also include a display name (e.g., "Jane <jane@example.com>") which is not
+// included in the IRI. The domain is lower-cased and internationalized domain names are
+// converted to their ASCII form.
+func EmailToIRI(address string) (string, errors.E) {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		errE := errors.WithMessage(err, "invalid e-mail address")
+		errors.Details(errE)["address"] = address
+		return "", errE
+	}
+
+	i := strings.LastIndex(addr.Address, "@")
+	domain, errE := normalizeHost(addr.Address[i+1:])
+	if errE != nil {
+		errors.Details(errE)["address"] = address
+		return "", errE
+	}
+
+	u := url.URL{ //nolint:exhaustruct
+		Scheme: "mailto",
+		Opaque: addr.Address[:i] + "@" + domain,
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,72 @@
+package document_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestURLToIRI(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://example.com/path?q=1#frag", "https://example.com/path?q=1#frag"},
+		{"HTTP://Example.COM:80/", "http://example.com/"},
+		{"https://example.com:8443/", "https://example.com:8443/"},
+		{"https://bücher.example/", "https://xn--bcher-kva.example/"},
+		{"https://[::1]:443/", "https://[::1]/"},
+		{"ftp://example.com/file", "ftp://example.com/file"},
+		{"mailto:jane@example.com", ""},
+		{"javascript:alert(1)", ""},
+		{"/relative", ""},
+		{"https:///path", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			t.Parallel()
+
+			u, err := url.Parse(test.url)
+			require.NoError(t, err)
+			iri, errE := document.URLToIRI(u)
+			if test.expected == "" {
+				assert.Error(t, errE)
+			} else if assert.NoError(t, errE, "% -+#.1v", errE) {
+				assert.Equal(t, test.expected, iri)
+			}
+		})
+	}
+}
+
+func TestEmailToIRI(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		address  string
+		expected string
+	}{
+		{"jane@example.com", "mailto:jane@example.com"},
+		{"Jane Doe <Jane@Example.COM>", "mailto:Jane@example.com"},
+		{"jane@bücher.example", "mailto:jane@xn--bcher-kva.example"},
+		{"not an address", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			t.Parallel()
+
+			iri, errE := document.EmailToIRI(test.address)
+			if test.expected == "" {
+				assert.Error(t, errE)
+			} else if assert.NoError(t, errE, "% -+#.1v", errE) {
+				assert.Equal(t, test.expected, iri)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package document
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+// Strategies of which claims to keep when truncating a document.
+const (
+	// TruncateByConfidence keeps claims with the highest (absolute) confidence. For Wikidata
+	// entities these are claims of statements with preferred rank, then normal rank.
+	TruncateByConfidence = "confidence"
+	// TruncateByOrder keeps claims stored first in the document.
+	TruncateByOrder = "order"
+)
+
+// Limits limit the size of a document so that it can be indexed. Zero values mean no limit.
+//
+// Limits apply to (top-level) claims of the document. Meta claims are removed together
+// with their claim.
+type Limits struct {
+	// MaxClaimsPerProperty is the maximum number of claims with the same property.
+	MaxClaimsPerProperty int
+	// MaxClaims is the maximum number of claims.
+	MaxClaims int
+	// MaxTextBytes is the maximum total size of text and string claims (all translations).
+	MaxTextBytes int
+	// Strategy is TruncateByConfidence (default when empty) or TruncateByOrder.
+	Strategy string
+}
+
+// Enabled returns true if any limit is set.
+func (l Limits) Enabled() bool {
+	return l.MaxClaimsPerProperty > 0 || l.MaxClaims > 0 || l.MaxTextBytes > 0
+}
+
+// Truncation describes claims removed from a document by Limits.Truncate.
+type Truncation struct {
+	Doc identifier.Identifier `json:"doc"`
+	// Claims is the number of removed claims.
+	Claims int `json:"claims"`
+	// TextBytes is the size of removed text and string claims.
+	TextBytes int `json:"textBytes"`
+	// Props maps properties (their IDs or unresolved references)
+	// to the number of removed claims with them.
+	Props map[string]int `json:"props"`
+}
+
+// Truncate removes claims from the document exceeding limits, keeping claims based on the strategy.
+// It returns nil if the document has not been truncated.
+func (l Limits) Truncate(doc *D) (*Truncation, errors.E) {
+	if !l.Enabled() {
+		return nil, nil //nolint:nilnil
+	}
+	if l.Strategy != "" && l.Strategy != TruncateByConfidence && l.Strategy != TruncateByOrder {
+		errE := errors.New("unknown truncation strategy")
+		errors.Details(errE)["strategy"] = l.Strategy
+		return nil, errE
+	}
+
+	v := &limitsVisitor{claims: []limitsClaim{}, drop: nil}
+	errE := doc.Visit(v)
+	if errE != nil {
+		return nil, errE
+	}
+
+	// Indices of claims in the order of preference.
+	order := make([]int, len(v.claims))
+	for i := range order {
+		order[i] = i
+	}
+	if l.Strategy != TruncateByOrder {
+		slices.SortStableFunc(order, func(a, b int) int {
+			ca := math.Abs(float64(v.claims[a].confidence))
+			cb := math.Abs(float64(v.claims[b].confidence))
+			if ca > cb {
+				return -1
+			} else if ca < cb {
+				return 1
+			}
+			return 0
+		})
+	}
+
+	drop := map[identifier.Identifier]bool{}
+	if l.MaxClaimsPerProperty > 0 {
+		counts := map[string]int{}
+		for _, i := range order {
+			counts[v.claims[i].prop]++
+			if counts[v.claims[i].prop] > l.MaxClaimsPerProperty {
+				drop[v.claims[i].id] = true
+			}
+		}
+	}
+	if l.MaxClaims > 0 {
+		count := 0
+		for _, i := range order {
+			if drop[v.claims[i].id] {
+				continue
+			}
+			count++
+			if count > l.MaxClaims {
+				drop[v.claims[i].id] = true
+			}
+		}
+	}
+	if l.MaxTextBytes > 0 {
+		size := 0
+		for _, i := range order {
+			if drop[v.claims[i].id] || v.claims[i].textBytes == 0 {
+				continue
+			}
+			if size+v.claims[i].textBytes > l.MaxTextBytes {
+				drop[v.claims[i].id] = true
+			} else {
+				size += v.claims[i].textBytes
+			}
+		}
+	}
+
+	if len(drop) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	truncation := &Truncation{
+		Doc:       doc.ID,
+		Claims:    len(drop),
+		TextBytes: 0,
+		Props:     map[string]int{},
+	}
+	for _, claim := range v.claims {
+		if drop[claim.id] {
+			truncation.TextBytes += claim.textBytes
+			truncation.Props[claim.prop]++
+		}
+	}
+
+	v.drop = drop
+	errE = doc.Visit(v)
+	if errE != nil {
+		return nil, errE
+	}
+
+	return truncation, nil
+}
+
+type limitsClaim struct {
+	id         identifier.Identifier
+	prop       string
+	confidence Confidence
+	textBytes  int
+}
+
+var _ Visitor = (*limitsVisitor)(nil)
+
+// limitsVisitor collects claims when drop is nil and drops
+// claims in drop otherwise.
+//
+// limitsVisitor does not recurse into meta claims.
+type limitsVisitor struct {
+	claims []limitsClaim
+	drop   map[identifier.Identifier]bool
+}
+
+func (v *limitsVisitor) visit(claim Claim, prop Reference, textBytes int) VisitResult {
+	if v.drop != nil {
+		if v.drop[claim.GetID()] {
+			return Drop
+		}
+		return Keep
+	}
+
+	p := strings.Join(prop.Temporary, "/")
+	if prop.ID != nil {
+		p = prop.ID.String()
+	}
+	v.claims = append(v.claims, limitsClaim{
+		id:         claim.GetID(),
+		prop:       p,
+		confidence: claim.GetConfidence(),
+		textBytes:  textBytes,
+	})
+	return Keep
+}
+
+func (v *limitsVisitor) VisitIdentifier(claim *IdentifierClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitReference(claim *ReferenceClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitText(claim *TextClaim) (VisitResult, errors.E) {
+	size := 0
+	for _, html := range claim.HTML {
+		size += len(html)
+	}
+	return v.visit(claim, claim.Prop, size), nil
+}
+
+func (v *limitsVisitor) VisitString(claim *StringClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, len(claim.String)), nil
+}
+
+func (v *limitsVisitor) VisitAmount(claim *AmountClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitAmountRange(claim *AmountRangeClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitRelation(claim *RelationClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitFile(claim *FileClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitNoValue(claim *NoValueClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitUnknownValue(claim *UnknownValueClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitTime(claim *TimeClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
+
+func (v *limitsVisitor) VisitTimeRange(claim *TimeRangeClaim) (VisitResult, errors.E) {
+	return v.visit(claim, claim.Prop, 0), nil
+}
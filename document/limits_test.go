@@ -0,0 +1,99 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestLimitsTruncate(t *testing.T) {
+	t.Parallel()
+
+	relation := func(confidence document.Confidence) *document.RelationClaim {
+		return &document.RelationClaim{
+			CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: confidence}, //nolint:exhaustruct
+			Prop:      document.GetCorePropertyReference("TYPE"),
+			To:        document.GetCorePropertyReference("ITEM"),
+		}
+	}
+
+	newDoc := func(t *testing.T) (*document.D, []identifier.Identifier) {
+		t.Helper()
+
+		doc := &document.D{ //nolint:exhaustruct
+			CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+		}
+		name := stringClaim("NAME", "aaaa")
+		name.Confidence = document.MediumConfidence
+		claims := []document.Claim{
+			relation(document.MediumConfidence),
+			relation(document.HighConfidence),
+			relation(document.NoConfidence),
+			relation(document.HighNegationConfidence),
+			name,
+			stringClaim("NAME", "bbbbbb"),
+			stringClaim("DESCRIPTION", "cc"),
+		}
+		ids := []identifier.Identifier{}
+		for _, claim := range claims {
+			require.NoError(t, doc.Add(claim))
+			ids = append(ids, claim.GetID())
+		}
+		return doc, ids
+	}
+
+	remaining := func(doc *document.D) []identifier.Identifier {
+		result := []identifier.Identifier{}
+		for _, claim := range doc.AllClaims() {
+			result = append(result, claim.GetID())
+		}
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		limits    document.Limits
+		keep      []int
+		textBytes int
+	}{
+		{"none", document.Limits{}, []int{0, 1, 2, 3, 4, 5, 6}, 0},                                                                       //nolint:exhaustruct
+		{"per property", document.Limits{MaxClaimsPerProperty: 2}, []int{1, 3, 4, 5, 6}, 0},                                              //nolint:exhaustruct
+		{"per property by order", document.Limits{MaxClaimsPerProperty: 2, Strategy: document.TruncateByOrder}, []int{0, 1, 4, 5, 6}, 0}, //nolint:exhaustruct
+		{"claims", document.Limits{MaxClaims: 4}, []int{1, 3, 5, 6}, 4},                                                                  //nolint:exhaustruct
+		{"text bytes", document.Limits{MaxTextBytes: 8}, []int{0, 1, 2, 3, 5, 6}, 4},                                                     //nolint:exhaustruct
+		{"text bytes by order", document.Limits{MaxTextBytes: 8, Strategy: document.TruncateByOrder}, []int{0, 1, 2, 3, 4, 6}, 6},        //nolint:exhaustruct
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			doc, ids := newDoc(t)
+			truncation, errE := test.limits.Truncate(doc)
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			expected := []identifier.Identifier{}
+			for _, i := range test.keep {
+				expected = append(expected, ids[i])
+			}
+			assert.ElementsMatch(t, expected, remaining(doc))
+
+			if len(test.keep) == len(ids) {
+				assert.Nil(t, truncation)
+				return
+			}
+			require.NotNil(t, truncation)
+			assert.Equal(t, doc.ID, truncation.Doc)
+			assert.Equal(t, len(ids)-len(test.keep), truncation.Claims)
+			assert.Equal(t, test.textBytes, truncation.TextBytes)
+		})
+	}
+
+	doc, _ := newDoc(t)
+	_, errE := document.Limits{MaxClaims: 1, Strategy: "random"}.Truncate(doc) //nolint:exhaustruct
+	assert.EqualError(t, errE, "unknown truncation strategy")
+}
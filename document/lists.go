@@ -0,0 +1,141 @@
+package document
+
+import (
+	"slices"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+// Ordered collections (e.g., track lists or authors in citation order) are represented
+// with claims which are elements of a list. Every element has a LIST identifier meta claim
+// with the ID of the list (shared by all elements of the list) and an ORDER amount meta
+// claim with the position of the element in the list. Lists can contain claims of different
+// types, but sorting preserves order only between claims of the same type.
+
+// AddToList makes the claim an element of the list at the given position by adding
+// LIST and ORDER meta claims to it. IDs of meta claims are derived from the claim's ID.
+func AddToList(claim Claim, list identifier.Identifier, order int) errors.E {
+	if _, _, ok := ListElement(claim); ok {
+		errE := errors.New("claim is already an element of a list")
+		errors.Details(errE)["claim"] = claim.GetID().String()
+		return errE
+	}
+	errE := claim.Add(&IdentifierClaim{
+		CoreClaim: CoreClaim{
+			ID:         GetID(nameSpaceCoreProperties, claim.GetID().String(), "LIST", 0),
+			Confidence: HighConfidence,
+		},
+		Prop:  GetCorePropertyReference("LIST"),
+		Value: list.String(),
+	})
+	if errE != nil {
+		return errE
+	}
+	return claim.Add(&AmountClaim{
+		CoreClaim: CoreClaim{
+			ID:         GetID(nameSpaceCoreProperties, claim.GetID().String(), "ORDER", 0),
+			Confidence: HighConfidence,
+		},
+		Prop:   GetCorePropertyReference("ORDER"),
+		Amount: float64(order),
+		Unit:   AmountUnitNone,
+	})
+}
+
+// ListElement returns the list ID and the position of the claim in the list, if the claim
+// is an element of a list. When there are multiple LIST or ORDER meta claims, the ones with
+// the highest confidence are used.
+func ListElement(claim Claim) (string, float64, bool) {
+	var list *IdentifierClaim
+	for _, c := range claim.Get(GetCorePropertyID("LIST")) {
+		if l, ok := c.(*IdentifierClaim); ok && (list == nil || l.Confidence > list.Confidence) {
+			list = l
+		}
+	}
+	var order *AmountClaim
+	for _, c := range claim.Get(GetCorePropertyID("ORDER")) {
+		if o, ok := c.(*AmountClaim); ok && (order == nil || o.Confidence > order.Confidence) {
+			order = o
+		}
+	}
+	if list == nil || order == nil {
+		return "", 0, false
+	}
+	return list.Value, order.Amount, true
+}
+
+// SortLists sorts elements of every list by their position in the list, also
+// in meta claims. Elements are reordered only among positions which elements
+// of the same list already occupy, so other claims do not move.
+func (c *ClaimTypes) SortLists() {
+	if c == nil {
+		return
+	}
+	sortListElements(c.Identifier)
+	sortListElements(c.Reference)
+	sortListElements(c.Text)
+	sortListElements(c.String)
+	sortListElements(c.Amount)
+	sortListElements(c.AmountRange)
+	sortListElements(c.Relation)
+	sortListElements(c.File)
+	sortListElements(c.NoValue)
+	sortListElements(c.UnknownValue)
+	sortListElements(c.Time)
+	sortListElements(c.TimeRange)
+}
+
+// SortLists sorts elements of every list in the document by their position in the list.
+// See ClaimTypes.SortLists.
+func (d *D) SortLists() {
+	d.Claims.SortLists()
+}
+
+func (cc *CoreClaim) sortLists() {
+	cc.Meta.SortLists()
+}
+
+type listClaim[T any] interface {
+	*T
+	Claim
+	sortLists()
+}
+
+func sortListElements[T any, P listClaim[T]](claims []T) {
+	type element struct {
+		Claim T
+		Order float64
+	}
+	// Positions occupied by elements of each list, and lists in order of appearance.
+	positions := map[string][]int{}
+	elements := map[string][]element{}
+	lists := []string{}
+	for i := range claims {
+		P(&claims[i]).sortLists()
+		list, order, ok := ListElement(P(&claims[i]))
+		if !ok {
+			continue
+		}
+		if _, ok := positions[list]; !ok {
+			lists = append(lists, list)
+		}
+		positions[list] = append(positions[list], i)
+		elements[list] = append(elements[list], element{Claim: claims[i], Order: order})
+	}
+	for _, list := range lists {
+		e := elements[list]
+		// Stable so that elements at the same position keep their relative order.
+		slices.SortStableFunc(e, func(a, b element) int {
+			if a.Order < b.Order {
+				return -1
+			} else if a.Order > b.Order {
+				return 1
+			}
+			return 0
+		})
+		for i, position := range positions[list] {
+			claims[position] = e[i].Claim
+		}
+	}
+}
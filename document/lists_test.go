@@ -0,0 +1,92 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func stringClaim(prop, value string) *document.StringClaim {
+	return &document.StringClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.GetCorePropertyReference(prop),
+		String:    value,
+	}
+}
+
+func TestSortLists(t *testing.T) {
+	t.Parallel()
+
+	tracks := identifier.New()
+	authors := identifier.New()
+
+	doc := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+
+	// Claims are added out of order, interleaved with claims not in lists.
+	for _, c := range []struct {
+		Value string
+		List  *identifier.Identifier
+		Order int
+	}{
+		{"track 3", &tracks, 2},
+		{"other", nil, 0},
+		{"author 2", &authors, 1},
+		{"track 1", &tracks, 0},
+		{"author 1", &authors, 0},
+		{"track 2", &tracks, 1},
+	} {
+		claim := stringClaim("NAME", c.Value)
+		if c.List != nil {
+			errE := document.AddToList(claim, *c.List, c.Order)
+			require.NoError(t, errE, "% -+#.1v", errE)
+		}
+		errE := doc.Add(claim)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	// A list in meta claims.
+	parent := doc.Claims.String[1].ID
+	for i, value := range []string{"b", "a"} {
+		claim := stringClaim("DESCRIPTION", value)
+		errE := document.AddToList(claim, tracks, 1-i)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		errE = doc.GetByID(parent).Add(claim)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	list, order, ok := document.ListElement(&doc.Claims.String[0])
+	assert.True(t, ok)
+	assert.Equal(t, tracks.String(), list)
+	assert.InDelta(t, 2.0, order, 0)
+	_, _, ok = document.ListElement(&doc.Claims.String[1])
+	assert.False(t, ok)
+
+	errE := document.AddToList(&doc.Claims.String[0], authors, 0)
+	assert.Error(t, errE)
+
+	doc.SortLists()
+
+	values := []string{}
+	for _, claim := range doc.Claims.String {
+		values = append(values, claim.String)
+	}
+	assert.Equal(t, []string{"track 1", "other", "author 1", "track 2", "author 2", "track 3"}, values)
+	assert.Equal(t, "a", doc.Claims.String[1].Meta.String[0].String)
+	assert.Equal(t, "b", doc.Claims.String[1].Meta.String[1].String)
+
+	// Order survives a round-trip.
+	data, errE := x.MarshalWithoutEscapeHTML(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	var doc2 document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc2)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	doc2.SortLists()
+	assert.Equal(t, doc, &doc2)
+}
@@ -0,0 +1,148 @@
+package document
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+// CoreNamespace is the mnemonic namespace of built-in core properties.
+const CoreNamespace = "CORE"
+
+var ErrUnknownMnemonic = errors.Base("unknown mnemonic")
+
+// MnemonicRegistration records a core property registered under a mnemonic by a namespace
+// (e.g., an importer).
+type MnemonicRegistration struct {
+	Namespace string `json:"namespace"`
+	// Mnemonic is the mnemonic of the core property. It is namespaced (e.g., "MOMA:MEDIUM")
+	// if the registration collides with an earlier registration of the same mnemonic.
+	Mnemonic        string                `json:"mnemonic"`
+	ID              identifier.Identifier `json:"id"`
+	DescriptionHTML string                `json:"description"`
+	Types           []string              `json:"types,omitempty"`
+}
+
+// sameMeaning returns true if both registrations describe the same property.
+func (r MnemonicRegistration) sameMeaning(other MnemonicRegistration) bool {
+	a := slices.Clone(r.Types)
+	b := slices.Clone(other.Types)
+	slices.Sort(a)
+	slices.Sort(b)
+	return r.DescriptionHTML == other.DescriptionHTML && slices.Equal(a, b)
+}
+
+// MnemonicCollision is a mnemonic registered with different meanings by multiple namespaces.
+type MnemonicCollision struct {
+	Mnemonic string `json:"mnemonic"`
+	// Registrations are in registration order. The mnemonic without
+	// a namespace resolves to the first one.
+	Registrations []MnemonicRegistration `json:"registrations"`
+}
+
+//nolint:gochecknoglobals
+var (
+	// mnemonicRegistrations is a map from a mnemonic (without a namespace)
+	// to all its registrations, in registration order.
+	mnemonicRegistrations = map[string][]MnemonicRegistration{}
+
+	// mnemonicNamespaces is a map from a namespace of documents which are not core
+	// properties to the UUID namespace their IDs are generated in.
+	mnemonicNamespaces = map[string]uuid.UUID{}
+)
+
+// RegisterMnemonicNamespace registers a namespace of documents which are not core properties
+// (e.g., "WD" for Wikidata entities), so that namespaced mnemonics (e.g., "WD:P569")
+// resolve to IDs of documents generated with GetID(namespace, mnemonic).
+func RegisterMnemonicNamespace(name string, namespace uuid.UUID) {
+	mnemonicNamespaces[name] = namespace
+}
+
+// registerMnemonic registers the core property under the mnemonic for the namespace.
+//
+// If the mnemonic has already been registered with a different meaning by another
+// namespace, the property is registered under the namespaced mnemonic instead, so that
+// the earlier property is not overwritten. It returns the mnemonic to generate the
+// property under and false if the property has already been generated.
+func registerMnemonic(namespace, mnemonic, descriptionHTML string, types []string) (string, bool) {
+	registration := MnemonicRegistration{
+		Namespace:       namespace,
+		Mnemonic:        mnemonic,
+		ID:              GetCorePropertyID(mnemonic),
+		DescriptionHTML: descriptionHTML,
+		Types:           types,
+	}
+
+	registrations := mnemonicRegistrations[mnemonic]
+	generate := true
+	for _, r := range registrations {
+		if r.sameMeaning(registration) {
+			registration.Mnemonic = r.Mnemonic
+			registration.ID = r.ID
+			generate = false
+			break
+		} else if r.Namespace == namespace {
+			panic(errors.Errorf(`mnemonic "%s" registered twice by namespace "%s"`, mnemonic, namespace))
+		}
+	}
+	if generate && len(registrations) > 0 {
+		registration.Mnemonic = namespace + ":" + mnemonic
+		registration.ID = GetCorePropertyID(registration.Mnemonic)
+	}
+
+	mnemonicRegistrations[mnemonic] = append(registrations, registration)
+	return registration.Mnemonic, generate
+}
+
+// ResolveMnemonic resolves the mnemonic to a document ID.
+//
+// A mnemonic without a namespace resolves to the core property first registered under it.
+// A namespaced mnemonic (e.g., "MOMA:MEDIUM") resolves to the core property registered
+// under the mnemonic by the namespace, or to a document in a namespace registered
+// with RegisterMnemonicNamespace (e.g., "WD:P569").
+func ResolveMnemonic(mnemonic string) (identifier.Identifier, errors.E) {
+	namespace, m, ok := strings.Cut(mnemonic, ":")
+	if !ok {
+		id := GetCorePropertyID(mnemonic)
+		if _, ok := CoreProperties[id]; ok {
+			return id, nil
+		}
+	} else if uuidNamespace, ok := mnemonicNamespaces[namespace]; ok {
+		return GetID(uuidNamespace, m), nil
+	} else {
+		for _, registration := range mnemonicRegistrations[m] {
+			if registration.Namespace == namespace {
+				return registration.ID, nil
+			}
+		}
+	}
+
+	errE := errors.WithStack(ErrUnknownMnemonic)
+	errors.Details(errE)["mnemonic"] = mnemonic
+	return identifier.Identifier{}, errE
+}
+
+// MnemonicCollisions returns all mnemonics registered with different meanings,
+// sorted by mnemonic.
+func MnemonicCollisions() []MnemonicCollision {
+	collisions := []MnemonicCollision{}
+	for mnemonic, registrations := range mnemonicRegistrations {
+		for _, registration := range registrations {
+			if registration.Mnemonic != mnemonic {
+				collisions = append(collisions, MnemonicCollision{
+					Mnemonic:      mnemonic,
+					Registrations: slices.Clone(registrations),
+				})
+				break
+			}
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		return collisions[i].Mnemonic < collisions[j].Mnemonic
+	})
+	return collisions
+}
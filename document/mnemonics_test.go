@@ -0,0 +1,75 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// TestMnemonicCollisions is not parallel because it registers core properties.
+func TestMnemonicCollisions(t *testing.T) { //nolint:paralleltest
+	assert.Empty(t, document.MnemonicCollisions())
+
+	document.GenerateNamespacedCoreProperties("FIRST", []struct {
+		Name            string
+		ExtraNames      []string
+		DescriptionHTML string
+		Types           []string
+	}{
+		{"test medium", nil, "A material an artwork is made of.", []string{`"relation" claim type`}},
+		{"test shared", nil, "A shared property.", []string{`"text" claim type`}},
+	})
+	document.GenerateNamespacedCoreProperties("SECOND", []struct {
+		Name            string
+		ExtraNames      []string
+		DescriptionHTML string
+		Types           []string
+	}{
+		{"test medium", nil, "A medium through which a work is published.", []string{`"relation" claim type`}},
+		{"test shared", nil, "A shared property.", []string{`"text" claim type`}},
+	})
+
+	collisions := document.MnemonicCollisions()
+	require.Len(t, collisions, 1)
+	assert.Equal(t, "TEST_MEDIUM", collisions[0].Mnemonic)
+	require.Len(t, collisions[0].Registrations, 2)
+	assert.Equal(t, "FIRST", collisions[0].Registrations[0].Namespace)
+	assert.Equal(t, "TEST_MEDIUM", collisions[0].Registrations[0].Mnemonic)
+	assert.Equal(t, "SECOND", collisions[0].Registrations[1].Namespace)
+	assert.Equal(t, "SECOND:TEST_MEDIUM", collisions[0].Registrations[1].Mnemonic)
+
+	// The earlier property is not overwritten.
+	property := document.CoreProperties[document.GetCorePropertyID("TEST_MEDIUM")]
+	assert.Equal(t, document.Mnemonic("TEST_MEDIUM"), property.Mnemonic)
+	assert.Equal(t, "A material an artwork is made of.", property.Claims.Text[1].HTML["en"])
+
+	for mnemonic, expected := range map[string]string{
+		"TEST_MEDIUM":        "TEST_MEDIUM",
+		"FIRST:TEST_MEDIUM":  "TEST_MEDIUM",
+		"SECOND:TEST_MEDIUM": "SECOND:TEST_MEDIUM",
+		"TEST_SHARED":        "TEST_SHARED",
+		"FIRST:TEST_SHARED":  "TEST_SHARED",
+		"SECOND:TEST_SHARED": "TEST_SHARED",
+		"CORE:NAME":          "NAME",
+	} {
+		id, errE := document.ResolveMnemonic(mnemonic)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, document.GetCorePropertyID(expected), id, mnemonic)
+		assert.Equal(t, document.GetCorePropertyID(expected), *document.GetCorePropertyReference(mnemonic).ID, mnemonic)
+	}
+
+	for _, mnemonic := range []string{"TEST_UNKNOWN", "SECOND:NAME", "UNKNOWN:TEST_MEDIUM"} {
+		_, errE := document.ResolveMnemonic(mnemonic)
+		assert.ErrorIs(t, errE, document.ErrUnknownMnemonic, mnemonic)
+	}
+
+	namespace := uuid.New()
+	document.RegisterMnemonicNamespace("TEST", namespace)
+	id, errE := document.ResolveMnemonic("TEST:P569")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, document.GetID(namespace, "P569"), id)
+}
@@ -696,14 +696,17 @@ type AmountClaimPatch struct {
 	Prop       *identifier.Identifier `exhaustruct:"optional" json:"prop,omitempty"`
 	Amount     *float64               `exhaustruct:"optional" json:"amount,omitempty"`
 	Unit       *AmountUnit            `exhaustruct:"optional" json:"unit,omitempty"`
+	// Decimal sets both the exact decimal representation and the amount.
+	// It cannot be used together with Amount.
+	Decimal *string `exhaustruct:"optional" json:"decimal,omitempty"`
 }
 
 func (p AmountClaimPatch) New(id identifier.Identifier) (Claim, errors.E) { //nolint:ireturn
-	if p.Confidence == nil || p.Prop == nil || p.Amount == nil || p.Unit == nil {
+	if p.Confidence == nil || p.Prop == nil || (p.Amount == nil && p.Decimal == nil) || p.Unit == nil {
 		return nil, errors.New("incomplete patch")
 	}
 
-	return &AmountClaim{
+	c := &AmountClaim{
 		CoreClaim: CoreClaim{
 			ID:         id,
 			Confidence: *p.Confidence,
@@ -711,13 +714,38 @@ func (p AmountClaimPatch) New(id identifier.Identifier) (Claim, errors.E) { //no
 		Prop: Reference{
 			ID: p.Prop,
 		},
-		Amount: *p.Amount,
-		Unit:   *p.Unit,
-	}, nil
+		Unit: *p.Unit,
+	}
+
+	errE := p.applyAmount(c)
+	if errE != nil {
+		return nil, errE
+	}
+
+	return c, nil
+}
+
+func (p AmountClaimPatch) applyAmount(c *AmountClaim) errors.E {
+	if p.Amount != nil && p.Decimal != nil {
+		return errors.New("both amount and decimal provided")
+	}
+	if p.Decimal != nil {
+		amount, decimal, errE := ParseDecimalAmount(*p.Decimal)
+		if errE != nil {
+			return errE
+		}
+		c.Amount = amount
+		c.Decimal = decimal
+	} else if p.Amount != nil {
+		c.Amount = *p.Amount
+		// Any existing exact decimal representation is not valid anymore.
+		c.Decimal = ""
+	}
+	return nil
 }
 
 func (p AmountClaimPatch) Apply(claim Claim) errors.E {
-	if p.Confidence == nil && p.Prop == nil && p.Amount == nil && p.Unit == nil {
+	if p.Confidence == nil && p.Prop == nil && p.Amount == nil && p.Unit == nil && p.Decimal == nil {
 		return errors.New("empty patch")
 	}
 
@@ -732,8 +760,9 @@ func (p AmountClaimPatch) Apply(claim Claim) errors.E {
 	if p.Prop != nil {
 		c.Prop.ID = p.Prop
 	}
-	if p.Amount != nil {
-		c.Amount = *p.Amount
+	errE := p.applyAmount(c)
+	if errE != nil {
+		return errE
 	}
 	if p.Unit != nil {
 		c.Unit = *p.Unit
@@ -945,6 +974,8 @@ type FileClaimPatch struct {
 	MediaType  *string                `exhaustruct:"optional" json:"mediaType,omitempty"`
 	URL        *string                `exhaustruct:"optional" json:"url,omitempty"`
 	Preview    []string               `exhaustruct:"optional" json:"preview"`
+	Blurhash   *string                `exhaustruct:"optional" json:"blurhash,omitempty"`
+	Checksum   *string                `exhaustruct:"optional" json:"checksum,omitempty"`
 }
 
 func (p FileClaimPatch) New(id identifier.Identifier) (Claim, errors.E) { //nolint:ireturn
@@ -952,6 +983,16 @@ func (p FileClaimPatch) New(id identifier.Identifier) (Claim, errors.E) { //noli
 		return nil, errors.New("incomplete patch")
 	}
 
+	blurhash := ""
+	if p.Blurhash != nil {
+		blurhash = *p.Blurhash
+	}
+
+	checksum := ""
+	if p.Checksum != nil {
+		checksum = *p.Checksum
+	}
+
 	return &FileClaim{
 		CoreClaim: CoreClaim{
 			ID:         id,
@@ -963,11 +1004,13 @@ func (p FileClaimPatch) New(id identifier.Identifier) (Claim, errors.E) { //noli
 		MediaType: *p.MediaType,
 		URL:       *p.URL,
 		Preview:   p.Preview,
+		Blurhash:  blurhash,
+		Checksum:  checksum,
 	}, nil
 }
 
 func (p FileClaimPatch) Apply(claim Claim) errors.E {
-	if p.Confidence == nil && p.Prop == nil && p.MediaType == nil && p.URL == nil && p.Preview == nil {
+	if p.Confidence == nil && p.Prop == nil && p.MediaType == nil && p.URL == nil && p.Preview == nil && p.Blurhash == nil && p.Checksum == nil {
 		return errors.New("empty patch")
 	}
 
@@ -991,6 +1034,12 @@ func (p FileClaimPatch) Apply(claim Claim) errors.E {
 	if p.Preview != nil {
 		c.Preview = p.Preview
 	}
+	if p.Blurhash != nil {
+		c.Blurhash = *p.Blurhash
+	}
+	if p.Checksum != nil {
+		c.Checksum = *p.Checksum
+	}
 
 	return nil
 }
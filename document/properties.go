@@ -81,12 +81,79 @@ var (
 			"Unit associated with an amount.",
 			nil,
 		},
+		{
+			"original amount",
+			nil,
+			"Amount as originally provided, before it was converted to a canonical unit.",
+			[]string{`"amount" claim type`, `single value`},
+		},
+		{
+			"validity",
+			[]string{"valid during", "period of validity", "in effect during"},
+			"Period of time during which a claim or a document is valid.",
+			[]string{`"time range" claim type`},
+		},
+		{
+			"minimum value",
+			[]string{"lower bound"},
+			"The smallest amount claims of a property can have.",
+			[]string{`"amount" claim type`, `single value`},
+		},
+		{
+			"maximum value",
+			[]string{"upper bound"},
+			"The largest amount claims of a property can have.",
+			[]string{`"amount" claim type`, `single value`},
+		},
 		{
 			"claim type",
 			nil,
 			"A property maps to a supported claim type.",
 			nil,
 		},
+		{
+			"single value",
+			[]string{"single-valued", "functional property"},
+			"A property has at most one claim per document (and per meta claims of a claim).",
+			nil,
+		},
+		{
+			"broader",
+			[]string{"parent", "subcategory of"},
+			"A document is narrower than (e.g., a subcategory of) another document. Filtering by a document matches narrower documents as well.",
+			[]string{`"relation" claim type`},
+		},
+		{
+			"expected property",
+			nil,
+			"Documents of a type are expected to have claims with a property.",
+			[]string{`"relation" claim type`},
+		},
+		{
+			"citation",
+			[]string{"provenance"},
+			"A property which, when used as a meta claim, cites a source of a claim.",
+			nil,
+		},
+		{
+			"citation required",
+			[]string{"citation needed"},
+			"Claims with a property require a citation of their source.",
+			nil,
+		},
+		{
+			"source URL",
+			[]string{"reference URL"},
+			"URL of a source of a claim.",
+			[]string{`"reference" claim type`, `citation`},
+		},
+		{
+			"completeness",
+			nil,
+			"Completeness score of a document, from 0 to 1: coverage of properties expected for its types " +
+				"and share of claims requiring a citation which have one.",
+			[]string{`"amount" claim type`, `single value`},
+		},
 		{
 			"description",
 			nil,
@@ -115,13 +182,13 @@ var (
 			"list",
 			nil,
 			"A list has an unique ID, even a list with just one element. All elements of a list share this ID.",
-			[]string{`"identifier" claim type`},
+			[]string{`"identifier" claim type`, `single value`},
 		},
 		{
 			"order",
 			nil,
 			"Order of an element inside its list. Smaller numbers are closer to the beginning of a list.",
-			[]string{`"amount" claim type`},
+			[]string{`"amount" claim type`, `single value`},
 		},
 		{
 			// TODO: How to define a property (type of relation) between parent and child?
@@ -206,12 +273,16 @@ var (
 	CoreProperties = map[identifier.Identifier]D{}
 )
 
+// GetCorePropertyReference returns a reference to the core property for the mnemonic,
+// which can also be namespaced (e.g., "MOMA:MEDIUM"). See ResolveMnemonic.
 func GetCorePropertyReference(mnemonic string) Reference {
-	property, ok := CoreProperties[GetCorePropertyID(mnemonic)]
-	if !ok {
-		panic(errors.Errorf(`core property for mnemonic "%s" cannot be found`, mnemonic))
+	id, errE := ResolveMnemonic(mnemonic)
+	if errE == nil {
+		if property, ok := CoreProperties[id]; ok {
+			return property.Reference()
+		}
 	}
-	return property.Reference()
+	panic(errors.Errorf(`core property for mnemonic "%s" cannot be found`, mnemonic))
 }
 
 func getMnemonic(data string) string {
@@ -241,15 +312,36 @@ func getPropertyClaimID(propertyMnemonic, claimMnemonic string, i int, args ...i
 	return GetID(nameSpaceCoreProperties, a...)
 }
 
+// GenerateCoreProperties generates built-in core properties. Importers should use
+// GenerateNamespacedCoreProperties instead.
 func GenerateCoreProperties(properties []struct {
 	Name            string
 	ExtraNames      []string
 	DescriptionHTML string
 	Types           []string
 },
+) {
+	GenerateNamespacedCoreProperties(CoreNamespace, properties)
+}
+
+// GenerateNamespacedCoreProperties generates core properties contributed by the namespace
+// (e.g., "MOMA" for an importer).
+//
+// Properties are registered under their mnemonics. If a mnemonic has already been
+// registered with a different meaning, the property is generated under the namespaced
+// mnemonic (e.g., "MOMA:MEDIUM") instead and the collision is reported by MnemonicCollisions.
+func GenerateNamespacedCoreProperties(namespace string, properties []struct {
+	Name            string
+	ExtraNames      []string
+	DescriptionHTML string
+	Types           []string
+},
 ) {
 	for _, property := range properties {
-		mnemonic := getMnemonic(property.Name)
+		mnemonic, generate := registerMnemonic(namespace, getMnemonic(property.Name), property.DescriptionHTML, property.Types)
+		if !generate {
+			continue
+		}
 		id := GetCorePropertyID(mnemonic)
 		CoreProperties[id] = D{
 			CoreDocument: CoreDocument{
@@ -339,9 +431,12 @@ func generateAllCoreProperties() {
 
 	for _, claimType := range claimTypes {
 		name := fmt.Sprintf(`"%s" claim type`, claimType)
-		mnemonic := getMnemonic(name)
-		id := GetCorePropertyID(mnemonic)
 		description := fmt.Sprintf(`The property is useful with the "%s" claim type.`, claimType)
+		mnemonic, generate := registerMnemonic(CoreNamespace, getMnemonic(name), html.EscapeString(description), []string{"CLAIM_TYPE"})
+		if !generate {
+			continue
+		}
+		id := GetCorePropertyID(mnemonic)
 		CoreProperties[id] = D{
 			CoreDocument: CoreDocument{
 				ID:    id,
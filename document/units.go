@@ -0,0 +1,301 @@
+package document
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidUnit = errors.Base("invalid unit")
+
+// Unit describes how to convert an amount in a unit to an amount in a canonical amount unit,
+// which determines the dimension of the unit.
+//
+// Converted amount is computed as amount * Factor + Offset.
+type Unit struct {
+	Symbol    string
+	Name      string
+	Canonical AmountUnit
+	Factor    *big.Rat
+	Offset    *big.Rat
+}
+
+// Convert converts amount to the canonical amount unit.
+func (u Unit) Convert(amount *big.Rat) *big.Rat {
+	res := new(big.Rat).Mul(amount, u.Factor)
+	return res.Add(res, u.Offset)
+}
+
+// ConvertFloat64 is like Convert, but for float64 amounts.
+func (u Unit) ConvertFloat64(amount float64) float64 {
+	a := new(big.Rat).SetFloat64(amount)
+	if a == nil {
+		// Infinity or NaN.
+		return amount
+	}
+	res, _ := u.Convert(a).Float64()
+	return res
+}
+
+func (u Unit) equal(other Unit) bool {
+	return u.Symbol == other.Symbol && u.Canonical == other.Canonical && u.Factor.Cmp(other.Factor) == 0 && u.Offset.Cmp(other.Offset) == 0
+}
+
+// UnitDefinition defines a unit in units configuration files.
+//
+// Dimension is the symbol of the canonical amount unit (e.g., "J" for units of energy).
+// Factor and offset are rational numbers as decimals (e.g., "4184") or fractions (e.g., "5/9").
+type UnitDefinition struct {
+	Symbol    string `json:"symbol"           yaml:"symbol"`
+	Name      string `json:"name,omitempty"   yaml:"name,omitempty"`
+	Dimension string `json:"dimension"        yaml:"dimension"`
+	Factor    string `json:"factor"           yaml:"factor"`
+	Offset    string `json:"offset,omitempty" yaml:"offset,omitempty"`
+}
+
+// Unit returns the unit defined by the definition.
+func (d UnitDefinition) Unit() (Unit, errors.E) {
+	if d.Symbol == "" {
+		return Unit{}, errors.WithMessage(ErrInvalidUnit, "missing symbol")
+	}
+
+	canonical, errE := ParseAmountUnit(d.Dimension)
+	if errE != nil {
+		errors.Details(errE)["symbol"] = d.Symbol
+		return Unit{}, errE
+	}
+
+	factor, ok := new(big.Rat).SetString(d.Factor)
+	if !ok {
+		errE := errors.WithMessage(ErrInvalidUnit, "invalid factor")
+		errors.Details(errE)["symbol"] = d.Symbol
+		errors.Details(errE)["factor"] = d.Factor
+		return Unit{}, errE
+	}
+
+	offset := new(big.Rat)
+	if d.Offset != "" {
+		offset, ok = offset.SetString(d.Offset)
+		if !ok {
+			errE := errors.WithMessage(ErrInvalidUnit, "invalid offset")
+			errors.Details(errE)["symbol"] = d.Symbol
+			errors.Details(errE)["offset"] = d.Offset
+			return Unit{}, errE
+		}
+	}
+
+	return Unit{
+		Symbol:    d.Symbol,
+		Name:      d.Name,
+		Canonical: canonical,
+		Factor:    factor,
+		Offset:    offset,
+	}, nil
+}
+
+func coreUnit(symbol, name string, canonical AmountUnit, factor string) Unit {
+	return coreUnitWithOffset(symbol, name, canonical, factor, "0")
+}
+
+func coreUnitWithOffset(symbol, name string, canonical AmountUnit, factor, offset string) Unit {
+	u, errE := UnitDefinition{
+		Symbol:    symbol,
+		Name:      name,
+		Dimension: canonical.String(),
+		Factor:    factor,
+		Offset:    offset,
+	}.Unit()
+	if errE != nil {
+		panic(errE)
+	}
+	return u
+}
+
+// CoreUnits are units available in every unit registry.
+//
+//nolint:gochecknoglobals
+var CoreUnits = []Unit{
+	// Canonical amount units.
+	coreUnit("1", "none", AmountUnitNone, "1"),
+	coreUnit("/", "ratio", AmountUnitRatio, "1"),
+	coreUnit("l", "litre", AmountUnitLitre, "1"),
+	coreUnit("kg/kg", "kilogram per kilogram", AmountUnitKilogramPerKilogram, "1"),
+	coreUnit("kg", "kilogram", AmountUnitKilogram, "1"),
+	coreUnit("kg/m³", "kilogram per cubic metre", AmountUnitKilogramPerCubicMetre, "1"),
+	coreUnit("m", "metre", AmountUnitMetre, "1"),
+	coreUnit("m²", "square metre", AmountUnitSquareMetre, "1"),
+	coreUnit("m/s", "metre per second", AmountUnitMetrePerSecond, "1"),
+	coreUnit("V", "volt", AmountUnitVolt, "1"),
+	coreUnit("W", "watt", AmountUnitWatt, "1"),
+	coreUnit("Pa", "pascal", AmountUnitPascal, "1"),
+	coreUnit("C", "coulomb", AmountUnitCoulomb, "1"),
+	coreUnit("J", "joule", AmountUnitJoule, "1"),
+	coreUnit("°C", "degree Celsius", AmountUnitCelsius, "1"),
+	coreUnit("rad", "radian", AmountUnitRadian, "1"),
+	coreUnit("Hz", "hertz", AmountUnitHertz, "1"),
+	coreUnit("$", "United States dollar", AmountUnitDollar, "1"),
+	coreUnit("B", "byte", AmountUnitByte, "1"),
+	coreUnit("px", "pixel", AmountUnitPixel, "1"),
+	coreUnit("s", "second", AmountUnitSecond, "1"),
+	coreUnit("€", "euro", AmountUnitEuro, "1"),
+	coreUnit("£", "pound sterling", AmountUnitPound, "1"),
+	coreUnit("¥", "Japanese yen", AmountUnitYen, "1"),
+
+	// Ratio.
+	coreUnit("%", "percent", AmountUnitRatio, "1/100"),
+	coreUnit("‰", "per mille", AmountUnitRatio, "1/1000"),
+	coreUnit("ppm", "parts per million", AmountUnitRatio, "1/1000000"),
+	coreUnit("ppb", "parts per billion", AmountUnitRatio, "1/1000000000"),
+
+	// Volume.
+	coreUnit("ml", "millilitre", AmountUnitLitre, "1/1000"),
+	coreUnit("cl", "centilitre", AmountUnitLitre, "1/100"),
+	coreUnit("dl", "decilitre", AmountUnitLitre, "1/10"),
+	coreUnit("m³", "cubic metre", AmountUnitLitre, "1000"),
+	coreUnit("gal", "US gallon", AmountUnitLitre, "3.785411784"),
+	coreUnit("fl oz", "US fluid ounce", AmountUnitLitre, "0.0295735295625"),
+
+	// Mass.
+	coreUnit("g", "gram", AmountUnitKilogram, "1/1000"),
+	coreUnit("mg", "milligram", AmountUnitKilogram, "1/1000000"),
+	coreUnit("µg", "microgram", AmountUnitKilogram, "1/1000000000"),
+	coreUnit("t", "tonne", AmountUnitKilogram, "1000"),
+	coreUnit("lb", "pound", AmountUnitKilogram, "0.45359237"),
+	coreUnit("oz", "ounce", AmountUnitKilogram, "0.028349523125"),
+
+	// Length and area.
+	coreUnit("km", "kilometre", AmountUnitMetre, "1000"),
+	coreUnit("cm", "centimetre", AmountUnitMetre, "1/100"),
+	coreUnit("mm", "millimetre", AmountUnitMetre, "1/1000"),
+	coreUnit("in", "inch", AmountUnitMetre, "0.0254"),
+	coreUnit("ft", "foot", AmountUnitMetre, "0.3048"),
+	coreUnit("yd", "yard", AmountUnitMetre, "0.9144"),
+	coreUnit("mi", "mile", AmountUnitMetre, "1609.344"),
+	coreUnit("km²", "square kilometre", AmountUnitSquareMetre, "1000000"),
+	coreUnit("ha", "hectare", AmountUnitSquareMetre, "10000"),
+
+	// Time.
+	coreUnit("min", "minute", AmountUnitSecond, "60"),
+	coreUnit("h", "hour", AmountUnitSecond, "3600"),
+	coreUnit("d", "day", AmountUnitSecond, "86400"),
+
+	// Energy and power.
+	coreUnit("kJ", "kilojoule", AmountUnitJoule, "1000"),
+	coreUnit("cal", "calorie", AmountUnitJoule, "4.184"),
+	coreUnit("kcal", "kilocalorie", AmountUnitJoule, "4184"),
+	coreUnit("Wh", "watt-hour", AmountUnitJoule, "3600"),
+	coreUnit("kWh", "kilowatt-hour", AmountUnitJoule, "3600000"),
+	coreUnit("kW", "kilowatt", AmountUnitWatt, "1000"),
+
+	// Pressure.
+	coreUnit("kPa", "kilopascal", AmountUnitPascal, "1000"),
+	coreUnit("bar", "bar", AmountUnitPascal, "100000"),
+	coreUnit("atm", "standard atmosphere", AmountUnitPascal, "101325"),
+
+	// Temperature.
+	coreUnitWithOffset("K", "kelvin", AmountUnitCelsius, "1", "-273.15"),
+	coreUnitWithOffset("°F", "degree Fahrenheit", AmountUnitCelsius, "5/9", "-160/9"),
+
+	// Other.
+	coreUnit("km/h", "kilometre per hour", AmountUnitMetrePerSecond, "5/18"),
+	coreUnit("°", "degree", AmountUnitRadian, "0.017453292519943295769236907684886127134428718885417254560971914"),
+	coreUnit("kHz", "kilohertz", AmountUnitHertz, "1000"),
+	coreUnit("MHz", "megahertz", AmountUnitHertz, "1000000"),
+	coreUnit("kB", "kilobyte", AmountUnitByte, "1000"),
+	coreUnit("MB", "megabyte", AmountUnitByte, "1000000"),
+	coreUnit("GB", "gigabyte", AmountUnitByte, "1000000000"),
+}
+
+// ParseCurrency returns the amount unit of the currency with the ISO 4217 code (e.g., "EUR")
+// or the symbol (e.g., "€"). Every currency is its own dimension: amounts in different
+// currencies are not converted between each other.
+func ParseCurrency(s string) (AmountUnit, errors.E) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "USD", "$":
+		return AmountUnitDollar, nil
+	case "EUR", "€":
+		return AmountUnitEuro, nil
+	case "GBP", "£":
+		return AmountUnitPound, nil
+	case "JPY", "¥":
+		return AmountUnitYen, nil
+	default:
+		errE := errors.WithMessage(ErrInvalidUnit, "unsupported currency")
+		errors.Details(errE)["currency"] = s
+		return 0, errE
+	}
+}
+
+// UnitRegistry maps unit symbols to units.
+type UnitRegistry map[string]Unit
+
+// NewUnitRegistry returns a registry populated with core units.
+func NewUnitRegistry() UnitRegistry {
+	r := UnitRegistry{}
+	for _, u := range CoreUnits {
+		r[u.Symbol] = u
+	}
+	return r
+}
+
+// Register adds the unit to the registry. It is an error to register
+// a different unit with the symbol of an already registered unit.
+func (r UnitRegistry) Register(u Unit) errors.E {
+	if u.Symbol == "" || u.Factor == nil || u.Offset == nil {
+		return errors.WithMessage(ErrInvalidUnit, "incomplete unit")
+	}
+	if u.Canonical == AmountUnitCustom || u.Canonical < 0 || u.Canonical >= AmountUnitsTotal {
+		errE := errors.WithMessage(ErrInvalidUnit, "invalid dimension")
+		errors.Details(errE)["symbol"] = u.Symbol
+		return errE
+	}
+	if u.Factor.Sign() == 0 {
+		errE := errors.WithMessage(ErrInvalidUnit, "zero factor")
+		errors.Details(errE)["symbol"] = u.Symbol
+		return errE
+	}
+	if existing, ok := r[u.Symbol]; ok {
+		if existing.equal(u) {
+			return nil
+		}
+		errE := errors.WithMessage(ErrInvalidUnit, "already registered")
+		errors.Details(errE)["symbol"] = u.Symbol
+		return errE
+	}
+	r[u.Symbol] = u
+	return nil
+}
+
+// Lookup returns the unit with the symbol.
+func (r UnitRegistry) Lookup(symbol string) (Unit, bool) {
+	u, ok := r[symbol]
+	return u, ok
+}
+
+// Load registers units from a YAML (or JSON) list of unit definitions.
+func (r UnitRegistry) Load(data []byte) errors.E {
+	var definitions []UnitDefinition
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err := decoder.Decode(&definitions)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.WithStack(err)
+	}
+
+	for _, definition := range definitions {
+		u, errE := definition.Unit()
+		if errE != nil {
+			return errE
+		}
+		errE = r.Register(u)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package document_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestParseAmountUnit(t *testing.T) {
+	t.Parallel()
+
+	for u := document.AmountUnitCustom; u < document.AmountUnitsTotal; u++ {
+		parsed, errE := document.ParseAmountUnit(u.String())
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, u, parsed)
+	}
+
+	_, errE := document.ParseAmountUnit("furlong")
+	assert.Error(t, errE)
+}
+
+func TestParseCurrency(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		currency string
+		expected document.AmountUnit
+	}{
+		{"USD", document.AmountUnitDollar},
+		{"eur", document.AmountUnitEuro},
+		{" GBP ", document.AmountUnitPound},
+		{"¥", document.AmountUnitYen},
+	} {
+		unit, errE := document.ParseCurrency(tt.currency)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, tt.expected, unit, tt.currency)
+	}
+
+	_, errE := document.ParseCurrency("XTS")
+	assert.ErrorIs(t, errE, document.ErrInvalidUnit)
+}
+
+func TestUnitRegistry(t *testing.T) {
+	t.Parallel()
+
+	units := document.NewUnitRegistry()
+
+	for _, tt := range []struct {
+		symbol    string
+		amount    float64
+		canonical document.AmountUnit
+		expected  float64
+	}{
+		{"kg", 2, document.AmountUnitKilogram, 2},
+		{"g", 250, document.AmountUnitKilogram, 0.25},
+		{"in", 10, document.AmountUnitMetre, 0.254},
+		{"kcal", 2, document.AmountUnitJoule, 8368},
+		{"ppm", 5, document.AmountUnitRatio, 0.000005},
+		{"°F", 212, document.AmountUnitCelsius, 100},
+		{"K", 0, document.AmountUnitCelsius, -273.15},
+	} {
+		t.Run(tt.symbol, func(t *testing.T) {
+			t.Parallel()
+
+			u, ok := units.Lookup(tt.symbol)
+			require.True(t, ok)
+			assert.Equal(t, tt.canonical, u.Canonical)
+			assert.InDelta(t, tt.expected, u.ConvertFloat64(tt.amount), 1e-9)
+		})
+	}
+
+	u, _ := units.Lookup("°F")
+	assert.Zero(t, u.Convert(big.NewRat(32, 1)).Sign())
+
+	_, ok := units.Lookup("cup")
+	assert.False(t, ok)
+}
+
+func TestUnitRegistryLoad(t *testing.T) {
+	t.Parallel()
+
+	units := document.NewUnitRegistry()
+	errE := units.Load([]byte(`
+- symbol: cup
+  name: US cup
+  dimension: l
+  factor: "0.2365882365"
+- symbol: Btu
+  dimension: J
+  factor: "1055.05585262"
+# Re-registering an identical unit is allowed.
+- symbol: "in"
+  dimension: m
+  factor: "0.0254"
+`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	u, ok := units.Lookup("cup")
+	require.True(t, ok)
+	assert.Equal(t, "US cup", u.Name)
+	assert.Equal(t, document.AmountUnitLitre, u.Canonical)
+	assert.InDelta(t, 0.473176473, u.ConvertFloat64(2), 1e-9)
+
+	// JSON works as well.
+	errE = units.Load([]byte(`[{"symbol": "mmHg", "dimension": "Pa", "factor": "133.322387415"}]`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	_, ok = units.Lookup("mmHg")
+	assert.True(t, ok)
+
+	for _, data := range []string{
+		// Conflicting re-registration.
+		`[{"symbol": "in", "dimension": "m", "factor": "0.03"}]`,
+		// Unknown dimension.
+		`[{"symbol": "furlong", "dimension": "chain", "factor": "10"}]`,
+		// Custom dimension.
+		`[{"symbol": "furlong", "dimension": "@", "factor": "10"}]`,
+		// Invalid factor.
+		`[{"symbol": "furlong", "dimension": "m", "factor": "long"}]`,
+		// Zero factor.
+		`[{"symbol": "furlong", "dimension": "m", "factor": "0"}]`,
+		// Missing symbol.
+		`[{"dimension": "m", "factor": "201.168"}]`,
+		// Unknown field.
+		`[{"symbol": "furlong", "dimension": "m", "factor": "201.168", "foo": "bar"}]`,
+	} {
+		errE := units.Load([]byte(data))
+		assert.Error(t, errE, data)
+	}
+	_, ok = units.Lookup("furlong")
+	assert.False(t, ok)
+}
@@ -0,0 +1,375 @@
+package document
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+var (
+	ErrInvalidClaimType = errors.Base("invalid claim type")
+	ErrValueOutOfRange  = errors.Base("value out of range")
+)
+
+// PropertyDataType describes values of claims a property expects.
+type PropertyDataType struct {
+	// ClaimTypes are claim types which can be used with the property. If empty, any claim type can be used.
+	ClaimTypes map[string]bool `json:"claimTypes,omitempty"`
+	// Unit is the unit amount and amount range claims with the property have to use.
+	Unit *AmountUnit `json:"unit,omitempty"`
+	// Minimum and Maximum are inclusive bounds of amounts of amount and amount range claims with the property.
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+}
+
+// Allows returns true if the claim type can be used with the property.
+func (t PropertyDataType) Allows(claimType string) bool {
+	return len(t.ClaimTypes) == 0 || t.ClaimTypes[claimType]
+}
+
+// claimTypesList returns allowed claim types, sorted.
+func (t PropertyDataType) claimTypesList() []string {
+	types := make([]string, 0, len(t.ClaimTypes))
+	for claimType := range t.ClaimTypes {
+		types = append(types, claimType)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// PropertyRegistry maps property IDs to data types of their claims.
+//
+// Claim types are determined by TYPE relation claims of property documents pointing
+// to claim type properties (e.g., `"time" claim type`). The unit is determined by an UNIT
+// string claim with the unit symbol (e.g., "m") and bounds by MINIMUM_VALUE and MAXIMUM_VALUE
+// amount claims of property documents.
+type PropertyRegistry map[identifier.Identifier]PropertyDataType
+
+// NewPropertyRegistry returns a registry populated with the given property documents.
+func NewPropertyRegistry(properties map[identifier.Identifier]D) PropertyRegistry {
+	r := PropertyRegistry{}
+	for _, property := range properties {
+		r.Add(&property)
+	}
+	return r
+}
+
+// Add adds the property document to the registry. Property documents which do not
+// declare any claim type, unit, or bounds are ignored.
+func (r PropertyRegistry) Add(property *D) {
+	claimTypeIDs := map[identifier.Identifier]string{}
+	for _, claimType := range claimTypes {
+		claimTypeIDs[GetCorePropertyID(getMnemonic(`"`+claimType+`" claim type`))] = claimType
+	}
+
+	dataType := PropertyDataType{
+		ClaimTypes: map[string]bool{},
+		Unit:       nil,
+		Minimum:    nil,
+		Maximum:    nil,
+	}
+
+	for _, claim := range property.Get(GetCorePropertyID("TYPE")) {
+		relation, ok := claim.(*RelationClaim)
+		if !ok || relation.To.ID == nil {
+			continue
+		}
+		claimType, ok := claimTypeIDs[*relation.To.ID]
+		if !ok {
+			continue
+		}
+		dataType.ClaimTypes[claimType] = true
+	}
+
+	for _, claim := range property.Get(GetCorePropertyID("UNIT")) {
+		str, ok := claim.(*StringClaim)
+		if !ok {
+			continue
+		}
+		unit, errE := ParseAmountUnit(str.String)
+		if errE == nil {
+			dataType.Unit = &unit
+		}
+	}
+
+	for _, claim := range property.Get(GetCorePropertyID("MINIMUM_VALUE")) {
+		if amount, ok := claim.(*AmountClaim); ok {
+			dataType.Minimum = &amount.Amount
+		}
+	}
+	for _, claim := range property.Get(GetCorePropertyID("MAXIMUM_VALUE")) {
+		if amount, ok := claim.(*AmountClaim); ok {
+			dataType.Maximum = &amount.Amount
+		}
+	}
+
+	if len(dataType.ClaimTypes) == 0 && dataType.Unit == nil && dataType.Minimum == nil && dataType.Maximum == nil {
+		return
+	}
+	r[property.ID] = dataType
+}
+
+// Lookup returns the data type of the property.
+func (r PropertyRegistry) Lookup(prop identifier.Identifier) (PropertyDataType, bool) {
+	dataType, ok := r[prop]
+	return dataType, ok
+}
+
+// CheckClaimType returns ErrInvalidClaimType if the claim type cannot be used with the property.
+// Properties not in the registry can be used with any claim type.
+func (r PropertyRegistry) CheckClaimType(prop identifier.Identifier, claimType string) errors.E {
+	dataType, ok := r[prop]
+	if !ok || dataType.Allows(claimType) {
+		return nil
+	}
+	errE := errors.WithMessagef(ErrInvalidClaimType, `property does not have "%s" claim type`, claimType)
+	errors.Details(errE)["prop"] = prop.String()
+	errors.Details(errE)["type"] = claimType
+	errors.Details(errE)["allowed"] = dataType.claimTypesList()
+	return errE
+}
+
+// CheckUnit returns ErrInvalidUnit if the property expects amounts in a different unit.
+func (r PropertyRegistry) CheckUnit(prop identifier.Identifier, unit AmountUnit) errors.E {
+	dataType, ok := r[prop]
+	if !ok || dataType.Unit == nil || *dataType.Unit == unit {
+		return nil
+	}
+	errE := errors.WithMessagef(ErrInvalidUnit, `property expects unit "%s"`, dataType.Unit.String())
+	errors.Details(errE)["prop"] = prop.String()
+	errors.Details(errE)["unit"] = unit.String()
+	errors.Details(errE)["expected"] = dataType.Unit.String()
+	return errE
+}
+
+// checkRange returns ErrValueOutOfRange if the amount is outside of bounds of the property.
+func (r PropertyRegistry) checkRange(prop identifier.Identifier, amount float64) errors.E {
+	dataType, ok := r[prop]
+	if !ok {
+		return nil
+	}
+	if (dataType.Minimum != nil && amount < *dataType.Minimum) || (dataType.Maximum != nil && amount > *dataType.Maximum) {
+		errE := errors.WithStack(ErrValueOutOfRange)
+		errors.Details(errE)["prop"] = prop.String()
+		errors.Details(errE)["amount"] = amount
+		if dataType.Minimum != nil {
+			errors.Details(errE)["minimum"] = *dataType.Minimum
+		}
+		if dataType.Maximum != nil {
+			errors.Details(errE)["maximum"] = *dataType.Maximum
+		}
+		return errE
+	}
+	return nil
+}
+
+// Validate checks that all claims (including meta claims) of the document and its
+// embedded children use claim types allowed for their properties and that amounts use
+// expected units and are inside bounds. Claims with properties not in the registry and "none"
+// and "unknown" claims are not checked. It also checks children (see D.ValidateChildren).
+func (r PropertyRegistry) Validate(doc *D) errors.E {
+	errE := doc.Visit(&validateVisitor{registry: r})
+	if errE != nil {
+		return errE
+	}
+	errE = doc.ValidateChildren()
+	if errE != nil {
+		return errE
+	}
+	for i := range doc.Children {
+		errE := doc.Children[i].Visit(&validateVisitor{registry: r})
+		if errE != nil {
+			errors.Details(errE)["child"] = doc.Children[i].ID.String()
+			return errE
+		}
+	}
+	return nil
+}
+
+// Coerce converts claims (including meta claims) of the document with claim types not allowed
+// for their properties into claims of an allowed claim type, when the conversion does not lose
+// information: between identifier and string claims, and from string claims with a number
+// into amount claims when the property expects a unit. It returns the number of converted claims.
+// Claims which cannot be converted are kept as they are (and Validate reports them).
+// Claims of embedded children are converted as well.
+func (r PropertyRegistry) Coerce(doc *D) (int, errors.E) {
+	count, errE := r.coerce(doc)
+	if errE != nil {
+		return count, errE
+	}
+	for i := range doc.Children {
+		c, errE := r.coerce(&doc.Children[i])
+		count += c
+		if errE != nil {
+			return count, errE
+		}
+	}
+	return count, nil
+}
+
+func (r PropertyRegistry) coerce(container ClaimsContainer) (int, errors.E) {
+	count := 0
+	for _, claim := range container.AllClaims() {
+		c, errE := r.coerce(claim)
+		if errE != nil {
+			return count, errE
+		}
+		count += c
+
+		coerced := r.coerceClaim(claim)
+		if coerced == nil {
+			continue
+		}
+		container.RemoveByID(claim.GetID())
+		errE = container.Add(coerced)
+		if errE != nil {
+			return count, errE
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (r PropertyRegistry) coerceClaim(claim Claim) Claim { //nolint:ireturn
+	switch c := claim.(type) {
+	case *IdentifierClaim:
+		dataType, ok := r.lookupReference(c.Prop)
+		if !ok || dataType.Allows("identifier") || !dataType.Allows("string") {
+			return nil
+		}
+		return &StringClaim{CoreClaim: c.CoreClaim, Prop: c.Prop, String: c.Value}
+	case *StringClaim:
+		dataType, ok := r.lookupReference(c.Prop)
+		if !ok || dataType.Allows("string") {
+			return nil
+		}
+		if dataType.Allows("identifier") {
+			return &IdentifierClaim{CoreClaim: c.CoreClaim, Prop: c.Prop, Value: c.String}
+		}
+		if dataType.Allows("amount") && dataType.Unit != nil {
+			amount, err := strconv.ParseFloat(strings.TrimSpace(c.String), 64)
+			if err != nil || math.IsInf(amount, 0) || math.IsNaN(amount) {
+				return nil
+			}
+			return &AmountClaim{CoreClaim: c.CoreClaim, Prop: c.Prop, Amount: amount, Unit: *dataType.Unit} //nolint:exhaustruct
+		}
+	}
+	return nil
+}
+
+func (r PropertyRegistry) lookupReference(prop Reference) (PropertyDataType, bool) {
+	if prop.ID == nil {
+		return PropertyDataType{}, false //nolint:exhaustruct
+	}
+	return r.Lookup(*prop.ID)
+}
+
+type validateVisitor struct {
+	registry PropertyRegistry
+}
+
+var _ Visitor = (*validateVisitor)(nil)
+
+func (v *validateVisitor) check(claim Claim, prop Reference, claimType string) (VisitResult, errors.E) {
+	if prop.ID != nil {
+		errE := v.registry.CheckClaimType(*prop.ID, claimType)
+		if errE == nil {
+			errE = v.checkAmount(claim, *prop.ID)
+		}
+		if errE != nil {
+			errors.Details(errE)["claim"] = claim.GetID().String()
+			return Keep, errE
+		}
+	}
+
+	errE := claim.Visit(v)
+	if errE != nil {
+		return Keep, errE
+	}
+	return Keep, nil
+}
+
+// checkAmount checks the unit and bounds of amount and amount range claims.
+func (v *validateVisitor) checkAmount(claim Claim, prop identifier.Identifier) errors.E {
+	switch c := claim.(type) {
+	case *AmountClaim:
+		errE := v.registry.CheckUnit(prop, c.Unit)
+		if errE != nil {
+			return errE
+		}
+		return v.registry.checkRange(prop, c.Amount)
+	case *AmountRangeClaim:
+		errE := v.registry.CheckUnit(prop, c.Unit)
+		if errE != nil {
+			return errE
+		}
+		errE = v.registry.checkRange(prop, c.Lower)
+		if errE != nil {
+			return errE
+		}
+		return v.registry.checkRange(prop, c.Upper)
+	}
+	return nil
+}
+
+func (v *validateVisitor) VisitIdentifier(claim *IdentifierClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "identifier")
+}
+
+func (v *validateVisitor) VisitReference(claim *ReferenceClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "reference")
+}
+
+func (v *validateVisitor) VisitText(claim *TextClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "text")
+}
+
+func (v *validateVisitor) VisitString(claim *StringClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "string")
+}
+
+func (v *validateVisitor) VisitAmount(claim *AmountClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "amount")
+}
+
+func (v *validateVisitor) VisitAmountRange(claim *AmountRangeClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "amount range")
+}
+
+func (v *validateVisitor) VisitRelation(claim *RelationClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "relation")
+}
+
+func (v *validateVisitor) VisitFile(claim *FileClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "file")
+}
+
+func (v *validateVisitor) VisitNoValue(claim *NoValueClaim) (VisitResult, errors.E) {
+	// "none" claims can be used with any property.
+	errE := claim.Visit(v)
+	if errE != nil {
+		return Keep, errE
+	}
+	return Keep, nil
+}
+
+func (v *validateVisitor) VisitUnknownValue(claim *UnknownValueClaim) (VisitResult, errors.E) {
+	// "unknown" claims can be used with any property.
+	errE := claim.Visit(v)
+	if errE != nil {
+		return Keep, errE
+	}
+	return Keep, nil
+}
+
+func (v *validateVisitor) VisitTime(claim *TimeClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "time")
+}
+
+func (v *validateVisitor) VisitTimeRange(claim *TimeRangeClaim) (VisitResult, errors.E) {
+	return v.check(claim, claim.Prop, "time range")
+}
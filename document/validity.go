@@ -0,0 +1,136 @@
+package document
+
+import (
+	"regexp"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// yearsPerPrecision maps year (and coarser) time precisions to the number of years they span.
+//
+//nolint:gochecknoglobals,mnd
+var yearsPerPrecision = map[TimePrecision]int{
+	TimePrecisionGigaYears:        1_000_000_000,
+	TimePrecisionHundredMegaYears: 100_000_000,
+	TimePrecisionTenMegaYears:     10_000_000,
+	TimePrecisionMegaYears:        1_000_000,
+	TimePrecisionHundredKiloYears: 100_000,
+	TimePrecisionTenKiloYears:     10_000,
+	TimePrecisionKiloYears:        1_000,
+	TimePrecisionHundredYears:     100,
+	TimePrecisionTenYears:         10,
+	TimePrecisionYear:             1,
+}
+
+// endOfPrecision returns the (exclusive) end of the period the timestamp denotes at the precision.
+// E.g., for year 1969 at year precision it returns the start of 1970.
+func endOfPrecision(t Timestamp, precision TimePrecision) time.Time {
+	tt := time.Time(t)
+	if years, ok := yearsPerPrecision[precision]; ok {
+		return tt.AddDate(years, 0, 0)
+	}
+	switch precision { //nolint:exhaustive
+	case TimePrecisionMonth:
+		return tt.AddDate(0, 1, 0)
+	case TimePrecisionDay:
+		return tt.AddDate(0, 0, 1)
+	case TimePrecisionHour:
+		return tt.Add(time.Hour)
+	case TimePrecisionMinute:
+		return tt.Add(time.Minute)
+	default:
+		return tt.Add(time.Second)
+	}
+}
+
+// GetValidity returns the validity period of the claim, stored as a VALIDITY time range
+// meta claim, or nil if the claim does not have one and is always valid.
+func GetValidity(claim Claim) *TimeRangeClaim {
+	for _, c := range claim.Get(GetCorePropertyID("VALIDITY")) {
+		if validity, ok := c.(*TimeRangeClaim); ok {
+			return validity
+		}
+	}
+	return nil
+}
+
+// ValidityPeriod returns the start (inclusive) and the end (exclusive) of the validity period
+// of the validity time range claim. The upper bound covers the whole period denoted by its precision,
+// e.g., validity with the upper bound 1969 at year precision ends at the start of 1970.
+func ValidityPeriod(validity *TimeRangeClaim) (time.Time, time.Time) {
+	return time.Time(validity.Lower), endOfPrecision(validity.Upper, validity.Precision)
+}
+
+func validAt(validity *TimeRangeClaim, at Timestamp) bool {
+	start, end := ValidityPeriod(validity)
+	t := time.Time(at)
+	return !t.Before(start) && t.Before(end)
+}
+
+// ValidAt returns true if the claim is valid at the given time.
+// Claims without a validity period are always valid.
+func ValidAt(claim Claim, at Timestamp) bool {
+	validity := GetValidity(claim)
+	if validity == nil {
+		return true
+	}
+	return validAt(validity, at)
+}
+
+// removeInvalidClaims removes claims (including meta claims) not valid at the given time.
+func removeInvalidClaims(container ClaimsContainer, at Timestamp) {
+	for _, claim := range container.AllClaims() {
+		if !ValidAt(claim, at) {
+			container.RemoveByID(claim.GetID())
+			continue
+		}
+		removeInvalidClaims(claim, at)
+	}
+}
+
+// AsOf removes claims of the document not valid at the given time.
+//
+// The document itself can have a validity period, stored as a top-level VALIDITY
+// time range claim. It returns false if the document is not valid at the given time.
+// Embedded children not valid at the given time are removed.
+func (d *D) AsOf(at Timestamp) bool {
+	for _, c := range d.Get(GetCorePropertyID("VALIDITY")) {
+		if validity, ok := c.(*TimeRangeClaim); ok && !validAt(validity, at) {
+			return false
+		}
+	}
+	removeInvalidClaims(d, at)
+	var children []D
+	for _, child := range d.Children {
+		if child.AsOf(at) {
+			children = append(children, child)
+		}
+	}
+	d.Children = children
+	return true
+}
+
+var partialTimeRegex = regexp.MustCompile(`^[+-]?\d{4,}(-\d{2}(-\d{2})?)?$`)
+
+// ParsePartialTimestamp parses a timestamp which can also be provided only with
+// the year (e.g., "1990"), the year and the month ("1990-05"), or the date ("1990-05-17").
+// Missing parts are set to their start.
+func ParsePartialTimestamp(s string) (Timestamp, errors.E) {
+	var t Timestamp
+	if match := partialTimeRegex.FindStringSubmatch(s); match != nil {
+		switch {
+		case match[2] != "":
+			s += "T00:00:00Z"
+		case match[1] != "":
+			s += "-01T00:00:00Z"
+		default:
+			s += "-01-01T00:00:00Z"
+		}
+	}
+	err := t.UnmarshalText([]byte(s))
+	if err != nil {
+		return t, errors.WithStack(err)
+	}
+	return t, nil
+}
@@ -0,0 +1,155 @@
+package document_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func mustParsePartialTimestamp(t *testing.T, s string) document.Timestamp {
+	t.Helper()
+
+	ts, errE := document.ParsePartialTimestamp(s)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	return ts
+}
+
+func validity(t *testing.T, lower, upper string, precision document.TimePrecision) *document.TimeRangeClaim {
+	t.Helper()
+
+	return &document.TimeRangeClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop:      document.GetCorePropertyReference("VALIDITY"),
+		Lower:     mustParsePartialTimestamp(t, lower),
+		Upper:     mustParsePartialTimestamp(t, upper),
+		Precision: precision,
+	}
+}
+
+func TestParsePartialTimestamp(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		input    string
+		expected string
+	}{
+		{"2005", "2005-01-01T00:00:00Z"},
+		{"2005-03", "2005-03-01T00:00:00Z"},
+		{"2005-03-17", "2005-03-17T00:00:00Z"},
+		{"2005-03-17T12:34:56Z", "2005-03-17T12:34:56Z"},
+		{"-10000", "-10000-01-01T00:00:00Z"},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			t.Parallel()
+
+			ts, errE := document.ParsePartialTimestamp(tt.input)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, tt.expected, ts.String())
+		})
+	}
+
+	for _, input := range []string{"", "05", "2005-3", "2005-03-17T12:34"} {
+		_, errE := document.ParsePartialTimestamp(input)
+		assert.Error(t, errE, input)
+	}
+}
+
+func TestValidAt(t *testing.T) {
+	t.Parallel()
+
+	claim := &document.StringClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop:   document.GetCorePropertyReference("NAME"),
+		String: "foobar",
+	}
+
+	assert.True(t, document.ValidAt(claim, mustParsePartialTimestamp(t, "2005")))
+
+	errE := claim.Add(validity(t, "1990", "2004", document.TimePrecisionYear))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.False(t, document.ValidAt(claim, mustParsePartialTimestamp(t, "1989-12-31")))
+	assert.True(t, document.ValidAt(claim, mustParsePartialTimestamp(t, "1990")))
+	// The upper bound covers the whole year.
+	assert.True(t, document.ValidAt(claim, mustParsePartialTimestamp(t, "2004-12-31")))
+	assert.False(t, document.ValidAt(claim, mustParsePartialTimestamp(t, "2005")))
+}
+
+func TestAsOf(t *testing.T) {
+	t.Parallel()
+
+	current := &document.StringClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop:   document.GetCorePropertyReference("NAME"),
+		String: "current",
+	}
+	errE := current.Add(validity(t, "2000", "2010", document.TimePrecisionYear))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	former := &document.StringClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop:   document.GetCorePropertyReference("NAME"),
+		String: "former",
+	}
+	errE = former.Add(validity(t, "1990", "1999", document.TimePrecisionYear))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	always := &document.StringClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop:   document.GetCorePropertyReference("DESCRIPTION"),
+		String: "always",
+	}
+
+	doc := &document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: document.HighConfidence,
+		},
+	}
+	for _, claim := range []document.Claim{current, former, always, validity(t, "1990", "2010", document.TimePrecisionYear)} {
+		errE = doc.Add(claim)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	validChild := document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: document.HighConfidence,
+		},
+	}
+	formerChild := document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: document.HighConfidence,
+		},
+	}
+	errE = formerChild.Add(validity(t, "1990", "1999", document.TimePrecisionYear))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	doc.Children = []document.D{validChild, formerChild}
+
+	assert.True(t, doc.AsOf(mustParsePartialTimestamp(t, "2005")))
+	assert.Equal(t, []document.Claim{current}, doc.Get(document.GetCorePropertyID("NAME")))
+	assert.Equal(t, []document.Claim{always}, doc.Get(document.GetCorePropertyID("DESCRIPTION")))
+	assert.Equal(t, []document.D{validChild}, doc.Children)
+
+	assert.False(t, doc.AsOf(mustParsePartialTimestamp(t, "2011")))
+}
@@ -379,7 +379,7 @@ func (v *GetByIDVisitor) VisitIdentifier(claim *IdentifierClaim) (VisitResult, e
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -391,7 +391,7 @@ func (v *GetByIDVisitor) VisitReference(claim *ReferenceClaim) (VisitResult, err
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -403,7 +403,7 @@ func (v *GetByIDVisitor) VisitText(claim *TextClaim) (VisitResult, errors.E) {
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -415,7 +415,7 @@ func (v *GetByIDVisitor) VisitString(claim *StringClaim) (VisitResult, errors.E)
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -427,7 +427,7 @@ func (v *GetByIDVisitor) VisitAmount(claim *AmountClaim) (VisitResult, errors.E)
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -439,7 +439,7 @@ func (v *GetByIDVisitor) VisitAmountRange(claim *AmountRangeClaim) (VisitResult,
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -451,7 +451,7 @@ func (v *GetByIDVisitor) VisitRelation(claim *RelationClaim) (VisitResult, error
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -463,7 +463,7 @@ func (v *GetByIDVisitor) VisitFile(claim *FileClaim) (VisitResult, errors.E) {
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -475,7 +475,7 @@ func (v *GetByIDVisitor) VisitNoValue(claim *NoValueClaim) (VisitResult, errors.
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -487,7 +487,7 @@ func (v *GetByIDVisitor) VisitUnknownValue(claim *UnknownValueClaim) (VisitResul
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -499,7 +499,7 @@ func (v *GetByIDVisitor) VisitTime(claim *TimeClaim) (VisitResult, errors.E) {
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -511,7 +511,7 @@ func (v *GetByIDVisitor) VisitTimeRange(claim *TimeRangeClaim) (VisitResult, err
 	}
 	errE := claim.Visit(v)
 	if v.Result != nil {
-		return v.Action, errE
+		return KeepAndStop, errE
 	}
 	return Keep, errE
 }
@@ -0,0 +1,72 @@
+package peerdb_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestRouteDocumentIncoming(t *testing.T) {
+	t.Parallel()
+
+	ts, service := startTestServer(t)
+
+	// Core properties have a TYPE relation claim pointing to the PROPERTY document.
+	target := document.GetCorePropertyID("PROPERTY").String()
+	referencing := document.GetCorePropertyID("NAME").String()
+
+	get := func(t require.TestingT, id string, qs url.Values) (int, []byte) {
+		path, errE := service.ReverseAPI("DocumentIncoming", waf.Params{"id": id}, qs)
+		require.NoError(t, errE, "% -+#.1v", errE)
+
+		resp, err := ts.Client().Get(ts.URL + path) //nolint:noctx
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		out, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, out
+	}
+
+	ids := func(t require.TestingT, out []byte) []string {
+		var results []struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal(out, &results))
+		ids := []string{}
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	// Populated documents might not be searchable yet.
+	assert.EventuallyWithT(t, func(c *assert.CollectT) {
+		status, out := get(c, target, nil)
+		require.Equal(c, http.StatusOK, status)
+		assert.Contains(c, ids(c, out), referencing)
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// Only relation claims with the given property are matched.
+	status, out := get(t, target, url.Values{"property": {document.GetCorePropertyID("TYPE").String()}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, ids(t, out), referencing)
+
+	status, out = get(t, target, url.Values{"property": {document.GetCorePropertyID("NAME").String()}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, ids(t, out))
+
+	status, _ = get(t, "invalid", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = get(t, target, url.Values{"property": {"invalid"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+}
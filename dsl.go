@@ -0,0 +1,146 @@
+package peerdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+type searchQueryRequest struct {
+	// Query is the structured search query. See search.QueryNode.
+	Query json.RawMessage `json:"query"`
+	// AsOf limits the search to claims and documents valid at that time.
+	AsOf string `json:"asOf,omitempty"`
+	// Sort are sort specifications in the same format as the "sort" search results parameter.
+	Sort json.RawMessage `json:"sort,omitempty"`
+	// Size is the maximum number of results to return. Default and maximum is the site's page size.
+	Size int `json:"size,omitempty"`
+}
+
+// SearchQueryPost is a POST HTTP request handler which searches using a structured
+// search query (see search.QueryNode) instead of a search state, for programmatic clients
+// which need precise control over the search. The query is validated against the property
+// registry of the site and it is executed in the same way as search results of a search state
+// are (with site's field weights, name properties, and scoring functions). Search results
+// are returned in the same format as well.
+func (s *Service) SearchQueryPost(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	start := time.Now()
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	if !s.validateJSON(w, req, "searchQueryRequest", buffer) {
+		return
+	}
+
+	var r searchQueryRequest
+	errE := x.UnmarshalWithoutUnknownFields(buffer, &r)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	site := waf.MustGetSite[*Site](ctx)
+	// Settings are obtained once so that the whole request uses the same settings even if they are reloaded.
+	settings := site.settings()
+
+	node, errE := search.ParseQueryNode(r.Query)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+	errE = node.CheckRestricted(ctx)
+	if errE != nil {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
+	}
+	errE = site.checkProperties(ctx, func(registry document.PropertyRegistry, names search.PropertyNames) errors.E {
+		return node.Check(registry, names, settings.Limits)
+	})
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+	errE = node.ExpandNarrower(ctx, s.getSearchServiceClosure(req))
+	if errE != nil {
+		s.WithError(ctx, errE)
+	}
+
+	var asOf *document.Timestamp
+	if r.AsOf != "" {
+		t, errE := document.ParsePartialTimestamp(r.AsOf)
+		if errE != nil {
+			s.BadRequestWithError(w, req, errors.WithMessage(errE, `"asOf" is not a valid timestamp`))
+			return
+		}
+		asOf = &t
+	}
+
+	var sorts []search.Sort
+	if len(r.Sort) > 0 {
+		sorts, errE = search.ParseSorts(string(r.Sort))
+		if errE != nil {
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
+	}
+
+	size := settings.maxResults(false)
+	if r.Size != 0 {
+		errE = search.CheckLimit("size", r.Size, size)
+		if errE != nil {
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
+		size = r.Size
+	}
+
+	query := search.ScoredQuery(node.ToQuery(asOf, settings.FieldWeights, settings.NameProperties), settings.Scoring, time.Now())
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	res, errE := s.executeSearch(req, query, sorts, settings.TieBreakers, asOf, size, "", false)
+	m.Stop()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+	took := time.Duration(res.TookInMillis) * time.Millisecond
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = took
+	recordSlowQuery(req, start, nil, took, query)
+
+	results := make([]searchResult, len(res.Hits.Hits))
+	for i, hit := range res.Hits.Hits {
+		results[i] = searchResult{ID: hit.Id} //nolint:exhaustruct
+	}
+
+	// Total is a string or a number.
+	var total interface{}
+	if res.Hits.TotalHits.Relation == "gte" {
+		total = fmt.Sprintf("+%d", res.Hits.TotalHits.Value)
+	} else {
+		total = res.Hits.TotalHits.Value
+	}
+
+	// Results of documents redirected to the same canonical document are collapsed.
+	s.WriteJSON(w, req, site.redirectMap.collapseResults(results), map[string]interface{}{
+		"total": total,
+	})
+}
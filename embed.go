@@ -0,0 +1,209 @@
+package peerdb
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+	texttemplate "text/template"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// embedPage is a self-contained search widget page which can be embedded into other sites
+// using an iframe. It uses only the embeddable API.
+//
+//nolint:gochecknoglobals,lll
+var embedPage = htmltemplate.Must(htmltemplate.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+    <title>{{ .Title }}</title>
+    <style>
+      body { margin: 0; padding: 8px; font-family: system-ui, sans-serif; font-size: 14px; }
+      form { display: flex; gap: 4px; }
+      input { flex-grow: 1; padding: 4px; }
+      ol { padding-left: 24px; }
+      li { margin: 4px 0; }
+      .status { color: #666; }
+    </style>
+  </head>
+  <body>
+    <form>
+      <input type="search" name="q" value="{{ .Query }}" placeholder="Search {{ .Title }}" aria-label="Search" />
+      <button type="submit">Search</button>
+    </form>
+    <p class="status" hidden></p>
+    <ol></ol>
+    <script>
+      (() => {
+        const nameProp = {{ .NameProp }};
+        const size = {{ .Size }};
+        const form = document.querySelector("form");
+        const status = document.querySelector(".status");
+        const list = document.querySelector("ol");
+
+        function text(html) {
+          return new DOMParser().parseFromString(html, "text/html").body.textContent || "";
+        }
+
+        function docName(doc) {
+          for (const claim of (doc.claims && doc.claims.text) || []) {
+            if (claim.prop.id === nameProp) {
+              return text(claim.html.en || Object.values(claim.html)[0] || "");
+            }
+          }
+          return doc.id;
+        }
+
+        async function search(q) {
+          status.hidden = false;
+          status.textContent = "Searching...";
+          list.replaceChildren();
+          try {
+            const create = await fetch("/api/s/create", { method: "POST", body: new URLSearchParams({ q }) });
+            if (!create.ok) throw new Error(create.statusText);
+            const s = await create.json();
+            let results;
+            for (;;) {
+              const res = await fetch("/api/s/" + encodeURIComponent(s.s));
+              if (res.status === 409) {
+                await new Promise((resolve) => setTimeout(resolve, 100));
+                continue;
+              }
+              if (!res.ok) throw new Error(res.statusText);
+              results = (await res.json()).slice(0, size);
+              break;
+            }
+            const docs = await Promise.all(results.map(async (r) => (await fetch("/api/d/" + encodeURIComponent(r.id))).json()));
+            for (const doc of docs) {
+              const a = document.createElement("a");
+              a.href = "/d/" + encodeURIComponent(doc.id);
+              a.target = "_blank";
+              a.rel = "noopener";
+              a.textContent = docName(doc);
+              const li = document.createElement("li");
+              li.append(a);
+              list.append(li);
+            }
+            status.hidden = docs.length > 0;
+            status.textContent = "No results.";
+          } catch (err) {
+            status.hidden = false;
+            status.textContent = "Search failed: " + err.message;
+          }
+        }
+
+        form.addEventListener("submit", (event) => {
+          event.preventDefault();
+          search(form.q.value);
+        });
+        if (form.q.value) {
+          search(form.q.value);
+        }
+      })();
+    </script>
+  </body>
+</html>
+`))
+
+// embedScript is a script which third-party sites include to embed the search widget.
+// It inserts an iframe with the search widget after the script element.
+//
+// Supported script element attributes are data-query (initial search query) and data-height (in pixels).
+//
+//nolint:gochecknoglobals
+var embedScript = texttemplate.Must(texttemplate.New("embed.js").Parse(`(() => {
+  const script = document.currentScript;
+  const url = new URL({{ printf "%q" .Path }}, script.src);
+  if (script.dataset.query) {
+    url.searchParams.set("q", script.dataset.query);
+  }
+  const iframe = document.createElement("iframe");
+  iframe.src = url.href;
+  iframe.title = {{ printf "%q" .Title }};
+  iframe.style.width = "100%";
+  iframe.style.height = (parseInt(script.dataset.height, 10) || {{ .Height }}) + "px";
+  iframe.style.border = "0";
+  script.after(iframe);
+})();
+`))
+
+const (
+	// Number of search results shown by the embedded search widget.
+	embedResultsSize = 10
+	// Default height in pixels of the embedded search widget.
+	embedDefaultHeight = 400
+)
+
+func (s *Service) renderEmbed(w http.ResponseWriter, req *http.Request, mediaType string, render func(*bytes.Buffer) error) {
+	var buf bytes.Buffer
+	err := render(&buf)
+	if err != nil {
+		s.InternalServerErrorWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		_, _ = buf.WriteTo(w)
+	}
+}
+
+// Embed is a GET/HEAD HTTP request handler which returns the search widget to be embedded
+// into other sites using an iframe. Which sites can embed it is determined by site's CORS configuration.
+// Without CORS configuration the widget is not available.
+func (s *Service) Embed(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	site := waf.MustGetSite[*Site](req.Context())
+	settings := site.settings()
+
+	if settings.cors == nil {
+		s.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+settings.frameAncestors)
+	s.renderEmbed(w, req, "text/html; charset=utf-8", func(buf *bytes.Buffer) error {
+		return embedPage.Execute(buf, map[string]interface{}{
+			"Title":    site.Title,
+			"Query":    req.Form.Get("q"),
+			"NameProp": document.GetCorePropertyID("NAME").String(),
+			"Size":     embedResultsSize,
+		})
+	})
+}
+
+// EmbedJS is a GET/HEAD HTTP request handler which returns the script which embeds the search widget.
+// Without CORS configuration the script is not available.
+func (s *Service) EmbedJS(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	site := waf.MustGetSite[*Site](req.Context())
+	settings := site.settings()
+
+	if settings.cors == nil {
+		s.NotFound(w, req)
+		return
+	}
+
+	path, errE := s.Reverse("Embed", nil, nil)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	// The script is loaded by other sites, so CORS headers are set for origins allowed by site's CORS configuration.
+	settings.cors.HandlerFunc(w, req)
+	s.renderEmbed(w, req, "text/javascript; charset=utf-8", func(buf *bytes.Buffer) error {
+		return embedScript.Execute(buf, map[string]interface{}{
+			"Path":   path,
+			"Title":  site.Title,
+			"Height": embedDefaultHeight,
+		})
+	})
+}
@@ -0,0 +1,30 @@
+package peerdb_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteEmbed(t *testing.T) {
+	t.Parallel()
+
+	ts, service := startTestServer(t)
+
+	// Without CORS configuration, the widget is not available.
+	for _, route := range []string{"Embed", "EmbedJS"} {
+		path, errE := service.Reverse(route, nil, nil)
+		require.NoError(t, errE, "% -+#.1v", errE)
+
+		resp, err := ts.Client().Get(ts.URL + path) //nolint:noctx,bodyclose
+		if assert.NoError(t, err) {
+			t.Cleanup(func(r *http.Response) func() { return func() { r.Body.Close() } }(resp))
+			_, _ = io.Copy(io.Discard, resp.Body)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+		}
+	}
+}
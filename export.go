@@ -0,0 +1,157 @@
+package peerdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/waf"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/tasks"
+)
+
+// maxExportTaskResults is the maximum number of search results exported by one export task.
+const maxExportTaskResults = 100_000
+
+// exportResults paginates through all results of the search state (at most maxExportTaskResults)
+// and returns them as CSV with the columns.
+func (s *Service) exportResults(
+	ctx context.Context, site *Site, settings *siteSettings, sh *search.State, weights search.FieldWeights,
+	sorts []search.Sort, columns []csvColumn, progress tasks.Progress,
+) ([]byte, errors.E) {
+	getSearchService := func() *elastic.SearchService {
+		// Index is determined by the point in time, so it must not be set here.
+		return s.esClient.Search().FetchSource(false).TrackTotalHits(true).AllowPartialSearchResults(false)
+	}
+	openPointInTime := func() *elastic.OpenPointInTimeService {
+		return s.esClient.OpenPointInTime(site.Index)
+	}
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	err := writer.Write(csvHeader(columns))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	size := settings.maxResults(true)
+	session := ""
+	done := int64(0)
+	for {
+		page, errE := search.Paginate(
+			ctx, getSearchService, s.pointsInTime, openPointInTime,
+			sh, settings.Scoring, weights, settings.NameProperties, sorts, settings.TieBreakers, size,
+			site.Index, s.secret, session, s.paginationKeepAlive,
+		)
+		if errE != nil {
+			return nil, errE
+		}
+
+		results := make([]searchResult, len(page.Hits))
+		for i, hit := range page.Hits {
+			results[i] = searchResult{ID: hit.Id, Matched: nil}
+		}
+		// Results are collapsed only within the page.
+		for _, result := range site.redirectMap.collapseResults(results) {
+			row, errE := site.csvRow(ctx, result.ID, columns)
+			if errE != nil {
+				return nil, errE
+			}
+			err := writer.Write(row)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		done += int64(len(page.Hits))
+		progress(done, min(page.Total.Value, maxExportTaskResults))
+
+		if page.Session == "" || done >= maxExportTaskResults {
+			break
+		}
+		session = page.Session
+	}
+
+	writer.Flush()
+	err = writer.Error()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// SearchExportPost is a POST HTTP request handler which starts a task exporting all results of the search
+// state (at most maxExportTaskResults) as CSV into a stored file. The ID of the file is the result of
+// the task and the file can be downloaded from storage once the task succeeds. Optional "columns",
+// "sort", and "weights" parameters are the same as for SearchResultsGet. It requires the elevated role.
+func (s *Service) SearchExportPost(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	if !s.validateEmptyRequest(w, req) {
+		return
+	}
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+	site := waf.MustGetSite[*Site](ctx)
+
+	m := metrics.Duration(internal.MetricSearchState).Start()
+	sh := search.GetState(ctx, params["s"])
+	m.Stop()
+	if sh == nil {
+		s.NotFound(w, req)
+		return
+	}
+
+	if !sh.Ready() {
+		s.replyWithError(w, req, http.StatusConflict, errors.WithStack(search.ErrNotReady))
+		return
+	}
+
+	columns, errE := parseCSVColumns(req.Form["columns"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	requestWeights, errE := search.ParseFieldWeights(req.Form.Get("weights"))
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+	// Settings are obtained once so that the whole export uses the same settings even if they are reloaded.
+	settings := site.settings()
+	weights := settings.FieldWeights.Merge(requestWeights)
+
+	sorts, errE := search.ParseSorts(req.Form.Get("sort"))
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	s.startTask(w, req, "export", func(ctx context.Context, progress tasks.Progress) (string, errors.E) {
+		data, errE := s.exportResults(ctx, site, settings, sh, weights, sorts, columns, progress)
+		if errE != nil {
+			return "", errE
+		}
+
+		id, errE := site.storage.Put(ctx, data, csvMediaType, "results.csv")
+		if errE != nil {
+			return "", errE
+		}
+
+		return id.String(), nil
+	})
+}
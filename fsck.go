@@ -0,0 +1,356 @@
+package peerdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// Fix actions of FsckCommand.
+const (
+	FsckFixRetract = "retract"
+	FsckFixUnknown = "unknown"
+)
+
+// Types of document problems found by FsckCommand, in addition to claim problems found by document.Check.
+const (
+	// FsckProblemMalformedDocument is a document which cannot be parsed (e.g., because of a malformed ID).
+	FsckProblemMalformedDocument = "malformedDocument"
+	// FsckProblemMismatchedID is a document with a different ID than it is stored under.
+	FsckProblemMismatchedID = "mismatchedID"
+)
+
+// FsckCommand checks integrity of the latest version of all documents of all sites.
+//
+// It reports relation claims pointing to documents which do not exist, claims with properties
+// which do not exist, amounts with unknown units, and malformed or duplicate IDs. The report
+// is written as one JSON object per line. Relation claims pointing to redirected documents
+// are checked against their canonical documents.
+//
+// Optionally, problematic claims can be fixed by retracting them or by replacing them with
+// "unknown" claims. Claims with unknown properties or without IDs are always retracted.
+// Malformed documents and duplicate claim IDs are only reported.
+type FsckCommand struct {
+	Report string `default:"-"                         help:"Path of the report to write. Default: standard output."                                                              placeholder:"PATH"   yaml:"report"`
+	Fix    string `default:""  enum:",retract,unknown" help:"Fix problematic claims by retracting them (\"retract\") or by replacing them with \"unknown\" claims (\"unknown\")." placeholder:"ACTION" yaml:"fix"`
+}
+
+type fsckProblem struct {
+	Schema string                 `json:"schema"`
+	Doc    identifier.Identifier  `json:"doc"`
+	Type   string                 `json:"type"`
+	Claim  *identifier.Identifier `json:"claim,omitempty"`
+	Parent *identifier.Identifier `json:"parent,omitempty"`
+	Prop   *identifier.Identifier `json:"prop,omitempty"`
+	To     *identifier.Identifier `json:"to,omitempty"`
+	Unit   string                 `json:"unit,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	// Fixed is the fix action applied to the claim, if any.
+	Fixed string `json:"fixed,omitempty"`
+}
+
+type fsckStats struct {
+	Checked  int64
+	Problems int64
+	Fixed    int64
+}
+
+func (c *FsckCommand) Run(globals *Globals) (errE errors.E) { //nolint:nonamedreturns
+	// We stop gracefully on ctrl-c and TERM signal.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var report io.Writer = os.Stdout
+	if c.Report != "-" {
+		file, err := os.Create(c.Report)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer func() {
+			errE = errors.Join(errE, file.Close())
+		}()
+		report = file
+	}
+
+	dbpool, errE := internal.InitPostgres(ctx, string(globals.Postgres.URL), globals.Logger, getRequestWithFallback(globals.Logger))
+	if errE != nil {
+		return errE
+	}
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), globals.Logger, globals.Elastic.URL)
+	if errE != nil {
+		return errE
+	}
+
+	for _, site := range backupSites(globals) {
+		// We set fallback context values which are used to set application name on PostgreSQL connections.
+		siteCtx := context.WithValue(ctx, requestIDContextKey, "fsck")
+		siteCtx = context.WithValue(siteCtx, schemaContextKey, site.Schema)
+
+		s, _, _, esProcessor, _, _, _, errE := es.InitForSite(siteCtx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField, site.RestrictedProperties)
+		if errE != nil {
+			return errE
+		}
+
+		// Site is used only to load redirects.
+		redirectsSite := &Site{} //nolint:exhaustruct
+		errE = initRedirects(siteCtx, dbpool, redirectsSite)
+		if errE != nil {
+			esProcessor.Close()
+			errors.Details(errE)["schema"] = site.Schema
+			return errE
+		}
+
+		stats, errE := c.runSite(siteCtx, report, s, redirectsSite.redirectMap, site)
+		esProcessor.Close()
+		if errE != nil {
+			errors.Details(errE)["schema"] = site.Schema
+			return errE
+		}
+
+		globals.Logger.Info().Str("schema", site.Schema).
+			Int64("checked", stats.Checked).Int64("problems", stats.Problems).Int64("fixed", stats.Fixed).
+			Msg("checked documents")
+	}
+
+	globals.Logger.Info().Msg("Done.")
+
+	return nil
+}
+
+func (c *FsckCommand) runSite(
+	ctx context.Context, report io.Writer,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	redirects *redirectMap, site backupSite,
+) (fsckStats, errors.E) {
+	stats := fsckStats{}
+
+	// Existence of documents is cached because the same properties and
+	// popular documents are referenced by many documents.
+	existing := map[identifier.Identifier]bool{}
+	exists := func(id identifier.Identifier) (bool, errors.E) {
+		id, _ = redirects.resolve(id)
+		if e, ok := existing[id]; ok {
+			return e, nil
+		}
+		_, _, _, errE := s.GetLatest(ctx, id) //nolint:dogsled
+		if errors.Is(errE, store.ErrValueNotFound) {
+			existing[id] = false
+			return false, nil
+		} else if errE != nil {
+			errors.Details(errE)["doc"] = id.String()
+			return false, errE
+		}
+		existing[id] = true
+		return true, nil
+	}
+
+	var after *identifier.Identifier
+	for {
+		ids, errE := s.List(ctx, after)
+		if errE != nil {
+			return stats, errE
+		}
+		if len(ids) == 0 {
+			return stats, nil
+		}
+
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return stats, errors.WithStack(ctx.Err())
+			}
+
+			problems, errE := c.checkDocument(ctx, s, exists, site, id, &stats)
+			if errE != nil {
+				errors.Details(errE)["doc"] = id.String()
+				return stats, errE
+			}
+
+			for _, problem := range problems {
+				data, errE := x.MarshalWithoutEscapeHTML(problem)
+				if errE != nil {
+					return stats, errE
+				}
+				_, err := report.Write(append(data, '\n'))
+				if err != nil {
+					return stats, errors.WithStack(err)
+				}
+			}
+		}
+
+		after = &ids[len(ids)-1]
+	}
+}
+
+func (c *FsckCommand) checkDocument(
+	ctx context.Context,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	exists func(id identifier.Identifier) (bool, errors.E), site backupSite, id identifier.Identifier, stats *fsckStats,
+) ([]fsckProblem, errors.E) {
+	data, _, version, errE := s.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueDeleted) {
+		return nil, nil
+	} else if errE != nil {
+		return nil, errE
+	}
+	stats.Checked++
+
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		problem := fsckProblem{ //nolint:exhaustruct
+			Schema: site.Schema,
+			Doc:    id,
+			Type:   FsckProblemMalformedDocument,
+			Error:  errE.Error(),
+		}
+		if errors.Is(errE, document.ErrInvalidUnit) {
+			problem.Type = document.ProblemInvalidUnit
+			problem.Unit, _ = errors.AllDetails(errE)["unit"].(string)
+		}
+		stats.Problems++
+		return []fsckProblem{problem}, nil
+	}
+
+	result := []fsckProblem{}
+	if doc.ID != id {
+		result = append(result, fsckProblem{ //nolint:exhaustruct
+			Schema: site.Schema,
+			Doc:    id,
+			Type:   FsckProblemMismatchedID,
+			Error:  `document has ID "` + doc.ID.String() + `"`,
+		})
+	}
+
+	problems, errE := document.Check(&doc, exists)
+	if errE != nil {
+		return nil, errE
+	}
+	stats.Problems += int64(len(result) + len(problems))
+	if len(problems) == 0 {
+		return result, nil
+	}
+
+	fix := c.Fix
+	if doc.ID != id {
+		// We do not know which document the document is really about, so we do not change it.
+		fix = ""
+	}
+	changes, fixed, errE := fsckFix(&doc, problems, fix)
+	if errE != nil {
+		return nil, errE
+	}
+	if len(changes) > 0 {
+		errE = UpdateDocumentWithChanges(ctx, s, &doc, version, changes)
+		if errE != nil {
+			return nil, errE
+		}
+	}
+
+	for i, problem := range problems {
+		if fixed[i] != "" {
+			stats.Fixed++
+		}
+		result = append(result, fsckProblem{
+			Schema: site.Schema,
+			Doc:    id,
+			Type:   problem.Type,
+			Claim:  &problem.Claim,
+			Parent: problem.Parent,
+			Prop:   problem.Prop,
+			To:     problem.To,
+			Unit:   problem.Unit,
+			Error:  "",
+			Fixed:  fixed[i],
+		})
+	}
+
+	return result, nil
+}
+
+// fsckFix applies the fix action to problematic claims of the document and returns changes made
+// and for each problem the fix action applied to its claim (or an empty string if none was).
+//
+// With FsckFixUnknown, claims with dangling relations and invalid units are replaced with "unknown"
+// claims with the same ID and property, while other problematic claims are retracted. Meta claims
+// of replaced claims are not preserved.
+func fsckFix(doc *document.D, problems []document.Problem, fix string) (document.Changes, []string, errors.E) {
+	fixed := make([]string, len(problems))
+	if fix == "" {
+		return nil, fixed, nil
+	}
+
+	// Claims with duplicate IDs cannot be reliably changed by their ID.
+	duplicate := map[identifier.Identifier]bool{}
+	// Claims which cannot be replaced with "unknown" claims.
+	retract := map[identifier.Identifier]bool{}
+	for _, problem := range problems {
+		switch problem.Type {
+		case document.ProblemDuplicateClaimID:
+			duplicate[problem.Claim] = true
+		case document.ProblemUnknownProperty, document.ProblemMissingID:
+			retract[problem.Claim] = true
+		}
+	}
+
+	changes := document.Changes{}
+	actions := map[identifier.Identifier]string{}
+	for i, problem := range problems {
+		if duplicate[problem.Claim] {
+			continue
+		}
+		if action, ok := actions[problem.Claim]; ok {
+			// Claim with multiple problems has already been fixed.
+			fixed[i] = action
+			continue
+		}
+
+		claim := doc.GetByID(problem.Claim)
+		if claim == nil {
+			// Claim has been removed together with a claim it was a meta claim of.
+			continue
+		}
+
+		claimChanges := document.Changes{document.RemoveClaimChange{ID: problem.Claim}}
+		action := FsckFixRetract
+		if fix == FsckFixUnknown && !retract[problem.Claim] && problem.Prop != nil {
+			confidence := claim.GetConfidence()
+			claimChanges = append(claimChanges, document.AddClaimChange{
+				Under: problem.Parent,
+				ID:    problem.Claim,
+				Patch: document.UnknownValueClaimPatch{
+					Confidence: &confidence,
+					Prop:       problem.Prop,
+				},
+			})
+			action = FsckFixUnknown
+		}
+
+		for _, change := range claimChanges {
+			errE := change.Apply(doc)
+			if errE != nil {
+				errors.Details(errE)["claim"] = problem.Claim.String()
+				return nil, nil, errE
+			}
+		}
+		changes = append(changes, claimChanges...)
+		actions[problem.Claim] = action
+		fixed[i] = action
+	}
+
+	return changes, fixed, nil
+}
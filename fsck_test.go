@@ -0,0 +1,113 @@
+package peerdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestFsckFix(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	unknownProp := identifier.New()
+	existing := identifier.New()
+	missing := identifier.New()
+
+	exists := func(id identifier.Identifier) (bool, errors.E) {
+		return id == prop || id == existing, nil
+	}
+
+	newDoc := func(t *testing.T) (*document.D, identifier.Identifier, identifier.Identifier, identifier.Identifier) {
+		t.Helper()
+
+		relation := &document.RelationClaim{
+			CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+			Prop:      document.Reference{ID: &prop},                                                 //nolint:exhaustruct
+			To:        document.Reference{ID: &existing},                                             //nolint:exhaustruct
+		}
+		dangling := &document.RelationClaim{
+			CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.MediumConfidence}, //nolint:exhaustruct
+			Prop:      document.Reference{ID: &prop},                                                   //nolint:exhaustruct
+			To:        document.Reference{ID: &missing},                                                //nolint:exhaustruct
+		}
+		unknown := &document.StringClaim{
+			CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+			Prop:      document.Reference{ID: &unknownProp},                                          //nolint:exhaustruct
+			String:    "foo",
+		}
+		errE := relation.Add(dangling)
+		require.NoError(t, errE, "% -+#.1v", errE)
+
+		doc := &document.D{ //nolint:exhaustruct
+			CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+		}
+		errE = doc.Add(relation)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		errE = doc.Add(unknown)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		return doc, relation.ID, dangling.ID, unknown.ID
+	}
+
+	t.Run("none", func(t *testing.T) {
+		t.Parallel()
+
+		doc, _, _, _ := newDoc(t)
+		problems, errE := document.Check(doc, exists)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		require.Len(t, problems, 2)
+
+		changes, fixed, errE := fsckFix(doc, problems, "")
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Empty(t, changes)
+		assert.Equal(t, []string{"", ""}, fixed)
+	})
+
+	t.Run("retract", func(t *testing.T) {
+		t.Parallel()
+
+		doc, relationID, danglingID, unknownID := newDoc(t)
+		problems, errE := document.Check(doc, exists)
+		require.NoError(t, errE, "% -+#.1v", errE)
+
+		changes, fixed, errE := fsckFix(doc, problems, FsckFixRetract)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Len(t, changes, 2)
+		assert.Equal(t, []string{FsckFixRetract, FsckFixRetract}, fixed)
+		assert.NotNil(t, doc.GetByID(relationID))
+		assert.Nil(t, doc.GetByID(danglingID))
+		assert.Nil(t, doc.GetByID(unknownID))
+
+		problems, errE = document.Check(doc, exists)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		t.Parallel()
+
+		doc, relationID, danglingID, unknownID := newDoc(t)
+		problems, errE := document.Check(doc, exists)
+		require.NoError(t, errE, "% -+#.1v", errE)
+
+		_, fixed, errE := fsckFix(doc, problems, FsckFixUnknown)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.ElementsMatch(t, []string{FsckFixUnknown, FsckFixRetract}, fixed)
+		assert.Nil(t, doc.GetByID(unknownID))
+
+		// The dangling relation is replaced with an "unknown" meta claim.
+		claim := doc.GetByID(relationID).GetByID(danglingID)
+		require.IsType(t, &document.UnknownValueClaim{}, claim)             //nolint:exhaustruct
+		assert.Equal(t, &prop, claim.(*document.UnknownValueClaim).Prop.ID) //nolint:forcetypeassert,errcheck
+		assert.Equal(t, document.Confidence(document.MediumConfidence), claim.GetConfidence())
+
+		problems, errE = document.Check(doc, exists)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Empty(t, problems)
+	})
+}
@@ -0,0 +1,249 @@
+package peerdb
+
+import (
+	"context"
+	"io"
+	"net"
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/peerdbpb"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// grpcServer implements the gRPC API (see peerdbpb package) using sites of the service.
+type grpcServer struct {
+	peerdbpb.UnimplementedPeerDBServer
+
+	service *Service
+}
+
+// GRPCServer returns a gRPC server with the gRPC API of the service registered.
+//
+// The site of a request is determined by the authority (host) of the request, the same
+// as with the HTTP API. The caller's role is determined by the bearer token in the
+// "authorization" metadata.
+func (s *Service) GRPCServer() *grpc.Server {
+	server := grpc.NewServer()
+	peerdbpb.RegisterPeerDBServer(server, &grpcServer{service: s}) //nolint:exhaustruct
+	return server
+}
+
+// site returns the site of the request and the context of the request
+// with the caller's role and fallback context values set.
+func (g *grpcServer) site(ctx context.Context) (context.Context, *Site, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var site *Site
+	for _, authority := range md.Get(":authority") {
+		host, _, err := net.SplitHostPort(authority)
+		if err != nil {
+			// Authority without a port.
+			host = authority
+		}
+		site = g.service.Sites[host]
+	}
+	if site == nil {
+		return nil, nil, status.Error(codes.NotFound, "site not found")
+	}
+
+	role := RolePublic
+	for _, authorization := range md.Get("authorization") {
+		var errE errors.E
+		role, errE = site.authorizationRole(authorization)
+		if errE != nil {
+			return nil, nil, status.Error(codes.Unauthenticated, errE.Error())
+		}
+	}
+
+	ctx = withRole(ctx, site, role)
+	// We set fallback context values which are used to set application name on PostgreSQL connections.
+	ctx = context.WithValue(ctx, requestIDContextKey, "grpc")
+	ctx = context.WithValue(ctx, schemaContextKey, site.Schema)
+	if g.service.readOnly {
+		ctx = internal.WithReadOnly(ctx)
+	}
+
+	return ctx, site, nil
+}
+
+// error converts the error to a gRPC status error. Unexpected errors are logged.
+func (g *grpcServer) error(errE errors.E) error {
+	switch {
+	case errors.Is(errE, store.ErrValueNotFound):
+		return status.Error(codes.NotFound, errE.Error())
+	case errors.Is(errE, store.ErrValueDeleted):
+		return status.Error(codes.NotFound, errE.Error())
+	case errors.Is(errE, es.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, errE.Error())
+	case errors.Is(errE, store.ErrConflict):
+		return status.Error(codes.Aborted, errE.Error())
+	}
+	g.service.Logger.Error().Err(errE).Msg("gRPC error")
+	return status.Error(codes.Internal, "internal server error")
+}
+
+func (g *grpcServer) GetDocument(ctx context.Context, req *peerdbpb.GetDocumentRequest) (*peerdbpb.Document, error) {
+	ctx, site, err := g.site(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, errE := identifier.FromString(req.GetId())
+	if errE != nil {
+		return nil, status.Error(codes.InvalidArgument, errE.Error())
+	}
+
+	var data []byte
+	var version store.Version
+	if req.GetVersion() != nil {
+		changeset, errE := identifier.FromString(req.GetVersion().GetChangeset()) //nolint:govet
+		if errE != nil {
+			return nil, status.Error(codes.InvalidArgument, errE.Error())
+		}
+		version = store.Version{
+			Changeset: changeset,
+			Revision:  req.GetVersion().GetRevision(),
+		}
+		data, _, errE = site.store.Get(ctx, id, version)
+	} else {
+		data, _, version, errE = site.store.GetLatest(ctx, id)
+	}
+	if errE != nil {
+		return nil, g.error(errE)
+	}
+
+	data, errE = site.filterDocumentJSON(ctx, data)
+	if errE != nil {
+		return nil, g.error(errE)
+	}
+
+	return &peerdbpb.Document{
+		Id: id.String(),
+		Version: &peerdbpb.Version{
+			Changeset: version.Changeset.String(),
+			Revision:  version.Revision,
+		},
+		Json: data,
+	}, nil
+}
+
+func (g *grpcServer) Search(ctx context.Context, req *peerdbpb.SearchRequest) (*peerdbpb.SearchResponse, error) {
+	ctx, site, err := g.site(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results, total, errE := g.service.searchDocuments(ctx, site, req.GetQuery())
+	if errE != nil {
+		return nil, g.error(errE)
+	}
+
+	ids := make([]string, len(results))
+	for i, id := range results {
+		ids[i] = id.String()
+	}
+
+	return &peerdbpb.SearchResponse{
+		Ids:   ids,
+		Total: total,
+	}, nil
+}
+
+func (g *grpcServer) BulkIndex(stream grpc.ClientStreamingServer[peerdbpb.BulkIndexRequest, peerdbpb.BulkIndexResponse]) error {
+	ctx, site, err := g.site(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	if getRole(ctx) != RoleElevated {
+		return status.Error(codes.PermissionDenied, "elevated role required")
+	}
+	if g.service.readOnly {
+		return status.Error(codes.FailedPrecondition, errReadOnly.Error())
+	}
+
+	errE := site.Quota.Check(ctx)
+	if errE != nil {
+		return g.error(errE)
+	}
+
+	count := int64(0)
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&peerdbpb.BulkIndexResponse{Count: count})
+		} else if err != nil {
+			return err
+		}
+
+		var doc document.D
+		errE := x.UnmarshalWithoutUnknownFields(req.GetDocument().GetJson(), &doc)
+		if errE != nil {
+			return status.Errorf(codes.InvalidArgument, "document %d: %s", count, errE.Error())
+		}
+		if req.GetDocument().GetId() != "" && req.GetDocument().GetId() != doc.ID.String() {
+			return status.Errorf(codes.InvalidArgument, "document %d: ID does not match ID in JSON", count)
+		}
+
+		errE = site.properties().registry.Validate(&doc)
+		if errE != nil {
+			return status.Errorf(codes.InvalidArgument, "document %d: %s", count, errE.Error())
+		}
+
+		_, errE = upsertDocument(ctx, site.store, &doc)
+		if errE != nil {
+			errors.Details(errE)["doc"] = doc.ID.String()
+			return g.error(errE)
+		}
+
+		count++
+	}
+}
+
+func (g *grpcServer) ListProperties(ctx context.Context, _ *peerdbpb.ListPropertiesRequest) (*peerdbpb.ListPropertiesResponse, error) {
+	_, site, err := g.site(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	props := site.properties()
+	properties := make([]*peerdbpb.Property, 0, len(props.names))
+	for id, name := range props.names {
+		property := &peerdbpb.Property{ //nolint:exhaustruct
+			Id:       id.String(),
+			Mnemonic: name.Mnemonic,
+			Name:     name.Name,
+		}
+		if dataType, ok := props.registry[id]; ok {
+			for claimType := range dataType.ClaimTypes {
+				property.ClaimTypes = append(property.ClaimTypes, claimType)
+			}
+			slices.Sort(property.ClaimTypes)
+			if dataType.Unit != nil {
+				property.Unit = dataType.Unit.String()
+			}
+			property.Minimum = dataType.Minimum
+			property.Maximum = dataType.Maximum
+		}
+		properties = append(properties, property)
+	}
+	slices.SortFunc(properties, func(a, b *peerdbpb.Property) int {
+		return strings.Compare(a.GetId(), b.GetId())
+	})
+
+	return &peerdbpb.ListPropertiesResponse{
+		Properties: properties,
+	}, nil
+}
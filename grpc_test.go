@@ -0,0 +1,64 @@
+package peerdb_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/peerdbpb"
+)
+
+func TestGRPC(t *testing.T) {
+	t.Parallel()
+
+	_, service := startTestServer(t)
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := service.GRPCServer()
+	t.Cleanup(server.Stop)
+	go server.Serve(listener) //nolint:errcheck
+
+	// Authority determines the site.
+	conn, err := grpc.NewClient(
+		"passthrough:///localhost",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := peerdbpb.NewPeerDBClient(conn)
+
+	ctx := context.Background()
+
+	properties, err := client.ListProperties(ctx, &peerdbpb.ListPropertiesRequest{})
+	require.NoError(t, err)
+	assert.Len(t, properties.GetProperties(), len(document.CoreProperties))
+
+	nameID := document.GetCorePropertyID("NAME").String()
+
+	doc, err := client.GetDocument(ctx, &peerdbpb.GetDocumentRequest{Id: nameID}) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Equal(t, nameID, doc.GetId())
+	assert.Equal(t, int64(1), doc.GetVersion().GetRevision())
+	assert.NotEmpty(t, doc.GetJson())
+
+	_, err = client.GetDocument(ctx, &peerdbpb.GetDocumentRequest{Id: "invalid"}) //nolint:exhaustruct
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Bulk indexing requires the elevated role.
+	stream, err := client.BulkIndex(ctx)
+	require.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -19,7 +19,7 @@ func (s *Service) Home(w http.ResponseWriter, req *http.Request, _ waf.Params) {
 	w.WriteHeader(http.StatusEarlyHints)
 
 	if s.ProxyStaticTo != "" {
-		s.Proxy(w, req)
+		s.serveDevelopmentHTML(w, req)
 	} else {
 		s.ServeStaticFile(w, req, "/index.html")
 	}
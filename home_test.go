@@ -113,6 +113,12 @@ func TestRouteHome(t *testing.T) {
 func startTestServer(t *testing.T) (*httptest.Server, *peerdb.Service) {
 	t.Helper()
 
+	return startTestServerWithReadOnly(t, false)
+}
+
+func startTestServerWithReadOnly(t *testing.T, readOnly bool) (*httptest.Server, *peerdb.Service) {
+	t.Helper()
+
 	if os.Getenv("ELASTIC") == "" {
 		t.Skip("ELASTIC is not available")
 	}
@@ -160,7 +166,8 @@ func startTestServer(t *testing.T) (*httptest.Server, *peerdb.Service) {
 			// Having 0 for port here makes the rest of the codebase expect a random port and wait for its assignment.
 			Addr: "localhost:0",
 		},
-		Title: peerdb.DefaultTitle,
+		Title:    peerdb.DefaultTitle,
+		ReadOnly: readOnly,
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -0,0 +1,147 @@
+package peerdb
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+// maxIdentifierMatches is the maximum number of documents reported when
+// an external identifier matches more than one document.
+const maxIdentifierMatches = 10
+
+// defaultIdentifierSchemes are identifier schemes available on all sites,
+// mapped to mnemonics of their identifier properties.
+//
+//nolint:gochecknoglobals
+var defaultIdentifierSchemes = map[string]string{
+	"wikidata": "WIKIDATA_ITEM_ID",
+	"gtin":     "GTIN",
+}
+
+// identifierProperty returns the identifier property for the scheme. The scheme can be configured
+// for the site, one of default schemes, or an ID of the identifier property itself.
+func (s *Site) identifierProperty(scheme string) (identifier.Identifier, bool) {
+	if prop, ok := s.IdentifierSchemes[scheme]; ok {
+		return prop, true
+	}
+	if mnemonic, ok := defaultIdentifierSchemes[scheme]; ok {
+		return document.GetCorePropertyID(mnemonic), true
+	}
+	prop, errE := identifier.FromString(scheme)
+	return prop, errE == nil
+}
+
+// resolveIdentifier returns the ID of the document with the identifier claim matching
+// "scheme" and "value" parameters. Otherwise it replies to the request and returns false.
+//
+// Identifier values are matched ignoring case and leading zeros.
+func (s *Service) resolveIdentifier(w http.ResponseWriter, req *http.Request, params waf.Params) (string, bool) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+	site := waf.MustGetSite[*Site](ctx)
+
+	prop, ok := site.identifierProperty(params["scheme"])
+	if !ok {
+		s.NotFound(w, req)
+		return "", false
+	}
+
+	// Identifiers of restricted properties cannot be resolved unless the caller has the elevated role.
+	errE := search.CheckRestricted(ctx, prop)
+	if errE != nil {
+		errors.Details(errE)["scheme"] = params["scheme"]
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return "", false
+	}
+
+	query := elastic.NewNestedQuery("claims.id", elastic.NewBoolQuery().Must(
+		elastic.NewTermQuery("claims.id.prop.id", prop),
+		elastic.NewTermQuery("claims.id.value", params["value"]),
+	))
+
+	searchService, _ := s.getSearchService(req)
+	searchService = searchService.From(0).Size(maxIdentifierMatches).Query(query)
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	res, err := searchService.Do(ctx)
+	m.Stop()
+	if err != nil {
+		s.InternalServerErrorWithError(w, req, errors.WithStack(err))
+		return "", false
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
+
+	// Documents redirected to the same canonical document are the same document.
+	ids := []string{}
+	for _, hit := range res.Hits.Hits {
+		id := site.redirectMap.resolveString(hit.Id)
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		s.NotFound(w, req)
+		return "", false
+	case 1:
+		return ids[0], true
+	default:
+		errE := errors.New("identifier matches multiple documents")
+		errors.Details(errE)["scheme"] = params["scheme"]
+		errors.Details(errE)["value"] = params["value"]
+		errors.Details(errE)["docs"] = ids
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return "", false
+	}
+}
+
+// DocumentByIdentifierGet is a GET/HEAD HTTP request handler which redirects to the document
+// API endpoint of the document with the identifier claim matching the external identifier
+// (e.g., /api/d/wikidata/Q5593). Query string is preserved.
+//
+// The identifier scheme can be configured for the site (see Site.IdentifierSchemes),
+// "wikidata" or "gtin", or an ID of the identifier property. If the identifier matches
+// multiple documents, it replies with the 409 (conflict) HTTP code listing them.
+// Schemes of restricted properties are available only to callers with the elevated role.
+func (s *Service) DocumentByIdentifierGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	id, ok := s.resolveIdentifier(w, req, params)
+	if !ok {
+		return
+	}
+
+	path, errE := s.ReverseAPI("DocumentGet", waf.Params{"id": id}, req.URL.Query())
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.TemporaryRedirectSameMethod(w, req, path)
+}
+
+// DocumentByIdentifier is a GET/HEAD HTTP request handler which redirects to the page of
+// the document with the identifier claim matching the external identifier. See DocumentByIdentifierGet.
+func (s *Service) DocumentByIdentifier(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	id, ok := s.resolveIdentifier(w, req, params)
+	if !ok {
+		return
+	}
+
+	path, errE := s.Reverse("DocumentGet", waf.Params{"id": id}, req.URL.Query())
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.TemporaryRedirectSameMethod(w, req, path)
+}
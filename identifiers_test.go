@@ -0,0 +1,43 @@
+package peerdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestIdentifierProperty(t *testing.T) {
+	t.Parallel()
+
+	isbn := identifier.New()
+	site := &Site{IdentifierSchemes: map[string]identifier.Identifier{ //nolint:exhaustruct
+		"isbn": isbn,
+		// Configured schemes take precedence over default ones.
+		"gtin": isbn,
+	}}
+
+	for _, tt := range []struct {
+		scheme string
+		prop   identifier.Identifier
+		ok     bool
+	}{
+		{"isbn", isbn, true},
+		{"gtin", isbn, true},
+		{"wikidata", document.GetCorePropertyID("WIKIDATA_ITEM_ID"), true},
+		{isbn.String(), isbn, true},
+		{"unknown", identifier.Identifier{}, false},
+	} {
+		prop, ok := site.identifierProperty(tt.scheme)
+		assert.Equal(t, tt.ok, ok, tt.scheme)
+		if tt.ok {
+			assert.Equal(t, tt.prop, prop, tt.scheme)
+		}
+	}
+
+	prop, ok := (&Site{}).identifierProperty("gtin") //nolint:exhaustruct
+	assert.True(t, ok)
+	assert.Equal(t, document.GetCorePropertyID("GTIN"), prop)
+}
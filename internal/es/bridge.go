@@ -5,7 +5,7 @@ import (
 
 	"github.com/olivere/elastic/v7"
 	"github.com/rs/zerolog"
-
+	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/identifier"
 
 	"gitlab.com/peerdb/peerdb/store"
@@ -17,58 +17,161 @@ import (
 //       where they were indexed and continue on (new) bridge start from we left the last time.
 //       At the same time make it work when peerdb process is horizontally scaled.
 
+// BridgeSync allows waiting for Bridge to process committed changesets.
+type BridgeSync struct {
+	requests chan chan struct{}
+}
+
+// NewBridgeSync returns a new BridgeSync to pass to Bridge.
+func NewBridgeSync() *BridgeSync {
+	return &BridgeSync{
+		requests: make(chan chan struct{}),
+	}
+}
+
+// Wait returns once all changesets committed before the call have been processed by Bridge,
+// i.e., their documents have been added to the bulk processor. Flush the bulk processor
+// afterwards to make sure they have been indexed.
+func (b *BridgeSync) Wait(ctx context.Context) errors.E {
+	done := make(chan struct{})
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case b.requests <- done:
+	}
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case <-done:
+		return nil
+	}
+}
+
+// Bridge indexes documents changed by committed changesets into ElasticSearch.
+//
+// Documents are prepared for indexing with prepareDocument, which can return IDs of other
+// documents which should be reindexed as well (e.g., because their reference counts changed).
+// Documents are indexed into all indices returned by generations.WriteIndices.
+// For deleted documents deleteDocument is called instead, which can return IDs of
+// other documents which should be reindexed as well.
+//
+// Changesets are sent to the channel when they are committed, so once Wait of bridgeSync
+// (if provided) returns, all changesets committed before it was called have been processed.
 func Bridge[Data, Metadata, CreateViewMetadata, ReleaseViewMetadata, CommitMetadata, Patch any](
 	ctx context.Context, logger zerolog.Logger, s *store.Store[Data, Metadata, CreateViewMetadata, ReleaseViewMetadata, CommitMetadata, Patch],
-	esProcessor *elastic.BulkProcessor, index string,
+	esProcessor *elastic.BulkProcessor, generations *Generations,
+	prepareDocument func(context.Context, identifier.Identifier, Data) (Data, []identifier.Identifier, errors.E),
+	deleteDocument func(context.Context, identifier.Identifier) ([]identifier.Identifier, errors.E),
 	committedChangesets <-chan store.CommittedChangeset[Data, Metadata, CreateViewMetadata, ReleaseViewMetadata, CommitMetadata, Patch],
+	bridgeSync *BridgeSync,
 ) {
+	var syncRequests chan chan struct{}
+	if bridgeSync != nil {
+		syncRequests = bridgeSync.requests
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case done := <-syncRequests:
+			// Changesets committed before the request have already been sent to the channel,
+			// so we process those which are still buffered before responding.
+			for range len(committedChangesets) {
+				c, ok := <-committedChangesets
+				if !ok {
+					break
+				}
+				bridgeChangeset(ctx, logger, s, esProcessor, generations, prepareDocument, deleteDocument, c)
+			}
+			close(done)
 		case c, ok := <-committedChangesets:
 			if !ok {
 				return
 			}
+			bridgeChangeset(ctx, logger, s, esProcessor, generations, prepareDocument, deleteDocument, c)
+		}
+	}
+}
 
-			// The order in which changesets are send to the channel is not necessary
-			// the order in which they were committed. We should not relay on the order.
+func bridgeChangeset[Data, Metadata, CreateViewMetadata, ReleaseViewMetadata, CommitMetadata, Patch any](
+	ctx context.Context, logger zerolog.Logger, s *store.Store[Data, Metadata, CreateViewMetadata, ReleaseViewMetadata, CommitMetadata, Patch],
+	esProcessor *elastic.BulkProcessor, generations *Generations,
+	prepareDocument func(context.Context, identifier.Identifier, Data) (Data, []identifier.Identifier, errors.E),
+	deleteDocument func(context.Context, identifier.Identifier) ([]identifier.Identifier, errors.E),
+	c store.CommittedChangeset[Data, Metadata, CreateViewMetadata, ReleaseViewMetadata, CommitMetadata, Patch],
+) {
+	// The order in which changesets are send to the channel is not necessary
+	// the order in which they were committed. We should not relay on the order.
 
-			// We have to reconstruct the committedChangeset and the view using our store.
-			committedChangeset, errE := c.WithStore(ctx, s)
+	// We have to reconstruct the committedChangeset and the view using our store.
+	committedChangeset, errE := c.WithStore(ctx, s)
+	if errE != nil {
+		logger.Error().Err(errE).Str("changeset", c.Changeset.String()).Str("view", c.View.Name()).Msg("bridge error: with store")
+		return
+	}
+
+	var after *identifier.Identifier
+	changes := []store.Change{}
+	for {
+		page, errE := committedChangeset.Changeset.Changes(ctx, after)
+		if errE != nil {
+			logger.Error().Err(errE).Str("changeset", c.Changeset.String()).Str("view", c.View.Name()).Msg("bridge error: changes")
+			break
+		}
+		changes = append(changes, page...)
+		if len(page) < store.MaxPageLength {
+			break
+		}
+		after = &page[4999].ID
+	}
+
+	ids := make([]identifier.Identifier, 0, len(changes))
+	changed := map[identifier.Identifier]bool{}
+	for _, change := range changes {
+		ids = append(ids, change.ID)
+		changed[change.ID] = true
+	}
+	indexed := map[identifier.Identifier]bool{}
+
+	for len(ids) > 0 {
+		id := ids[0]
+		ids = ids[1:]
+		if indexed[id] {
+			continue
+		}
+		indexed[id] = true
+
+		// Because changesets are not necessary in order, we always get the latest version and index it.
+		data, _, version, errE := s.GetLatest(ctx, id)
+		if errors.Is(errE, store.ErrValueDeleted) && changed[id] {
+			reindex, errE := deleteDocument(ctx, id)
 			if errE != nil {
-				logger.Error().Err(errE).Str("changeset", c.Changeset.String()).Str("view", c.View.Name()).Msg("bridge error: with store")
+				logger.Error().Err(errE).Str("changeset", c.Changeset.String()).Str("view", c.View.Name()).Str("doc", id.String()).
+					Msg("bridge error: delete document")
 				continue
 			}
+			ids = append(ids, reindex...)
+			continue
+		} else if errors.Is(errE, store.ErrValueNotFound) && !changed[id] {
+			// Document to reindex does not exist (yet).
+			continue
+		} else if errE != nil {
+			logger.Error().Err(errE).Str("changeset", c.Changeset.String()).Str("view", c.View.Name()).Msg("bridge error: get current")
+			continue
+		}
 
-			var after *identifier.Identifier
-			changes := []store.Change{}
-			for {
-				page, errE := committedChangeset.Changeset.Changes(ctx, after)
-				if errE != nil {
-					logger.Error().Err(errE).Str("changeset", c.Changeset.String()).Str("view", c.View.Name()).Msg("bridge error: changes")
-					break
-				}
-				changes = append(changes, page...)
-				if len(page) < store.MaxPageLength {
-					break
-				}
-				after = &page[4999].ID
-			}
-
-			for _, change := range changes {
-				// Because changesets are not necessary in order, we always get the latest version and index it.
-				data, _, _, errE := s.GetLatest(ctx, change.ID)
-				if errE != nil {
-					logger.Error().Err(errE).Str("changeset", c.Changeset.String()).Str("view", c.View.Name()).Msg("bridge error: get current")
-					continue
-				}
+		data, reindex, errE := prepareDocument(ctx, id, data)
+		if errE != nil {
+			logger.Error().Err(errE).Str("changeset", c.Changeset.String()).Str("view", c.View.Name()).Str("doc", id.String()).
+				Msg("bridge error: prepare document")
+			continue
+		}
+		ids = append(ids, reindex...)
 
-				// TODO: Convert data into searchable document for the general case.
-				// TODO: Use also information about the view so that documents are searchable by view as well.
-				req := elastic.NewBulkIndexRequest().Index(index).Id(change.ID.String()).Doc(data)
-				esProcessor.Add(req)
-			}
+		// TODO: Use also information about the view so that documents are searchable by view as well.
+		for _, index := range generations.WriteIndices() {
+			esProcessor.Add(generations.IndexRequest(index, id, version.Revision, data))
 		}
 	}
 }
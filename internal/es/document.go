@@ -0,0 +1,429 @@
+package es
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// timeFields maps claim types to their timestamp fields.
+var timeFields = map[string][]string{ //nolint:gochecknoglobals
+	"time":      {"timestamp"},
+	"timeRange": {"lower", "upper"},
+}
+
+// PrepareDocument converts the document into a form which is indexed in ElasticSearch.
+//
+// For every timestamp field of time and time range claims (including meta claims) it adds two numeric fields:
+// "<field>Seconds" with seconds since Unix epoch and "<field>Year" with the (astronomical)
+// year. ElasticSearch date fields cannot represent all timestamps PeerDB supports
+// (e.g., years after 9999 or very far in the past), so numeric fields are used for
+// filtering, sorting, and histograms instead.
+//
+// For every claim with a validity period (see document.GetValidity) it adds "validFromSeconds"
+// and "validToSeconds" fields with the (inclusive) bounds of the period in seconds since Unix epoch.
+// Top-level validity time range claims (validity of the document itself) get those fields as well.
+//
+// For every amount claim with meta relation claims it adds "metaRel" field with
+// "<prop ID>|<to ID>" values, one for every meta relation claim, so that amounts
+// can be filtered by their meta relation claims (e.g., if an amount is per serving).
+//
+// For every relation claim with meta time claims it adds nested "metaTime" field with
+// "prop" and "timestampSeconds" fields, one for every meta time claim, so that results
+// can be sorted by meta time claims (e.g., by the date since when an artwork is in a collection).
+//
+// It adds top-level "sortKeys" field with materialized minimal and maximal timestamps (in seconds
+// since Unix epoch) of time claims per property and of meta time claims per property of relation
+// claims and property of meta time claims (see SortKeyField). Sorting by them is much faster than
+// sorting by nested claims.
+func PrepareDocument(data json.RawMessage) (json.RawMessage, errors.E) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// We want to preserve numbers exactly as they are.
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	claims, ok := doc["claims"].(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+
+	changed := false
+	sortKeys := map[string]int64{}
+	for claimType, cs := range claims {
+		cs, ok := cs.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, c := range cs {
+			claim, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if claimType == "amount" {
+				if rels := metaRelations(claim); len(rels) > 0 {
+					claim["metaRel"] = rels
+					changed = true
+				}
+			}
+			if claimType == "rel" {
+				times, errE := metaTimes(claim)
+				if errE != nil {
+					errors.Details(errE)["type"] = claimType
+					return nil, errE
+				}
+				if len(times) > 0 {
+					claim["metaTime"] = times
+					changed = true
+				}
+				if prop, ok := claim["prop"].(map[string]interface{}); ok {
+					if propID, ok := prop["id"].(string); ok {
+						for _, t := range times {
+							metaProp, _ := t["prop"].(map[string]interface{})
+							metaID, ok := metaProp["id"].(string)
+							if !ok {
+								continue
+							}
+							seconds, _ := t["timestampSeconds"].(int64)
+							addSortKey(sortKeys, propID, metaID, seconds)
+						}
+					}
+				}
+			}
+			var validity *document.TimeRangeClaim
+			var errE errors.E
+			if claimType == "timeRange" && isValidity(claim) {
+				// Validity of the document itself. We index it in the same way so
+				// that documents can be filtered by their validity as well.
+				validity, errE = parseValidity(claim)
+			} else {
+				validity, errE = getValidity(claim)
+			}
+			if errE != nil {
+				errors.Details(errE)["type"] = claimType
+				return nil, errE
+			}
+			if validity == nil {
+				continue
+			}
+			start, end := document.ValidityPeriod(validity)
+			claim["validFromSeconds"] = start.Unix()
+			claim["validToSeconds"] = end.Unix() - 1
+			changed = true
+		}
+	}
+
+	timesChanged, errE := addTimeFields(claims, sortKeys)
+	if errE != nil {
+		return nil, errE
+	}
+	if timesChanged {
+		changed = true
+	}
+
+	if len(sortKeys) > 0 {
+		doc["sortKeys"] = sortKeys
+		changed = true
+	}
+
+	if !changed {
+		return data, nil
+	}
+
+	return x.MarshalWithoutEscapeHTML(doc)
+}
+
+// addTimeFields adds "<field>Seconds" and "<field>Year" fields for every timestamp field of time
+// and time range claims, recursing into meta claims. If sortKeys is not nil, it is updated with
+// timestamps of time claims (meta claims do not have sort keys of their own).
+func addTimeFields(claims map[string]interface{}, sortKeys map[string]int64) (bool, errors.E) {
+	changed := false
+	for claimType, cs := range claims {
+		cs, ok := cs.([]interface{})
+		if !ok {
+			continue
+		}
+		fields := timeFields[claimType]
+		for _, c := range cs {
+			claim, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if meta, ok := claim["meta"].(map[string]interface{}); ok {
+				metaChanged, errE := addTimeFields(meta, nil)
+				if errE != nil {
+					return false, errE
+				}
+				if metaChanged {
+					changed = true
+				}
+			}
+			for _, field := range fields {
+				value, ok := claim[field].(string)
+				if !ok {
+					continue
+				}
+				var timestamp document.Timestamp
+				err := timestamp.UnmarshalText([]byte(value))
+				if err != nil {
+					errE := errors.WithMessage(err, "invalid timestamp")
+					errors.Details(errE)["type"] = claimType
+					errors.Details(errE)["field"] = field
+					return false, errE
+				}
+				claim[field+"Seconds"] = time.Time(timestamp).Unix()
+				claim[field+"Year"] = time.Time(timestamp).Year()
+				changed = true
+				if claimType == "time" && sortKeys != nil {
+					if prop, ok := claim["prop"].(map[string]interface{}); ok {
+						if propID, ok := prop["id"].(string); ok {
+							addSortKey(sortKeys, propID, "", time.Time(timestamp).Unix())
+						}
+					}
+				}
+			}
+		}
+	}
+	return changed, nil
+}
+
+// metaRelations returns "<prop ID>|<to ID>" values for meta relation claims of the claim.
+func metaRelations(claim map[string]interface{}) []string {
+	meta, ok := claim["meta"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	rels, ok := meta["rel"].([]interface{})
+	if !ok {
+		return nil
+	}
+	result := []string{}
+	for _, r := range rels {
+		rel, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		prop, ok := rel["prop"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		to, ok := rel["to"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		propID, ok := prop["id"].(string)
+		if !ok {
+			continue
+		}
+		toID, ok := to["id"].(string)
+		if !ok {
+			continue
+		}
+		result = append(result, MetaRelationValue(propID, toID))
+	}
+	return result
+}
+
+// metaTimes returns "metaTime" values for meta time claims of the claim.
+func metaTimes(claim map[string]interface{}) ([]map[string]interface{}, errors.E) {
+	meta, ok := claim["meta"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	times, ok := meta["time"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	result := []map[string]interface{}{}
+	for _, t := range times {
+		tm, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		prop, ok := tm["prop"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, ok := tm["timestamp"].(string)
+		if !ok {
+			continue
+		}
+		var timestamp document.Timestamp
+		err := timestamp.UnmarshalText([]byte(value))
+		if err != nil {
+			errE := errors.WithMessage(err, "invalid meta timestamp")
+			errors.Details(errE)["field"] = "timestamp"
+			return nil, errE
+		}
+		result = append(result, map[string]interface{}{
+			"prop":             map[string]interface{}{"id": prop["id"]},
+			"timestampSeconds": time.Time(timestamp).Unix(),
+		})
+	}
+	return result, nil
+}
+
+// SortKeyField returns the name of the materialized "sortKeys" field with the minimal
+// (or maximal, if maximum is true) timestamp of time claims with property propID. If metaID
+// is set, it is the name of the field for meta time claims with property metaID of relation
+// claims with property propID.
+func SortKeyField(propID, metaID string, maximum bool) string {
+	field := "sortKeys." + propID
+	if metaID != "" {
+		field += "_" + metaID
+	}
+	if maximum {
+		return field + "_max"
+	}
+	return field + "_min"
+}
+
+// addSortKey updates minimal and maximal materialized sort keys with the timestamp (in seconds).
+func addSortKey(sortKeys map[string]int64, propID, metaID string, seconds int64) {
+	for _, maximum := range []bool{false, true} {
+		field := strings.TrimPrefix(SortKeyField(propID, metaID, maximum), "sortKeys.")
+		current, ok := sortKeys[field]
+		if !ok || (maximum && seconds > current) || (!maximum && seconds < current) {
+			sortKeys[field] = seconds
+		}
+	}
+}
+
+// MetaRelationValue returns the value of "metaRel" field for a meta relation claim
+// with property propID pointing to toID.
+func MetaRelationValue(propID, toID string) string {
+	return propID + "|" + toID
+}
+
+// getValidity returns the validity period from meta claims of the claim, if it has one.
+func getValidity(claim map[string]interface{}) (*document.TimeRangeClaim, errors.E) {
+	meta, ok := claim["meta"].(map[string]interface{})
+	if !ok {
+		return nil, nil //nolint:nilnil
+	}
+	timeRanges, ok := meta["timeRange"].([]interface{})
+	if !ok {
+		return nil, nil //nolint:nilnil
+	}
+	for _, tr := range timeRanges {
+		timeRange, ok := tr.(map[string]interface{})
+		if !ok || !isValidity(timeRange) {
+			continue
+		}
+		return parseValidity(timeRange)
+	}
+	return nil, nil //nolint:nilnil
+}
+
+// isValidity returns true if the time range claim is a VALIDITY claim.
+func isValidity(timeRange map[string]interface{}) bool {
+	prop, ok := timeRange["prop"].(map[string]interface{})
+	return ok && prop["id"] == document.GetCorePropertyID("VALIDITY").String()
+}
+
+func parseValidity(timeRange map[string]interface{}) (*document.TimeRangeClaim, errors.E) {
+	data, errE := x.MarshalWithoutEscapeHTML(timeRange)
+	if errE != nil {
+		return nil, errE
+	}
+	var validity document.TimeRangeClaim
+	errE = x.UnmarshalWithoutUnknownFields(data, &validity)
+	if errE != nil {
+		return nil, errors.WithMessage(errE, "invalid validity")
+	}
+	return &validity, nil
+}
+
+// RemoveRestricted removes claims (including meta claims and claims of children documents)
+// of restricted properties from the document, so that they are not indexed and cannot be
+// searched for.
+//
+// If there is nothing to remove, data is returned as-is.
+func RemoveRestricted(data json.RawMessage, restricted []identifier.Identifier) (json.RawMessage, errors.E) {
+	if len(restricted) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// We want to preserve numbers exactly as they are.
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	props := make(map[string]bool, len(restricted))
+	for _, prop := range restricted {
+		props[prop.String()] = true
+	}
+
+	if !removeRestrictedFromDocument(doc, props) {
+		return data, nil
+	}
+
+	return x.MarshalWithoutEscapeHTML(doc)
+}
+
+func removeRestrictedFromDocument(doc map[string]interface{}, props map[string]bool) bool {
+	changed := false
+	if claims, ok := doc["claims"].(map[string]interface{}); ok {
+		changed = removeRestrictedClaims(claims, props)
+	}
+	if children, ok := doc["children"].([]interface{}); ok {
+		for _, c := range children {
+			if child, ok := c.(map[string]interface{}); ok && removeRestrictedFromDocument(child, props) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// removeRestrictedClaims removes claims of props from claims, recursing into meta claims.
+func removeRestrictedClaims(claims map[string]interface{}, props map[string]bool) bool {
+	changed := false
+	for claimType, cs := range claims {
+		cs, ok := cs.([]interface{})
+		if !ok {
+			continue
+		}
+		kept := make([]interface{}, 0, len(cs))
+		for _, c := range cs {
+			claim, ok := c.(map[string]interface{})
+			if !ok {
+				kept = append(kept, c)
+				continue
+			}
+			if prop, ok := claim["prop"].(map[string]interface{}); ok {
+				if propID, ok := prop["id"].(string); ok && props[propID] {
+					changed = true
+					continue
+				}
+			}
+			if meta, ok := claim["meta"].(map[string]interface{}); ok && removeRestrictedClaims(meta, props) {
+				changed = true
+				if len(meta) == 0 {
+					delete(claim, "meta")
+				}
+			}
+			kept = append(kept, claim)
+		}
+		if len(kept) == 0 {
+			delete(claims, claimType)
+		} else {
+			claims[claimType] = kept
+		}
+	}
+	return changed
+}
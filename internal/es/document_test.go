@@ -0,0 +1,153 @@
+package es_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+)
+
+func TestPrepareDocument(t *testing.T) {
+	t.Parallel()
+
+	data, errE := es.PrepareDocument(json.RawMessage(`{"id":"x","score":0.5,"claims":{` +
+		`"time":[{"timestamp":"-9999-01-01T00:00:00Z","precision":"y"}],` +
+		`"timeRange":[{"lower":"1970-01-01T00:00:00Z","upper":"20006-12-04T12:34:45Z","precision":"s"}]}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, `{"id":"x","score":0.5,"claims":{`+
+		`"time":[{"timestamp":"-9999-01-01T00:00:00Z","timestampSeconds":-377705116800,"timestampYear":-9999,"precision":"y"}],`+
+		`"timeRange":[{"lower":"1970-01-01T00:00:00Z","lowerSeconds":0,"lowerYear":1970,`+
+		`"upper":"20006-12-04T12:34:45Z","upperSeconds":569190371685,"upperYear":20006,"precision":"s"}]}}`, string(data))
+
+	input := json.RawMessage(`{"id":"x","claims":{"id":[{"value":"1"}]}}`)
+	data, errE = es.PrepareDocument(input)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, input, data)
+
+	_, errE = es.PrepareDocument(json.RawMessage(`{"claims":{"time":[{"timestamp":"invalid"}]}}`))
+	assert.Error(t, errE)
+}
+
+func TestPrepareDocumentValidity(t *testing.T) {
+	t.Parallel()
+
+	validityID := identifier.New().String()
+	validity := `{"id":"` + validityID + `","confidence":1,"prop":{"id":"` + document.GetCorePropertyID("VALIDITY").String() + `"},` +
+		`"lower":"1970-01-01T00:00:00Z","upper":"1970-01-01T00:00:00Z","precision":"d"}`
+
+	data, errE := es.PrepareDocument(json.RawMessage(`{"id":"x","claims":{` +
+		`"string":[{"id":"s","confidence":1,"prop":{"id":"p"},"string":"foo","meta":{"timeRange":[` + validity + `]}}]}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	indexedValidity := `{"id":"` + validityID + `","confidence":1,"prop":{"id":"` + document.GetCorePropertyID("VALIDITY").String() + `"},` +
+		`"lower":"1970-01-01T00:00:00Z","lowerSeconds":0,"lowerYear":1970,"upper":"1970-01-01T00:00:00Z","upperSeconds":0,"upperYear":1970,"precision":"d"}`
+	assert.JSONEq(t, `{"id":"x","claims":{`+
+		`"string":[{"id":"s","confidence":1,"prop":{"id":"p"},"string":"foo","meta":{"timeRange":[`+indexedValidity+`]},`+
+		`"validFromSeconds":0,"validToSeconds":86399}]}}`, string(data))
+
+	data, errE = es.PrepareDocument(json.RawMessage(`{"id":"x","claims":{"timeRange":[` + validity + `]}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, `{"id":"x","claims":{"timeRange":[{"id":"`+validityID+`","confidence":1,"prop":{"id":"`+document.GetCorePropertyID("VALIDITY").String()+`"},`+
+		`"lower":"1970-01-01T00:00:00Z","lowerSeconds":0,"lowerYear":1970,"upper":"1970-01-01T00:00:00Z","upperSeconds":0,"upperYear":1970,`+
+		`"precision":"d","validFromSeconds":0,"validToSeconds":86399}]}}`, string(data))
+}
+
+func TestPrepareDocumentMetaRelations(t *testing.T) {
+	t.Parallel()
+
+	data, errE := es.PrepareDocument(json.RawMessage(`{"id":"x","claims":{` +
+		`"amount":[{"id":"a","confidence":1,"prop":{"id":"p"},"amount":1,"unit":"kg","meta":{"rel":[{"id":"r","confidence":1,"prop":{"id":"b"},"to":{"id":"s"}}]}}],` +
+		`"string":[{"id":"s","confidence":1,"prop":{"id":"p"},"string":"foo","meta":{"rel":[{"id":"r","confidence":1,"prop":{"id":"b"},"to":{"id":"s"}}]}}]}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, `{"id":"x","claims":{`+
+		`"amount":[{"id":"a","confidence":1,"prop":{"id":"p"},"amount":1,"unit":"kg","meta":{"rel":[{"id":"r","confidence":1,"prop":{"id":"b"},"to":{"id":"s"}}]},"metaRel":["b|s"]}],`+
+		`"string":[{"id":"s","confidence":1,"prop":{"id":"p"},"string":"foo","meta":{"rel":[{"id":"r","confidence":1,"prop":{"id":"b"},"to":{"id":"s"}}]}}]}}`, string(data))
+}
+
+func TestPrepareDocumentMetaTimes(t *testing.T) {
+	t.Parallel()
+
+	data, errE := es.PrepareDocument(json.RawMessage(`{"id":"x","claims":{` +
+		`"rel":[{"id":"r","confidence":1,"prop":{"id":"p"},"to":{"id":"c"},"meta":{"time":[{"id":"t","confidence":1,"prop":{"id":"s"},"timestamp":"1970-01-02T00:00:00Z","precision":"d"}]}}],` +
+		`"string":[{"id":"s","confidence":1,"prop":{"id":"p"},"string":"foo","meta":{"time":[{"id":"t","confidence":1,"prop":{"id":"s"},"timestamp":"1970-01-02T00:00:00Z","precision":"d"}]}}]}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, `{"id":"x","claims":{`+
+		`"rel":[{"id":"r","confidence":1,"prop":{"id":"p"},"to":{"id":"c"},"meta":{"time":[{"id":"t","confidence":1,"prop":{"id":"s"},"timestamp":"1970-01-02T00:00:00Z","timestampSeconds":86400,"timestampYear":1970,"precision":"d"}]},`+
+		`"metaTime":[{"prop":{"id":"s"},"timestampSeconds":86400}]}],`+
+		`"string":[{"id":"s","confidence":1,"prop":{"id":"p"},"string":"foo","meta":{"time":[{"id":"t","confidence":1,"prop":{"id":"s"},"timestamp":"1970-01-02T00:00:00Z","timestampSeconds":86400,"timestampYear":1970,"precision":"d"}]}}]},`+
+		`"sortKeys":{"p_s_min":86400,"p_s_max":86400}}`, string(data))
+
+	_, errE = es.PrepareDocument(json.RawMessage(`{"claims":{"rel":[{"meta":{"time":[{"prop":{"id":"s"},"timestamp":"invalid"}]}}]}}`))
+	assert.Error(t, errE)
+}
+
+func TestPrepareDocumentMetaTimeFields(t *testing.T) {
+	t.Parallel()
+
+	// Meta claims (also of meta claims) get numeric time fields, but not sort keys.
+	data, errE := es.PrepareDocument(json.RawMessage(`{"id":"x","claims":{` +
+		`"string":[{"id":"s","confidence":1,"prop":{"id":"p"},"string":"foo","meta":{` +
+		`"time":[{"id":"t","confidence":1,"prop":{"id":"q"},"timestamp":"-9999-01-01T00:00:00Z","precision":"y","meta":{` +
+		`"timeRange":[{"id":"r","confidence":1,"prop":{"id":"q"},"lower":"1970-01-01T00:00:00Z","upper":"1970-01-02T00:00:00Z","precision":"d"}]}}]}}]}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, `{"id":"x","claims":{`+
+		`"string":[{"id":"s","confidence":1,"prop":{"id":"p"},"string":"foo","meta":{`+
+		`"time":[{"id":"t","confidence":1,"prop":{"id":"q"},"timestamp":"-9999-01-01T00:00:00Z","timestampSeconds":-377705116800,"timestampYear":-9999,"precision":"y","meta":{`+
+		`"timeRange":[{"id":"r","confidence":1,"prop":{"id":"q"},"lower":"1970-01-01T00:00:00Z","lowerSeconds":0,"lowerYear":1970,`+
+		`"upper":"1970-01-02T00:00:00Z","upperSeconds":86400,"upperYear":1970,"precision":"d"}]}}]}}]}}`, string(data))
+
+	_, errE = es.PrepareDocument(json.RawMessage(`{"claims":{"string":[{"meta":{"time":[{"timestamp":"invalid"}]}}]}}`))
+	assert.Error(t, errE)
+}
+
+func TestPrepareDocumentSortKeys(t *testing.T) {
+	t.Parallel()
+
+	data, errE := es.PrepareDocument(json.RawMessage(`{"id":"x","claims":{"time":[` +
+		`{"id":"a","confidence":1,"prop":{"id":"p"},"timestamp":"1970-01-02T00:00:00Z","precision":"d"},` +
+		`{"id":"b","confidence":1,"prop":{"id":"p"},"timestamp":"1970-01-01T00:00:00Z","precision":"d"},` +
+		`{"id":"c","confidence":1,"prop":{"id":"q"},"timestamp":"1970-01-03T00:00:00Z","precision":"d"}]}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	var doc struct {
+		SortKeys map[string]int64 `json:"sortKeys"`
+	}
+	errE = x.Unmarshal(data, &doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, map[string]int64{
+		"p_min": 0,
+		"p_max": 86400,
+		"q_min": 172800,
+		"q_max": 172800,
+	}, doc.SortKeys)
+
+	assert.Equal(t, "sortKeys.p_min", es.SortKeyField("p", "", false))
+	assert.Equal(t, "sortKeys.p_s_max", es.SortKeyField("p", "s", true))
+}
+
+func TestRemoveRestricted(t *testing.T) {
+	t.Parallel()
+
+	public := identifier.New().String()
+	restricted := identifier.New()
+
+	data, errE := es.RemoveRestricted(json.RawMessage(`{"id":"x","claims":{`+
+		`"string":[{"id":"a","prop":{"id":"`+public+`"},"string":"name","meta":{"string":[{"id":"b","prop":{"id":"`+restricted.String()+`"},"string":"meta email"}]}},`+
+		`{"id":"c","prop":{"id":"`+restricted.String()+`"},"string":"email"}]},`+
+		`"children":[{"id":"y","claims":{"string":[{"id":"d","prop":{"id":"`+restricted.String()+`"},"string":"child email"}]}}]}`),
+		[]identifier.Identifier{restricted})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, `{"id":"x","claims":{`+
+		`"string":[{"id":"a","prop":{"id":"`+public+`"},"string":"name"}]},`+
+		`"children":[{"id":"y","claims":{}}]}`, string(data))
+
+	input := json.RawMessage(`{"id":"x","claims":{"string":[{"id":"a","prop":{"id":"` + public + `"},"string":"name"}]}}`)
+	data, errE = es.RemoveRestricted(input, []identifier.Identifier{restricted})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, input, data)
+}
@@ -0,0 +1,289 @@
+package es
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+// ErrReindexInProgress is returned when a new index generation is being built already.
+var ErrReindexInProgress = errors.Base("reindex in progress")
+
+// GenerationIndex returns the name of the concrete index of the generation of the index.
+// Generation 0 is the concrete index with the name of the index itself, created before
+// index generations were introduced.
+func GenerationIndex(index string, generation int64) string {
+	if generation == 0 {
+		return index
+	}
+	return index + "_" + strconv.FormatInt(generation, 10)
+}
+
+// parseGeneration returns the generation of the concrete index of the index.
+func parseGeneration(index, concrete string) (int64, errors.E) {
+	if concrete == index {
+		return 0, nil
+	}
+	suffix, ok := strings.CutPrefix(concrete, index+"_")
+	if ok {
+		generation, err := strconv.ParseInt(suffix, 10, 64)
+		if err == nil && generation > 0 {
+			return generation, nil
+		}
+	}
+	errE := errors.New("unexpected concrete index")
+	errors.Details(errE)["index"] = index
+	errors.Details(errE)["concrete"] = concrete
+	return 0, errE
+}
+
+// Generations routes reads and writes of the index between index generations, so that
+// the index can be rebuilt from scratch while reads see a consistent index.
+//
+// The index is an alias of the concrete index of the current generation (see GenerationIndex),
+// so reads through the index always use the current generation. While the next generation is
+// being built (between Begin and Cutover), writes go to both generations (see WriteIndices).
+// At cutover, the alias is atomically switched to the next generation. The previous generation
+// is deleted only after a retention period, so that pagination sessions, which use points
+// in time of concrete indices, started before cutover can continue.
+//
+// Generations are tracked per process, so only one process should write to the index while
+// the next generation is being built.
+type Generations struct {
+	Index string
+
+	esClient *elastic.Client
+	mu       sync.RWMutex
+	current  int64
+	next     int64
+}
+
+// Init determines the current generation of the index, which has to exist.
+func (g *Generations) Init(ctx context.Context, esClient *elastic.Client) errors.E {
+	res, err := esClient.IndexGetSettings(g.Index).Name("index.uuid").Do(ctx)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["index"] = g.Index
+		return errE
+	}
+	if len(res) != 1 {
+		errE := errors.New("unexpected number of indices")
+		errors.Details(errE)["index"] = g.Index
+		return errE
+	}
+
+	for concrete := range res {
+		generation, errE := parseGeneration(g.Index, concrete)
+		if errE != nil {
+			return errE
+		}
+		g.mu.Lock()
+		g.esClient = esClient
+		g.current = generation
+		g.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Current returns the current generation, used for reads.
+func (g *Generations) Current() int64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.current
+}
+
+// Next returns the concrete index of the generation being built, if any.
+func (g *Generations) Next() (string, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.next == 0 {
+		return "", false
+	}
+	return GenerationIndex(g.Index, g.next), true
+}
+
+// WriteIndices returns indices into which changed documents should be indexed:
+// the index itself and the concrete index of the generation being built, if any.
+func (g *Generations) WriteIndices() []string {
+	if next, ok := g.Next(); ok {
+		return []string{g.Index, next}
+	}
+	return []string{g.Index}
+}
+
+// IndexRequest returns a bulk request indexing the document at the revision into the index
+// (one of WriteIndices). Documents indexed into the concrete index of the generation being built use
+// the revision as an external version, so that an older revision (e.g., indexed while populating the
+// generation) does not replace a newer one.
+func (g *Generations) IndexRequest(index string, id identifier.Identifier, revision int64, data interface{}) *elastic.BulkIndexRequest {
+	req := elastic.NewBulkIndexRequest().Index(index).Id(id.String()).Doc(data)
+	if index != g.Index {
+		req = req.Version(revision).VersionType("external_gte")
+	}
+	return req
+}
+
+// Begin creates the concrete index of the next generation and starts routing writes to it
+// as well. It returns the name of the concrete index which should be populated with all documents.
+//
+// The concrete index is created with synonym rules (see UpdateSynonyms for synonymsDir).
+// If rules change while the next generation is being built, they should be applied to
+// the concrete index before cutover.
+//
+// Generations older than the previous generation (e.g., left behind by a restarted process)
+// are deleted.
+func (g *Generations) Begin(ctx context.Context, sizeField bool, synonyms []string, synonymsDir string) (string, errors.E) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next != 0 {
+		errE := errors.WithStack(ErrReindexInProgress)
+		errors.Details(errE)["index"] = g.Index
+		return "", errE
+	}
+
+	errE := g.deleteStale(ctx)
+	if errE != nil {
+		return "", errE
+	}
+
+	next := g.current + 1
+	index := GenerationIndex(g.Index, next)
+
+	config, errE := getIndexConfiguration(sizeField)
+	if errE != nil {
+		return "", errE
+	}
+	if synonymsDir != "" {
+		// The synonym file has to exist before the index is created.
+		_, errE = writeSynonymsFile(synonymsDir, index, synonyms)
+		if errE != nil {
+			errors.Details(errE)["index"] = index
+			return "", errE
+		}
+	}
+	config = withSynonyms(config, index, synonyms, synonymsDir)
+	createIndex, err := g.esClient.CreateIndex(index).BodyJson(config).Do(ctx)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["index"] = index
+		return "", errE
+	}
+	if !createIndex.Acknowledged {
+		errE := errors.New("create index not acknowledged")
+		errors.Details(errE)["index"] = index
+		return "", errE
+	}
+
+	g.next = next
+	return index, nil
+}
+
+// deleteStale deletes concrete indices of generations other than the current generation
+// and the previous generation (which might still be used by pagination sessions).
+func (g *Generations) deleteStale(ctx context.Context) errors.E {
+	res, err := g.esClient.IndexGetSettings(g.Index + "_*").Name("index.uuid").Do(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for concrete := range res {
+		generation, errE := parseGeneration(g.Index, concrete)
+		if errE != nil {
+			// Not an index generation.
+			continue
+		}
+		if generation == g.current || generation == g.current-1 {
+			continue
+		}
+		_, err := g.esClient.DeleteIndex(concrete).Do(ctx)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["index"] = concrete
+			return errE
+		}
+	}
+	return nil
+}
+
+// Abort stops building the next generation and deletes its concrete index.
+func (g *Generations) Abort(ctx context.Context) errors.E {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next == 0 {
+		return nil
+	}
+	index := GenerationIndex(g.Index, g.next)
+	g.next = 0
+
+	_, err := g.esClient.DeleteIndex(index).Do(ctx)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["index"] = index
+		return errE
+	}
+	return nil
+}
+
+// Cutover atomically switches reads to the next generation, which should be fully populated
+// and flushed. The concrete index of the previous generation is deleted after retain duration.
+//
+// Generation 0 cannot be retained because the alias takes its name, so it is deleted immediately
+// and pagination sessions started before cutover expire.
+func (g *Generations) Cutover(ctx context.Context, retain time.Duration) errors.E {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.next == 0 {
+		return errors.New("no generation is being built")
+	}
+
+	previous := GenerationIndex(g.Index, g.current)
+	next := GenerationIndex(g.Index, g.next)
+
+	_, err := g.esClient.Refresh(next).Do(ctx)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["index"] = next
+		return errE
+	}
+
+	actions := []elastic.AliasAction{elastic.NewAliasAddAction(g.Index).Index(next)}
+	if g.current == 0 {
+		actions = append(actions, elastic.NewAliasRemoveIndexAction(previous))
+	} else {
+		actions = append(actions, elastic.NewAliasRemoveAction(g.Index).Index(previous))
+	}
+	res, err := g.esClient.Alias().Action(actions...).Do(ctx)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["index"] = g.Index
+		return errE
+	}
+	if !res.Acknowledged {
+		errE := errors.New("alias update not acknowledged")
+		errors.Details(errE)["index"] = g.Index
+		return errE
+	}
+
+	if g.current != 0 {
+		esClient := g.esClient
+		time.AfterFunc(retain, func() {
+			// If this fails (or the process exits before), the index is deleted by a later Begin.
+			_, _ = esClient.DeleteIndex(previous).Do(context.Background())
+		})
+	}
+
+	g.current = g.next
+	g.next = 0
+	return nil
+}
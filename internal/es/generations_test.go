@@ -0,0 +1,59 @@
+package es
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+)
+
+func TestGenerationIndex(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		generation int64
+		concrete   string
+	}{
+		{0, "docs"},
+		{1, "docs_1"},
+		{42, "docs_42"},
+	} {
+		assert.Equal(t, tt.concrete, GenerationIndex("docs", tt.generation))
+		generation, errE := parseGeneration("docs", tt.concrete)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, tt.generation, generation)
+	}
+
+	for _, concrete := range []string{"other", "docs_", "docs_0", "docs_x", "docs_1_1"} {
+		_, errE := parseGeneration("docs", concrete)
+		assert.Error(t, errE, concrete)
+	}
+}
+
+func TestGenerationsWriteIndices(t *testing.T) {
+	t.Parallel()
+
+	g := &Generations{Index: "docs"} //nolint:exhaustruct
+	g.current = 1
+
+	assert.Equal(t, []string{"docs"}, g.WriteIndices())
+	_, ok := g.Next()
+	assert.False(t, ok)
+
+	g.next = 2
+
+	assert.Equal(t, []string{"docs", "docs_2"}, g.WriteIndices())
+	next, ok := g.Next()
+	assert.True(t, ok)
+	assert.Equal(t, "docs_2", next)
+
+	id := identifier.New()
+	source, err := g.IndexRequest("docs", id, 3, map[string]interface{}{}).Source()
+	require.NoError(t, err)
+	assert.NotContains(t, source[0], "version")
+	source, err = g.IndexRequest("docs_2", id, 3, map[string]interface{}{}).Source()
+	require.NoError(t, err)
+	assert.Contains(t, source[0], `"version":3`)
+	assert.Contains(t, source[0], `"version_type":"external_gte"`)
+}
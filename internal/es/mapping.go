@@ -0,0 +1,125 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+)
+
+// IndexMappingDifferences returns differences between the mapping of the existing index
+// and the mapping new indices are created with (see ensureIndex), one per differing field.
+// No differences means that the mapping of the index is up to date.
+func IndexMappingDifferences(ctx context.Context, esClient *elastic.Client, index string, sizeField bool) ([]string, errors.E) {
+	config, errE := getIndexConfiguration(sizeField)
+	if errE != nil {
+		return nil, errE
+	}
+
+	res, err := esClient.GetMapping().Index(index).Do(ctx)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["index"] = index
+		return nil, errE
+	}
+	// Index can be an alias, so the response is keyed by the name of the index it points to.
+	if len(res) != 1 {
+		errE := errors.New("unexpected number of indices")
+		errors.Details(errE)["index"] = index
+		errors.Details(errE)["indices"] = slices.Collect(maps.Keys(res))
+		return nil, errE
+	}
+	var mappings interface{}
+	for _, r := range res {
+		if m, ok := r.(map[string]interface{}); ok {
+			mappings = m["mappings"]
+		}
+	}
+
+	differences := []string{}
+	compareMappings("", config.Mappings, mappings, &differences)
+	return differences, nil
+}
+
+// compareMappings compares the expected mapping with the actual one and appends
+// a description of every difference to differences. Scalar values are compared
+// in their string form because ElasticSearch returns some of them as strings
+// (e.g., "false" for dynamic mapping). Fields added dynamically to objects
+// with dynamic mapping enabled (e.g., "scores") are not reported as unexpected.
+func compareMappings(path string, expected, actual interface{}, differences *[]string) {
+	compareMappingsDynamic(path, expected, actual, false, differences)
+}
+
+func compareMappingsDynamic(path string, expected, actual interface{}, dynamic bool, differences *[]string) {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			*differences = append(*differences, fmt.Sprintf("%s: expected an object", path))
+			return
+		}
+		keys := slices.Collect(maps.Keys(e))
+		if !dynamic {
+			for key := range a {
+				if _, ok := e[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			if _, ok := a[key]; !ok {
+				*differences = append(*differences, fmt.Sprintf("%s: missing", p))
+			} else if _, ok := e[key]; !ok {
+				*differences = append(*differences, fmt.Sprintf("%s: unexpected", p))
+			} else {
+				compareMappingsDynamic(p, e[key], a[key], key == "properties" && e["dynamic"] == true, differences)
+			}
+		}
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(e) {
+			*differences = append(*differences, fmt.Sprintf("%s: expected an array of length %d", path, len(e)))
+			return
+		}
+		for i := range e {
+			compareMappingsDynamic(fmt.Sprintf("%s[%d]", path, i), e[i], a[i], false, differences)
+		}
+	default:
+		if fmt.Sprint(expected) != fmt.Sprint(actual) {
+			*differences = append(*differences, fmt.Sprintf("%s: expected %v, got %v", path, expected, actual))
+		}
+	}
+}
+
+// UpdateSortKeysMapping updates the mapping of the existing index so that materialized
+// sort keys (see SortKeyField) can be indexed. Indices created before sort keys were
+// materialized do not have the necessary mapping.
+func UpdateSortKeysMapping(ctx context.Context, esClient *elastic.Client, index string, sizeField bool) errors.E {
+	config, errE := getIndexConfiguration(sizeField)
+	if errE != nil {
+		return errE
+	}
+
+	properties, _ := config.Mappings["properties"].(map[string]interface{})
+	// Dynamic templates are replaced as a whole, so we provide all of them.
+	_, err := esClient.PutMapping().Index(index).BodyJson(map[string]interface{}{
+		"dynamic_templates": config.Mappings["dynamic_templates"],
+		"properties": map[string]interface{}{
+			"sortKeys": properties["sortKeys"],
+		},
+	}).Do(ctx)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["index"] = index
+		return errE
+	}
+	return nil
+}
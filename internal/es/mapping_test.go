@@ -0,0 +1,59 @@
+package es
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+)
+
+func TestCompareMappings(t *testing.T) {
+	t.Parallel()
+
+	config, errE := getIndexConfiguration(true)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	differences := []string{}
+	compareMappings("", config.Mappings, config.Mappings, &differences)
+	assert.Empty(t, differences)
+
+	var actual map[string]interface{}
+	errE = x.UnmarshalWithoutUnknownFields([]byte(`{
+		"dynamic": "false",
+		"properties": {
+			"id": {"type": "keyword"},
+			"score": {"type": "text"},
+			"extra": {"type": "keyword"}
+		}
+	}`), &actual)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	differences = []string{}
+	compareMappings("", map[string]interface{}{
+		"dynamic": false,
+		"properties": map[string]interface{}{
+			"id":     map[string]interface{}{"type": "keyword"},
+			"score":  map[string]interface{}{"type": "double"},
+			"claims": map[string]interface{}{"type": "object"},
+		},
+	}, actual, &differences)
+	assert.Equal(t, []string{
+		"properties.claims: missing",
+		"properties.extra: unexpected",
+		"properties.score.type: expected double, got text",
+	}, differences)
+
+	// Dynamically added fields are expected.
+	differences = []string{}
+	compareMappings("", map[string]interface{}{
+		"scores": map[string]interface{}{"dynamic": true, "properties": map[string]interface{}{}},
+	}, map[string]interface{}{
+		"scores": map[string]interface{}{"dynamic": "true", "properties": map[string]interface{}{"foo": map[string]interface{}{"type": "double"}}},
+	}, &differences)
+	assert.Empty(t, differences)
+
+	differences = []string{}
+	compareMappings("", map[string]interface{}{"copy_to": []interface{}{"a", "b"}}, map[string]interface{}{"copy_to": "a"}, &differences)
+	assert.Equal(t, []string{"copy_to: expected an array of length 2"}, differences)
+}
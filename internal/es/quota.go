@@ -0,0 +1,202 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const (
+	// quotaRefreshInterval is how often index usage is refreshed from ElasticSearch.
+	quotaRefreshInterval = 30 * time.Second
+
+	// quotaAlertTimeout is the timeout for sending an alert to the webhook.
+	quotaAlertTimeout = 10 * time.Second
+)
+
+var ErrQuotaExceeded = errors.Base("index quota exceeded")
+
+// Quota limits the size of an index.
+//
+// Quotas are soft: usage is refreshed from ElasticSearch only periodically and documents
+// waiting to be indexed are not counted, so usage can go somewhat over limits before new
+// writes are rejected.
+type Quota struct {
+	// MaxDocuments is the maximum number of documents in the index. Zero disables the limit.
+	MaxDocuments int64 `yaml:"maxDocuments,omitempty"`
+
+	// MaxBytes is the maximum size of the index (of its primary shards) in bytes. Zero disables the limit.
+	MaxBytes int64 `yaml:"maxBytes,omitempty"`
+
+	// Webhook is URL to which usage is POSTed as JSON when the quota is exceeded.
+	Webhook string `yaml:"webhook,omitempty"`
+
+	logger     zerolog.Logger
+	httpClient *http.Client
+	index      string
+	stats      func(ctx context.Context) (int64, int64, errors.E)
+
+	mu        sync.Mutex
+	usage     QuotaUsage
+	refreshed time.Time
+}
+
+// QuotaUsage is the current usage of the index.
+type QuotaUsage struct {
+	Index        string    `json:"index"`
+	Documents    int64     `json:"documents"`
+	Bytes        int64     `json:"bytes"`
+	MaxDocuments int64     `json:"maxDocuments,omitempty"`
+	MaxBytes     int64     `json:"maxBytes,omitempty"`
+	Exceeded     bool      `json:"exceeded"`
+	Refreshed    time.Time `json:"refreshed"`
+}
+
+// Init initializes the Quota for the index.
+func (q *Quota) Init(logger zerolog.Logger, httpClient *http.Client, esClient *elastic.Client, index string) errors.E {
+	if q.stats != nil {
+		return errors.New("already initialized")
+	}
+	if q.MaxDocuments < 0 || q.MaxBytes < 0 {
+		errE := errors.New("invalid quota")
+		errors.Details(errE)["maxDocuments"] = q.MaxDocuments
+		errors.Details(errE)["maxBytes"] = q.MaxBytes
+		return errE
+	}
+
+	q.logger = logger
+	q.httpClient = httpClient
+	q.index = index
+	q.stats = func(ctx context.Context) (int64, int64, errors.E) {
+		return indexStats(ctx, esClient, index)
+	}
+	return nil
+}
+
+// indexStats returns the number of documents in the index and the size of its primary shards.
+func indexStats(ctx context.Context, esClient *elastic.Client, index string) (int64, int64, errors.E) {
+	// We count documents using the count API because index stats count nested documents as well.
+	documents, err := esClient.Count(index).Do(ctx)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	stats, err := esClient.IndexStats(index).Metric("store").Do(ctx)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	var size int64
+	// Index can be an alias, so we sum over all indices.
+	for _, s := range stats.Indices {
+		if s.Primaries != nil && s.Primaries.Store != nil {
+			size += s.Primaries.Store.SizeInBytes
+		}
+	}
+	return documents, size, nil
+}
+
+// Usage returns the current usage of the index, refreshing it from ElasticSearch if it is stale.
+func (q *Quota) Usage(ctx context.Context) (QuotaUsage, errors.E) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if time.Since(q.refreshed) < quotaRefreshInterval {
+		return q.usage, nil
+	}
+
+	documents, size, errE := q.stats(ctx)
+	if errE != nil {
+		errors.Details(errE)["index"] = q.index
+		return q.usage, errE
+	}
+
+	wasExceeded := q.usage.Exceeded
+	q.refreshed = time.Now().UTC()
+	q.usage = QuotaUsage{
+		Index:        q.index,
+		Documents:    documents,
+		Bytes:        size,
+		MaxDocuments: q.MaxDocuments,
+		MaxBytes:     q.MaxBytes,
+		Exceeded:     (q.MaxDocuments > 0 && documents >= q.MaxDocuments) || (q.MaxBytes > 0 && size >= q.MaxBytes),
+		Refreshed:    q.refreshed,
+	}
+
+	// We alert only once when the quota becomes exceeded.
+	if q.usage.Exceeded && !wasExceeded {
+		q.logger.Warn().Str("index", q.index).Int64("documents", documents).Int64("bytes", size).
+			Int64("maxDocuments", q.MaxDocuments).Int64("maxBytes", q.MaxBytes).Msg("index quota exceeded")
+		if q.Webhook != "" {
+			go q.alert(context.WithoutCancel(ctx), q.usage)
+		}
+	}
+
+	return q.usage, nil
+}
+
+// alert POSTs usage to the webhook.
+func (q *Quota) alert(ctx context.Context, usage QuotaUsage) {
+	ctx, cancel := context.WithTimeout(ctx, quotaAlertTimeout)
+	defer cancel()
+
+	errE := func() errors.E {
+		data, errE := x.MarshalWithoutEscapeHTML(usage)
+		if errE != nil {
+			return errE
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.Webhook, bytes.NewReader(data))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := q.httpClient.Do(req)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+			errE := errors.New("unexpected status code")
+			errors.Details(errE)["code"] = resp.StatusCode
+			return errE
+		}
+		return nil
+	}()
+	if errE != nil {
+		q.logger.Error().Err(errE).Str("index", q.index).Msg("index quota alert failed")
+	}
+}
+
+// Check returns ErrQuotaExceeded if the index is over the quota.
+//
+// If usage cannot be refreshed, the last known usage is used.
+func (q *Quota) Check(ctx context.Context) errors.E {
+	if q == nil || (q.MaxDocuments == 0 && q.MaxBytes == 0) {
+		return nil
+	}
+
+	usage, errE := q.Usage(ctx)
+	if errE != nil {
+		q.logger.Warn().Err(errE).Msg("index quota usage refresh failed")
+	}
+	if !usage.Exceeded {
+		return nil
+	}
+
+	errE = errors.WithStack(ErrQuotaExceeded)
+	errors.Details(errE)["index"] = usage.Index
+	errors.Details(errE)["documents"] = usage.Documents
+	errors.Details(errE)["bytes"] = usage.Bytes
+	if usage.MaxDocuments > 0 {
+		errors.Details(errE)["maxDocuments"] = usage.MaxDocuments
+	}
+	if usage.MaxBytes > 0 {
+		errors.Details(errE)["maxBytes"] = usage.MaxBytes
+	}
+	return errE
+}
@@ -0,0 +1,107 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	alerts := make(chan QuotaUsage, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var usage QuotaUsage
+		err := json.NewDecoder(req.Body).Decode(&usage)
+		assert.NoError(t, err)
+		alerts <- usage
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(ts.Close)
+
+	documents := int64(5)
+	size := int64(1000)
+
+	q := &Quota{ //nolint:exhaustruct
+		MaxDocuments: 10,
+		MaxBytes:     0,
+		Webhook:      ts.URL,
+	}
+	errE := q.Init(zerolog.Nop(), ts.Client(), nil, "test")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	q.stats = func(_ context.Context) (int64, int64, errors.E) {
+		return documents, size, nil
+	}
+
+	ctx := context.Background()
+
+	errE = q.Check(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// Usage is cached.
+	documents = 10
+	errE = q.Check(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	q.refreshed = time.Time{}
+	errE = q.Check(ctx)
+	assert.ErrorIs(t, errE, ErrQuotaExceeded)
+	assert.Equal(t, int64(10), errors.Details(errE)["documents"])
+	assert.Equal(t, int64(10), errors.Details(errE)["maxDocuments"])
+
+	select {
+	case usage := <-alerts:
+		assert.Equal(t, "test", usage.Index)
+		assert.Equal(t, int64(10), usage.Documents)
+		assert.True(t, usage.Exceeded)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "alert not received")
+	}
+
+	// An alert is sent only once while the quota stays exceeded.
+	q.refreshed = time.Time{}
+	errE = q.Check(ctx)
+	assert.ErrorIs(t, errE, ErrQuotaExceeded)
+
+	// Failing to refresh usage uses the last known usage.
+	q.refreshed = time.Time{}
+	q.stats = func(_ context.Context) (int64, int64, errors.E) {
+		return 0, 0, errors.New("test error")
+	}
+	errE = q.Check(ctx)
+	assert.ErrorIs(t, errE, ErrQuotaExceeded)
+
+	q.refreshed = time.Time{}
+	q.stats = func(_ context.Context) (int64, int64, errors.E) {
+		return 9, size, nil
+	}
+	usage, errE := q.Usage(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.False(t, usage.Exceeded)
+	errE = q.Check(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.Empty(t, alerts)
+
+	// A nil quota or a quota without limits never rejects.
+	var nilQuota *Quota
+	require.NoError(t, nilQuota.Check(ctx))
+}
+
+func TestQuotaInvalid(t *testing.T) {
+	t.Parallel()
+
+	q := &Quota{ //nolint:exhaustruct
+		MaxDocuments: -1,
+	}
+	errE := q.Init(zerolog.Nop(), http.DefaultClient, nil, "test")
+	assert.Error(t, errE)
+}
@@ -0,0 +1,276 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+// References maintains counts of how many other documents reference a document
+// through relation claims.
+//
+// For every document it stores the set of documents it references. The set is
+// replaced every time the document is indexed, so counts are updated incrementally
+// and do not depend on the order in which documents are indexed.
+type References struct {
+	// Prefix to use when initializing PostgreSQL objects used by references.
+	Prefix string
+	// Restricted are properties whose claims are removed from documents before they
+	// are indexed (see RemoveRestricted).
+	Restricted []identifier.Identifier
+
+	dbpool *pgxpool.Pool
+}
+
+// Init initializes the References.
+//
+// It creates and configures the PostgreSQL table and index if they do not already exist.
+func (r *References) Init(ctx context.Context, dbpool *pgxpool.Pool) errors.E {
+	if r.dbpool != nil {
+		return errors.New("already initialized")
+	}
+
+	// TODO: Use schema management/migration instead.
+	errE := internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		_, err := tx.Exec(ctx, `
+			CREATE TABLE "`+r.Prefix+`References" (
+				-- ID of the document with the relation claim.
+				"source" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				-- ID of the document the relation claim points to.
+				"target" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				PRIMARY KEY ("source", "target")
+			);
+			CREATE INDEX "`+r.Prefix+`ReferencesTarget" ON "`+r.Prefix+`References" ("target");
+		`)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+
+		return nil
+	}, nil)
+	if errE != nil {
+		var pgError *pgconn.PgError
+		if errors.As(errE, &pgError) {
+			switch pgError.Code {
+			case internal.ErrorCodeUniqueViolation:
+				// Nothing.
+			case internal.ErrorCodeDuplicateTable:
+				// Nothing.
+			case internal.ErrorCodeReadOnlyTransaction:
+				// In read-only mode, tables have to be created by another instance.
+			default:
+				return errE
+			}
+		} else {
+			return errE
+		}
+	}
+
+	r.dbpool = dbpool
+
+	return nil
+}
+
+// Update replaces the set of documents referenced by the source document.
+// It returns documents whose reference counts changed.
+func (r *References) Update(ctx context.Context, source identifier.Identifier, targets []string) ([]identifier.Identifier, errors.E) {
+	// Documents referencing themselves are not counted.
+	targets = slices.DeleteFunc(slices.Clone(targets), func(t string) bool {
+		return t == source.String()
+	})
+	arguments := []any{
+		source.String(), targets,
+	}
+	var changed []string
+	errE := internal.RetryTransaction(ctx, r.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		// Initialize in the case transaction is retried.
+		changed = []string{}
+
+		rows, err := tx.Query(ctx, `
+			DELETE FROM "`+r.Prefix+`References" WHERE "source"=$1 AND NOT ("target"=ANY($2::text[])) RETURNING "target"
+		`, arguments...)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+		removed, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+
+		rows, err = tx.Query(ctx, `
+			INSERT INTO "`+r.Prefix+`References" SELECT $1, UNNEST($2::text[]) ON CONFLICT DO NOTHING RETURNING "target"
+		`, arguments...)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+		added, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+
+		changed = append(removed, added...) //nolint:gocritic
+		return nil
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["doc"] = source.String()
+		return nil, errE
+	}
+
+	return targetIDs(changed), nil
+}
+
+// Delete removes the set of documents referenced by the source document, e.g., because
+// the source document has been deleted. It returns documents whose reference counts changed.
+func (r *References) Delete(ctx context.Context, source identifier.Identifier) ([]identifier.Identifier, errors.E) {
+	var removed []string
+	errE := internal.RetryTransaction(ctx, r.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		rows, err := tx.Query(ctx, `
+			DELETE FROM "`+r.Prefix+`References" WHERE "source"=$1 RETURNING "target"
+		`, source.String())
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+		removed, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["doc"] = source.String()
+		return nil, errE
+	}
+
+	return targetIDs(removed), nil
+}
+
+// targetIDs converts targets to IDs.
+func targetIDs(targets []string) []identifier.Identifier {
+	result := []identifier.Identifier{}
+	for _, t := range targets {
+		id, errE := identifier.FromString(t)
+		if errE != nil {
+			// Targets which are not valid IDs are still counted, but they cannot be reindexed.
+			continue
+		}
+		result = append(result, id)
+	}
+	return result
+}
+
+// Count returns the number of documents referencing the document.
+func (r *References) Count(ctx context.Context, id identifier.Identifier) (int64, errors.E) {
+	var count int64
+	errE := internal.RetryTransaction(ctx, r.dbpool, pgx.ReadOnly, func(ctx context.Context, tx pgx.Tx) errors.E {
+		err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM "`+r.Prefix+`References" WHERE "target"=$1`, id.String()).Scan(&count)
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		return 0, errE
+	}
+	return count, nil
+}
+
+// PrepareDocument removes claims of restricted properties from the document, updates the set of
+// documents referenced by the document, and then prepares the document for indexing (see PrepareDocument)
+// with the added "references" field containing the number of documents referencing the document.
+//
+// It returns documents whose reference counts changed and which should be reindexed.
+func (r *References) PrepareDocument(
+	ctx context.Context, id identifier.Identifier, data json.RawMessage,
+) (json.RawMessage, []identifier.Identifier, errors.E) {
+	data, errE := RemoveRestricted(data, r.Restricted)
+	if errE != nil {
+		return nil, nil, errE
+	}
+	targets, errE := relationTargets(data)
+	if errE != nil {
+		return nil, nil, errE
+	}
+	changed, errE := r.Update(ctx, id, targets)
+	if errE != nil {
+		return nil, nil, errE
+	}
+	count, errE := r.Count(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
+	}
+	data, errE = PrepareDocument(data)
+	if errE != nil {
+		return nil, nil, errE
+	}
+	data, errE = setReferences(data, count)
+	if errE != nil {
+		return nil, nil, errE
+	}
+	return data, changed, nil
+}
+
+// relationTargetsClaims are claims of a document, by claim type, as needed by relationTargets.
+type relationTargetsClaims map[string][]struct {
+	To *struct {
+		ID string `json:"id"`
+	} `json:"to"`
+	Meta relationTargetsClaims `json:"meta"`
+}
+
+// relationTargetsDocument is a document as needed by relationTargets.
+type relationTargetsDocument struct {
+	Claims   relationTargetsClaims     `json:"claims"`
+	Children []relationTargetsDocument `json:"children"`
+}
+
+// relationTargets returns IDs of documents to which relation claims of the document point,
+// including relation meta claims and relation claims of its children. IDs are sorted.
+func relationTargets(data json.RawMessage) ([]string, errors.E) {
+	var doc relationTargetsDocument
+	errE := x.Unmarshal(data, &doc)
+	if errE != nil {
+		return nil, errE
+	}
+	targets := []string{}
+	addDocumentRelationTargets(&targets, doc)
+	slices.Sort(targets)
+	return slices.Compact(targets), nil
+}
+
+func addDocumentRelationTargets(targets *[]string, doc relationTargetsDocument) {
+	addRelationTargets(targets, doc.Claims)
+	for _, child := range doc.Children {
+		addDocumentRelationTargets(targets, child)
+	}
+}
+
+// addRelationTargets adds to targets IDs of documents to which relation claims point, recursing into meta claims.
+func addRelationTargets(targets *[]string, claims relationTargetsClaims) {
+	for claimType, cs := range claims {
+		for _, claim := range cs {
+			if claimType == "rel" && claim.To != nil && claim.To.ID != "" {
+				*targets = append(*targets, claim.To.ID)
+			}
+			addRelationTargets(targets, claim.Meta)
+		}
+	}
+}
+
+// setReferences sets the "references" field of the document.
+func setReferences(data json.RawMessage, count int64) (json.RawMessage, errors.E) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// We want to preserve numbers exactly as they are.
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	doc["references"] = count
+	return x.MarshalWithoutEscapeHTML(doc)
+}
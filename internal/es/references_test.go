@@ -0,0 +1,39 @@
+package es
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelationTargets(t *testing.T) {
+	t.Parallel()
+
+	targets, errE := relationTargets(json.RawMessage(`{"id":"x","claims":{` +
+		`"rel":[{"id":"r1","prop":{"id":"p"},"to":{"id":"a"}},{"id":"r2","prop":{"id":"q"},"to":{"id":"b"}},{"id":"r3","prop":{"id":"q"},"to":{"id":"a"}}],` +
+		`"string":[{"id":"s","prop":{"id":"p"},"string":"foo"}]}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"a", "b"}, targets)
+
+	// Relation meta claims (of any claim) and relation claims of children are included.
+	targets, errE = relationTargets(json.RawMessage(`{"id":"x","claims":{` +
+		`"rel":[{"id":"r1","prop":{"id":"p"},"to":{"id":"c"},"meta":{"rel":[{"id":"r2","prop":{"id":"p"},"to":{"id":"b"}}]}}],` +
+		`"string":[{"id":"s","prop":{"id":"p"},"string":"foo","meta":{"rel":[{"id":"r3","prop":{"id":"p"},"to":{"id":"a"}}]}}]},` +
+		`"children":[{"id":"y","claims":{"rel":[{"id":"r4","prop":{"id":"p"},"to":{"id":"d"}}]}}]}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, targets)
+
+	targets, errE = relationTargets(json.RawMessage(`{"id":"x"}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Empty(t, targets)
+}
+
+func TestSetReferences(t *testing.T) {
+	t.Parallel()
+
+	data, errE := setReferences(json.RawMessage(`{"id":"x","score":0.5}`), 1234)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, `{"id":"x","score":0.5,"references":1234}`, string(data))
+}
@@ -0,0 +1,265 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// SynonymsFilter is the name of the synonym graph token filter used by the search analyzer
+// of text fields. Its rules are applied at query time only, so they can be changed without reindexing.
+const SynonymsFilter = "synonyms"
+
+// SynonymsPath is the path of the directory with synonym files, relative to the config
+// directory of ElasticSearch nodes. The directory into which synonym files are written
+// (see UpdateSynonyms) has to be available at this path to all ElasticSearch nodes.
+const SynonymsPath = "peerdb-synonyms"
+
+// synonymsFile returns the name of the synonym file of the concrete index.
+func synonymsFile(index string) string {
+	return index + ".txt"
+}
+
+// synonymsFilterSettings returns settings of the synonyms token filter of the concrete index.
+//
+// If dir is empty, rules are stored in index settings. Otherwise rules are read from the index's
+// synonym file (which should be written into dir first) and the filter is updateable, so that
+// changed rules can be loaded without closing the index.
+func synonymsFilterSettings(index string, rules []string, dir string) map[string]interface{} {
+	if dir == "" {
+		return map[string]interface{}{
+			"type":     "synonym_graph",
+			"lenient":  true,
+			"synonyms": rules,
+		}
+	}
+	return map[string]interface{}{
+		"type":          "synonym_graph",
+		"lenient":       true,
+		"updateable":    true,
+		"synonyms_path": SynonymsPath + "/" + synonymsFile(index),
+	}
+}
+
+// withSynonyms sets synonyms token filter of the index configuration for the concrete index.
+func withSynonyms(config indexConfigurationStruct, index string, rules []string, dir string) indexConfigurationStruct {
+	analysis, _ := config.Settings["analysis"].(map[string]interface{})
+	if analysis == nil {
+		return config
+	}
+	filter, _ := analysis["filter"].(map[string]interface{})
+	if filter == nil {
+		return config
+	}
+	filter[SynonymsFilter] = synonymsFilterSettings(index, rules, dir)
+	return config
+}
+
+// writeSynonymsFile writes rules into the synonym file of the concrete index in dir.
+// It returns true if the file has changed.
+func writeSynonymsFile(dir, index string, rules []string) (bool, errors.E) {
+	var data bytes.Buffer
+	for _, rule := range rules {
+		data.WriteString(rule)
+		data.WriteString("\n")
+	}
+
+	path := filepath.Join(dir, synonymsFile(index))
+	existing, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(existing, data.Bytes()) {
+		return false, nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, errors.WithStack(err)
+	}
+
+	// We write into a temporary file first and rename it, so that
+	// ElasticSearch nodes never read a partially written file.
+	f, err := os.CreateTemp(dir, "."+synonymsFile(index)+".*")
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck
+	_, err = f.Write(data.Bytes())
+	err2 := f.Close()
+	if err = errors.Join(err, err2); err != nil {
+		return false, errors.WithStack(err)
+	}
+	// Synonym files have to be readable by ElasticSearch nodes.
+	err = os.Chmod(f.Name(), 0o644) //nolint:gosec,mnd
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, nil
+}
+
+type reloadSearchAnalyzersResponse struct {
+	Shards struct {
+		Failed int `json:"failed"`
+	} `json:"_shards"`
+}
+
+// reloadSearchAnalyzers makes the concrete index load its synonym file again.
+func reloadSearchAnalyzers(ctx context.Context, esClient *elastic.Client, index string) errors.E {
+	res, err := esClient.PerformRequest(ctx, elastic.PerformRequestOptions{ //nolint:exhaustruct
+		Method: http.MethodPost,
+		Path:   "/" + index + "/_reload_search_analyzers",
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var response reloadSearchAnalyzersResponse
+	errE := x.Unmarshal(res.Body, &response)
+	if errE != nil {
+		return errE
+	}
+	if response.Shards.Failed > 0 {
+		errE := errors.New("reloading search analyzers failed")
+		errors.Details(errE)["failed"] = response.Shards.Failed
+		return errE
+	}
+	return nil
+}
+
+// currentSynonymsFilters returns settings of the synonyms token filter for every index the index name resolves to.
+func currentSynonymsFilters(ctx context.Context, esClient *elastic.Client, index string) (map[string]map[string]interface{}, errors.E) {
+	res, err := esClient.IndexGetSettings(index).Name("index.analysis.filter." + SynonymsFilter + ".*").Do(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	filters := map[string]map[string]interface{}{}
+	for name, r := range res {
+		// Settings are nested as index.analysis.filter.<name>.
+		var value interface{} = r.Settings
+		for _, key := range []string{"index", "analysis", "filter", SynonymsFilter} {
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = m[key]
+		}
+		filter, _ := value.(map[string]interface{})
+		if filter == nil {
+			filter = map[string]interface{}{}
+		}
+		filters[name] = filter
+	}
+	return filters, nil
+}
+
+// filterRules returns synonym rules stored in settings of the synonyms token filter.
+func filterRules(filter map[string]interface{}) []string {
+	rules := []string{}
+	list, _ := filter["synonyms"].([]interface{})
+	for _, rule := range list {
+		if s, ok := rule.(string); ok {
+			rules = append(rules, s)
+		}
+	}
+	return rules
+}
+
+// isUpdateableFilter returns true if the synonyms token filter of the concrete index reads
+// rules from its synonym file and they can be reloaded.
+func isUpdateableFilter(filter map[string]interface{}, index string) bool {
+	// Settings are returned as strings.
+	return filter["updateable"] == "true" && filter["synonyms_path"] == SynonymsPath+"/"+synonymsFile(index)
+}
+
+// UpdateSynonyms sets synonym rules (in Solr format) used at query time by the index.
+//
+// If dir is set, rules are written into synonym files in dir (see SynonymsPath) and
+// search analyzers of the index are reloaded, without closing the index. Otherwise
+// rules are stored in index settings and the index has to be closed to update them,
+// so it is briefly unavailable. The index is closed also the first time its rules are
+// moved from index settings to a synonym file (or back, if dir is not set anymore).
+//
+// The index is updated only if its rules differ from provided rules.
+func UpdateSynonyms(ctx context.Context, esClient *elastic.Client, index string, rules []string, dir string) errors.E {
+	filters, errE := currentSynonymsFilters(ctx, esClient, index)
+	if errE != nil {
+		errors.Details(errE)["index"] = index
+		return errE
+	}
+
+	if len(filters) == 0 {
+		errE := errors.New("index not found")
+		errors.Details(errE)["index"] = index
+		return errE
+	}
+
+	// Index names are sorted so that indices are updated in a deterministic order.
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		errE := updateIndexSynonyms(ctx, esClient, name, filters[name], rules, dir)
+		if errE != nil {
+			errors.Details(errE)["index"] = name
+			return errE
+		}
+	}
+
+	return nil
+}
+
+// updateIndexSynonyms sets synonym rules of the concrete index with the current synonyms token filter settings.
+func updateIndexSynonyms(
+	ctx context.Context, esClient *elastic.Client, index string, filter map[string]interface{}, rules []string, dir string,
+) errors.E {
+	if dir != "" {
+		changed, errE := writeSynonymsFile(dir, index, rules)
+		if errE != nil {
+			return errE
+		}
+		if isUpdateableFilter(filter, index) {
+			if !changed {
+				return nil
+			}
+			return reloadSearchAnalyzers(ctx, esClient, index)
+		}
+	} else if filter["synonyms_path"] == nil && slices.Equal(filterRules(filter), rules) {
+		return nil
+	}
+
+	settings := synonymsFilterSettings(index, rules, dir)
+	// Settings of the other way of providing rules are removed.
+	for _, key := range []string{"synonyms", "synonyms_path", "updateable"} {
+		if _, ok := settings[key]; !ok && filter[key] != nil {
+			settings[key] = nil
+		}
+	}
+	_, err := esClient.CloseIndex(index).Do(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = esClient.IndexPutSettings(index).BodyJson(map[string]interface{}{
+		"analysis": map[string]interface{}{
+			"filter": map[string]interface{}{
+				SynonymsFilter: settings,
+			},
+		},
+	}).Do(ctx)
+
+	// We reopen the index even if updating settings failed. We do not use ctx
+	// so that the index is reopened even if ctx has been canceled meanwhile.
+	_, errOpen := esClient.OpenIndex(index).Do(context.WithoutCancel(ctx))
+
+	return errors.Join(err, errOpen)
+}
@@ -0,0 +1,127 @@
+package es
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+)
+
+func TestWriteSynonymsFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	changed, errE := writeSynonymsFile(dir, "docs_1", []string{"foo, bar", "baz => qux"})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, changed)
+
+	data, err := os.ReadFile(filepath.Join(dir, "docs_1.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo, bar\nbaz => qux\n", string(data))
+
+	changed, errE = writeSynonymsFile(dir, "docs_1", []string{"foo, bar", "baz => qux"})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.False(t, changed)
+
+	changed, errE = writeSynonymsFile(dir, "docs_1", []string{})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, changed)
+
+	// Temporary files are removed.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestSynonymsFilterSettings(t *testing.T) {
+	t.Parallel()
+
+	settings := synonymsFilterSettings("docs_1", []string{"foo, bar"}, "")
+	assert.Equal(t, []string{"foo, bar"}, settings["synonyms"])
+	assert.NotContains(t, settings, "synonyms_path")
+
+	settings = synonymsFilterSettings("docs_1", []string{"foo, bar"}, "/synonyms")
+	assert.NotContains(t, settings, "synonyms")
+	assert.Equal(t, "peerdb-synonyms/docs_1.txt", settings["synonyms_path"])
+	assert.Equal(t, true, settings["updateable"])
+
+	// Settings are returned by ElasticSearch as strings.
+	assert.True(t, isUpdateableFilter(map[string]interface{}{"updateable": "true", "synonyms_path": "peerdb-synonyms/docs_1.txt"}, "docs_1"))
+	assert.False(t, isUpdateableFilter(map[string]interface{}{"updateable": "true", "synonyms_path": "peerdb-synonyms/docs_1.txt"}, "docs_2"))
+	assert.False(t, isUpdateableFilter(map[string]interface{}{"synonyms": []interface{}{"foo, bar"}}, "docs_1"))
+}
+
+func TestWithSynonyms(t *testing.T) {
+	t.Parallel()
+
+	config, errE := getIndexConfiguration(false)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	config = withSynonyms(config, "docs_1", []string{"foo, bar"}, "")
+	assert.Equal(t, synonymsFilterSettings("docs_1", []string{"foo, bar"}, ""), config.Settings["analysis"].(map[string]interface{})["filter"].(map[string]interface{})[SynonymsFilter]) //nolint:forcetypeassert,errcheck
+
+	// Configuration of other indices is not changed.
+	config, errE = getIndexConfiguration(false)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []interface{}{}, config.Settings["analysis"].(map[string]interface{})["filter"].(map[string]interface{})[SynonymsFilter].(map[string]interface{})["synonyms"]) //nolint:forcetypeassert,errcheck
+}
+
+func TestGenerationsSynonyms(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("ELASTIC") == "" {
+		t.Skip("ELASTIC is not available")
+	}
+
+	ctx := context.Background()
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+
+	esClient, errE := GetClient(cleanhttp.DefaultPooledClient(), logger, os.Getenv("ELASTIC"))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	index := strings.ToLower(identifier.New().String())
+	errE = ensureIndex(ctx, esClient, index, false)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	t.Cleanup(func() {
+		_, _ = esClient.DeleteIndex(index + "*").Do(context.Background())
+	})
+
+	errE = UpdateSynonyms(ctx, esClient, index, []string{"foo, bar"}, "")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	g := &Generations{Index: index} //nolint:exhaustruct
+	errE = g.Init(ctx, esClient)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	// The next generation is created with current synonym rules.
+	next, errE := g.Begin(ctx, false, []string{"foo, bar"}, "")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	filters, errE := currentSynonymsFilters(ctx, esClient, next)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"foo, bar"}, filterRules(filters[next]))
+
+	// Rules changed while the next generation is being built are applied only to the current generation.
+	errE = UpdateSynonyms(ctx, esClient, index, []string{"foo, bar", "baz, qux"}, "")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	filters, errE = currentSynonymsFilters(ctx, esClient, next)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"foo, bar"}, filterRules(filters[next]))
+
+	// So they have to be applied to the next generation before cutover.
+	errE = UpdateSynonyms(ctx, esClient, next, []string{"foo, bar", "baz, qux"}, "")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = g.Cutover(ctx, 0)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	filters, errE = currentSynonymsFilters(ctx, esClient, index)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, filters, 1)
+	assert.Equal(t, []string{"foo, bar", "baz, qux"}, filterRules(filters[next]))
+}
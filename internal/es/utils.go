@@ -2,11 +2,11 @@ package es
 
 import (
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"slices"
@@ -16,16 +16,15 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-cleanhttp"
-	"github.com/hashicorp/go-retryablehttp"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/olivere/elastic/v7"
 	"github.com/rs/zerolog"
-	"gitlab.com/tozd/go/cli"
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/x"
 	"gitlab.com/tozd/identifier"
 
+	"gitlab.com/peerdb/peerdb/coordination"
 	"gitlab.com/peerdb/peerdb/coordinator"
 	"gitlab.com/peerdb/peerdb/document"
 	internal "gitlab.com/peerdb/peerdb/internal/store"
@@ -43,48 +42,17 @@ const (
 	bulkProcessorWorkers = 2
 	bulkActions          = 1000
 	flushInterval        = time.Second
-	clientRetryWaitMax   = 10 * 60 * time.Second
-	clientRetryMax       = 9
 	// TODO: Determine reasonable size for the buffer.
 	bridgeBufferSize = 100
 )
 
-func prepareFields(keysAndValues []interface{}) {
-	for i, keyOrValue := range keysAndValues {
-		// We want URLs logged as strings.
-		u, ok := keyOrValue.(*url.URL)
-		if ok {
-			keysAndValues[i] = u.String()
-		}
-	}
-}
-
-type retryableHTTPLoggerAdapter struct {
-	logger zerolog.Logger
-}
-
-func (a retryableHTTPLoggerAdapter) Error(msg string, keysAndValues ...interface{}) {
-	prepareFields(keysAndValues)
-	a.logger.Error().Fields(keysAndValues).Msg(msg)
-}
-
-func (a retryableHTTPLoggerAdapter) Info(msg string, keysAndValues ...interface{}) {
-	prepareFields(keysAndValues)
-	a.logger.Info().Fields(keysAndValues).Msg(msg)
-}
-
-func (a retryableHTTPLoggerAdapter) Debug(msg string, keysAndValues ...interface{}) {
-	prepareFields(keysAndValues)
-	a.logger.Debug().Fields(keysAndValues).Msg(msg)
-}
-
-func (a retryableHTTPLoggerAdapter) Warn(msg string, keysAndValues ...interface{}) {
-	prepareFields(keysAndValues)
-	a.logger.Warn().Fields(keysAndValues).Msg(msg)
+// IndexConfigurationHash returns a hex encoded SHA-256 hash of the index configuration
+// used when creating new indices. It can be used to determine compatibility of indices.
+func IndexConfigurationHash() string {
+	h := sha256.Sum256(indexConfiguration)
+	return hex.EncodeToString(h[:])
 }
 
-var _ retryablehttp.LeveledLogger = (*retryableHTTPLoggerAdapter)(nil)
-
 type loggerAdapter struct {
 	log   zerolog.Logger
 	level zerolog.Level
@@ -117,26 +85,44 @@ func GetClient(httpClient *http.Client, logger zerolog.Logger, url string) (*ela
 	return esClient, errors.WithStack(err)
 }
 
+// getIndexConfiguration returns the configuration new indices are created with.
+func getIndexConfiguration(sizeField bool) (indexConfigurationStruct, errors.E) {
+	var config indexConfigurationStruct
+	errE := x.UnmarshalWithoutUnknownFields(indexConfiguration, &config)
+	if errE != nil {
+		return config, errE
+	}
+
+	if sizeField {
+		config.Mappings["_size"] = map[string]interface{}{"enabled": true}
+	}
+
+	return config, nil
+}
+
 // ensureIndex makes sure the index for PeerDB documents exists. If not, it creates it.
 // It does not update configuration of an existing index if it is different from
 // what current implementation of ensureIndex would otherwise create.
+// In read-only mode (see internal.WithReadOnly) it only checks that the index exists.
 func ensureIndex(ctx context.Context, esClient *elastic.Client, index string, sizeField bool) errors.E {
 	exists, err := esClient.IndexExists(index).Do(ctx)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	if !exists && internal.IsReadOnly(ctx) {
+		// In read-only mode, the index has to be created by another instance.
+		errE := errors.New("index does not exist")
+		errors.Details(errE)["index"] = index
+		return errE
+	}
+
 	if !exists {
-		var config indexConfigurationStruct
-		errE := x.UnmarshalWithoutUnknownFields(indexConfiguration, &config)
+		config, errE := getIndexConfiguration(sizeField)
 		if errE != nil {
 			return errE
 		}
 
-		if sizeField {
-			config.Mappings["_size"] = map[string]interface{}{"enabled": true}
-		}
-
 		createIndex, err := esClient.CreateIndex(index).BodyJson(config).Do(ctx)
 		if err != nil {
 			return errors.WithStack(err)
@@ -160,6 +146,11 @@ func initProcessor(ctx context.Context, logger zerolog.Logger, esClient *elastic
 				logger.Error().Err(err).Str("index", index).Msg("indexing error")
 			} else if failed := response.Failed(); len(failed) > 0 {
 				for _, f := range failed {
+					if f.Status == http.StatusConflict {
+						// An older revision of the document was indexed into the
+						// index generation being built after a newer one, which is expected.
+						continue
+					}
 					logger.Error().
 						Str("index", index).
 						Str("id", f.Id).Int("code", f.Status).
@@ -180,24 +171,22 @@ func initProcessor(ctx context.Context, logger zerolog.Logger, esClient *elastic
 	return processor, nil
 }
 
-func endDocumentSession(
+// DocumentSession returns begin metadata of the edit session, JSON of the document version
+// the session started from, and changes made in the session, in order.
+func DocumentSession(
 	ctx context.Context, s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	c *coordinator.Coordinator[json.RawMessage, *types.DocumentBeginMetadata, *types.DocumentEndMetadata, *types.DocumentChangeMetadata],
-	session identifier.Identifier, endMetadata *types.DocumentEndMetadata,
-) (*types.DocumentEndMetadata, errors.E) {
-	if endMetadata.Discarded {
-		return nil, nil //nolint:nilnil
-	}
-
+	session identifier.Identifier,
+) (*types.DocumentBeginMetadata, json.RawMessage, document.Changes, errors.E) {
 	beginMetadata, _, errE := c.Get(ctx, session)
 	if errE != nil {
-		return nil, errE
+		return nil, nil, nil, errE
 	}
 
 	// TODO: Support more than 5000 changes.
 	changesList, errE := c.List(ctx, session, nil)
 	if errE != nil {
-		return nil, errE
+		return nil, nil, nil, errE
 	}
 
 	// changesList is sorted from newest to oldest change, but we want the opposite as we have forward patches.
@@ -208,18 +197,35 @@ func endDocumentSession(
 		data, _, errE := c.GetData(ctx, session, ch) //nolint:govet
 		if errE != nil {
 			errors.Details(errE)["change"] = ch
-			return nil, errE
+			return nil, nil, nil, errE
 		}
 		change, errE := document.ChangeUnmarshalJSON(data)
 		if errE != nil {
 			errors.Details(errE)["change"] = ch
-			return nil, errE
+			return nil, nil, nil, errE
 		}
 		changes = append(changes, change)
 	}
 
 	// TODO: Get latest revision at the same changeset?
 	docJSON, _, errE := s.Get(ctx, beginMetadata.ID, beginMetadata.Version)
+	if errE != nil {
+		return nil, nil, nil, errE
+	}
+
+	return beginMetadata, docJSON, changes, nil
+}
+
+func endDocumentSession(
+	ctx context.Context, s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	c *coordinator.Coordinator[json.RawMessage, *types.DocumentBeginMetadata, *types.DocumentEndMetadata, *types.DocumentChangeMetadata],
+	session identifier.Identifier, endMetadata *types.DocumentEndMetadata,
+) (*types.DocumentEndMetadata, errors.E) {
+	if endMetadata.Discarded {
+		return nil, nil //nolint:nilnil
+	}
+
+	beginMetadata, docJSON, changes, errE := DocumentSession(ctx, s, c, session)
 	if errE != nil {
 		return nil, errE
 	}
@@ -235,6 +241,9 @@ func endDocumentSession(
 		return nil, errE
 	}
 
+	// Elements of lists are stored in order.
+	doc.SortLists()
+
 	docJSON, errE = x.MarshalWithoutEscapeHTML(doc)
 	if errE != nil {
 		return nil, errE
@@ -254,8 +263,16 @@ func endDocumentSession(
 	return endMetadata, nil
 }
 
-func Standalone(logger zerolog.Logger, database, elastic, schema, index string, sizeField bool) (
-	context.Context, context.CancelFunc, *retryablehttp.Client,
+// Standalone connects to PostgreSQL and ElasticSearch for use outside of the HTTP server
+// (e.g., by importers). Returned context is canceled on ctrl-c and TERM signal.
+// Claims of restricted properties are not indexed (see RemoveRestricted).
+//
+// If job is not empty, a lease on the job is held while running, so that only one instance
+// runs the job at a time. If the lease is held by another instance, coordination.ErrLeaseHeld
+// is returned. If the lease is lost (e.g., it was not renewed in time and another instance took
+// it over), returned context is canceled. Returned function releases the lease.
+func Standalone(logger zerolog.Logger, database, elastic, schema, index string, sizeField bool, restricted []identifier.Identifier, job string) (
+	context.Context, context.CancelFunc,
 	*store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	*elastic.Client, *elastic.BulkProcessor, errors.E,
 ) {
@@ -266,34 +283,63 @@ func Standalone(logger zerolog.Logger, database, elastic, schema, index string,
 		return schema, "standalone"
 	})
 	if errE != nil {
-		return nil, nil, nil, nil, nil, nil, errE
+		return nil, nil, nil, nil, nil, errE
 	}
 
-	simpleHTTPClient := cleanhttp.DefaultPooledClient()
+	esClient, errE := GetClient(cleanhttp.DefaultPooledClient(), logger, elastic)
+	if errE != nil {
+		return nil, nil, nil, nil, nil, errE
+	}
 
-	esClient, errE := GetClient(simpleHTTPClient, logger, elastic)
+	store, _, _, esProcessor, _, _, _, errE := InitForSite(ctx, logger, dbpool, esClient, schema, index, sizeField, restricted)
 	if errE != nil {
-		return nil, nil, nil, nil, nil, nil, errE
+		return nil, nil, nil, nil, nil, errE
 	}
 
-	store, _, _, esProcessor, errE := InitForSite(ctx, logger, dbpool, esClient, schema, index, sizeField)
+	jobCtx, jobStop, errE := holdLease(ctx, stop, logger, dbpool, job)
 	if errE != nil {
-		return nil, nil, nil, nil, nil, nil, errE
+		stop()
+		return nil, nil, nil, nil, nil, errE
+	}
+
+	return jobCtx, jobStop, store, esClient, esProcessor, nil
+}
+
+// holdLease holds a lease on the job, if job is not empty. Returned function
+// releases the lease and then calls stop.
+func holdLease(
+	ctx context.Context, stop context.CancelFunc, logger zerolog.Logger, dbpool *pgxpool.Pool, job string,
+) (context.Context, context.CancelFunc, errors.E) {
+	if job == "" {
+		return ctx, stop, nil
 	}
 
-	httpClient := retryablehttp.NewClient()
-	httpClient.HTTPClient = simpleHTTPClient
-	httpClient.RetryWaitMax = clientRetryWaitMax
-	httpClient.RetryMax = clientRetryMax
-	httpClient.Logger = retryableHTTPLoggerAdapter{logger}
+	leases := &coordination.Leases{
+		Prefix: "importer",
+		Owner:  "",
+		TTL:    0,
+	}
+	errE := leases.Init(ctx, dbpool)
+	if errE != nil {
+		return nil, nil, errE
+	}
 
-	// Set User-Agent header.
-	httpClient.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, _ int) {
-		// TODO: Make contact e-mail into a CLI argument.
-		req.Header.Set("User-Agent", fmt.Sprintf("PeerBot/%s (build on %s, git revision %s) (mailto:mitar.peerbot@tnode.com)", cli.Version, cli.BuildTimestamp, cli.Revision))
+	leaseCtx, release, errE := leases.Hold(ctx, job)
+	if errE != nil {
+		return nil, nil, errE
 	}
+	logger.Info().Str("job", job).Str("owner", leases.Owner).Msg("lease acquired")
+
+	context.AfterFunc(leaseCtx, func() {
+		if errors.Is(context.Cause(leaseCtx), coordination.ErrLeaseLost) {
+			logger.Error().Str("job", job).Msg("lease lost, stopping")
+		}
+	})
 
-	return ctx, stop, httpClient, store, esClient, esProcessor, nil
+	return leaseCtx, func() {
+		release()
+		stop()
+	}, nil
 }
 
 func Progress(logger zerolog.Logger, esProcessor *elastic.BulkProcessor, cache *Cache, skipped *int64, description string) func(ctx context.Context, p x.Progress) {
@@ -320,11 +366,15 @@ func Progress(logger zerolog.Logger, esProcessor *elastic.BulkProcessor, cache *
 
 func InitForSite(
 	ctx context.Context, logger zerolog.Logger, dbpool *pgxpool.Pool, esClient *elastic.Client, schema, index string, sizeField bool,
+	restricted []identifier.Identifier,
 ) (
 	*store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	*coordinator.Coordinator[json.RawMessage, *types.DocumentBeginMetadata, *types.DocumentEndMetadata, *types.DocumentChangeMetadata],
 	*storage.Storage,
 	*elastic.BulkProcessor,
+	*References,
+	*Generations,
+	*BridgeSync,
 	errors.E,
 ) {
 	// TODO: Add some monitoring of the channel contention.
@@ -336,19 +386,25 @@ func InitForSite(
 
 	errE := ensureIndex(ctx, esClient, index, sizeField)
 	if errE != nil {
-		return nil, nil, nil, nil, errE
+		return nil, nil, nil, nil, nil, nil, nil, errE
 	}
 
 	errE = internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
 		return internal.EnsureSchema(ctx, tx, schema)
 	}, nil)
 	if errE != nil {
-		return nil, nil, nil, nil, errE
+		return nil, nil, nil, nil, nil, nil, nil, errE
+	}
+
+	generations := &Generations{Index: index} //nolint:exhaustruct
+	errE = generations.Init(ctx, esClient)
+	if errE != nil {
+		return nil, nil, nil, nil, nil, nil, nil, errE
 	}
 
 	esProcessor, errE := initProcessor(ctx, logger, esClient, index)
 	if errE != nil {
-		return nil, nil, nil, nil, errE
+		return nil, nil, nil, nil, nil, nil, nil, errE
 	}
 
 	s := &store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes]{
@@ -360,7 +416,7 @@ func InitForSite(
 	}
 	errE = s.Init(ctx, dbpool)
 	if errE != nil {
-		return nil, nil, nil, nil, errE
+		return nil, nil, nil, nil, nil, nil, nil, errE
 	}
 
 	var c *coordinator.Coordinator[json.RawMessage, *types.DocumentBeginMetadata, *types.DocumentEndMetadata, *types.DocumentChangeMetadata]
@@ -376,7 +432,7 @@ func InitForSite(
 	}
 	errE = c.Init(ctx, dbpool)
 	if errE != nil {
-		return nil, nil, nil, nil, errE
+		return nil, nil, nil, nil, nil, nil, nil, errE
 	}
 
 	storage := &storage.Storage{
@@ -385,17 +441,31 @@ func InitForSite(
 	}
 	errE = storage.Init(ctx, dbpool)
 	if errE != nil {
-		return nil, nil, nil, nil, errE
+		return nil, nil, nil, nil, nil, nil, nil, errE
+	}
+
+	references := &References{
+		Prefix:     "docs",
+		Restricted: restricted,
+	}
+	errE = references.Init(ctx, dbpool)
+	if errE != nil {
+		return nil, nil, nil, nil, nil, nil, nil, errE
 	}
 
+	bridgeSync := NewBridgeSync()
+
 	go Bridge(
 		ctx,
 		logger.With().Str("schema", schema).Str("index", index).Logger(),
 		s,
 		esProcessor,
-		index,
+		generations,
+		references.PrepareDocument,
+		references.Delete,
 		channel,
+		bridgeSync,
 	)
 
-	return s, c, storage, esProcessor, nil
+	return s, c, storage, esProcessor, references, generations, bridgeSync, nil
 }
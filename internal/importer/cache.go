@@ -0,0 +1,121 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// ErrCacheMiss is returned by CacheBackend.Get when the file is not cached.
+var ErrCacheMiss = errors.Base("cache miss")
+
+// CacheBackend stores cached files remotely, so that they persist across machines and runs.
+type CacheBackend interface {
+	// Get returns a reader of the cached file with the name and its size. It returns
+	// ErrCacheMiss if the file is not cached. The returned reader has to be closed.
+	Get(ctx context.Context, name string) (io.ReadCloser, int64, errors.E)
+	// Put stores the local file at path as the cached file with the name.
+	Put(ctx context.Context, name, path string) errors.E
+}
+
+// NewCacheBackend returns a backend for the remote cache at remoteURL.
+//
+// For S3-compatible storage the URL is "s3://bucket/prefix" (see newS3Cache for its configuration).
+// For HTTP storage (e.g., WebDAV) the URL is the base URL under which files are fetched with GET
+// and stored with PUT. Credentials in the URL are used for basic authentication.
+func NewCacheBackend(remoteURL string, client *http.Client) (CacheBackend, errors.E) { //nolint:ireturn
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = remoteURL
+		return nil, errE
+	}
+	switch u.Scheme {
+	case "s3":
+		return newS3Cache(u, client)
+	case "http", "https":
+		return &httpCache{base: u, client: client}, nil
+	default:
+		errE := errors.New("unsupported remote cache")
+		errors.Details(errE)["url"] = remoteURL
+		return nil, errE
+	}
+}
+
+// responseError returns an error for an unexpected response, including the start of its body.
+func responseError(resp *http.Response) errors.E {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024)) //nolint:mnd
+	errE := errors.New("unexpected response status")
+	errors.Details(errE)["url"] = resp.Request.URL.Redacted()
+	errors.Details(errE)["code"] = resp.StatusCode
+	if len(body) > 0 {
+		errors.Details(errE)["body"] = string(body)
+	}
+	return errE
+}
+
+// httpCache is a CacheBackend which stores files on an HTTP server.
+type httpCache struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func (c *httpCache) Get(ctx context.Context, name string) (io.ReadCloser, int64, errors.E) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base.JoinPath(name).String(), nil)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, resp.ContentLength, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		errE := errors.WithStack(ErrCacheMiss)
+		errors.Details(errE)["name"] = name
+		return nil, 0, errE
+	default:
+		defer resp.Body.Close()
+		return nil, 0, responseError(resp)
+	}
+}
+
+func (c *httpCache) Put(ctx context.Context, name, path string) errors.E {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.base.JoinPath(name).String(), file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.ContentLength = info.Size()
+	if req.ContentLength == 0 {
+		// Otherwise the request would be sent with chunked encoding.
+		req.Body = http.NoBody
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return responseError(resp)
+	}
+	return nil
+}
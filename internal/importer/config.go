@@ -0,0 +1,91 @@
+// Package importer provides functionality shared by commands which import data into PeerDB.
+//
+// A command embeds Config into its configuration, uses Download to obtain (and cache)
+// source files, and creates an Importer to save documents and wait for them to be indexed.
+package importer
+
+import (
+	"strconv"
+
+	"github.com/alecthomas/kong"
+	"gitlab.com/tozd/go/zerolog"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	DefaultCacheDir = ".cache"
+)
+
+// Modes of checking that properties declared to have a single value have at most one claim.
+const (
+	CardinalityOff    = "off"
+	CardinalityWarn   = "warn"
+	CardinalityReject = "reject"
+)
+
+//nolint:lll
+type PostgresConfig struct {
+	URL    kong.FileContentFlag `                           env:"URL_PATH" help:"File with PostgreSQL database URL. Environment variable: ${env}." placeholder:"PATH" required:"" short:"d"`
+	Schema string               `default:"${defaultSchema}"                help:"Name of PostgreSQL schema to use. Default: ${defaultSchema}."     placeholder:"NAME"             short:"s"`
+}
+
+//nolint:lll
+type ElasticConfig struct {
+	URL          string `default:"${defaultElastic}" help:"URL of the ElasticSearch instance. Default: ${defaultElastic}."                                       placeholder:"URL"   short:"e"`
+	Index        string `default:"${defaultIndex}"   help:"Name of ElasticSearch index to use. Default: ${defaultIndex}."                                        placeholder:"NAME"  short:"i"`
+	SizeField    bool   `                            help:"Enable size field on documents. Requires mapper-size ElasticSearch plugin installed."`
+	MaxDocuments int64  `                            help:"Maximum number of documents in the index. Saving documents fails once it is exceeded."                placeholder:"INT"`
+	MaxBytes     int64  `                            help:"Maximum size of the index in bytes. Saving documents fails once it is exceeded."                      placeholder:"BYTES"`
+	QuotaWebhook string `                            help:"URL to which index usage is POSTed as JSON when the maximum number of documents or size is exceeded." placeholder:"URL"`
+
+	RestrictedProperties []identifier.Identifier `help:"Property whose claims are not indexed, so that they cannot be searched for. It should match site's restricted properties. Can be provided multiple times." name:"restricted-property" placeholder:"ID"`
+}
+
+//nolint:lll
+type LimitsConfig struct {
+	MaxClaimsPerProperty int    `                                                     help:"Maximum number of claims with the same property per document. Default: no limit."                                                               placeholder:"INT"`
+	MaxClaims            int    `                                                     help:"Maximum number of claims per document. Default: no limit."                                                                                      placeholder:"INT"`
+	MaxTextBytes         int    `                                                     help:"Maximum total size of text and string claims per document. Default: no limit."                                                                  placeholder:"BYTES"`
+	Truncate             string `default:"${defaultTruncate}" enum:"confidence,order" help:"Which claims to keep when a document exceeds limits: confidence (the highest confidence) or order (stored first). Default: ${defaultTruncate}." placeholder:"STRATEGY"`
+	Report               string `                                                     help:"Write a JSON report of truncated documents to the file."                                                                                        placeholder:"PATH"     type:"path"`
+}
+
+// Config provides configuration common to all importers.
+// It should be embedded into command's configuration.
+//
+//nolint:lll
+type Config struct {
+	zerolog.LoggingConfig
+
+	Version      kong.VersionFlag `                                                                                      help:"Show program's version and exit."                                                                                                                                                                        short:"V"`
+	CacheDir     string           `default:"${defaultCacheDir}"                                                          help:"Where to cache files to. Default: ${defaultCacheDir}."                                                                                                name:"cache" placeholder:"DIR"                     short:"C" type:"path"`
+	Revalidate   bool             `                                                                                      help:"Revalidate cached files using their ETags and download them again if they changed."`
+	RemoteCache  string           `                                                                                      help:"URL of a remote cache to share cached files across machines and runs: s3://bucket/prefix or a HTTP(S) URL."                                                        placeholder:"URL"`
+	Units        string           `                                                                                      help:"YAML or JSON file with additional units to register."                                                                                                              placeholder:"PATH"                              type:"path"`
+	Describe     string           `                                                                                      help:"Write a JSON schema of saved documents (properties, claim types, cardinalities, units) to the file."                                                               placeholder:"PATH"                              type:"path"`
+	Cardinality  string           `default:"${defaultCardinality}"          enum:"off,warn,reject"                       help:"What to do when a document has multiple claims for a property declared to have a single value: off, warn, or reject. Default: ${defaultCardinality}."              placeholder:"MODE"`
+	Completeness bool             `                                                                                      help:"Compute completeness scores of documents and store them as completeness amount claims."`
+	Postgres     PostgresConfig   `                                embed:""                        envprefix:"POSTGRES_"                                                                                                                                                                                             prefix:"postgres."`
+	Elastic      ElasticConfig    `                                embed:""                        envprefix:"ELASTIC_"                                                                                                                                                                                              prefix:"elastic."`
+	Limits       LimitsConfig     `                                embed:""                                                                                                                                                                                                                                          prefix:"limits."`
+	HTTP         HTTPConfig       `                                embed:""                                                                                                                                                                                                                                          prefix:"http."`
+}
+
+// Vars returns Kong variables with defaults used by Config, extended with vars.
+func Vars(vars kong.Vars) kong.Vars {
+	return kong.Vars{
+		"defaultCacheDir":      DefaultCacheDir,
+		"defaultCardinality":   CardinalityOff,
+		"defaultElastic":       peerdb.DefaultElastic,
+		"defaultHTTPContact":   DefaultHTTPContact,
+		"defaultHTTPETagCache": strconv.Itoa(DefaultHTTPETagCache),
+		"defaultHTTPRateLimit": "0",
+		"defaultHTTPRetryMax":  strconv.Itoa(DefaultHTTPRetryMax),
+		"defaultIndex":         peerdb.DefaultIndex,
+		"defaultSchema":        peerdb.DefaultSchema,
+		"defaultTruncate":      document.TruncateByConfidence,
+	}.CloneWith(vars)
+}
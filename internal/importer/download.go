@@ -0,0 +1,330 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb/internal/es"
+)
+
+// etagSuffix is the suffix of the file next to the cached file which stores its ETag.
+const etagSuffix = ".etag"
+
+// GetPathAndURL returns the path where the file at URL is (or should be) cached and
+// the URL from which to download it. If url is in fact a path to a local file, it
+// returns that path and an empty URL.
+func GetPathAndURL(cacheDir, url string) (string, string) {
+	_ = os.MkdirAll(cacheDir, 0o755) //nolint:mnd
+	_, err := os.Stat(url)
+	if os.IsNotExist(err) {
+		return filepath.Join(cacheDir, path.Base(url)), url
+	}
+	return url, ""
+}
+
+// file is a reader of a cached or downloaded file which runs cleanup functions on close.
+type file struct {
+	io.Reader
+
+	cleanup []func()
+}
+
+func (f *file) Close() error {
+	// We run cleanup functions in reverse order, like defer does.
+	for i := len(f.cleanup) - 1; i >= 0; i-- {
+		f.cleanup[i]()
+	}
+	f.cleanup = nil
+	return nil
+}
+
+// checkETag returns true if the file at URL has not changed since it had the ETag.
+func checkETag(ctx context.Context, httpClient *retryablehttp.Client, etag, url string) (bool, errors.E) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, io.LimitReader(resp.Body, 1)) //nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return true, nil
+	case http.StatusOK:
+		return false, nil
+	default:
+		errE := errors.New("unexpected response status")
+		errors.Details(errE)["url"] = url
+		errors.Details(errE)["code"] = resp.StatusCode
+		return false, errE
+	}
+}
+
+// revalidate returns true if the cached file at cachedPath is still current.
+// If it is not, it removes the cached file.
+func revalidate(ctx context.Context, httpClient *retryablehttp.Client, cachedPath, url string) (bool, errors.E) {
+	etag, err := os.ReadFile(cachedPath + etagSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		// We cannot revalidate without ETag, so we assume the cached file is current.
+		return true, nil
+	} else if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	current, errE := checkETag(ctx, httpClient, strings.TrimSpace(string(etag)), url)
+	if errE != nil {
+		return false, errE
+	}
+	if !current {
+		_ = os.Remove(cachedPath)
+		_ = os.Remove(cachedPath + etagSuffix)
+	}
+	return current, nil
+}
+
+// getRemote returns a reader for the file cached in the remote cache, its size, and its ETag.
+// If revalidateCache is true, the file is revalidated using its ETag and ErrCacheMiss is
+// returned if it changed.
+func getRemote(
+	ctx context.Context, httpClient *retryablehttp.Client, remote CacheBackend,
+	revalidateCache bool, name, url string,
+) (io.ReadCloser, int64, string, errors.E) {
+	etag := ""
+	etagReader, _, errE := remote.Get(ctx, name+etagSuffix)
+	if errE == nil {
+		data, err := io.ReadAll(io.LimitReader(etagReader, 1024)) //nolint:mnd
+		etagReader.Close()
+		if err != nil {
+			return nil, 0, "", errors.WithStack(err)
+		}
+		etag = strings.TrimSpace(string(data))
+	} else if !errors.Is(errE, ErrCacheMiss) {
+		return nil, 0, "", errE
+	}
+
+	if revalidateCache && etag != "" {
+		current, errE := checkETag(ctx, httpClient, etag, url)
+		if errE != nil {
+			return nil, 0, "", errE
+		}
+		if !current {
+			errE := errors.WithStack(ErrCacheMiss)
+			errors.Details(errE)["name"] = name
+			return nil, 0, "", errE
+		}
+	}
+
+	reader, size, errE := remote.Get(ctx, name)
+	if errE != nil {
+		return nil, 0, "", errE
+	}
+	return reader, size, etag, nil
+}
+
+// putRemote stores the cached file at cachedPath (and its ETag, if any) into the remote cache.
+func putRemote(ctx context.Context, remote CacheBackend, cachedPath string) errors.E {
+	name := filepath.Base(cachedPath)
+	errE := remote.Put(ctx, name, cachedPath)
+	if errE != nil {
+		return errE
+	}
+	if _, err := os.Stat(cachedPath + etagSuffix); err == nil {
+		return remote.Put(ctx, name+etagSuffix, cachedPath+etagSuffix)
+	}
+	return nil
+}
+
+// cacheReader makes f read from reader while saving its content to cachedPath. Once f is closed,
+// the cached file is deleted if incomplete, otherwise etag (if any) is saved next to it and
+// onComplete (if set) is called.
+func cacheReader(f *file, reader io.ReadCloser, size int64, cachedPath, etag string, onComplete func()) errors.E {
+	f.cleanup = append(f.cleanup, func() { reader.Close() })
+	cachedFile, err := os.Create(cachedPath)
+	if err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	f.cleanup = append(f.cleanup, func() {
+		info, err := os.Stat(cachedPath)
+		if err != nil || size != info.Size() {
+			// Incomplete file. Delete.
+			_ = os.Remove(cachedPath)
+			_ = os.Remove(cachedPath + etagSuffix)
+			return
+		}
+		if etag != "" {
+			_ = os.WriteFile(cachedPath+etagSuffix, []byte(etag), 0o644) //nolint:mnd,gosec
+		}
+		if onComplete != nil {
+			onComplete()
+		}
+	})
+	f.cleanup = append(f.cleanup, func() { cachedFile.Close() })
+	f.Reader = io.TeeReader(reader, cachedFile)
+	return nil
+}
+
+// Download returns a reader for the file at URL, which can also be a path to a local file.
+//
+// Downloaded files are cached in the cache directory and the cached file is used if it exists.
+// If a remote cache is configured, a file not in the cache directory is fetched from the remote
+// cache if it is there, otherwise it is downloaded and then also stored into the remote cache.
+// If revalidation is enabled, cached files are revalidated using their ETags and downloaded again
+// if they changed. Progress of reading the file is logged using description. The returned reader
+// has to be closed.
+func Download(
+	ctx context.Context, httpClient *retryablehttp.Client, config *Config, url, description string,
+) (io.ReadCloser, errors.E) {
+	logger := config.Logger
+	cachedPath, url := GetPathAndURL(config.CacheDir, url)
+
+	var remote CacheBackend
+	if url != "" && config.RemoteCache != "" {
+		var errE errors.E
+		remote, errE = NewCacheBackend(config.RemoteCache, httpClient.HTTPClient)
+		if errE != nil {
+			return nil, errE
+		}
+	}
+
+	f := &file{Reader: nil, cleanup: nil}
+
+	var cachedSize int64
+
+	if url != "" && config.Revalidate {
+		if _, err := os.Stat(cachedPath); err == nil {
+			_, errE := revalidate(ctx, httpClient, cachedPath, url)
+			if errE != nil {
+				return nil, errE
+			}
+		}
+	}
+
+	cachedFile, err := os.Open(cachedPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, errors.WithStack(err)
+		}
+		// File does not exists. Continue.
+	} else {
+		f.cleanup = append(f.cleanup, func() { cachedFile.Close() })
+		f.Reader = cachedFile
+		cachedSize, err = cachedFile.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, errors.WithStack(err)
+		}
+		_, err = cachedFile.Seek(0, io.SeekStart)
+		if err != nil {
+			f.Close()
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if f.Reader == nil && remote != nil {
+		reader, size, etag, errE := getRemote(ctx, httpClient, remote, config.Revalidate, filepath.Base(cachedPath), url)
+		if errE == nil && size < 0 {
+			// We cannot know if the file has been fetched completely, so we download it instead.
+			reader.Close()
+			errE = errors.New("unknown size of the file in remote cache")
+		}
+		if errE == nil {
+			errE = cacheReader(f, reader, size, cachedPath, etag, nil)
+			if errE != nil {
+				return nil, errE
+			}
+			cachedSize = size
+		} else if !errors.Is(errE, ErrCacheMiss) {
+			// Remote cache is only an optimization, so we download the file instead.
+			logger.Warn().Err(errE).Str("url", url).Msg("remote cache failed")
+		}
+	}
+
+	if f.Reader == nil {
+		// File does not already exist. We download the file and save it.
+		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		downloadReader, errE := x.NewRetryableResponse(httpClient, req)
+		if errE != nil {
+			return nil, errE
+		}
+		var onComplete func()
+		if remote != nil {
+			onComplete = func() {
+				errE := putRemote(ctx, remote, cachedPath)
+				if errE != nil {
+					logger.Warn().Err(errE).Str("url", url).Msg("storing into remote cache failed")
+				}
+			}
+		}
+		cachedSize = downloadReader.Size()
+		errE = cacheReader(f, downloadReader, cachedSize, cachedPath, downloadReader.Header.Get("ETag"), onComplete)
+		if errE != nil {
+			return nil, errE
+		}
+	}
+
+	progress := es.Progress(logger, nil, nil, nil, description)
+	countingReader := &x.CountingReader{Reader: f.Reader}
+	ticker := x.NewTicker(ctx, countingReader, cachedSize, ProgressPrintRate)
+	f.cleanup = append(f.cleanup, ticker.Stop)
+	go func() {
+		for p := range ticker.C {
+			progress(ctx, p)
+		}
+	}()
+	f.Reader = countingReader
+
+	return f, nil
+}
+
+// Fetch makes sure the file at URL is in the cache directory (fetching it from the remote cache
+// or downloading it, see Download) and returns its path. If url is in fact a path to a local
+// file, it returns that path.
+//
+// It is useful when the file is read by code which does not support the remote cache.
+func Fetch(ctx context.Context, httpClient *retryablehttp.Client, config *Config, url, description string) (string, errors.E) {
+	cachedPath, u := GetPathAndURL(config.CacheDir, url)
+	if u == "" {
+		return cachedPath, nil
+	}
+	if _, err := os.Stat(cachedPath); err == nil {
+		current := true
+		if config.Revalidate {
+			var errE errors.E
+			current, errE = revalidate(ctx, httpClient, cachedPath, u)
+			if errE != nil {
+				return "", errE
+			}
+		}
+		if current {
+			return cachedPath, nil
+		}
+	}
+	reader, errE := Download(ctx, httpClient, config, url, description)
+	if errE != nil {
+		return "", errE
+	}
+	_, err := io.Copy(io.Discard, reader)
+	reader.Close()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return cachedPath, nil
+}
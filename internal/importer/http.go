@@ -0,0 +1,231 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-retryablehttp"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/cli"
+	"gitlab.com/tozd/go/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultHTTPContact   = "mitar.peerbot@tnode.com"
+	DefaultHTTPRetryMax  = 9
+	DefaultHTTPETagCache = 1000
+
+	httpRetryWaitMax = 10 * 60 * time.Second
+	// Only responses up to this size are cached.
+	maxETagCacheBodySize = 1 << 20 // 1 MB
+)
+
+// HTTPConfig configures the outbound HTTP client used by importers.
+//
+//nolint:lll
+type HTTPConfig struct {
+	UserAgent      string             `                                  help:"User-Agent header of outbound HTTP requests. Default: PeerBot with version and contact."                                                                               placeholder:"STRING"`
+	Contact        string             `default:"${defaultHTTPContact}"   help:"Contact e-mail address included in the default User-Agent header. Default: ${defaultHTTPContact}."                                                                     placeholder:"EMAIL"`
+	RateLimit      float64            `default:"${defaultHTTPRateLimit}" help:"Maximum number of outbound HTTP requests per second to each host. Zero disables the limit. Default: ${defaultHTTPRateLimit}."                                          placeholder:"RATE"`
+	HostRateLimits map[string]float64 `                                  help:"Maximum number of outbound HTTP requests per second to the host, overriding the default limit."                                                 name:"host-rate-limit" placeholder:"HOST=RATE"`
+	RetryMax       int                `default:"${defaultHTTPRetryMax}"  help:"Maximum number of retries of failed outbound HTTP requests. Default: ${defaultHTTPRetryMax}."                                                                          placeholder:"INT"`
+	ETagCache      int                `default:"${defaultHTTPETagCache}" help:"Number of responses with ETags cached in memory and revalidated with conditional requests. Zero disables it. Default: ${defaultHTTPETagCache}." name:"etag-cache"      placeholder:"INT"`
+}
+
+func prepareFields(keysAndValues []interface{}) {
+	for i, keyOrValue := range keysAndValues {
+		// We want URLs logged as strings.
+		u, ok := keyOrValue.(*url.URL)
+		if ok {
+			keysAndValues[i] = u.String()
+		}
+	}
+}
+
+type retryableHTTPLoggerAdapter struct {
+	logger zerolog.Logger
+}
+
+func (a retryableHTTPLoggerAdapter) Error(msg string, keysAndValues ...interface{}) {
+	prepareFields(keysAndValues)
+	a.logger.Error().Fields(keysAndValues).Msg(msg)
+}
+
+func (a retryableHTTPLoggerAdapter) Info(msg string, keysAndValues ...interface{}) {
+	prepareFields(keysAndValues)
+	a.logger.Info().Fields(keysAndValues).Msg(msg)
+}
+
+func (a retryableHTTPLoggerAdapter) Debug(msg string, keysAndValues ...interface{}) {
+	prepareFields(keysAndValues)
+	a.logger.Debug().Fields(keysAndValues).Msg(msg)
+}
+
+func (a retryableHTTPLoggerAdapter) Warn(msg string, keysAndValues ...interface{}) {
+	prepareFields(keysAndValues)
+	a.logger.Warn().Fields(keysAndValues).Msg(msg)
+}
+
+var _ retryablehttp.LeveledLogger = (*retryableHTTPLoggerAdapter)(nil)
+
+type cachedResponse struct {
+	ETag   string
+	Header http.Header
+	Body   []byte
+}
+
+// httpTransport sets User-Agent header, limits the rate of requests per host,
+// and caches responses with ETags, revalidating them with conditional requests.
+type httpTransport struct {
+	base           http.RoundTripper
+	userAgent      string
+	rateLimit      float64
+	hostRateLimits map[string]float64
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+
+	// cache is nil when caching is disabled.
+	cache *lru.Cache[string, cachedResponse]
+}
+
+// limiter returns the rate limiter for the host, or nil if requests to the host are not limited.
+func (t *httpTransport) limiter(host string) *rate.Limiter {
+	limit, ok := t.hostRateLimits[host]
+	if !ok {
+		limit = t.rateLimit
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limiter, ok := t.limiters[host]
+	if !ok {
+		// We allow a burst of one second worth of requests.
+		limiter = rate.NewLimiter(rate.Limit(limit), max(1, int(limit)))
+		t.limiters[host] = limiter
+	}
+	return limiter
+}
+
+func (t *httpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip should not modify the request, so we clone it.
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	if limiter := t.limiter(req.URL.Hostname()); limiter != nil {
+		err := limiter.Wait(req.Context())
+		if err != nil {
+			// Context has been canceled.
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	// We do not cache requests which are already conditional or partial.
+	cacheable := t.cache != nil && req.Method == http.MethodGet && req.Header.Get("If-None-Match") == "" && req.Header.Get("Range") == ""
+	key := req.URL.String()
+	var cached cachedResponse
+	var ok bool
+	if cacheable {
+		cached, ok = t.cache.Get(key)
+		if ok {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return &http.Response{ //nolint:exhaustruct
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        cached.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(cached.Body)),
+			ContentLength: int64(len(cached.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	etag := resp.Header.Get("ETag")
+	if cacheable && resp.StatusCode == http.StatusOK && etag != "" && resp.ContentLength >= 0 && resp.ContentLength <= maxETagCacheBodySize {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		t.cache.Add(key, cachedResponse{ETag: etag, Header: resp.Header.Clone(), Body: body})
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return resp, nil
+}
+
+// jitterBackoff is exponential backoff with added jitter, so that concurrent requests
+// do not all retry at the same time. Retry-After header of 429 and 503 responses is respected.
+func jitterBackoff(minWait, maxWait time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) && resp.Header.Get("Retry-After") != "" {
+		return retryablehttp.DefaultBackoff(minWait, maxWait, attemptNum, resp)
+	}
+	wait := retryablehttp.DefaultBackoff(minWait, maxWait, attemptNum, nil)
+	// We wait at least half of the exponential backoff.
+	return wait/2 + rand.N(wait/2+1) //nolint:gosec,mnd
+}
+
+// NewHTTPClient returns the outbound HTTP client to be shared by all requests of an importer.
+//
+// The client retries failed requests with exponential backoff with jitter, limits the rate
+// of requests per host, caches responses with ETags, and sets User-Agent header.
+func NewHTTPClient(logger zerolog.Logger, config *HTTPConfig) (*retryablehttp.Client, errors.E) {
+	userAgent := config.UserAgent
+	if userAgent == "" {
+		userAgent = fmt.Sprintf("PeerBot/%s (build on %s, git revision %s) (mailto:%s)", cli.Version, cli.BuildTimestamp, cli.Revision, config.Contact)
+	}
+
+	transport := &httpTransport{
+		base:           cleanhttp.DefaultPooledTransport(),
+		userAgent:      userAgent,
+		rateLimit:      config.RateLimit,
+		hostRateLimits: config.HostRateLimits,
+		mu:             sync.Mutex{},
+		limiters:       map[string]*rate.Limiter{},
+		cache:          nil,
+	}
+	if config.ETagCache > 0 {
+		cache, err := lru.New[string, cachedResponse](config.ETagCache)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		transport.cache = cache
+	}
+
+	httpClient := retryablehttp.NewClient()
+	httpClient.HTTPClient = &http.Client{Transport: transport} //nolint:exhaustruct
+	httpClient.RetryWaitMax = httpRetryWaitMax
+	httpClient.RetryMax = config.RetryMax
+	httpClient.Backoff = jitterBackoff
+	httpClient.Logger = retryableHTTPLoggerAdapter{logger}
+
+	return httpClient, nil
+}
@@ -0,0 +1,107 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	var requests, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		assert.Equal(t, "TestBot/1.0", req.Header.Get("User-Agent"))
+		if req.URL.Path == "/retry" && requests.Load() == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = io.WriteString(w, "content")
+	}))
+	t.Cleanup(server.Close)
+
+	httpClient, errE := NewHTTPClient(zerolog.Nop(), &HTTPConfig{ //nolint:exhaustruct
+		UserAgent: "TestBot/1.0",
+		RetryMax:  1,
+		ETagCache: 10,
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	httpClient.RetryWaitMin = time.Millisecond
+	httpClient.RetryWaitMax = time.Millisecond
+
+	get := func(t *testing.T, path string) string {
+		t.Helper()
+
+		req, err := retryablehttp.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		resp, err := httpClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// Failed request is retried.
+	assert.Equal(t, "content", get(t, "/retry"))
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Cached response is revalidated and returned.
+	assert.Equal(t, "content", get(t, "/retry"))
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, int32(1), notModified.Load())
+}
+
+func TestHTTPTransportLimiter(t *testing.T) {
+	t.Parallel()
+
+	transport := &httpTransport{ //nolint:exhaustruct
+		rateLimit:      10,
+		hostRateLimits: map[string]float64{"example.com": 2, "unlimited.example.com": 0},
+		limiters:       map[string]*rate.Limiter{},
+	}
+
+	limiter := transport.limiter("example.com")
+	require.NotNil(t, limiter)
+	assert.InDelta(t, 2.0, float64(limiter.Limit()), 0)
+	assert.Same(t, limiter, transport.limiter("example.com"))
+
+	limiter = transport.limiter("other.example.com")
+	require.NotNil(t, limiter)
+	assert.InDelta(t, 10.0, float64(limiter.Limit()), 0)
+	assert.Equal(t, 10, limiter.Burst())
+
+	assert.Nil(t, transport.limiter("unlimited.example.com"))
+}
+
+func TestJitterBackoff(t *testing.T) {
+	t.Parallel()
+
+	for attempt := range 5 {
+		wait := retryablehttp.DefaultBackoff(time.Second, time.Minute, attempt, nil)
+		backoff := jitterBackoff(time.Second, time.Minute, attempt, nil)
+		assert.GreaterOrEqual(t, backoff, wait/2)
+		assert.LessOrEqual(t, backoff, wait)
+	}
+
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"30"}}} //nolint:exhaustruct
+	assert.Equal(t, 30*time.Second, jitterBackoff(time.Second, time.Minute, 0, resp))
+}
@@ -0,0 +1,300 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/olivere/elastic/v7"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// ProgressPrintRate is how often progress is logged.
+const ProgressPrintRate = 30 * time.Second
+
+// Importer saves documents into the store and tracks their indexing.
+type Importer struct {
+	Logger      zerolog.Logger
+	HTTPClient  *retryablehttp.Client
+	Store       *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes]
+	ESClient    *elastic.Client
+	ESProcessor *elastic.BulkProcessor
+	Index       string
+	// Quota limits the size of the index. Documents are not saved once it is exceeded.
+	Quota *es.Quota
+	// Units are core units and units registered by the units file.
+	Units document.UnitRegistry
+
+	registry document.PropertyRegistry
+	// singleValue is nil when cardinality is not checked.
+	singleValue document.SingleValueProperties
+	// rejectCardinality is true when documents violating cardinality are not saved.
+	rejectCardinality bool
+	// completeness is nil when completeness scores are not computed.
+	completeness *document.Completeness
+	// truncator is nil when documents are not limited in size.
+	truncator *Truncator
+	// describer is nil when the schema of saved documents is not written.
+	describer    *document.Describer
+	describeMu   sync.Mutex
+	describePath string
+	// Count of processed (saved or skipped) documents, used for progress.
+	count x.Counter
+	// Count of saved documents.
+	saved x.Counter
+}
+
+// New connects to PostgreSQL and ElasticSearch based on config and returns a new Importer.
+//
+// If validate is true, claims of documents are validated against data types of core properties
+// before they are saved. Returned context is canceled on ctrl-c and TERM signal.
+// While running, a lease on job is held so that only one instance runs the import
+// at a time (see es.Standalone). Returned function has to be called to release resources.
+func New(config *Config, validate bool, job string) (context.Context, context.CancelFunc, *Importer, errors.E) {
+	units := document.NewUnitRegistry()
+	if config.Units != "" {
+		data, err := os.ReadFile(config.Units)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["path"] = config.Units
+			return nil, nil, nil, errE
+		}
+		errE := units.Load(data)
+		if errE != nil {
+			errors.Details(errE)["path"] = config.Units
+			return nil, nil, nil, errE
+		}
+	}
+
+	httpClient, errE := NewHTTPClient(config.Logger, &config.HTTP)
+	if errE != nil {
+		return nil, nil, nil, errE
+	}
+
+	ctx, stop, store, esClient, esProcessor, errE := es.Standalone(
+		config.Logger, string(config.Postgres.URL), config.Elastic.URL, config.Postgres.Schema, config.Elastic.Index, config.Elastic.SizeField, config.Elastic.RestrictedProperties, job,
+	)
+	if errE != nil {
+		return nil, nil, nil, errE
+	}
+
+	quota := &es.Quota{ //nolint:exhaustruct
+		MaxDocuments: config.Elastic.MaxDocuments,
+		MaxBytes:     config.Elastic.MaxBytes,
+		Webhook:      config.Elastic.QuotaWebhook,
+	}
+	errE = quota.Init(config.Logger, httpClient.StandardClient(), esClient, config.Elastic.Index)
+	if errE != nil {
+		stop()
+		return nil, nil, nil, errE
+	}
+
+	var registry document.PropertyRegistry
+	if validate {
+		registry = document.NewPropertyRegistry(document.CoreProperties)
+	}
+
+	var singleValue document.SingleValueProperties
+	if config.Cardinality != CardinalityOff {
+		singleValue = document.NewSingleValueProperties(document.CoreProperties)
+	}
+
+	var completeness *document.Completeness
+	if config.Completeness {
+		completeness = document.NewCompleteness(document.CoreProperties)
+	}
+
+	var describer *document.Describer
+	if config.Describe != "" {
+		describer = document.NewDescriber()
+	}
+
+	return ctx, stop, &Importer{
+		Logger:            config.Logger,
+		HTTPClient:        httpClient,
+		Store:             store,
+		ESClient:          esClient,
+		ESProcessor:       esProcessor,
+		Index:             config.Elastic.Index,
+		Quota:             quota,
+		Units:             units,
+		registry:          registry,
+		singleValue:       singleValue,
+		rejectCardinality: config.Cardinality == CardinalityReject,
+		completeness:      completeness,
+		truncator:         NewTruncator(config),
+		describer:         describer,
+		describeMu:        sync.Mutex{},
+		describePath:      config.Describe,
+		count:             0,
+		saved:             0,
+	}, nil
+}
+
+// SaveCoreProperties saves all core properties and waits for them to be available for search.
+func (i *Importer) SaveCoreProperties(ctx context.Context) errors.E {
+	return peerdb.SaveCoreProperties(ctx, i.Logger, i.Store, i.ESClient, i.ESProcessor, i.Index)
+}
+
+// Progress starts logging progress of saving documents with the expected total number of
+// documents. Returned function stops logging.
+func (i *Importer) Progress(ctx context.Context, total int64) func() {
+	progress := es.Progress(i.Logger, i.ESProcessor, nil, nil, "indexing")
+	ticker := x.NewTicker(ctx, &i.count, total, ProgressPrintRate)
+	go func() {
+		for p := range ticker.C {
+			progress(ctx, p)
+		}
+	}()
+	return ticker.Stop
+}
+
+// Save validates (if enabled, after coercing claims to claim types of their properties) and saves
+// the document, replacing any existing document with the same ID. If the index is over its quota, es.ErrQuotaExceeded is returned. Depending on configuration,
+// document.ErrTooManyClaims is returned (or only logged) when a property declared to have
+// a single value has multiple claims. Documents exceeding configured limits are truncated first.
+// If enabled, the completeness score of the document is set before it is saved.
+func (i *Importer) Save(ctx context.Context, doc *document.D) errors.E {
+	errE := i.Quota.Check(ctx)
+	if errE != nil {
+		errors.Details(errE)["doc"] = doc.ID.String()
+		return errE
+	}
+
+	errE = i.truncator.Truncate(doc)
+	if errE != nil {
+		return errE
+	}
+
+	if i.registry != nil {
+		coerced, errE := i.registry.Coerce(doc)
+		if errE != nil {
+			errors.Details(errE)["doc"] = doc.ID.String()
+			return errE
+		}
+		if coerced > 0 {
+			i.Logger.Debug().Str("doc", doc.ID.String()).Int("claims", coerced).Msg("claims coerced to claim types of their properties")
+		}
+		errE = i.registry.Validate(doc)
+		if errE != nil {
+			errors.Details(errE)["doc"] = doc.ID.String()
+			return errE
+		}
+	}
+
+	if i.singleValue != nil {
+		errE = i.singleValue.Check(doc)
+		if errE != nil {
+			errors.Details(errE)["doc"] = doc.ID.String()
+			if i.rejectCardinality {
+				return errE
+			}
+			i.Logger.Warn().Err(errE).Msg("property declared to have a single value has multiple claims")
+		}
+	}
+
+	if i.completeness != nil {
+		errE = i.completeness.Set(doc)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	if i.describer != nil {
+		i.describeMu.Lock()
+		errE = i.describer.Add(doc)
+		i.describeMu.Unlock()
+		if errE != nil {
+			errors.Details(errE)["doc"] = doc.ID.String()
+			return errE
+		}
+	}
+
+	i.count.Increment()
+	i.saved.Increment()
+
+	i.Logger.Debug().Str("doc", doc.ID.String()).Msg("saving document")
+	return peerdb.InsertOrReplaceDocument(ctx, i.Store, doc)
+}
+
+// Skip records that a document has been skipped, for progress.
+func (i *Importer) Skip() {
+	i.count.Increment()
+}
+
+// Wait waits for all saved documents to be indexed into ElasticSearch and then
+// checks that none of them failed to be indexed. If enabled, it then writes
+// the schema of saved documents and the report of truncated documents.
+func (i *Importer) Wait(ctx context.Context) errors.E {
+	// TODO: Improve this to not have a busy wait.
+	for {
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		err := i.ESProcessor.Flush()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		stats := i.ESProcessor.Stats()
+		if i.saved.Count() <= stats.Indexed {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+
+	stats := i.ESProcessor.Stats()
+	if stats.Failed > 0 {
+		errE := errors.New("some documents failed to be indexed")
+		errors.Details(errE)["failed"] = stats.Failed
+		errors.Details(errE)["indexed"] = stats.Indexed
+		return errE
+	}
+
+	errE := i.writeSchema()
+	if errE != nil {
+		return errE
+	}
+
+	return i.truncator.WriteReport()
+}
+
+// writeSchema writes the schema of saved documents to the file, if enabled.
+func (i *Importer) writeSchema() errors.E {
+	if i.describer == nil {
+		return nil
+	}
+
+	i.describeMu.Lock()
+	schema := i.describer.Schema()
+	i.describeMu.Unlock()
+
+	data, errE := x.MarshalWithoutEscapeHTML(schema)
+	if errE != nil {
+		return errE
+	}
+	var out bytes.Buffer
+	err := json.Indent(&out, data, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	out.WriteString("\n")
+	err = os.WriteFile(i.describePath, out.Bytes(), 0o644) //nolint:mnd,gosec
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = i.describePath
+		return errE
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// Truncator truncates documents exceeding configured limits and records truncated documents.
+//
+// It is safe for concurrent use.
+type Truncator struct {
+	logger      zerolog.Logger
+	limits      document.Limits
+	reportPath  string
+	mu          sync.Mutex
+	truncations []document.Truncation
+}
+
+// NewTruncator returns a new Truncator based on config. It returns nil if no limits are configured.
+func NewTruncator(config *Config) *Truncator {
+	limits := document.Limits{
+		MaxClaimsPerProperty: config.Limits.MaxClaimsPerProperty,
+		MaxClaims:            config.Limits.MaxClaims,
+		MaxTextBytes:         config.Limits.MaxTextBytes,
+		Strategy:             config.Limits.Truncate,
+	}
+	if !limits.Enabled() {
+		return nil
+	}
+	return &Truncator{
+		logger:      config.Logger,
+		limits:      limits,
+		reportPath:  config.Limits.Report,
+		mu:          sync.Mutex{},
+		truncations: []document.Truncation{},
+	}
+}
+
+// Truncate removes claims from the document exceeding limits.
+// A nil Truncator does not truncate documents.
+func (t *Truncator) Truncate(doc *document.D) errors.E {
+	if t == nil {
+		return nil
+	}
+
+	truncation, errE := t.limits.Truncate(doc)
+	if errE != nil {
+		errors.Details(errE)["doc"] = doc.ID.String()
+		return errE
+	}
+	if truncation == nil {
+		return nil
+	}
+
+	t.logger.Warn().Str("doc", doc.ID.String()).Int("claims", truncation.Claims).Int("textBytes", truncation.TextBytes).
+		Msg("document exceeds limits and has been truncated")
+
+	if t.reportPath != "" {
+		t.mu.Lock()
+		t.truncations = append(t.truncations, *truncation)
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// WriteReport writes the report of truncated documents to the file, if enabled.
+func (t *Truncator) WriteReport() errors.E {
+	if t == nil || t.reportPath == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	data, errE := x.MarshalWithoutEscapeHTML(t.truncations)
+	t.mu.Unlock()
+	if errE != nil {
+		return errE
+	}
+	var out bytes.Buffer
+	err := json.Indent(&out, data, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	out.WriteString("\n")
+	err = os.WriteFile(t.reportPath, out.Bytes(), 0o644) //nolint:mnd,gosec
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = t.reportPath
+		return errE
+	}
+	return nil
+}
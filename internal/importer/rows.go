@@ -0,0 +1,470 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// Claim types to which column values can be mapped.
+const (
+	ColumnIdentifier = "id"
+	ColumnReference  = "ref"
+	ColumnText       = "text"
+	ColumnString     = "string"
+	ColumnAmount     = "amount"
+	ColumnTime       = "time"
+	ColumnRelation   = "rel"
+)
+
+// RowMapping maps rows returned by a SQL query into documents, so that relational
+// datasets can be imported without writing code for every table.
+type RowMapping struct {
+	// Name of the mapping (e.g., table name). Together with values of ID columns
+	// it determines IDs of documents.
+	Name string `yaml:"name"`
+	// Query returns rows to import.
+	Query string `yaml:"query"`
+	// ID are columns whose values identify a row.
+	ID []string `yaml:"id"`
+	// Types are mnemonics of types added to all documents with TYPE relation claims.
+	Types []string `yaml:"types,omitempty"`
+	// Columns maps columns to claims. Columns which are not listed are ignored.
+	Columns map[string]ColumnMapping `yaml:"columns"`
+
+	types []identifier.Identifier
+}
+
+// ColumnMapping maps values of a column to claims.
+type ColumnMapping struct {
+	// Property is the mnemonic of the property of claims (see document.ResolveMnemonic).
+	Property string `yaml:"property"`
+	// Type is the claim type to which values are converted.
+	Type string `yaml:"type"`
+	// Unit is the unit symbol of amount claims (see document.ParseAmountUnit). Default is "1".
+	Unit string `yaml:"unit,omitempty"`
+	// Precision of time claims (e.g., "d"). Default is "s".
+	Precision string `yaml:"precision,omitempty"`
+	// Mapping is the name of the mapping with rows to which relation claims point.
+	// Values of the column are values of the (single) ID column of those rows.
+	Mapping string `yaml:"mapping,omitempty"`
+
+	prop      identifier.Identifier
+	unit      document.AmountUnit
+	precision document.TimePrecision
+}
+
+// RowsConfig is the configuration of importing rows. It is read from a YAML file.
+type RowsConfig struct {
+	// Namespace from which IDs of documents and claims are derived.
+	Namespace uuid.UUID `yaml:"namespace"`
+	// Mappings of rows into documents.
+	Mappings []*RowMapping `yaml:"mappings"`
+}
+
+// LoadRowsConfig reads the configuration of importing rows from the file at path and validates it.
+func LoadRowsConfig(path string) (*RowsConfig, errors.E) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	var config RowsConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	errE := config.Validate()
+	if errE != nil {
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	return &config, nil
+}
+
+// Validate validates the configuration and resolves mnemonics, units, and precisions.
+func (c *RowsConfig) Validate() errors.E {
+	if c.Namespace == uuid.Nil {
+		return errors.New("namespace is required")
+	}
+	names := map[string]*RowMapping{}
+	for _, mapping := range c.Mappings {
+		if mapping.Name == "" {
+			return errors.New("mapping name is required")
+		}
+		if _, ok := names[mapping.Name]; ok {
+			errE := errors.New("duplicate mapping name")
+			errors.Details(errE)["mapping"] = mapping.Name
+			return errE
+		}
+		names[mapping.Name] = mapping
+	}
+	for _, mapping := range c.Mappings {
+		errE := mapping.validate(names)
+		if errE != nil {
+			errors.Details(errE)["mapping"] = mapping.Name
+			return errE
+		}
+	}
+	return nil
+}
+
+func (m *RowMapping) validate(names map[string]*RowMapping) errors.E {
+	if m.Query == "" {
+		return errors.New("query is required")
+	}
+	if len(m.ID) == 0 {
+		return errors.New("at least one ID column is required")
+	}
+
+	m.types = []identifier.Identifier{}
+	for _, mnemonic := range m.Types {
+		id, errE := document.ResolveMnemonic(mnemonic)
+		if errE != nil {
+			return errE
+		}
+		m.types = append(m.types, id)
+	}
+
+	for name, column := range m.Columns {
+		errE := column.validate(names)
+		if errE != nil {
+			errors.Details(errE)["column"] = name
+			return errE
+		}
+		m.Columns[name] = column
+	}
+	return nil
+}
+
+func (c *ColumnMapping) validate(names map[string]*RowMapping) errors.E {
+	prop, errE := document.ResolveMnemonic(c.Property)
+	if errE != nil {
+		return errE
+	}
+	c.prop = prop
+
+	switch c.Type {
+	case ColumnIdentifier, ColumnReference, ColumnText, ColumnString:
+	case ColumnAmount:
+		unit := c.Unit
+		if unit == "" {
+			unit = "1"
+		}
+		c.unit, errE = document.ParseAmountUnit(unit)
+		if errE != nil {
+			return errE
+		}
+	case ColumnTime:
+		precision := c.Precision
+		if precision == "" {
+			precision = "s"
+		}
+		errE = x.UnmarshalWithoutUnknownFields([]byte(strconv.Quote(precision)), &c.precision)
+		if errE != nil {
+			return errE
+		}
+	case ColumnRelation:
+		mapping, ok := names[c.Mapping]
+		if !ok {
+			errE := errors.New("unknown mapping of relation column")
+			errors.Details(errE)["target"] = c.Mapping
+			return errE
+		}
+		if len(mapping.ID) != 1 {
+			errE := errors.New("mapping of relation column must have a single ID column")
+			errors.Details(errE)["target"] = c.Mapping
+			return errE
+		}
+	default:
+		errE := errors.New("unsupported column type")
+		errors.Details(errE)["type"] = c.Type
+		return errE
+	}
+	return nil
+}
+
+// Document converts the row with values for columns into a document. Null values and empty
+// strings are skipped. The configuration has to be validated first.
+func (c *RowsConfig) Document(mapping *RowMapping, columns []string, values []interface{}) (*document.D, errors.E) {
+	if len(columns) != len(values) {
+		return nil, errors.New("number of columns and values do not match")
+	}
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		row[column] = values[i]
+	}
+
+	idArgs := []interface{}{mapping.Name}
+	for _, column := range mapping.ID {
+		value, ok := row[column]
+		if !ok || value == nil {
+			errE := errors.New("missing value of ID column")
+			errors.Details(errE)["column"] = column
+			return nil, errE
+		}
+		s, errE := columnString(value)
+		if errE != nil {
+			errors.Details(errE)["column"] = column
+			return nil, errE
+		}
+		// Values are trimmed in the same way as values of relation columns.
+		idArgs = append(idArgs, strings.TrimSpace(s))
+	}
+	claimID := func(args ...interface{}) identifier.Identifier {
+		return document.GetID(c.Namespace, append(append([]interface{}{}, idArgs...), args...)...)
+	}
+
+	doc := &document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    document.GetID(c.Namespace, idArgs...),
+			Score: document.LowConfidence,
+		},
+		Claims: nil,
+	}
+
+	for _, typeID := range mapping.types {
+		errE := doc.Add(&document.RelationClaim{
+			CoreClaim: document.CoreClaim{
+				ID:         claimID("TYPE", 0, typeID.String(), 0),
+				Confidence: document.HighConfidence,
+			},
+			Prop: document.GetCorePropertyReference("TYPE"),
+			To:   document.Reference{ID: &typeID}, //nolint:exhaustruct
+		})
+		if errE != nil {
+			return nil, errE
+		}
+	}
+
+	// We iterate over columns in the order of the row so that claims are added deterministically.
+	for _, name := range columns {
+		column, ok := mapping.Columns[name]
+		if !ok || row[name] == nil {
+			continue
+		}
+		claim, errE := c.columnClaim(&column, claimID(name, 0), row[name])
+		if errE != nil {
+			errors.Details(errE)["column"] = name
+			return nil, errE
+		}
+		if claim == nil {
+			continue
+		}
+		errE = doc.Add(claim)
+		if errE != nil {
+			errors.Details(errE)["column"] = name
+			return nil, errE
+		}
+	}
+
+	return doc, nil
+}
+
+// columnClaim converts the value of the column into a claim. It returns nil for empty strings.
+func (c *RowsConfig) columnClaim(column *ColumnMapping, id identifier.Identifier, value interface{}) (document.Claim, errors.E) { //nolint:ireturn
+	core := document.CoreClaim{
+		ID:         id,
+		Confidence: document.HighConfidence,
+	}
+	prop := document.Reference{ID: &column.prop} //nolint:exhaustruct
+
+	switch column.Type {
+	case ColumnAmount:
+		amount, errE := columnFloat(value)
+		if errE != nil {
+			return nil, errE
+		}
+		return &document.AmountClaim{CoreClaim: core, Prop: prop, Amount: amount, Unit: column.unit}, nil
+	case ColumnTime:
+		timestamp, errE := columnTime(value)
+		if errE != nil {
+			return nil, errE
+		}
+		return &document.TimeClaim{CoreClaim: core, Prop: prop, Timestamp: document.Timestamp(timestamp), Precision: column.precision}, nil
+	}
+
+	s, errE := columnString(value)
+	if errE != nil {
+		return nil, errE
+	}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	switch column.Type {
+	case ColumnIdentifier:
+		return &document.IdentifierClaim{CoreClaim: core, Prop: prop, Value: s}, nil
+	case ColumnReference:
+		return &document.ReferenceClaim{CoreClaim: core, Prop: prop, IRI: s}, nil
+	case ColumnText:
+		return &document.TextClaim{CoreClaim: core, Prop: prop, HTML: document.TranslatableHTMLString{"en": html.EscapeString(s)}}, nil
+	case ColumnRelation:
+		to := document.GetID(c.Namespace, column.Mapping, s)
+		return &document.RelationClaim{CoreClaim: core, Prop: prop, To: document.Reference{ID: &to}}, nil //nolint:exhaustruct
+	default:
+		return &document.StringClaim{CoreClaim: core, Prop: prop, String: s}, nil
+	}
+}
+
+// columnString converts a column value as returned by pgx into a string.
+func columnString(value interface{}) (string, errors.E) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, bool:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case driver.Valuer:
+		// E.g., pgtype.Numeric.
+		return valuerValue(v, columnString)
+	}
+	errE := errors.New("unsupported column value")
+	errors.Details(errE)["value"] = fmt.Sprintf("%T", value)
+	return "", errE
+}
+
+// columnFloat converts a column value as returned by pgx into a float.
+func columnFloat(value interface{}) (float64, errors.E) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return f, nil
+	case driver.Valuer:
+		return valuerValue(v, columnFloat)
+	}
+	errE := errors.New("unsupported amount value")
+	errors.Details(errE)["value"] = fmt.Sprintf("%T", value)
+	return 0, errE
+}
+
+// columnTime converts a column value as returned by pgx into a time.
+// Strings are parsed as RFC 3339 timestamps or dates.
+func columnTime(value interface{}) (time.Time, errors.E) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		v = strings.TrimSpace(v)
+		for _, layout := range []string{time.RFC3339, time.DateOnly} {
+			t, err := time.Parse(layout, v)
+			if err == nil {
+				return t.UTC(), nil
+			}
+		}
+		errE := errors.New("unable to parse time")
+		errors.Details(errE)["value"] = v
+		return time.Time{}, errE
+	case driver.Valuer:
+		return valuerValue(v, columnTime)
+	}
+	errE := errors.New("unsupported time value")
+	errors.Details(errE)["value"] = fmt.Sprintf("%T", value)
+	return time.Time{}, errE
+}
+
+// valuerValue converts the value of the valuer with convert.
+func valuerValue[T any](valuer driver.Valuer, convert func(interface{}) (T, errors.E)) (T, errors.E) {
+	v, err := valuer.Value()
+	if err != nil {
+		var zero T
+		return zero, errors.WithStack(err)
+	}
+	if _, ok := v.(driver.Valuer); ok || v == nil {
+		var zero T
+		errE := errors.New("unsupported column value")
+		errors.Details(errE)["value"] = fmt.Sprintf("%T", valuer)
+		return zero, errE
+	}
+	return convert(v)
+}
+
+// Querier runs SQL queries. It is implemented by pgx connections and pools.
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// ImportRows runs queries of all mappings of the configuration using db and saves
+// documents converted from returned rows. The configuration has to be validated first.
+func (i *Importer) ImportRows(ctx context.Context, db Querier, config *RowsConfig) errors.E {
+	for _, mapping := range config.Mappings {
+		errE := i.importMapping(ctx, db, config, mapping)
+		if errE != nil {
+			errors.Details(errE)["mapping"] = mapping.Name
+			return errE
+		}
+	}
+	return nil
+}
+
+func (i *Importer) importMapping(ctx context.Context, db Querier, config *RowsConfig, mapping *RowMapping) errors.E {
+	rows, err := db.Query(ctx, mapping.Query)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for _, field := range rows.FieldDescriptions() {
+		columns = append(columns, field.Name)
+	}
+
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		doc, errE := config.Document(mapping, columns, values)
+		if errE != nil {
+			return errE
+		}
+		errE = i.Save(ctx, doc)
+		if errE != nil {
+			return errE
+		}
+	}
+	return errors.WithStack(rows.Err())
+}
@@ -0,0 +1,197 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const testRowsConfig = `namespace: 0e2fa0b5-2d3e-4bd4-9d5c-8e0aa6b8e1a1
+mappings:
+  - name: products
+    query: SELECT id, name, gtin, url, weight, added, category FROM products
+    id: [id]
+    types: [ITEM]
+    columns:
+      name:
+        property: NAME
+        type: text
+      gtin:
+        property: MEDIA_TYPE
+        type: id
+      url:
+        property: FILE_URL
+        type: ref
+      weight:
+        property: WEIGHT
+        type: amount
+        unit: kg
+      added:
+        property: VALIDITY
+        type: time
+        precision: d
+      category:
+        property: LABEL
+        type: rel
+        mapping: categories
+  - name: categories
+    query: SELECT id, name FROM categories
+    id: [id]
+    columns:
+      name:
+        property: NAME
+        type: string
+`
+
+func writeRowsConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rows.yml")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	return path
+}
+
+func TestRowsConfigDocument(t *testing.T) {
+	t.Parallel()
+
+	config, errE := LoadRowsConfig(writeRowsConfig(t, testRowsConfig))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	added := time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)
+	doc, errE := config.Document(
+		config.Mappings[0],
+		[]string{"id", "name", "gtin", "url", "weight", "added", "category", "other"},
+		[]interface{}{int64(42), "Cat <food>", " 04006381333931 ", nil, 1.5, added, int32(7), "ignored"},
+	)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	assert.Equal(t, document.GetID(config.Namespace, "products", "42"), doc.ID)
+
+	item := document.GetCorePropertyID("ITEM")
+	category := document.GetID(config.Namespace, "categories", "7")
+	expected := &document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    doc.ID,
+			Score: document.LowConfidence,
+		},
+		Claims: &document.ClaimTypes{
+			Identifier: document.IdentifierClaims{{
+				CoreClaim: document.CoreClaim{ID: document.GetID(config.Namespace, "products", "42", "gtin", 0), Confidence: document.HighConfidence},
+				Prop:      document.GetCorePropertyReference("MEDIA_TYPE"),
+				Value:     "04006381333931",
+			}},
+			Text: document.TextClaims{{
+				CoreClaim: document.CoreClaim{ID: document.GetID(config.Namespace, "products", "42", "name", 0), Confidence: document.HighConfidence},
+				Prop:      document.GetCorePropertyReference("NAME"),
+				HTML:      document.TranslatableHTMLString{"en": "Cat &lt;food&gt;"},
+			}},
+			Amount: document.AmountClaims{{
+				CoreClaim: document.CoreClaim{ID: document.GetID(config.Namespace, "products", "42", "weight", 0), Confidence: document.HighConfidence},
+				Prop:      document.GetCorePropertyReference("WEIGHT"),
+				Amount:    1.5,
+				Unit:      document.AmountUnitKilogram,
+			}},
+			Relation: document.RelationClaims{{
+				CoreClaim: document.CoreClaim{ID: document.GetID(config.Namespace, "products", "42", "TYPE", 0, item.String(), 0), Confidence: document.HighConfidence},
+				Prop:      document.GetCorePropertyReference("TYPE"),
+				To:        document.Reference{ID: &item},
+			}, {
+				CoreClaim: document.CoreClaim{ID: document.GetID(config.Namespace, "products", "42", "category", 0), Confidence: document.HighConfidence},
+				Prop:      document.GetCorePropertyReference("LABEL"),
+				To:        document.Reference{ID: &category},
+			}},
+			Time: document.TimeClaims{{
+				CoreClaim: document.CoreClaim{ID: document.GetID(config.Namespace, "products", "42", "added", 0), Confidence: document.HighConfidence},
+				Prop:      document.GetCorePropertyReference("VALIDITY"),
+				Timestamp: document.Timestamp(added),
+				Precision: document.TimePrecisionDay,
+			}},
+		},
+	} //nolint:exhaustruct
+	expectedJSON, errE := x.MarshalWithoutEscapeHTML(expected)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	docJSON, errE := x.MarshalWithoutEscapeHTML(doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.JSONEq(t, string(expectedJSON), string(docJSON))
+
+	// Relation claims point to documents of the target mapping.
+	target, errE := config.Document(config.Mappings[1], []string{"id", "name"}, []interface{}{"7", "Pet food"})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, category, target.ID)
+
+	_, errE = config.Document(config.Mappings[1], []string{"id", "name"}, []interface{}{nil, "Pet food"})
+	assert.EqualError(t, errE, "missing value of ID column")
+
+	_, errE = config.Document(config.Mappings[0], []string{"id", "added"}, []interface{}{1, "yesterday"})
+	assert.EqualError(t, errE, "unable to parse time")
+
+	_, errE = config.Document(config.Mappings[0], []string{"id", "weight"}, []interface{}{1, struct{}{}})
+	assert.EqualError(t, errE, "unsupported amount value")
+}
+
+func TestLoadRowsConfigInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		config string
+		err    string
+	}{
+		{`mappings: []`, "namespace is required"},
+		{`namespace: 0e2fa0b5-2d3e-4bd4-9d5c-8e0aa6b8e1a1
+mappings:
+  - name: a
+    query: SELECT 1
+    id: [id]
+  - name: a
+    query: SELECT 1
+    id: [id]`, "duplicate mapping name"},
+		{`namespace: 0e2fa0b5-2d3e-4bd4-9d5c-8e0aa6b8e1a1
+mappings:
+  - name: a
+    id: [id]`, "query is required"},
+		{`namespace: 0e2fa0b5-2d3e-4bd4-9d5c-8e0aa6b8e1a1
+mappings:
+  - name: a
+    query: SELECT 1
+    id: [id]
+    columns:
+      x:
+        property: UNKNOWN_PROPERTY
+        type: string`, "unknown mnemonic"},
+		{`namespace: 0e2fa0b5-2d3e-4bd4-9d5c-8e0aa6b8e1a1
+mappings:
+  - name: a
+    query: SELECT 1
+    id: [id]
+    columns:
+      x:
+        property: NAME
+        type: file`, "unsupported column type"},
+		{`namespace: 0e2fa0b5-2d3e-4bd4-9d5c-8e0aa6b8e1a1
+mappings:
+  - name: a
+    query: SELECT 1
+    id: [id]
+    columns:
+      x:
+        property: LABEL
+        type: rel
+        mapping: b`, "unknown mapping of relation column"},
+		{`namespace: 0e2fa0b5-2d3e-4bd4-9d5c-8e0aa6b8e1a1
+mappings:
+  - name: a
+    query: SELECT 1
+    id: [id]
+    unknown: true`, "field unknown not found"},
+	} {
+		_, errE := LoadRowsConfig(writeRowsConfig(t, tt.config))
+		assert.ErrorContains(t, errE, tt.err)
+	}
+}
@@ -0,0 +1,128 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	s3DefaultRegion   = "us-east-1"
+	s3DefaultEndpoint = "s3.amazonaws.com"
+	// s3PartSize is the size of parts of multipart uploads.
+	// Files up to this size are uploaded with a single request.
+	s3PartSize = 64 << 20
+)
+
+// s3Cache is a CacheBackend which stores files in S3-compatible storage.
+type s3Cache struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// newS3Cache returns a backend for S3-compatible storage at "s3://bucket/prefix" URL.
+//
+// Credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and (optional)
+// AWS_SESSION_TOKEN environment variables, the region from AWS_REGION (default us-east-1).
+// If AWS_ENDPOINT_URL is set, it is used as the endpoint of S3-compatible storage with
+// path-style requests.
+func newS3Cache(u *url.URL, client *http.Client) (*s3Cache, errors.E) {
+	if u.Host == "" {
+		errE := errors.New("missing bucket")
+		errors.Details(errE)["url"] = u.String()
+		return nil, errE
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("missing S3 credentials")
+	}
+
+	options := &minio.Options{ //nolint:exhaustruct
+		Creds:        credentials.NewStaticV4(accessKey, secretKey, os.Getenv("AWS_SESSION_TOKEN")),
+		Secure:       true,
+		Region:       region,
+		Transport:    client.Transport,
+		BucketLookup: minio.BucketLookupAuto,
+	}
+	host := s3DefaultEndpoint
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		e, err := url.Parse(endpoint)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["endpoint"] = endpoint
+			return nil, errE
+		}
+		host = e.Host
+		options.Secure = e.Scheme != "http"
+		options.BucketLookup = minio.BucketLookupPath
+	}
+
+	c, err := minio.New(host, options)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["endpoint"] = host
+		return nil, errE
+	}
+
+	return &s3Cache{
+		client: c,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+// key returns the object key for the cached file with the name.
+func (c *s3Cache) key(name string) string {
+	if c.prefix != "" {
+		return c.prefix + "/" + name
+	}
+	return name
+}
+
+func (c *s3Cache) Get(ctx context.Context, name string) (io.ReadCloser, int64, errors.E) {
+	object, err := c.client.GetObject(ctx, c.bucket, c.key(name), minio.GetObjectOptions{}) //nolint:exhaustruct
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["name"] = name
+		return nil, 0, errE
+	}
+	// Stat makes the request, so that missing files are detected before reading.
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			errE := errors.WithStack(ErrCacheMiss)
+			errors.Details(errE)["name"] = name
+			return nil, 0, errE
+		}
+		errE := errors.WithStack(err)
+		errors.Details(errE)["name"] = name
+		return nil, 0, errE
+	}
+	return object, info.Size, nil
+}
+
+func (c *s3Cache) Put(ctx context.Context, name, path string) errors.E {
+	// Larger files are uploaded in parts and failed multipart uploads are aborted.
+	_, err := c.client.FPutObject(ctx, c.bucket, c.key(name), path, minio.PutObjectOptions{PartSize: s3PartSize}) //nolint:exhaustruct
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["name"] = name
+		return errE
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb/internal/es"
+)
+
+const (
+	// StatusFormatHuman logs status as a human-readable message.
+	StatusFormatHuman = "human"
+	// StatusFormatJSON writes status as one JSON object per line.
+	StatusFormatJSON = "json"
+)
+
+// Status describes progress of a long-running processing.
+type Status struct {
+	Description string `json:"description"`
+	// Number of processed items.
+	Processed int64 `json:"processed"`
+	// Processed items per second since the previous report.
+	Rate float64 `json:"rate"`
+	// Percent of the input consumed. It is nil when the size of the input is not known.
+	Percent *float64 `json:"percent,omitempty"`
+	// Elapsed time in seconds.
+	Elapsed float64 `json:"elapsed"`
+	// Estimated remaining time in seconds. It is nil when the size of the input is not known.
+	ETA *float64 `json:"eta,omitempty"`
+	// Count of errors by category.
+	Errors map[string]int64 `json:"errors"`
+	// Number of documents indexed and failed to be indexed by ElasticSearch.
+	Indexed     *int64 `json:"indexed,omitempty"`
+	IndexFailed *int64 `json:"indexFailed,omitempty"`
+	// Number of cache misses since the previous report.
+	CacheMiss *uint64 `json:"cacheMiss,omitempty"`
+}
+
+// String returns a human-readable representation of the status.
+func (s Status) String() string {
+	var b strings.Builder
+	b.WriteString(s.Description)
+	if s.Percent != nil {
+		fmt.Fprintf(&b, " %0.2f%%", *s.Percent)
+	}
+	fmt.Fprintf(&b, ", %d processed (%0.1f/s)", s.Processed, s.Rate)
+	if s.ETA != nil {
+		fmt.Fprintf(&b, ", ETA %s", (time.Duration(*s.ETA) * time.Second).String())
+	}
+	categories := make([]string, 0, len(s.Errors))
+	for category := range s.Errors {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	errs := []string{}
+	for _, category := range categories {
+		if s.Errors[category] > 0 {
+			errs = append(errs, fmt.Sprintf("%s=%d", category, s.Errors[category]))
+		}
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(&b, ", errors: %s", strings.Join(errs, " "))
+	}
+	return b.String()
+}
+
+// StatusReporter tracks progress of processing items and periodically reports it, together with
+// counts of errors by category. Items are processed from an input of known size (e.g., a dump file),
+// whose consumption is used to estimate the percent done and remaining time.
+//
+// It is safe for concurrent use.
+type StatusReporter struct {
+	logger      zerolog.Logger
+	description string
+	format      string
+	output      io.Writer
+	esProcessor *elastic.BulkProcessor
+	cache       *es.Cache
+
+	started   time.Time
+	processed x.Counter
+	// Map is populated at construction and not changed afterwards.
+	errors map[string]*x.Counter
+
+	mu sync.Mutex
+	// Last progress of input consumption.
+	progress *x.Progress
+	// Processed items at the time of the last progress.
+	lastProcessed int64
+	rate          float64
+	cacheMiss     *uint64
+}
+
+// NewStatusReporter returns a new StatusReporter for errors in categories.
+//
+// With StatusFormatHuman format, status is logged. With StatusFormatJSON format,
+// status is written to output. esProcessor and cache are optional.
+func NewStatusReporter(
+	logger zerolog.Logger, description, format string, output io.Writer, categories []string,
+	esProcessor *elastic.BulkProcessor, cache *es.Cache,
+) *StatusReporter {
+	errs := make(map[string]*x.Counter, len(categories))
+	for _, category := range categories {
+		errs[category] = new(x.Counter)
+	}
+	return &StatusReporter{
+		logger:        logger,
+		description:   description,
+		format:        format,
+		output:        output,
+		esProcessor:   esProcessor,
+		cache:         cache,
+		started:       time.Now(),
+		processed:     0,
+		errors:        errs,
+		mu:            sync.Mutex{},
+		progress:      nil,
+		lastProcessed: 0,
+		rate:          0,
+		cacheMiss:     nil,
+	}
+}
+
+// Processed records that an item has been processed (successfully or not).
+func (s *StatusReporter) Processed() {
+	s.processed.Increment()
+}
+
+// Error records an error in the category. Category must be one of those passed to NewStatusReporter.
+func (s *StatusReporter) Error(category string) {
+	counter, ok := s.errors[category]
+	if !ok {
+		panic(errors.Errorf(`unknown error category "%s"`, category))
+	}
+	counter.Increment()
+}
+
+// Status returns the current status.
+func (s *StatusReporter) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status(time.Now())
+}
+
+func (s *StatusReporter) status(now time.Time) Status {
+	errs := make(map[string]int64, len(s.errors))
+	for category, counter := range s.errors {
+		errs[category] = counter.Count()
+	}
+	status := Status{
+		Description: s.description,
+		Processed:   s.processed.Count(),
+		Rate:        s.rate,
+		Percent:     nil,
+		Elapsed:     now.Sub(s.started).Seconds(),
+		ETA:         nil,
+		Errors:      errs,
+		Indexed:     nil,
+		IndexFailed: nil,
+		CacheMiss:   s.cacheMiss,
+	}
+	if s.progress != nil && s.progress.Size > 0 {
+		percent := s.progress.Percent()
+		status.Percent = &percent
+		if s.progress.Count > 0 {
+			eta := max(s.progress.Remaining().Truncate(time.Second).Seconds(), 0)
+			status.ETA = &eta
+		}
+	}
+	if s.esProcessor != nil {
+		stats := s.esProcessor.Stats()
+		status.Indexed = &stats.Succeeded
+		status.IndexFailed = &stats.Failed
+	}
+	return status
+}
+
+// Progress records progress of input consumption and reports the status.
+//
+// It can be used as a progress callback (e.g., for mediawiki.ProcessDumpConfig).
+func (s *StatusReporter) Progress(_ context.Context, p x.Progress) {
+	s.mu.Lock()
+	previous := s.progress
+	processed := s.processed.Count()
+	interval := p.Elapsed
+	if previous != nil {
+		interval = p.Current.Sub(previous.Current)
+	}
+	if interval > 0 {
+		s.rate = float64(processed-s.lastProcessed) / interval.Seconds()
+	}
+	s.progress = &p
+	s.lastProcessed = processed
+	if s.cache != nil {
+		cacheMiss := s.cache.MissCount()
+		s.cacheMiss = &cacheMiss
+	}
+	status := s.status(p.Current)
+	s.mu.Unlock()
+
+	s.report(status)
+}
+
+func (s *StatusReporter) report(status Status) {
+	if s.format == StatusFormatJSON {
+		data, errE := x.MarshalWithoutEscapeHTML(status)
+		if errE != nil {
+			s.logger.Error().Err(errE).Msg("unable to marshal status")
+			return
+		}
+		_, err := s.output.Write(append(data, '\n'))
+		if err != nil {
+			s.logger.Error().Err(err).Msg("unable to write status")
+		}
+		return
+	}
+
+	e := s.logger.Info().Int64("processed", status.Processed).Float64("rate", status.Rate)
+	if status.ETA != nil {
+		e = e.Str("eta", (time.Duration(*status.ETA) * time.Second).String())
+	}
+	if status.Indexed != nil {
+		e = e.Int64("indexed", *status.Indexed).Int64("failed", *status.IndexFailed)
+	}
+	if status.CacheMiss != nil {
+		e = e.Uint64("cacheMiss", *status.CacheMiss)
+	}
+	e.Interface("errors", status.Errors).Msg(status.String())
+}
+
+// ServeHTTP serves the current status as JSON.
+func (s *StatusReporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	data, errE := x.MarshalWithoutEscapeHTML(s.Status())
+	if errE != nil {
+		s.logger.Error().Err(errE).Msg("unable to marshal status")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(data)
+}
+
+// Serve starts serving the current status as JSON over HTTP on the port, on all interfaces.
+// Returned function stops the server.
+func (s *StatusReporter) Serve(ctx context.Context, port int) (func(), errors.E) {
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "tcp", fmt.Sprintf(":%d", port)) //nolint:exhaustruct
+	if err != nil {
+		errE := errors.WithMessage(err, "unable to listen for status")
+		errors.Details(errE)["port"] = port
+		return nil, errE
+	}
+	server := &http.Server{ //nolint:exhaustruct
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second, //nolint:mnd
+	}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error().Err(err).Msg("status server failed")
+		}
+	}()
+	s.logger.Info().Str("address", listener.Addr().String()).Msg("serving status")
+	return func() {
+		server.Close()
+	}, nil
+}
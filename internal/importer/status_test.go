@@ -0,0 +1,74 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+)
+
+func TestStatusReporter(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	status := NewStatusReporter(zerolog.Nop(), "test", StatusFormatJSON, &output, []string{"skipped", "failed"}, nil, nil)
+
+	for range 10 {
+		status.Processed()
+	}
+	status.Error("skipped")
+	status.Error("skipped")
+	status.Error("failed")
+	assert.Panics(t, func() { status.Error("unknown") })
+
+	s := status.Status()
+	assert.Equal(t, int64(10), s.Processed)
+	assert.Equal(t, map[string]int64{"skipped": 2, "failed": 1}, s.Errors)
+	assert.Nil(t, s.Percent)
+	assert.Nil(t, s.ETA)
+
+	// Progress is obtained from a ticker, so we make one to report for us.
+	counter := x.Counter(0)
+	counter.Add(25)
+	ticker := x.NewTicker(context.Background(), &counter, 100, time.Millisecond)
+	p := <-ticker.C
+	ticker.Stop()
+	status.Progress(context.Background(), p)
+
+	var reported Status
+	require.NoError(t, json.Unmarshal(output.Bytes(), &reported))
+	assert.Equal(t, "test", reported.Description)
+	assert.Equal(t, int64(10), reported.Processed)
+	assert.Positive(t, reported.Rate)
+	require.NotNil(t, reported.Percent)
+	assert.InDelta(t, 25.0, *reported.Percent, 0.001)
+	assert.NotNil(t, reported.ETA)
+	assert.Equal(t, map[string]int64{"skipped": 2, "failed": 1}, reported.Errors)
+
+	assert.Contains(t, reported.String(), "test 25.00%, 10 processed")
+	assert.Contains(t, reported.String(), "errors: failed=1 skipped=2")
+
+	server := httptest.NewServer(status)
+	t.Cleanup(server.Close)
+	resp, err := server.Client().Get(server.URL) //nolint:noctx
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var served Status
+	require.NoError(t, json.Unmarshal(data, &served))
+	assert.Equal(t, reported.Processed, served.Processed)
+	assert.Equal(t, reported.Errors, served.Errors)
+	assert.Equal(t, reported.Percent, served.Percent)
+}
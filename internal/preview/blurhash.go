@@ -0,0 +1,122 @@
+package preview
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	blurhashComponentsX = 4
+	blurhashComponentsY = 3
+
+	blurhashCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// Blurhash encodes the image as a blurhash string with componentsX×componentsY components.
+//
+// See: https://blurha.sh/
+func Blurhash(img *image.RGBA, componentsX, componentsY int) (string, errors.E) {
+	if componentsX < 1 || componentsX > 9 || componentsY < 1 || componentsY > 9 {
+		errE := errors.New("invalid number of components")
+		errors.Details(errE)["x"] = componentsX
+		errors.Details(errE)["y"] = componentsY
+		return "", errE
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return "", errors.New("empty image")
+	}
+
+	// We convert pixels to linear RGB only once.
+	linear := make([][3]float64, width*height)
+	for y := range height {
+		for x := range width {
+			offset := img.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			linear[y*width+x] = [3]float64{
+				sRGBToLinear(img.Pix[offset]),
+				sRGBToLinear(img.Pix[offset+1]),
+				sRGBToLinear(img.Pix[offset+2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := range componentsY {
+		for i := range componentsX {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+			var factor [3]float64
+			for y := range height {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := range width {
+					basis := normalisation * math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * basisY
+					pixel := linear[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+			scale := 1.0 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	encodeBase83(&hash, (componentsX-1)+(componentsY-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximumValue := 0.0
+		for _, f := range ac {
+			actualMaximumValue = max(actualMaximumValue, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantisedMaximumValue := int(max(0, min(82, math.Floor(actualMaximumValue*166-0.5))))
+		maximumValue = float64(quantisedMaximumValue+1) / 166
+		encodeBase83(&hash, quantisedMaximumValue, 1)
+	} else {
+		encodeBase83(&hash, 0, 1)
+	}
+
+	encodeBase83(&hash, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		encodeBase83(&hash, quantiseAC(f[0], maximumValue)*19*19+quantiseAC(f[1], maximumValue)*19+quantiseAC(f[2], maximumValue), 2)
+	}
+
+	return hash.String(), nil
+}
+
+func encodeBase83(b *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83 //nolint:mnd
+		b.WriteByte(blurhashCharacters[digit])
+	}
+}
+
+func quantiseAC(value, maximumValue float64) int {
+	v := value / maximumValue
+	return int(max(0, min(18, math.Floor(math.Copysign(math.Sqrt(math.Abs(v)), v)*9+9.5))))
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := max(0, min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
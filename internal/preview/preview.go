@@ -0,0 +1,150 @@
+// Package preview generates standardized previews of image files.
+package preview
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Register GIF decoder.
+	"image/jpeg"
+	_ "image/png" // Register PNG decoder.
+
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	// MediaType is the media type of generated thumbnails.
+	MediaType = "image/jpeg"
+
+	// MaxPixels is the maximum number of pixels of an image for which a preview is generated.
+	MaxPixels = 50_000_000
+
+	// JPEG quality of generated thumbnails.
+	jpegQuality = 85
+)
+
+// ErrUnsupported is returned for files for which a preview cannot be generated.
+var ErrUnsupported = errors.Base("unsupported file")
+
+// SupportedMediaTypes are media types of files for which previews can be generated.
+//
+//nolint:gochecknoglobals
+var SupportedMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Preview is a generated preview of a file.
+type Preview struct {
+	// Thumbnail encoded as JPEG.
+	Data []byte
+
+	Width  int
+	Height int
+
+	// Blurhash of the thumbnail, to be used as a placeholder while the thumbnail is loading.
+	Blurhash string
+}
+
+// Generate decodes the image in data and returns its preview with the thumbnail
+// fitting into size×size pixels. Images smaller than that are not upscaled.
+func Generate(data []byte, size int) (*Preview, errors.E) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, errors.WithStack(ErrUnsupported)
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		errE := errors.WithMessage(ErrUnsupported, "invalid image dimensions")
+		errors.Details(errE)["width"] = config.Width
+		errors.Details(errE)["height"] = config.Height
+		return nil, errE
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["format"] = format
+		return nil, errE
+	}
+
+	thumbnail := Thumbnail(img, size)
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: jpegQuality})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	hash, errE := Blurhash(thumbnail, blurhashComponentsX, blurhashComponentsY)
+	if errE != nil {
+		return nil, errE
+	}
+
+	return &Preview{
+		Data:     buf.Bytes(),
+		Width:    thumbnail.Bounds().Dx(),
+		Height:   thumbnail.Bounds().Dy(),
+		Blurhash: hash,
+	}, nil
+}
+
+// Thumbnail returns the image downscaled to fit into size×size pixels, preserving its aspect ratio.
+//
+// Transparent parts of the image are composed over white background.
+// Pixels are downscaled by averaging all source pixels covered by a target pixel.
+func Thumbnail(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Over)
+
+	dstWidth, dstHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			dstWidth = size
+			dstHeight = max(1, (height*size+width/2)/width)
+		} else {
+			dstHeight = size
+			dstWidth = max(1, (width*size+height/2)/height)
+		}
+	}
+	if dstWidth == width && dstHeight == height {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := range dstHeight {
+		y0 := y * height / dstHeight
+		y1 := max(y0+1, (y+1)*height/dstHeight)
+		for x := range dstWidth {
+			x0 := x * width / dstWidth
+			x1 := max(x0+1, (x+1)*width/dstWidth)
+
+			var r, g, b, count int
+			for sy := y0; sy < y1; sy++ {
+				offset := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[offset])
+					g += int(src.Pix[offset+1])
+					b += int(src.Pix[offset+2])
+					offset += 4
+					count++
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8((r + count/2) / count)   //nolint:gosec
+			dst.Pix[offset+1] = uint8((g + count/2) / count) //nolint:gosec
+			dst.Pix[offset+2] = uint8((b + count/2) / count) //nolint:gosec
+			dst.Pix[offset+3] = 0xff
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,97 @@
+package preview_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/internal/preview"
+)
+
+func uniformImage(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestThumbnail(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		width, height                 int
+		expectedWidth, expectedHeight int
+	}{
+		{1000, 500, 256, 128},
+		{500, 1000, 128, 256},
+		{300, 300, 256, 256},
+		{100, 50, 100, 50},
+		{2000, 1, 256, 1},
+	} {
+		thumbnail := preview.Thumbnail(uniformImage(tt.width, tt.height, color.RGBA{R: 200, G: 100, B: 50, A: 255}), 256)
+		assert.Equal(t, tt.expectedWidth, thumbnail.Bounds().Dx())
+		assert.Equal(t, tt.expectedHeight, thumbnail.Bounds().Dy())
+		assert.Equal(t, color.RGBA{R: 200, G: 100, B: 50, A: 255}, thumbnail.RGBAAt(0, 0))
+	}
+
+	// Transparent pixels are composed over white background.
+	thumbnail := preview.Thumbnail(uniformImage(10, 10, color.Transparent), 256)
+	assert.Equal(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, thumbnail.RGBAAt(5, 5))
+}
+
+func TestBlurhash(t *testing.T) {
+	t.Parallel()
+
+	hash, errE := preview.Blurhash(uniformImage(32, 32, color.White), 4, 3)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	// Size flag, maximum AC value, white DC, and AC components.
+	assert.Equal(t, "L9TSUA~qfQ~q~qoffQoffQfQfQfQ", hash)
+
+	hash, errE = preview.Blurhash(uniformImage(32, 32, color.Black), 1, 1)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "000000", hash)
+
+	img := uniformImage(32, 32, color.Black)
+	for y := range 32 {
+		for x := range 16 {
+			img.Set(x, y, color.White)
+		}
+	}
+	hash, errE = preview.Blurhash(img, 4, 3)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, hash, 28)
+	assert.NotEqual(t, "0", hash[1:2])
+
+	_, errE = preview.Blurhash(img, 10, 3)
+	assert.Error(t, errE)
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, uniformImage(600, 300, color.RGBA{R: 10, G: 20, B: 30, A: 255})))
+
+	p, errE := preview.Generate(buf.Bytes(), 256)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 256, p.Width)
+	assert.Equal(t, 128, p.Height)
+	assert.Len(t, p.Blurhash, 28)
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(p.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 256, config.Width)
+	assert.Equal(t, 128, config.Height)
+
+	_, errE = preview.Generate([]byte("not an image"), 256)
+	assert.ErrorIs(t, errE, preview.ErrUnsupported)
+}
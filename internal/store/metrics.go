@@ -13,4 +13,6 @@ const (
 	MetricJSONUnmarshal          = "d"
 	MetricJSONUnmarshal1         = "d1"
 	MetricJSONUnmarshal2         = "d2"
+	MetricQueue                  = "q"
+	MetricQueueLength            = "ql"
 )
@@ -32,6 +32,7 @@ const (
 	ErrorCodeDuplicateFunction    = "42723"
 	ErrorCodeSerializationFailure = "40001"
 	ErrorCodeDeadlockDetected     = "40P01"
+	ErrorCodeReadOnlyTransaction  = "25006"
 	ErrorExclusionViolation       = "23P01"
 )
 
@@ -198,6 +199,9 @@ func EnsureSchema(ctx context.Context, tx pgx.Tx, schema string) errors.E {
 				return nil
 			case ErrorCodeDuplicateSchema:
 				return nil
+			case ErrorCodeReadOnlyTransaction:
+				// In read-only mode, the schema has to be created by another instance.
+				return nil
 			}
 		}
 		return WithPgxError(err)
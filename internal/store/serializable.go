@@ -61,6 +61,10 @@ func RetryTransaction(
 		return nestedTransaction(ctx, parentTx.Tx, fn)
 	}
 
+	if IsReadOnly(ctx) {
+		accessMode = pgx.ReadOnly
+	}
+
 	metrics, _ := waf.GetMetrics(ctx)
 	counter := metrics.Counter(MetricDatabaseRetries)
 
@@ -1,5 +1,9 @@
 package store
 
+import (
+	"context"
+)
+
 // contextKey is a value for use with context.WithValue. It's used as
 // a pointer so it fits in an interface{} without allocation.
 type contextKey struct {
@@ -8,3 +12,18 @@ type contextKey struct {
 
 // transactionContextKey contains the existing transaction, if any.
 var transactionContextKey = &contextKey{"transaction"} //nolint:gochecknoglobals
+
+// readOnlyContextKey is set when all transactions should be read-only.
+var readOnlyContextKey = &contextKey{"readOnly"} //nolint:gochecknoglobals
+
+// WithReadOnly returns a context in which all transactions started by
+// RetryTransaction are read-only, so that nothing can be written to the database.
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyContextKey, true)
+}
+
+// IsReadOnly returns true if the context has been made read-only using WithReadOnly.
+func IsReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyContextKey).(bool)
+	return readOnly
+}
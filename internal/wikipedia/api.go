@@ -58,10 +58,10 @@ func shallowCopy(in url.Values) url.Values {
 }
 
 func ListAllPages(
-	ctx context.Context, httpClient *retryablehttp.Client, namespaces []int, site string, limiter *rate.Limiter, output chan<- AllPagesPage,
+	ctx context.Context, httpClient *retryablehttp.Client, namespaces []int, site string, output chan<- AllPagesPage,
 ) errors.E {
-	// We still want to make sure we are contacting query API only once every second.
-	localLimiter := rate.NewLimiter(rate.Every(time.Second), 1)
+	// We want to make sure we are contacting query API only once every second.
+	limiter := rate.NewLimiter(rate.Every(time.Second), 1)
 
 	for _, namespace := range namespaces {
 		baseData := url.Values{}
@@ -86,13 +86,7 @@ func ListAllPages(
 		previousURL := ""
 
 		for {
-			err := localLimiter.Wait(ctx)
-			if err != nil {
-				// Context has been canceled.
-				return errors.WithStack(err)
-			}
-
-			err = limiter.Wait(ctx)
+			err := limiter.Wait(ctx)
 			if err != nil {
 				// Context has been canceled.
 				return errors.WithStack(err)
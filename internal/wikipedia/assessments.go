@@ -0,0 +1,272 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// qualityClasses maps lower case WikiProject assessment quality classes of articles
+// to their canonical names and ranks. Higher rank means higher quality.
+// Classes of non-article pages (e.g., "Disambig", "Redirect") are not included.
+//
+//nolint:gochecknoglobals
+var qualityClasses = map[string]struct {
+	Name string
+	Rank int
+}{
+	"stub":  {"Stub", 1},
+	"start": {"Start", 2},
+	"list":  {"List", 3},
+	"c":     {"C", 3},
+	"b":     {"B", 4},
+	"ga":    {"GA", 5},
+	"a":     {"A", 6},
+	"fl":    {"FL", 7},
+	"fa":    {"FA", 7},
+}
+
+// importanceLevels maps lower case WikiProject assessment importance levels
+// to their canonical names and ranks. Higher rank means higher importance.
+//
+//nolint:gochecknoglobals
+var importanceLevels = map[string]struct {
+	Name string
+	Rank int
+}{
+	"low":  {"Low", 1},
+	"mid":  {"Mid", 2},
+	"high": {"High", 3},
+	"top":  {"Top", 4},
+}
+
+// Assessment is a WikiProject assessment of an article.
+//
+// Only fields which could be extracted are set.
+type Assessment struct {
+	Class      string
+	ClassRank  int
+	Importance string
+}
+
+type wikitextTemplate struct {
+	Name   string
+	Params map[string]string
+}
+
+// findClosing returns the index of "}}" closing the template which starts at i,
+// or -1 if the template is not closed.
+func findClosing(wikitext string, i int) int {
+	depth := 0
+	for i < len(wikitext)-1 {
+		switch wikitext[i : i+2] {
+		case "{{":
+			depth++
+			i += 2
+		case "}}":
+			depth--
+			if depth == 0 {
+				return i
+			}
+			i += 2
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// splitTemplate splits the body of a template at pipes which are not
+// inside nested templates or links.
+func splitTemplate(body string) []string {
+	parts := []string{}
+	depth := 0
+	start := 0
+	for i := 0; i < len(body); i++ {
+		switch {
+		case strings.HasPrefix(body[i:], "{{") || strings.HasPrefix(body[i:], "[["):
+			depth++
+			i++
+		case strings.HasPrefix(body[i:], "}}") || strings.HasPrefix(body[i:], "]]"):
+			depth--
+			i++
+		case body[i] == '|' && depth == 0:
+			parts = append(parts, body[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, body[start:])
+}
+
+// parseTemplates returns all templates used in wikitext, including nested ones.
+// Positional parameters are not returned.
+func parseTemplates(wikitext string) []wikitextTemplate {
+	templates := []wikitextTemplate{}
+	for i := 0; i < len(wikitext)-1; {
+		if wikitext[i:i+2] != "{{" {
+			i++
+			continue
+		}
+		end := findClosing(wikitext, i)
+		if end == -1 {
+			break
+		}
+		parts := splitTemplate(wikitext[i+2 : end])
+		name := strings.TrimSpace(strings.ReplaceAll(parts[0], "_", " "))
+		if name != "" {
+			template := wikitextTemplate{
+				Name:   FirstUpperCase(name),
+				Params: map[string]string{},
+			}
+			for _, part := range parts[1:] {
+				key, value, ok := strings.Cut(part, "=")
+				if ok {
+					template.Params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+				}
+			}
+			templates = append(templates, template)
+		}
+		// Nested templates (e.g., WikiProject banners inside a banner shell) are parsed as well.
+		templates = append(templates, parseTemplates(wikitext[i+2:end])...)
+		i = end + 2
+	}
+	return templates
+}
+
+func isWikiProjectBanner(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "wikiproject") || strings.HasPrefix(name, "WP")
+}
+
+func isWikiProjectBannerShell(name string) bool {
+	switch strings.ToLower(name) {
+	case "wikiproject banner shell", "wikiprojectbannershell", "wikiproject banners", "wpbs", "wpb":
+		return true
+	}
+	return false
+}
+
+// ParseAssessment extracts WikiProject assessment from wikitext of a talk page.
+//
+// Quality class set in the banner shell takes precedence over those set in banners
+// of individual WikiProjects, otherwise the highest one is used. The highest importance
+// among WikiProjects is used.
+func ParseAssessment(wikitext string) Assessment {
+	assessment := Assessment{}
+	shellClass := false
+	importanceRank := 0
+	for _, template := range parseTemplates(wikitext) {
+		if !isWikiProjectBanner(template.Name) {
+			continue
+		}
+		shell := isWikiProjectBannerShell(template.Name)
+		if class, ok := qualityClasses[strings.ToLower(template.Params["class"])]; ok {
+			if shell && !shellClass {
+				shellClass = true
+				assessment.Class = class.Name
+				assessment.ClassRank = class.Rank
+			} else if !shellClass && class.Rank > assessment.ClassRank {
+				assessment.Class = class.Name
+				assessment.ClassRank = class.Rank
+			}
+		}
+		if importance, ok := importanceLevels[strings.ToLower(template.Params["importance"])]; ok && importance.Rank > importanceRank {
+			importanceRank = importance.Rank
+			assessment.Importance = importance.Name
+		}
+	}
+	return assessment
+}
+
+// ConvertAssessment sets claims with WikiProject assessment of the article on the document,
+// replacing any existing ones: ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_CLASS (e.g., "FA"),
+// ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_RANK (from 1 for "Stub" to 7 for "FA" and "FL", usable for
+// scoring), and ENGLISH_WIKIPEDIA_ARTICLE_IMPORTANCE (e.g., "High").
+func ConvertAssessment(id string, assessment Assessment, doc *document.D) errors.E {
+	doc.Remove(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_CLASS"))
+	doc.Remove(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_RANK"))
+	doc.Remove(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_IMPORTANCE"))
+
+	claims := []document.Claim{}
+	if assessment.Class != "" {
+		claims = append(claims,
+			&document.StringClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceWikidata, id, "ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_CLASS", 0),
+					Confidence: document.HighConfidence,
+				},
+				Prop:   document.GetCorePropertyReference("ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_CLASS"),
+				String: assessment.Class,
+			},
+			&document.AmountClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceWikidata, id, "ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_RANK", 0),
+					Confidence: document.HighConfidence,
+				},
+				Prop:   document.GetCorePropertyReference("ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_RANK"),
+				Amount: float64(assessment.ClassRank),
+				Unit:   document.AmountUnitNone,
+			},
+		)
+	}
+	if assessment.Importance != "" {
+		claims = append(claims, &document.StringClaim{
+			CoreClaim: document.CoreClaim{
+				ID:         document.GetID(NameSpaceWikidata, id, "ENGLISH_WIKIPEDIA_ARTICLE_IMPORTANCE", 0),
+				Confidence: document.HighConfidence,
+			},
+			Prop:   document.GetCorePropertyReference("ENGLISH_WIKIPEDIA_ARTICLE_IMPORTANCE"),
+			String: assessment.Importance,
+		})
+	}
+
+	for _, claim := range claims {
+		err := doc.Add(claim)
+		if err != nil {
+			errE := errors.WithMessage(err, "claim cannot be added")
+			errors.Details(errE)["doc"] = doc.ID.String()
+			errors.Details(errE)["claim"] = claim.GetID().String()
+			return errE
+		}
+	}
+
+	return nil
+}
+
+// GetEnglishWikipediaArticle returns the document of the Wikidata entity with the English Wikipedia
+// article with the given title, together with the ID of the entity.
+func GetEnglishWikipediaArticle(
+	ctx context.Context, s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	index string, esClient *elastic.Client, title string,
+) (*document.D, store.Version, string, errors.E) {
+	doc, version, errE := getDocumentFromByProp(ctx, s, index, esClient, "ENGLISH_WIKIPEDIA_PAGE_TITLE", title)
+	if errE != nil {
+		errors.Details(errE)["title"] = title
+		return nil, store.Version{}, "", errE
+	}
+
+	for _, claim := range doc.Get(document.GetCorePropertyID("WIKIDATA_ITEM_ID")) {
+		idClaim, ok := claim.(*document.IdentifierClaim)
+		if !ok {
+			errE := errors.New("unexpected ID claim type")
+			errors.Details(errE)["doc"] = doc.ID.String()
+			errors.Details(errE)["claim"] = claim.GetID().String()
+			errors.Details(errE)["got"] = fmt.Sprintf("%T", claim)
+			errors.Details(errE)["expected"] = fmt.Sprintf("%T", new(document.IdentifierClaim))
+			return nil, store.Version{}, "", errE
+		}
+		return doc, version, idClaim.Value, nil
+	}
+
+	errE = errors.New("document without Wikidata item ID")
+	errors.Details(errE)["doc"] = doc.ID.String()
+	errors.Details(errE)["title"] = title
+	return nil, store.Version{}, "", errE
+}
@@ -0,0 +1,90 @@
+package wikipedia
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestParseAssessment(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		wikitext   string
+		assessment Assessment
+	}{
+		{
+			"banner shell",
+			`{{Talk header}}
+{{WikiProject banner shell|class=fa|vital=yes|1=
+{{WikiProject Biography|importance=Mid|living=no}}
+{{WikiProject Physics|class=B|importance=top}}
+{{WikiProject Germany|importance=[[Low]]}}
+}}
+{{Article history|action1=FAC}}`,
+			Assessment{Class: "FA", ClassRank: 7, Importance: "Top"},
+		},
+		{
+			"individual banners",
+			`{{WPBIO|class=start|importance=low}}
+{{WikiProject Women in Red| class = GA | importance = High }}`,
+			Assessment{Class: "GA", ClassRank: 5, Importance: "High"},
+		},
+		{
+			"not assessed",
+			`{{WikiProject banner shell|class=Disambig|1={{WikiProject Disambiguation}}}}
+{{Talk header|class=FA}}`,
+			Assessment{Class: "", ClassRank: 0, Importance: ""},
+		},
+		{
+			"unclosed",
+			`{{WikiProject Biography|class=Stub|importance=Low}} {{WikiProject Physics|class=B`,
+			Assessment{Class: "Stub", ClassRank: 1, Importance: "Low"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.assessment, ParseAssessment(tt.wikitext))
+		})
+	}
+}
+
+func TestConvertAssessment(t *testing.T) {
+	t.Parallel()
+
+	doc := &document.D{
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence},
+		Claims:       nil,
+	}
+
+	errE := ConvertAssessment("Q42", Assessment{Class: "GA", ClassRank: 5, Importance: "High"}, doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	classes := doc.Get(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_CLASS"))
+	require.Len(t, classes, 1)
+	assert.Equal(t, "GA", classes[0].(*document.StringClaim).String) //nolint:forcetypeassert
+	ranks := doc.Get(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_RANK"))
+	require.Len(t, ranks, 1)
+	assert.InDelta(t, 5.0, ranks[0].(*document.AmountClaim).Amount, 0) //nolint:forcetypeassert
+	importances := doc.Get(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_IMPORTANCE"))
+	require.Len(t, importances, 1)
+	assert.Equal(t, "High", importances[0].(*document.StringClaim).String) //nolint:forcetypeassert
+
+	// Converting again replaces existing claims.
+	errE = ConvertAssessment("Q42", Assessment{Class: "FA", ClassRank: 7, Importance: ""}, doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	classes = doc.Get(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_CLASS"))
+	require.Len(t, classes, 1)
+	assert.Equal(t, "FA", classes[0].(*document.StringClaim).String) //nolint:forcetypeassert
+	assert.Len(t, doc.Get(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_QUALITY_RANK")), 1)
+	assert.Empty(t, doc.Get(document.GetCorePropertyID("ENGLISH_WIKIPEDIA_ARTICLE_IMPORTANCE")))
+}
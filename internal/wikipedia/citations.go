@@ -0,0 +1,94 @@
+package wikipedia
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// Citation is a source cited by an article.
+//
+// Only fields which could be extracted are set.
+type Citation struct {
+	URL  string
+	DOI  string
+	ISBN string
+}
+
+func normalizeISBN(isbn string) string {
+	isbn = strings.ToUpper(isbn)
+	isbn = strings.ReplaceAll(isbn, "-", "")
+	isbn = strings.ReplaceAll(isbn, " ", "")
+	return isbn
+}
+
+func extractCitation(cite *goquery.Selection) Citation {
+	citation := Citation{}
+	cite.Find("a").EachWithBreak(func(_ int, a *goquery.Selection) bool {
+		href, ok := a.Attr("href")
+		if !ok {
+			return true
+		}
+		parsedHref, err := url.Parse(href)
+		if err != nil {
+			return true
+		}
+		if parsedHref.Host != "" && parsedHref.Scheme == "" {
+			parsedHref.Scheme = https
+		}
+		switch {
+		case strings.HasSuffix(parsedHref.Host, "doi.org"):
+			if citation.DOI == "" {
+				citation.DOI = strings.TrimPrefix(parsedHref.Path, "/")
+			}
+		case strings.Contains(parsedHref.Path, "Special:BookSources/"):
+			if citation.ISBN == "" {
+				i := strings.LastIndex(parsedHref.Path, "/")
+				citation.ISBN = normalizeISBN(parsedHref.Path[i+1:])
+			}
+		case a.HasClass("external") && (parsedHref.Scheme == "http" || parsedHref.Scheme == https):
+			// The first external link is the cited source itself, others are
+			// generally archive links and similar.
+			if citation.URL == "" {
+				iri, errE := document.URLToIRI(parsedHref)
+				if errE == nil {
+					citation.URL = iri
+				}
+			}
+		}
+		return citation.URL == "" || citation.DOI == "" || citation.ISBN == ""
+	})
+	return citation
+}
+
+// ExtractCitations extracts citations made using cite templates from article HTML.
+//
+// It should be called on the original article HTML and not on the output of ExtractArticle,
+// because ExtractArticle removes references. Citations without an URL, DOI, or ISBN are skipped.
+// Duplicate citations are returned only once, in the order of their first occurrence.
+func ExtractCitations(input string) ([]Citation, errors.E) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(input))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	citations := []Citation{}
+	seen := map[Citation]bool{}
+	doc.Find("cite.citation").Each(func(_ int, cite *goquery.Selection) {
+		citation := extractCitation(cite)
+		if citation.URL == "" && citation.DOI == "" && citation.ISBN == "" {
+			return
+		}
+		if seen[citation] {
+			return
+		}
+		seen[citation] = true
+		citations = append(citations, citation)
+	})
+
+	return citations, nil
+}
@@ -0,0 +1,141 @@
+package wikipedia
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"gitlab.com/tozd/go/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Cleanup configures which parts of article HTML are removed before it is stored
+// in text claims, in addition to what is always removed (e.g., infoboxes and banners).
+type Cleanup struct {
+	// Remove are CSS selectors of elements to remove.
+	Remove []string `yaml:"remove,omitempty"`
+
+	// Keep are CSS selectors of elements which are not removed even if they match Remove.
+	Keep []string `yaml:"keep,omitempty"`
+
+	// Sections are (parts of) headings of sections to remove.
+	Sections []string `yaml:"sections,omitempty"`
+}
+
+// DefaultCleanup is the cleanup used for all editions.
+//
+//nolint:gochecknoglobals
+var DefaultCleanup = Cleanup{
+	Remove: []string{
+		// Navigation boxes.
+		".navbox", ".vertical-navbox", ".navbox-styles", ".navigation-not-searchable",
+		// Reference markers.
+		".mw-ref", ".mw-cite-backlink",
+		// Edit links.
+		".mw-editsection",
+	},
+	Keep: nil,
+	Sections: []string{
+		"See also", "Online sources", "External links", "References", "Footnotes", "Notes", "Further reading",
+	},
+}
+
+// Merge returns a cleanup which removes everything either of cleanups removes.
+func (c Cleanup) Merge(other Cleanup) Cleanup {
+	return Cleanup{
+		Remove:   append(slices.Clone(c.Remove), other.Remove...),
+		Keep:     append(slices.Clone(c.Keep), other.Keep...),
+		Sections: append(slices.Clone(c.Sections), other.Sections...),
+	}
+}
+
+// Validate validates the cleanup.
+func (c Cleanup) Validate() errors.E {
+	for _, selector := range append(slices.Clone(c.Remove), c.Keep...) {
+		_, err := cascadia.ParseGroup(selector)
+		if err != nil {
+			errE := errors.WithMessage(err, "invalid selector")
+			errors.Details(errE)["selector"] = selector
+			return errE
+		}
+	}
+	return nil
+}
+
+func (c Cleanup) apply(doc *goquery.Document) {
+	if len(c.Remove) > 0 {
+		remove := doc.Find(strings.Join(c.Remove, ", "))
+		if len(c.Keep) > 0 {
+			remove = remove.Not(strings.Join(c.Keep, ", "))
+		}
+		remove.Remove()
+	}
+	doc.Find("section").Each(func(_ int, section *goquery.Selection) {
+	LEVEL:
+		for _, level := range []string{"h1", "h2", "h3", "h4", "h5", "h6"} {
+			heading := section.ChildrenFiltered(level).Text()
+			for _, h := range c.Sections {
+				if strings.Contains(heading, h) {
+					section.Remove()
+					break LEVEL
+				}
+			}
+		}
+	})
+}
+
+// CleanupConfig configures cleanup of article HTML, with overrides per edition.
+type CleanupConfig struct {
+	// Cleanup is used for all editions, in addition to DefaultCleanup.
+	Cleanup `yaml:",inline"`
+
+	// Editions are cleanups used for editions (by their language code),
+	// in addition to DefaultCleanup and Cleanup.
+	Editions map[string]Cleanup `yaml:"editions,omitempty"`
+}
+
+// LoadCleanupConfig loads cleanup configuration from a YAML file.
+func LoadCleanupConfig(path string) (*CleanupConfig, errors.E) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	var config CleanupConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	errE := config.Validate()
+	if errE != nil {
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	for language, cleanup := range config.Editions {
+		errE := cleanup.Validate()
+		if errE != nil {
+			errors.Details(errE)["path"] = path
+			errors.Details(errE)["edition"] = language
+			return nil, errE
+		}
+	}
+	return &config, nil
+}
+
+// For returns the cleanup for the edition. It can be called on nil *CleanupConfig
+// in which case DefaultCleanup is returned.
+func (c *CleanupConfig) For(edition Edition) Cleanup {
+	if c == nil {
+		return DefaultCleanup
+	}
+	return DefaultCleanup.Merge(c.Cleanup).Merge(c.Editions[edition.Language])
+}
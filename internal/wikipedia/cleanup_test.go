@@ -0,0 +1,94 @@
+package wikipedia_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/peerdb/peerdb/internal/wikipedia"
+)
+
+func TestCleanup(t *testing.T) {
+	t.Parallel()
+
+	config, errE := wikipedia.LoadCleanupConfig(filepath.Join("testdata", "cleanup", "config.yaml"))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	entries, err := content.ReadDir("testdata/cleanup")
+	require.NoError(t, err)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), "_in.html") {
+			continue
+		}
+		base := strings.TrimSuffix(entry.Name(), "_in.html")
+		t.Run(base, func(t *testing.T) {
+			t.Parallel()
+
+			// Files are prefixed with the language code of the edition.
+			editions, errE := wikipedia.ParseEditions([]string{strings.SplitN(base, "_", 2)[0]}) //nolint:mnd
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			input, err := content.ReadFile(filepath.Join("testdata", "cleanup", entry.Name()))
+			require.NoError(t, err)
+			output, _, errE := wikipedia.ExtractArticle(string(input), config.For(editions[0]))
+			require.NoError(t, errE, "% -+#.1v", errE)
+			expectedFilePath := filepath.Join("testdata", "cleanup", base+"_out.html")
+			expected, err := content.ReadFile(expectedFilePath)
+			if errors.Is(err, fs.ErrNotExist) {
+				f, err := os.Create(expectedFilePath)
+				require.NoError(t, err)
+				_, _ = f.WriteString(output)
+			} else {
+				assert.Equal(t, string(expected), output)
+			}
+		})
+	}
+}
+
+func TestCleanupConfig(t *testing.T) {
+	t.Parallel()
+
+	var config *wikipedia.CleanupConfig
+	assert.Equal(t, wikipedia.DefaultCleanup, config.For(wikipedia.EnglishEdition))
+
+	config = &wikipedia.CleanupConfig{
+		Cleanup: wikipedia.Cleanup{Remove: []string{".a"}, Keep: nil, Sections: nil},
+		Editions: map[string]wikipedia.Cleanup{
+			"de": {Remove: []string{".b"}, Keep: []string{".c"}, Sections: []string{"Weblinks"}},
+		},
+	}
+	en := config.For(wikipedia.EnglishEdition)
+	assert.Equal(t, append(append([]string{}, wikipedia.DefaultCleanup.Remove...), ".a"), en.Remove)
+	assert.Equal(t, wikipedia.DefaultCleanup.Sections, en.Sections)
+	de := config.For(wikipedia.Edition{Language: "de", Name: "German"})
+	assert.Equal(t, append(append([]string{}, wikipedia.DefaultCleanup.Remove...), ".a", ".b"), de.Remove)
+	assert.Equal(t, []string{".c"}, de.Keep)
+	assert.Equal(t, append(append([]string{}, wikipedia.DefaultCleanup.Sections...), "Weblinks"), de.Sections)
+	// Defaults are not modified.
+	assert.NotContains(t, wikipedia.DefaultCleanup.Remove, ".a")
+
+	assert.NoError(t, wikipedia.DefaultCleanup.Validate())
+	assert.Error(t, wikipedia.Cleanup{Remove: []string{"div["}, Keep: nil, Sections: nil}.Validate())
+
+	path := filepath.Join(t.TempDir(), "cleanup.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("editions:\n  de:\n    keep: ['>>']\n"), 0o600))
+	_, errE := wikipedia.LoadCleanupConfig(path)
+	assert.Error(t, errE)
+	require.NoError(t, os.WriteFile(path, []byte("unknown: true\n"), 0o600))
+	_, errE = wikipedia.LoadCleanupConfig(path)
+	assert.Error(t, errE)
+	require.NoError(t, os.WriteFile(path, []byte(""), 0o600))
+	config, errE = wikipedia.LoadCleanupConfig(path)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, wikipedia.DefaultCleanup, config.For(wikipedia.EnglishEdition))
+}
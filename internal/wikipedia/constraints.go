@@ -0,0 +1,451 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// Wikidata entities used to describe property constraints.
+// See: https://www.wikidata.org/wiki/Help:Property_constraints_portal
+const (
+	WikidataPropertyConstraint      = "P2302"
+	WikidataValueTypeConstraint     = "Q21510865"
+	WikidataFormatConstraint        = "Q21502404"
+	WikidataSingleValueConstraint   = "Q19474404"
+	wikidataClass                   = "P2308"
+	wikidataRelation                = "P2309"
+	wikidataFormatAsRegex           = "P1793"
+	wikidataInstanceOf              = "P31"
+	wikidataSubclassOf              = "P279"
+	wikidataInstanceOfRelation      = "Q21503252"
+	wikidataSubclassOfRelation      = "Q21514624"
+	wikidataInstanceOrSubclassOfRel = "Q30208840"
+)
+
+// How many levels of "subclass of" claims are followed when checking value type constraints.
+const maxSubclassDepth = 10
+
+// ValueTypeConstraint requires that values of relation claims are instances
+// or subclasses (depending on the relation) of one of the classes.
+type ValueTypeConstraint struct {
+	// Classes are Wikidata IDs of classes.
+	Classes []string `json:"classes"`
+	// Relation is the Wikidata ID of the relation: instance of, subclass of,
+	// or instance or subclass of. Instance of is used when empty.
+	Relation string `json:"relation,omitempty"`
+}
+
+// PropertyConstraints are supported constraints of a Wikidata property.
+type PropertyConstraints struct {
+	// Property is the Wikidata ID of the property.
+	Property   string                `json:"property"`
+	ValueTypes []ValueTypeConstraint `json:"valueTypes,omitempty"`
+	// Formats are regular expressions which string values have to fully match.
+	Formats     []string `json:"formats,omitempty"`
+	SingleValue bool     `json:"singleValue,omitempty"`
+}
+
+// ExtractPropertyConstraints returns supported constraints of the Wikidata property entity.
+// It returns nil if the entity is not a property or if it does not have any supported constraint.
+// Deprecated constraints are ignored.
+func ExtractPropertyConstraints(entity mediawiki.Entity) *PropertyConstraints {
+	if entity.Type != mediawiki.Property {
+		return nil
+	}
+
+	constraints := PropertyConstraints{
+		Property:    entity.ID,
+		ValueTypes:  nil,
+		Formats:     nil,
+		SingleValue: false,
+	}
+	found := false
+	for _, statement := range entity.Claims[WikidataPropertyConstraint] {
+		if statement.Rank == mediawiki.Deprecated {
+			continue
+		}
+		switch snakEntityID(statement.MainSnak) {
+		case WikidataValueTypeConstraint:
+			classes := []string{}
+			for _, snak := range statement.Qualifiers[wikidataClass] {
+				if id := snakEntityID(snak); id != "" {
+					classes = append(classes, id)
+				}
+			}
+			if len(classes) == 0 {
+				continue
+			}
+			relation := ""
+			for _, snak := range statement.Qualifiers[wikidataRelation] {
+				relation = snakEntityID(snak)
+			}
+			constraints.ValueTypes = append(constraints.ValueTypes, ValueTypeConstraint{
+				Classes:  classes,
+				Relation: relation,
+			})
+			found = true
+		case WikidataFormatConstraint:
+			for _, snak := range statement.Qualifiers[wikidataFormatAsRegex] {
+				if snak.SnakType != mediawiki.Value || snak.DataValue == nil {
+					continue
+				}
+				if value, ok := snak.DataValue.Value.(mediawiki.StringValue); ok && value != "" {
+					constraints.Formats = append(constraints.Formats, string(value))
+					found = true
+				}
+			}
+		case WikidataSingleValueConstraint:
+			constraints.SingleValue = true
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return &constraints
+}
+
+// snakEntityID returns the Wikidata ID of the snak's value or an empty string
+// if the snak does not have an entity value.
+func snakEntityID(snak mediawiki.Snak) string {
+	if snak.SnakType != mediawiki.Value || snak.DataValue == nil {
+		return ""
+	}
+	value, ok := snak.DataValue.Value.(mediawiki.WikiBaseEntityIDValue)
+	if !ok {
+		return ""
+	}
+	return value.ID
+}
+
+// ConstraintViolation is a claim violating a constraint of its property.
+type ConstraintViolation struct {
+	Claim identifier.Identifier
+	// Constraint is the Wikidata ID of the constraint type.
+	Constraint string
+}
+
+type compiledConstraints struct {
+	valueTypes  []ValueTypeConstraint
+	formats     []*regexp.Regexp
+	singleValue bool
+}
+
+// ConstraintChecker checks claims of documents against constraints of their properties.
+type ConstraintChecker struct {
+	properties  map[identifier.Identifier]*compiledConstraints
+	getDocument func(ctx context.Context, id identifier.Identifier) (*document.D, errors.E)
+}
+
+// NewConstraintChecker returns a new ConstraintChecker for constraints, fetching documents
+// (to check value types) from the store, using the cache. Formats which cannot be compiled
+// as Go regular expressions are logged and ignored.
+func NewConstraintChecker(
+	logger zerolog.Logger,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	cache *es.Cache, constraints []PropertyConstraints,
+) *ConstraintChecker {
+	return newConstraintChecker(logger, constraints, func(ctx context.Context, id identifier.Identifier) (*document.D, errors.E) {
+		if doc, ok := cache.Get(id); ok {
+			return doc, nil
+		}
+		doc, _, errE := getDocumentFromByID(ctx, s, id)
+		if errE != nil {
+			errors.Details(errE)["doc"] = id.String()
+			return nil, errE
+		}
+		cache.Add(id, doc)
+		return doc, nil
+	})
+}
+
+func newConstraintChecker(
+	logger zerolog.Logger, constraints []PropertyConstraints,
+	getDocument func(ctx context.Context, id identifier.Identifier) (*document.D, errors.E),
+) *ConstraintChecker {
+	properties := map[identifier.Identifier]*compiledConstraints{}
+	for _, c := range constraints {
+		compiled := &compiledConstraints{
+			valueTypes:  c.ValueTypes,
+			formats:     []*regexp.Regexp{},
+			singleValue: c.SingleValue,
+		}
+		for _, format := range c.Formats {
+			// Format has to match the whole value.
+			re, err := regexp.Compile(`^(?:` + format + `)$`)
+			if err != nil {
+				logger.Warn().Str("entity", c.Property).Str("format", format).Err(err).Msg("unsupported format constraint")
+				continue
+			}
+			compiled.formats = append(compiled.formats, re)
+		}
+		properties[GetWikidataDocumentID(c.Property)] = compiled
+	}
+	return &ConstraintChecker{
+		properties:  properties,
+		getDocument: getDocument,
+	}
+}
+
+// Check returns violations of constraints by (top-level) claims of the document.
+// Claims with no confidence (from deprecated statements) are not checked.
+func (c *ConstraintChecker) Check(ctx context.Context, doc *document.D) ([]ConstraintViolation, errors.E) {
+	violations := []ConstraintViolation{}
+	// Number of checked claims per property, for single value constraints.
+	counts := map[identifier.Identifier][]identifier.Identifier{}
+	// Properties in order of appearance, for deterministic order of violations.
+	props := []identifier.Identifier{}
+
+	// Reference claims of external identifier properties are IRIs resolved from
+	// identifiers and are not values on their own, so we do not check them.
+	identifierProps := map[identifier.Identifier]bool{}
+	for _, claim := range doc.AllClaims() {
+		if cl, ok := claim.(*document.IdentifierClaim); ok && cl.Prop.ID != nil {
+			identifierProps[*cl.Prop.ID] = true
+		}
+	}
+
+	for _, claim := range doc.AllClaims() {
+		if claim.GetConfidence() <= document.NoConfidence {
+			continue
+		}
+
+		var prop *identifier.Identifier
+		var value *string
+		var to *identifier.Identifier
+		switch cl := claim.(type) {
+		case *document.IdentifierClaim:
+			prop, value = cl.Prop.ID, &cl.Value
+		case *document.StringClaim:
+			prop, value = cl.Prop.ID, &cl.String
+		case *document.ReferenceClaim:
+			if cl.Prop.ID != nil && identifierProps[*cl.Prop.ID] {
+				continue
+			}
+			prop, value = cl.Prop.ID, &cl.IRI
+		case *document.RelationClaim:
+			prop, to = cl.Prop.ID, cl.To.ID
+		case *document.TextClaim:
+			prop = cl.Prop.ID
+		case *document.AmountClaim:
+			prop = cl.Prop.ID
+		case *document.AmountRangeClaim:
+			prop = cl.Prop.ID
+		case *document.FileClaim:
+			prop = cl.Prop.ID
+		case *document.TimeClaim:
+			prop = cl.Prop.ID
+		case *document.TimeRangeClaim:
+			prop = cl.Prop.ID
+		case *document.NoValueClaim, *document.UnknownValueClaim:
+			// These claims do not have a value to check and do not count as values.
+			continue
+		}
+		if prop == nil {
+			continue
+		}
+		constraints, ok := c.properties[*prop]
+		if !ok {
+			continue
+		}
+
+		if _, ok := counts[*prop]; !ok {
+			props = append(props, *prop)
+		}
+		counts[*prop] = append(counts[*prop], claim.GetID())
+
+		if value != nil {
+			for _, format := range constraints.formats {
+				if !format.MatchString(*value) {
+					violations = append(violations, ConstraintViolation{Claim: claim.GetID(), Constraint: WikidataFormatConstraint})
+					break
+				}
+			}
+		}
+
+		if to != nil {
+			for _, valueType := range constraints.valueTypes {
+				ok, errE := c.checkValueType(ctx, *to, valueType)
+				if errE != nil {
+					errors.Details(errE)["claim"] = claim.GetID().String()
+					return nil, errE
+				}
+				if !ok {
+					violations = append(violations, ConstraintViolation{Claim: claim.GetID(), Constraint: WikidataValueTypeConstraint})
+					break
+				}
+			}
+		}
+	}
+
+	for _, prop := range props {
+		if !c.properties[prop].singleValue || len(counts[prop]) <= 1 {
+			continue
+		}
+		for _, id := range counts[prop] {
+			violations = append(violations, ConstraintViolation{Claim: id, Constraint: WikidataSingleValueConstraint})
+		}
+	}
+
+	return violations, nil
+}
+
+// checkValueType returns true if the document is an instance or a subclass (depending on
+// the relation) of any of the constraint's classes, directly or through "subclass of" claims.
+// Values which do not exist are not checked (they are reported by integrity checks).
+func (c *ConstraintChecker) checkValueType(ctx context.Context, id identifier.Identifier, constraint ValueTypeConstraint) (bool, errors.E) {
+	classes := map[identifier.Identifier]bool{}
+	for _, class := range constraint.Classes {
+		classes[GetWikidataDocumentID(class)] = true
+	}
+
+	doc, errE := c.getDocument(ctx, id)
+	if errors.Is(errE, ErrNotFound) {
+		return true, nil
+	} else if errE != nil {
+		return false, errE
+	}
+
+	var current []identifier.Identifier
+	switch constraint.Relation {
+	case wikidataSubclassOfRelation:
+		current = relationTargets(doc, wikidataSubclassOf)
+	case wikidataInstanceOrSubclassOfRel:
+		current = append(relationTargets(doc, wikidataInstanceOf), relationTargets(doc, wikidataSubclassOf)...)
+	default:
+		current = relationTargets(doc, wikidataInstanceOf)
+	}
+
+	visited := map[identifier.Identifier]bool{}
+	for depth := 0; len(current) > 0 && depth < maxSubclassDepth; depth++ {
+		next := []identifier.Identifier{}
+		for _, class := range current {
+			if classes[class] {
+				return true, nil
+			}
+			if visited[class] {
+				continue
+			}
+			visited[class] = true
+
+			classDoc, errE := c.getDocument(ctx, class)
+			if errors.Is(errE, ErrNotFound) {
+				continue
+			} else if errE != nil {
+				return false, errE
+			}
+			next = append(next, relationTargets(classDoc, wikidataSubclassOf)...)
+		}
+		current = next
+	}
+
+	return false, nil
+}
+
+// relationTargets returns IDs of documents the document's relation claims with the Wikidata property point to.
+func relationTargets(doc *document.D, prop string) []identifier.Identifier {
+	result := []identifier.Identifier{}
+	for _, claim := range doc.Get(GetWikidataDocumentID(prop)) {
+		if rel, ok := claim.(*document.RelationClaim); ok && rel.To.ID != nil && rel.GetConfidence() > document.NoConfidence {
+			result = append(result, *rel.To.ID)
+		}
+	}
+	return result
+}
+
+// SetConstraintViolations records constraint violations on the document, replacing any
+// previously recorded violations. It returns true if the document changed.
+//
+// Every violation is recorded as a WIKIDATA_CONSTRAINT_VIOLATION meta claim of the violating
+// claim pointing to the constraint type. Every violated constraint type is recorded also as
+// a top-level WIKIDATA_CONSTRAINT_VIOLATION claim so that documents with violations can
+// be found using search.
+func SetConstraintViolations(doc *document.D, violations []ConstraintViolation) (bool, errors.E) {
+	prop := document.GetCorePropertyID("WIKIDATA_CONSTRAINT_VIOLATION")
+
+	type recorded struct {
+		Parent *identifier.Identifier
+		Claim  *document.RelationClaim
+	}
+	newClaims := []recorded{}
+	constraints := []string{}
+	for _, violation := range violations {
+		parent := violation.Claim
+		newClaims = append(newClaims, recorded{
+			Parent: &parent,
+			Claim:  constraintViolationClaim(document.GetID(NameSpaceWikidata, "CONSTRAINT_VIOLATION", violation.Claim.String(), violation.Constraint), violation.Constraint),
+		})
+		if !slices.Contains(constraints, violation.Constraint) {
+			constraints = append(constraints, violation.Constraint)
+		}
+	}
+	for _, constraint := range constraints {
+		newClaims = append(newClaims, recorded{
+			Parent: nil,
+			Claim:  constraintViolationClaim(document.GetID(NameSpaceWikidata, doc.ID.String(), "CONSTRAINT_VIOLATION", constraint), constraint),
+		})
+	}
+
+	oldIDs := []identifier.Identifier{}
+	for _, claim := range doc.Remove(prop) {
+		oldIDs = append(oldIDs, claim.GetID())
+	}
+	for _, claim := range doc.AllClaims() {
+		for _, meta := range claim.Remove(prop) {
+			oldIDs = append(oldIDs, meta.GetID())
+		}
+	}
+
+	newIDs := []identifier.Identifier{}
+	for _, c := range newClaims {
+		var container document.ClaimsContainer = doc
+		if c.Parent != nil {
+			container = doc.GetByID(*c.Parent)
+			if container == nil {
+				errE := errors.New("claim not found")
+				errors.Details(errE)["claim"] = c.Parent.String()
+				return false, errE
+			}
+		}
+		errE := container.Add(c.Claim)
+		if errE != nil {
+			return false, errE
+		}
+		newIDs = append(newIDs, c.Claim.ID)
+	}
+
+	slices.SortFunc(oldIDs, compareIdentifiers)
+	slices.SortFunc(newIDs, compareIdentifiers)
+	return !slices.Equal(oldIDs, newIDs), nil
+}
+
+func compareIdentifiers(a, b identifier.Identifier) int {
+	return slices.Compare(a[:], b[:])
+}
+
+func constraintViolationClaim(id identifier.Identifier, constraint string) *document.RelationClaim {
+	to := GetWikidataDocumentID(constraint)
+	return &document.RelationClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         id,
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("WIKIDATA_CONSTRAINT_VIOLATION"),
+		To: document.Reference{
+			ID: &to,
+		},
+	}
+}
@@ -0,0 +1,180 @@
+package wikipedia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func entitySnak(id string) mediawiki.Snak {
+	return mediawiki.Snak{ //nolint:exhaustruct
+		SnakType:  mediawiki.Value,
+		DataValue: &mediawiki.DataValue{Value: mediawiki.WikiBaseEntityIDValue{Type: mediawiki.ItemType, ID: id}},
+	}
+}
+
+func TestExtractPropertyConstraints(t *testing.T) {
+	t.Parallel()
+
+	entity := mediawiki.Entity{ //nolint:exhaustruct
+		ID:   "P1",
+		Type: mediawiki.Property,
+		Claims: map[string][]mediawiki.Statement{
+			WikidataPropertyConstraint: {
+				{ //nolint:exhaustruct
+					MainSnak: entitySnak(WikidataValueTypeConstraint),
+					Rank:     mediawiki.Normal,
+					Qualifiers: map[string][]mediawiki.Snak{
+						wikidataClass:    {entitySnak("Q5"), entitySnak("Q6")},
+						wikidataRelation: {entitySnak(wikidataInstanceOrSubclassOfRel)},
+					},
+				},
+				{ //nolint:exhaustruct
+					MainSnak: entitySnak(WikidataFormatConstraint),
+					Rank:     mediawiki.Normal,
+					Qualifiers: map[string][]mediawiki.Snak{
+						wikidataFormatAsRegex: {{ //nolint:exhaustruct
+							SnakType:  mediawiki.Value,
+							DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue(`\d+`)},
+						}},
+					},
+				},
+				{ //nolint:exhaustruct
+					MainSnak: entitySnak(WikidataSingleValueConstraint),
+					Rank:     mediawiki.Deprecated,
+				},
+				{ //nolint:exhaustruct
+					// Unsupported constraint.
+					MainSnak: entitySnak("Q21503247"),
+					Rank:     mediawiki.Normal,
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, &PropertyConstraints{
+		Property: "P1",
+		ValueTypes: []ValueTypeConstraint{
+			{Classes: []string{"Q5", "Q6"}, Relation: wikidataInstanceOrSubclassOfRel},
+		},
+		Formats:     []string{`\d+`},
+		SingleValue: false,
+	}, ExtractPropertyConstraints(entity))
+
+	entity.Claims = nil
+	assert.Nil(t, ExtractPropertyConstraints(entity))
+}
+
+func relationClaim(prop, to string, confidence document.Confidence) *document.RelationClaim {
+	propID := GetWikidataDocumentID(prop)
+	toID := GetWikidataDocumentID(to)
+	return &document.RelationClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: confidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &propID},                                  //nolint:exhaustruct
+		To:        document.Reference{ID: &toID},                                    //nolint:exhaustruct
+	}
+}
+
+func TestConstraintChecker(t *testing.T) {
+	t.Parallel()
+
+	// Q2 is an instance of Q3 which is a subclass of Q5. Q4 is an instance of Q6.
+	docs := map[identifier.Identifier]*document.D{}
+	for _, d := range []struct {
+		ID    string
+		Prop  string
+		Class string
+	}{
+		{"Q2", wikidataInstanceOf, "Q3"},
+		{"Q3", wikidataSubclassOf, "Q5"},
+		{"Q4", wikidataInstanceOf, "Q6"},
+	} {
+		doc := &document.D{ //nolint:exhaustruct
+			CoreDocument: document.CoreDocument{ID: GetWikidataDocumentID(d.ID), Score: document.LowConfidence}, //nolint:exhaustruct
+		}
+		errE := doc.Add(relationClaim(d.Prop, d.Class, document.HighConfidence))
+		require.NoError(t, errE, "% -+#.1v", errE)
+		docs[doc.ID] = doc
+	}
+	getDocument := func(_ context.Context, id identifier.Identifier) (*document.D, errors.E) {
+		doc, ok := docs[id]
+		if !ok {
+			return nil, errors.WithStack(ErrNotFound)
+		}
+		return doc, nil
+	}
+
+	checker := newConstraintChecker(zerolog.Nop(), []PropertyConstraints{
+		{Property: "P1", ValueTypes: []ValueTypeConstraint{{Classes: []string{"Q5"}, Relation: ""}}, Formats: nil, SingleValue: true},
+		// Unsupported regular expression is ignored.
+		{Property: "P7", ValueTypes: nil, Formats: []string{`[A-Z]\d+`, `(?=x)`}, SingleValue: false},
+	}, getDocument)
+
+	valid := relationClaim("P1", "Q2", document.HighConfidence)
+	invalid := relationClaim("P1", "Q4", document.MediumConfidence)
+	deprecated := relationClaim("P1", "Q4", document.NoConfidence)
+	p7 := GetWikidataDocumentID("P7")
+	validID := &document.IdentifierClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &p7},                                                   //nolint:exhaustruct
+		Value:     "A123",
+	}
+	invalidID := &document.IdentifierClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &p7},                                                   //nolint:exhaustruct
+		Value:     "A123x",
+	}
+	// IRIs resolved from identifiers are not checked.
+	resolvedID := &document.ReferenceClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+		Prop:      document.Reference{ID: &p7},                                                   //nolint:exhaustruct
+		IRI:       "https://example.com/A123",
+	}
+
+	doc := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+	for _, claim := range []document.Claim{valid, invalid, deprecated, validID, invalidID, resolvedID} {
+		errE := doc.Add(claim)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	violations, errE := checker.Check(context.Background(), doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []ConstraintViolation{
+		{Claim: invalidID.ID, Constraint: WikidataFormatConstraint},
+		{Claim: invalid.ID, Constraint: WikidataValueTypeConstraint},
+		{Claim: valid.ID, Constraint: WikidataSingleValueConstraint},
+		{Claim: invalid.ID, Constraint: WikidataSingleValueConstraint},
+	}, violations)
+
+	changed, errE := SetConstraintViolations(doc, violations)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, changed)
+
+	prop := document.GetCorePropertyID("WIKIDATA_CONSTRAINT_VIOLATION")
+	assert.Len(t, doc.Get(prop), 3)
+	assert.Len(t, doc.GetByID(invalid.ID).Get(prop), 2)
+	assert.Len(t, doc.GetByID(valid.ID).Get(prop), 1)
+	assert.Empty(t, doc.GetByID(validID.ID).Get(prop))
+
+	// Recording the same violations again does not change the document.
+	changed, errE = SetConstraintViolations(doc, violations)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.False(t, changed)
+
+	// Violations are removed when they are fixed.
+	changed, errE = SetConstraintViolations(doc, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, changed)
+	assert.Empty(t, doc.Get(prop))
+	assert.Empty(t, doc.GetByID(invalid.ID).Get(prop))
+}
@@ -0,0 +1,345 @@
+package wikipedia
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// DefaultWaybackAPI is the URL of the Wayback Machine availability API.
+// See: https://archive.org/help/wayback_api.php
+const DefaultWaybackAPI = "https://archive.org/wayback/available"
+
+//nolint:gochecknoglobals
+var (
+	// NameSpaceDeadLinks is the namespace of IDs of claims recording results of link checks.
+	NameSpaceDeadLinks = uuid.MustParse("4a3c1a0e-5d36-4a5f-9a2e-0c5f8f2b7d61")
+
+	// Hosts whose links are not checked because they are sources of imported data themselves.
+	deadLinksSkippedHosts = []string{"wikipedia.org", "wikidata.org", "wikimedia.org"}
+)
+
+// LinkCheck is the result of checking liveness of a link.
+type LinkCheck struct {
+	// Status is the HTTP status code of the response, or zero if no response was received.
+	Status int
+	// Dead is true if the link is known to be dead: the server responded with 404 or 410
+	// HTTP status code or the host does not exist. Other failures are inconclusive.
+	Dead bool
+}
+
+// Conclusive returns true if the check determined whether the link is alive or dead.
+func (c LinkCheck) Conclusive() bool {
+	return c.Status != 0 || c.Dead
+}
+
+// DeadLinkChecker checks liveness of links of reference claims of documents and records
+// results as meta claims.
+//
+// Outbound requests should be rate limited by the HTTP client. Every link is checked
+// at most once per checker.
+type DeadLinkChecker struct {
+	// Client is used for liveness checks. It should not retry requests.
+	Client *http.Client
+	// ArchiveClient is used to find archived snapshots of dead links. If nil, dead links are not rewritten.
+	ArchiveClient *http.Client
+	// WaybackAPI is the URL of the Wayback Machine availability API. If empty, DefaultWaybackAPI is used.
+	WaybackAPI string
+	// Recheck is the duration after which a link is checked again.
+	Recheck time.Duration
+
+	checks sync.Map
+}
+
+// CheckLink checks liveness of the link using a HEAD request, or a GET request if the server
+// does not support HEAD requests.
+func (c *DeadLinkChecker) CheckLink(ctx context.Context, link string) LinkCheck {
+	if check, ok := c.checks.Load(link); ok {
+		return check.(LinkCheck) //nolint:forcetypeassert,errcheck
+	}
+
+	check := c.checkLink(ctx, link, http.MethodHead)
+	if check.Status == http.StatusMethodNotAllowed || check.Status == http.StatusNotImplemented {
+		check = c.checkLink(ctx, link, http.MethodGet)
+	}
+
+	// We do not remember inconclusive checks because they might be caused by context cancellation.
+	if check.Conclusive() {
+		c.checks.Store(link, check)
+	}
+	return check
+}
+
+func (c *DeadLinkChecker) checkLink(ctx context.Context, link, method string) LinkCheck {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return LinkCheck{Status: 0, Dead: false}
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		var dnsError *net.DNSError
+		if errors.As(err, &dnsError) && dnsError.IsNotFound {
+			return LinkCheck{Status: 0, Dead: true}
+		}
+		return LinkCheck{Status: 0, Dead: false}
+	}
+	resp.Body.Close()
+	return LinkCheck{
+		Status: resp.StatusCode,
+		Dead:   resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone,
+	}
+}
+
+type waybackAvailability struct {
+	URL               string `json:"url"`
+	ArchivedSnapshots struct {
+		Closest *struct {
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+			Timestamp string `json:"timestamp"`
+			Status    string `json:"status"`
+		} `json:"closest,omitempty"`
+	} `json:"archived_snapshots"`
+}
+
+// ArchivedLink returns the URL of the closest successful Wayback Machine snapshot of the link,
+// or an empty string if there is none.
+func (c *DeadLinkChecker) ArchivedLink(ctx context.Context, link string) (string, errors.E) {
+	api := c.WaybackAPI
+	if api == "" {
+		api = DefaultWaybackAPI
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api+"?"+url.Values{"url": {link}}.Encode(), nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	resp, err := c.ArchiveClient.Do(req)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		errE := errors.New("bad response status")
+		errors.Details(errE)["code"] = resp.StatusCode
+		return "", errE
+	}
+
+	var availability waybackAvailability
+	errE := x.DecodeJSON(resp.Body, &availability)
+	if errE != nil {
+		return "", errE
+	}
+	closest := availability.ArchivedSnapshots.Closest
+	if closest == nil || !closest.Available || closest.URL == "" || closest.Status != "200" {
+		return "", nil
+	}
+	u, err := url.Parse(closest.URL)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	// Snapshot URLs are sometimes returned with http scheme.
+	u.Scheme = https
+	return document.URLToIRI(u)
+}
+
+// checkableLink returns true if the IRI is a http(s) link which should be checked.
+func checkableLink(iri string) bool {
+	u, err := url.Parse(iri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != https) || u.Host == "" {
+		return false
+	}
+	host := u.Hostname()
+	for _, skipped := range deadLinksSkippedHosts {
+		if host == skipped || strings.HasSuffix(host, "."+skipped) {
+			return false
+		}
+	}
+	return true
+}
+
+func lastChecked(claim document.Claim) (time.Time, bool) {
+	for _, c := range claim.Get(document.GetCorePropertyID("LINK_LAST_CHECKED")) {
+		if t, ok := c.(*document.TimeClaim); ok {
+			return time.Time(t.Timestamp), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func deadLinkLabelClaim(id identifier.Identifier) *document.RelationClaim {
+	return &document.RelationClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         id,
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("LABEL"),
+		To:   document.GetCorePropertyReference("DEAD_LINK"),
+	}
+}
+
+// recordLinkCheck replaces meta claims of the claim recording a previous check with those of the new check.
+func recordLinkCheck(claim *document.ReferenceClaim, check LinkCheck, checked time.Time) errors.E {
+	claimID := claim.ID.String()
+	for _, id := range []identifier.Identifier{
+		document.GetID(NameSpaceDeadLinks, claimID, "LINK_LAST_CHECKED", 0),
+		document.GetID(NameSpaceDeadLinks, claimID, "LINK_HTTP_STATUS", 0),
+		document.GetID(NameSpaceDeadLinks, claimID, "LABEL", 0, "DEAD_LINK", 0),
+	} {
+		claim.RemoveByID(id)
+	}
+
+	errE := claim.Add(&document.TimeClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         document.GetID(NameSpaceDeadLinks, claimID, "LINK_LAST_CHECKED", 0),
+			Confidence: document.HighConfidence,
+		},
+		Prop:      document.GetCorePropertyReference("LINK_LAST_CHECKED"),
+		Timestamp: document.Timestamp(checked),
+		Precision: document.TimePrecisionSecond,
+	})
+	if errE != nil {
+		return errE
+	}
+	if check.Status != 0 {
+		errE = claim.Add(&document.AmountClaim{
+			CoreClaim: document.CoreClaim{
+				ID:         document.GetID(NameSpaceDeadLinks, claimID, "LINK_HTTP_STATUS", 0),
+				Confidence: document.HighConfidence,
+			},
+			Prop:   document.GetCorePropertyReference("LINK_HTTP_STATUS"),
+			Amount: float64(check.Status),
+			Unit:   document.AmountUnitNone,
+		})
+		if errE != nil {
+			return errE
+		}
+	}
+	if check.Dead {
+		return claim.Add(deadLinkLabelClaim(document.GetID(NameSpaceDeadLinks, claimID, "LABEL", 0, "DEAD_LINK", 0)))
+	}
+	return nil
+}
+
+// CheckDocument checks links of (top-level) reference claims of the document which have not been
+// checked within Recheck duration and records results. It returns true if the document changed.
+//
+// The time of the last conclusive check and the HTTP status code are recorded as LINK_LAST_CHECKED
+// and LINK_HTTP_STATUS meta claims of the reference claim. Dead links are labeled with DEAD_LINK
+// meta claims and, if any link of the document is dead, the document is labeled with DEAD_LINK as well,
+// so that documents with dead links can be found using search.
+//
+// If ArchiveClient is set, dead links with an archived snapshot are rewritten to the snapshot and the
+// original link is recorded as an ORIGINAL_URL meta claim. Such claims are not checked anymore and their
+// other meta claims describe the original link.
+//
+// Reference claims of external identifier properties (IRIs resolved from identifiers), claims with no confidence,
+// and links to Wikimedia projects are not checked.
+func (c *DeadLinkChecker) CheckDocument(ctx context.Context, doc *document.D) (bool, errors.E) {
+	if doc.Claims == nil {
+		return false, nil
+	}
+
+	identifierProps := map[identifier.Identifier]bool{}
+	for _, claim := range doc.Claims.Identifier {
+		if claim.Prop.ID != nil {
+			identifierProps[*claim.Prop.ID] = true
+		}
+	}
+
+	changed := false
+	dead := false
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := range doc.Claims.Reference {
+		claim := &doc.Claims.Reference[i]
+		if ctx.Err() != nil {
+			return false, errors.WithStack(ctx.Err())
+		}
+
+		if len(claim.Get(document.GetCorePropertyID("ORIGINAL_URL"))) > 0 {
+			// Rewritten to an archived snapshot, the original link was dead.
+			dead = true
+			continue
+		}
+		if claim.GetConfidence() <= document.NoConfidence || (claim.Prop.ID != nil && identifierProps[*claim.Prop.ID]) || !checkableLink(claim.IRI) {
+			continue
+		}
+		if checked, ok := lastChecked(claim); ok && now.Sub(checked) < c.Recheck {
+			if slices.ContainsFunc(claim.Get(document.GetCorePropertyID("LABEL")), isDeadLinkLabel) {
+				dead = true
+			}
+			continue
+		}
+
+		check := c.CheckLink(ctx, claim.IRI)
+		if !check.Conclusive() {
+			continue
+		}
+		errE := recordLinkCheck(claim, check, now)
+		if errE != nil {
+			errors.Details(errE)["claim"] = claim.ID.String()
+			return false, errE
+		}
+		changed = true
+		if !check.Dead {
+			continue
+		}
+		dead = true
+
+		if c.ArchiveClient == nil {
+			continue
+		}
+		archived, errE := c.ArchivedLink(ctx, claim.IRI)
+		if errE != nil {
+			errors.Details(errE)["link"] = claim.IRI
+			return false, errE
+		}
+		if archived == "" {
+			continue
+		}
+		errE = claim.Add(&document.ReferenceClaim{
+			CoreClaim: document.CoreClaim{
+				ID:         document.GetID(NameSpaceDeadLinks, claim.ID.String(), "ORIGINAL_URL", 0),
+				Confidence: document.HighConfidence,
+			},
+			Prop: document.GetCorePropertyReference("ORIGINAL_URL"),
+			IRI:  claim.IRI,
+		})
+		if errE != nil {
+			errors.Details(errE)["claim"] = claim.ID.String()
+			return false, errE
+		}
+		claim.IRI = archived
+	}
+
+	labelID := document.GetID(NameSpaceDeadLinks, doc.ID.String(), "LABEL", 0, "DEAD_LINK", 0)
+	hasLabel := doc.GetByID(labelID) != nil
+	if dead && !hasLabel {
+		errE := doc.Add(deadLinkLabelClaim(labelID))
+		if errE != nil {
+			return false, errE
+		}
+		changed = true
+	} else if !dead && hasLabel {
+		doc.RemoveByID(labelID)
+		changed = true
+	}
+
+	return changed, nil
+}
+
+func isDeadLinkLabel(claim document.Claim) bool {
+	c, ok := claim.(*document.RelationClaim)
+	return ok && c.To.ID != nil && *c.To.ID == document.GetCorePropertyID("DEAD_LINK")
+}
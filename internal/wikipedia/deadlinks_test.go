@@ -0,0 +1,141 @@
+package wikipedia
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func referenceClaim(iri string) document.ReferenceClaim {
+	return document.ReferenceClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("ENGLISH_WIKIPEDIA_CITATION_URL"),
+		IRI:  iri,
+	}
+}
+
+func TestDeadLinkChecker(t *testing.T) {
+	t.Parallel()
+
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		switch req.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/nohead":
+			if req.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			} else {
+				w.WriteHeader(http.StatusOK)
+			}
+		case "/wayback":
+			w.Header().Set("Content-Type", "application/json")
+			if req.URL.Query().Get("url") == "http://"+req.Host+"/gone" {
+				_, _ = w.Write([]byte(`{"url":"example.com/gone","archived_snapshots":{"closest":{"status":"200","available":true,` +
+					`"url":"http://web.archive.org/web/20200101000000/http://example.com/gone","timestamp":"20200101000000"}}}`))
+			} else {
+				_, _ = w.Write([]byte(`{"url":"example.com/missing","archived_snapshots":{}}`))
+			}
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	checker := &DeadLinkChecker{ //nolint:exhaustruct
+		Client:        ts.Client(),
+		ArchiveClient: ts.Client(),
+		WaybackAPI:    ts.URL + "/wayback",
+		Recheck:       time.Hour,
+	}
+
+	ok := referenceClaim(ts.URL + "/ok")
+	gone := referenceClaim(ts.URL + "/gone")
+	missing := referenceClaim(ts.URL + "/missing")
+	noHead := referenceClaim(ts.URL + "/nohead")
+	failing := referenceClaim(ts.URL + "/error")
+	wikipedia := referenceClaim("https://en.wikipedia.org/wiki/Main_Page")
+	doc := document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: document.LowConfidence,
+		},
+		Claims: &document.ClaimTypes{ //nolint:exhaustruct
+			Reference: document.ReferenceClaims{ok, gone, missing, noHead, failing, wikipedia},
+		},
+	}
+
+	changed, errE := checker.CheckDocument(context.Background(), &doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, changed)
+
+	status := func(claim document.Claim) float64 {
+		amounts := claim.Get(document.GetCorePropertyID("LINK_HTTP_STATUS"))
+		require.Len(t, amounts, 1)
+		return amounts[0].(*document.AmountClaim).Amount //nolint:forcetypeassert,errcheck
+	}
+	dead := func(claim document.Claim) bool {
+		return len(claim.Get(document.GetCorePropertyID("LABEL"))) > 0
+	}
+
+	claims := doc.Claims.Reference
+	assert.InDelta(t, http.StatusOK, status(&claims[0]), 0)
+	assert.False(t, dead(&claims[0]))
+	assert.InDelta(t, http.StatusGone, status(&claims[1]), 0)
+	assert.True(t, dead(&claims[1]))
+	assert.Equal(t, "https://web.archive.org/web/20200101000000/http://example.com/gone", claims[1].IRI)
+	original := claims[1].Get(document.GetCorePropertyID("ORIGINAL_URL"))
+	require.Len(t, original, 1)
+	assert.Equal(t, ts.URL+"/gone", original[0].(*document.ReferenceClaim).IRI) //nolint:forcetypeassert,errcheck
+	assert.InDelta(t, http.StatusNotFound, status(&claims[2]), 0)
+	assert.True(t, dead(&claims[2]))
+	assert.Equal(t, ts.URL+"/missing", claims[2].IRI)
+	assert.InDelta(t, http.StatusOK, status(&claims[3]), 0)
+	assert.False(t, dead(&claims[3]))
+	// The server error is reported as a status, but it does not mean that the link is dead.
+	assert.InDelta(t, http.StatusInternalServerError, status(&claims[4]), 0)
+	assert.False(t, dead(&claims[4]))
+	assert.Empty(t, claims[5].Get(document.GetCorePropertyID("LINK_LAST_CHECKED")))
+
+	labels := doc.Get(document.GetCorePropertyID("LABEL"))
+	require.Len(t, labels, 1)
+	assert.Equal(t, document.GetCorePropertyID("DEAD_LINK"), *labels[0].(*document.RelationClaim).To.ID) //nolint:forcetypeassert,errcheck
+
+	assert.Equal(t, []string{
+		"HEAD /ok", "HEAD /gone", "GET /wayback", "HEAD /missing", "GET /wayback", "HEAD /nohead", "GET /nohead", "HEAD /error",
+	}, requests)
+
+	// Links have been checked recently, so they are not checked again.
+	requests = nil
+	changed, errE = checker.CheckDocument(context.Background(), &doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.False(t, changed)
+	assert.Empty(t, requests)
+	assert.Len(t, doc.Get(document.GetCorePropertyID("LABEL")), 1)
+
+	// Links checked by the checker are not requested again, even when they have to be rechecked,
+	// but a snapshot of the dead link is looked up again.
+	checker.Recheck = 0
+	changed, errE = checker.CheckDocument(context.Background(), &doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"GET /wayback"}, requests)
+	assert.InDelta(t, http.StatusNotFound, status(&doc.Claims.Reference[2]), 0)
+	assert.Len(t, doc.Claims.Reference[2].Get(document.GetCorePropertyID("LABEL")), 1)
+}
@@ -0,0 +1,134 @@
+package wikipedia
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// Edition is a Wikipedia language edition.
+type Edition struct {
+	// Language code of the edition. It is also used as the language of text claims.
+	Language string
+
+	// Name of the language in English.
+	Name string
+}
+
+// Wiki returns the database name of the edition (e.g., "enwiki").
+func (e Edition) Wiki() string {
+	return e.Language + "wiki"
+}
+
+// Domain returns the domain of the edition (e.g., "en.wikipedia.org").
+func (e Edition) Domain() string {
+	return e.Language + ".wikipedia.org"
+}
+
+// MnemonicPrefix returns the prefix of mnemonics of properties specific
+// to the edition (e.g., "ENGLISH_WIKIPEDIA").
+func (e Edition) MnemonicPrefix() string {
+	return strings.ToUpper(e.Name) + "_WIKIPEDIA"
+}
+
+// EnglishEdition is the English Wikipedia edition.
+//
+//nolint:gochecknoglobals
+var EnglishEdition = Edition{Language: "en", Name: "English"}
+
+// Editions are supported Wikipedia language editions.
+//
+//nolint:gochecknoglobals
+var Editions = []Edition{
+	EnglishEdition,
+	{Language: "de", Name: "German"},
+	{Language: "fr", Name: "French"},
+	{Language: "es", Name: "Spanish"},
+	{Language: "it", Name: "Italian"},
+	{Language: "nl", Name: "Dutch"},
+	{Language: "pl", Name: "Polish"},
+	{Language: "pt", Name: "Portuguese"},
+	{Language: "ru", Name: "Russian"},
+	{Language: "ja", Name: "Japanese"},
+	{Language: "zh", Name: "Chinese"},
+}
+
+// ParseEditions returns editions for the language codes, in the same order.
+// Duplicate language codes are ignored.
+func ParseEditions(languages []string) ([]Edition, errors.E) {
+	editions := []Edition{}
+	for _, language := range languages {
+		language = strings.TrimSpace(language)
+		i := slices.IndexFunc(Editions, func(e Edition) bool {
+			return e.Language == language
+		})
+		if i < 0 {
+			errE := errors.New("unsupported Wikipedia edition")
+			errors.Details(errE)["edition"] = language
+			return nil, errE
+		}
+		if !slices.Contains(editions, Editions[i]) {
+			editions = append(editions, Editions[i])
+		}
+	}
+	if len(editions) == 0 {
+		return nil, errors.New("no Wikipedia edition")
+	}
+	return editions, nil
+}
+
+// editionProperties returns properties specific to non-English editions.
+// Properties for the English edition are listed in wikipediaProperties.
+func editionProperties() []struct {
+	Name            string
+	ExtraNames      []string
+	DescriptionHTML string
+	Types           []string
+} {
+	properties := []struct {
+		Name            string
+		ExtraNames      []string
+		DescriptionHTML string
+		Types           []string
+	}{}
+	for _, edition := range Editions {
+		if edition == EnglishEdition {
+			continue
+		}
+		link := fmt.Sprintf(`<a href="https://%s/">%s Wikipedia</a>`, edition.Domain(), edition.Name)
+		properties = append(properties, []struct {
+			Name            string
+			ExtraNames      []string
+			DescriptionHTML string
+			Types           []string
+		}{
+			{
+				edition.Name + " Wikipedia page id",
+				nil,
+				link + " page identifier.",
+				[]string{`"identifier" claim type`},
+			},
+			{
+				edition.Name + " Wikipedia citation URL",
+				nil,
+				"URL of a source cited by " + link + " article.",
+				[]string{`"reference" claim type`, `citation`},
+			},
+			{
+				edition.Name + " Wikipedia citation DOI",
+				nil,
+				`<a href="https://www.doi.org/">DOI</a> of a source cited by ` + link + " article.",
+				[]string{`"identifier" claim type`, `citation`},
+			},
+			{
+				edition.Name + " Wikipedia citation ISBN",
+				nil,
+				`<a href="https://www.isbn-international.org/">ISBN</a> of a source cited by ` + link + " article.",
+				[]string{`"identifier" claim type`, `citation`},
+			},
+		}...)
+	}
+	return properties
+}
@@ -0,0 +1,132 @@
+package wikipedia
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/mediawiki"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestParseEditions(t *testing.T) {
+	t.Parallel()
+
+	editions, errE := ParseEditions([]string{"en", " de", "fr", "de"})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []Edition{
+		{Language: "en", Name: "English"},
+		{Language: "de", Name: "German"},
+		{Language: "fr", Name: "French"},
+	}, editions)
+
+	assert.Equal(t, "dewiki", editions[1].Wiki())
+	assert.Equal(t, "de.wikipedia.org", editions[1].Domain())
+	assert.Equal(t, "GERMAN_WIKIPEDIA", editions[1].MnemonicPrefix())
+	assert.Equal(t, "ENGLISH_WIKIPEDIA", EnglishEdition.MnemonicPrefix())
+
+	_, errE = ParseEditions([]string{"en", "xx"})
+	require.Error(t, errE)
+	assert.Equal(t, "xx", errors.Details(errE)["edition"])
+
+	_, errE = ParseEditions(nil)
+	assert.Error(t, errE)
+}
+
+func TestEditionProperties(t *testing.T) {
+	t.Parallel()
+
+	for _, edition := range Editions {
+		for _, suffix := range []string{"_PAGE_ID", "_CITATION_URL", "_CITATION_DOI", "_CITATION_ISBN"} {
+			assert.NotPanics(t, func() {
+				document.GetCorePropertyReference(edition.MnemonicPrefix() + suffix)
+			}, edition.MnemonicPrefix()+suffix)
+		}
+	}
+}
+
+func articleWithImage(url string) mediawiki.Article {
+	return mediawiki.Article{ //nolint:exhaustruct
+		Name:  "Test",
+		Image: &mediawiki.Image{ContentURL: url}, //nolint:exhaustruct
+	}
+}
+
+func articleImages(doc *document.D) map[string]document.Confidence {
+	images := map[string]document.Confidence{}
+	for _, claim := range doc.Get(document.GetCorePropertyID("WIKIPEDIA_ARTICLE_IMAGE_URL")) {
+		c := claim.(*document.ReferenceClaim) //nolint:forcetypeassert,errcheck
+		images[c.IRI] = c.Confidence
+	}
+	return images
+}
+
+func TestConvertArticleImage(t *testing.T) {
+	t.Parallel()
+
+	logger := zerolog.Nop()
+	german := Edition{Language: "de", Name: "German"}
+	french := Edition{Language: "fr", Name: "French"}
+
+	doc := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: GetWikidataDocumentID("Q1"), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+
+	errE := ConvertArticleImage(logger, EnglishEdition, true, "Q1", articleWithImage("https://example.com/a.jpg"), doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	// An article without an image does not change anything.
+	errE = ConvertArticleImage(logger, german, false, "Q1", mediawiki.Article{Name: "Test"}, doc) //nolint:exhaustruct
+	require.NoError(t, errE, "% -+#.1v", errE)
+	// An edition which agrees with the primary edition.
+	errE = ConvertArticleImage(logger, german, false, "Q1", articleWithImage("https://example.com/a.jpg"), doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	// An edition which disagrees with the primary edition.
+	errE = ConvertArticleImage(logger, french, false, "Q1", articleWithImage("https://example.com/b.jpg"), doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, map[string]document.Confidence{
+		"https://example.com/a.jpg": document.HighConfidence,
+		"https://example.com/b.jpg": document.LowConfidence,
+	}, articleImages(doc))
+
+	doc = &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: GetWikidataDocumentID("Q2"), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+
+	// Other editions might be imported before the primary one.
+	errE = ConvertArticleImage(logger, german, false, "Q2", articleWithImage("https://example.com/b.jpg"), doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, map[string]document.Confidence{
+		"https://example.com/b.jpg": document.MediumConfidence,
+	}, articleImages(doc))
+	errE = ConvertArticleImage(logger, EnglishEdition, true, "Q2", articleWithImage("https://example.com/a.jpg"), doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, map[string]document.Confidence{
+		"https://example.com/a.jpg": document.HighConfidence,
+		"https://example.com/b.jpg": document.LowConfidence,
+	}, articleImages(doc))
+}
+
+func TestConvertArticleRedirectsLanguage(t *testing.T) {
+	t.Parallel()
+
+	doc := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: GetWikidataDocumentID("Q1"), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+	article := mediawiki.Article{ //nolint:exhaustruct
+		Name:      "Test",
+		Redirects: []mediawiki.Redirect{{Name: "Same_name"}}, //nolint:exhaustruct
+	}
+
+	errE := ConvertArticleRedirects(zerolog.Nop(), NameSpaceWikidata, "en", "Q1", article, doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = ConvertArticleRedirects(zerolog.Nop(), NameSpaceWikidata, "de", "Q1", article, doc)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	names := doc.Get(document.GetCorePropertyID("NAME"))
+	require.Len(t, names, 2)
+	assert.Equal(t, document.TranslatableHTMLString{"en": "Same name"}, names[0].(*document.TextClaim).HTML) //nolint:forcetypeassert,errcheck
+	assert.Equal(t, document.TranslatableHTMLString{"de": "Same name"}, names[1].(*document.TextClaim).HTML) //nolint:forcetypeassert,errcheck
+}
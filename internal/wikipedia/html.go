@@ -170,31 +170,21 @@ func cleanupDocument(doc *goquery.Document) {
 	doc.Find("*").RemoveAttr("data-mw")
 }
 
-func extractArticle(doc *goquery.Document) (*goquery.Document, errors.E) { //nolint:unparam
+func extractArticle(doc *goquery.Document, cleanup Cleanup) (*goquery.Document, errors.E) { //nolint:unparam
 	cleanupDocument(doc)
-	// Remove some sections.
-	// TODO: Extract to annotations and metadata.
-	doc.Find("section").Each(func(_ int, section *goquery.Selection) {
-	LEVEL:
-		for _, level := range []string{"h1", "h2", "h3", "h4", "h5", "h6"} {
-			heading := section.ChildrenFiltered(level).Text()
-			for _, h := range []string{"See also", "Online sources", "External links", "References", "Footnotes", "Notes", "Further reading"} {
-				if strings.Contains(heading, h) {
-					section.Remove()
-					break LEVEL
-				}
-			}
-		}
-	})
+	// TODO: Extract removed sections to annotations and metadata.
+	cleanup.apply(doc)
 	return doc, nil
 }
 
-func ExtractArticle(input string) (string, *goquery.Document, errors.E) {
+// ExtractArticle extracts the body of the article from article HTML, removing
+// parts as configured by cleanup.
+func ExtractArticle(input string, cleanup Cleanup) (string, *goquery.Document, errors.E) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(input))
 	if err != nil {
 		return "", nil, errors.WithStack(err)
 	}
-	doc, errE := extractArticle(doc)
+	doc, errE := extractArticle(doc, cleanup)
 	if errE != nil {
 		return "", doc, errE
 	}
@@ -243,7 +233,7 @@ func ExtractCategoryDescription(input string) (string, errors.E) {
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
-	doc, errE := extractArticle(doc)
+	doc, errE := extractArticle(doc, DefaultCleanup)
 	if errE != nil {
 		return "", errE
 	}
@@ -287,7 +277,7 @@ func ExtractTemplateDescription(input string) (string, errors.E) {
 		doc.Find(".documentation-startbox, .documentation section, .documentation-clear").Remove()
 		return exctractSummary(doc.Find(".documentation"))
 	}
-	doc, errE := extractArticle(doc)
+	doc, errE := extractArticle(doc, DefaultCleanup)
 	if errE != nil {
 		return "", errE
 	}
@@ -38,7 +38,7 @@ func TestExtractArticle(t *testing.T) {
 
 			input, err := content.ReadFile(filepath.Join("testdata", "article", entry.Name()))
 			require.NoError(t, err)
-			output, _, err := wikipedia.ExtractArticle(string(input))
+			output, _, err := wikipedia.ExtractArticle(string(input), wikipedia.DefaultCleanup)
 			require.NoError(t, err)
 			expectedFilePath := filepath.Join("testdata", "article", base+"_out.html")
 			expected, err := content.ReadFile(expectedFilePath)
@@ -72,7 +72,7 @@ func TestExtractArticleSummary(t *testing.T) {
 
 			input, err := content.ReadFile(filepath.Join("testdata", "article", entry.Name()))
 			require.NoError(t, err)
-			_, doc, err := wikipedia.ExtractArticle(string(input))
+			_, doc, err := wikipedia.ExtractArticle(string(input), wikipedia.DefaultCleanup)
 			require.NoError(t, err)
 			output, err := wikipedia.ExtractArticleSummary(doc)
 			require.NoError(t, err)
@@ -180,3 +180,26 @@ func TestExtractCategoryAndTemplateDescription(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractCitations(t *testing.T) {
+	t.Parallel()
+
+	input := `<html><body><section><p>Text.<sup class="reference"><a href="#cite_note-1">[1]</a></sup></p></section>` +
+		`<ol class="references"><li id="cite_note-1"><span class="mw-reference-text">` +
+		`<cite class="citation journal">Author (2020). <a rel="mw:ExtLink" class="external text" href="//example.com/paper">Paper</a>. ` +
+		`<a rel="mw:ExtLink" class="external text" href="https://web.archive.org/example.com/paper">Archived</a>. ` +
+		`<a rel="mw:ExtLink" class="external text" href="https://doi.org/10.1000/182">10.1000/182</a>.</cite></span></li>` +
+		`<li id="cite_note-2"><span class="mw-reference-text"><cite class="citation book">Book. ` +
+		`<a rel="mw:WikiLink" href="./Special:BookSources/978-0-00-000000-2">978-0-00-000000-2</a>.</cite></span></li>` +
+		`<li id="cite_note-3"><span class="mw-reference-text"><cite class="citation book">Book. ` +
+		`<a rel="mw:WikiLink" href="./Special:BookSources/978-0-00-000000-2">978-0-00-000000-2</a>.</cite></span></li>` +
+		`<li id="cite_note-4"><span class="mw-reference-text"><cite class="citation">No source.</cite></span></li>` +
+		`</ol></body></html>`
+
+	citations, errE := wikipedia.ExtractCitations(input)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []wikipedia.Citation{
+		{URL: "https://example.com/paper", DOI: "10.1000/182", ISBN: ""},
+		{URL: "", DOI: "", ISBN: "9780000000002"},
+	}, citations)
+}
@@ -0,0 +1,342 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+const (
+	// WikidataEntitiesAPILimit is the maximum number of entities which can be fetched
+	// in one request to Wikidata wbgetentities API.
+	WikidataEntitiesAPILimit = 50
+
+	wikidataItemNamespace     = 0
+	wikidataPropertyNamespace = 120
+)
+
+// Core properties of claims made by ConvertEntity which can change when the entity changes.
+// Other claims made by ConvertEntity are based only on entity ID.
+//
+//nolint:gochecknoglobals
+var wikidataEntityCoreProperties = map[identifier.Identifier]bool{
+	document.GetCorePropertyID("NAME"):                         true,
+	document.GetCorePropertyID("DESCRIPTION"):                  true,
+	document.GetCorePropertyID("ENGLISH_WIKIPEDIA_PAGE_TITLE"): true,
+	document.GetCorePropertyID("ENGLISH_WIKIPEDIA_PAGE"):       true,
+	document.GetCorePropertyID("WIKIMEDIA_COMMONS_PAGE_TITLE"): true,
+	document.GetCorePropertyID("WIKIMEDIA_COMMONS_PAGE"):       true,
+}
+
+// WikidataIncrementalDumpURL returns the URL of Wikidata incremental (daily) stubs dump for the date.
+func WikidataIncrementalDumpURL(date time.Time) string {
+	d := date.UTC().Format("20060102")
+	return fmt.Sprintf("https://dumps.wikimedia.org/other/incr/wikidatawiki/%s/wikidatawiki-%s-stubs-meta-hist-incr.xml.gz", d, d)
+}
+
+type stubsPage struct {
+	Title     string `xml:"title"`
+	Namespace int    `xml:"ns"`
+}
+
+// ChangedWikidataEntities parses XML of Wikidata incremental stubs dump and returns
+// IDs of items and properties changed in the dump, in the order of their first revision.
+func ChangedWikidataEntities(r io.Reader) ([]string, errors.E) {
+	ids := []string{}
+	seen := map[string]bool{}
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "page" {
+			continue
+		}
+
+		var page stubsPage
+		err = decoder.DecodeElement(&page, &start)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		var id string
+		switch page.Namespace {
+		case wikidataItemNamespace:
+			id = page.Title
+		case wikidataPropertyNamespace:
+			id = strings.TrimPrefix(page.Title, "Property:")
+		default:
+			continue
+		}
+
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+type wbGetEntitiesResponse struct {
+	Error    json.RawMessage            `json:"error,omitempty"`
+	ServedBy string                     `json:"servedby,omitempty"`
+	Success  int                        `json:"success"`
+	Entities map[string]json.RawMessage `json:"entities"`
+}
+
+type wbGetEntitiesEntity struct {
+	ID        string          `json:"id"`
+	Missing   *string         `json:"missing,omitempty"`
+	Redirects json.RawMessage `json:"redirects,omitempty"`
+}
+
+// GetWikidataEntities fetches current entities with IDs using Wikidata API.
+//
+// At most WikidataEntitiesAPILimit IDs can be provided. Entities which do not exist
+// anymore or which have been redirected to another entity are not returned.
+func GetWikidataEntities(ctx context.Context, httpClient *retryablehttp.Client, ids []string) ([]mediawiki.Entity, errors.E) {
+	if len(ids) > WikidataEntitiesAPILimit {
+		errE := errors.New("too many IDs")
+		errors.Details(errE)["count"] = len(ids)
+		return nil, errE
+	}
+
+	data := url.Values{}
+	data.Set("action", "wbgetentities")
+	data.Set("format", "json")
+	data.Set("ids", strings.Join(ids, "|"))
+	apiURL := "https://www.wikidata.org/w/api.php"
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPost, apiURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = apiURL
+		return nil, errE
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = apiURL
+		return nil, errE
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		errE := errors.New("bad response status")
+		errors.Details(errE)["url"] = apiURL
+		errors.Details(errE)["code"] = resp.StatusCode
+		errors.Details(errE)["body"] = strings.TrimSpace(string(body))
+		return nil, errE
+	}
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = apiURL
+		return nil, errE
+	}
+
+	return parseWikidataEntities(ids, body)
+}
+
+func parseWikidataEntities(ids []string, body []byte) ([]mediawiki.Entity, errors.E) {
+	var response wbGetEntitiesResponse
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["body"] = string(body)
+		return nil, errE
+	}
+	if len(response.Error) > 0 {
+		errE := errors.New("API error")
+		errors.Details(errE)["body"] = response.Error
+		errors.Details(errE)["servedBy"] = response.ServedBy
+		return nil, errE
+	}
+
+	entities := []mediawiki.Entity{}
+	for _, id := range ids {
+		data, ok := response.Entities[id]
+		if !ok {
+			continue
+		}
+
+		var e wbGetEntitiesEntity
+		err := json.Unmarshal(data, &e)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["entity"] = id
+			return nil, errE
+		}
+		if e.Missing != nil || len(e.Redirects) > 0 || e.ID != id {
+			continue
+		}
+
+		var entity mediawiki.Entity
+		err = json.Unmarshal(data, &entity)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["entity"] = id
+			return nil, errE
+		}
+		entities = append(entities, entity)
+	}
+
+	return entities, nil
+}
+
+func claimPropID(claim document.Claim) *identifier.Identifier {
+	switch c := claim.(type) {
+	case *document.IdentifierClaim:
+		return c.Prop.ID
+	case *document.ReferenceClaim:
+		return c.Prop.ID
+	case *document.TextClaim:
+		return c.Prop.ID
+	case *document.StringClaim:
+		return c.Prop.ID
+	case *document.AmountClaim:
+		return c.Prop.ID
+	case *document.AmountRangeClaim:
+		return c.Prop.ID
+	case *document.RelationClaim:
+		return c.Prop.ID
+	case *document.FileClaim:
+		return c.Prop.ID
+	case *document.NoValueClaim:
+		return c.Prop.ID
+	case *document.UnknownValueClaim:
+		return c.Prop.ID
+	case *document.TimeClaim:
+		return c.Prop.ID
+	case *document.TimeRangeClaim:
+		return c.Prop.ID
+	}
+	return nil
+}
+
+// IsWikidataEntityClaim returns true if the claim has been made by ConvertEntity from
+// a Wikidata entity's statement, label, alias, description, or sitelink.
+//
+// Other passes add claims to the same documents and those claims should be kept
+// when the entity changes.
+func IsWikidataEntityClaim(
+	ctx context.Context,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	cache *es.Cache, claim document.Claim,
+) (bool, errors.E) {
+	prop := claimPropID(claim)
+	if prop == nil {
+		return false, nil
+	}
+	if wikidataEntityCoreProperties[*prop] {
+		return true, nil
+	}
+
+	doc, ok := cache.Get(*prop)
+	if !ok {
+		var errE errors.E
+		doc, _, errE = getDocumentFromByID(ctx, s, *prop)
+		if errors.Is(errE, ErrNotFound) {
+			return false, nil
+		} else if errE != nil {
+			errors.Details(errE)["doc"] = prop.String()
+			return false, errE
+		}
+		cache.Add(*prop, doc)
+	}
+
+	return len(doc.Get(document.GetCorePropertyID("WIKIDATA_PROPERTY_ID"))) > 0, nil
+}
+
+// GetWikidataEntityDocument returns the current document for the Wikidata entity, if it exists.
+func GetWikidataEntityDocument(
+	ctx context.Context,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	id string,
+) (*document.D, store.Version, errors.E) {
+	doc, version, errE := getDocumentFromByID(ctx, s, GetWikidataDocumentID(id))
+	if errE != nil {
+		errors.Details(errE)["entity"] = id
+		return nil, store.Version{}, errE
+	}
+	return doc, version, nil
+}
+
+// cloneDocument returns a deep copy of the document.
+func cloneDocument(doc *document.D) (*document.D, errors.E) {
+	data, errE := x.MarshalWithoutEscapeHTML(doc)
+	if errE != nil {
+		return nil, errE
+	}
+	var clone document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &clone)
+	if errE != nil {
+		return nil, errE
+	}
+	return &clone, nil
+}
+
+// DiffWikidataEntity returns claim-level changes which update the existing document
+// of a Wikidata entity to the newly converted document of the same entity, and the
+// updated document with changes applied.
+//
+// Claims of the existing document which have not been made from the entity are kept.
+// References in the converted document have to be already resolved.
+func DiffWikidataEntity(
+	ctx context.Context,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	cache *es.Cache, existing, converted *document.D,
+) (document.Changes, *document.D, errors.E) {
+	var ownedErrE errors.E
+	changes, errE := document.Diff(existing, converted, func(claim document.Claim) bool {
+		owned, errE := IsWikidataEntityClaim(ctx, s, cache, claim)
+		if errE != nil && ownedErrE == nil {
+			ownedErrE = errE
+		}
+		return owned
+	})
+	if errE != nil {
+		return nil, nil, errE
+	}
+	if ownedErrE != nil {
+		return nil, nil, ownedErrE
+	}
+
+	updated, errE := cloneDocument(existing)
+	if errE != nil {
+		return nil, nil, errE
+	}
+	errE = changes.Apply(updated)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	return changes, updated, nil
+}
@@ -0,0 +1,46 @@
+package wikipedia
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedWikidataEntities(t *testing.T) {
+	t.Parallel()
+
+	dump := `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.11/" version="0.11">
+  <siteinfo><sitename>Wikidata</sitename></siteinfo>
+  <page><title>Q42</title><ns>0</ns><id>138</id><revision><id>1</id></revision></page>
+  <page><title>Property:P31</title><ns>120</ns><id>3918489</id><revision><id>2</id></revision></page>
+  <page><title>Lexeme:L1</title><ns>146</ns><id>54387043</id><revision><id>3</id></revision></page>
+  <page><title>Q42</title><ns>0</ns><id>138</id><revision><id>4</id></revision></page>
+  <page><title>Q1</title><ns>0</ns><id>129</id><revision><id>5</id></revision></page>
+</mediawiki>`
+
+	ids, errE := ChangedWikidataEntities(strings.NewReader(dump))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []string{"Q42", "P31", "Q1"}, ids)
+}
+
+func TestParseWikidataEntities(t *testing.T) {
+	t.Parallel()
+
+	body := `{"entities":{
+  "Q1":{"type":"item","id":"Q1","pageid":129,"ns":0,"title":"Q1","lastrevid":5,"modified":"2024-01-01T00:00:00Z",
+        "labels":{"en":{"language":"en","value":"Universe"}}},
+  "Q2":{"id":"Q2","missing":""},
+  "Q3":{"type":"item","id":"Q4","redirects":{"from":"Q3","to":"Q4"}}
+},"success":1}`
+
+	entities, errE := parseWikidataEntities([]string{"Q1", "Q2", "Q3"}, []byte(body))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, entities, 1)
+	assert.Equal(t, "Q1", entities[0].ID)
+	assert.Equal(t, "Universe", entities[0].Labels["en"].Value)
+
+	_, errE = parseWikidataEntities([]string{"Q1"}, []byte(`{"error":{"code":"no-such-entity"},"servedby":"mw1"}`))
+	assert.Error(t, errE)
+}
@@ -39,6 +39,24 @@ var wikipediaProperties = []struct {
 		`<a href="https://www.wikidata.org/wiki/Wikidata:Main_Page">Wikidata</a> item page IRI.`,
 		[]string{`"reference" claim type`},
 	},
+	{
+		"Wikidata constraint violation",
+		nil,
+		`Violated <a href="https://www.wikidata.org/wiki/Help:Property_constraints_portal">Wikidata property constraint</a>.`,
+		[]string{`"relation" claim type`},
+	},
+	{
+		"Wikidata preferred rank",
+		nil,
+		`A label that a claim comes from a <a href="https://www.wikidata.org/wiki/Help:Ranking">Wikidata</a> statement with preferred rank.`,
+		nil,
+	},
+	{
+		"Wikidata deprecated rank",
+		nil,
+		`A label that a claim comes from a <a href="https://www.wikidata.org/wiki/Help:Ranking">Wikidata</a> statement with deprecated rank.`,
+		nil,
+	},
 	{
 		"English Wikipedia page title",
 		nil,
@@ -136,8 +154,78 @@ var wikipediaProperties = []struct {
 		`Entity is in <a href="https://commons.wikimedia.org/wiki/Commons:Categories">Wikimedia Commons category</a>.`,
 		[]string{`"relation" claim type`},
 	},
+	{
+		"English Wikipedia citation URL",
+		nil,
+		`URL of a source cited by <a href="https://en.wikipedia.org/wiki/Main_Page">English Wikipedia</a> article.`,
+		[]string{`"reference" claim type`, `citation`},
+	},
+	{
+		"English Wikipedia citation DOI",
+		nil,
+		`<a href="https://www.doi.org/">DOI</a> of a source cited by <a href="https://en.wikipedia.org/wiki/Main_Page">English Wikipedia</a> article.`,
+		[]string{`"identifier" claim type`, `citation`},
+	},
+	{
+		"English Wikipedia citation ISBN",
+		nil,
+		`<a href="https://www.isbn-international.org/">ISBN</a> of a source cited by <a href="https://en.wikipedia.org/wiki/Main_Page">English Wikipedia</a> article.`,
+		[]string{`"identifier" claim type`, `citation`},
+	},
+	{
+		"Wikipedia article image URL",
+		nil,
+		`URL of the lead image of a <a href="https://www.wikipedia.org/">Wikipedia</a> article.`,
+		[]string{`"reference" claim type`},
+	},
+	{
+		"English Wikipedia article quality class",
+		nil,
+		`<a href="https://en.wikipedia.org/wiki/Wikipedia:Content_assessment">Quality class</a> of <a href="https://en.wikipedia.org/wiki/Main_Page">English Wikipedia</a> ` +
+			`article assessed by WikiProjects (e.g., "FA" for featured articles).`,
+		[]string{`"string" claim type`, `single value`},
+	},
+	{
+		"English Wikipedia article quality rank",
+		nil,
+		`Rank of <a href="https://en.wikipedia.org/wiki/Wikipedia:Content_assessment">quality class</a> of <a href="https://en.wikipedia.org/wiki/Main_Page">English Wikipedia</a> ` +
+			`article, from 1 for stubs to 7 for featured articles and lists.`,
+		[]string{`"amount" claim type`, `single value`},
+	},
+	{
+		"English Wikipedia article importance",
+		nil,
+		`Highest <a href="https://en.wikipedia.org/wiki/Wikipedia:Content_assessment">importance</a> of <a href="https://en.wikipedia.org/wiki/Main_Page">English Wikipedia</a> ` +
+			`article assessed by WikiProjects (e.g., "Top").`,
+		[]string{`"string" claim type`, `single value`},
+	},
+	{
+		"link last checked",
+		nil,
+		`When liveness of the link of a reference claim was last checked.`,
+		[]string{`"time" claim type`, `single value`},
+	},
+	{
+		"link HTTP status",
+		nil,
+		`HTTP status code returned when liveness of the link of a reference claim was last checked.`,
+		[]string{`"amount" claim type`, `single value`},
+	},
+	{
+		"dead link",
+		nil,
+		`A label that a link of a reference claim is dead or that a document has a dead link.`,
+		nil,
+	},
+	{
+		"original URL",
+		nil,
+		`Original URL of a dead link which has been replaced with its <a href="https://web.archive.org/">Wayback Machine</a> snapshot.`,
+		[]string{`"reference" claim type`, `single value`},
+	},
 }
 
 func init() { //nolint:gochecknoinits
-	document.GenerateCoreProperties(wikipediaProperties)
+	document.GenerateNamespacedCoreProperties("WIKIPEDIA", wikipediaProperties)
+	document.GenerateNamespacedCoreProperties("WIKIPEDIA", editionProperties())
 }
@@ -0,0 +1,47 @@
+package wikipedia
+
+import (
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// RelatedWikidataEntities returns sorted IDs of Wikidata items and properties the entity is related to:
+// properties of its statements, qualifiers, and references, and items and properties which are their values.
+// The entity itself is not included.
+func RelatedWikidataEntities(entity mediawiki.Entity) []string {
+	related := map[string]bool{}
+	addSnak := func(snak mediawiki.Snak) {
+		related[snak.Property] = true
+		related[snakEntityID(snak)] = true
+	}
+
+	for _, statements := range entity.Claims {
+		for _, statement := range statements {
+			addSnak(statement.MainSnak)
+			for _, snaks := range statement.Qualifiers {
+				for _, snak := range snaks {
+					addSnak(snak)
+				}
+			}
+			for _, reference := range statement.References {
+				for _, snaks := range reference.Snaks {
+					for _, snak := range snaks {
+						addSnak(snak)
+					}
+				}
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(related))
+	for id := range related {
+		// Lexemes, forms, senses, and media info entities are not imported.
+		if id != entity.ID && (strings.HasPrefix(id, "Q") || strings.HasPrefix(id, "P")) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,55 @@
+package wikipedia
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestRelatedWikidataEntities(t *testing.T) {
+	t.Parallel()
+
+	propertySnak := func(prop, id string) mediawiki.Snak {
+		snak := entitySnak(id)
+		snak.Property = prop
+		return snak
+	}
+
+	entity := mediawiki.Entity{ //nolint:exhaustruct
+		ID:   "Q1",
+		Type: mediawiki.Item,
+		Claims: map[string][]mediawiki.Statement{
+			"P31": {
+				{ //nolint:exhaustruct
+					MainSnak: propertySnak("P31", "Q5"),
+					Qualifiers: map[string][]mediawiki.Snak{
+						"P642": {propertySnak("P642", "Q1"), propertySnak("P642", "L7")},
+					},
+					References: []mediawiki.Reference{
+						{ //nolint:exhaustruct
+							Snaks: map[string][]mediawiki.Snak{
+								"P248": {propertySnak("P248", "Q36578")},
+								"P813": {{ //nolint:exhaustruct
+									SnakType:  mediawiki.Value,
+									Property:  "P813",
+									DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue("x")},
+								}},
+							},
+						},
+					},
+				},
+			},
+			"P1628": {
+				{ //nolint:exhaustruct
+					MainSnak: mediawiki.Snak{ //nolint:exhaustruct
+						SnakType: mediawiki.NoValue,
+						Property: "P1628",
+					},
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, []string{"P1628", "P248", "P31", "P642", "P813", "Q36578", "Q5"}, RelatedWikidataEntities(entity))
+}
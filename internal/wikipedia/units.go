@@ -0,0 +1,111 @@
+package wikipedia
+
+import (
+	"math/big"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// wikidataUnit describes how to convert an amount in a Wikidata unit to a canonical unit.
+//
+// Converted amount is computed as amount * factor + offset.
+type wikidataUnit struct {
+	Unit   document.AmountUnit
+	Factor *big.Rat
+	Offset *big.Rat
+}
+
+func mustRat(s string) *big.Rat {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		panic("invalid rational number: " + s)
+	}
+	return r
+}
+
+func newWikidataUnit(u document.AmountUnit, factor, offset string) wikidataUnit {
+	return wikidataUnit{
+		Unit:   u,
+		Factor: mustRat(factor),
+		Offset: mustRat(offset),
+	}
+}
+
+// wikidataUnits maps Wikidata unit items to canonical units.
+//
+// Units which are not listed are kept as custom units.
+//
+//nolint:gochecknoglobals
+var wikidataUnits = map[string]wikidataUnit{
+	// Length.
+	"Q11573":  newWikidataUnit(document.AmountUnitMetre, "1", "0"),                    // metre
+	"Q828224": newWikidataUnit(document.AmountUnitMetre, "1000", "0"),                 // kilometre
+	"Q174728": newWikidataUnit(document.AmountUnitMetre, "0.01", "0"),                 // centimetre
+	"Q174789": newWikidataUnit(document.AmountUnitMetre, "0.001", "0"),                // millimetre
+	"Q218593": newWikidataUnit(document.AmountUnitMetre, "0.0254", "0"),               // inch
+	"Q3710":   newWikidataUnit(document.AmountUnitMetre, "0.3048", "0"),               // foot
+	"Q482798": newWikidataUnit(document.AmountUnitMetre, "0.9144", "0"),               // yard
+	"Q253276": newWikidataUnit(document.AmountUnitMetre, "1609.344", "0"),             // mile
+	"Q93318":  newWikidataUnit(document.AmountUnitMetre, "1852", "0"),                 // nautical mile
+	"Q25343":  newWikidataUnit(document.AmountUnitSquareMetre, "1", "0"),              // square metre
+	"Q712226": newWikidataUnit(document.AmountUnitSquareMetre, "1e6", "0"),            // square kilometre
+	"Q35852":  newWikidataUnit(document.AmountUnitSquareMetre, "10000", "0"),          // hectare
+	"Q81292":  newWikidataUnit(document.AmountUnitSquareMetre, "4046.8564224", "0"),   // acre
+	"Q232291": newWikidataUnit(document.AmountUnitSquareMetre, "2589988.110336", "0"), // square mile
+
+	// Mass.
+	"Q11570":  newWikidataUnit(document.AmountUnitKilogram, "1", "0"),              // kilogram
+	"Q41803":  newWikidataUnit(document.AmountUnitKilogram, "0.001", "0"),          // gram
+	"Q191118": newWikidataUnit(document.AmountUnitKilogram, "1000", "0"),           // tonne
+	"Q100995": newWikidataUnit(document.AmountUnitKilogram, "0.45359237", "0"),     // pound
+	"Q48013":  newWikidataUnit(document.AmountUnitKilogram, "0.028349523125", "0"), // ounce
+
+	// Volume.
+	"Q11582": newWikidataUnit(document.AmountUnitLitre, "1", "0"),    // litre
+	"Q25517": newWikidataUnit(document.AmountUnitLitre, "1000", "0"), // cubic metre
+
+	// Time.
+	"Q11574": newWikidataUnit(document.AmountUnitSecond, "1", "0"),     // second
+	"Q7727":  newWikidataUnit(document.AmountUnitSecond, "60", "0"),    // minute
+	"Q25235": newWikidataUnit(document.AmountUnitSecond, "3600", "0"),  // hour
+	"Q573":   newWikidataUnit(document.AmountUnitSecond, "86400", "0"), // day
+
+	// Temperature.
+	"Q25267": newWikidataUnit(document.AmountUnitCelsius, "1", "0"),        // degree Celsius
+	"Q11579": newWikidataUnit(document.AmountUnitCelsius, "1", "-273.15"),  // kelvin
+	"Q42289": newWikidataUnit(document.AmountUnitCelsius, "5/9", "-160/9"), // degree Fahrenheit
+
+	// Speed and density.
+	"Q182429": newWikidataUnit(document.AmountUnitMetrePerSecond, "1", "0"),        // metre per second
+	"Q180154": newWikidataUnit(document.AmountUnitMetrePerSecond, "5/18", "0"),     // kilometre per hour
+	"Q844211": newWikidataUnit(document.AmountUnitKilogramPerCubicMetre, "1", "0"), // kilogram per cubic metre
+
+	// Other SI units.
+	"Q25250": newWikidataUnit(document.AmountUnitVolt, "1", "0"),    // volt
+	"Q25236": newWikidataUnit(document.AmountUnitWatt, "1", "0"),    // watt
+	"Q44395": newWikidataUnit(document.AmountUnitPascal, "1", "0"),  // pascal
+	"Q25406": newWikidataUnit(document.AmountUnitCoulomb, "1", "0"), // coulomb
+	"Q25269": newWikidataUnit(document.AmountUnitJoule, "1", "0"),   // joule
+	"Q39369": newWikidataUnit(document.AmountUnitHertz, "1", "0"),   // hertz
+	"Q33680": newWikidataUnit(document.AmountUnitRadian, "1", "0"),  // radian
+
+	// Angle.
+	"Q28390": newWikidataUnit(document.AmountUnitRadian, "0.017453292519943295769236907684886127134428718885417254560971914", "0"), // degree
+
+	// Ratio.
+	"Q11229": newWikidataUnit(document.AmountUnitRatio, "0.01", "0"), // percent
+
+	// Other.
+	"Q4917":   newWikidataUnit(document.AmountUnitDollar, "1", "0"), // United States dollar
+	"Q4916":   newWikidataUnit(document.AmountUnitEuro, "1", "0"),   // euro
+	"Q25224":  newWikidataUnit(document.AmountUnitPound, "1", "0"),  // pound sterling
+	"Q8146":   newWikidataUnit(document.AmountUnitYen, "1", "0"),    // Japanese yen
+	"Q8799":   newWikidataUnit(document.AmountUnitByte, "1", "0"),   // byte
+	"Q355198": newWikidataUnit(document.AmountUnitPixel, "1", "0"),  // pixel
+}
+
+// convert converts amount to the canonical unit.
+func (u wikidataUnit) convert(amount *big.Rat) *big.Rat {
+	res := new(big.Rat).Mul(amount, u.Factor)
+	return res.Add(res, u.Offset)
+}
@@ -0,0 +1,41 @@
+package wikipedia
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestWikidataUnitConvert(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		unitID   string
+		amount   string
+		unit     document.AmountUnit
+		expected string
+	}{
+		{"Q3710", "10", document.AmountUnitMetre, "3.048"},
+		{"Q100995", "2", document.AmountUnitKilogram, "0.90718474"},
+		{"Q42289", "212", document.AmountUnitCelsius, "100"},
+		{"Q11579", "0", document.AmountUnitCelsius, "-273.15"},
+		{"Q25235", "1.5", document.AmountUnitSecond, "5400"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.unitID, func(t *testing.T) {
+			t.Parallel()
+
+			u, ok := wikidataUnits[tt.unitID]
+			if assert.True(t, ok) {
+				amount, _ := new(big.Rat).SetString(tt.amount)
+				expected, _ := new(big.Rat).SetString(tt.expected)
+				assert.Equal(t, tt.unit, u.Unit)
+				assert.Equal(t, expected.String(), u.convert(amount).String())
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"html"
 	"math"
+	"math/big"
+	"net/url"
 	"path"
 	"sort"
 	"strings"
@@ -34,6 +36,10 @@ const (
 	WikimediaCommonsTemplateReference = "CommonsTemplate"
 )
 
+// WikidataFormatterURL is the Wikidata property with the formatter URL of
+// external identifier properties, with "$1" to be replaced by the identifier.
+const WikidataFormatterURL = "P1630"
+
 //nolint:gochecknoglobals
 var (
 	NameSpaceWikidata = uuid.MustParse("8f8ba777-bcce-4e45-8dd4-a328e6722c82")
@@ -83,6 +89,23 @@ var (
 	}
 
 	claimTypeToDataTypesMap = map[string][]mediawiki.DataType{}
+
+	// Characters which Wikibase does not escape in external identifiers
+	// when formatting them into IRIs (same as MediaWiki's wfUrlencode).
+	externalIDUnescaper = strings.NewReplacer(
+		"+", "%20",
+		"%3B", ";",
+		"%40", "@",
+		"%24", "$",
+		"%21", "!",
+		"%2A", "*",
+		"%28", "(",
+		"%29", ")",
+		"%2C", ",",
+		"%2F", "/",
+		"%7E", "~",
+		"%3A", ":",
+	)
 )
 
 func init() { //nolint:gochecknoinits
@@ -93,6 +116,8 @@ func init() { //nolint:gochecknoinits
 		}
 		claimTypeToDataTypesMap[claimType] = append(claimTypeToDataTypesMap[claimType], dataType)
 	}
+	// So that Wikidata entities can be referenced with namespaced mnemonics (e.g., "WD:P569").
+	document.RegisterMnemonicNamespace("WD", NameSpaceWikidata)
 }
 
 func GetWikidataDocumentID(id string) identifier.Identifier {
@@ -172,6 +197,29 @@ func getConfidence(entityID, prop, statementID string, rank mediawiki.StatementR
 
 // getDocumentReference does not return a valid reference, but it encodes original
 // ID into the _temp field to be resolved later. It panics for unsupported IDs.
+// addRank adds to the claim made from a statement with preferred or deprecated rank a LABEL
+// meta claim to WIKIDATA_PREFERRED_RANK or WIKIDATA_DEPRECATED_RANK, respectively, so that
+// claims can be filtered by rank. Claims from statements with normal rank are not labeled.
+func addRank(namespace uuid.UUID, claim document.Claim, rank mediawiki.StatementRank) errors.E {
+	var label string
+	switch rank {
+	case mediawiki.Preferred:
+		label = "WIKIDATA_PREFERRED_RANK"
+	case mediawiki.Deprecated:
+		label = "WIKIDATA_DEPRECATED_RANK"
+	case mediawiki.Normal:
+		return nil
+	}
+	return claim.Add(&document.RelationClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         document.GetID(namespace, claim.GetID().String(), "LABEL", 0, label, 0),
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("LABEL"),
+		To:   document.GetCorePropertyReference(label),
+	})
+}
+
 func getDocumentReference(id, source string) document.Reference {
 	if strings.HasPrefix(id, "M") {
 		return document.Reference{
@@ -353,10 +401,10 @@ func resolveDataTypeFromPropertyDocument(doc *document.D, prop string, valueType
 	return 0, err
 }
 
-func getDataTypeForProperty(
+func getPropertyDocument(
 	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	cache *es.Cache, prop string, valueType *mediawiki.WikiBaseEntityType,
-) (mediawiki.DataType, errors.E) {
+	cache *es.Cache, prop string,
+) (*document.D, errors.E) {
 	id := GetWikidataDocumentID(prop)
 
 	cachedDoc, ok := cache.Get(id)
@@ -364,26 +412,97 @@ func getDataTypeForProperty(
 		if cachedDoc == nil {
 			err := errors.WithStack(ErrNotFound)
 			errors.Details(err)["prop"] = prop
-			return 0, err
+			return nil, err
 		}
-		return resolveDataTypeFromPropertyDocument(cachedDoc, prop, valueType)
+		return cachedDoc, nil
 	}
 
 	doc, _, err := getDocumentFromByID(ctx, store, id)
 	if errors.Is(err, ErrNotFound) {
 		cache.Add(id, nil)
 		errors.Details(err)["prop"] = prop
-		return 0, err
+		return nil, err
 	} else if err != nil {
 		errors.Details(err)["prop"] = prop
-		return 0, err
+		return nil, err
 	}
 
 	cache.Add(doc.ID, doc)
 
+	return doc, nil
+}
+
+func getDataTypeForProperty(
+	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	cache *es.Cache, prop string, valueType *mediawiki.WikiBaseEntityType,
+) (mediawiki.DataType, errors.E) {
+	doc, err := getPropertyDocument(ctx, store, cache, prop)
+	if err != nil {
+		return 0, err
+	}
+
 	return resolveDataTypeFromPropertyDocument(doc, prop, valueType)
 }
 
+// isWikidataProperty returns true if the reference is to the Wikidata property,
+// resolved or still temporary.
+func isWikidataProperty(ref document.Reference, prop string) bool {
+	if ref.ID != nil {
+		return *ref.ID == GetWikidataDocumentID(prop)
+	}
+	return len(ref.Temporary) == 2 && ref.Temporary[0] == WikidataReference && ref.Temporary[1] == prop
+}
+
+// resolveFormatterURLFromPropertyDocument returns the formatter URL with the highest
+// confidence among formatter URL claims of the property document, or an empty string
+// if there is none. Formatter URLs from deprecated statements are ignored.
+func resolveFormatterURLFromPropertyDocument(doc *document.D) string {
+	formatter := ""
+	confidence := document.Confidence(document.NoConfidence)
+	for _, claim := range doc.AllClaims() {
+		c, ok := claim.(*document.StringClaim)
+		if !ok || !isWikidataProperty(c.Prop, WikidataFormatterURL) {
+			continue
+		}
+		if c.Confidence > confidence && strings.Contains(c.String, "$1") {
+			formatter = c.String
+			confidence = c.Confidence
+		}
+	}
+	return formatter
+}
+
+// getFormatterURLForProperty returns the formatter URL of the Wikidata property or
+// an empty string if the property does not have one or its document does not exist (yet).
+func getFormatterURLForProperty(
+	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	cache *es.Cache, prop string,
+) (string, errors.E) {
+	doc, err := getPropertyDocument(ctx, store, cache, prop)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return resolveFormatterURLFromPropertyDocument(doc), nil
+}
+
+// FormatExternalID returns the IRI for the external identifier using the formatter URL,
+// escaping the identifier in the same way as Wikibase does. It returns an empty string
+// if the formatter URL does not have the "$1" placeholder or the result is not an absolute IRI.
+func FormatExternalID(formatter, value string) string {
+	if !strings.Contains(formatter, "$1") || value == "" {
+		return ""
+	}
+	iri := strings.ReplaceAll(formatter, "$1", externalIDUnescaper.Replace(url.QueryEscape(value)))
+	u, err := url.Parse(iri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return ""
+	}
+	return iri
+}
+
 func getWikiBaseEntityType(value interface{}) *mediawiki.WikiBaseEntityType {
 	wikiBaseEntityValue, ok := value.(mediawiki.WikiBaseEntityIDValue)
 	if !ok {
@@ -446,7 +565,7 @@ func processSnak( //nolint:ireturn,nolintlint,maintidx
 	case mediawiki.StringValue:
 		switch dataType { //nolint:exhaustive
 		case mediawiki.ExternalID:
-			return []document.Claim{
+			claims := []document.Claim{
 				&document.IdentifierClaim{
 					CoreClaim: document.CoreClaim{
 						ID:         id,
@@ -455,7 +574,28 @@ func processSnak( //nolint:ireturn,nolintlint,maintidx
 					Prop:  getDocumentReference(prop, ""),
 					Value: string(value),
 				},
-			}, nil
+			}
+
+			// We also resolve the identifier to an IRI using the formatter URL of the property,
+			// so that documents can link to external databases.
+			formatter, err := getFormatterURLForProperty(ctx, store, cache, prop)
+			if err != nil {
+				return nil, errors.WithMessage(err, "unable to resolve formatter URL for property")
+			}
+			if iri := FormatExternalID(formatter, string(value)); iri != "" {
+				args := append([]interface{}{}, idArgs...)
+				args = append(args, "IRI", 0)
+				claims = append(claims, &document.ReferenceClaim{
+					CoreClaim: document.CoreClaim{
+						ID:         document.GetID(namespace, args...),
+						Confidence: confidence,
+					},
+					Prop: getDocumentReference(prop, ""),
+					IRI:  iri,
+				})
+			}
+
+			return claims, nil
 		case mediawiki.String:
 			return []document.Claim{
 				&document.StringClaim{
@@ -588,44 +728,19 @@ func processSnak( //nolint:ireturn,nolintlint,maintidx
 	case mediawiki.QuantityValue:
 		switch dataType { //nolint:exhaustive
 		case mediawiki.Quantity:
-			amount, exact := value.Amount.Float64()
-			if !exact && math.IsInf(amount, 0) {
-				return nil, errors.Errorf("amount cannot be represented by float64: %s", value.Amount.String())
-			}
-			var uncertaintyLower, uncertaintyUpper *float64
+			var unit document.AmountUnit
+			var metaClaims *document.ClaimTypes
+			amountRat := &value.Amount.Rat
+			var lowerRat, upperRat *big.Rat
 			if value.LowerBound != nil && value.UpperBound != nil {
-				l, exact := value.LowerBound.Float64()
-				if !exact && math.IsInf(l, 0) {
-					return nil, errors.Errorf("lower bound cannot be represented by float64: %s", value.LowerBound.String())
-				}
-				uncertaintyLower = &l
-				u, exact := value.UpperBound.Float64()
-				if !exact && math.IsInf(u, 0) {
-					return nil, errors.Errorf("upper bound cannot be represented by float64: %s", value.UpperBound.String())
-				}
-				uncertaintyUpper = &u
-				if *uncertaintyLower > amount {
-					return nil, errors.Errorf("lower bound %f cannot be larger than the amount %f", *uncertaintyLower, amount)
-				}
-				if *uncertaintyUpper < amount {
-					return nil, errors.Errorf("upper bound %f cannot be smaller than the amount %f", *uncertaintyUpper, amount)
-				}
+				lowerRat = &value.LowerBound.Rat
+				upperRat = &value.UpperBound.Rat
 			} else if value.LowerBound != nil || value.UpperBound != nil {
 				return nil, errors.Errorf("both lower and upper bounds have to be provided, or none, not just one")
 			}
-
-			var unit document.AmountUnit
-			var metaClaims *document.ClaimTypes
 			if value.Unit == "1" {
 				unit = document.AmountUnitNone
 			} else {
-				// For now we store the amount as-is and convert to the same unit later on
-				// using the unit we store into meta claims.
-				// TODO: Implement unit post-processing.
-				unit = document.AmountUnitCustom
-				args := append([]interface{}{}, idArgs...)
-				args = append(args, "UNIT", 0)
-				claimID := document.GetID(NameSpaceWikidata, args...)
 				var unitID string
 				if strings.HasPrefix(value.Unit, "http://www.wikidata.org/entity/") {
 					unitID = strings.TrimPrefix(value.Unit, "http://www.wikidata.org/entity/")
@@ -634,17 +749,89 @@ func processSnak( //nolint:ireturn,nolintlint,maintidx
 				} else {
 					return nil, errors.Errorf("unsupported unit URL: %s", value.Unit)
 				}
-				metaClaims = &document.ClaimTypes{
-					Relation: document.RelationClaims{
-						{
-							CoreClaim: document.CoreClaim{
-								ID:         claimID,
-								Confidence: document.HighConfidence,
+				if u, ok := wikidataUnits[unitID]; ok {
+					// We convert the amount to the canonical unit and store the
+					// original amount and its unit into meta claims.
+					unit = u.Unit
+					originalAmount, originalDecimal := document.DecimalAmount(amountRat)
+					if math.IsInf(originalAmount, 0) {
+						return nil, errors.Errorf("amount cannot be represented by float64: %s", value.Amount.String())
+					}
+					amountRat = u.convert(amountRat)
+					if lowerRat != nil && upperRat != nil {
+						lowerRat = u.convert(lowerRat)
+						upperRat = u.convert(upperRat)
+					}
+					args := append([]interface{}{}, idArgs...)
+					args = append(args, "ORIGINAL_AMOUNT", 0)
+					metaClaims = &document.ClaimTypes{
+						Amount: document.AmountClaims{
+							{
+								CoreClaim: document.CoreClaim{
+									ID:         document.GetID(NameSpaceWikidata, args...),
+									Confidence: document.HighConfidence,
+									Meta: &document.ClaimTypes{
+										Relation: document.RelationClaims{
+											{
+												CoreClaim: document.CoreClaim{
+													ID:         document.GetID(NameSpaceWikidata, append(args, "UNIT", 0)...),
+													Confidence: document.HighConfidence,
+												},
+												Prop: document.GetCorePropertyReference("UNIT"),
+												To:   getDocumentReference(unitID, ""),
+											},
+										},
+									},
+								},
+								Prop:    document.GetCorePropertyReference("ORIGINAL_AMOUNT"),
+								Amount:  originalAmount,
+								Unit:    document.AmountUnitCustom,
+								Decimal: originalDecimal,
 							},
-							Prop: document.GetCorePropertyReference("UNIT"),
-							To:   getDocumentReference(unitID, ""),
 						},
-					},
+					}
+				} else {
+					// Unit is not known to us, so we store the amount as-is
+					// and store the unit into meta claims.
+					unit = document.AmountUnitCustom
+					args := append([]interface{}{}, idArgs...)
+					args = append(args, "UNIT", 0)
+					metaClaims = &document.ClaimTypes{
+						Relation: document.RelationClaims{
+							{
+								CoreClaim: document.CoreClaim{
+									ID:         document.GetID(NameSpaceWikidata, args...),
+									Confidence: document.HighConfidence,
+								},
+								Prop: document.GetCorePropertyReference("UNIT"),
+								To:   getDocumentReference(unitID, ""),
+							},
+						},
+					}
+				}
+			}
+
+			amount, decimal := document.DecimalAmount(amountRat)
+			if math.IsInf(amount, 0) {
+				return nil, errors.Errorf("amount cannot be represented by float64: %s", amountRat.String())
+			}
+			var uncertaintyLower, uncertaintyUpper *float64
+			if lowerRat != nil && upperRat != nil {
+				l, exact := lowerRat.Float64()
+				if !exact && math.IsInf(l, 0) {
+					return nil, errors.Errorf("lower bound cannot be represented by float64: %s", lowerRat.String())
+				}
+				uncertaintyLower = &l
+				u, exact := upperRat.Float64()
+				if !exact && math.IsInf(u, 0) {
+					return nil, errors.Errorf("upper bound cannot be represented by float64: %s", upperRat.String())
+				}
+				uncertaintyUpper = &u
+				if *uncertaintyLower > amount {
+					return nil, errors.Errorf("lower bound %f cannot be larger than the amount %f", *uncertaintyLower, amount)
+				}
+				if *uncertaintyUpper < amount {
+					return nil, errors.Errorf("upper bound %f cannot be smaller than the amount %f", *uncertaintyUpper, amount)
 				}
 			}
 
@@ -655,9 +842,10 @@ func processSnak( //nolint:ireturn,nolintlint,maintidx
 						Confidence: confidence,
 						Meta:       metaClaims,
 					},
-					Prop:   getDocumentReference(prop, ""),
-					Amount: amount,
-					Unit:   unit,
+					Prop:    getDocumentReference(prop, ""),
+					Amount:  amount,
+					Unit:    unit,
+					Decimal: decimal,
 				},
 			}
 			if uncertaintyLower != nil && uncertaintyUpper != nil {
@@ -805,10 +993,15 @@ func addReference(
 
 // ConvertEntity converts both Wikidata entities and Wikimedia Commons entities.
 // Entities can reference only Wikimedia Commons files and not Wikipedia files.
+//
+// Claims from statements with preferred rank have high confidence, with normal rank medium confidence,
+// and with deprecated rank no confidence. Claims from statements with preferred and deprecated rank
+// are also labeled with their rank (see addRank). If skipDeprecated is true, statements with
+// deprecated rank are skipped entirely.
 func ConvertEntity( //nolint:maintidx
 	ctx context.Context, logger zerolog.Logger,
 	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	cache *es.Cache, namespace uuid.UUID, entity mediawiki.Entity,
+	cache *es.Cache, namespace uuid.UUID, entity mediawiki.Entity, skipDeprecated bool,
 ) (*document.D, errors.E) {
 	englishLabels := getEnglishValues(entity.Labels)
 	// We are processing just English Wikidata entities for now.
@@ -1096,6 +1289,11 @@ func ConvertEntity( //nolint:maintidx
 				continue
 			}
 
+			if skipDeprecated && statement.Rank == mediawiki.Deprecated {
+				logger.Debug().Str("entity", entity.ID).Array("path", zerolog.Arr().Str(prop).Str(statement.ID)).Msg("skipping deprecated statement")
+				continue
+			}
+
 			confidence := getConfidence(entity.ID, prop, statement.ID, statement.Rank)
 			claims, errE := processSnak(
 				ctx, store, cache, namespace, prop, []interface{}{entity.ID, prop, statement.ID, "mainsnak"}, confidence, statement.MainSnak,
@@ -1110,6 +1308,11 @@ func ConvertEntity( //nolint:maintidx
 				continue
 			}
 			for j, claim := range claims {
+				errE = addRank(namespace, claim, statement.Rank)
+				if errE != nil {
+					logger.Error().Str("entity", entity.ID).Array("path", zerolog.Arr().Str(prop).Str(statement.ID).Int(j).Str("rank")).
+						Err(errE).Msg("meta claim cannot be added")
+				}
 				errE = addQualifiers(
 					ctx, logger, store, cache, namespace, claim, entity.ID, prop, statement.ID, statement.Qualifiers, statement.QualifiersOrder,
 				)
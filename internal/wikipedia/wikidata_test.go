@@ -0,0 +1,159 @@
+package wikipedia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+)
+
+func TestFormatExternalID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		formatter string
+		value     string
+		expected  string
+	}{
+		{"https://viaf.org/viaf/$1/", "113230702", "https://viaf.org/viaf/113230702/"},
+		{"https://orcid.org/$1", "0000-0002-1825-0097", "https://orcid.org/0000-0002-1825-0097"},
+		{"https://doi.org/$1", "10.1000/xyz(1)", "https://doi.org/10.1000/xyz(1)"},
+		{"https://example.com/?q=$1", "a b&c", "https://example.com/?q=a%20b%26c"},
+		{"https://example.com/", "123", ""},
+		{"$1", "123", ""},
+		{"", "123", ""},
+		{"https://example.com/$1", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.formatter, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.expected, FormatExternalID(tt.formatter, tt.value))
+		})
+	}
+}
+
+func formatterURLClaim(formatter string, confidence document.Confidence) *document.StringClaim {
+	return &document.StringClaim{
+		CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: confidence}, //nolint:exhaustruct
+		Prop:      getDocumentReference(WikidataFormatterURL, ""),
+		String:    formatter,
+	}
+}
+
+func TestProcessSnakExternalID(t *testing.T) {
+	t.Parallel()
+
+	cache, errE := es.NewCache(100)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	property := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: GetWikidataDocumentID("P214"), Score: document.LowConfidence}, //nolint:exhaustruct
+	}
+	for _, claim := range []document.Claim{
+		formatterURLClaim("https://deprecated.example.com/$1", document.NoConfidence),
+		formatterURLClaim("https://viaf.example.com/$1", document.MediumConfidence),
+		formatterURLClaim("https://preferred.example.com/$1", document.HighConfidence),
+	} {
+		errE = property.Add(claim)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	cache.Add(property.ID, property)
+	// Property without a document.
+	cache.Add(GetWikidataDocumentID("P1"), nil)
+
+	dataType := mediawiki.ExternalID
+	snak := mediawiki.Snak{ //nolint:exhaustruct
+		SnakType:  mediawiki.Value,
+		DataType:  &dataType,
+		DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue("113230702")},
+	}
+
+	claims, errE := processSnak(context.Background(), nil, cache, NameSpaceWikidata, "P214", []interface{}{"Q1", "P214", "S1"}, document.MediumConfidence, snak)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, claims, 2)
+	if assert.IsType(t, &document.IdentifierClaim{}, claims[0]) { //nolint:exhaustruct
+		assert.Equal(t, "113230702", claims[0].(*document.IdentifierClaim).Value) //nolint:forcetypeassert
+	}
+	if assert.IsType(t, &document.ReferenceClaim{}, claims[1]) { //nolint:exhaustruct
+		reference := claims[1].(*document.ReferenceClaim) //nolint:forcetypeassert
+		assert.Equal(t, "https://preferred.example.com/113230702", reference.IRI)
+		assert.Equal(t, getDocumentReference("P214", ""), reference.Prop)
+		assert.Equal(t, document.Confidence(document.MediumConfidence), reference.Confidence)
+		assert.NotEqual(t, claims[0].GetID(), reference.ID)
+	}
+
+	claims, errE = processSnak(context.Background(), nil, cache, NameSpaceWikidata, "P1", []interface{}{"Q1", "P1", "S2"}, document.MediumConfidence, snak)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Len(t, claims, 1)
+}
+
+func TestConvertEntityRanks(t *testing.T) {
+	t.Parallel()
+
+	cache, errE := es.NewCache(100)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	dataType := mediawiki.String
+	statement := func(id, value string, rank mediawiki.StatementRank) mediawiki.Statement {
+		return mediawiki.Statement{ //nolint:exhaustruct
+			ID:   id,
+			Type: mediawiki.StatementT,
+			MainSnak: mediawiki.Snak{ //nolint:exhaustruct
+				SnakType:  mediawiki.Value,
+				DataType:  &dataType,
+				DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue(value)},
+			},
+			Rank: rank,
+		}
+	}
+	entity := mediawiki.Entity{ //nolint:exhaustruct
+		ID:     "Q1",
+		Type:   mediawiki.Item,
+		Labels: map[string]mediawiki.LanguageValue{"en": {Language: "en", Value: "Universe"}},
+		Claims: map[string][]mediawiki.Statement{
+			"P1": {
+				statement("S1", "preferred", mediawiki.Preferred),
+				statement("S2", "normal", mediawiki.Normal),
+				statement("S3", "deprecated", mediawiki.Deprecated),
+			},
+		},
+	}
+
+	labels := func(claim document.Claim) []string {
+		ls := []string{}
+		for _, c := range claim.Get(document.GetCorePropertyID("LABEL")) {
+			if rel, ok := c.(*document.RelationClaim); ok {
+				ls = append(ls, rel.To.ID.String())
+			}
+		}
+		return ls
+	}
+
+	doc, errE := ConvertEntity(context.Background(), zerolog.Nop(), nil, cache, NameSpaceWikidata, entity, false)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, doc.Claims.String, 3)
+	assert.Equal(t, "preferred", doc.Claims.String[0].String)
+	assert.Equal(t, document.Confidence(document.HighConfidence), doc.Claims.String[0].Confidence)
+	assert.Equal(t, []string{document.GetCorePropertyID("WIKIDATA_PREFERRED_RANK").String()}, labels(&doc.Claims.String[0]))
+	assert.Equal(t, "normal", doc.Claims.String[1].String)
+	assert.Equal(t, document.Confidence(document.MediumConfidence), doc.Claims.String[1].Confidence)
+	assert.Empty(t, labels(&doc.Claims.String[1]))
+	assert.Equal(t, "deprecated", doc.Claims.String[2].String)
+	assert.Equal(t, document.Confidence(document.NoConfidence), doc.Claims.String[2].Confidence)
+	assert.Equal(t, []string{document.GetCorePropertyID("WIKIDATA_DEPRECATED_RANK").String()}, labels(&doc.Claims.String[2]))
+
+	doc, errE = ConvertEntity(context.Background(), zerolog.Nop(), nil, cache, NameSpaceWikidata, entity, true)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, doc.Claims.String, 2)
+	assert.Equal(t, "preferred", doc.Claims.String[0].String)
+	assert.Equal(t, "normal", doc.Claims.String[1].String)
+}
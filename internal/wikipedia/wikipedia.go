@@ -34,6 +34,11 @@ func ConvertWikipediaImage(
 	return convertImage(ctx, logger, httpClient, NameSpaceWikipediaFile, "en", "en.wikipedia.org", "ENGLISH_WIKIPEDIA", token, apiLimit, image)
 }
 
+// ConvertWikipediaArticle adds the body and the summary of the article from the edition to the document.
+// Articles from different editions about the same entity are merged: the body and the summary
+// are stored in the same claims, each under the language of its edition. The body is cleaned up
+// as configured by cleanup.
+//
 // TODO: Store the revision, license, and source used for the HTML into a meta claim.
 // TODO: Investigate how to make use of additional entities metadata. See: https://www.mediawiki.org/wiki/Topic:Wotwu75akwx2wnsb
 // TODO: Make internal links to other articles work in HTML (link to PeerDB documents instead).
@@ -41,8 +46,8 @@ func ConvertWikipediaImage(
 // TODO: Clean custom tags and attributes used in HTML to add metadata into HTML, potentially extract and store that. See: https://www.mediawiki.org/wiki/Specs/HTML/2.4.0
 // TODO: Remove some templates (e.g., infobox, top-level notices) and convert them to claims.
 // TODO: Extract all links pointing out of the article into claims and reverse claims (so if they point to other documents, they should have backlink as claim).
-func ConvertWikipediaArticle(id, html string, doc *document.D) errors.E {
-	body, article, err := ExtractArticle(html)
+func ConvertWikipediaArticle(edition Edition, cleanup Cleanup, id, html string, doc *document.D) errors.E {
+	body, article, err := ExtractArticle(html, cleanup)
 	if err != nil {
 		errE := errors.WithMessage(err, "article extraction failed")
 		errors.Details(errE)["doc"] = doc.ID.String()
@@ -50,7 +55,19 @@ func ConvertWikipediaArticle(id, html string, doc *document.D) errors.E {
 	}
 
 	claimID := document.GetID(NameSpaceWikidata, id, "ARTICLE", 0)
-	err = updateTextClaim(claimID, doc, "ARTICLE", body)
+	err = updateTextClaim(claimID, doc, "ARTICLE", edition.Language, body)
+	if err != nil {
+		return err
+	}
+
+	citations, err := ExtractCitations(html)
+	if err != nil {
+		errE := errors.WithMessage(err, "citations extraction failed")
+		errors.Details(errE)["doc"] = doc.ID.String()
+		return errE
+	}
+
+	err = addCitations(doc, doc.GetByID(claimID), edition.MnemonicPrefix(), citations)
 	if err != nil {
 		return err
 	}
@@ -84,7 +101,7 @@ func ConvertWikipediaArticle(id, html string, doc *document.D) errors.E {
 
 	// TODO: Remove summary if is now empty, but before it was not.
 	if summary != "" {
-		err := updateDescription(NameSpaceWikidata, id, "ARTICLE", 0, summary, doc)
+		err := updateDescription(NameSpaceWikidata, id, "ARTICLE", 0, edition.Language, summary, doc)
 		if err != nil {
 			return err
 		}
@@ -103,7 +120,7 @@ func ConvertFileDescription(namespace uuid.UUID, id, from, html string, doc *doc
 
 	// TODO: Remove old descriptions if there are now less of them then before.
 	for i, description := range descriptions {
-		err := updateDescription(namespace, id, from, i, description, doc)
+		err := updateDescription(namespace, id, from, i, "en", description, doc)
 		if err != nil {
 			return err
 		}
@@ -116,7 +133,7 @@ func ConvertCategoryDescription(id, from, html string, doc *document.D) errors.E
 	return convertDescription(NameSpaceWikidata, id, from, html, doc, ExtractCategoryDescription)
 }
 
-func updateTextClaim(claimID identifier.Identifier, doc *document.D, prop, value string) errors.E {
+func updateTextClaim(claimID identifier.Identifier, doc *document.D, prop, language, value string) errors.E {
 	existingClaim := doc.GetByID(claimID)
 	if existingClaim != nil {
 		claim, ok := existingClaim.(*document.TextClaim)
@@ -128,7 +145,7 @@ func updateTextClaim(claimID identifier.Identifier, doc *document.D, prop, value
 			errors.Details(errE)["expected"] = fmt.Sprintf("%T", new(document.TextClaim))
 			return errE
 		}
-		claim.HTML["en"] = value
+		claim.HTML[language] = value
 	} else {
 		claim := &document.TextClaim{
 			CoreClaim: document.CoreClaim{
@@ -137,7 +154,7 @@ func updateTextClaim(claimID identifier.Identifier, doc *document.D, prop, value
 			},
 			Prop: document.GetCorePropertyReference(prop),
 			HTML: document.TranslatableHTMLString{
-				"en": value,
+				language: value,
 			},
 		}
 		err := doc.Add(claim)
@@ -152,10 +169,65 @@ func updateTextClaim(claimID identifier.Identifier, doc *document.D, prop, value
 	return nil
 }
 
-func updateDescription(namespace uuid.UUID, id, from string, i int, description string, doc *document.D) errors.E {
+// addCitations adds citations as meta claims to the claim. Claim IDs are derived from
+// citation values so that citations which are already present are not added again.
+// TODO: Remove citations which are not cited anymore.
+func addCitations(doc *document.D, claim document.Claim, mnemonicPrefix string, citations []Citation) errors.E {
+	for _, citation := range citations {
+		metaClaims := []document.Claim{}
+		if citation.URL != "" {
+			metaClaims = append(metaClaims, &document.ReferenceClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceWikidata, claim.GetID(), mnemonicPrefix+"_CITATION_URL", citation.URL),
+					Confidence: document.HighConfidence,
+				},
+				Prop: document.GetCorePropertyReference(mnemonicPrefix + "_CITATION_URL"),
+				IRI:  citation.URL,
+			})
+		}
+		if citation.DOI != "" {
+			metaClaims = append(metaClaims, &document.IdentifierClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceWikidata, claim.GetID(), mnemonicPrefix+"_CITATION_DOI", citation.DOI),
+					Confidence: document.HighConfidence,
+				},
+				Prop:  document.GetCorePropertyReference(mnemonicPrefix + "_CITATION_DOI"),
+				Value: citation.DOI,
+			})
+		}
+		if citation.ISBN != "" {
+			metaClaims = append(metaClaims, &document.IdentifierClaim{
+				CoreClaim: document.CoreClaim{
+					ID:         document.GetID(NameSpaceWikidata, claim.GetID(), mnemonicPrefix+"_CITATION_ISBN", citation.ISBN),
+					Confidence: document.HighConfidence,
+				},
+				Prop:  document.GetCorePropertyReference(mnemonicPrefix + "_CITATION_ISBN"),
+				Value: citation.ISBN,
+			})
+		}
+
+		for _, metaClaim := range metaClaims {
+			if claim.GetByID(metaClaim.GetID()) != nil {
+				continue
+			}
+			err := claim.Add(metaClaim)
+			if err != nil {
+				errE := errors.WithMessage(err, "meta claim cannot be added")
+				errors.Details(errE)["doc"] = doc.ID.String()
+				errors.Details(errE)["claim"] = claim.GetID().String()
+				errors.Details(errE)["metaClaim"] = metaClaim.GetID().String()
+				return errE
+			}
+		}
+	}
+
+	return nil
+}
+
+func updateDescription(namespace uuid.UUID, id, from string, i int, language, description string, doc *document.D) errors.E {
 	// A slightly different construction for claimID so that it does not overlap with any other descriptions.
 	claimID := document.GetID(namespace, id, from, 0, "DESCRIPTION", i)
-	return updateTextClaim(claimID, doc, "DESCRIPTION", description)
+	return updateTextClaim(claimID, doc, "DESCRIPTION", language, description)
 }
 
 func convertDescription(namespace uuid.UUID, id, from, html string, doc *document.D, extract func(string) (string, errors.E)) errors.E {
@@ -168,7 +240,7 @@ func convertDescription(namespace uuid.UUID, id, from, html string, doc *documen
 
 	// TODO: Remove description if is now empty, but before it was not.
 	if description != "" {
-		err := updateDescription(namespace, id, from, 0, description, doc)
+		err := updateDescription(namespace, id, from, 0, "en", description, doc)
 		if err != nil {
 			return err
 		}
@@ -334,23 +406,96 @@ func convertUsedTemplate(logger zerolog.Logger, namespace uuid.UUID, mnemonicPre
 }
 
 // TODO: How to remove redirects which has previously been added but are later on removed?
-func ConvertArticleRedirects(logger zerolog.Logger, namespace uuid.UUID, id string, article mediawiki.Article, doc *document.D) errors.E {
+func ConvertArticleRedirects(logger zerolog.Logger, namespace uuid.UUID, language, id string, article mediawiki.Article, doc *document.D) errors.E {
 	for _, redirect := range article.Redirects {
-		convertRedirect(logger, namespace, id, article.Name, redirect.Name, doc)
+		convertRedirect(logger, namespace, language, id, article.Name, redirect.Name, doc)
 	}
 	return nil
 }
 
+// ConvertArticleImage adds the lead image of the article as a claim.
+//
+// Editions can disagree about the lead image, so there is one claim per image URL and
+// disagreement is expressed through confidence. The image from the primary edition has
+// high confidence and other images have their confidence lowered. An image from another
+// edition has medium confidence, or low confidence if it disagrees with existing images.
+// TODO: How to remove images which has previously been added but are later on removed?
+func ConvertArticleImage(logger zerolog.Logger, edition Edition, primary bool, id string, article mediawiki.Article, doc *document.D) errors.E {
+	if article.Image == nil || article.Image.ContentURL == "" {
+		return nil
+	}
+
+	claimID := document.GetID(NameSpaceWikidata, id, "WIKIPEDIA_ARTICLE_IMAGE_URL", article.Image.ContentURL)
+	var existingClaim *document.ReferenceClaim
+	otherClaims := []*document.ReferenceClaim{}
+	for _, claim := range doc.Get(document.GetCorePropertyID("WIKIPEDIA_ARTICLE_IMAGE_URL")) {
+		c, ok := claim.(*document.ReferenceClaim)
+		if !ok {
+			continue
+		}
+		if c.ID == claimID {
+			existingClaim = c
+		} else {
+			otherClaims = append(otherClaims, c)
+		}
+	}
+
+	if len(otherClaims) > 0 {
+		logger.Debug().Str("doc", doc.ID.String()).Str("entity", id).Str("edition", edition.Language).Str("title", article.Name).
+			Msg("editions disagree on article image")
+	}
+
+	var confidence document.Confidence = document.MediumConfidence
+	if primary {
+		confidence = document.HighConfidence
+		for _, c := range otherClaims {
+			c.Confidence = document.LowConfidence
+		}
+	} else if len(otherClaims) > 0 {
+		confidence = document.LowConfidence
+	}
+
+	if existingClaim != nil {
+		// Another edition agrees with the image, so confidence can only increase.
+		existingClaim.Confidence = max(existingClaim.Confidence, confidence)
+		return nil
+	}
+
+	claim := &document.ReferenceClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         claimID,
+			Confidence: confidence,
+		},
+		Prop: document.GetCorePropertyReference("WIKIPEDIA_ARTICLE_IMAGE_URL"),
+		IRI:  article.Image.ContentURL,
+	}
+	err := doc.Add(claim)
+	if err != nil {
+		errE := errors.WithMessage(err, "claim cannot be added")
+		errors.Details(errE)["doc"] = doc.ID.String()
+		errors.Details(errE)["claim"] = claimID.String()
+		return errE
+	}
+
+	return nil
+}
+
 // TODO: How to remove redirects which has previously been added but are later on removed?
 func ConvertPageRedirects(logger zerolog.Logger, namespace uuid.UUID, id string, page AllPagesPage, doc *document.D) errors.E {
 	for _, redirect := range page.Redirects {
-		convertRedirect(logger, namespace, id, page.Title, redirect.Title, doc)
+		convertRedirect(logger, namespace, "en", id, page.Title, redirect.Title, doc)
 	}
 	return nil
 }
 
-func convertRedirect(logger zerolog.Logger, namespace uuid.UUID, id, title, redirect string, doc *document.D) {
-	claimID := document.GetID(namespace, id, "NAME", redirect)
+func convertRedirect(logger zerolog.Logger, namespace uuid.UUID, language, id, title, redirect string, doc *document.D) {
+	args := []interface{}{id, "NAME", redirect}
+	if language != EnglishEdition.Language {
+		// The same redirect can exist in multiple editions. We do not include
+		// the language for English so that existing claim IDs do not change.
+		args = append(args, language)
+	}
+	claimID := document.GetID(namespace, args...)
 	existingClaim := doc.GetByID(claimID)
 	if existingClaim != nil {
 		return
@@ -358,7 +503,7 @@ func convertRedirect(logger zerolog.Logger, namespace uuid.UUID, id, title, redi
 	escapedName := html.EscapeString(strings.ReplaceAll(redirect, "_", " "))
 	found := false
 	for _, claim := range doc.Get(document.GetCorePropertyID("NAME")) {
-		if c, ok := claim.(*document.TextClaim); ok && c.HTML["en"] == escapedName {
+		if c, ok := claim.(*document.TextClaim); ok && c.HTML[language] == escapedName {
 			found = true
 			break
 		}
@@ -373,7 +518,7 @@ func convertRedirect(logger zerolog.Logger, namespace uuid.UUID, id, title, redi
 		},
 		Prop: document.GetCorePropertyReference("NAME"),
 		HTML: document.TranslatableHTMLString{
-			"en": escapedName,
+			language: escapedName,
 		},
 	}
 	errE := doc.Add(claim)
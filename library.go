@@ -0,0 +1,212 @@
+package peerdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// New returns a service for using PeerDB from Go programs without running the HTTP server.
+//
+// It uses PostgreSQL and ElasticSearch configuration from globals for a single site with
+// globals.Postgres.Schema schema and globals.Elastic.Index index. Schema and index are created
+// if they do not yet exist. Resources are released once ctx is canceled.
+func New(ctx context.Context, globals *Globals) (*Service, errors.E) {
+	dbpool, errE := internal.InitPostgres(ctx, string(globals.Postgres.URL), globals.Logger, getRequestWithFallback(globals.Logger))
+	if errE != nil {
+		return nil, errE
+	}
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), globals.Logger, globals.Elastic.URL)
+	if errE != nil {
+		return nil, errE
+	}
+
+	site := &Site{
+		Site: waf.Site{
+			Domain:   "",
+			CertFile: "",
+			KeyFile:  "",
+		},
+		Build:           nil,
+		Index:           globals.Elastic.Index,
+		Schema:          globals.Postgres.Schema,
+		Title:           DefaultTitle,
+		SizeField:       globals.Elastic.SizeField,
+		store:           nil,
+		coordinator:     nil,
+		storage:         nil,
+		esProcessor:     nil,
+		references:      nil,
+		generations:     nil,
+		synonyms:        nil,
+		redirects:       nil,
+		redirectMap:     nil,
+		sitemaps:        nil,
+		slowQueries:     nil,
+		reloadable:      nil,
+		propertiesTotal: 0,
+	}
+
+	// We set fallback context values which are used to set application name on PostgreSQL connections.
+	siteCtx := context.WithValue(ctx, requestIDContextKey, "library")
+	siteCtx = context.WithValue(siteCtx, schemaContextKey, site.Schema)
+
+	site.store, site.coordinator, site.storage, site.esProcessor, site.references, site.generations, _, errE = es.InitForSite(
+		siteCtx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField, site.RestrictedProperties,
+	)
+	if errE != nil {
+		return nil, errE
+	}
+
+	service := &Service{ //nolint:exhaustruct
+		Service: waf.Service[*Site]{ //nolint:exhaustruct
+			Logger:          globals.Logger,
+			CanonicalLogger: globals.Logger,
+			WithContext:     globals.WithContext,
+			Sites: map[string]*Site{
+				site.Domain: site,
+			},
+		},
+		esClient: esClient,
+	}
+
+	errE = service.populatePropertiesTotal(ctx)
+	if errE != nil {
+		return nil, errE
+	}
+
+	errE = service.populatePropertyRegistries(ctx)
+	if errE != nil {
+		return nil, errE
+	}
+
+	return service, nil
+}
+
+// librarySite returns the only site of the service.
+func (s *Service) librarySite() (*Site, errors.E) {
+	if len(s.Sites) != 1 {
+		errE := errors.New("service has to have exactly one site")
+		errors.Details(errE)["sites"] = len(s.Sites)
+		return nil, errE
+	}
+	for _, site := range s.Sites {
+		return site, nil
+	}
+	panic(errors.New("not reachable"))
+}
+
+// Search searches documents using the search query and returns IDs of up to
+// search.MaxResultsCount matching documents, ordered by relevance, together with the
+// total number of matching documents.
+func (s *Service) Search(ctx context.Context, query string) ([]identifier.Identifier, int64, errors.E) {
+	site, errE := s.librarySite()
+	if errE != nil {
+		return nil, 0, errE
+	}
+
+	return s.searchDocuments(ctx, site, query)
+}
+
+// searchDocuments is like Search, but for the given site.
+func (s *Service) searchDocuments(ctx context.Context, site *Site, query string) ([]identifier.Identifier, int64, errors.E) {
+	state := search.State{ //nolint:exhaustruct
+		SearchQuery: query,
+	}
+
+	res, err := s.esClient.Search(site.Index).FetchSource(false).TrackTotalHits(true).AllowPartialSearchResults(false).
+		From(0).Size(search.MaxResultsCount).Query(state.Query()).SortBy(search.Sorters(nil, nil, nil)...).Do(ctx)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	results := make([]identifier.Identifier, 0, len(res.Hits.Hits))
+	for _, hit := range res.Hits.Hits {
+		id, errE := identifier.FromString(hit.Id)
+		if errE != nil {
+			errors.Details(errE)["id"] = hit.Id
+			return nil, 0, errE
+		}
+		results = append(results, id)
+	}
+
+	return results, res.Hits.TotalHits.Value, nil
+}
+
+// GetDocument returns the latest version of the document with the given ID.
+func (s *Service) GetDocument(ctx context.Context, id identifier.Identifier) (*document.D, store.Version, errors.E) {
+	site, errE := s.librarySite()
+	if errE != nil {
+		return nil, store.Version{}, errE
+	}
+
+	data, _, version, errE := site.store.GetLatest(ctx, id)
+	if errE != nil {
+		return nil, store.Version{}, errE
+	}
+
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return nil, store.Version{}, errE
+	}
+
+	return &doc, version, nil
+}
+
+// IndexDocuments inserts documents which do not yet exist and updates existing ones.
+//
+// Documents are indexed in ElasticSearch asynchronously. Use Flush to wait for them to be indexed.
+func (s *Service) IndexDocuments(ctx context.Context, docs []*document.D) errors.E {
+	site, errE := s.librarySite()
+	if errE != nil {
+		return errE
+	}
+
+	errE = site.Quota.Check(ctx)
+	if errE != nil {
+		return errE
+	}
+
+	for _, doc := range docs {
+		_, errE := upsertDocument(ctx, site.store, doc)
+		if errE != nil {
+			errors.Details(errE)["doc"] = doc.ID.String()
+			return errE
+		}
+	}
+
+	return nil
+}
+
+// Flush waits for all documents to be indexed and makes them available for search.
+func (s *Service) Flush(ctx context.Context) errors.E {
+	site, errE := s.librarySite()
+	if errE != nil {
+		return errE
+	}
+
+	// We sleep to make sure all changesets are bridged.
+	time.Sleep(time.Second)
+
+	// Make sure all just indexed documents are available for search.
+	err := site.esProcessor.Flush()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = s.esClient.Refresh(site.Index).Do(ctx)
+	return errors.WithStack(err)
+}
@@ -0,0 +1,121 @@
+package peerdb
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+const gtinLength = 14
+
+// normalizeGTIN validates a GTIN-8, GTIN-12 (UPC), GTIN-13 (EAN), or GTIN-14 code
+// and returns it as a GTIN-14 code (padded with leading zeros).
+//
+// Spaces and dashes in the code are ignored.
+func normalizeGTIN(code string) (string, errors.E) {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+
+	switch len(code) {
+	case 8, 12, 13, gtinLength: //nolint:mnd
+	default:
+		errE := errors.New("invalid GTIN length")
+		errors.Details(errE)["code"] = code
+		return "", errE
+	}
+
+	code = strings.Repeat("0", gtinLength-len(code)) + code
+
+	sum := 0
+	for i, c := range code {
+		if c < '0' || c > '9' {
+			errE := errors.New("GTIN contains non-digits")
+			errors.Details(errE)["code"] = code
+			return "", errE
+		}
+		d := int(c - '0')
+		if i == gtinLength-1 {
+			break
+		}
+		// Digits are weighted alternately by 3 and 1, starting with 3 at the leftmost digit of GTIN-14.
+		if i%2 == 0 {
+			sum += 3 * d //nolint:mnd
+		} else {
+			sum += d
+		}
+	}
+	if (10-sum%10)%10 != int(code[gtinLength-1]-'0') { //nolint:mnd
+		errE := errors.New("invalid GTIN check digit")
+		errors.Details(errE)["code"] = code
+		return "", errE
+	}
+
+	return code, nil
+}
+
+type lookupResult struct {
+	ID string `json:"id"`
+}
+
+// LookupGTINGet is a GET/HEAD HTTP request handler which returns IDs of documents
+// with GTIN identifier claim matching the GTIN code given as a parameter.
+//
+// GTIN-8, GTIN-12 (UPC), GTIN-13 (EAN), and GTIN-14 variants of the same code
+// all match because leading zeros are ignored when matching identifier claims.
+func (s *Service) LookupGTINGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	code, errE := normalizeGTIN(params["code"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"code" is not a valid GTIN`))
+		return
+	}
+
+	query := elastic.NewNestedQuery("claims.id", elastic.NewBoolQuery().Must(
+		elastic.NewTermQuery("claims.id.prop.id", document.GetCorePropertyID("GTIN")),
+		elastic.NewTermQuery("claims.id.value", code),
+	))
+
+	searchService, _ := s.getSearchService(req)
+	searchService = searchService.From(0).Size(search.MaxResultsCount).Query(query)
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	res, err := searchService.Do(ctx)
+	m.Stop()
+	if err != nil {
+		s.InternalServerErrorWithError(w, req, errors.WithStack(err))
+		return
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
+
+	if len(res.Hits.Hits) == 0 {
+		s.NotFound(w, req)
+		return
+	}
+
+	results := make([]lookupResult, len(res.Hits.Hits))
+	for i, hit := range res.Hits.Hits {
+		results[i] = lookupResult{ID: hit.Id}
+	}
+
+	// Total is a string or a number.
+	var total interface{}
+	if res.Hits.TotalHits.Relation == "gte" {
+		total = fmt.Sprintf("+%d", res.Hits.TotalHits.Value)
+	} else {
+		total = res.Hits.TotalHits.Value
+	}
+
+	s.WriteJSON(w, req, results, map[string]interface{}{
+		"total": total,
+	})
+}
@@ -0,0 +1,54 @@
+package peerdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeGTIN(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		code     string
+		expected string
+		err      string
+	}{
+		// EAN-8.
+		{"96385074", "00000096385074", ""},
+		{"96385075", "", "invalid GTIN check digit"},
+		// UPC-A.
+		{"036000291452", "00036000291452", ""},
+		{"036000291453", "", "invalid GTIN check digit"},
+		// EAN-13.
+		{"4006381333931", "04006381333931", ""},
+		{"978-0-306-40615-7", "09780306406157", ""},
+		{"4006381333932", "", "invalid GTIN check digit"},
+		// GTIN-14.
+		{"10614141000415", "10614141000415", ""},
+		{"00036000291452", "00036000291452", ""},
+		{"10614141000416", "", "invalid GTIN check digit"},
+		// Spaces and dashes are ignored.
+		{"4 006381 333931", "04006381333931", ""},
+		// Check digit 0.
+		{"00000000000000", "00000000000000", ""},
+		{"", "", "invalid GTIN length"},
+		{"1234567", "", "invalid GTIN length"},
+		{"123456789", "", "invalid GTIN length"},
+		{"036000291452000", "", "invalid GTIN length"},
+		{"0360002914a2", "", "GTIN contains non-digits"},
+		{"4006381333931X", "", "GTIN contains non-digits"},
+	} {
+		t.Run(tt.code, func(t *testing.T) {
+			t.Parallel()
+
+			code, errE := normalizeGTIN(tt.code)
+			if tt.err != "" {
+				assert.EqualError(t, errE, tt.err)
+			} else {
+				assert.NoError(t, errE, "% -+#.1v", errE)
+			}
+			assert.Equal(t, tt.expected, code)
+		})
+	}
+}
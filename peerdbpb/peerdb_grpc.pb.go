@@ -0,0 +1,250 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: peerdb.proto
+
+// Package peerdb.v1 is the gRPC API of PeerDB. It exposes core operations of a site
+// to internal services which prefer typed clients and streaming bulk ingestion to the HTTP API.
+
+package peerdbpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PeerDB_GetDocument_FullMethodName    = "/peerdb.v1.PeerDB/GetDocument"
+	PeerDB_Search_FullMethodName         = "/peerdb.v1.PeerDB/Search"
+	PeerDB_BulkIndex_FullMethodName      = "/peerdb.v1.PeerDB/BulkIndex"
+	PeerDB_ListProperties_FullMethodName = "/peerdb.v1.PeerDB/ListProperties"
+)
+
+// PeerDBClient is the client API for PeerDB service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// PeerDB is the gRPC service of a PeerDB site. The site is determined by the authority
+// (host) of the request. The caller's role is determined by the bearer token in the
+// "authorization" metadata, the same as with the HTTP API.
+type PeerDBClient interface {
+	// GetDocument returns a version of the document, by default the latest one.
+	GetDocument(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*Document, error)
+	// Search returns IDs of documents matching the search query, ordered by relevance.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// BulkIndex inserts documents which do not yet exist and updates existing ones.
+	// It requires the elevated role.
+	BulkIndex(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[BulkIndexRequest, BulkIndexResponse], error)
+	// ListProperties returns properties known to the site together with their data types.
+	ListProperties(ctx context.Context, in *ListPropertiesRequest, opts ...grpc.CallOption) (*ListPropertiesResponse, error)
+}
+
+type peerDBClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPeerDBClient(cc grpc.ClientConnInterface) PeerDBClient {
+	return &peerDBClient{cc}
+}
+
+func (c *peerDBClient) GetDocument(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*Document, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Document)
+	err := c.cc.Invoke(ctx, PeerDB_GetDocument_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerDBClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, PeerDB_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerDBClient) BulkIndex(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[BulkIndexRequest, BulkIndexResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PeerDB_ServiceDesc.Streams[0], PeerDB_BulkIndex_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BulkIndexRequest, BulkIndexResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PeerDB_BulkIndexClient = grpc.ClientStreamingClient[BulkIndexRequest, BulkIndexResponse]
+
+func (c *peerDBClient) ListProperties(ctx context.Context, in *ListPropertiesRequest, opts ...grpc.CallOption) (*ListPropertiesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListPropertiesResponse)
+	err := c.cc.Invoke(ctx, PeerDB_ListProperties_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PeerDBServer is the server API for PeerDB service.
+// All implementations must embed UnimplementedPeerDBServer
+// for forward compatibility.
+//
+// PeerDB is the gRPC service of a PeerDB site. The site is determined by the authority
+// (host) of the request. The caller's role is determined by the bearer token in the
+// "authorization" metadata, the same as with the HTTP API.
+type PeerDBServer interface {
+	// GetDocument returns a version of the document, by default the latest one.
+	GetDocument(context.Context, *GetDocumentRequest) (*Document, error)
+	// Search returns IDs of documents matching the search query, ordered by relevance.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// BulkIndex inserts documents which do not yet exist and updates existing ones.
+	// It requires the elevated role.
+	BulkIndex(grpc.ClientStreamingServer[BulkIndexRequest, BulkIndexResponse]) error
+	// ListProperties returns properties known to the site together with their data types.
+	ListProperties(context.Context, *ListPropertiesRequest) (*ListPropertiesResponse, error)
+	mustEmbedUnimplementedPeerDBServer()
+}
+
+// UnimplementedPeerDBServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPeerDBServer struct{}
+
+func (UnimplementedPeerDBServer) GetDocument(context.Context, *GetDocumentRequest) (*Document, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetDocument not implemented")
+}
+func (UnimplementedPeerDBServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedPeerDBServer) BulkIndex(grpc.ClientStreamingServer[BulkIndexRequest, BulkIndexResponse]) error {
+	return status.Error(codes.Unimplemented, "method BulkIndex not implemented")
+}
+func (UnimplementedPeerDBServer) ListProperties(context.Context, *ListPropertiesRequest) (*ListPropertiesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListProperties not implemented")
+}
+func (UnimplementedPeerDBServer) mustEmbedUnimplementedPeerDBServer() {}
+func (UnimplementedPeerDBServer) testEmbeddedByValue()                {}
+
+// UnsafePeerDBServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PeerDBServer will
+// result in compilation errors.
+type UnsafePeerDBServer interface {
+	mustEmbedUnimplementedPeerDBServer()
+}
+
+func RegisterPeerDBServer(s grpc.ServiceRegistrar, srv PeerDBServer) {
+	// If the following call panics, it indicates UnimplementedPeerDBServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PeerDB_ServiceDesc, srv)
+}
+
+func _PeerDB_GetDocument_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerDBServer).GetDocument(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerDB_GetDocument_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerDBServer).GetDocument(ctx, req.(*GetDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PeerDB_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerDBServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerDB_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerDBServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PeerDB_BulkIndex_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PeerDBServer).BulkIndex(&grpc.GenericServerStream[BulkIndexRequest, BulkIndexResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PeerDB_BulkIndexServer = grpc.ClientStreamingServer[BulkIndexRequest, BulkIndexResponse]
+
+func _PeerDB_ListProperties_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPropertiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerDBServer).ListProperties(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerDB_ListProperties_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerDBServer).ListProperties(ctx, req.(*ListPropertiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PeerDB_ServiceDesc is the grpc.ServiceDesc for PeerDB service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PeerDB_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "peerdb.v1.PeerDB",
+	HandlerType: (*PeerDBServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetDocument",
+			Handler:    _PeerDB_GetDocument_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _PeerDB_Search_Handler,
+		},
+		{
+			MethodName: "ListProperties",
+			Handler:    _PeerDB_ListProperties_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BulkIndex",
+			Handler:       _PeerDB_BulkIndex_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "peerdb.proto",
+}
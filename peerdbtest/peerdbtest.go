@@ -0,0 +1,265 @@
+// Package peerdbtest provides a harness for integration tests against a real PeerDB stack.
+//
+// It starts PeerDB HTTP server backed by PostgreSQL and ElasticSearch, bootstraps
+// the schema and the index (with core properties), indexes fixture documents, and
+// provides helpers for API-level tests. PostgreSQL and ElasticSearch are expected
+// to run (e.g., in Docker) at URLs in POSTGRES and ELASTIC environment variables.
+// Tests are skipped when those are not set. Every stack uses a new PostgreSQL
+// schema and a new ElasticSearch index, so tests can run in parallel.
+package peerdbtest
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+	z "gitlab.com/tozd/go/zerolog"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb"
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+//nolint:exhaustruct,gochecknoglobals
+var files = fstest.MapFS{
+	"dist/index.html": &fstest.MapFile{
+		Data: []byte("<html><body>peerdbtest</body></html>"),
+	},
+}
+
+// Config configures the stack.
+type Config struct {
+	// ReadOnly runs the server in read-only mode. Fixtures are indexed before.
+	ReadOnly bool
+
+	// Documents are fixture documents indexed before the server starts.
+	Documents []*document.D
+}
+
+// Stack is a running PeerDB HTTP server with its PostgreSQL schema and ElasticSearch index.
+type Stack struct {
+	Globals *peerdb.Globals
+	Service *peerdb.Service
+	Server  *httptest.Server
+}
+
+// Start starts a stack for the test. The stack is stopped when the test finishes.
+// The test is skipped if POSTGRES or ELASTIC environment variables are not set.
+func Start(t testing.TB, config Config) *Stack {
+	t.Helper()
+
+	if os.Getenv("ELASTIC") == "" {
+		t.Skip("ELASTIC is not available")
+	}
+	if os.Getenv("POSTGRES") == "" {
+		t.Skip("POSTGRES is not available")
+	}
+
+	tempDir := t.TempDir()
+	certPath := filepath.Join(tempDir, "test_cert.pem")
+	keyPath := filepath.Join(tempDir, "test_key.pem")
+
+	errE := x.CreateTempCertificateFiles(certPath, keyPath, []string{"localhost"})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+
+	globals := &peerdb.Globals{ //nolint:exhaustruct
+		LoggingConfig: z.LoggingConfig{ //nolint:exhaustruct
+			Logger: logger,
+		},
+		Postgres: peerdb.PostgresConfig{
+			URL:    []byte(os.Getenv("POSTGRES")),
+			Schema: identifier.New().String(),
+		},
+		Elastic: peerdb.ElasticConfig{
+			URL:       os.Getenv("ELASTIC"),
+			Index:     strings.ToLower(identifier.New().String()),
+			SizeField: false,
+		},
+	}
+
+	// Creates the schema and the index and saves core properties.
+	populate := peerdb.PopulateCommand{}
+	errE = populate.Run(globals)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if len(config.Documents) > 0 {
+		// Fixtures are indexed through the library API so that they are indexed
+		// also when the server is in read-only mode.
+		service, errE := peerdb.New(ctx, globals)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		errE = service.IndexDocuments(ctx, config.Documents)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		errE = service.Flush(ctx)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	serve := peerdb.ServeCommand{ //nolint:exhaustruct
+		Server: waf.Server[*peerdb.Site]{ //nolint:exhaustruct
+			TLS: waf.TLS{ //nolint:exhaustruct
+				CertFile: certPath,
+				KeyFile:  keyPath,
+			},
+			Development: true,
+			// httptest.Server allocates a random port for its listener (but does not use serve.Server.Addr to do so).
+			// Having 0 for port here makes the rest of the codebase expect a random port and wait for its assignment.
+			Addr: "localhost:0",
+		},
+		Title:    peerdb.DefaultTitle,
+		ReadOnly: config.ReadOnly,
+	}
+
+	handler, service, errE := serve.Init(ctx, globals, files)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	t.Cleanup(ts.Close)
+
+	ts.Config = serve.Server.HTTPServer
+	ts.Config.Handler = handler
+	ts.TLS = serve.Server.HTTPServer.TLSConfig.Clone()
+
+	// We have to call GetCertificate ourselves.
+	// See: https://github.com/golang/go/issues/63812
+	cert, err := ts.TLS.GetCertificate(&tls.ClientHelloInfo{ //nolint:exhaustruct
+		ServerName: "localhost",
+	})
+	require.NoError(t, err, "% -+#.1v", err)
+	// By setting Certificates, we force testing server and testing client to use our certificate.
+	ts.TLS.Certificates = []tls.Certificate{*cert}
+
+	// This does not start server's managers, but that is OK for tests.
+	ts.StartTLS()
+
+	// Our certificate is for localhost domain and not 127.0.0.1 IP.
+	ts.URL = strings.ReplaceAll(ts.URL, "127.0.0.1", "localhost")
+
+	return &Stack{
+		Globals: globals,
+		Service: service,
+		Server:  ts,
+	}
+}
+
+// LoadDocuments loads fixture documents from a JSON file with an array of documents.
+func LoadDocuments(t testing.TB, path string) []*document.D {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var docs []*document.D
+	errE := x.UnmarshalWithoutUnknownFields(data, &docs)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	return docs
+}
+
+// Index indexes documents and waits for them to be available for search.
+// It fails in read-only mode.
+func (s *Stack) Index(t testing.TB, docs ...*document.D) {
+	t.Helper()
+
+	errE := s.Service.IndexDocuments(context.Background(), docs)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = s.Service.Flush(context.Background())
+	require.NoError(t, errE, "% -+#.1v", errE)
+}
+
+// URL returns the full URL of the API route.
+func (s *Stack) URL(t testing.TB, route string, params waf.Params, qs url.Values) string {
+	t.Helper()
+
+	path, errE := s.Service.ReverseAPI(route, params, qs)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	return s.Server.URL + path
+}
+
+// Do makes the request to the server. The response body is closed when the test finishes.
+func (s *Stack) Do(t testing.TB, req *http.Request) *http.Response {
+	t.Helper()
+
+	resp, err := s.Server.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// Get makes a GET request to the API route.
+func (s *Stack) Get(t testing.TB, route string, params waf.Params, qs url.Values) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, s.URL(t, route, params, qs), nil)
+	require.NoError(t, err)
+
+	return s.Do(t, req)
+}
+
+// PostForm makes a POST request with form values to the API route.
+func (s *Stack) PostForm(t testing.TB, route string, params waf.Params, form url.Values) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(
+		context.Background(), http.MethodPost, s.URL(t, route, params, nil), strings.NewReader(form.Encode()),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return s.Do(t, req)
+}
+
+// GetJSON makes a GET request to the API route, requires the 200 (OK) HTTP code,
+// and decodes the JSON response into v.
+func (s *Stack) GetJSON(t testing.TB, route string, params waf.Params, qs url.Values, v interface{}) {
+	t.Helper()
+
+	resp := s.Get(t, route, params, qs)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	err := json.NewDecoder(resp.Body).Decode(v)
+	require.NoError(t, err)
+}
+
+// Search creates a search state for the search query and returns IDs of found documents.
+func (s *Stack) Search(t testing.TB, query string) []identifier.Identifier {
+	t.Helper()
+
+	resp := s.PostForm(t, "SearchCreate", nil, url.Values{"q": {query}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var state struct {
+		ID identifier.Identifier `json:"s"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&state)
+	require.NoError(t, err)
+
+	var results []struct {
+		ID identifier.Identifier `json:"id"`
+	}
+	s.GetJSON(t, "SearchResults", waf.Params{"s": state.ID.String()}, nil, &results)
+
+	ids := make([]identifier.Identifier, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	return ids
+}
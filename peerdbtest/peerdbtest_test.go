@@ -0,0 +1,50 @@
+package peerdbtest_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/peerdbtest"
+)
+
+func TestStack(t *testing.T) {
+	t.Parallel()
+
+	docs := peerdbtest.LoadDocuments(t, "testdata/documents.json")
+	require.Len(t, docs, 2)
+
+	stack := peerdbtest.Start(t, peerdbtest.Config{ReadOnly: false, Documents: docs})
+
+	assert.Equal(t, []identifier.Identifier{docs[0].ID}, stack.Search(t, "lighthouse"))
+	assert.Equal(t, []identifier.Identifier{docs[1].ID}, stack.Search(t, "railway"))
+
+	var doc document.D
+	stack.GetJSON(t, "DocumentGet", waf.Params{"id": docs[0].ID.String()}, nil, &doc)
+	assert.Equal(t, docs[0].ID, doc.ID)
+
+	resp := stack.Get(t, "DocumentGet", waf.Params{"id": identifier.New().String()}, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Documents can also be indexed after the stack started.
+	name := document.GetCorePropertyReference("NAME")
+	extra := &document.D{ //nolint:exhaustruct
+		CoreDocument: document.CoreDocument{ID: identifier.New(), Score: document.LowConfidence}, //nolint:exhaustruct
+		Claims: &document.ClaimTypes{ //nolint:exhaustruct
+			Text: document.TextClaims{
+				{
+					CoreClaim: document.CoreClaim{ID: identifier.New(), Confidence: document.HighConfidence}, //nolint:exhaustruct
+					Prop:      name,
+					HTML:      document.TranslatableHTMLString{"en": "Harbour crane"},
+				},
+			},
+		},
+	}
+	stack.Index(t, extra)
+	assert.Equal(t, []identifier.Identifier{extra.ID}, stack.Search(t, "crane"))
+}
@@ -0,0 +1,153 @@
+package peerdb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+// personalizationCookie is the name of the cookie identifying callers
+// without an API key for personalization.
+const personalizationCookie = "personalization"
+
+// personalizationOptOut returns true if the caller opted out of tracking
+// using Do Not Track or Global Privacy Control request headers.
+func personalizationOptOut(req *http.Request) bool {
+	return req.Header.Get("DNT") == "1" || req.Header.Get("Sec-GPC") == "1"
+}
+
+// signCookieValue returns value signed with the secret of the service.
+func (s *Service) signCookieValue(value string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCookieValue returns the value of a signed cookie value and true if the signature is valid.
+func (s *Service) verifyCookieValue(signed string) (string, bool) {
+	value, _, ok := strings.Cut(signed, ".")
+	if !ok {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signed), []byte(s.signCookieValue(value))) {
+		return "", false
+	}
+	return value, true
+}
+
+// personalizationSession returns the personalization session ID from the signed
+// personalization cookie of the request, if the request has a valid one.
+func (s *Service) personalizationSession(req *http.Request) (identifier.Identifier, bool) {
+	cookie, err := req.Cookie(personalizationCookie)
+	if err != nil {
+		return identifier.Identifier{}, false
+	}
+	value, ok := s.verifyCookieValue(cookie.Value)
+	if !ok {
+		return identifier.Identifier{}, false
+	}
+	id, errE := identifier.FromString(value)
+	if errE != nil {
+		return identifier.Identifier{}, false
+	}
+	return id, true
+}
+
+// setPersonalizationCookie sets the signed personalization cookie with the session ID on the response.
+// If session is nil, the cookie is removed.
+func (s *Service) setPersonalizationCookie(w http.ResponseWriter, session *identifier.Identifier) {
+	cookie := &http.Cookie{ //nolint:exhaustruct
+		Name:     personalizationCookie,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if session == nil {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Value = s.signCookieValue(session.String())
+		cookie.MaxAge = int(search.DefaultPersonalizationTTL.Seconds())
+	}
+	http.SetCookie(w, cookie)
+	// Responses setting the cookie must not be stored by shared caches.
+	w.Header().Set("Cache-Control", "private, no-cache")
+}
+
+// personalizationUser returns the user for which the request should be personalized and true,
+// if personalization is enabled and the caller has not opted out.
+//
+// Callers with an API key are identified by their API key (see apiKey). Other callers are
+// identified by a session ID stored in a signed personalization cookie. If w is not nil and
+// such a caller does not have a valid cookie, a new session is started and the cookie is set
+// on the response. Cookies are not set on responses which might be cached by shared caches,
+// so callers obtain them when they first request personalized search results.
+//
+// When the caller opted out, its interaction history is forgotten (and the cookie
+// removed, if w is not nil).
+func (s *Service) personalizationUser(w http.ResponseWriter, req *http.Request) (string, bool) {
+	if s.personalization == nil {
+		return "", false
+	}
+	user := apiKey(req)
+	if user == publicAPIKey {
+		session, ok := s.personalizationSession(req)
+		switch {
+		case ok:
+			user = "session-" + session.String()
+		case w == nil || personalizationOptOut(req):
+			return "", false
+		default:
+			session = identifier.New()
+			s.setPersonalizationCookie(w, &session)
+			return "session-" + session.String(), true
+		}
+	}
+	if personalizationOptOut(req) {
+		s.personalization.Forget(user)
+		if w != nil && strings.HasPrefix(user, "session-") {
+			s.setPersonalizationCookie(w, nil)
+		}
+		return "", false
+	}
+	return user, true
+}
+
+// recordDocumentView records that the caller viewed the document, for personalization.
+func (s *Service) recordDocumentView(req *http.Request, data json.RawMessage) errors.E {
+	// Document responses can be cached, so we do not set the cookie on them.
+	user, ok := s.personalizationUser(nil, req)
+	if !ok {
+		return nil
+	}
+	var doc document.D
+	errE := x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return errE
+	}
+	s.personalization.RecordView(user, &doc)
+	return nil
+}
+
+// personalizeQuery returns true if "personalize" parameter is set to true.
+func personalizeQuery(req *http.Request) (bool, errors.E) {
+	if !req.Form.Has("personalize") {
+		return false, nil
+	}
+	personalize, err := strconv.ParseBool(req.Form.Get("personalize"))
+	if err != nil {
+		return false, errors.WithMessage(err, `"personalize" is not a valid boolean`)
+	}
+	return personalize, nil
+}
@@ -0,0 +1,97 @@
+package peerdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+// responseCookies returns cookies set on the response.
+func responseCookies(t *testing.T, w *httptest.ResponseRecorder) []*http.Cookie {
+	t.Helper()
+
+	cookies := []*http.Cookie{}
+	for _, line := range w.Header().Values("Set-Cookie") {
+		cookie, err := http.ParseSetCookie(line)
+		require.NoError(t, err)
+		cookies = append(cookies, cookie)
+	}
+	return cookies
+}
+
+func TestPersonalizationUser(t *testing.T) {
+	t.Parallel()
+
+	s := &Service{ //nolint:exhaustruct
+		secret:          []byte("secret"),
+		personalization: &search.Personalization{}, //nolint:exhaustruct
+	}
+
+	// Callers without an API key and a cookie obtain a new session.
+	req := httptest.NewRequest(http.MethodGet, "/api/s/foo", nil)
+	w := httptest.NewRecorder()
+	user, ok := s.personalizationUser(w, req)
+	require.True(t, ok)
+	assert.True(t, strings.HasPrefix(user, "session-"), user)
+	cookies := responseCookies(t, w)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, personalizationCookie, cookies[0].Name)
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+
+	// The session is identified by the cookie.
+	req = httptest.NewRequest(http.MethodGet, "/api/s/foo", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	sessionUser, ok := s.personalizationUser(w, req)
+	require.True(t, ok)
+	assert.Equal(t, user, sessionUser)
+	assert.Empty(t, responseCookies(t, w))
+
+	// Without a response, new sessions are not started.
+	req = httptest.NewRequest(http.MethodGet, "/api/d/foo", nil)
+	_, ok = s.personalizationUser(nil, req)
+	assert.False(t, ok)
+	req.AddCookie(cookies[0])
+	sessionUser, ok = s.personalizationUser(nil, req)
+	require.True(t, ok)
+	assert.Equal(t, user, sessionUser)
+
+	// Cookies with an invalid signature are ignored.
+	req = httptest.NewRequest(http.MethodGet, "/api/d/foo", nil)
+	req.AddCookie(&http.Cookie{Name: personalizationCookie, Value: strings.TrimPrefix(user, "session-") + ".invalid"}) //nolint:exhaustruct
+	_, ok = s.personalizationUser(nil, req)
+	assert.False(t, ok)
+
+	// Callers with an API key are identified by it.
+	req = httptest.NewRequest(http.MethodGet, "/api/s/foo", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	w = httptest.NewRecorder()
+	keyUser, ok := s.personalizationUser(w, req)
+	require.True(t, ok)
+	assert.Equal(t, apiKey(req), keyUser)
+	assert.Empty(t, responseCookies(t, w))
+
+	// Opting out removes the cookie.
+	req = httptest.NewRequest(http.MethodGet, "/api/s/foo", nil)
+	req.Header.Set("DNT", "1")
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	_, ok = s.personalizationUser(w, req)
+	assert.False(t, ok)
+	removed := responseCookies(t, w)
+	require.Len(t, removed, 1)
+	assert.Negative(t, removed[0].MaxAge)
+
+	// Personalization is disabled.
+	s = &Service{secret: []byte("secret")} //nolint:exhaustruct
+	req = httptest.NewRequest(http.MethodGet, "/api/s/foo", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	_, ok = s.personalizationUser(httptest.NewRecorder(), req)
+	assert.False(t, ok)
+}
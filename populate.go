@@ -13,6 +13,7 @@ import (
 	"github.com/olivere/elastic/v7"
 	"github.com/rs/zerolog"
 	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
 
 	"gitlab.com/peerdb/peerdb/document"
 	"gitlab.com/peerdb/peerdb/internal/es"
@@ -21,11 +22,30 @@ import (
 	"gitlab.com/peerdb/peerdb/store"
 )
 
+// reportMnemonicCollisions logs mnemonics of core properties registered with different
+// meanings by multiple namespaces (e.g., importers). Such properties are saved under
+// namespaced mnemonics, so documents referencing them by mnemonic without a namespace
+// might reference an unintended property.
+func reportMnemonicCollisions(logger zerolog.Logger) {
+	for _, collision := range document.MnemonicCollisions() {
+		namespaces := []string{}
+		mnemonics := []string{}
+		for _, registration := range collision.Registrations {
+			namespaces = append(namespaces, registration.Namespace)
+			mnemonics = append(mnemonics, registration.Mnemonic)
+		}
+		logger.Warn().Str("mnemonic", collision.Mnemonic).Strs("namespaces", namespaces).Strs("mnemonics", mnemonics).
+			Msg("mnemonic collision")
+	}
+}
+
 func SaveCoreProperties(
 	ctx context.Context, logger zerolog.Logger,
 	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	esClient *elastic.Client, esProcessor *elastic.BulkProcessor, index string,
 ) errors.E {
+	reportMnemonicCollisions(logger)
+
 	for _, property := range document.CoreProperties {
 		if ctx.Err() != nil {
 			break
@@ -56,13 +76,13 @@ func SaveCoreProperties(
 
 func (c *PopulateCommand) runIndex(
 	ctx context.Context, logger zerolog.Logger, dbpool *pgxpool.Pool, esClient *elastic.Client,
-	schema, index string, sizeField bool,
+	schema, index string, sizeField bool, restricted []identifier.Identifier,
 ) errors.E {
 	// We set fallback context values which are used to set application name on PostgreSQL connections.
 	ctx = context.WithValue(ctx, requestIDContextKey, "populate")
 	ctx = context.WithValue(ctx, schemaContextKey, schema)
 
-	store, _, _, esProcessor, errE := es.InitForSite(ctx, logger, dbpool, esClient, schema, index, sizeField)
+	store, _, _, esProcessor, _, _, _, errE := es.InitForSite(ctx, logger, dbpool, esClient, schema, index, sizeField, restricted)
 	if errE != nil {
 		return errE
 	}
@@ -87,13 +107,13 @@ func (c *PopulateCommand) Run(globals *Globals) errors.E {
 
 	if len(globals.Sites) > 0 {
 		for _, site := range globals.Sites {
-			err := c.runIndex(ctx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField)
+			err := c.runIndex(ctx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField, site.RestrictedProperties)
 			if err != nil {
 				return err
 			}
 		}
 	} else {
-		err := c.runIndex(ctx, globals.Logger, dbpool, esClient, globals.Postgres.Schema, globals.Elastic.Index, globals.Elastic.SizeField)
+		err := c.runIndex(ctx, globals.Logger, dbpool, esClient, globals.Postgres.Schema, globals.Elastic.Index, globals.Elastic.SizeField, nil)
 		if err != nil {
 			return err
 		}
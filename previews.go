@@ -0,0 +1,324 @@
+package peerdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/internal/preview"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/storage"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+const (
+	// DefaultBaseURL is the base URL of the site when sites are not configured.
+	DefaultBaseURL = "https://localhost:8080"
+
+	// MaxPreviewSourceSize is the maximum size of a file from which a preview is generated.
+	MaxPreviewSourceSize = 100 << 20 // 100 MB
+
+	storagePathPrefix = "/f/"
+)
+
+// PreviewsCommand generates thumbnails and blurhash placeholders for files of all documents.
+//
+// Thumbnails are stored into site's storage and file claims are updated with their IRIs
+// and blurhash. File claims which already have a preview get only blurhash computed from
+// their existing preview, unless regeneration is forced. The command can be run
+// periodically to process newly added files.
+type PreviewsCommand struct {
+	BaseURL string `default:"${defaultBaseURL}" help:"Base URL of the site used for preview IRIs when sites are not configured. Default: ${defaultBaseURL}." placeholder:"URL" yaml:"baseURL"`
+	Force   bool   `                            help:"Regenerate previews of files which already have them."                                                                  yaml:"force"`
+}
+
+type previewsSite struct {
+	backupSite
+
+	BaseURL string
+}
+
+type previewsStats struct {
+	Generated int64
+	Skipped   int64
+	Failed    int64
+}
+
+// fileSites returns sites whose files are processed. When sites are not configured,
+// baseURL is used as the base URL of the only site.
+func fileSites(globals *Globals, baseURL string) []previewsSite {
+	if len(globals.Sites) == 0 {
+		return []previewsSite{{
+			backupSite: backupSite{
+				Schema:               globals.Postgres.Schema,
+				Index:                globals.Elastic.Index,
+				SizeField:            globals.Elastic.SizeField,
+				RestrictedProperties: nil,
+			},
+			BaseURL: strings.TrimSuffix(baseURL, "/"),
+		}}
+	}
+
+	sites := make([]previewsSite, 0, len(globals.Sites))
+	for _, site := range globals.Sites {
+		sites = append(sites, previewsSite{
+			backupSite: backupSite{
+				Schema:               site.Schema,
+				Index:                site.Index,
+				SizeField:            site.SizeField,
+				RestrictedProperties: site.RestrictedProperties,
+			},
+			BaseURL: "https://" + site.Domain,
+		})
+	}
+	return sites
+}
+
+func (c *PreviewsCommand) Run(globals *Globals) errors.E {
+	// We stop gracefully on ctrl-c and TERM signal.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbpool, errE := internal.InitPostgres(ctx, string(globals.Postgres.URL), globals.Logger, getRequestWithFallback(globals.Logger))
+	if errE != nil {
+		return errE
+	}
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), globals.Logger, globals.Elastic.URL)
+	if errE != nil {
+		return errE
+	}
+
+	httpClient := cleanhttp.DefaultPooledClient()
+
+	for _, site := range fileSites(globals, c.BaseURL) {
+		// We set fallback context values which are used to set application name on PostgreSQL connections.
+		siteCtx := context.WithValue(ctx, requestIDContextKey, "previews")
+		siteCtx = context.WithValue(siteCtx, schemaContextKey, site.Schema)
+
+		s, _, siteStorage, esProcessor, _, _, _, errE := es.InitForSite(siteCtx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField, site.RestrictedProperties)
+		if errE != nil {
+			return errE
+		}
+
+		stats, errE := c.runSite(siteCtx, globals.Logger, httpClient, s, siteStorage, site)
+		esProcessor.Close()
+		if errE != nil {
+			errors.Details(errE)["schema"] = site.Schema
+			return errE
+		}
+
+		globals.Logger.Info().Str("schema", site.Schema).
+			Int64("generated", stats.Generated).Int64("skipped", stats.Skipped).Int64("failed", stats.Failed).
+			Msg("generated previews")
+	}
+
+	globals.Logger.Info().Msg("Done.")
+
+	return nil
+}
+
+func (c *PreviewsCommand) runSite(
+	ctx context.Context, logger zerolog.Logger, httpClient *http.Client,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	siteStorage *storage.Storage, site previewsSite,
+) (previewsStats, errors.E) {
+	stats := previewsStats{}
+	var after *identifier.Identifier
+	for {
+		ids, errE := s.List(ctx, after)
+		if errE != nil {
+			return stats, errE
+		}
+		if len(ids) == 0 {
+			return stats, nil
+		}
+
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return stats, errors.WithStack(ctx.Err())
+			}
+
+			errE := c.processDocument(ctx, logger, httpClient, s, siteStorage, site, id, &stats)
+			if errE != nil {
+				errors.Details(errE)["doc"] = id.String()
+				return stats, errE
+			}
+		}
+
+		after = &ids[len(ids)-1]
+	}
+}
+
+func (c *PreviewsCommand) needsPreview(claim *document.FileClaim) bool {
+	if !preview.SupportedMediaTypes[claim.MediaType] {
+		return false
+	}
+	return c.Force || claim.Blurhash == ""
+}
+
+func (c *PreviewsCommand) processDocument(
+	ctx context.Context, logger zerolog.Logger, httpClient *http.Client,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	siteStorage *storage.Storage, site previewsSite, id identifier.Identifier, stats *previewsStats,
+) errors.E {
+	data, _, version, errE := s.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueDeleted) {
+		return nil
+	} else if errE != nil {
+		return errE
+	}
+
+	var doc document.D
+	errE = x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return errE
+	}
+
+	changes := document.Changes{}
+	for _, claim := range doc.AllClaims() {
+		fileClaim, ok := claim.(*document.FileClaim)
+		if !ok || !c.needsPreview(fileClaim) {
+			continue
+		}
+
+		patch, errE := c.generatePreview(ctx, httpClient, siteStorage, site, fileClaim)
+		if errE != nil {
+			// Failing to generate a preview for one file should not stop processing of others.
+			logger.Warn().Err(errE).Str("doc", id.String()).Str("claim", fileClaim.ID.String()).Str("url", fileClaim.URL).
+				Msg("unable to generate preview")
+			stats.Failed++
+			continue
+		}
+
+		change := document.SetClaimChange{ID: fileClaim.ID, Patch: patch}
+		errE = change.Apply(&doc)
+		if errE != nil {
+			return errE
+		}
+		changes = append(changes, change)
+		stats.Generated++
+	}
+
+	if len(changes) == 0 {
+		stats.Skipped++
+		return nil
+	}
+
+	return UpdateDocumentWithChanges(ctx, s, &doc, version, changes)
+}
+
+// generatePreview returns a patch which sets preview and blurhash of the file claim.
+func (c *PreviewsCommand) generatePreview(
+	ctx context.Context, httpClient *http.Client, siteStorage *storage.Storage, site previewsSite, claim *document.FileClaim,
+) (document.FileClaimPatch, errors.E) {
+	// If the file already has a preview, we compute blurhash from it, which is faster.
+	source := claim.URL
+	if !c.Force && len(claim.Preview) > 0 {
+		source = claim.Preview[0]
+	}
+
+	data, errE := fetchFile(ctx, httpClient, siteStorage, site, source)
+	if errE != nil {
+		return document.FileClaimPatch{}, errE
+	}
+
+	p, errE := preview.Generate(data, es.PreviewSize)
+	if errE != nil {
+		errors.Details(errE)["url"] = source
+		return document.FileClaimPatch{}, errE
+	}
+
+	if !c.Force && len(claim.Preview) > 0 {
+		return document.FileClaimPatch{Blurhash: &p.Blurhash}, nil
+	}
+
+	previewID, errE := siteStorage.Put(ctx, p.Data, preview.MediaType, claim.ID.String()+".jpg")
+	if errE != nil {
+		return document.FileClaimPatch{}, errE
+	}
+
+	return document.FileClaimPatch{
+		Preview:  []string{site.BaseURL + storagePathPrefix + previewID.String()},
+		Blurhash: &p.Blurhash,
+	}, nil
+}
+
+// openFile returns a reader for contents of the file at the URL. Files from site's storage are read directly.
+// The caller has to close the reader.
+func openFile(ctx context.Context, httpClient *http.Client, siteStorage *storage.Storage, site previewsSite, url string) (io.ReadCloser, errors.E) {
+	if strings.HasPrefix(url, site.BaseURL+storagePathPrefix) {
+		id, errE := identifier.FromString(strings.TrimPrefix(url, site.BaseURL+storagePathPrefix))
+		if errE == nil {
+			data, _, _, errE := siteStorage.Store().GetLatest(ctx, id)
+			if errE != nil {
+				errors.Details(errE)["url"] = url
+				return nil, errE
+			}
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = url
+		return nil, errE
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = url
+		return nil, errE
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body) //nolint:errcheck
+		resp.Body.Close()
+		errE := errors.New("bad response status")
+		errors.Details(errE)["url"] = url
+		errors.Details(errE)["code"] = resp.StatusCode
+		return nil, errE
+	}
+
+	return resp.Body, nil
+}
+
+// fetchFile returns contents of the file at the URL. Files from site's storage are read directly.
+func fetchFile(ctx context.Context, httpClient *http.Client, siteStorage *storage.Storage, site previewsSite, url string) ([]byte, errors.E) {
+	body, errE := openFile(ctx, httpClient, siteStorage, site, url)
+	if errE != nil {
+		return nil, errE
+	}
+	defer body.Close()
+	defer io.Copy(io.Discard, body) //nolint:errcheck
+
+	data, err := io.ReadAll(io.LimitReader(body, MaxPreviewSourceSize+1))
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["url"] = url
+		return nil, errE
+	}
+	if len(data) > MaxPreviewSourceSize {
+		errE := errors.New("file too large")
+		errors.Details(errE)["url"] = url
+		return nil, errE
+	}
+
+	return data, nil
+}
@@ -0,0 +1,64 @@
+package peerdb
+
+import (
+	"net/http"
+	"strconv"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/waf"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+// queueRetryAfter is the number of seconds after which clients should retry
+// requests rejected because a work queue is full.
+const queueRetryAfter = 5
+
+// replyQueueFull replies to the request with the 503 (service unavailable) HTTP code
+// and Retry-After header.
+func (s *Service) replyQueueFull(w http.ResponseWriter, req *http.Request, errE errors.E) {
+	w.Header().Set("Retry-After", strconv.Itoa(queueRetryAfter))
+	s.replyWithError(w, req, http.StatusServiceUnavailable, errE)
+}
+
+// enqueue waits for the request's turn in the work queue and returns a function which
+// must be called once the request is handled. It replies to the request and returns
+// false if the request cannot be handled.
+//
+// The time spent waiting and the number of requests waiting before it are recorded as metrics.
+func (s *Service) enqueue(w http.ResponseWriter, req *http.Request, queue *search.WorkQueue) (func(), bool) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	metrics.Counter(internal.MetricQueueLength).Add(queue.Waiting())
+	m := metrics.Duration(internal.MetricQueue).Start()
+	release, errE := queue.Acquire(ctx)
+	m.Stop()
+	if errors.Is(errE, search.ErrQueueFull) {
+		s.replyQueueFull(w, req, errE)
+		return nil, false
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return nil, false
+	}
+	return release, true
+}
+
+// checkLLMQueue replies to the request with the 503 (service unavailable) HTTP code
+// and returns false if too many prompts are already waiting to be parsed.
+//
+// Prompts are parsed in the background so requests do not wait for their turn themselves.
+func (s *Service) checkLLMQueue(w http.ResponseWriter, req *http.Request) bool {
+	metrics := waf.MustGetMetrics(req.Context())
+
+	metrics.Counter(internal.MetricQueueLength).Add(s.llmQueue.Waiting())
+	if s.llmQueue.Full() {
+		errE := errors.WithStack(search.ErrQueueFull)
+		errors.Details(errE)["running"] = s.llmQueue.Running()
+		errors.Details(errE)["waiting"] = s.llmQueue.Waiting()
+		s.replyQueueFull(w, req, errE)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package peerdb
+
+import (
+	"net/http"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/internal/es"
+)
+
+// checkQuota replies to the request with the 403 (forbidden) HTTP code
+// and returns false if the index of the site is over its quota.
+func (s *Service) checkQuota(w http.ResponseWriter, req *http.Request) bool {
+	site := waf.MustGetSite[*Site](req.Context())
+
+	errE := site.Quota.Check(req.Context())
+	if errors.Is(errE, es.ErrQuotaExceeded) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return false
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return false
+	}
+	return true
+}
+
+type adminStatsResponse struct {
+	Index es.QuotaUsage `json:"index"`
+}
+
+// AdminStatsGet is a GET/HEAD HTTP request handler which returns current usage of
+// the index of the site together with its quota. It requires the elevated role.
+func (s *Service) AdminStatsGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	site := waf.MustGetSite[*Site](req.Context())
+
+	usage, errE := site.Quota.Usage(req.Context())
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, adminStatsResponse{Index: usage}, nil)
+}
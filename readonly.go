@@ -0,0 +1,48 @@
+package peerdb
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+var errReadOnly = errors.Base("read-only mode")
+
+// readOnlyHandlers are handlers which do not use GET/HEAD HTTP methods,
+// but which do not write and are available in read-only mode.
+//
+//nolint:gochecknoglobals
+var readOnlyHandlers = map[string]bool{
+	"SearchCreatePost":        true,
+	"SearchQueryPost":         true,
+	"SearchShareCreatePost":   true,
+	"AdminScoringPreviewPost": true,
+	"AdminReloadPost":         true,
+	"TrackViewPost":           true,
+}
+
+// readOnlyMiddleware rejects requests to handlers which write with the 405 (method not allowed)
+// HTTP code and makes all database transactions of other requests read-only.
+func (s *Service) readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			route, errE := s.router.Get(req.URL.Path, req.Method)
+			// Requests which do not match any route are handled by the router.
+			handlerName := fmt.Sprintf("%s%s", route.Name, strings.Title(strings.ToLower(req.Method))) //nolint:staticcheck
+			if errE == nil && !readOnlyHandlers[handlerName] {
+				errE = errors.WithStack(errReadOnly)
+				errors.Details(errE)["method"] = req.Method
+				s.replyWithError(w, req, http.StatusMethodNotAllowed, errE)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, req.WithContext(internal.WithReadOnly(req.Context())))
+	})
+}
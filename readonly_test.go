@@ -0,0 +1,53 @@
+package peerdb_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb"
+)
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	ts, service := startTestServerWithReadOnly(t, true)
+
+	path, errE := service.ReverseAPI("DocumentCreate", nil, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	resp, err := ts.Client().Post(ts.URL+path, "application/json", nil) //nolint:noctx
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	var response struct {
+		Error struct {
+			Code peerdb.ErrorCode `json:"code"`
+		} `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	require.NoError(t, err)
+	assert.Equal(t, peerdb.ErrorCodeReadOnly, response.Error.Code)
+
+	// Creating search states does not write, so it is allowed.
+	path, errE = service.ReverseAPI("SearchCreate", nil, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	resp2, err := ts.Client().PostForm(ts.URL+path, url.Values{"q": {"test"}}) //nolint:noctx
+	require.NoError(t, err)
+	t.Cleanup(func() { resp2.Body.Close() })
+	assert.Equal(t, http.StatusOK, resp2.StatusCode)
+
+	// Parsing prompts records LLM usage, so it is not allowed.
+	resp3, err := ts.Client().PostForm(ts.URL+path, url.Values{"p": {"test"}}) //nolint:noctx
+	require.NoError(t, err)
+	t.Cleanup(func() { resp3.Body.Close() })
+	assert.Equal(t, http.StatusMethodNotAllowed, resp3.StatusCode)
+	err = json.NewDecoder(resp3.Body).Decode(&response)
+	require.NoError(t, err)
+	assert.Equal(t, peerdb.ErrorCodeReadOnly, response.Error.Code)
+}
@@ -0,0 +1,499 @@
+package peerdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// redirect is stored for an ID of a document which has been merged into another document.
+type redirect struct {
+	To identifier.Identifier `json:"to"`
+}
+
+// redirectWithID is a redirect together with the redirected ID, as returned by the admin API.
+type redirectWithID struct {
+	From identifier.Identifier `json:"from"`
+	To   identifier.Identifier `json:"to"`
+}
+
+// redirectMap maps redirected document IDs to IDs of documents they redirect to.
+//
+// Redirects never form a cycle, but they can form chains which are followed
+// to the canonical document. Methods which only read redirects can be called
+// on nil *redirectMap which has no redirects.
+type redirectMap struct {
+	mu sync.RWMutex
+	m  map[identifier.Identifier]identifier.Identifier
+}
+
+func newRedirectMap() *redirectMap {
+	return &redirectMap{
+		mu: sync.RWMutex{},
+		m:  map[identifier.Identifier]identifier.Identifier{},
+	}
+}
+
+// resolve returns the ID of the canonical document and true if the ID is redirected.
+func (r *redirectMap) resolve(id identifier.Identifier) (identifier.Identifier, bool) {
+	if r == nil {
+		return id, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.resolveLocked(id)
+}
+
+// resolveLocked is resolve which must be called with the mutex held.
+func (r *redirectMap) resolveLocked(id identifier.Identifier) (identifier.Identifier, bool) {
+	redirected := false
+	for {
+		to, ok := r.m[id]
+		if !ok {
+			return id, redirected
+		}
+		id = to
+		redirected = true
+	}
+}
+
+// resolveString is resolve for string IDs. Invalid IDs are returned as-is.
+func (r *redirectMap) resolveString(id string) string {
+	i, errE := identifier.FromString(id)
+	if errE != nil {
+		return id
+	}
+	to, _ := r.resolve(i)
+	return to.String()
+}
+
+// sources returns all IDs which redirect (possibly through a chain) to the ID.
+func (r *redirectMap) sources(id identifier.Identifier) []identifier.Identifier {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sources := []identifier.Identifier{}
+	for from := range r.m {
+		if r.chainContainsLocked(from, id) {
+			sources = append(sources, from)
+		}
+	}
+	return sources
+}
+
+// chainContainsLocked returns true if the chain of redirects starting after
+// the start ID contains the ID. It must be called with the mutex held.
+func (r *redirectMap) chainContainsLocked(start, id identifier.Identifier) bool {
+	for {
+		to, ok := r.m[start]
+		if !ok {
+			return false
+		}
+		if to == id {
+			return true
+		}
+		start = to
+	}
+}
+
+func (r *redirectMap) empty() bool {
+	if r == nil {
+		return true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.m) == 0
+}
+
+// wouldCycle returns true if redirecting from to to would create a cycle.
+func (r *redirectMap) wouldCycle(from, to identifier.Identifier) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return to == from || r.chainContainsLocked(to, from)
+}
+
+func (r *redirectMap) set(from, to identifier.Identifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.m[from] = to
+}
+
+func (r *redirectMap) remove(from identifier.Identifier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.m, from)
+}
+
+// rewriteRelations replaces targets of relation claims (including meta claims)
+// which are redirected with their canonical documents.
+func (r *redirectMap) rewriteRelations(container document.ClaimsContainer) {
+	for _, claim := range container.AllClaims() {
+		if relation, ok := claim.(*document.RelationClaim); ok && relation.To.ID != nil {
+			if to, ok := r.resolve(*relation.To.ID); ok {
+				relation.To.ID = &to
+			}
+		}
+		r.rewriteRelations(claim)
+	}
+}
+
+// rewriteRelationsJSON is rewriteRelations for a document as JSON.
+func (r *redirectMap) rewriteRelationsJSON(data json.RawMessage) (json.RawMessage, errors.E) {
+	if r.empty() {
+		return data, nil
+	}
+	var doc document.D
+	errE := x.UnmarshalWithoutUnknownFields(data, &doc)
+	if errE != nil {
+		return nil, errE
+	}
+	r.rewriteRelations(&doc)
+	for i := range doc.Children {
+		r.rewriteRelations(&doc.Children[i])
+	}
+	return x.MarshalWithoutEscapeHTML(doc)
+}
+
+// collapseResults replaces IDs of redirected documents in search results with IDs of
+// their canonical documents, keeping only the first (highest ranked) result for each document.
+func (r *redirectMap) collapseResults(results []searchResult) []searchResult {
+	if r.empty() {
+		return results
+	}
+	collapsed := make([]searchResult, 0, len(results))
+	seen := map[string]bool{}
+	for _, result := range results {
+		id := r.resolveString(result.ID)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		collapsed = append(collapsed, searchResult{ID: id, Matched: result.Matched})
+	}
+	return collapsed
+}
+
+// collapseDedupResults is collapseResults for deduplicated search results.
+func (r *redirectMap) collapseDedupResults(results []search.DedupResult) []search.DedupResult {
+	if r.empty() {
+		return results
+	}
+	collapsed := make([]search.DedupResult, 0, len(results))
+	seen := map[string]bool{}
+	for _, result := range results {
+		id := r.resolveString(result.ID)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		var alternates []string
+		for _, alternate := range result.Alternates {
+			alternate = r.resolveString(alternate)
+			if seen[alternate] {
+				continue
+			}
+			seen[alternate] = true
+			alternates = append(alternates, alternate)
+		}
+		collapsed = append(collapsed, search.DedupResult{ID: id, Alternates: alternates})
+	}
+	return collapsed
+}
+
+// initRedirects initializes the store of site's redirects and loads them.
+func initRedirects(ctx context.Context, dbpool *pgxpool.Pool, site *Site) errors.E {
+	site.redirects = &store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, store.None]{
+		Prefix:       "redirects",
+		Committed:    nil,
+		DataType:     "jsonb",
+		MetadataType: "jsonb",
+		PatchType:    "",
+	}
+	errE := site.redirects.Init(ctx, dbpool)
+	if errE != nil {
+		return errE
+	}
+
+	redirects, errE := listRedirects(ctx, site)
+	if errE != nil {
+		return errE
+	}
+	site.redirectMap = newRedirectMap()
+	for _, r := range redirects {
+		site.redirectMap.set(r.From, r.To)
+	}
+	return nil
+}
+
+// listRedirects returns all redirects of the site, ordered by redirected IDs.
+func listRedirects(ctx context.Context, site *Site) ([]redirectWithID, errors.E) {
+	redirects := []redirectWithID{}
+	var after *identifier.Identifier
+	for {
+		ids, errE := site.redirects.List(ctx, after)
+		if errE != nil {
+			return nil, errE
+		}
+		if len(ids) == 0 {
+			return redirects, nil
+		}
+
+		for _, id := range ids {
+			data, _, _, errE := site.redirects.GetLatest(ctx, id)
+			if errors.Is(errE, store.ErrValueDeleted) {
+				continue
+			} else if errE != nil {
+				errors.Details(errE)["id"] = id.String()
+				return nil, errE
+			}
+			var r redirect
+			errE = x.UnmarshalWithoutUnknownFields(data, &r)
+			if errE != nil {
+				errors.Details(errE)["id"] = id.String()
+				return nil, errE
+			}
+			redirects = append(redirects, redirectWithID{From: id, To: r.To})
+		}
+
+		after = &ids[len(ids)-1]
+	}
+}
+
+// AdminRedirectsGet is a GET/HEAD HTTP request handler which returns all redirects
+// of the site. It requires the elevated role.
+func (s *Service) AdminRedirectsGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	redirects, errE := listRedirects(req.Context(), waf.MustGetSite[*Site](req.Context()))
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, redirects, nil)
+}
+
+// getRedirectID returns the redirected ID given as a parameter, or replies with an error.
+func (s *Service) getRedirectID(w http.ResponseWriter, req *http.Request, params waf.Params) (identifier.Identifier, bool) {
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"id" is not a valid identifier`))
+		return identifier.Identifier{}, false
+	}
+	return id, true
+}
+
+// AdminRedirectGet is a GET/HEAD HTTP request handler which returns the redirect
+// of the document ID given as a parameter. It requires the elevated role.
+func (s *Service) AdminRedirectGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	id, ok := s.getRedirectID(w, req, params)
+	if !ok {
+		return
+	}
+
+	data, _, _, errE := waf.MustGetSite[*Site](req.Context()).redirects.GetLatest(req.Context(), id)
+	if errors.Is(errE, store.ErrValueNotFound) {
+		s.NotFoundWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	var r redirect
+	errE = x.UnmarshalWithoutUnknownFields(data, &r)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, redirectWithID{From: id, To: r.To}, nil)
+}
+
+// AdminRedirectPut is a PUT HTTP request handler which creates or replaces the redirect
+// of the document ID given as a parameter to the document in the request body,
+// e.g., after the former has been merged into the latter. It requires the elevated role.
+func (s *Service) AdminRedirectPut(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	id, ok := s.getRedirectID(w, req, params)
+	if !ok {
+		return
+	}
+
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	if !s.validateJSON(w, req, "redirect", buffer) {
+		return
+	}
+
+	var r redirect
+	errE := x.UnmarshalWithoutUnknownFields(buffer, &r)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	ctx := req.Context()
+	site := waf.MustGetSite[*Site](ctx)
+
+	// Redirects are changed one at a time so that checking for cycles is consistent.
+	s.redirectsMu.Lock()
+	defer s.redirectsMu.Unlock()
+
+	if site.redirectMap.wouldCycle(id, r.To) {
+		errE := errors.New("redirect would create a cycle")
+		errors.Details(errE)["from"] = id.String()
+		errors.Details(errE)["to"] = r.To.String()
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	_, _, _, errE = site.store.GetLatest(ctx, r.To)
+	if errors.Is(errE, store.ErrValueNotFound) {
+		errE = errors.WithMessage(errE, "redirect target")
+		errors.Details(errE)["to"] = r.To.String()
+		s.BadRequestWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	data, errE := x.MarshalWithoutEscapeHTML(r)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+	metadata := &types.DocumentMetadata{
+		At: types.Time(time.Now().UTC()),
+	}
+
+	_, _, version, errE := site.redirects.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueNotFound) && !errors.Is(errE, store.ErrValueDeleted) {
+		_, errE = site.redirects.Insert(ctx, id, data, metadata, &types.NoMetadata{})
+	} else if errors.Is(errE, store.ErrValueDeleted) || errE == nil {
+		// A deleted redirect has a version as well, so we can replace it.
+		_, errE = site.redirects.Replace(ctx, id, version.Changeset, data, metadata, &types.NoMetadata{})
+	}
+	if errors.Is(errE, store.ErrConflict) || errors.Is(errE, store.ErrParentInvalid) {
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	site.redirectMap.set(id, r.To)
+
+	s.WriteJSON(w, req, []byte(`{"success":true}`), nil)
+}
+
+// AdminRedirectDelete is a DELETE HTTP request handler which removes the redirect
+// of the document ID given as a parameter. It requires the elevated role.
+func (s *Service) AdminRedirectDelete(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	id, ok := s.getRedirectID(w, req, params)
+	if !ok {
+		return
+	}
+
+	ctx := req.Context()
+	site := waf.MustGetSite[*Site](ctx)
+
+	s.redirectsMu.Lock()
+	defer s.redirectsMu.Unlock()
+
+	_, _, version, errE := site.redirects.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueNotFound) {
+		s.NotFoundWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	_, errE = site.redirects.Delete(ctx, id, version.Changeset, &types.DocumentMetadata{
+		At: types.Time(time.Now().UTC()),
+	}, &types.NoMetadata{})
+	if errors.Is(errE, store.ErrConflict) || errors.Is(errE, store.ErrParentInvalid) {
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	site.redirectMap.remove(id)
+
+	s.WriteJSON(w, req, []byte(`{"success":true}`), nil)
+}
+
+// redirectToCanonical redirects the client to the canonical document if the document
+// with the ID is redirected. It returns true if the request has been handled.
+func (s *Service) redirectToCanonical(w http.ResponseWriter, req *http.Request, id identifier.Identifier, api bool) bool {
+	to, ok := waf.MustGetSite[*Site](req.Context()).redirectMap.resolve(id)
+	if !ok {
+		return false
+	}
+
+	reverse := s.Reverse
+	if api {
+		reverse = s.ReverseAPI
+	}
+	path, errE := reverse("DocumentGet", waf.Params{"id": to.String()}, req.URL.Query())
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return true
+	}
+
+	s.TemporaryRedirectSameMethod(w, req, path)
+	return true
+}
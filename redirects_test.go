@@ -0,0 +1,127 @@
+package peerdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestRedirectMap(t *testing.T) {
+	t.Parallel()
+
+	var nilMap *redirectMap
+	id := identifier.New()
+	to, ok := nilMap.resolve(id)
+	assert.False(t, ok)
+	assert.Equal(t, id, to)
+	assert.True(t, nilMap.empty())
+
+	a := identifier.New()
+	b := identifier.New()
+	c := identifier.New()
+
+	r := newRedirectMap()
+	assert.True(t, r.empty())
+	r.set(a, b)
+	r.set(b, c)
+	assert.False(t, r.empty())
+
+	to, ok = r.resolve(a)
+	assert.True(t, ok)
+	assert.Equal(t, c, to)
+	to, ok = r.resolve(c)
+	assert.False(t, ok)
+	assert.Equal(t, c, to)
+
+	assert.ElementsMatch(t, []identifier.Identifier{a, b}, r.sources(c))
+	assert.Equal(t, []identifier.Identifier{a}, r.sources(b))
+
+	assert.True(t, r.wouldCycle(c, a))
+	assert.True(t, r.wouldCycle(a, a))
+	assert.False(t, r.wouldCycle(a, c))
+
+	r.remove(b)
+	to, ok = r.resolve(a)
+	assert.True(t, ok)
+	assert.Equal(t, b, to)
+	assert.False(t, r.wouldCycle(c, a))
+}
+
+func TestRedirectMapCollapseResults(t *testing.T) {
+	t.Parallel()
+
+	a := identifier.New()
+	b := identifier.New()
+	c := identifier.New()
+
+	r := newRedirectMap()
+	r.set(a, b)
+
+	assert.Equal(t, []searchResult{
+		{ID: b.String()},
+		{ID: c.String()},
+	}, r.collapseResults([]searchResult{
+		{ID: a.String()},
+		{ID: c.String()},
+		{ID: b.String()},
+	}))
+
+	assert.Equal(t, []search.DedupResult{
+		{ID: c.String(), Alternates: []string{b.String()}},
+	}, r.collapseDedupResults([]search.DedupResult{
+		{ID: c.String(), Alternates: []string{a.String(), b.String()}},
+		{ID: b.String(), Alternates: nil},
+	}))
+}
+
+func TestRedirectMapRewriteRelations(t *testing.T) {
+	t.Parallel()
+
+	a := identifier.New()
+	b := identifier.New()
+
+	r := newRedirectMap()
+	r.set(a, b)
+
+	doc := &document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: document.HighConfidence,
+		},
+	}
+	claim := &document.RelationClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("TYPE"),
+		To:   document.Reference{ID: &a},
+	}
+	metaClaim := &document.RelationClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("TYPE"),
+		To:   document.Reference{ID: &a},
+	}
+	errE := claim.Add(metaClaim)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = doc.Add(claim)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	r.rewriteRelations(doc)
+
+	// Claims are copied into the document, so we have to get them from it.
+	rewritten, ok := doc.GetByID(claim.ID).(*document.RelationClaim)
+	require.True(t, ok)
+	assert.Equal(t, b, *rewritten.To.ID)
+	rewrittenMeta, ok := rewritten.GetByID(metaClaim.ID).(*document.RelationClaim)
+	require.True(t, ok)
+	assert.Equal(t, b, *rewrittenMeta.To.ID)
+}
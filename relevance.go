@@ -0,0 +1,187 @@
+package peerdb
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb/internal/es"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+var errRelevanceRegressed = errors.Base("relevance regressed")
+
+// RelevanceTestCommand evaluates relevance of search results for a corpus of queries with
+// expected top results, to guard ranking changes.
+//
+// The report (precision, recall, and reciprocal rank per query and their means) is written as
+// a JSON object. If a baseline report or another index is provided, the report also contains
+// changes compared to it and the command fails if precision or recall decreased by more than
+// the tolerance. Comparing an index with itself (an A/A test) shows how much results vary
+// between runs.
+//
+//nolint:lll
+type RelevanceTestCommand struct {
+	Corpus       string  `arg:""                                    help:"Path to a YAML file with the corpus of queries."                                                                                                              placeholder:"PATH"   type:"existingfile"`
+	Domain       string  `                                          help:"Domain of the site whose index, field weights, and scoring are used. Default: the first site."                                                                placeholder:"DOMAIN"                     yaml:"domain"`
+	Index        string  `                                          help:"Name of ElasticSearch index to use instead of the site's index."                                                                                              placeholder:"NAME"                       yaml:"index"`
+	CompareIndex string  `                                          help:"Name of ElasticSearch index to compare with. Use the same index for an A/A test."                                                                             placeholder:"NAME"                       yaml:"compareIndex"`
+	Baseline     string  `                                          help:"Path to a previous report to compare with."                                                                                                                   placeholder:"PATH"   type:"existingfile" yaml:"baseline"`
+	Depth        int     `       default:"${defaultRelevanceDepth}" help:"Number of top search results evaluated per query. Default: ${defaultRelevanceDepth}."                                                                         placeholder:"INT"                        yaml:"depth"`
+	Tolerance    float64 `                                          help:"By how much can precision or recall decrease (overall or for any query) compared to the baseline or the compared index before the command fails. Default: 0." placeholder:"FLOAT"                      yaml:"tolerance"`
+	Report       string  `       default:"-"                        help:"Path of the report to write. Default: standard output."                                                                                                       placeholder:"PATH"                       yaml:"report"`
+}
+
+type relevanceTestReport struct {
+	*search.RelevanceReport
+
+	// Compared is the report the report is compared with, if any.
+	Compared *search.RelevanceReport `json:"compared,omitempty"`
+	Delta    *search.RelevanceDelta  `json:"delta,omitempty"`
+}
+
+// site returns the site whose configuration is used. If no sites are configured,
+// a site based on global configuration is returned.
+func (c *RelevanceTestCommand) site(globals *Globals) (*Site, errors.E) {
+	if len(globals.Sites) == 0 {
+		if c.Domain != "" {
+			errE := errors.New("site not found")
+			errors.Details(errE)["site"] = c.Domain
+			return nil, errE
+		}
+		return &Site{Index: globals.Elastic.Index}, nil //nolint:exhaustruct
+	}
+	if c.Domain == "" {
+		return &globals.Sites[0], nil
+	}
+	for i := range globals.Sites {
+		if globals.Sites[i].Domain == c.Domain {
+			return &globals.Sites[i], nil
+		}
+	}
+	errE := errors.New("site not found")
+	errors.Details(errE)["site"] = c.Domain
+	return nil, errE
+}
+
+func (c *RelevanceTestCommand) Run(globals *Globals) (errE errors.E) { //nolint:nonamedreturns
+	// We stop gracefully on ctrl-c and TERM signal.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if c.CompareIndex != "" && c.Baseline != "" {
+		return errors.New("only one of compared index and baseline can be provided")
+	}
+
+	site, errE := c.site(globals)
+	if errE != nil {
+		return errE
+	}
+	index := site.Index
+	if c.Index != "" {
+		index = c.Index
+	}
+
+	corpus, errE := search.LoadRelevanceCorpus(c.Corpus)
+	if errE != nil {
+		return errE
+	}
+
+	var compared *search.RelevanceReport
+	if c.Baseline != "" {
+		data, err := os.ReadFile(c.Baseline)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// Baseline is a report written by this command, possibly compared with another report itself.
+		var baseline relevanceTestReport
+		errE = x.UnmarshalWithoutUnknownFields(data, &baseline)
+		if errE != nil {
+			errors.Details(errE)["path"] = c.Baseline
+			return errE
+		}
+		if baseline.RelevanceReport == nil {
+			errE := errors.New("invalid baseline report")
+			errors.Details(errE)["path"] = c.Baseline
+			return errE
+		}
+		compared = baseline.RelevanceReport
+	}
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), globals.Logger, globals.Elastic.URL)
+	if errE != nil {
+		return errE
+	}
+
+	report := relevanceTestReport{
+		RelevanceReport: nil,
+		Compared:        nil,
+		Delta:           nil,
+	}
+	report.RelevanceReport, errE = search.RunRelevance(ctx, esClient, index, corpus, site.FieldWeights, site.NameProperties, site.Scoring, c.Depth)
+	if errE != nil {
+		return errE
+	}
+
+	if c.CompareIndex != "" {
+		compared, errE = search.RunRelevance(ctx, esClient, c.CompareIndex, corpus, site.FieldWeights, site.NameProperties, site.Scoring, c.Depth)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	if compared != nil {
+		if compared.Depth != report.Depth {
+			errE := errors.New("compared report has a different depth")
+			errors.Details(errE)["depth"] = report.Depth
+			errors.Details(errE)["compared"] = compared.Depth
+			return errE
+		}
+		report.Compared = compared
+		report.Delta = search.CompareRelevance(compared, report.RelevanceReport)
+	}
+
+	var output io.Writer = os.Stdout
+	if c.Report != "-" {
+		file, err := os.Create(c.Report)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer func() {
+			errE = errors.Join(errE, file.Close())
+		}()
+		output = file
+	}
+
+	data, errE := x.MarshalWithoutEscapeHTML(report)
+	if errE != nil {
+		return errE
+	}
+	_, err := output.Write(append(data, '\n'))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	logEvent := globals.Logger.Info().Str("index", index).Int("queries", len(report.Queries)).
+		Float64("precision", report.Precision).Float64("recall", report.Recall).Float64("mrr", report.MeanReciprocalRank)
+	if report.Delta != nil {
+		logEvent = logEvent.Float64("precisionDelta", report.Delta.Precision).Float64("recallDelta", report.Delta.Recall).
+			Float64("mrrDelta", report.Delta.MeanReciprocalRank).Int("changed", len(report.Delta.Queries))
+	}
+	logEvent.Msg("relevance evaluated")
+
+	if report.Delta != nil && report.Delta.Regressed(c.Tolerance) {
+		errE := errors.WithStack(errRelevanceRegressed)
+		errors.Details(errE)["precision"] = report.Delta.Precision
+		errors.Details(errE)["recall"] = report.Delta.Recall
+		return errE
+	}
+
+	return nil
+}
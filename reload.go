@@ -0,0 +1,290 @@
+package peerdb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/rs/cors"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/waf"
+	"gopkg.in/yaml.v3"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+var errInvalidConfig = errors.Base("invalid configuration")
+
+// siteSettings are parts of site configuration which can be reloaded while the server is running.
+//
+// Other fields of Site are used only when the server starts.
+type siteSettings struct {
+	ElevatedTokens  []string
+	ModeratorTokens []string
+	CORS            *CORSConfig
+	Scoring         []search.ScoringFunction
+	FieldWeights    search.FieldWeights
+	NameProperties  search.NameProperties
+	Limits          search.Limits
+	Fallbacks       search.Fallbacks
+	TieBreakers     search.TieBreakers
+
+	// cors and frameAncestors are compiled from CORS.
+	cors           *cors.Cors
+	frameAncestors string
+}
+
+func newSiteSettings(site *Site) *siteSettings {
+	return &siteSettings{
+		ElevatedTokens:  site.ElevatedTokens,
+		ModeratorTokens: site.ModeratorTokens,
+		CORS:            site.CORS,
+		Scoring:         site.Scoring,
+		FieldWeights:    site.FieldWeights,
+		NameProperties:  site.NameProperties,
+		Limits:          site.Limits,
+		Fallbacks:       site.Fallbacks,
+		TieBreakers:     site.TieBreakers,
+		cors:            newCORS(site.CORS),
+		frameAncestors:  site.CORS.frameAncestors(),
+	}
+}
+
+// maxResults returns the maximum number of search results returned in one response.
+func (s *siteSettings) maxResults(csvFormat bool) int {
+	if csvFormat {
+		return min(s.Limits.PageSize(), s.Limits.ExportSize())
+	}
+	return s.Limits.PageSize()
+}
+
+// initSettings makes site's configuration reloadable.
+func (s *Site) initSettings() {
+	s.reloadable = &atomic.Pointer[siteSettings]{}
+	s.reloadable.Store(newSiteSettings(s))
+}
+
+// settings returns current reloadable settings of the site. Settings should be
+// obtained once per request so that the whole request uses the same settings.
+func (s *Site) settings() *siteSettings {
+	if s.reloadable == nil {
+		// Site has not been initialized by ServeCommand (e.g., in tests).
+		return newSiteSettings(s)
+	}
+	return s.reloadable.Load()
+}
+
+// validateSettings validates parts of site configuration which can be reloaded.
+func (s *Site) validateSettings() error {
+	if s.CORS != nil {
+		if err := s.CORS.Validate(); err != nil {
+			return errors.Errorf(`invalid CORS configuration for site "%s": %w`, s.Domain, err)
+		}
+	}
+	if errE := search.ValidateScoringFunctions(s.Scoring); errE != nil {
+		return errors.Errorf(`invalid scoring configuration for site "%s": %w`, s.Domain, errE)
+	}
+	if errE := s.FieldWeights.Validate(); errE != nil {
+		return errors.Errorf(`invalid field weights configuration for site "%s": %w`, s.Domain, errE)
+	}
+	if errE := s.NameProperties.Validate(); errE != nil {
+		return errors.Errorf(`invalid name properties configuration for site "%s": %w`, s.Domain, errE)
+	}
+	if errE := s.Limits.Validate(); errE != nil {
+		return errors.Errorf(`invalid limits configuration for site "%s": %w`, s.Domain, errE)
+	}
+	if errE := s.Fallbacks.Validate(); errE != nil {
+		return errors.Errorf(`invalid fallbacks configuration for site "%s": %w`, s.Domain, errE)
+	}
+	if errE := s.TieBreakers.Validate(); errE != nil {
+		return errors.Errorf(`invalid tie-breakers configuration for site "%s": %w`, s.Domain, errE)
+	}
+	return nil
+}
+
+// loadSiteSettings reads sites from the configuration file and returns their validated
+// reloadable settings, for every site in sites. Sites cannot be added or removed.
+func loadSiteSettings(path string, sites map[string]*Site) (map[string]*siteSettings, errors.E) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errE := errors.Prefix(err, errInvalidConfig)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		errE := errors.Prefix(err, errInvalidConfig)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+
+	if len(config.Sites) == 0 {
+		errE := errors.Errorf("%w: sites are not configured in the configuration file", errInvalidConfig)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+
+	settings := map[string]*siteSettings{}
+	for i := range config.Sites {
+		site := &config.Sites[i]
+		if _, ok := sites[site.Domain]; !ok {
+			errE := errors.Errorf("%w: sites cannot be added without restart", errInvalidConfig)
+			errors.Details(errE)["domain"] = site.Domain
+			return nil, errE
+		}
+		if _, ok := settings[site.Domain]; ok {
+			errE := errors.Errorf("%w: duplicate site", errInvalidConfig)
+			errors.Details(errE)["domain"] = site.Domain
+			return nil, errE
+		}
+		if err := site.validateSettings(); err != nil {
+			return nil, errors.Prefix(err, errInvalidConfig)
+		}
+		settings[site.Domain] = newSiteSettings(site)
+	}
+	for domain := range sites {
+		if _, ok := settings[domain]; !ok {
+			errE := errors.Errorf("%w: sites cannot be removed without restart", errInvalidConfig)
+			errors.Details(errE)["domain"] = domain
+			return nil, errE
+		}
+	}
+
+	return settings, nil
+}
+
+// loadLLMAPIKey reads the API key of the LLM provider from the file and makes it available
+// to parsing of prompts, which reads it from ANTHROPIC_API_KEY environment variable.
+func loadLLMAPIKey(path string) errors.E {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return errE
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		errE := errors.Errorf("%w: empty LLM API key", errInvalidConfig)
+		errors.Details(errE)["path"] = path
+		return errE
+	}
+	return errors.WithStack(os.Setenv("ANTHROPIC_API_KEY", key))
+}
+
+// reloadConfig reads the configuration file again and replaces reloadable settings of all
+// sites with it. It also reloads the LLM API key file, applies stored synonym sets again, and
+// loads data types and names of properties again from the index.
+//
+// The new configuration is first validated for all sites and only then settings of all sites are
+// replaced, so an invalid configuration leaves the running configuration unchanged.
+func (s *Service) reloadConfig(ctx context.Context) errors.E {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.configPath == "" {
+		return errors.Errorf("%w: configuration has not been loaded from a file", errInvalidConfig)
+	}
+
+	settings, errE := loadSiteSettings(s.configPath, s.Sites)
+	if errE != nil {
+		return errE
+	}
+
+	if s.llmAPIKeyFile != "" {
+		errE = loadLLMAPIKey(s.llmAPIKeyFile)
+		if errE != nil {
+			return errE
+		}
+	}
+
+	// We iterate in a deterministic order so that logs are easier to follow.
+	domains := make([]string, 0, len(settings))
+	for domain := range settings {
+		domains = append(domains, domain)
+	}
+	slices.Sort(domains)
+
+	for _, domain := range domains {
+		s.Sites[domain].reloadable.Store(settings[domain])
+	}
+
+	if !internal.IsReadOnly(ctx) {
+		for _, domain := range domains {
+			site := s.Sites[domain]
+			siteCtx := context.WithValue(ctx, schemaContextKey, site.Schema)
+			errE = s.updateSynonymsAfterChange(siteCtx, site)
+			if errE != nil {
+				errors.Details(errE)["domain"] = domain
+				return errE
+			}
+		}
+	}
+
+	errE = s.populatePropertyRegistries(ctx)
+	if errE != nil {
+		return errE
+	}
+
+	s.Logger.Info().Strs("domains", domains).Msg("configuration reloaded")
+
+	return nil
+}
+
+// reloadOnSignal reloads the configuration every time the process receives the SIGHUP signal.
+func (s *Service) reloadOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			errE := s.reloadConfig(context.WithValue(ctx, requestIDContextKey, "reload"))
+			if errE != nil {
+				s.Logger.Error().Err(errE).Msg("configuration reload failed")
+			}
+		}
+	}
+}
+
+// AdminReloadPost is a POST HTTP request handler which reloads the configuration file,
+// like sending the SIGHUP signal to the process does. If the new configuration is invalid,
+// it replies with the 409 (conflict) HTTP code and the running configuration is kept.
+// It requires the elevated role.
+func (s *Service) AdminReloadPost(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	if !s.validateEmptyRequest(w, req) {
+		return
+	}
+
+	errE := s.reloadConfig(req.Context())
+	if errors.Is(errE, errInvalidConfig) {
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, []byte(`{"success":true}`), nil)
+}
@@ -0,0 +1,124 @@
+package peerdb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/waf"
+)
+
+func TestLoadSiteSettings(t *testing.T) {
+	t.Parallel()
+
+	sites := map[string]*Site{
+		"example.com": {Site: waf.Site{Domain: "example.com"}, ElevatedTokens: []string{"old"}}, //nolint:exhaustruct
+		"example.org": {Site: waf.Site{Domain: "example.org"}},                                  //nolint:exhaustruct
+	}
+	for _, site := range sites {
+		site.initSettings()
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"valid", `
+globals:
+  sites:
+    - domain: example.com
+      elevatedTokens: [new]
+      limits:
+        maxPageSize: 10
+      cors:
+        allowedOrigins: ["https://example.net"]
+    - domain: example.org
+`, ""},
+		{"invalid limits", `
+globals:
+  sites:
+    - domain: example.com
+      limits:
+        maxPageSize: -1
+    - domain: example.org
+`, `invalid limits configuration for site "example.com": limit out of range`},
+		{"invalid CORS", `
+globals:
+  sites:
+    - domain: example.com
+      cors:
+        allowedOrigins: ["example.net"]
+    - domain: example.org
+`, `invalid CORS configuration for site "example.com": invalid origin "example.net"`},
+		{"unknown field", `
+globals:
+  sites:
+    - domain: example.com
+      unknown: true
+`, "yaml: unmarshal errors:\n  line 5: field unknown not found in type peerdb.Site"},
+		{"added site", `
+globals:
+  sites:
+    - domain: example.com
+    - domain: example.org
+    - domain: example.net
+`, "sites cannot be added without restart"},
+		{"removed site", `
+globals:
+  sites:
+    - domain: example.com
+`, "sites cannot be removed without restart"},
+		{"no sites", ``, "sites are not configured in the configuration file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(test.config), 0o600))
+
+			settings, errE := loadSiteSettings(path, sites)
+			if test.err != "" {
+				assert.ErrorIs(t, errE, errInvalidConfig)
+				assert.EqualError(t, errE, "invalid configuration: "+test.err)
+				return
+			}
+			require.NoError(t, errE, "% -+#.1v", errE)
+			require.Len(t, settings, 2)
+
+			assert.Equal(t, []string{"new"}, settings["example.com"].ElevatedTokens)
+			assert.Equal(t, 10, settings["example.com"].maxResults(false))
+			assert.NotNil(t, settings["example.com"].cors)
+			assert.Nil(t, settings["example.org"].cors)
+		})
+	}
+
+	// Sites are not changed by loading.
+	assert.Equal(t, []string{"old"}, sites["example.com"].settings().ElevatedTokens)
+}
+
+func TestSiteSettings(t *testing.T) {
+	t.Parallel()
+
+	site := &Site{ElevatedTokens: []string{"old"}} //nolint:exhaustruct
+	// Without initialization, settings are those from the configuration.
+	assert.Equal(t, []string{"old"}, site.settings().ElevatedTokens)
+
+	site.initSettings()
+	site.reloadable.Store(&siteSettings{ElevatedTokens: []string{"new"}}) //nolint:exhaustruct
+	assert.True(t, site.isElevatedToken("new"))
+	assert.False(t, site.isElevatedToken("old"))
+}
+
+func TestReloadConfigWithoutFile(t *testing.T) {
+	t.Parallel()
+
+	s := &Service{} //nolint:exhaustruct
+	errE := s.reloadConfig(context.Background())
+	assert.ErrorIs(t, errE, errInvalidConfig)
+}
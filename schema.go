@@ -0,0 +1,335 @@
+package peerdb
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"gitlab.com/tozd/go/cli"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/waf"
+)
+
+//go:embed schema/*.json
+var schemaFiles embed.FS
+
+const (
+	schemaBaseURL = "file:///schema/"
+	openAPIName   = "openapi.json"
+)
+
+// apiOperation describes request and response bodies of an API handler
+// as names of definitions in schema/api.json.
+type apiOperation struct {
+	Request  string
+	Response string
+}
+
+// apiOperations maps API handler names to their request and response bodies.
+// Handlers which are not listed do not have a request body and their response
+// body is not described.
+var apiOperations = map[string]apiOperation{ //nolint:gochecknoglobals
+	"SearchResultsGet":              {Request: "", Response: "searchResults"},
+	"SearchExportPost":              {Request: "emptyRequest", Response: "taskResponse"},
+	"SearchCreatePost":              {Request: "", Response: "searchCreateResponse"},
+	"SearchFederatedGet":            {Request: "", Response: "federatedSearchResults"},
+	"SearchQueryPost":               {Request: "searchQueryRequest", Response: "searchResults"},
+	"SearchShareCreatePost":         {Request: "", Response: "searchShareCreateResponse"},
+	"SearchShareGetGet":             {Request: "", Response: "sharedSearch"},
+	"AdminLLMUsageGet":              {Request: "", Response: "llmUsages"},
+	"AdminSynonymsGet":              {Request: "", Response: "synonymSets"},
+	"AdminSynonymsPost":             {Request: "synonymSet", Response: "synonymSetCreateResponse"},
+	"AdminSynonymSetGet":            {Request: "", Response: "synonymSetWithID"},
+	"AdminSynonymSetPut":            {Request: "synonymSet", Response: "taskResponse"},
+	"AdminSynonymSetDelete":         {Request: "", Response: "taskResponse"},
+	"AdminRedirectsGet":             {Request: "", Response: "redirects"},
+	"AdminRedirectGet":              {Request: "", Response: "redirectWithID"},
+	"AdminRedirectPut":              {Request: "redirect", Response: "successResponse"},
+	"AdminRedirectDelete":           {Request: "", Response: "successResponse"},
+	"AdminAnnotationsGet":           {Request: "", Response: "annotations"},
+	"AdminTasksGet":                 {Request: "", Response: "tasks"},
+	"AdminReindexPost":              {Request: "emptyRequest", Response: "taskResponse"},
+	"AdminScoringPreviewPost":       {Request: "scoringPreview", Response: "scoringPreviewResults"},
+	"AdminStatsGet":                 {Request: "", Response: "adminStats"},
+	"AdminSlowQueriesGet":           {Request: "", Response: "adminSlowQueries"},
+	"AdminFileDuplicatesGet":        {Request: "", Response: "adminFileDuplicates"},
+	"AdminReloadPost":               {Request: "emptyRequest", Response: "successResponse"},
+	"TaskGet":                       {Request: "", Response: "task"},
+	"TaskCancelPost":                {Request: "emptyRequest", Response: "task"},
+	"SiteConfigGet":                 {Request: "", Response: "siteConfig"},
+	"DocumentGetGet":                {Request: "", Response: "doc.json#"},
+	"DocumentCreatePost":            {Request: "emptyRequest", Response: "documentCreateResponse"},
+	"DocumentBeginEditPost":         {Request: "emptyRequest", Response: "documentBeginEditResponse"},
+	"DocumentSaveChangePost":        {Request: "change", Response: "successResponse"},
+	"DocumentListChangesGet":        {Request: "", Response: "changeNumbers"},
+	"DocumentGetChangeGet":          {Request: "", Response: "change"},
+	"DocumentEndEditPost":           {Request: "emptyRequest", Response: "documentEndEditResponse"},
+	"DocumentDiscardEditPost":       {Request: "emptyRequest", Response: "successResponse"},
+	"DocumentUpdatePatch":           {Request: "documentUpdateRequest", Response: "documentUpdateResponse"},
+	"DocumentIncomingGet":           {Request: "", Response: "searchResults"},
+	"DocumentAnnotationsGet":        {Request: "", Response: "annotations"},
+	"DocumentAnnotationsPost":       {Request: "annotationCreateRequest", Response: "annotationCreateResponse"},
+	"DocumentResolveAnnotationPost": {Request: "annotationResolveRequest", Response: "annotation"},
+	"LookupGTINGet":                 {Request: "", Response: "searchResults"},
+	"StorageBeginUploadPost":        {Request: "storageBeginUploadRequest", Response: "storageBeginUploadResponse"},
+	"StorageListChunksGet":          {Request: "", Response: "chunkNumbers"},
+	"StorageGetChunkGet":            {Request: "", Response: "storageGetChunkResponse"},
+	"StorageEndUploadPost":          {Request: "emptyRequest", Response: "successResponse"},
+	"StorageDiscardUploadPost":      {Request: "emptyRequest", Response: "successResponse"},
+	"TrackViewPost":                 {Request: "trackViewRequest", Response: "successResponse"},
+	"TrendingGet":                   {Request: "", Response: "trendingDocuments"},
+	"RecentGet":                     {Request: "", Response: "searchResults"},
+}
+
+// schemaRef returns a JSON pointer to the definition with the given name, relative to
+// the location where schemas are served. Names can be prefixed with a schema file
+// name, otherwise schema/api.json is assumed.
+func schemaRef(name string) string {
+	if strings.Contains(name, "#") {
+		return "/schema/" + name
+	}
+	return "/schema/api.json#/$defs/" + name
+}
+
+// compileAPISchemas compiles all request body definitions used by apiOperations.
+func compileAPISchemas() (map[string]*jsonschema.Schema, errors.E) {
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft2019)
+
+	entries, err := fs.ReadDir(schemaFiles, "schema")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, entry := range entries {
+		data, err := schemaFiles.ReadFile("schema/" + entry.Name())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		if err != nil {
+			errE := errors.WithMessage(err, "invalid schema file")
+			errors.Details(errE)["file"] = entry.Name()
+			return nil, errE
+		}
+		err = compiler.AddResource(schemaBaseURL+entry.Name(), doc)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	schemas := map[string]*jsonschema.Schema{}
+	for _, operation := range apiOperations {
+		if operation.Request == "" {
+			continue
+		}
+		if _, ok := schemas[operation.Request]; ok {
+			continue
+		}
+		schema, err := compiler.Compile(schemaBaseURL + "api.json#/$defs/" + operation.Request)
+		if err != nil {
+			errE := errors.WithMessage(err, "unable to compile schema")
+			errors.Details(errE)["definition"] = operation.Request
+			return nil, errE
+		}
+		schemas[operation.Request] = schema
+	}
+
+	return schemas, nil
+}
+
+// validateEmptyRequest reads the request body and validates that it is an empty JSON object,
+// as declared for operations with emptyRequest request body.
+func (s *Service) validateEmptyRequest(w http.ResponseWriter, req *http.Request) bool {
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return false
+	}
+
+	return s.validateJSON(w, req, "emptyRequest", buffer)
+}
+
+// validateJSON validates data against the definition with the given name.
+//
+// If data is invalid, it replies to the request with the 400 (bad request) HTTP code
+// and a structured error listing instance locations which failed validation in its details,
+// and returns false.
+func (s *Service) validateJSON(w http.ResponseWriter, req *http.Request, name string, data []byte) bool {
+	schema, ok := s.apiSchemas[name]
+	if !ok {
+		errE := errors.New("unknown schema definition")
+		errors.Details(errE)["definition"] = name
+		s.InternalServerErrorWithError(w, req, errE)
+		return false
+	}
+
+	value, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return false
+	}
+
+	err = schema.Validate(value)
+	if err == nil {
+		return true
+	}
+
+	var validationError *jsonschema.ValidationError
+	if !errors.As(err, &validationError) {
+		s.InternalServerErrorWithError(w, req, errors.WithStack(err))
+		return false
+	}
+
+	errE := errors.WrapWith(err, errInvalidBody)
+	errors.Details(errE)["validation"] = validationError.BasicOutput()
+	s.BadRequestWithError(w, req, errE)
+	return false
+}
+
+// generateOpenAPI generates an OpenAPI document describing all API routes
+// for which the service has handlers.
+func generateOpenAPI(service *Service, routes []waf.Route) ([]byte, errors.E) {
+	v := reflect.ValueOf(service)
+
+	errorContent := map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{
+				"$ref": schemaRef("errorResponse"),
+			},
+		},
+	}
+
+	paths := map[string]interface{}{}
+	for _, route := range routes {
+		if route.API == nil {
+			continue
+		}
+
+		segments := strings.Split(route.Path, "/")
+		parameters := []interface{}{}
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				name := strings.TrimPrefix(segment, ":")
+				segments[i] = "{" + name + "}"
+				parameters = append(parameters, map[string]interface{}{
+					"name":     name,
+					"in":       "path",
+					"required": true,
+					"schema": map[string]interface{}{
+						"type": "string",
+					},
+				})
+			}
+		}
+		path := "/api" + strings.Join(segments, "/")
+		if path == "/api/" {
+			path = "/api"
+		}
+
+		operations := map[string]interface{}{}
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			handlerName := fmt.Sprintf("%s%s", route.Name, strings.Title(strings.ToLower(method))) //nolint:staticcheck
+			if !v.MethodByName(handlerName).IsValid() {
+				continue
+			}
+
+			response := map[string]interface{}{
+				"description": "Successful response.",
+			}
+			operation := map[string]interface{}{
+				"operationId": handlerName,
+				"responses": map[string]interface{}{
+					"200": response,
+					"400": map[string]interface{}{
+						"description": "Invalid request.",
+						"content":     errorContent,
+					},
+					"default": map[string]interface{}{
+						"description": "Error response.",
+						"content":     errorContent,
+					},
+				},
+			}
+			if len(parameters) > 0 {
+				operation["parameters"] = parameters
+			}
+			if api, ok := apiOperations[handlerName]; ok {
+				if api.Request != "" {
+					operation["requestBody"] = map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{
+									"$ref": schemaRef(api.Request),
+								},
+							},
+						},
+					}
+				}
+				if api.Response != "" {
+					response["content"] = map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"$ref": schemaRef(api.Response),
+							},
+						},
+					}
+				}
+			}
+			operations[strings.ToLower(method)] = operation
+		}
+
+		if len(operations) > 0 {
+			paths[path] = operations
+		}
+	}
+
+	version := cli.Version
+	if version == "" {
+		version = "devel"
+	}
+
+	return x.MarshalWithoutEscapeHTML(map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   "PeerDB API",
+			"version": version,
+		},
+		"paths": paths,
+	})
+}
+
+// Schema is a GET/HEAD HTTP request handler which returns JSON Schema files
+// describing documents and API request and response bodies, or the OpenAPI
+// document generated from them.
+func (s *Service) Schema(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	name := params["name"]
+
+	if name == openAPIName {
+		s.WriteJSON(w, req, s.openAPI, nil)
+		return
+	}
+
+	if !strings.HasSuffix(name, ".json") || strings.Contains(name, "/") {
+		s.NotFound(w, req)
+		return
+	}
+
+	data, err := schemaFiles.ReadFile("schema/" + name)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.NotFound(w, req)
+		return
+	} else if err != nil {
+		s.InternalServerErrorWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	s.WriteJSON(w, req, data, nil)
+}
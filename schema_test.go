@@ -0,0 +1,151 @@
+package peerdb
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/waf"
+)
+
+func TestAPISchemas(t *testing.T) {
+	t.Parallel()
+
+	schemas, errE := compileAPISchemas()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"change", `{"type":"add","id":"LpkhHZYzTsdjZKR6cPmWQY","patch":{"type":"amount","prop":"KhqMjmabSERw4Nwv7sLFms","amount":42.1,"unit":"kg"}}`, true},
+		{"change", `{"type":"set","id":"LpkhHZYzTsdjZKR6cPmWQY","patch":{"type":"string","string":"foobar"}}`, true},
+		{"change", `{"type":"remove","id":"LpkhHZYzTsdjZKR6cPmWQY"}`, true},
+		{"change", `{"type":"remove","id":"invalid"}`, false},
+		{"change", `{"type":"add","id":"LpkhHZYzTsdjZKR6cPmWQY","patch":{"type":"string","amount":42}}`, false},
+		{"change", `{"type":"unknown","id":"LpkhHZYzTsdjZKR6cPmWQY"}`, false},
+		{"storageBeginUploadRequest", `{"size":10,"mediaType":"text/plain","filename":"test.txt"}`, true},
+		{"storageBeginUploadRequest", `{"size":-1,"mediaType":"text/plain","filename":"test.txt"}`, false},
+		{"documentUpdateRequest", `{"version":"LpkhHZYzTsdjZKR6cPmWQY-1","changes":[{"type":"remove","id":"LpkhHZYzTsdjZKR6cPmWQY"}]}`, true},
+		{"documentUpdateRequest", `{"version":"LpkhHZYzTsdjZKR6cPmWQY","changes":[{"type":"remove","id":"LpkhHZYzTsdjZKR6cPmWQY"}]}`, false},
+		{"documentUpdateRequest", `{"version":"LpkhHZYzTsdjZKR6cPmWQY-1","changes":[]}`, false},
+		{"searchQueryRequest", `{"query":{"and":[{"text":{"query":"foo"}},{"not":{"index":{"str":"foo"}}}]},"size":10}`, true},
+		{"searchQueryRequest", `{"query":{"text":{"query":"foo"},"index":{"str":"foo"}}}`, false},
+		{"searchQueryRequest", `{"query":{"text":{"query":"foo","operator":"xor"}}}`, false},
+		{"annotationCreateRequest", `{"claim":"LpkhHZYzTsdjZKR6cPmWQY","flag":"incorrect value"}`, true},
+		{"annotationCreateRequest", `{"comment":"Foobar."}`, true},
+		{"annotationCreateRequest", `{"claim":"LpkhHZYzTsdjZKR6cPmWQY"}`, false},
+		{"annotationCreateRequest", `{"flag":"unknown"}`, false},
+		{"trackViewRequest", `{"id":"LpkhHZYzTsdjZKR6cPmWQY"}`, true},
+		{"trackViewRequest", `{"id":"LpkhHZYzTsdjZKR6cPmWQY","session":"KhqMjmabSERw4Nwv7sLFms"}`, false},
+		{"emptyRequest", `{}`, true},
+		{"emptyRequest", `{"foo":1}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			t.Parallel()
+
+			value, err := jsonschema.UnmarshalJSON(bytes.NewReader([]byte(tt.data)))
+			require.NoError(t, err)
+			err = schemas[tt.name].Validate(value)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateEmptyRequest(t *testing.T) {
+	t.Parallel()
+
+	schemas, errE := compileAPISchemas()
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	s := &Service{apiSchemas: schemas} //nolint:exhaustruct
+
+	for _, tt := range []struct {
+		body  string
+		valid bool
+	}{
+		{`{}`, true},
+		{` { } `, true},
+		{``, false},
+		{`{"foo":1}`, false},
+		{`[]`, false},
+	} {
+		t.Run(tt.body, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/reindex", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			assert.Equal(t, tt.valid, s.validateEmptyRequest(w, req))
+			if !tt.valid {
+				assert.Equal(t, http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+func TestGenerateOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	openAPI, errE := generateOpenAPI(&Service{}, []waf.Route{ //nolint:exhaustruct
+		{Name: "DocumentSaveChange", Path: "/d/saveChange/:session", API: &waf.RouteOptions{}, Get: nil}, //nolint:exhaustruct
+		{Name: "Home", Path: "/", API: nil, Get: &waf.RouteOptions{}},                                    //nolint:exhaustruct
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Contains(t, string(openAPI), `"/api/d/saveChange/{session}":{"post":{`)
+	assert.Contains(t, string(openAPI), `"$ref":"/schema/api.json#/$defs/change"`)
+	assert.Contains(t, string(openAPI), `"$ref":"/schema/api.json#/$defs/errorResponse"`)
+	assert.NotContains(t, string(openAPI), `"/api"`)
+
+	openAPI, errE = generateOpenAPI(&Service{}, []waf.Route{ //nolint:exhaustruct
+		{Name: "AdminSynonymSet", Path: "/admin/synonyms/:id", API: &waf.RouteOptions{}, Get: nil}, //nolint:exhaustruct
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Contains(t, string(openAPI), `"operationId":"AdminSynonymSetGet"`)
+	assert.Contains(t, string(openAPI), `"operationId":"AdminSynonymSetPut"`)
+	assert.Contains(t, string(openAPI), `"operationId":"AdminSynonymSetDelete"`)
+}
+
+func TestGenerateOpenAPIOperations(t *testing.T) {
+	t.Parallel()
+
+	var routesConfig struct {
+		Routes []waf.Route `json:"routes"`
+	}
+	errE := x.UnmarshalWithoutUnknownFields(routesConfiguration, &routesConfig)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	openAPI, errE := generateOpenAPI(&Service{}, routesConfig.Routes) //nolint:exhaustruct
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	var spec struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	errE = x.Unmarshal(openAPI, &spec)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	operationIDs := []string{}
+	for _, operations := range spec.Paths {
+		for _, operation := range operations {
+			operationIDs = append(operationIDs, operation.OperationID)
+		}
+	}
+
+	// Every operation with request or response schemas has to be in the spec.
+	for handlerName := range apiOperations {
+		assert.Contains(t, operationIDs, handlerName)
+	}
+}
@@ -0,0 +1,80 @@
+package peerdb
+
+import (
+	"io"
+	"net/http"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+type scoringPreviewRequest struct {
+	// IDs of documents to score. If empty, documents with the highest scores are returned.
+	IDs []identifier.Identifier `json:"ids,omitempty"`
+	// Scoring functions to preview. If not provided, site's scoring functions are used.
+	Scoring []search.ScoringFunction `json:"scoring,omitempty"`
+	// Size is the number of documents to return when IDs are not provided.
+	Size int `json:"size,omitempty"`
+}
+
+// AdminScoringPreviewPost is a POST HTTP request handler which scores a sample of documents
+// using only scoring functions, either site's or those provided in the request, so that
+// scoring functions can be checked before they are configured. It requires the elevated role.
+func (s *Service) AdminScoringPreviewPost(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	if !s.validateJSON(w, req, "scoringPreview", buffer) {
+		return
+	}
+
+	var r scoringPreviewRequest
+	errE := x.UnmarshalWithoutUnknownFields(buffer, &r)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	functions := r.Scoring
+	if functions == nil {
+		functions = waf.MustGetSite[*Site](req.Context()).settings().Scoring
+	} else {
+		errE = search.ValidateScoringFunctions(functions)
+		if errE != nil {
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
+	}
+
+	size := r.Size
+	if size == 0 {
+		size = search.DefaultScoringPreviewSize
+	}
+
+	results, errE := search.ScoringPreview(req.Context(), s.getSearchServiceClosure(req), functions, r.IDs, size)
+	if errors.Is(errE, search.ErrInvalidArgument) {
+		s.BadRequestWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, results, nil)
+}
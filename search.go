@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/olivere/elastic/v7"
@@ -11,10 +13,33 @@ import (
 	"gitlab.com/tozd/identifier"
 	"gitlab.com/tozd/waf"
 
+	"gitlab.com/peerdb/peerdb/document"
 	internal "gitlab.com/peerdb/peerdb/internal/store"
 	"gitlab.com/peerdb/peerdb/search"
 )
 
+// maxSearchTimeout is the maximum search timeout clients can request.
+const maxSearchTimeout = time.Minute
+
+// searchTimeoutQuery returns the search timeout in ElasticSearch format from "timeoutMs" parameter,
+// or an empty string if the parameter is not provided.
+func searchTimeoutQuery(req *http.Request) (string, errors.E) {
+	if !req.Form.Has("timeoutMs") {
+		return "", nil
+	}
+	t, err := strconv.ParseInt(req.Form.Get("timeoutMs"), 10, 64)
+	if err != nil {
+		return "", errors.WithMessage(err, `"timeoutMs" is not a valid integer`)
+	}
+	if t <= 0 || t > maxSearchTimeout.Milliseconds() {
+		errE := errors.New(`"timeoutMs" is out of range`)
+		errors.Details(errE)["timeoutMs"] = t
+		errors.Details(errE)["max"] = maxSearchTimeout.Milliseconds()
+		return "", errE
+	}
+	return fmt.Sprintf("%dms", t), nil
+}
+
 // TODO: Limit properties only to those really used in filters ("rel", "amount", "amountRange")?
 
 func (s *Service) populatePropertiesTotal(ctx context.Context) errors.E {
@@ -65,10 +90,36 @@ func (s *Service) SearchAmountFilterGet(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	data, metadata, errE := search.AmountFilterGet(req.Context(), s.getSearchServiceClosure(req), id, prop, params["unit"])
+	// Optional "metaProp" and "metaValue" parameters limit amounts
+	// to those with a matching meta relation claim.
+	var meta *search.MetaRelFilter
+	if req.Form.Has("metaProp") || req.Form.Has("metaValue") {
+		metaProp, errE := identifier.FromString(req.Form.Get("metaProp")) //nolint:govet
+		if errE != nil {
+			s.BadRequestWithError(w, req, errors.WithMessage(errE, `"metaProp" is not a valid identifier`))
+			return
+		}
+		metaValue, errE := identifier.FromString(req.Form.Get("metaValue"))
+		if errE != nil {
+			s.BadRequestWithError(w, req, errors.WithMessage(errE, `"metaValue" is not a valid identifier`))
+			return
+		}
+		meta = &search.MetaRelFilter{Prop: metaProp, Value: metaValue}
+	}
+
+	release, ok := s.enqueue(w, req, s.filtersQueue)
+	if !ok {
+		return
+	}
+	defer release()
+
+	data, metadata, errE := search.AmountFilterGet(req.Context(), s.getSearchServiceClosure(req), id, prop, params["unit"], meta)
 	if errors.Is(errE, search.ErrNotFound) {
 		s.NotFoundWithError(w, req, errE)
 		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
 	} else if errors.Is(errE, search.ErrInvalidArgument) {
 		s.BadRequestWithError(w, req, errE)
 		return
@@ -87,16 +138,85 @@ func (s *Service) SearchFiltersGet(w http.ResponseWriter, req *http.Request, par
 		return
 	}
 
-	data, metadata, errE := search.FiltersGet(req.Context(), s.getSearchServiceClosure(req), id)
+	size, ok := s.sizeParam(w, req, "size", waf.MustGetSite[*Site](req.Context()).settings().Limits.FacetSize())
+	if !ok {
+		return
+	}
+
+	release, ok := s.enqueue(w, req, s.filtersQueue)
+	if !ok {
+		return
+	}
+	defer release()
+
+	data, metadata, errE := search.FiltersGet(req.Context(), s.getSearchServiceClosure(req), id, size)
 	if errors.Is(errE, search.ErrNotFound) {
 		s.NotFoundWithError(w, req, errE)
 		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
 	} else if errors.Is(errE, search.ErrInvalidArgument) {
 		s.BadRequestWithError(w, req, errE)
 		return
 	} else if errors.Is(errE, search.ErrNotReady) {
-		s.WithError(req.Context(), errE)
-		waf.Error(w, req, http.StatusConflict)
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, data, metadata)
+}
+
+// SearchClustersGet is a GET/HEAD HTTP request handler which clusters top search results by text similarity
+// and returns to the client a JSON with an array of clusters, each with its label (most significant terms)
+// and members (IDs of documents). See search.ClusterDocuments for details.
+//
+// Optional "size" parameter sets how many top search results are clustered (search.DefaultClusterResults
+// by default) and optional "clusters" parameter sets the maximum number of clusters (search.DefaultClusters
+// by default).
+func (s *Service) SearchClustersGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	id, errE := identifier.FromString(params["s"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"s" is not a valid identifier`))
+		return
+	}
+
+	size := search.DefaultClusterResults
+	if req.Form.Has("size") {
+		var ok bool
+		size, ok = s.sizeParam(w, req, "size", search.MaxClusterResults)
+		if !ok {
+			return
+		}
+	}
+
+	clusters := search.DefaultClusters
+	if req.Form.Has("clusters") {
+		var ok bool
+		clusters, ok = s.sizeParam(w, req, "clusters", search.MaxClusters)
+		if !ok {
+			return
+		}
+	}
+
+	release, ok := s.enqueue(w, req, s.filtersQueue)
+	if !ok {
+		return
+	}
+	defer release()
+
+	data, metadata, errE := search.ClustersGet(req.Context(), s.getSearchServiceClosure(req), id, size, clusters)
+	if errors.Is(errE, search.ErrNotFound) {
+		s.NotFoundWithError(w, req, errE)
+		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
+	} else if errors.Is(errE, search.ErrNotReady) {
+		s.replyWithError(w, req, http.StatusConflict, errE)
 		return
 	} else if errE != nil {
 		s.InternalServerErrorWithError(w, req, errE)
@@ -113,10 +233,19 @@ func (s *Service) SearchIndexFilterGet(w http.ResponseWriter, req *http.Request,
 		return
 	}
 
+	release, ok := s.enqueue(w, req, s.filtersQueue)
+	if !ok {
+		return
+	}
+	defer release()
+
 	data, metadata, errE := search.IndexFilterGet(req.Context(), s.getSearchServiceClosure(req), id)
 	if errors.Is(errE, search.ErrNotFound) {
 		s.NotFoundWithError(w, req, errE)
 		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
 	} else if errors.Is(errE, search.ErrInvalidArgument) {
 		s.BadRequestWithError(w, req, errE)
 		return
@@ -142,10 +271,24 @@ func (s *Service) SearchRelFilterGet(w http.ResponseWriter, req *http.Request, p
 		return
 	}
 
-	data, metadata, errE := search.RelFilterGet(req.Context(), s.getSearchServiceClosure(req), id, prop)
+	size, ok := s.sizeParam(w, req, "size", waf.MustGetSite[*Site](req.Context()).settings().Limits.FacetSize())
+	if !ok {
+		return
+	}
+
+	release, ok := s.enqueue(w, req, s.filtersQueue)
+	if !ok {
+		return
+	}
+	defer release()
+
+	data, metadata, errE := search.RelFilterGet(req.Context(), s.getSearchServiceClosure(req), id, prop, size)
 	if errors.Is(errE, search.ErrNotFound) {
 		s.NotFoundWithError(w, req, errE)
 		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
 	} else if errors.Is(errE, search.ErrInvalidArgument) {
 		s.BadRequestWithError(w, req, errE)
 		return
@@ -164,10 +307,19 @@ func (s *Service) SearchSizeFilterGet(w http.ResponseWriter, req *http.Request,
 		return
 	}
 
+	release, ok := s.enqueue(w, req, s.filtersQueue)
+	if !ok {
+		return
+	}
+	defer release()
+
 	data, metadata, errE := search.SizeFilterGet(req.Context(), s.getSearchServiceClosure(req), id)
 	if errors.Is(errE, search.ErrNotFound) {
 		s.NotFoundWithError(w, req, errE)
 		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
 	} else if errors.Is(errE, search.ErrInvalidArgument) {
 		s.BadRequestWithError(w, req, errE)
 		return
@@ -193,10 +345,24 @@ func (s *Service) SearchStringFilterGet(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	data, metadata, errE := search.StringFilterGet(req.Context(), s.getSearchServiceClosure(req), id, prop)
+	size, ok := s.sizeParam(w, req, "size", waf.MustGetSite[*Site](req.Context()).settings().Limits.FacetSize())
+	if !ok {
+		return
+	}
+
+	release, ok := s.enqueue(w, req, s.filtersQueue)
+	if !ok {
+		return
+	}
+	defer release()
+
+	data, metadata, errE := search.StringFilterGet(req.Context(), s.getSearchServiceClosure(req), id, prop, size)
 	if errors.Is(errE, search.ErrNotFound) {
 		s.NotFoundWithError(w, req, errE)
 		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
 	} else if errors.Is(errE, search.ErrInvalidArgument) {
 		s.BadRequestWithError(w, req, errE)
 		return
@@ -222,10 +388,19 @@ func (s *Service) SearchTimeFilterGet(w http.ResponseWriter, req *http.Request,
 		return
 	}
 
-	data, metadata, errE := search.TimeFilterGet(req.Context(), s.getSearchServiceClosure(req), id, prop)
+	release, ok := s.enqueue(w, req, s.filtersQueue)
+	if !ok {
+		return
+	}
+	defer release()
+
+	data, metadata, errE := search.TimeFilterGet(req.Context(), s.getSearchServiceClosure(req), id, prop, req.Form.Get("interval"))
 	if errors.Is(errE, search.ErrNotFound) {
 		s.NotFoundWithError(w, req, errE)
 		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
 	} else if errors.Is(errE, search.ErrInvalidArgument) {
 		s.BadRequestWithError(w, req, errE)
 		return
@@ -263,13 +438,43 @@ func (s *Service) SearchResults(w http.ResponseWriter, req *http.Request, params
 	var filters *string
 	if req.Form.Has("filters") {
 		f := req.Form.Get("filters")
+		errE := waf.MustGetSite[*Site](req.Context()).checkRestrictedFilters(ctx, f)
+		if errE != nil {
+			s.replyWithError(w, req, http.StatusForbidden, errE)
+			return
+		}
+		errE = waf.MustGetSite[*Site](req.Context()).checkFilters(ctx, f)
+		if errE != nil {
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
 		filters = &f
 	}
 
+	var asOf *string
+	if req.Form.Has("asOf") {
+		a := req.Form.Get("asOf")
+		asOf = &a
+	}
+
+	if isPrompt && *searchQuery != "" {
+		// Prompt is parsed only if it differs from the one in the existing search state.
+		if sh := search.GetState(ctx, params["s"]); sh == nil || sh.Prompt != *searchQuery {
+			if !s.checkLLMBudget(w, req) {
+				return
+			}
+			if !s.checkLLMQueue(w, req) {
+				return
+			}
+		}
+	}
+
 	site := waf.MustGetSite[*Site](req.Context())
 
 	m := metrics.Duration(internal.MetricSearchState).Start()
-	sh, ok := search.GetOrCreateState(ctx, site.store, s.getSearchServiceClosure(req), params["s"], searchQuery, filters, isPrompt)
+	sh, ok := search.GetOrCreateState(
+		ctx, site.store, s.getSearchServiceClosure(req), s.recordLLMUsageClosure(req), s.llmQueue, s.llm, params["s"], searchQuery, filters, asOf, isPrompt,
+	)
 	m.Stop()
 	if !ok {
 		// Something was not OK, so we redirect to the correct URL.
@@ -299,19 +504,123 @@ func (s *Service) SearchResults(w http.ResponseWriter, req *http.Request, params
 
 type searchResult struct {
 	ID string `json:"id"`
+	// Matched are parts of the search state parsed from a prompt which the result matched.
+	Matched []search.Match `json:"matched,omitempty"`
+}
+
+// sizeParam returns the value of the optional integer parameter param, or maxValue if the
+// parameter is not provided. If the value is invalid or larger than maxValue, it replies
+// with an error and returns false.
+func (s *Service) sizeParam(w http.ResponseWriter, req *http.Request, param string, maxValue int) (int, bool) {
+	if !req.Form.Has(param) {
+		return maxValue, true
+	}
+	size, err := strconv.Atoi(req.Form.Get(param))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithMessagef(err, `"%s" is not a valid integer`, param))
+		return 0, false
+	}
+	if size <= 0 {
+		errE := errors.Errorf(`"%s" is out of range`, param)
+		errors.Details(errE)[param] = size
+		s.BadRequestWithError(w, req, errE)
+		return 0, false
+	}
+	errE := search.CheckLimit(param, size, maxValue)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return 0, false
+	}
+	return size, true
+}
+
+// strictQuery returns true if "strict" parameter is set to true.
+func strictQuery(req *http.Request) (bool, errors.E) {
+	if !req.Form.Has("strict") {
+		return false, nil
+	}
+	strict, err := strconv.ParseBool(req.Form.Get("strict"))
+	if err != nil {
+		return false, errors.WithMessage(err, `"strict" is not a valid boolean`)
+	}
+	return strict, nil
+}
+
+// checkStrictQuery replies to the request with the 400 (bad request) HTTP code and
+// a structured error describing malformed parts of the query in its details and returns
+// false if "strict" parameter is set to true and the query is malformed.
+func (s *Service) checkStrictQuery(w http.ResponseWriter, req *http.Request, query string) bool {
+	strict, errE := strictQuery(req)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return false
+	}
+	if !strict {
+		return true
+	}
+
+	errE = search.ValidateQuery(query)
+	if errE == nil {
+		return true
+	}
+
+	s.BadRequestWithError(w, req, errE)
+	return false
 }
 
 // SearchResultsGet is a GET/HEAD HTTP request handler and it searches ElasticSearch index using provided
 // search state and returns to the client a JSON with an array of IDs of found documents.
 // It returns search metadata (e.g., total results) as PeerDB HTTP response headers.
+//
+// When the search state was parsed from a prompt, each found document also lists under "matched"
+// which parts of the search state it matched: the search query ({"q": ...}) and filter clauses
+// (in the same format as filters are provided, e.g., {"rel": {"prop": ..., "value": ...}}),
+// so that clients can explain why the document was found. Deduplicated results do not list them.
+//
+// Optional "timeoutMs" parameter sets the search timeout. When the timeout is reached, results
+// gathered until then are returned and "partial" metadata is set.
+//
+// Optional "dedup" parameter is an ID of an identifier property. When provided, results
+// sharing a value of an identifier claim with that property are collapsed into
+// the highest ranked result which lists the rest as alternates. Total is not deduplicated.
+//
+// When "format" parameter is "csv" or the client accepts "text/csv", results are returned
+// as CSV instead. Optional "columns" parameter can be repeated to add columns with values
+// of claims with the given property. See parseCSVColumns for its format.
+//
+// When "size" or "session" parameter is provided, results are paginated: at most "size" results
+// (by default and at most the maximum page size of the site) are returned from a point in time snapshot of the index, together with
+// "session" metadata which should be passed as "session" parameter to obtain the next page.
+// "session" metadata is not set once there are no more results. Pagination cannot be combined with "dedup".
+//
+// When "personalize" parameter is true and personalization is enabled, results are rescored
+// for callers with an API key to rank higher documents of types and with properties of documents
+// the caller recently viewed. Callers can opt out using Do Not Track or Global Privacy Control
+// request headers, which also forgets their interaction history. Personalization cannot be
+// combined with pagination.
+//
+// Optional "weights" parameter is JSON with weights of properties used when matching the search
+// query against claims (see search.FieldWeights), merged with site's default field weights.
+//
+// When the search yields no results and the site configures fallbacks (see search.Fallbacks),
+// the search state is progressively relaxed until a relaxed search yields results, which are
+// returned instead, with applied steps in "fallback" metadata and dropped filters in
+// "fallback-dropped" metadata. Fallbacks are not used with pagination and CSV exports.
+//
+// Malformed parts of the search query are fixed (see search.ParseQuery) and described in "warnings"
+// of the search state. When "strict" parameter is true, malformed queries are instead rejected
+// with a JSON describing them.
 func (s *Service) SearchResultsGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	start := time.Now()
 	ctx := req.Context()
 	metrics := waf.MustGetMetrics(ctx)
 
+	setIndexGeneration(w, waf.MustGetSite[*Site](ctx))
+
 	// TODO: Move most of this logic to search package (similar to SearchFiltersGet).
 
 	m := metrics.Duration(internal.MetricSearchState).Start()
-	sh := search.GetState(params["s"])
+	sh := search.GetState(ctx, params["s"])
 	m.Stop()
 	if sh == nil {
 		s.NotFound(w, req)
@@ -319,25 +628,160 @@ func (s *Service) SearchResultsGet(w http.ResponseWriter, req *http.Request, par
 	}
 
 	if !sh.Ready() {
-		waf.Error(w, req, http.StatusConflict)
+		s.replyWithError(w, req, http.StatusConflict, errors.WithStack(search.ErrNotReady))
 		return
 	}
 
-	searchService, _ := s.getSearchService(req)
-	searchService = searchService.From(0).Size(search.MaxResultsCount).Query(sh.Query())
+	if !s.checkStrictQuery(w, req, sh.SearchQuery) {
+		return
+	}
+
+	csvFormat := wantsCSV(w, req)
+	var columns []csvColumn
+	if csvFormat {
+		var errE errors.E
+		columns, errE = parseCSVColumns(req.Form["columns"])
+		if errE != nil {
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
 
+		release, ok := s.enqueue(w, req, s.exportQueue)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	requestWeights, errE := search.ParseFieldWeights(req.Form.Get("weights"))
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+	// Settings are obtained once so that the whole request uses the same settings even if they are reloaded.
+	settings := waf.MustGetSite[*Site](ctx).settings()
+	weights := settings.FieldWeights.Merge(requestWeights)
+
+	var dedupProp identifier.Identifier
+	dedup := req.Form.Has("dedup")
+	if dedup {
+		dedupProp, errE = identifier.FromString(req.Form.Get("dedup"))
+		if errE != nil {
+			s.BadRequestWithError(w, req, errors.WithMessage(errE, `"dedup" is not a valid identifier`))
+			return
+		}
+	}
+
+	personalize, errE := personalizeQuery(req)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+	var profile *search.Profile
+	if personalize {
+		if user, ok := s.personalizationUser(w, req); ok {
+			p := s.personalization.Profile(user)
+			profile = &p
+		}
+		// Response depends on the caller.
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "Cookie")
+	}
+
+	// prepareQuery deduplicates, personalizes, and scores the query of the search state.
+	prepareQuery := func(query elastic.Query) elastic.Query {
+		if dedup {
+			query = search.DedupQuery(query, dedupProp)
+		}
+		if profile != nil {
+			query = search.PersonalizedQuery(query, *profile)
+		}
+		return search.ScoredQuery(query, settings.Scoring, time.Now())
+	}
+
+	query := prepareQuery(sh.NamedQuery(weights, settings.NameProperties))
+
+	sorts, errE := search.ParseSorts(req.Form.Get("sort"))
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	timeout, errE := searchTimeoutQuery(req)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	if req.Form.Has("size") || req.Form.Has("session") {
+		if dedup {
+			s.BadRequestWithError(w, req, errors.New(`"dedup" cannot be used with pagination`))
+			return
+		}
+		if personalize {
+			s.BadRequestWithError(w, req, errors.New(`"personalize" cannot be used with pagination`))
+			return
+		}
+		s.searchResultsPage(w, req, start, sh, weights, sorts, timeout, csvFormat, columns)
+		return
+	}
+
+	// Type counts are not included in CSV exports.
+	index := waf.MustGetSite[*Site](ctx).Index
+	typeCounts, typeCountsCached := search.CachedTypeCounts(index, sh)
+
+	var took time.Duration
+	doSearch := func(query elastic.Query) (*elastic.SearchResult, errors.E) {
+		res, errE := s.executeSearch(req, query, sorts, settings.TieBreakers, sh.AsOf, settings.maxResults(csvFormat), timeout, !csvFormat && !typeCountsCached)
+		if errE != nil {
+			return nil, errE
+		}
+		took += time.Duration(res.TookInMillis) * time.Millisecond
+		recordSlowQuery(req, start, sh, time.Duration(res.TookInMillis)*time.Millisecond, query)
+		return res, nil
+	}
+
+	// Duration of all searches (including fallbacks) is measured together.
 	m = metrics.Duration(internal.MetricElasticSearch).Start()
-	res, err := searchService.Do(ctx)
+	res, errE := doSearch(query)
+
+	// When the search yields no results, it is relaxed using fallbacks of the site until
+	// a relaxed search yields some. CSV exports contain only exact results.
+	resultsState := sh
+	var fallback *search.Fallback
+	if errE == nil && res.Hits.TotalHits.Value == 0 && !res.TimedOut && !csvFormat {
+		for _, f := range settings.Fallbacks.Relax(sh) {
+			var r *elastic.SearchResult
+			r, errE = doSearch(prepareQuery(f.NamedQuery(weights, settings.NameProperties)))
+			if errE != nil {
+				break
+			}
+			if r.Hits.TotalHits.Value > 0 || r.TimedOut {
+				res = r
+				resultsState = f.State
+				fallback = &f
+				break
+			}
+		}
+	}
 	m.Stop()
-	if err != nil {
-		s.InternalServerErrorWithError(w, req, errors.WithStack(err))
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = took
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
 		return
 	}
-	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
 
-	results := make([]searchResult, len(res.Hits.Hits))
-	for i, hit := range res.Hits.Hits {
-		results[i] = searchResult{ID: hit.Id}
+	// Results of documents redirected to the same canonical document are collapsed.
+	redirects := waf.MustGetSite[*Site](ctx).redirectMap
+	var results interface{}
+	if dedup {
+		results = redirects.collapseDedupResults(search.Dedup(res.Hits.Hits))
+	} else {
+		r := make([]searchResult, len(res.Hits.Hits))
+		for i, hit := range res.Hits.Hits {
+			r[i] = searchResult{ID: hit.Id, Matched: resultsState.Matches(hit.MatchedQueries)}
+		}
+		results = redirects.collapseResults(r)
 	}
 
 	// Total is a string or a number.
@@ -348,6 +792,256 @@ func (s *Service) SearchResultsGet(w http.ResponseWriter, req *http.Request, par
 		total = res.Hits.TotalHits.Value
 	}
 
+	metadata := map[string]interface{}{
+		"total": total,
+	}
+	if res.TimedOut {
+		metadata["partial"] = true
+	}
+
+	if csvFormat {
+		ids := []string{}
+		switch r := results.(type) {
+		case []search.DedupResult:
+			for _, result := range r {
+				ids = append(ids, result.ID)
+			}
+		case []searchResult:
+			for _, result := range r {
+				ids = append(ids, result.ID)
+			}
+		}
+		s.writeCSV(w, req, ids, columns, metadata)
+		return
+	}
+
+	if !typeCountsCached {
+		typeCounts, errE = search.TypeCounts(index, sh, res.Aggregations, res.TimedOut)
+		if errE != nil {
+			s.InternalServerErrorWithError(w, req, errE)
+			return
+		}
+	}
+	search.TypeCountsMetadata(typeCounts, metadata)
+
+	if fallback != nil {
+		fallback.Metadata(metadata)
+	}
+
+	s.WriteJSON(w, req, results, metadata)
+}
+
+// setIndexGeneration sets the Index-Generation response header to the current generation
+// of the site's index (see es.Generations), for debugging. Responses continuing a pagination
+// session started before a cutover still use the previous generation.
+func setIndexGeneration(w http.ResponseWriter, site *Site) {
+	if site.generations == nil {
+		return
+	}
+	w.Header().Set("Index-Generation", strconv.FormatInt(site.generations.Current(), 10))
+}
+
+// executeSearch searches for at most size documents matching the query, sorted by sorts
+// and with ties broken by tieBreakers and document ID.
+// When timeout is set and reached, results gathered until then are returned.
+// If typeCounts is true, counts of matching documents per type are aggregated as well.
+func (s *Service) executeSearch(
+	req *http.Request, query elastic.Query, sorts []search.Sort, tieBreakers search.TieBreakers, asOf *document.Timestamp, size int, timeout string, typeCounts bool,
+) (*elastic.SearchResult, errors.E) {
+	searchService, _ := s.getSearchService(req)
+	searchService = searchService.From(0).Size(size).Query(query)
+	searchService = search.SortedSearch(searchService, sorts, tieBreakers, asOf)
+
+	if typeCounts {
+		searchService = search.WithTypeCounts(searchService)
+	}
+
+	if timeout != "" {
+		// When timeout is reached, ElasticSearch returns results gathered until then
+		// (and we set the partial flag) instead of failing.
+		searchService = searchService.Timeout(timeout).AllowPartialSearchResults(true)
+	}
+
+	res, err := searchService.Do(req.Context())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return res, nil
+}
+
+// searchResultsPage returns one page of search results of a pagination session.
+// See search.Paginate for details.
+func (s *Service) searchResultsPage(
+	w http.ResponseWriter, req *http.Request, start time.Time, sh *search.State, weights search.FieldWeights, sorts []search.Sort,
+	timeout string, csvFormat bool, columns []csvColumn,
+) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	settings := waf.MustGetSite[*Site](ctx).settings()
+
+	size, ok := s.sizeParam(w, req, "size", settings.maxResults(csvFormat))
+	if !ok {
+		return
+	}
+
+	// Type counts are not included in CSV exports. They are cached, so usually
+	// they are computed only for the first page of a pagination session.
+	index := waf.MustGetSite[*Site](ctx).Index
+	typeCounts, typeCountsCached := search.CachedTypeCounts(index, sh)
+
+	getSearchService := func() *elastic.SearchService {
+		// Index is determined by the point in time, so it must not be set here.
+		searchService := s.esClient.Search().FetchSource(false).Header("X-Opaque-ID", waf.MustRequestID(ctx).String()).
+			TrackTotalHits(true).AllowPartialSearchResults(false)
+		if timeout != "" {
+			searchService = searchService.Timeout(timeout).AllowPartialSearchResults(true)
+		}
+		if !csvFormat && !typeCountsCached {
+			searchService = search.WithTypeCounts(searchService)
+		}
+		return searchService
+	}
+	openPointInTime := func() *elastic.OpenPointInTimeService {
+		return s.esClient.OpenPointInTime(index).Preference(getHost(req.RemoteAddr))
+	}
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	page, errE := search.Paginate(
+		ctx, getSearchService, s.pointsInTime, openPointInTime,
+		sh, settings.Scoring, weights, settings.NameProperties, sorts, settings.TieBreakers, size,
+		index, s.secret, req.Form.Get("session"), s.paginationKeepAlive,
+	)
+	m.Stop()
+	if errors.Is(errE, search.ErrInvalidArgument) {
+		s.BadRequestWithError(w, req, errE)
+		return
+	} else if errors.Is(errE, search.ErrSessionExpired) {
+		s.replyWithError(w, req, http.StatusGone, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = page.Took
+	// This is the query search.Paginate uses, only scored at a slightly different time.
+	recordSlowQuery(req, start, sh, page.Took, search.ScoredQuery(sh.NamedQuery(weights, settings.NameProperties), settings.Scoring, time.Now()))
+
+	results := make([]searchResult, len(page.Hits))
+	for i, hit := range page.Hits {
+		results[i] = searchResult{ID: hit.Id, Matched: sh.Matches(hit.MatchedQueries)}
+	}
+	// Results are collapsed only within the page.
+	results = waf.MustGetSite[*Site](ctx).redirectMap.collapseResults(results)
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+
+	// Total is a string or a number.
+	var total interface{}
+	if page.Total.Relation == "gte" {
+		total = fmt.Sprintf("+%d", page.Total.Value)
+	} else {
+		total = page.Total.Value
+	}
+
+	metadata := map[string]interface{}{
+		"total": total,
+	}
+	if page.TimedOut {
+		metadata["partial"] = true
+	}
+	if page.Session != "" {
+		metadata["session"] = page.Session
+	}
+
+	if csvFormat {
+		s.writeCSV(w, req, ids, columns, metadata)
+		return
+	}
+
+	if !typeCountsCached {
+		typeCounts, errE = search.TypeCounts(index, sh, page.Aggregations, page.TimedOut)
+		if errE != nil {
+			s.InternalServerErrorWithError(w, req, errE)
+			return
+		}
+	}
+	search.TypeCountsMetadata(typeCounts, metadata)
+
+	s.WriteJSON(w, req, results, metadata)
+}
+
+// SearchFederatedGet is a GET/HEAD HTTP request handler which searches indices of multiple
+// sites in one request and returns to the client a JSON with an array of found documents,
+// each labeled with its site and a score normalized per index.
+// It returns search metadata (e.g., total results across all indices) as PeerDB HTTP response headers.
+//
+// Optional "sites" parameter can be repeated to limit the search to those sites (identified
+// by their domains). By default all sites are searched. Optional "q" parameter is the search query.
+// Optional "filters" parameter is applied to all sites, while optional "filters.<domain>"
+// parameters are applied only to the corresponding site.
+func (s *Service) SearchFederatedGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	domains := req.Form["sites"]
+	if len(domains) == 0 {
+		for domain := range s.Sites {
+			domains = append(domains, domain)
+		}
+		slices.Sort(domains)
+	}
+
+	indices := make([]search.FederatedIndex, 0, len(domains))
+	for _, domain := range domains {
+		site, ok := s.Sites[domain]
+		if !ok {
+			errE := errors.New("unknown site")
+			errors.Details(errE)["site"] = domain
+			s.BadRequestWithError(w, req, errE)
+			return
+		}
+		for _, f := range []string{req.Form.Get("filters"), req.Form.Get("filters." + domain)} {
+			errE := site.checkRestrictedFilters(ctx, f)
+			if errE != nil {
+				errors.Details(errE)["site"] = domain
+				s.replyWithError(w, req, http.StatusForbidden, errE)
+				return
+			}
+			errE = site.checkFilters(ctx, f)
+			if errE != nil {
+				errors.Details(errE)["site"] = domain
+				s.BadRequestWithError(w, req, errE)
+				return
+			}
+		}
+		indices = append(indices, search.FederatedIndex{
+			Site:    domain,
+			Index:   site.Index,
+			Filters: req.Form.Get("filters." + domain),
+		})
+	}
+
+	getMultiSearchService := func() *elastic.MultiSearchService {
+		return s.esClient.MultiSearch().Header("X-Opaque-ID", waf.MustRequestID(ctx).String())
+	}
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	results, total, errE := search.Federated(ctx, getMultiSearchService, indices, req.Form.Get("q"), req.Form.Get("filters"))
+	m.Stop()
+	if errors.Is(errE, search.ErrInvalidArgument) {
+		s.BadRequestWithError(w, req, errE)
+		return
+	} else if errors.Is(errE, search.ErrRestricted) {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
 	s.WriteJSON(w, req, results, map[string]interface{}{
 		"total": total,
 	})
@@ -359,7 +1053,7 @@ func (s *Service) SearchGetGet(w http.ResponseWriter, req *http.Request, params
 	metrics := waf.MustGetMetrics(ctx)
 
 	m := metrics.Duration(internal.MetricSearchState).Start()
-	sh := search.GetState(params["s"])
+	sh := search.GetState(ctx, params["s"])
 	m.Stop()
 	if sh == nil {
 		s.NotFound(w, req)
@@ -373,10 +1067,13 @@ type searchCreateResponse struct {
 	ID          identifier.Identifier `json:"s"`
 	SearchQuery *string               `json:"q,omitempty"`
 	Prompt      string                `json:"p,omitempty"`
+	Warnings    []search.QueryWarning `json:"warnings,omitempty"`
 }
 
 // SearchCreatePost is a POST HTTP request handler which stores the search state
-// and returns the search state ID in the response.
+// and returns the search state ID in the response, together with warnings about
+// malformed parts of the search query. When "strict" parameter is true, malformed
+// queries are instead rejected with a JSON describing them.
 func (s *Service) SearchCreatePost(w http.ResponseWriter, req *http.Request, _ waf.Params) {
 	ctx := req.Context()
 	metrics := waf.MustGetMetrics(ctx)
@@ -401,15 +1098,41 @@ func (s *Service) SearchCreatePost(w http.ResponseWriter, req *http.Request, _ w
 
 	filtersJSON := req.Form.Get("filters")
 
+	errE := waf.MustGetSite[*Site](ctx).checkRestrictedFilters(ctx, filtersJSON)
+	if errE != nil {
+		s.replyWithError(w, req, http.StatusForbidden, errE)
+		return
+	}
+
+	errE = waf.MustGetSite[*Site](ctx).checkFilters(ctx, filtersJSON)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	if !isPrompt && !s.checkStrictQuery(w, req, searchQuery) {
+		return
+	}
+
+	if isPrompt && !s.checkLLMBudget(w, req) {
+		return
+	}
+
+	if isPrompt && !s.checkLLMQueue(w, req) {
+		return
+	}
+
 	site := waf.MustGetSite[*Site](req.Context())
 
 	m := metrics.Duration(internal.MetricSearchState).Start()
-	sh := search.CreateState(ctx, site.store, s.getSearchServiceClosure(req), currentSearchState, searchQuery, filtersJSON, isPrompt)
+	sh := search.CreateState(
+		ctx, site.store, s.getSearchServiceClosure(req), s.recordLLMUsageClosure(req), s.llmQueue, s.llm, currentSearchState, searchQuery, filtersJSON, req.Form.Get("asOf"), isPrompt,
+	)
 	m.Stop()
 
 	var q *string
 	if sh.Prompt == "" {
 		q = &sh.SearchQuery
 	}
-	s.WriteJSON(w, req, searchCreateResponse{ID: sh.ID, SearchQuery: q, Prompt: sh.Prompt}, nil)
+	s.WriteJSON(w, req, searchCreateResponse{ID: sh.ID, SearchQuery: q, Prompt: sh.Prompt, Warnings: sh.Warnings}, nil)
 }
@@ -55,8 +55,11 @@ type histogramAmountResult struct {
 	Count int64   `json:"count"`
 }
 
+// AmountFilterGet returns a histogram of amounts of amount claims with the property and unit
+// for documents matching the search. Optional meta limits amount claims to those with a matching
+// meta relation claim (e.g., amounts per serving).
 func AmountFilterGet(
-	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id, prop identifier.Identifier, unit string,
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id, prop identifier.Identifier, unit string, meta *MetaRelFilter,
 ) (interface{}, map[string]interface{}, errors.E) {
 	metrics := waf.MustGetMetrics(ctx)
 
@@ -67,14 +70,21 @@ func AmountFilterGet(
 		return nil, nil, errors.Errorf(`%w: "unit" cannot be "@"`, ErrInvalidArgument)
 	}
 
-	m := metrics.Duration(internal.MetricSearchState).Start()
-	ss, ok := searches.Load(id)
-	m.Stop()
-	if !ok {
-		// Something was not OK, so we return not found.
-		return nil, nil, errors.WithStack(ErrNotFound)
+	errE := CheckRestricted(ctx, prop)
+	if errE != nil {
+		return nil, nil, errE
+	}
+	if meta != nil {
+		errE = CheckRestricted(ctx, meta.Prop)
+		if errE != nil {
+			return nil, nil, errE
+		}
+	}
+
+	sh, errE := loadState(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
 	}
-	sh := ss.(*State) //nolint:errcheck,forcetypeassert
 
 	query := sh.Query()
 
@@ -82,11 +92,7 @@ func AmountFilterGet(
 	minMaxAggregation := elastic.NewNestedAggregation().Path("claims.amount").SubAggregation(
 		"filter",
 		elastic.NewFilterAggregation().Filter(
-			elastic.NewBoolQuery().Must(
-				elastic.NewTermQuery("claims.amount.prop.id", prop),
-			).Must(
-				elastic.NewTermQuery("claims.amount.unit", unit),
-			),
+			amountQuery(prop, unit, meta),
 		).SubAggregation(
 			"min",
 			elastic.NewMinAggregation().Field("claims.amount.amount"),
@@ -105,7 +111,7 @@ func AmountFilterGet(
 	)
 	minMaxSearchService = minMaxSearchService.Size(0).Query(query).Aggregation("minMax", minMaxAggregation)
 
-	m = metrics.Duration(internal.MetricElasticSearch1).Start()
+	m := metrics.Duration(internal.MetricElasticSearch1).Start()
 	res, err := minMaxSearchService.Do(ctx)
 	m.Stop()
 	if err != nil {
@@ -115,7 +121,7 @@ func AmountFilterGet(
 
 	m = metrics.Duration(internal.MetricJSONUnmarshal1).Start()
 	var minMax minMaxAmountAggregations
-	errE := x.Unmarshal(res.Aggregations["minMax"], &minMax)
+	errE = x.Unmarshal(res.Aggregations["minMax"], &minMax)
 	m.Stop()
 	if errE != nil {
 		return nil, nil, errE
@@ -148,11 +154,7 @@ func AmountFilterGet(
 	histogramAggregation := elastic.NewNestedAggregation().Path("claims.amount").SubAggregation(
 		"filter",
 		elastic.NewFilterAggregation().Filter(
-			elastic.NewBoolQuery().Must(
-				elastic.NewTermQuery("claims.amount.prop.id", prop),
-			).Must(
-				elastic.NewTermQuery("claims.amount.unit", unit),
-			),
+			amountQuery(prop, unit, meta),
 		).SubAggregation(
 			"hist",
 			elastic.NewHistogramAggregation().Field("claims.amount.amount").Offset(minValue).Interval(interval).SubAggregation(
@@ -0,0 +1,338 @@
+package search
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/fun"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+const (
+	// DefaultLLMPromptPrice is the default price in USD per million prompt tokens.
+	DefaultLLMPromptPrice = 3.0
+	// DefaultLLMResponsePrice is the default price in USD per million response tokens.
+	DefaultLLMResponsePrice = 15.0
+)
+
+// ErrBudgetExceeded is returned when the monthly LLM budget for an API key is exhausted.
+var ErrBudgetExceeded = errors.Base("budget exceeded")
+
+// LLMUsage is LLM usage of one API key in one month.
+type LLMUsage struct {
+	Month          string  `json:"month"`
+	Prompts        int64   `json:"prompts"`
+	PromptTokens   int64   `json:"promptTokens"`
+	ResponseTokens int64   `json:"responseTokens"`
+	Cost           float64 `json:"cost"`
+}
+
+// LLMBudget tracks LLM usage per API key and enforces a monthly budget for each API key.
+//
+// Once initialized (see Init), usage is stored in PostgreSQL, so that it is shared between
+// instances and kept across restarts. Otherwise it is tracked in memory only, for the current month.
+// Usage which could not be stored in PostgreSQL is kept in memory and stored again before
+// the next budget check for the API key, which fails if storing fails again.
+type LLMBudget struct {
+	// Monthly is the budget in USD per API key per month. Zero disables the limit.
+	Monthly float64
+
+	// PromptPrice is the price in USD per million prompt tokens.
+	PromptPrice float64
+
+	// ResponsePrice is the price in USD per million response tokens.
+	ResponsePrice float64
+
+	// Prefix to use when initializing PostgreSQL objects used by the budget.
+	Prefix string
+
+	dbpool *pgxpool.Pool
+	mu     sync.Mutex
+	usage  map[string]*LLMUsage
+	// unrecorded is usage per API key which could not be stored in PostgreSQL.
+	unrecorded map[string][]LLMUsage
+}
+
+// Init initializes the LLMBudget to store usage in PostgreSQL.
+//
+// It creates and configures the PostgreSQL table if it does not already exist.
+func (b *LLMBudget) Init(ctx context.Context, dbpool *pgxpool.Pool) errors.E {
+	if b.dbpool != nil {
+		return errors.New("already initialized")
+	}
+
+	// TODO: Use schema management/migration instead.
+	errE := internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		_, err := tx.Exec(ctx, `
+			CREATE TABLE "`+b.Prefix+`LLMUsage" (
+				"key" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				-- Month in UTC, in "YYYY-MM" format.
+				"month" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				"prompts" bigint NOT NULL,
+				"promptTokens" bigint NOT NULL,
+				"responseTokens" bigint NOT NULL,
+				"cost" double precision NOT NULL,
+				PRIMARY KEY ("month", "key")
+			);
+		`)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+
+		return nil
+	}, nil)
+	if errE != nil {
+		var pgError *pgconn.PgError
+		if errors.As(errE, &pgError) {
+			switch pgError.Code {
+			case internal.ErrorCodeUniqueViolation:
+				// Nothing.
+			case internal.ErrorCodeDuplicateTable:
+				// Nothing.
+			case internal.ErrorCodeReadOnlyTransaction:
+				// In read-only mode, tables have to be created by another instance.
+			default:
+				return errE
+			}
+		} else {
+			return errE
+		}
+	}
+
+	b.dbpool = dbpool
+
+	return nil
+}
+
+func month(now time.Time) string {
+	return now.UTC().Format("2006-01")
+}
+
+// get returns usage of the API key for the current month. It must be called with the mutex held.
+func (b *LLMBudget) get(key string, now time.Time) *LLMUsage {
+	m := month(now)
+	if b.usage == nil {
+		b.usage = map[string]*LLMUsage{}
+	}
+	usage, ok := b.usage[key]
+	if !ok || usage.Month != m {
+		usage = &LLMUsage{Month: m} //nolint:exhaustruct
+		b.usage[key] = usage
+	}
+	return usage
+}
+
+// load returns usage of the API key for the current month.
+func (b *LLMBudget) load(ctx context.Context, key string) (LLMUsage, errors.E) {
+	if b.dbpool == nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		return *b.get(key, time.Now()), nil
+	}
+
+	m := month(time.Now())
+	usage := LLMUsage{Month: m} //nolint:exhaustruct
+	errE := internal.RetryTransaction(ctx, b.dbpool, pgx.ReadOnly, func(ctx context.Context, tx pgx.Tx) errors.E {
+		err := tx.QueryRow(ctx, `
+			SELECT "prompts", "promptTokens", "responseTokens", "cost" FROM "`+b.Prefix+`LLMUsage" WHERE "month"=$1 AND "key"=$2
+		`, m, key).Scan(&usage.Prompts, &usage.PromptTokens, &usage.ResponseTokens, &usage.Cost)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["key"] = key
+		return usage, errE
+	}
+	return usage, nil
+}
+
+// Allow returns ErrBudgetExceeded if the API key has already used its monthly budget.
+//
+// Usage is recorded only once the prompt is parsed, so concurrent prompts can exceed the budget.
+// Usage of the API key which could not be recorded before is recorded first and if that fails,
+// the error is returned, so that prompts are not allowed while their usage cannot be recorded.
+func (b *LLMBudget) Allow(ctx context.Context, key string) errors.E {
+	if b == nil {
+		return nil
+	}
+
+	errE := b.recordUnrecorded(ctx, key)
+	if errE != nil {
+		return errE
+	}
+
+	if b.Monthly <= 0 {
+		return nil
+	}
+
+	usage, errE := b.load(ctx, key)
+	if errE != nil {
+		return errE
+	}
+	if usage.Cost >= b.Monthly {
+		errE := errors.WithStack(ErrBudgetExceeded)
+		errors.Details(errE)["month"] = usage.Month
+		errors.Details(errE)["cost"] = usage.Cost
+		errors.Details(errE)["budget"] = b.Monthly
+		return errE
+	}
+	return nil
+}
+
+func sumUsedTokens(calls []fun.TextRecorderCall) (int64, int64) {
+	prompt := int64(0)
+	response := int64(0)
+	for i := range calls {
+		for _, usedTokens := range calls[i].UsedTokens {
+			prompt += int64(usedTokens.Prompt)
+			response += int64(usedTokens.Response)
+		}
+		for j := range calls[i].Messages {
+			p, r := sumUsedTokens(calls[i].Messages[j].ToolCalls)
+			prompt += p
+			response += r
+		}
+	}
+	return prompt, response
+}
+
+// Record records LLM usage of calls made while parsing one prompt for the API key.
+//
+// If usage cannot be stored in PostgreSQL, the error is returned and usage is stored again
+// before the next budget check for the API key (see Allow).
+func (b *LLMBudget) Record(ctx context.Context, key string, calls []fun.TextRecorderCall) errors.E {
+	if b == nil {
+		return nil
+	}
+
+	prompt, response := sumUsedTokens(calls)
+	cost := (float64(prompt)*b.PromptPrice + float64(response)*b.ResponsePrice) / 1_000_000 //nolint:mnd
+
+	if b.dbpool == nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		usage := b.get(key, time.Now())
+		usage.Prompts++
+		usage.PromptTokens += prompt
+		usage.ResponseTokens += response
+		usage.Cost += cost
+		return nil
+	}
+
+	usage := LLMUsage{
+		Month:          month(time.Now()),
+		Prompts:        1,
+		PromptTokens:   prompt,
+		ResponseTokens: response,
+		Cost:           cost,
+	}
+	errE := b.store(ctx, key, usage)
+	if errE != nil {
+		// We keep usage to store it again before the next budget check for the API key.
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.unrecorded == nil {
+			b.unrecorded = map[string][]LLMUsage{}
+		}
+		b.unrecorded[key] = append(b.unrecorded[key], usage)
+		return errE
+	}
+	return nil
+}
+
+// recordUnrecorded stores usage of the API key which could not be stored in PostgreSQL before.
+// Usage which cannot be stored again is kept for the next attempt.
+func (b *LLMBudget) recordUnrecorded(ctx context.Context, key string) errors.E {
+	b.mu.Lock()
+	unrecorded := b.unrecorded[key]
+	delete(b.unrecorded, key)
+	b.mu.Unlock()
+
+	for i, usage := range unrecorded {
+		errE := b.store(ctx, key, usage)
+		if errE != nil {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			b.unrecorded[key] = append(unrecorded[i:], b.unrecorded[key]...)
+			return errE
+		}
+	}
+	return nil
+}
+
+// store adds usage of the API key to usage stored in PostgreSQL.
+func (b *LLMBudget) store(ctx context.Context, key string, usage LLMUsage) errors.E {
+	errE := internal.RetryTransaction(ctx, b.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO "`+b.Prefix+`LLMUsage" AS u VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT ("month", "key") DO UPDATE SET
+					"prompts"=u."prompts"+EXCLUDED."prompts",
+					"promptTokens"=u."promptTokens"+EXCLUDED."promptTokens",
+					"responseTokens"=u."responseTokens"+EXCLUDED."responseTokens",
+					"cost"=u."cost"+EXCLUDED."cost"
+		`, key, usage.Month, usage.Prompts, usage.PromptTokens, usage.ResponseTokens, usage.Cost)
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["key"] = key
+		errors.Details(errE)["month"] = usage.Month
+		return errE
+	}
+	return nil
+}
+
+// Usage returns LLM usage for the current month of all API keys which made prompts this month.
+func (b *LLMBudget) Usage(ctx context.Context) (map[string]LLMUsage, errors.E) {
+	result := map[string]LLMUsage{}
+	if b == nil {
+		return result, nil
+	}
+
+	m := month(time.Now())
+
+	if b.dbpool == nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		for key, usage := range b.usage {
+			if usage.Month == m {
+				result[key] = *usage
+			}
+		}
+		return result, nil
+	}
+
+	errE := internal.RetryTransaction(ctx, b.dbpool, pgx.ReadOnly, func(ctx context.Context, tx pgx.Tx) errors.E {
+		// We reset the result on every retry.
+		result = map[string]LLMUsage{}
+		rows, err := tx.Query(ctx, `
+			SELECT "key", "prompts", "promptTokens", "responseTokens", "cost" FROM "`+b.Prefix+`LLMUsage" WHERE "month"=$1
+		`, m)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+		var key string
+		usage := LLMUsage{Month: m} //nolint:exhaustruct
+		_, err = pgx.ForEachRow(rows, []any{&key, &usage.Prompts, &usage.PromptTokens, &usage.ResponseTokens, &usage.Cost}, func() error {
+			result[key] = usage
+			return nil
+		})
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		return nil, errE
+	}
+	return result, nil
+}
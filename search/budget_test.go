@@ -0,0 +1,187 @@
+package search_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/fun"
+	"gitlab.com/tozd/identifier"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestLLMBudget(t *testing.T) {
+	t.Parallel()
+
+	testLLMBudget(t, context.Background(), &search.LLMBudget{ //nolint:exhaustruct
+		Monthly:       0.01,
+		PromptPrice:   search.DefaultLLMPromptPrice,
+		ResponsePrice: search.DefaultLLMResponsePrice,
+	})
+}
+
+func TestLLMBudgetPostgres(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("POSTGRES") == "" {
+		t.Skip("POSTGRES is not available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	schema := identifier.New().String()
+
+	dbpool, errE := internal.InitPostgres(ctx, os.Getenv("POSTGRES"), logger, func(context.Context) (string, string) {
+		return schema, "tests"
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		return internal.EnsureSchema(ctx, tx, schema)
+	}, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	budget := &search.LLMBudget{ //nolint:exhaustruct
+		Monthly:       0.01,
+		PromptPrice:   search.DefaultLLMPromptPrice,
+		ResponsePrice: search.DefaultLLMResponsePrice,
+		Prefix:        identifier.New().String() + "_",
+	}
+	errE = budget.Init(ctx, dbpool)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	testLLMBudget(t, ctx, budget)
+
+	// Usage is shared between instances using the same database.
+	other := &search.LLMBudget{ //nolint:exhaustruct
+		Monthly:       0.01,
+		PromptPrice:   search.DefaultLLMPromptPrice,
+		ResponsePrice: search.DefaultLLMResponsePrice,
+		Prefix:        budget.Prefix,
+	}
+	errE = other.Init(ctx, dbpool)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = other.Allow(ctx, "key")
+	assert.ErrorIs(t, errE, search.ErrBudgetExceeded)
+
+	// Usage which cannot be stored (here because of read-only transactions) is not lost.
+	readOnlyCtx := internal.WithReadOnly(ctx)
+	errE = budget.Record(readOnlyCtx, "failed", []fun.TextRecorderCall{{ //nolint:exhaustruct
+		UsedTokens: map[string]fun.TextRecorderUsedTokens{
+			"a": {Prompt: 10000, Response: 0}, //nolint:exhaustruct
+		},
+	}})
+	require.Error(t, errE)
+	// The budget check fails while usage cannot be stored.
+	errE = budget.Allow(readOnlyCtx, "failed")
+	require.Error(t, errE)
+	assert.NotErrorIs(t, errE, search.ErrBudgetExceeded)
+	// Once it can be stored, it is counted against the budget.
+	errE = budget.Allow(ctx, "failed")
+	assert.ErrorIs(t, errE, search.ErrBudgetExceeded)
+	usage, errE := budget.Usage(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(1), usage["failed"].Prompts)
+	assert.Equal(t, int64(10000), usage["failed"].PromptTokens)
+}
+
+func TestLLMBudgetExceeded(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	calls := []fun.TextRecorderCall{{ //nolint:exhaustruct
+		UsedTokens: map[string]fun.TextRecorderUsedTokens{
+			"a": {Prompt: 1000, Response: 1000}, //nolint:exhaustruct
+		},
+	}}
+
+	budget := &search.LLMBudget{ //nolint:exhaustruct
+		Monthly:       0.01,
+		PromptPrice:   search.DefaultLLMPromptPrice,
+		ResponsePrice: search.DefaultLLMResponsePrice,
+	}
+
+	// The last prompt is allowed even if it then exceeds the budget.
+	errE := budget.Allow(ctx, "key")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = budget.Record(ctx, "key", calls)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = budget.Allow(ctx, "key")
+	require.ErrorIs(t, errE, search.ErrBudgetExceeded)
+	details := errors.AllDetails(errE)
+	assert.InDelta(t, 0.018, details["cost"], 1e-9)
+	assert.InDelta(t, 0.01, details["budget"], 1e-9)
+	assert.NotEmpty(t, details["month"])
+
+	// Without a monthly budget, usage is only tracked.
+	unlimited := &search.LLMBudget{ //nolint:exhaustruct
+		Monthly:       0,
+		PromptPrice:   search.DefaultLLMPromptPrice,
+		ResponsePrice: search.DefaultLLMResponsePrice,
+	}
+	for range 3 {
+		errE = unlimited.Record(ctx, "key", calls)
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE = unlimited.Allow(ctx, "key")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	usage, errE := unlimited.Usage(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, int64(3), usage["key"].Prompts)
+}
+
+func testLLMBudget(t *testing.T, ctx context.Context, budget *search.LLMBudget) { //nolint:revive
+	t.Helper()
+
+	errE := budget.Allow(ctx, "key")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = budget.Record(ctx, "key", []fun.TextRecorderCall{{ //nolint:exhaustruct
+		UsedTokens: map[string]fun.TextRecorderUsedTokens{
+			"a": {Prompt: 1000, Response: 100}, //nolint:exhaustruct
+		},
+		Messages: []fun.TextRecorderMessage{{ //nolint:exhaustruct
+			ToolCalls: []fun.TextRecorderCall{{ //nolint:exhaustruct
+				UsedTokens: map[string]fun.TextRecorderUsedTokens{
+					"b": {Prompt: 1000, Response: 100}, //nolint:exhaustruct
+				},
+			}},
+		}},
+	}})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	usage, errE := budget.Usage(ctx)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Contains(t, usage, "key")
+	assert.Equal(t, int64(1), usage["key"].Prompts)
+	assert.Equal(t, int64(2000), usage["key"].PromptTokens)
+	assert.Equal(t, int64(200), usage["key"].ResponseTokens)
+	assert.InDelta(t, 0.009, usage["key"].Cost, 1e-9)
+
+	errE = budget.Allow(ctx, "key")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = budget.Record(ctx, "key", []fun.TextRecorderCall{{ //nolint:exhaustruct
+		UsedTokens: map[string]fun.TextRecorderUsedTokens{
+			"c": {Prompt: 1000, Response: 0}, //nolint:exhaustruct
+		},
+	}})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = budget.Allow(ctx, "key")
+	assert.ErrorIs(t, errE, search.ErrBudgetExceeded)
+
+	// Other keys have their own budget.
+	errE = budget.Allow(ctx, "other")
+	require.NoError(t, errE, "% -+#.1v", errE)
+}
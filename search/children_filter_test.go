@@ -0,0 +1,38 @@
+//nolint:testpackage
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChildrenFilter(t *testing.T) {
+	t.Parallel()
+
+	f, errE := parseFilters(`{"children":{"query":"chapter"}}`)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, f.matchable())
+
+	source, err := f.ToQuery(nil).Source()
+	require.NoError(t, err)
+	data, err := json.Marshal(source)
+	require.NoError(t, err)
+
+	nested := func(path, field string) string {
+		return `{"nested":{"path":"` + path + `","query":{"simple_query_string":{"default_operator":"and","fields":["` +
+			path + `.` + field + `"],"query":"chapter"}}}}`
+	}
+	assert.JSONEq(t, `{"nested":{"path":"children","query":{"bool":{"should":[`+
+		`{"term":{"children.id":"chapter"}},`+
+		nested("children.claims.id", "value")+`,`+
+		nested("children.claims.ref", "iri")+`,`+
+		nested("children.claims.text", "html.en")+`,`+
+		nested("children.claims.string", "string")+
+		`]}}}}`, string(data))
+
+	_, errE = parseFilters(`{"children":{"query":""}}`)
+	assert.ErrorIs(t, errE, ErrInvalidArgument)
+}
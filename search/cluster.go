@@ -0,0 +1,412 @@
+package search
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+	"golang.org/x/net/html"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+const (
+	// DefaultClusterResults is the default number of top search results which are clustered.
+	DefaultClusterResults = 100
+	// MaxClusterResults is the maximum number of top search results which can be clustered.
+	MaxClusterResults = 1000
+
+	// DefaultClusters is the default number of clusters.
+	DefaultClusters = 5
+	// MaxClusters is the maximum number of clusters.
+	MaxClusters = 20
+
+	// Number of terms used as a cluster label.
+	clusterLabelTerms = 3
+	// Maximum number of k-means iterations.
+	clusterIterations = 20
+	// Terms shorter than this are ignored.
+	minClusterTermLength = 3
+)
+
+//nolint:gochecknoglobals
+var clusterStopWords = map[string]bool{
+	"about": true, "after": true, "all": true, "also": true, "and": true, "any": true, "are": true, "been": true,
+	"before": true, "between": true, "but": true, "can": true, "could": true, "did": true, "does": true, "during": true,
+	"each": true, "for": true, "from": true, "had": true, "has": true, "have": true, "her": true, "his": true,
+	"into": true, "its": true, "more": true, "most": true, "not": true, "one": true, "only": true, "other": true,
+	"our": true, "over": true, "she": true, "some": true, "such": true, "than": true, "that": true, "the": true,
+	"their": true, "them": true, "then": true, "there": true, "these": true, "they": true, "this": true, "those": true,
+	"through": true, "under": true, "was": true, "were": true, "what": true, "when": true, "where": true, "which": true,
+	"while": true, "who": true, "will": true, "with": true, "would": true, "you": true, "your": true,
+}
+
+// Cluster is a group of search results about a similar theme.
+type Cluster struct {
+	// Label are the most significant terms of the cluster, in the order of significance.
+	// Results which could not be clustered (e.g., without any text) are returned
+	// as the last cluster without a label.
+	Label []string `json:"label,omitempty"`
+	// Members are IDs of documents in the cluster, in the order of their rank.
+	Members []string `json:"members"`
+}
+
+// ClusterDocument is a search result to cluster.
+type ClusterDocument struct {
+	ID   string
+	Text string
+}
+
+// clusterTerms tokenizes text into stemmed terms. For each term it records
+// how many times each surface form has been seen into forms.
+func clusterTerms(text string, forms map[string]map[string]int) map[string]int {
+	terms := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) < minClusterTermLength || clusterStopWords[word] {
+			continue
+		}
+		if strings.IndexFunc(word, unicode.IsLetter) == -1 {
+			continue
+		}
+		term := clusterStem(word)
+		terms[term]++
+		if forms[term] == nil {
+			forms[term] = map[string]int{}
+		}
+		forms[term][word]++
+	}
+	return terms
+}
+
+// clusterStem removes the plural suffix from the word.
+func clusterStem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case len(word) > 3 && strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return strings.TrimSuffix(word, "s")
+	default:
+		return word
+	}
+}
+
+// vector is a sparse L2-normalized vector of term weights.
+type vector map[string]float64
+
+func (v vector) normalize() vector {
+	norm := 0.0
+	for _, w := range v {
+		norm += w * w
+	}
+	if norm == 0 {
+		return v
+	}
+	norm = math.Sqrt(norm)
+	for t, w := range v {
+		v[t] = w / norm
+	}
+	return v
+}
+
+func (v vector) dot(other vector) float64 {
+	if len(other) < len(v) {
+		v, other = other, v
+	}
+	sum := 0.0
+	for t, w := range v {
+		sum += w * other[t]
+	}
+	return sum
+}
+
+// ClusterDocuments groups documents by text similarity into at most k clusters.
+//
+// Documents are represented as TF-IDF vectors of terms in their text, where document frequencies
+// are computed over the documents themselves, so terms common to all documents (e.g., the search query)
+// do not contribute. Vectors are clustered using spherical k-means, deterministically initialized
+// with the highest ranked document and then documents least similar to already chosen centroids.
+// Each cluster is labeled with terms which are the most overrepresented in the cluster compared
+// to all documents. Clusters are ordered by decreasing size.
+func ClusterDocuments(docs []ClusterDocument, k int) []Cluster {
+	forms := map[string]map[string]int{}
+	docTerms := make([]map[string]int, len(docs))
+	df := map[string]int{}
+	for i, doc := range docs {
+		docTerms[i] = clusterTerms(doc.Text, forms)
+		for term := range docTerms[i] {
+			df[term]++
+		}
+	}
+
+	n := float64(len(docs))
+	vectors := make([]vector, len(docs))
+	clusterable := []int{}
+	unclustered := []string{}
+	for i, terms := range docTerms {
+		v := vector{}
+		for term, count := range terms {
+			// Terms in only one document do not make documents similar and terms in all documents
+			// do not make them different.
+			if df[term] < 2 || df[term] == len(docs) {
+				continue
+			}
+			v[term] = (1 + math.Log(float64(count))) * math.Log(n/float64(df[term]))
+		}
+		if len(v) == 0 {
+			unclustered = append(unclustered, docs[i].ID)
+			continue
+		}
+		vectors[i] = v.normalize()
+		clusterable = append(clusterable, i)
+	}
+
+	k = min(k, len(clusterable))
+	if k <= 0 {
+		if len(unclustered) == 0 {
+			return []Cluster{}
+		}
+		return []Cluster{{Label: nil, Members: unclustered}}
+	}
+
+	centroids := initialCentroids(vectors, clusterable, k)
+	assignments := make([]int, len(docs))
+	for iteration := range clusterIterations {
+		changed := false
+		for _, i := range clusterable {
+			best := 0
+			bestSimilarity := math.Inf(-1)
+			for c, centroid := range centroids {
+				similarity := vectors[i].dot(centroid)
+				if similarity > bestSimilarity {
+					best = c
+					bestSimilarity = similarity
+				}
+			}
+			if iteration == 0 || assignments[i] != best {
+				changed = true
+			}
+			assignments[i] = best
+		}
+		if !changed {
+			break
+		}
+		for c := range centroids {
+			centroid := vector{}
+			for _, i := range clusterable {
+				if assignments[i] == c {
+					for t, w := range vectors[i] {
+						centroid[t] += w
+					}
+				}
+			}
+			centroids[c] = centroid.normalize()
+		}
+	}
+
+	members := make([][]int, k)
+	for _, i := range clusterable {
+		members[assignments[i]] = append(members[assignments[i]], i)
+	}
+
+	members = slices.DeleteFunc(members, func(m []int) bool {
+		return len(m) == 0
+	})
+	// Members are in rank order, so ties are ordered by the rank of their first member.
+	slices.SortFunc(members, func(a, b []int) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return a[0] - b[0]
+	})
+
+	clusters := []Cluster{}
+	for _, m := range members {
+		ids := make([]string, len(m))
+		for j, i := range m {
+			ids[j] = docs[i].ID
+		}
+		clusters = append(clusters, Cluster{Label: clusterLabel(m, vectors, df, n, forms), Members: ids})
+	}
+	if len(unclustered) > 0 {
+		clusters = append(clusters, Cluster{Label: nil, Members: unclustered})
+	}
+	return clusters
+}
+
+// initialCentroids chooses k documents as initial centroids: the first (highest ranked) document
+// and then repeatedly the document least similar to any already chosen centroid.
+func initialCentroids(vectors []vector, clusterable []int, k int) []vector {
+	chosen := []int{clusterable[0]}
+	// Maximum similarity of each document to already chosen centroids.
+	similarities := make([]float64, len(vectors))
+	for len(chosen) < k {
+		last := vectors[chosen[len(chosen)-1]]
+		next := -1
+		for _, i := range clusterable {
+			similarities[i] = max(similarities[i], vectors[i].dot(last))
+			if slices.Contains(chosen, i) {
+				continue
+			}
+			if next == -1 || similarities[i] < similarities[next] {
+				next = i
+			}
+		}
+		chosen = append(chosen, next)
+	}
+
+	centroids := make([]vector, k)
+	for c, i := range chosen {
+		centroids[c] = vector{}
+		for t, w := range vectors[i] {
+			centroids[c][t] = w
+		}
+	}
+	return centroids
+}
+
+// clusterLabel returns the most significant terms of the cluster with members, using their most common surface forms.
+//
+// Significance of a term is computed as (fg - bg) * fg / bg, where fg is the fraction of cluster members
+// with the term and bg is the fraction of all documents with the term.
+func clusterLabel(members []int, vectors []vector, df map[string]int, n float64, forms map[string]map[string]int) []string {
+	counts := map[string]int{}
+	for _, i := range members {
+		for term := range vectors[i] {
+			counts[term]++
+		}
+	}
+	type scored struct {
+		term  string
+		score float64
+	}
+	terms := []scored{}
+	for term, count := range counts {
+		fg := float64(count) / float64(len(members))
+		bg := float64(df[term]) / n
+		if fg <= bg {
+			continue
+		}
+		terms = append(terms, scored{term: term, score: (fg - bg) * fg / bg})
+	}
+	slices.SortFunc(terms, func(a, b scored) int {
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.term, b.term)
+	})
+
+	label := []string{}
+	for _, t := range terms[:min(clusterLabelTerms, len(terms))] {
+		label = append(label, surfaceForm(forms[t.term]))
+	}
+	return label
+}
+
+// surfaceForm returns the most common surface form.
+func surfaceForm(forms map[string]int) string {
+	best := ""
+	for form, count := range forms {
+		if best == "" || count > forms[best] || (count == forms[best] && form < best) {
+			best = form
+		}
+	}
+	return best
+}
+
+// htmlText returns text content of HTML.
+func htmlText(s string) string {
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			b.Write(tokenizer.Text())
+			b.WriteString(" ")
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken, html.CommentToken, html.DoctypeToken:
+		}
+	}
+}
+
+type clusterSource struct {
+	Claims struct {
+		Text []struct {
+			HTML map[string]string `json:"html"`
+		} `json:"text"`
+		String []struct {
+			String string `json:"string"`
+		} `json:"string"`
+	} `json:"claims"`
+}
+
+// ClustersGet clusters the top size results of the search state by text similarity into at most k clusters.
+// Text of results are their text and string claims. See ClusterDocuments for details.
+func ClustersGet(
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id identifier.Identifier, size, k int,
+) (interface{}, map[string]interface{}, errors.E) {
+	metrics := waf.MustGetMetrics(ctx)
+
+	sh, errE := loadState(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	if !sh.Ready() {
+		return nil, nil, errors.WithStack(ErrNotReady)
+	}
+
+	searchService, _ := getSearchService()
+	searchService = searchService.From(0).Size(size).Query(sh.Query()).SortBy(Sorters(nil, nil, nil)...).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("claims.text.html.en", "claims.string.string"))
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	res, err := searchService.Do(ctx)
+	m.Stop()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
+
+	m = metrics.Duration(internal.MetricJSONUnmarshal).Start()
+	docs := make([]ClusterDocument, len(res.Hits.Hits))
+	for i, hit := range res.Hits.Hits {
+		var source clusterSource
+		errE := x.Unmarshal(hit.Source, &source)
+		if errE != nil {
+			m.Stop()
+			errors.Details(errE)["doc"] = hit.Id
+			return nil, nil, errE
+		}
+		texts := []string{}
+		for _, t := range source.Claims.Text {
+			texts = append(texts, htmlText(t.HTML["en"]))
+		}
+		for _, s := range source.Claims.String {
+			texts = append(texts, s.String)
+		}
+		docs[i] = ClusterDocument{ID: hit.Id, Text: strings.Join(texts, " ")}
+	}
+	m.Stop()
+
+	clusters := ClusterDocuments(docs, k)
+
+	return clusters, map[string]interface{}{
+		"total": strconv.Itoa(len(clusters)),
+	}, nil
+}
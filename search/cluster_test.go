@@ -0,0 +1,38 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestClusterDocuments(t *testing.T) {
+	t.Parallel()
+
+	docs := []search.ClusterDocument{
+		{ID: "a", Text: "Brooklyn Bridge, a photograph of the bridge at night"},
+		{ID: "b", Text: "Golden Gate Bridge, architectural drawing with elevation and section"},
+		{ID: "c", Text: "Tower Bridge photographs, gelatin silver print photograph"},
+		{ID: "d", Text: "Bridge over a river, architectural drawings, elevation"},
+		{ID: "e", Text: "Photograph of a bridge, gelatin silver print"},
+		{ID: "f", Text: "Bridge"},
+	}
+
+	clusters := search.ClusterDocuments(docs, 2)
+	assert.Equal(t, []search.Cluster{
+		{Label: []string{"photograph", "gelatin", "print"}, Members: []string{"a", "c", "e"}},
+		{Label: []string{"architectural", "drawing", "elevation"}, Members: []string{"b", "d"}},
+		{Label: nil, Members: []string{"f"}},
+	}, clusters)
+
+	// Clustering is deterministic.
+	assert.Equal(t, clusters, search.ClusterDocuments(docs, 2))
+
+	// There cannot be more clusters than clusterable documents.
+	clusters = search.ClusterDocuments(docs[:2], 5)
+	assert.Equal(t, []search.Cluster{{Label: nil, Members: []string{"a", "b"}}}, clusters)
+
+	assert.Equal(t, []search.Cluster{}, search.ClusterDocuments(nil, 5))
+}
@@ -0,0 +1,114 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// ErrFilterDataType is returned when a filter does not match the data type of its property.
+var ErrFilterDataType = errors.BaseWrap(ErrInvalidArgument, "filter does not match property data type")
+
+// CheckFilterProperties returns ErrUnknownProperty if a clause of JSON filters uses a property
+// which is neither in the registry nor in names, and ErrFilterDataType if a clause cannot match
+// claims of its property (e.g., an amount filter on a property with string claims) or
+// if an amount filter uses a different unit than the property expects.
+//
+// Errors include (under "suggestions" details) up to MaxPropertySuggestions properties with
+// similar names (or IDs, for unknown properties) which the filter could use instead.
+// When names are empty, properties are not checked to be known. Filters which are not
+// valid and properties not in the registry are not checked for data types.
+func CheckFilterProperties(filtersJSON string, registry document.PropertyRegistry, names PropertyNames) errors.E {
+	if filtersJSON == "" {
+		return nil
+	}
+	if len(names) > 0 {
+		errE := checkFilterProps(filtersJSON, registry, names)
+		if errE != nil {
+			return errE
+		}
+	}
+	if len(registry) == 0 {
+		return nil
+	}
+	var f filters
+	if x.UnmarshalWithoutUnknownFields([]byte(filtersJSON), &f) != nil || f.Valid() != nil {
+		return nil
+	}
+	return f.checkDataTypes(registry, names)
+}
+
+func checkFilterClaimType(
+	registry document.PropertyRegistry, names PropertyNames, filter string, prop identifier.Identifier, claimType string,
+) errors.E {
+	dataType, ok := registry.Lookup(prop)
+	if !ok || dataType.Allows(claimType) {
+		return nil
+	}
+	allowed := []string{}
+	for t := range dataType.ClaimTypes {
+		allowed = append(allowed, fmt.Sprintf(`"%s"`, t))
+	}
+	slices.Sort(allowed)
+	errE := errors.Errorf(`%w: %s filter on property with %s claim type`, ErrFilterDataType, filter, strings.Join(allowed, " or "))
+	errors.Details(errE)["prop"] = prop.String()
+	if suggestions := names.suggestFor(prop, acceptClaimType(registry, claimType)); len(suggestions) > 0 {
+		errors.Details(errE)["suggestions"] = suggestions
+	}
+	return errE
+}
+
+func (f filters) checkDataTypes(registry document.PropertyRegistry, names PropertyNames) errors.E {
+	for _, c := range f.And {
+		errE := c.checkDataTypes(registry, names)
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, c := range f.Or {
+		errE := c.checkDataTypes(registry, names)
+		if errE != nil {
+			return errE
+		}
+	}
+	if f.Not != nil {
+		return f.Not.checkDataTypes(registry, names)
+	}
+	switch {
+	case f.Rel != nil:
+		return checkFilterClaimType(registry, names, "relation", f.Rel.Prop, "relation")
+	case f.Amount != nil:
+		errE := checkFilterClaimType(registry, names, "amount", f.Amount.Prop, "amount")
+		if errE != nil {
+			return errE
+		}
+		dataType, ok := registry.Lookup(f.Amount.Prop)
+		// Valid filters always have the unit set.
+		if ok && dataType.Unit != nil && *dataType.Unit != *f.Amount.Unit {
+			errE := errors.Errorf(
+				`%w: amount filter with unit "%s" on property with unit "%s"`, ErrFilterDataType, f.Amount.Unit.String(), dataType.Unit.String(),
+			)
+			errors.Details(errE)["prop"] = f.Amount.Prop.String()
+			accept := acceptClaimType(registry, "amount")
+			suggestions := names.suggestFor(f.Amount.Prop, func(id identifier.Identifier) bool {
+				d, _ := registry.Lookup(id)
+				return accept(id) && d.Unit != nil && *d.Unit == *f.Amount.Unit
+			})
+			if len(suggestions) > 0 {
+				errors.Details(errE)["suggestions"] = suggestions
+			}
+			return errE
+		}
+	case f.Time != nil:
+		return checkFilterClaimType(registry, names, "time", f.Time.Prop, "time")
+	case f.Str != nil:
+		return checkFilterClaimType(registry, names, "string", f.Str.Prop, "string")
+	}
+	return nil
+}
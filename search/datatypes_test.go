@@ -0,0 +1,66 @@
+package search_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestCheckFilterProperties(t *testing.T) {
+	t.Parallel()
+
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+	mediaType := document.GetCorePropertyID("MEDIA_TYPE")
+	duration := document.GetCorePropertyID("DURATION")
+	unit := document.AmountUnitSecond
+	registry[duration] = document.PropertyDataType{
+		ClaimTypes: map[string]bool{"amount": true},
+		Unit:       &unit,
+		Minimum:    nil,
+		Maximum:    nil,
+	}
+
+	tests := []struct {
+		filters string
+		err     string
+	}{
+		{``, ""},
+		{`{"str": {"prop": "` + mediaType.String() + `", "str": "image/png"}}`, ""},
+		{`{"amount": {"prop": "` + duration.String() + `", "unit": "s", "gte": 1}}`, ""},
+		{
+			`{"and": [{"amount": {"prop": "` + mediaType.String() + `", "unit": "1", "gte": 1}}]}`,
+			`filter does not match property data type: amount filter on property with "string" claim type`,
+		},
+		{
+			`{"not": {"rel": {"prop": "` + duration.String() + `", "none": true}}}`,
+			`filter does not match property data type: relation filter on property with "amount" claim type`,
+		},
+		{
+			`{"amount": {"prop": "` + duration.String() + `", "unit": "m", "gte": 1}}`,
+			`filter does not match property data type: amount filter with unit "m" on property with unit "s"`,
+		},
+		// Properties not in the registry are not checked.
+		{`{"time": {"prop": "` + identifier.New().String() + `", "none": true}}`, ""},
+		// Invalid filters are not checked.
+		{`{"amount": {"prop": "` + mediaType.String() + `"}}`, ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("case=%d", i), func(t *testing.T) {
+			t.Parallel()
+
+			errE := search.CheckFilterProperties(tt.filters, registry, nil)
+			if tt.err == "" {
+				assert.NoError(t, errE, "% -+#.1v", errE)
+			} else {
+				assert.EqualError(t, errE, tt.err)
+				assert.ErrorIs(t, errE, search.ErrInvalidArgument)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package search
+
+import (
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/identifier"
+)
+
+const (
+	dedupInnerHitName = "dedup"
+	dedupField        = "claims.id.value"
+	// Maximum number of identifier claim values per document considered for deduplication.
+	maxDedupValues = 10
+)
+
+// DedupResult is a search result with IDs of alternate documents which share an identifier
+// claim value with the primary document.
+type DedupResult struct {
+	ID         string   `json:"id"`
+	Alternates []string `json:"alternates,omitempty"`
+}
+
+// DedupQuery wraps the query so that values of identifier claims with the given property
+// are returned with each hit. The query matches and scores the same documents as the
+// wrapped query.
+func DedupQuery(query elastic.Query, prop identifier.Identifier) elastic.Query { //nolint:ireturn
+	return elastic.NewBoolQuery().Must(query).Should(
+		elastic.NewNestedQuery(
+			"claims.id",
+			elastic.NewTermQuery("claims.id.prop.id", prop),
+		).ScoreMode("none").InnerHit(
+			elastic.NewInnerHit().Name(dedupInnerHitName).FetchSource(false).DocvalueFields(dedupField).Size(maxDedupValues),
+		),
+	)
+}
+
+// Dedup collapses hits of a query constructed using DedupQuery which share an identifier
+// claim value. The first (highest ranked) hit in a group is the primary result and the
+// rest are listed as its alternates. Order of primary results is preserved.
+func Dedup(hits []*elastic.SearchHit) []DedupResult {
+	results := []DedupResult{}
+	// Maps identifier claim values to the index of the primary result.
+	primaries := map[string]int{}
+
+	for _, hit := range hits {
+		values := dedupValues(hit)
+
+		primary := -1
+		for _, value := range values {
+			if i, ok := primaries[value]; ok {
+				primary = i
+				break
+			}
+		}
+
+		if primary == -1 {
+			primary = len(results)
+			results = append(results, DedupResult{ID: hit.Id, Alternates: nil})
+		} else {
+			results[primary].Alternates = append(results[primary].Alternates, hit.Id)
+		}
+
+		// Values of alternates are also mapped so that documents sharing
+		// a value only with an alternate are collapsed as well.
+		for _, value := range values {
+			if _, ok := primaries[value]; !ok {
+				primaries[value] = primary
+			}
+		}
+	}
+
+	return results
+}
+
+func dedupValues(hit *elastic.SearchHit) []string {
+	innerHits, ok := hit.InnerHits[dedupInnerHitName]
+	if !ok || innerHits.Hits == nil {
+		return nil
+	}
+	values := []string{}
+	for _, innerHit := range innerHits.Hits.Hits {
+		v, ok := innerHit.Fields.Strings(dedupField)
+		if ok {
+			values = append(values, v...)
+		}
+	}
+	return values
+}
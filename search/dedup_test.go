@@ -0,0 +1,32 @@
+package search_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestDedup(t *testing.T) {
+	t.Parallel()
+
+	var hits []*elastic.SearchHit
+	err := json.Unmarshal([]byte(`[
+		{"_id": "a", "inner_hits": {"dedup": {"hits": {"hits": [{"fields": {"claims.id.value": ["q1"]}}]}}}},
+		{"_id": "b", "inner_hits": {"dedup": {"hits": {"hits": []}}}},
+		{"_id": "c", "inner_hits": {"dedup": {"hits": {"hits": [{"fields": {"claims.id.value": ["q2"]}}, {"fields": {"claims.id.value": ["q1"]}}]}}}},
+		{"_id": "d", "inner_hits": {"dedup": {"hits": {"hits": [{"fields": {"claims.id.value": ["q2"]}}]}}}},
+		{"_id": "e"}
+	]`), &hits)
+	require.NoError(t, err)
+
+	assert.Equal(t, []search.DedupResult{
+		{ID: "a", Alternates: []string{"c", "d"}},
+		{ID: "b", Alternates: nil},
+		{ID: "e", Alternates: nil},
+	}, search.Dedup(hits))
+}
@@ -0,0 +1,282 @@
+package search
+
+import (
+	"context"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// ErrUnknownProperty is returned when a structured query or filters use a property which is not known.
+var ErrUnknownProperty = errors.BaseWrap(ErrInvalidArgument, "unknown property")
+
+// TextNode matches the search query against claims of documents.
+//
+// The search query uses the same syntax as the "q" parameter of search, but it has to be well-formed.
+// Operator is the default operator between terms, "and" (the default) or "or".
+type TextNode struct {
+	Query    string `json:"query"`
+	Operator string `json:"operator,omitempty"`
+}
+
+func (n TextNode) Valid() errors.E {
+	if n.Query == "" {
+		return errors.New("query has to be set")
+	}
+	switch n.Operator {
+	case "", "and", "or":
+	default:
+		errE := errors.New("invalid operator")
+		errors.Details(errE)["operator"] = n.Operator
+		return errE
+	}
+	_, warnings := ParseQuery(n.Query)
+	if len(warnings) > 0 {
+		errE := errors.New("malformed query")
+		errors.Details(errE)["warnings"] = warnings
+		return errE
+	}
+	return nil
+}
+
+// QueryNode is a node of a structured search query, for programmatic clients which need
+// precise control over the search instead of combining a search query with filters.
+//
+// Exactly one clause has to be set: a boolean node (And, Or, Not), a text node, or
+// a property predicate in the same format as clauses of filters.
+type QueryNode struct {
+	And      []QueryNode     `json:"and,omitempty"`
+	Or       []QueryNode     `json:"or,omitempty"`
+	Not      *QueryNode      `json:"not,omitempty"`
+	Text     *TextNode       `json:"text,omitempty"`
+	Rel      *relFilter      `json:"rel,omitempty"`
+	Amount   *amountFilter   `json:"amount,omitempty"`
+	Time     *timeFilter     `json:"time,omitempty"`
+	Str      *stringFilter   `json:"str,omitempty"`
+	Index    *indexFilter    `json:"index,omitempty"`
+	Size     *sizeFilter     `json:"size,omitempty"`
+	Children *childrenFilter `json:"children,omitempty"`
+}
+
+// ParseQueryNode parses JSON with a structured search query and validates it.
+func ParseQueryNode(data []byte) (*QueryNode, errors.E) {
+	var n QueryNode
+	errE := x.UnmarshalWithoutUnknownFields(data, &n)
+	if errE != nil {
+		return nil, errors.WrapWith(errE, ErrInvalidArgument)
+	}
+	errE = n.Valid()
+	if errE != nil {
+		return nil, errors.WrapWith(errE, ErrInvalidArgument)
+	}
+	return &n, nil
+}
+
+// predicate returns the property predicate of the node as filters,
+// or nil if the node is not a property predicate.
+func (n QueryNode) predicate() *filters {
+	f := filters{ //nolint:exhaustruct
+		Rel:      n.Rel,
+		Amount:   n.Amount,
+		Time:     n.Time,
+		Str:      n.Str,
+		Index:    n.Index,
+		Size:     n.Size,
+		Children: n.Children,
+	}
+	if f.Rel == nil && f.Amount == nil && f.Time == nil && f.Str == nil && f.Index == nil && f.Size == nil && f.Children == nil {
+		return nil
+	}
+	return &f
+}
+
+func (n QueryNode) Valid() errors.E {
+	nonEmpty := 0
+	if len(n.And) > 0 {
+		nonEmpty++
+		for _, c := range n.And {
+			errE := c.Valid()
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+	if len(n.Or) > 0 {
+		nonEmpty++
+		for _, c := range n.Or {
+			errE := c.Valid()
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+	if n.Not != nil {
+		nonEmpty++
+		errE := n.Not.Valid()
+		if errE != nil {
+			return errE
+		}
+	}
+	if n.Text != nil {
+		nonEmpty++
+		errE := n.Text.Valid()
+		if errE != nil {
+			return errE
+		}
+	}
+	if p := n.predicate(); p != nil {
+		if nonEmpty > 0 {
+			return errors.New("only one clause can be set")
+		}
+		// Filters validate that only one predicate is set.
+		return p.Valid()
+	}
+	if nonEmpty > 1 {
+		return errors.New("only one clause can be set")
+	} else if nonEmpty == 0 {
+		return errors.New("no clause is set")
+	}
+	return nil
+}
+
+// count returns the number of nodes in the query.
+func (n QueryNode) count() int {
+	c := 1
+	for _, a := range n.And {
+		c += a.count()
+	}
+	for _, o := range n.Or {
+		c += o.count()
+	}
+	if n.Not != nil {
+		c += n.Not.count()
+	}
+	return c
+}
+
+// Check returns ErrLimitExceeded if the query has more nodes than filters are allowed to
+// have clauses, ErrUnknownProperty if a property predicate uses a property which is neither in
+// the registry nor in names, and ErrFilterDataType if a property predicate cannot match claims of its
+// property. Errors include suggestions of similar properties (see CheckFilterProperties).
+// When the registry is empty, properties are not checked. The query should be valid.
+func (n QueryNode) Check(registry document.PropertyRegistry, names PropertyNames, limits Limits) errors.E {
+	errE := CheckLimit("query", n.count(), limits.Filters())
+	if errE != nil {
+		return errE
+	}
+	if len(registry) == 0 {
+		return nil
+	}
+	return n.checkProperties(registry, names)
+}
+
+func (n QueryNode) checkProperties(registry document.PropertyRegistry, names PropertyNames) errors.E {
+	for _, c := range n.And {
+		errE := c.checkProperties(registry, names)
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, c := range n.Or {
+		errE := c.checkProperties(registry, names)
+		if errE != nil {
+			return errE
+		}
+	}
+	if n.Not != nil {
+		return n.Not.checkProperties(registry, names)
+	}
+	p := n.predicate()
+	if p == nil {
+		return nil
+	}
+	var prop *identifier.Identifier
+	switch {
+	case p.Rel != nil:
+		prop = &p.Rel.Prop
+	case p.Amount != nil:
+		prop = &p.Amount.Prop
+	case p.Time != nil:
+		prop = &p.Time.Prop
+	case p.Str != nil:
+		prop = &p.Str.Prop
+	}
+	if prop != nil {
+		if !knownProperty(registry, names, *prop) {
+			return unknownPropertyError(names, prop.String())
+		}
+	}
+	return p.checkDataTypes(registry, names)
+}
+
+// CheckRestricted returns ErrRestricted if a property predicate of the query uses a property
+// whose claims cannot be accessed in the context (see WithRestricted).
+func (n QueryNode) CheckRestricted(ctx context.Context) errors.E {
+	for _, c := range n.And {
+		errE := c.CheckRestricted(ctx)
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, c := range n.Or {
+		errE := c.CheckRestricted(ctx)
+		if errE != nil {
+			return errE
+		}
+	}
+	if n.Not != nil {
+		return n.Not.CheckRestricted(ctx)
+	}
+	if p := n.predicate(); p != nil {
+		return p.checkRestricted(ctx)
+	}
+	return nil
+}
+
+// ToQuery returns the ElasticSearch query for the structured query. Matches of text nodes
+// in claims of properties with a weight have their score multiplied by the weight and text
+// nodes are matched against claims of name properties together (see State.WeightedQuery).
+// If asOf is set, only claims and documents valid at that time are considered.
+func (n QueryNode) ToQuery(asOf *document.Timestamp, weights FieldWeights, names NameProperties) elastic.Query { //nolint:ireturn
+	query := n.toQuery(asOf, weights, names)
+	if asOf != nil {
+		return elastic.NewBoolQuery().Must(query).Filter(documentValidAtQuery(*asOf))
+	}
+	return query
+}
+
+func (n QueryNode) toQuery(asOf *document.Timestamp, weights FieldWeights, names NameProperties) elastic.Query { //nolint:ireturn
+	if len(n.And) > 0 {
+		boolQuery := elastic.NewBoolQuery()
+		for _, c := range n.And {
+			boolQuery.Must(c.toQuery(asOf, weights, names))
+		}
+		return boolQuery
+	}
+	if len(n.Or) > 0 {
+		boolQuery := elastic.NewBoolQuery()
+		for _, c := range n.Or {
+			boolQuery.Should(c.toQuery(asOf, weights, names))
+		}
+		return boolQuery
+	}
+	if n.Not != nil {
+		return elastic.NewBoolQuery().MustNot(n.Not.toQuery(asOf, weights, names))
+	}
+	if n.Text != nil {
+		operator := "AND"
+		if n.Text.Operator != "" {
+			operator = strings.ToUpper(n.Text.Operator)
+		}
+		return documentTextSearchQuery(n.Text.Query, operator, asOf, weights, names)
+	}
+	if p := n.predicate(); p != nil {
+		return p.ToQuery(asOf)
+	}
+	panic(errors.New("invalid query"))
+}
@@ -0,0 +1,132 @@
+package search_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestParseQueryNode(t *testing.T) {
+	t.Parallel()
+
+	mediaType := document.GetCorePropertyID("MEDIA_TYPE")
+
+	for i, data := range []string{
+		`{"text": {"query": "foo bar"}}`,
+		`{"and": [{"text": {"query": "foo", "operator": "or"}}, {"not": {"str": {"prop": "` + mediaType.String() + `", "str": "image/png"}}}]}`,
+		`{"or": [{"index": {"str": "foo"}}, {"size": {"gte": 10}}]}`,
+	} {
+		t.Run(fmt.Sprintf("valid=%d", i), func(t *testing.T) {
+			t.Parallel()
+
+			_, errE := search.ParseQueryNode([]byte(data))
+			assert.NoError(t, errE, "% -+#.1v", errE)
+		})
+	}
+
+	for i, data := range []string{
+		`{}`,
+		`{"foo": 1}`,
+		`{"text": {"query": ""}}`,
+		`{"text": {"query": "foo", "operator": "xor"}}`,
+		`{"text": {"query": "(foo"}}`,
+		`{"text": {"query": "foo"}, "not": {"text": {"query": "bar"}}}`,
+		`{"text": {"query": "foo"}, "index": {"str": "foo"}}`,
+		`{"and": [{"amount": {"prop": "` + mediaType.String() + `"}}]}`,
+	} {
+		t.Run(fmt.Sprintf("invalid=%d", i), func(t *testing.T) {
+			t.Parallel()
+
+			_, errE := search.ParseQueryNode([]byte(data))
+			assert.ErrorIs(t, errE, search.ErrInvalidArgument)
+		})
+	}
+}
+
+func TestQueryNodeCheck(t *testing.T) {
+	t.Parallel()
+
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+	mediaType := document.GetCorePropertyID("MEDIA_TYPE")
+
+	node, errE := search.ParseQueryNode([]byte(`{"str": {"prop": "` + mediaType.String() + `", "str": "image/png"}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = node.Check(registry, nil, search.Limits{}) //nolint:exhaustruct
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	node, errE = search.ParseQueryNode([]byte(`{"not": {"time": {"prop": "` + mediaType.String() + `", "none": true}}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = node.Check(registry, nil, search.Limits{}) //nolint:exhaustruct
+	assert.ErrorIs(t, errE, search.ErrFilterDataType)
+
+	unknown := identifier.New()
+	node, errE = search.ParseQueryNode([]byte(`{"or": [{"text": {"query": "foo"}}, {"rel": {"prop": "` + unknown.String() + `", "none": true}}]}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = node.Check(registry, nil, search.Limits{}) //nolint:exhaustruct
+	assert.ErrorIs(t, errE, search.ErrUnknownProperty)
+	// Without a registry properties are not checked.
+	errE = node.Check(nil, nil, search.Limits{}) //nolint:exhaustruct
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	node, errE = search.ParseQueryNode([]byte(`{"and": [` + strings.Repeat(`{"text": {"query": "foo"}},`, 10) + `{"text": {"query": "foo"}}]}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = node.Check(registry, nil, search.Limits{MaxFilters: 10}) //nolint:exhaustruct
+	assert.ErrorIs(t, errE, search.ErrLimitExceeded)
+}
+
+func TestQueryNodeToQuery(t *testing.T) {
+	t.Parallel()
+
+	mediaType := document.GetCorePropertyID("MEDIA_TYPE")
+
+	node, errE := search.ParseQueryNode([]byte(`{"and": [{"index": {"str": "foo"}}, {"not": {"str": {"prop": "` + mediaType.String() + `", "none": true}}}]}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	source, err := node.ToQuery(nil, nil, nil).Source()
+	require.NoError(t, err)
+	data, err := json.Marshal(source)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"bool": {
+			"must": [
+				{"term": {"_index": "foo"}},
+				{"bool": {"must_not": {"bool": {"must_not": {
+					"nested": {
+						"path": "claims.string",
+						"query": {"term": {"claims.string.prop.id": "`+mediaType.String()+`"}}
+					}
+				}}}}}
+			]
+		}
+	}`, string(data))
+}
+
+func TestQueryNodeCheckRestricted(t *testing.T) {
+	t.Parallel()
+
+	public := identifier.New()
+	restricted := identifier.New()
+
+	ctx := search.WithRestricted(context.Background(), []identifier.Identifier{restricted})
+
+	node, errE := search.ParseQueryNode([]byte(`{"and": [{"text": {"query": "foo"}}, {"str": {"prop": "` + public.String() + `", "str": "bar"}}]}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = node.CheckRestricted(ctx)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	node, errE = search.ParseQueryNode([]byte(`{"or": [{"text": {"query": "foo"}}, {"not": {"str": {"prop": "` + restricted.String() + `", "str": "bar"}}}]}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = node.CheckRestricted(ctx)
+	assert.ErrorIs(t, errE, search.ErrRestricted)
+	errE = node.CheckRestricted(context.Background())
+	assert.NoError(t, errE, "% -+#.1v", errE)
+}
@@ -8,4 +8,5 @@ var (
 	ErrNotFound        = errors.Base("not found")
 	ErrInvalidArgument = errors.Base("invalid argument")
 	ErrNotReady        = errors.Base("not ready")
+	ErrRestricted      = errors.Base("restricted property")
 )
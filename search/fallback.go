@@ -0,0 +1,154 @@
+package search
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// Zero-result fallback strategies.
+const (
+	// FallbackFilters progressively drops filters, the last "and" clause first.
+	FallbackFilters = "filters"
+	// FallbackFuzzy matches any term of the search query, with fuzziness.
+	FallbackFuzzy = "fuzzy"
+)
+
+// MaxFallbacks is the maximum number of fallback searches done when a search yields no results.
+const MaxFallbacks = 5
+
+// Fallbacks are strategies applied in order to relax the search state when a search
+// yields no results, until a relaxed search yields some. Strategies are cumulative:
+// every relaxation keeps relaxations done by previous strategies.
+//
+// Semantic search is not available as a strategy because search does not support embeddings.
+type Fallbacks []string
+
+// Validate validates fallbacks.
+func (f Fallbacks) Validate() errors.E {
+	seen := []string{}
+	for _, strategy := range f {
+		switch strategy {
+		case FallbackFilters, FallbackFuzzy:
+		default:
+			errE := errors.New("unknown fallback strategy")
+			errors.Details(errE)["strategy"] = strategy
+			return errE
+		}
+		if slices.Contains(seen, strategy) {
+			errE := errors.New("duplicate fallback strategy")
+			errors.Details(errE)["strategy"] = strategy
+			return errE
+		}
+		seen = append(seen, strategy)
+	}
+	return nil
+}
+
+// FallbackStep describes one relaxation of the search state.
+type FallbackStep struct {
+	Strategy string
+	// Dropped is the filter dropped by the step (for "filters" strategy), in the same
+	// format as filters are provided.
+	Dropped []byte
+}
+
+// Fallback is the search state relaxed by fallback steps.
+type Fallback struct {
+	State *State
+	Fuzzy bool
+	Steps []FallbackStep
+}
+
+// NamedQuery is like State.NamedQuery, but for the relaxed search state.
+func (f *Fallback) NamedQuery(weights FieldWeights, names NameProperties) elastic.Query { //nolint:ireturn
+	return f.State.query(weights, names, f.State.Prompt != "", f.Fuzzy)
+}
+
+// Metadata adds applied fallback steps to metadata of the response: "fallback" is
+// the list of strategies of steps and "fallback-dropped" the list of dropped filters
+// (as JSON byte sequences).
+func (f *Fallback) Metadata(metadata map[string]interface{}) {
+	strategies := make([]string, len(f.Steps))
+	dropped := [][]byte{}
+	for i, step := range f.Steps {
+		strategies[i] = step.Strategy
+		if step.Dropped != nil {
+			dropped = append(dropped, step.Dropped)
+		}
+	}
+	metadata["fallback"] = strategies
+	if len(dropped) > 0 {
+		metadata["fallback-dropped"] = dropped
+	}
+}
+
+// Relax returns progressively more relaxed search states using the strategies,
+// to be searched in order until one yields results. At most MaxFallbacks are returned.
+func (f Fallbacks) Relax(s *State) []Fallback {
+	fallbacks := []Fallback{}
+	current := Fallback{State: s, Fuzzy: false, Steps: nil}
+
+	add := func(state *State, fuzzy bool, step FallbackStep) {
+		current = Fallback{
+			State: state,
+			Fuzzy: fuzzy,
+			Steps: append(slices.Clone(current.Steps), step),
+		}
+		fallbacks = append(fallbacks, current)
+	}
+
+	for _, strategy := range f {
+		switch strategy {
+		case FallbackFilters:
+			for current.State.Filters != nil {
+				relaxed := *current.State
+				var dropped filters
+				if len(relaxed.Filters.And) > 1 {
+					and := relaxed.Filters.And
+					dropped = and[len(and)-1]
+					relaxed.Filters = &filters{And: and[:len(and)-1]} //nolint:exhaustruct
+				} else {
+					dropped = *relaxed.Filters
+					if len(dropped.And) == 1 {
+						dropped = dropped.And[0]
+					}
+					relaxed.Filters = nil
+				}
+				data, errE := x.MarshalWithoutEscapeHTML(dropped)
+				if errE != nil {
+					// This should not happen.
+					panic(errE)
+				}
+				add(&relaxed, current.Fuzzy, FallbackStep{Strategy: FallbackFilters, Dropped: data})
+			}
+		case FallbackFuzzy:
+			if current.State.SearchQuery != "" && !current.Fuzzy {
+				add(current.State, true, FallbackStep{Strategy: FallbackFuzzy, Dropped: nil})
+			}
+		}
+	}
+
+	if len(fallbacks) > MaxFallbacks {
+		fallbacks = fallbacks[:MaxFallbacks]
+	}
+	return fallbacks
+}
+
+// fuzzyQuery returns the search query in simple query string syntax with fuzziness
+// added to all terms which do not already have fuzziness or are not prefixes.
+// The query should be well-formed. See ParseQuery.
+func fuzzyQuery(query string) string {
+	tokens, _ := tokenizeQuery([]rune(query))
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.Text)
+		if t.Kind == queryTokenTerm && !strings.Contains(t.Text, "~") && !strings.HasSuffix(t.Text, "*") {
+			b.WriteString("~")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package search //nolint:testpackage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+)
+
+func TestFallbacksValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, Fallbacks{FallbackFilters, FallbackFuzzy}.Validate())
+	assert.NoError(t, Fallbacks{}.Validate())
+
+	errE := Fallbacks{"semantic"}.Validate()
+	assert.EqualError(t, errE, "unknown fallback strategy")
+	errE = Fallbacks{FallbackFuzzy, FallbackFuzzy}.Validate()
+	assert.EqualError(t, errE, "duplicate fallback strategy")
+}
+
+func TestFallbacksRelax(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	value := identifier.New()
+	var fs filters
+	errE := json.Unmarshal([]byte(`{"and":[`+
+		`{"rel":{"prop":"`+prop.String()+`","value":"`+value.String()+`"}},`+
+		`{"str":{"prop":"`+prop.String()+`","str":"foo"}}`+
+		`]}`), &fs)
+	require.NoError(t, errE)
+
+	sh := &State{ //nolint:exhaustruct
+		ID:          identifier.New(),
+		SearchQuery: "foo bar",
+		Filters:     &fs,
+	}
+
+	fallbacks := Fallbacks{FallbackFilters, FallbackFuzzy}.Relax(sh)
+	require.Len(t, fallbacks, 3)
+
+	// The last "and" clause is dropped first.
+	require.NotNil(t, fallbacks[0].State.Filters)
+	assert.Len(t, fallbacks[0].State.Filters.And, 1)
+	assert.False(t, fallbacks[0].Fuzzy)
+	require.Len(t, fallbacks[0].Steps, 1)
+	assert.Equal(t, FallbackFilters, fallbacks[0].Steps[0].Strategy)
+	assert.JSONEq(t, `{"str":{"prop":"`+prop.String()+`","str":"foo"}}`, string(fallbacks[0].Steps[0].Dropped))
+
+	assert.Nil(t, fallbacks[1].State.Filters)
+	require.Len(t, fallbacks[1].Steps, 2)
+	assert.JSONEq(t, `{"rel":{"prop":"`+prop.String()+`","value":"`+value.String()+`"}}`, string(fallbacks[1].Steps[1].Dropped))
+
+	assert.Nil(t, fallbacks[2].State.Filters)
+	assert.True(t, fallbacks[2].Fuzzy)
+	require.Len(t, fallbacks[2].Steps, 3)
+	assert.Equal(t, FallbackFuzzy, fallbacks[2].Steps[2].Strategy)
+	assert.Nil(t, fallbacks[2].Steps[2].Dropped)
+
+	// The original search state is not changed.
+	assert.Len(t, sh.Filters.And, 2)
+
+	metadata := map[string]interface{}{}
+	fallbacks[2].Metadata(metadata)
+	assert.Equal(t, []string{FallbackFilters, FallbackFilters, FallbackFuzzy}, metadata["fallback"])
+	assert.Len(t, metadata["fallback-dropped"], 2)
+
+	// Without the search query there is nothing to match with fuzziness.
+	assert.Empty(t, Fallbacks{FallbackFuzzy}.Relax(&State{ID: identifier.New()})) //nolint:exhaustruct
+}
+
+func TestFuzzyQuery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{`foo bar`, `foo~ bar~`},
+		{`"foo bar" baz`, `"foo bar" baz~`},
+		{`foo~2 bar* -baz`, `foo~2 bar* -baz~`},
+		{`(foo | bar) +baz`, `(foo~ | bar~) +baz~`},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.expected, fuzzyQuery(test.query))
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package search
+
+import (
+	"context"
+	"slices"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// FederatedIndex describes one index to search in a federated search.
+type FederatedIndex struct {
+	// Site is used to label results from this index.
+	Site string
+
+	Index string
+
+	// Filters in JSON, applied only to this index. Can be empty.
+	Filters string
+}
+
+// FederatedResult is a search result of a federated search.
+type FederatedResult struct {
+	ID   string `json:"id"`
+	Site string `json:"site"`
+
+	// Score is normalized to [0, 1] range per index, so that
+	// scores from different indices are comparable.
+	Score float64 `json:"score"`
+}
+
+func parseFilters(filtersJSON string) (*filters, errors.E) {
+	if filtersJSON == "" {
+		return nil, nil //nolint:nilnil
+	}
+	var f filters
+	errE := x.UnmarshalWithoutUnknownFields([]byte(filtersJSON), &f)
+	if errE != nil {
+		return nil, errors.WrapWith(errE, ErrInvalidArgument)
+	}
+	errE = f.Valid()
+	if errE != nil {
+		return nil, errors.WrapWith(errE, ErrInvalidArgument)
+	}
+	return &f, nil
+}
+
+// Federated searches multiple indices in one request using the search query and
+// filters (both can be empty). Filters are applied to all indices, in addition to
+// per-index filters. Results are merged by their normalized scores.
+//
+// It returns up to MaxResultsCount results together with the total number of
+// matching documents across all indices.
+func Federated(
+	ctx context.Context, getMultiSearchService func() *elastic.MultiSearchService,
+	indices []FederatedIndex, searchQuery, filtersJSON string,
+) ([]FederatedResult, int64, errors.E) {
+	if len(indices) == 0 {
+		return nil, 0, errors.WithMessage(ErrInvalidArgument, "no indices")
+	}
+
+	fs, errE := parseFilters(filtersJSON)
+	if errE != nil {
+		errors.Details(errE)["filters"] = filtersJSON
+		return nil, 0, errE
+	}
+	if fs != nil {
+		errE = fs.checkRestricted(ctx)
+		if errE != nil {
+			return nil, 0, errE
+		}
+	}
+
+	multiSearchService := getMultiSearchService()
+	for _, index := range indices {
+		indexFilters, errE := parseFilters(index.Filters)
+		if errE != nil {
+			errors.Details(errE)["site"] = index.Site
+			errors.Details(errE)["filters"] = index.Filters
+			return nil, 0, errE
+		}
+
+		boolQuery := elastic.NewBoolQuery()
+		if searchQuery != "" {
+			boolQuery.Must(documentTextSearchQuery(searchQuery, "AND", nil, nil, nil))
+		}
+		if fs != nil {
+			boolQuery.Must(fs.ToQuery(nil))
+		}
+		if indexFilters != nil {
+			boolQuery.Must(indexFilters.ToQuery(nil))
+		}
+
+		multiSearchService.Add(
+			elastic.NewSearchRequest().Index(index.Index).Source(
+				elastic.NewSearchSource().FetchSource(false).TrackTotalHits(true).From(0).Size(MaxResultsCount).Query(boolQuery).
+					SortBy(Sorters(nil, nil, nil)...),
+			),
+		)
+	}
+
+	res, err := multiSearchService.Do(ctx)
+	if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	sites := make([]string, len(indices))
+	hits := make([][]*elastic.SearchHit, len(indices))
+	total := int64(0)
+	for i, response := range res.Responses {
+		if response.Error != nil {
+			errE := errors.New("search failed")
+			errors.Details(errE)["site"] = indices[i].Site
+			errors.Details(errE)["type"] = response.Error.Type
+			errors.Details(errE)["reason"] = response.Error.Reason
+			return nil, 0, errE
+		}
+		sites[i] = indices[i].Site
+		hits[i] = response.Hits.Hits
+		total += response.Hits.TotalHits.Value
+	}
+
+	results := MergeFederated(sites, hits)
+	if len(results) > MaxResultsCount {
+		results = results[:MaxResultsCount]
+	}
+
+	return results, total, nil
+}
+
+// MergeFederated merges hits from multiple indices, labeling them with corresponding sites.
+//
+// Scores are normalized per index by dividing them with the maximum score in that index.
+// Results are ordered by normalized scores, ties are broken by the order of indices
+// and then by the order of hits.
+func MergeFederated(sites []string, hits [][]*elastic.SearchHit) []FederatedResult {
+	results := []FederatedResult{}
+	for i, indexHits := range hits {
+		maxScore := 0.0
+		for _, hit := range indexHits {
+			if hit.Score != nil && *hit.Score > maxScore {
+				maxScore = *hit.Score
+			}
+		}
+		for _, hit := range indexHits {
+			// When there is no query (only filters), all documents have the same score.
+			score := 1.0
+			if hit.Score != nil && maxScore > 0 {
+				score = *hit.Score / maxScore
+			}
+			results = append(results, FederatedResult{
+				ID:    hit.Id,
+				Site:  sites[i],
+				Score: score,
+			})
+		}
+	}
+
+	slices.SortStableFunc(results, func(a, b FederatedResult) int {
+		if a.Score > b.Score {
+			return -1
+		} else if a.Score < b.Score {
+			return 1
+		}
+		return 0
+	})
+
+	return results
+}
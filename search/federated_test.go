@@ -0,0 +1,32 @@
+package search_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestMergeFederated(t *testing.T) {
+	t.Parallel()
+
+	var art, products, filtered []*elastic.SearchHit
+	err := json.Unmarshal([]byte(`[{"_id": "a", "_score": 10}, {"_id": "b", "_score": 5}]`), &art)
+	require.NoError(t, err)
+	err = json.Unmarshal([]byte(`[{"_id": "c", "_score": 2}, {"_id": "d", "_score": 0.5}]`), &products)
+	require.NoError(t, err)
+	err = json.Unmarshal([]byte(`[{"_id": "e", "_score": 0}]`), &filtered)
+	require.NoError(t, err)
+
+	assert.Equal(t, []search.FederatedResult{
+		{ID: "a", Site: "art", Score: 1},
+		{ID: "c", Site: "products", Score: 1},
+		{ID: "e", Site: "filtered", Score: 1},
+		{ID: "b", Site: "art", Score: 0.5},
+		{ID: "d", Site: "products", Score: 0.25},
+	}, search.MergeFederated([]string{"art", "products", "filtered"}, [][]*elastic.SearchHit{art, products, filtered}))
+}
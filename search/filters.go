@@ -48,6 +48,29 @@ type filteredMultiTermAggregations struct {
 	} `json:"filter"`
 }
 
+//nolint:tagliatelle
+type filteredTimeTermAggregations struct {
+	Filter struct {
+		Props struct {
+			Buckets []struct {
+				Key  string `json:"key"`
+				Docs struct {
+					Count int64 `json:"doc_count"`
+				} `json:"docs"`
+				Min struct {
+					Value float64 `json:"value"`
+				} `json:"min"`
+				Max struct {
+					Value float64 `json:"value"`
+				} `json:"max"`
+			} `json:"buckets"`
+		} `json:"props"`
+		Total struct {
+			Value int64 `json:"value"`
+		} `json:"total"`
+	} `json:"filter"`
+}
+
 type intValueAggregation struct {
 	Value int64 `json:"value"`
 }
@@ -57,54 +80,58 @@ type searchFiltersResult struct {
 	Count int64  `json:"count,omitempty"`
 	Type  string `json:"type,omitempty"`
 	Unit  string `json:"unit,omitempty"`
+
+	// Interval and Histogram are set for time filters, with the interval
+	// selected based on the range of data (see TimeFilterGet).
+	Interval  string                `json:"interval,omitempty"`
+	Histogram []histogramTimeResult `json:"histogram,omitempty"`
 }
 
 func FiltersGet(
-	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id identifier.Identifier,
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id identifier.Identifier, facetSize int,
 ) (interface{}, map[string]interface{}, errors.E) {
-	metrics := waf.MustGetMetrics(ctx)
-
-	m := metrics.Duration(internal.MetricSearchState).Start()
-	ss, ok := searches.Load(id)
-	m.Stop()
-	if !ok {
-		// Something was not OK, so we return not found.
-		return nil, nil, errors.WithStack(ErrNotFound)
+	sh, errE := loadState(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
 	}
-	sh := ss.(*State) //nolint:errcheck,forcetypeassert
 
 	if !sh.Ready() {
 		return nil, nil, errors.WithStack(ErrNotReady)
 	}
 
-	return filtersGet(ctx, getSearchService, sh.Query())
+	return filtersGet(ctx, getSearchService, sh.Query(), facetSize)
 }
 
 func filtersGet( //nolint:maintidx
-	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), query elastic.Query,
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), query elastic.Query, facetSize int,
 ) (interface{}, map[string]interface{}, errors.E) {
 	metrics := waf.MustGetMetrics(ctx)
 
 	searchService, propertiesTotal := getSearchService()
 	relAggregation := elastic.NewNestedAggregation().Path("claims.rel").SubAggregation(
-		"props",
-		elastic.NewTermsAggregation().Field("claims.rel.prop.id").Size(MaxResultsCount).OrderByAggregation("docs", false).SubAggregation(
-			"docs",
-			elastic.NewReverseNestedAggregation(),
+		"filter",
+		elastic.NewFilterAggregation().Filter(
+			restrictedFilter(ctx, "claims.rel"),
+		).SubAggregation(
+			"props",
+			elastic.NewTermsAggregation().Field("claims.rel.prop.id").Size(facetSize).OrderByAggregation("docs", false).SubAggregation(
+				"docs",
+				elastic.NewReverseNestedAggregation(),
+			),
+		).SubAggregation(
+			"total",
+			// Cardinality aggregation returns the count of all buckets. It can be at most propertiesTotal,
+			// so we set precision threshold to twice as much to try to always get precise counts.
+			elastic.NewCardinalityAggregation().Field("claims.rel.prop.id").PrecisionThreshold(2*propertiesTotal), //nolint:mnd
 		),
-	).SubAggregation(
-		"total",
-		// Cardinality aggregation returns the count of all buckets. It can be at most propertiesTotal,
-		// so we set precision threshold to twice as much to try to always get precise counts.
-		elastic.NewCardinalityAggregation().Field("claims.rel.prop.id").PrecisionThreshold(2*propertiesTotal), //nolint:mnd
 	)
 	amountAggregation := elastic.NewNestedAggregation().Path("claims.amount").SubAggregation(
 		"filter",
 		elastic.NewFilterAggregation().Filter(
-			elastic.NewBoolQuery().MustNot(elastic.NewTermQuery("claims.amount.unit", "@")),
+			restrictedFilter(ctx, "claims.amount").MustNot(elastic.NewTermQuery("claims.amount.unit", "@")),
 		).SubAggregation(
 			"props",
-			elastic.NewMultiTermsAggregation().Terms("claims.amount.prop.id", "claims.amount.unit").Size(MaxResultsCount).OrderByAggregation("docs", false).SubAggregation(
+			elastic.NewMultiTermsAggregation().Terms("claims.amount.prop.id", "claims.amount.unit").Size(facetSize).OrderByAggregation("docs", false).SubAggregation(
 				"docs",
 				elastic.NewReverseNestedAggregation(),
 			),
@@ -121,28 +148,45 @@ func filtersGet( //nolint:maintidx
 		),
 	)
 	timeAggregation := elastic.NewNestedAggregation().Path("claims.time").SubAggregation(
-		"props",
-		elastic.NewTermsAggregation().Field("claims.time.prop.id").Size(MaxResultsCount).OrderByAggregation("docs", false).SubAggregation(
-			"docs",
-			elastic.NewReverseNestedAggregation(),
+		"filter",
+		elastic.NewFilterAggregation().Filter(
+			restrictedFilter(ctx, "claims.time"),
+		).SubAggregation(
+			"props",
+			elastic.NewTermsAggregation().Field("claims.time.prop.id").Size(facetSize).OrderByAggregation("docs", false).SubAggregation(
+				"docs",
+				elastic.NewReverseNestedAggregation(),
+			).SubAggregation(
+				// Min and max are used to select the interval of histograms.
+				"min",
+				elastic.NewMinAggregation().Field("claims.time.timestampSeconds"),
+			).SubAggregation(
+				"max",
+				elastic.NewMaxAggregation().Field("claims.time.timestampSeconds"),
+			),
+		).SubAggregation(
+			"total",
+			// Cardinality aggregation returns the count of all buckets. It can be at most propertiesTotal,
+			// so we set precision threshold to twice as much to try to always get precise counts.
+			elastic.NewCardinalityAggregation().Field("claims.time.prop.id").PrecisionThreshold(2*propertiesTotal), //nolint:mnd
 		),
-	).SubAggregation(
-		"total",
-		// Cardinality aggregation returns the count of all buckets. It can be at most propertiesTotal,
-		// so we set precision threshold to twice as much to try to always get precise counts.
-		elastic.NewCardinalityAggregation().Field("claims.time.prop.id").PrecisionThreshold(2*propertiesTotal), //nolint:mnd
 	)
 	stringAggregation := elastic.NewNestedAggregation().Path("claims.string").SubAggregation(
-		"props",
-		elastic.NewTermsAggregation().Field("claims.string.prop.id").Size(MaxResultsCount).OrderByAggregation("docs", false).SubAggregation(
-			"docs",
-			elastic.NewReverseNestedAggregation(),
+		"filter",
+		elastic.NewFilterAggregation().Filter(
+			restrictedFilter(ctx, "claims.string"),
+		).SubAggregation(
+			"props",
+			elastic.NewTermsAggregation().Field("claims.string.prop.id").Size(facetSize).OrderByAggregation("docs", false).SubAggregation(
+				"docs",
+				elastic.NewReverseNestedAggregation(),
+			),
+		).SubAggregation(
+			"total",
+			// Cardinality aggregation returns the count of all buckets. It can be at most propertiesTotal,
+			// so we set precision threshold to twice as much to try to always get precise counts.
+			elastic.NewCardinalityAggregation().Field("claims.string.prop.id").PrecisionThreshold(2*propertiesTotal), //nolint:mnd
 		),
-	).SubAggregation(
-		"total",
-		// Cardinality aggregation returns the count of all buckets. It can be at most propertiesTotal,
-		// so we set precision threshold to twice as much to try to always get precise counts.
-		elastic.NewCardinalityAggregation().Field("claims.string.prop.id").PrecisionThreshold(2*propertiesTotal), //nolint:mnd
 	)
 	// Cardinality aggregation returns the count of all buckets. 40000 is the maximum precision threshold,
 	// so we use it to get the most accurate approximation.
@@ -165,8 +209,8 @@ func filtersGet( //nolint:maintidx
 	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
 
 	m = metrics.Duration(internal.MetricJSONUnmarshal).Start()
-	var rel termAggregations
-	errE := x.Unmarshal(res.Aggregations["rel"], &rel)
+	var relF filteredTermAggregations
+	errE := x.Unmarshal(res.Aggregations["rel"], &relF)
 	if errE != nil {
 		m.Stop()
 		return nil, nil, errE
@@ -177,14 +221,14 @@ func filtersGet( //nolint:maintidx
 		m.Stop()
 		return nil, nil, errE
 	}
-	var timeA termAggregations
-	errE = x.Unmarshal(res.Aggregations["time"], &timeA)
+	var timeF filteredTimeTermAggregations
+	errE = x.Unmarshal(res.Aggregations["time"], &timeF)
 	if errE != nil {
 		m.Stop()
 		return nil, nil, errE
 	}
-	var str termAggregations
-	errE = x.Unmarshal(res.Aggregations["string"], &str)
+	var strF filteredTermAggregations
+	errE = x.Unmarshal(res.Aggregations["string"], &strF)
 	if errE != nil {
 		m.Stop()
 		return nil, nil, errE
@@ -203,6 +247,8 @@ func filtersGet( //nolint:maintidx
 	}
 	m.Stop()
 
+	rel, timeA, str := relF.Filter, timeF.Filter, strF.Filter
+
 	indexFilter := 0
 	if index.Value > 1 {
 		indexFilter++
@@ -220,6 +266,9 @@ func filtersGet( //nolint:maintidx
 			Count: bucket.Docs.Count,
 			Type:  "rel",
 			Unit:  "",
+
+			Interval:  "",
+			Histogram: nil,
 		}
 	}
 	for i, bucket := range amount.Filter.Props.Buckets {
@@ -228,6 +277,9 @@ func filtersGet( //nolint:maintidx
 			Count: bucket.Docs.Count,
 			Type:  "amount",
 			Unit:  bucket.Key[1],
+
+			Interval:  "",
+			Histogram: nil,
 		}
 	}
 	for i, bucket := range timeA.Props.Buckets {
@@ -236,6 +288,9 @@ func filtersGet( //nolint:maintidx
 			Count: bucket.Docs.Count,
 			Type:  "time",
 			Unit:  "",
+
+			Interval:  "",
+			Histogram: nil,
 		}
 	}
 	for i, bucket := range str.Props.Buckets {
@@ -244,6 +299,9 @@ func filtersGet( //nolint:maintidx
 			Count: bucket.Docs.Count,
 			Type:  "string",
 			Unit:  "",
+
+			Interval:  "",
+			Histogram: nil,
 		}
 	}
 	if indexFilter != 0 {
@@ -253,6 +311,9 @@ func filtersGet( //nolint:maintidx
 			Count: res.Hits.TotalHits.Value,
 			Type:  "index",
 			Unit:  "",
+
+			Interval:  "",
+			Histogram: nil,
 		}
 	}
 	if sizeFilter != 0 {
@@ -261,10 +322,13 @@ func filtersGet( //nolint:maintidx
 			Count: size.Value,
 			Type:  "size",
 			Unit:  "",
+
+			Interval:  "",
+			Histogram: nil,
 		}
 	}
 
-	// Because we combine multiple aggregations of MaxResultsCount each, we have to
+	// Because we combine multiple aggregations of facetSize each, we have to
 	// re-sort results and limit them ourselves.
 	slices.SortStableFunc(results, func(a searchFiltersResult, b searchFiltersResult) int {
 		if a.Count > b.Count {
@@ -274,8 +338,21 @@ func filtersGet( //nolint:maintidx
 		}
 		return 0
 	})
-	if len(results) > MaxResultsCount {
-		results = results[:MaxResultsCount]
+	if len(results) > facetSize {
+		results = results[:facetSize]
+	}
+
+	timeHistograms := map[string]*timeHistogram{}
+	for _, bucket := range timeA.Props.Buckets {
+		h, errE := newTimeHistogram(secondsToTimestamp(bucket.Min.Value), secondsToTimestamp(bucket.Max.Value), TimeIntervalAuto)
+		if errE != nil {
+			return nil, nil, errE
+		}
+		timeHistograms[bucket.Key] = h
+	}
+	errE = timeFacetHistograms(ctx, getSearchService, query, results, timeHistograms)
+	if errE != nil {
+		return nil, nil, errE
 	}
 
 	// Cardinality count is approximate, so we make sure the total is sane.
@@ -298,3 +375,50 @@ func filtersGet( //nolint:maintidx
 		"total": total,
 	}, nil
 }
+
+// timeFacetHistograms sets histograms of time filters in results, all computed with one search.
+func timeFacetHistograms(
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), query elastic.Query,
+	results []searchFiltersResult, histograms map[string]*timeHistogram,
+) errors.E {
+	metrics := waf.MustGetMetrics(ctx)
+
+	searchService, _ := getSearchService()
+	searchService = searchService.Size(0).Query(query)
+	found := false
+	for _, result := range results {
+		if result.Type == "time" && histograms[result.ID] != nil {
+			searchService = searchService.Aggregation("time-"+result.ID, histograms[result.ID].aggregation(result.ID))
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	m := metrics.Duration(internal.MetricElasticSearch1).Start()
+	res, err := searchService.Do(ctx)
+	m.Stop()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal1).Duration = time.Duration(res.TookInMillis) * time.Millisecond
+
+	m = metrics.Duration(internal.MetricJSONUnmarshal1).Start()
+	defer m.Stop()
+	for i, result := range results {
+		h := histograms[result.ID]
+		if result.Type != "time" || h == nil {
+			continue
+		}
+		histogram, errE := h.results(res.Aggregations["time-"+result.ID])
+		if errE != nil {
+			errors.Details(errE)["prop"] = result.ID
+			return errE
+		}
+		results[i].Interval = h.intervalString()
+		results[i].Histogram = histogram
+	}
+
+	return nil
+}
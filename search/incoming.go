@@ -0,0 +1,71 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+type searchIncomingResult struct {
+	ID string `json:"id"`
+}
+
+// IncomingQuery returns a query matching all documents with a relation claim pointing to
+// any of the documents with the given IDs. Optionally, only relation claims with the given property are matched.
+func IncomingQuery(ids []identifier.Identifier, prop *identifier.Identifier) elastic.Query { //nolint:ireturn
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	boolQuery := elastic.NewBoolQuery().Must(
+		elastic.NewTermsQuery("claims.rel.to.id", values...),
+	)
+	if prop != nil {
+		boolQuery.Must(elastic.NewTermQuery("claims.rel.prop.id", *prop))
+	}
+	return elastic.NewNestedQuery("claims.rel", boolQuery)
+}
+
+// IncomingGet returns up to MaxResultsCount IDs of documents with a relation claim pointing
+// to any of the documents with the given IDs (e.g., a document and documents redirected to it). Relation claims are indexed at write time for all documents,
+// so this is an inverse lookup over the search index.
+func IncomingGet(
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), ids []identifier.Identifier, prop *identifier.Identifier,
+) (interface{}, map[string]interface{}, errors.E) {
+	metrics := waf.MustGetMetrics(ctx)
+
+	searchService, _ := getSearchService()
+	searchService = searchService.From(0).Size(MaxResultsCount).Query(IncomingQuery(ids, prop)).SortBy(Sorters(nil, nil, nil)...)
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	res, err := searchService.Do(ctx)
+	m.Stop()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
+
+	results := make([]searchIncomingResult, len(res.Hits.Hits))
+	for i, hit := range res.Hits.Hits {
+		results[i] = searchIncomingResult{ID: hit.Id}
+	}
+
+	// Total is a string or a number.
+	var total interface{}
+	if res.Hits.TotalHits.Relation == "gte" {
+		total = fmt.Sprintf("+%d", res.Hits.TotalHits.Value)
+	} else {
+		total = res.Hits.TotalHits.Value
+	}
+
+	return results, map[string]interface{}{
+		"total": total,
+	}, nil
+}
@@ -27,14 +27,10 @@ func IndexFilterGet(
 ) (interface{}, map[string]interface{}, errors.E) {
 	metrics := waf.MustGetMetrics(ctx)
 
-	m := metrics.Duration(internal.MetricSearchState).Start()
-	ss, ok := searches.Load(id)
-	m.Stop()
-	if !ok {
-		// Something was not OK, so we return not found.
-		return nil, nil, errors.WithStack(ErrNotFound)
+	sh, errE := loadState(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
 	}
-	sh := ss.(*State) //nolint:errcheck,forcetypeassert
 
 	query := sh.Query()
 
@@ -45,7 +41,7 @@ func IndexFilterGet(
 	indexAggregation := elastic.NewCardinalityAggregation().Field("_index").PrecisionThreshold(40000) //nolint:mnd
 	searchService = searchService.Size(0).Query(query).Aggregation("terms", termsAggregation).Aggregation("index", indexAggregation)
 
-	m = metrics.Duration(internal.MetricElasticSearch).Start()
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
 	res, err := searchService.Do(ctx)
 	m.Stop()
 	if err != nil {
@@ -55,7 +51,7 @@ func IndexFilterGet(
 
 	m = metrics.Duration(internal.MetricJSONUnmarshal).Start()
 	var terms indexAggregations
-	errE := x.Unmarshal(res.Aggregations["terms"], &terms)
+	errE = x.Unmarshal(res.Aggregations["terms"], &terms)
 	if errE != nil {
 		m.Stop()
 		return nil, nil, errE
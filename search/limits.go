@@ -0,0 +1,141 @@
+package search
+
+import (
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+// ErrLimitExceeded is returned when a request exceeds a limit.
+var ErrLimitExceeded = errors.BaseWrap(ErrInvalidArgument, "limit exceeded")
+
+const (
+	// DefaultMaxFilters is the default maximum number of filter clauses in a search request.
+	DefaultMaxFilters = 100
+
+	// MaxFilters is the largest configurable maximum number of filter clauses in a search request.
+	MaxFilters = 1000
+)
+
+// Limits are maximums enforced on search requests so that a single request cannot
+// construct an enormous ElasticSearch query. Zero value of a field means the default.
+//
+// Limits can only be lowered from the defaults (which are also hard maximums),
+// except for the number of filter clauses which can be raised up to MaxFilters.
+type Limits struct {
+	// MaxPageSize is the maximum number of search results returned in one response.
+	// Default is MaxPageSize.
+	MaxPageSize int `yaml:"maxPageSize,omitempty"`
+
+	// MaxFilters is the maximum number of filter clauses (including "and", "or", and "not"
+	// clauses) in a search request. Default is DefaultMaxFilters.
+	MaxFilters int `yaml:"maxFilters,omitempty"`
+
+	// MaxFacetSize is the maximum number of values (buckets) returned for a filter facet.
+	// Default is MaxResultsCount.
+	MaxFacetSize int `yaml:"maxFacetSize,omitempty"`
+
+	// MaxExportSize is the maximum number of search results exported as CSV in one response.
+	// Default is MaxResultsCount.
+	MaxExportSize int `yaml:"maxExportSize,omitempty"`
+}
+
+func validateLimit(name string, value, maxValue int) errors.E {
+	if value < 0 || value > maxValue {
+		errE := errors.New("limit out of range")
+		errors.Details(errE)["limit"] = name
+		errors.Details(errE)["value"] = value
+		errors.Details(errE)["max"] = maxValue
+		return errE
+	}
+	return nil
+}
+
+// Validate validates limits.
+func (l Limits) Validate() errors.E {
+	errE := validateLimit("maxPageSize", l.MaxPageSize, MaxPageSize)
+	if errE != nil {
+		return errE
+	}
+	errE = validateLimit("maxFilters", l.MaxFilters, MaxFilters)
+	if errE != nil {
+		return errE
+	}
+	errE = validateLimit("maxFacetSize", l.MaxFacetSize, MaxResultsCount)
+	if errE != nil {
+		return errE
+	}
+	return validateLimit("maxExportSize", l.MaxExportSize, MaxResultsCount)
+}
+
+// PageSize returns the maximum page size.
+func (l Limits) PageSize() int {
+	if l.MaxPageSize == 0 {
+		return MaxPageSize
+	}
+	return l.MaxPageSize
+}
+
+// Filters returns the maximum number of filter clauses.
+func (l Limits) Filters() int {
+	if l.MaxFilters == 0 {
+		return DefaultMaxFilters
+	}
+	return l.MaxFilters
+}
+
+// FacetSize returns the maximum facet size.
+func (l Limits) FacetSize() int {
+	if l.MaxFacetSize == 0 {
+		return MaxResultsCount
+	}
+	return l.MaxFacetSize
+}
+
+// ExportSize returns the maximum export size.
+func (l Limits) ExportSize() int {
+	if l.MaxExportSize == 0 {
+		return MaxResultsCount
+	}
+	return l.MaxExportSize
+}
+
+// CheckLimit returns ErrLimitExceeded if value is larger than maxValue.
+// The name of the request parameter is recorded in error details.
+func CheckLimit(param string, value, maxValue int) errors.E {
+	if value > maxValue {
+		errE := errors.WithMessage(ErrLimitExceeded, param)
+		errors.Details(errE)["param"] = param
+		errors.Details(errE)["value"] = value
+		errors.Details(errE)["max"] = maxValue
+		return errE
+	}
+	return nil
+}
+
+// CheckFilters returns ErrLimitExceeded if JSON filters have more clauses than allowed.
+// Filters which are not valid are not checked.
+func (l Limits) CheckFilters(filtersJSON string) errors.E {
+	if filtersJSON == "" {
+		return nil
+	}
+	var f filters
+	if x.UnmarshalWithoutUnknownFields([]byte(filtersJSON), &f) != nil {
+		return nil
+	}
+	return CheckLimit("filters", f.count(), l.Filters())
+}
+
+// count returns the number of clauses in filters.
+func (f filters) count() int {
+	c := 1
+	for _, a := range f.And {
+		c += a.count()
+	}
+	for _, o := range f.Or {
+		c += o.count()
+	}
+	if f.Not != nil {
+		c += f.Not.count()
+	}
+	return c
+}
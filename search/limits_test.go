@@ -0,0 +1,72 @@
+package search_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	var limits search.Limits
+	require.NoError(t, limits.Validate())
+	assert.Equal(t, search.MaxPageSize, limits.PageSize())
+	assert.Equal(t, search.DefaultMaxFilters, limits.Filters())
+	assert.Equal(t, search.MaxResultsCount, limits.FacetSize())
+	assert.Equal(t, search.MaxResultsCount, limits.ExportSize())
+
+	limits = search.Limits{MaxPageSize: 50, MaxFilters: 500, MaxFacetSize: 20, MaxExportSize: 10}
+	require.NoError(t, limits.Validate())
+	assert.Equal(t, 50, limits.PageSize())
+	assert.Equal(t, 500, limits.Filters())
+	assert.Equal(t, 20, limits.FacetSize())
+	assert.Equal(t, 10, limits.ExportSize())
+
+	for _, l := range []search.Limits{
+		{MaxPageSize: search.MaxPageSize + 1},       //nolint:exhaustruct
+		{MaxFilters: search.MaxFilters + 1},         //nolint:exhaustruct
+		{MaxFacetSize: -1},                          //nolint:exhaustruct
+		{MaxExportSize: search.MaxResultsCount + 1}, //nolint:exhaustruct
+	} {
+		assert.Error(t, l.Validate(), "%+v", l)
+	}
+}
+
+func TestCheckLimit(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, search.CheckLimit("size", 10, 10))
+
+	errE := search.CheckLimit("size", 11, 10)
+	assert.ErrorIs(t, errE, search.ErrLimitExceeded)
+	assert.ErrorIs(t, errE, search.ErrInvalidArgument)
+	assert.Equal(t, map[string]interface{}{"param": "size", "value": 11, "max": 10}, errors.AllDetails(errE))
+}
+
+func TestCheckFilters(t *testing.T) {
+	t.Parallel()
+
+	limits := search.Limits{MaxFilters: 3} //nolint:exhaustruct
+
+	rel := func() string {
+		return `{"rel":{"prop":"` + identifier.New().String() + `","none":true}}`
+	}
+
+	assert.NoError(t, limits.CheckFilters(""))
+	assert.NoError(t, limits.CheckFilters(rel()))
+	assert.NoError(t, limits.CheckFilters(`{"and":[`+rel()+`,`+rel()+`]}`))
+	// Invalid filters are not checked.
+	assert.NoError(t, limits.CheckFilters(`invalid`))
+
+	errE := limits.CheckFilters(`{"or":[` + strings.Join([]string{rel(), rel(), rel()}, ",") + `]}`)
+	assert.ErrorIs(t, errE, search.ErrLimitExceeded)
+	errE = limits.CheckFilters(`{"not":{"and":[` + rel() + `,` + rel() + `]}}`)
+	assert.ErrorIs(t, errE, search.ErrLimitExceeded)
+}
@@ -3,7 +3,6 @@ package search
 import (
 	"context"
 	"encoding/json"
-	"os"
 	"slices"
 
 	"github.com/olivere/elastic/v7"
@@ -87,9 +86,10 @@ func (s outputStruct) Filters() (*filters, errors.E) {
 			if value != "" {
 				f.And = append(f.And, filters{ //nolint:exhaustruct
 					Str: &stringFilter{
-						Prop: prop,
-						Str:  value,
-						None: false,
+						Prop:  prop,
+						Str:   value,
+						Match: "",
+						None:  false,
 					},
 				})
 			}
@@ -380,7 +380,7 @@ func findProperties(
 	}
 
 	bq := elastic.NewBoolQuery()
-	bq.Must(documentTextSearchQuery(query, "OR"))
+	bq.Must(documentTextSearchQuery(query, "OR", nil, nil, nil))
 	bq.Must(elastic.NewNestedQuery("claims.rel",
 		elastic.NewBoolQuery().Must(
 			elastic.NewTermQuery("claims.rel.prop.id", "CAfaL1ZZs6L4uyFdrJZ2wN"), // TYPE.
@@ -388,7 +388,7 @@ func findProperties(
 		),
 	))
 	timePropertiesSearchSevice, _ := getSearchService()
-	timePropertiesSearchSevice = timePropertiesSearchSevice.From(0).Size(MaxResultsCount).Query(bq)
+	timePropertiesSearchSevice = timePropertiesSearchSevice.From(0).Size(MaxResultsCount).Query(bq).SortBy(Sorters(nil, nil, nil)...)
 	res, err := timePropertiesSearchSevice.Do(ctx)
 	if err != nil {
 		return output, errors.WithStack(err)
@@ -429,7 +429,7 @@ func findProperties(
 	}
 
 	bq = elastic.NewBoolQuery()
-	bq.Must(documentTextSearchQuery(query, "OR"))
+	bq.Must(documentTextSearchQuery(query, "OR", nil, nil, nil))
 	bq.Must(elastic.NewNestedQuery("claims.rel",
 		elastic.NewBoolQuery().Must(
 			elastic.NewTermQuery("claims.rel.prop.id", "CAfaL1ZZs6L4uyFdrJZ2wN"), // TYPE.
@@ -437,7 +437,7 @@ func findProperties(
 		),
 	))
 	amountPropertiesSearchSevice, _ := getSearchService()
-	amountPropertiesSearchSevice = amountPropertiesSearchSevice.From(0).Size(MaxResultsCount).Query(bq)
+	amountPropertiesSearchSevice = amountPropertiesSearchSevice.From(0).Size(MaxResultsCount).Query(bq).SortBy(Sorters(nil, nil, nil)...)
 	res, err = amountPropertiesSearchSevice.Do(ctx)
 	if err != nil {
 		return output, errors.WithStack(err)
@@ -481,7 +481,7 @@ func findProperties(
 
 	// TODO: Generalize to all relation properties.
 	bq = elastic.NewBoolQuery()
-	bq.Must(documentTextSearchQuery(query, "OR"))
+	bq.Must(documentTextSearchQuery(query, "OR", nil, nil, nil))
 	bq.Must(elastic.NewNestedQuery("claims.rel",
 		elastic.NewBoolQuery().Must(
 			elastic.NewTermQuery("claims.rel.prop.id", "CAfaL1ZZs6L4uyFdrJZ2wN"), // TYPE.
@@ -489,7 +489,7 @@ func findProperties(
 		),
 	))
 	itemsSearchSevice, _ := getSearchService()
-	itemsSearchSevice = itemsSearchSevice.From(0).Size(MaxResultsCount).Query(bq)
+	itemsSearchSevice = itemsSearchSevice.From(0).Size(MaxResultsCount).Query(bq).SortBy(Sorters(nil, nil, nil)...)
 	res, err = itemsSearchSevice.Do(ctx)
 	if err != nil {
 		return output, errors.WithStack(err)
@@ -545,26 +545,14 @@ func findProperties(
 }
 
 func parsePrompt(
-	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	ctx context.Context, provider fun.TextProvider,
+	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	getSearchService func() (*elastic.SearchService, int64), prompt string,
 ) (outputStruct, errors.E) {
-	// TODO: Move out into config.
-	if os.Getenv("ANTHROPIC_API_KEY") == "" {
-		return outputStruct{}, errors.New("ANTHROPIC_API_KEY is not available")
-	}
-
 	var result *outputStruct
 
 	f := fun.Text[string, string]{
-		Provider: &fun.AnthropicTextProvider{
-			Client:            nil,
-			APIKey:            os.Getenv("ANTHROPIC_API_KEY"),
-			Model:             "claude-3-5-sonnet-20240620",
-			MaxContextLength:  0,
-			MaxResponseLength: 0,
-			PromptCaching:     true,
-			Temperature:       0,
-		},
+		Provider:         provider,
 		InputJSONSchema:  nil,
 		OutputJSONSchema: nil,
 		Prompt:           systemPrompt,
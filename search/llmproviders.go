@@ -0,0 +1,205 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/fun"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+const (
+	// DefaultLLMPrimary is the default primary LLM provider.
+	DefaultLLMPrimary = "anthropic:claude-3-5-sonnet-20240620"
+	// DefaultLLMLatencySLO is the default latency after which parsing of a prompt with the primary
+	// LLM provider is abandoned in favor of the fallback provider.
+	DefaultLLMLatencySLO = 30 * time.Second
+
+	// defaultOllamaHost is used when OLLAMA_HOST environment variable is not set.
+	defaultOllamaHost = "http://localhost:11434"
+)
+
+// llmAPIKeyEnvs maps supported LLM provider types to environment variables with their API keys.
+// Ollama does not use an API key.
+var llmAPIKeyEnvs = map[string]string{ //nolint:gochecknoglobals
+	"anthropic": "ANTHROPIC_API_KEY",
+	"openai":    "OPENAI_API_KEY",
+	"groq":      "GROQ_API_KEY",
+	"ollama":    "",
+}
+
+// LLMProvider is a LLM provider and its model used to parse prompts.
+//
+// API keys are read from environment variables when prompts are parsed: ANTHROPIC_API_KEY,
+// OPENAI_API_KEY, and GROQ_API_KEY, respectively. Ollama is reached at OLLAMA_HOST
+// (by default http://localhost:11434).
+type LLMProvider struct {
+	// Type is one of "anthropic", "openai", "groq", and "ollama".
+	Type  string
+	Model string
+}
+
+// ParseLLMProvider parses the LLM provider from a "<type>:<model>" string
+// (e.g., "openai:gpt-4o").
+func ParseLLMProvider(spec string) (*LLMProvider, errors.E) {
+	providerType, model, ok := strings.Cut(spec, ":")
+	if !ok || model == "" {
+		errE := errors.New(`LLM provider has to be in "<type>:<model>" format`)
+		errors.Details(errE)["provider"] = spec
+		return nil, errE
+	}
+	if _, ok := llmAPIKeyEnvs[providerType]; !ok {
+		errE := errors.New("unsupported LLM provider type")
+		errors.Details(errE)["provider"] = spec
+		errors.Details(errE)["type"] = providerType
+		return nil, errE
+	}
+	return &LLMProvider{
+		Type:  providerType,
+		Model: model,
+	}, nil
+}
+
+func (p LLMProvider) String() string {
+	return p.Type + ":" + p.Model
+}
+
+// Available returns true if the API key of the provider is available.
+func (p LLMProvider) Available() bool {
+	env := llmAPIKeyEnvs[p.Type]
+	return env == "" || os.Getenv(env) != ""
+}
+
+// textProvider returns a new fun.TextProvider for the provider.
+func (p LLMProvider) textProvider() (fun.TextProvider, errors.E) { //nolint:ireturn
+	env := llmAPIKeyEnvs[p.Type]
+	if env != "" && os.Getenv(env) == "" {
+		return nil, errors.Errorf("%s is not available", env)
+	}
+
+	switch p.Type {
+	case "anthropic":
+		return &fun.AnthropicTextProvider{ //nolint:exhaustruct
+			Client:        nil,
+			APIKey:        os.Getenv(env),
+			Model:         p.Model,
+			PromptCaching: true,
+			Temperature:   0,
+		}, nil
+	case "openai":
+		return &fun.OpenAITextProvider{ //nolint:exhaustruct
+			Client:      nil,
+			APIKey:      os.Getenv(env),
+			Model:       p.Model,
+			Temperature: 0,
+		}, nil
+	case "groq":
+		return &fun.GroqTextProvider{ //nolint:exhaustruct
+			Client:      nil,
+			APIKey:      os.Getenv(env),
+			Model:       p.Model,
+			Temperature: 0,
+		}, nil
+	case "ollama":
+		base := os.Getenv("OLLAMA_HOST")
+		if base == "" {
+			base = defaultOllamaHost
+		}
+		return &fun.OllamaTextProvider{ //nolint:exhaustruct
+			Client:      nil,
+			Base:        base,
+			Model:       p.Model,
+			Temperature: 0,
+		}, nil
+	}
+
+	errE := errors.New("unsupported LLM provider type")
+	errors.Details(errE)["type"] = p.Type
+	return nil, errE
+}
+
+// LLMProviders configures LLM providers used to parse prompts: the primary provider and
+// an optional fallback provider.
+//
+// When parsing with the primary provider fails or does not finish within LatencySLO,
+// the prompt is transparently parsed again with the fallback provider, so that natural
+// language search stays available during outages of the primary provider.
+type LLMProviders struct {
+	Primary LLMProvider
+	// Fallback is nil when there is no fallback provider.
+	Fallback *LLMProvider
+	// LatencySLO is ignored when there is no fallback provider. Zero disables it.
+	LatencySLO time.Duration
+}
+
+// Available returns true if the API key of the primary or the fallback provider is available.
+//
+// Nil LLMProviders means the default primary provider without a fallback.
+func (p *LLMProviders) Available() bool {
+	if p == nil {
+		return os.Getenv(llmAPIKeyEnvs["anthropic"]) != ""
+	}
+	return p.Primary.Available() || (p.Fallback != nil && p.Fallback.Available())
+}
+
+// parsePrompt parses the prompt with the primary provider and, if that fails, with
+// the fallback provider. It returns the output and the provider which produced it.
+func (p *LLMProviders) parsePrompt(
+	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	getSearchService func() (*elastic.SearchService, int64), prompt string,
+) (outputStruct, string, errors.E) {
+	if p == nil {
+		provider, errE := ParseLLMProvider(DefaultLLMPrimary)
+		if errE != nil {
+			return outputStruct{}, "", errE
+		}
+		p = &LLMProviders{Primary: *provider, Fallback: nil, LatencySLO: 0}
+	}
+
+	primaryCtx := ctx
+	if p.Fallback != nil && p.LatencySLO > 0 {
+		var cancel context.CancelFunc
+		primaryCtx, cancel = context.WithTimeout(ctx, p.LatencySLO)
+		defer cancel()
+	}
+
+	output, errE := parsePromptWith(primaryCtx, p.Primary, store, getSearchService, prompt)
+	if errE == nil {
+		return output, p.Primary.String(), nil
+	}
+	if p.Fallback == nil || ctx.Err() != nil {
+		errors.Details(errE)["provider"] = p.Primary.String()
+		return outputStruct{}, "", errE
+	}
+
+	zerolog.Ctx(ctx).Warn().Err(errE).Str("primary", p.Primary.String()).Str("fallback", p.Fallback.String()).
+		Msg("prompt parsing with primary LLM provider failed, using fallback")
+
+	output, errE = parsePromptWith(ctx, *p.Fallback, store, getSearchService, prompt)
+	if errE != nil {
+		errors.Details(errE)["provider"] = p.Fallback.String()
+		return outputStruct{}, "", errE
+	}
+	return output, p.Fallback.String(), nil
+}
+
+func parsePromptWith(
+	ctx context.Context, provider LLMProvider,
+	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	getSearchService func() (*elastic.SearchService, int64), prompt string,
+) (outputStruct, errors.E) {
+	textProvider, errE := provider.textProvider()
+	if errE != nil {
+		return outputStruct{}, errE
+	}
+	return parsePrompt(ctx, textProvider, store, getSearchService, prompt)
+}
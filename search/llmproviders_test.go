@@ -0,0 +1,37 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestParseLLMProvider(t *testing.T) {
+	t.Parallel()
+
+	provider, errE := search.ParseLLMProvider(search.DefaultLLMPrimary)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "anthropic", provider.Type)
+	assert.Equal(t, search.DefaultLLMPrimary, provider.String())
+
+	provider, errE = search.ParseLLMProvider("ollama:llama3.1:8b")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, &search.LLMProvider{Type: "ollama", Model: "llama3.1:8b"}, provider)
+	// Ollama does not use an API key.
+	assert.True(t, provider.Available())
+
+	for _, spec := range []string{"", "anthropic", "anthropic:", "unknown:model"} {
+		_, errE := search.ParseLLMProvider(spec)
+		assert.Error(t, errE, spec)
+	}
+
+	providers := &search.LLMProviders{
+		Primary:    search.LLMProvider{Type: "groq", Model: "llama3-70b-8192"},
+		Fallback:   provider,
+		LatencySLO: search.DefaultLLMLatencySLO,
+	}
+	assert.True(t, providers.Available())
+}
@@ -0,0 +1,123 @@
+package search
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	matchedQueryName   = "q"
+	matchedFiltersName = "f"
+)
+
+// Match describes a part of the search state a search result matched: either the
+// search query or a filter clause (in the same format as filters are provided).
+type Match struct {
+	filters
+
+	Query string `json:"q,omitempty"`
+}
+
+// namedQuery is like ToQuery, but queries of clauses which can be matched are named so that
+// ElasticSearch reports them as matched. Names are paths of clauses in filters.
+func (f filters) namedQuery(asOf *document.Timestamp, name string) elastic.Query { //nolint:ireturn
+	if len(f.And) > 0 {
+		boolQuery := elastic.NewBoolQuery()
+		for i, filter := range f.And {
+			boolQuery.Must(filter.namedQuery(asOf, name+"."+strconv.Itoa(i)))
+		}
+		return boolQuery
+	}
+	if len(f.Or) > 0 {
+		boolQuery := elastic.NewBoolQuery()
+		for i, filter := range f.Or {
+			boolQuery.Should(filter.namedQuery(asOf, name+"."+strconv.Itoa(i)))
+		}
+		return boolQuery
+	}
+	if !f.matchable() {
+		return f.ToQuery(asOf)
+	}
+	return elastic.NewBoolQuery().Must(f.ToQuery(asOf)).QueryName(name)
+}
+
+// matchable returns true if the clause can be reported as matched:
+// it is not a negation and it is not matching documents without a value.
+func (f filters) matchable() bool {
+	switch {
+	case f.Rel != nil:
+		return !f.Rel.None
+	case f.Amount != nil:
+		return !f.Amount.None
+	case f.Time != nil:
+		return !f.Time.None
+	case f.Str != nil:
+		return !f.Str.None
+	case f.Size != nil:
+		return !f.Size.None
+	case f.Children != nil:
+		return true
+	default:
+		return f.Index != nil
+	}
+}
+
+// named returns the clause with the name given to it by namedQuery.
+func (f filters) named(name string) (filters, bool) {
+	path, ok := strings.CutPrefix(name, matchedFiltersName)
+	if !ok {
+		return filters{}, false //nolint:exhaustruct
+	}
+	for path != "" {
+		var next string
+		path, ok = strings.CutPrefix(path, ".")
+		if !ok {
+			return filters{}, false //nolint:exhaustruct
+		}
+		next, path, _ = strings.Cut(path, ".")
+		if path != "" {
+			path = "." + path
+		}
+		i, err := strconv.Atoi(next)
+		if err != nil || i < 0 {
+			return filters{}, false //nolint:exhaustruct
+		}
+		switch {
+		case i < len(f.And):
+			f = f.And[i]
+		case i < len(f.Or):
+			f = f.Or[i]
+		default:
+			return filters{}, false //nolint:exhaustruct
+		}
+	}
+	return f, f.matchable()
+}
+
+// NamedQuery is like WeightedQuery, but for search states with the query and filters
+// parsed from a prompt, parts of the search state are named so that ElasticSearch reports
+// for each search result which parts it matched. Use Matches to describe them.
+func (s *State) NamedQuery(weights FieldWeights, names NameProperties) elastic.Query { //nolint:ireturn
+	return s.query(weights, names, s.Prompt != "", false)
+}
+
+// Matches returns descriptions of parts of the search state ElasticSearch reported
+// as matched (by their names given by NamedQuery), in the order of names.
+// Unknown names are ignored.
+func (s *State) Matches(names []string) []Match {
+	var matches []Match
+	for _, name := range names {
+		if name == matchedQueryName {
+			matches = append(matches, Match{filters: filters{}, Query: s.SearchQuery}) //nolint:exhaustruct
+		} else if s.Filters != nil {
+			if f, ok := s.Filters.named(name); ok {
+				matches = append(matches, Match{filters: f, Query: ""})
+			}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,61 @@
+//nolint:testpackage
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+)
+
+func TestMatches(t *testing.T) {
+	t.Parallel()
+
+	artist := identifier.New()
+	picasso := identifier.New()
+	braque := identifier.New()
+	classification := identifier.New()
+
+	f, errE := parseFilters(`{"and":[` +
+		`{"or":[{"rel":{"prop":"` + artist.String() + `","value":"` + picasso.String() + `"}},` +
+		`{"rel":{"prop":"` + artist.String() + `","value":"` + braque.String() + `"}}]},` +
+		`{"str":{"prop":"` + classification.String() + `","str":"Painting"}},` +
+		`{"not":{"index":{"str":"other"}}},` +
+		`{"rel":{"prop":"` + classification.String() + `","none":true}}` +
+		`]}`)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	sh := &State{SearchQuery: "cubism", Filters: f} //nolint:exhaustruct
+
+	// Without a prompt, nothing is named.
+	source, err := sh.NamedQuery(nil, nil).Source()
+	require.NoError(t, err)
+	data, err := json.Marshal(source)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "_name")
+
+	sh.Prompt = "cubist paintings by Picasso or Braque"
+	source, err = sh.NamedQuery(nil, nil).Source()
+	require.NoError(t, err)
+	data, err = json.Marshal(source)
+	require.NoError(t, err)
+	for _, name := range []string{`"_name":"q"`, `"_name":"f.0.0"`, `"_name":"f.0.1"`, `"_name":"f.1"`} {
+		assert.Contains(t, string(data), name)
+	}
+	// Negations and clauses matching missing values are not named.
+	assert.NotContains(t, string(data), `"_name":"f.2"`)
+	assert.NotContains(t, string(data), `"_name":"f.3"`)
+
+	matches := sh.Matches([]string{"q", "f.0.1", "f.1", "f.2", "f.3", "f.9", "f.x", "other"})
+	data, err = json.Marshal(matches)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[`+
+		`{"q":"cubism"},`+
+		`{"rel":{"prop":"`+artist.String()+`","value":"`+braque.String()+`"}},`+
+		`{"str":{"prop":"`+classification.String()+`","str":"Painting"}}`+
+		`]`, string(data))
+
+	assert.Nil(t, sh.Matches(nil))
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	// MaxNameProperties is the maximum number of name properties.
+	MaxNameProperties = 20
+
+	// maxNameTerms is the maximum number of terms of a search query which is matched
+	// against name properties. Longer queries are not names.
+	maxNameTerms = 10
+)
+
+// NameProperties are properties whose claims contain names or parts of names (e.g., given
+// and family names of people). Terms of the search query are matched against claims of all
+// name properties of a document together, so a query matches when every term matches some
+// name claim, regardless of the order of terms. Matching ignores case and diacritics and
+// initials (e.g., "L." or "L") match name parts starting with the letter.
+//
+// In JSON and YAML, properties are listed by their IDs or by mnemonics of core properties.
+type NameProperties []identifier.Identifier
+
+func (n *NameProperties) fromStrings(props []string) errors.E {
+	result := NameProperties{}
+	for _, key := range props {
+		id, errE := parseProperty(key)
+		if errE != nil {
+			return errE
+		}
+		result = append(result, id)
+	}
+	*n = result
+	return nil
+}
+
+func (n *NameProperties) UnmarshalJSON(data []byte) error {
+	var props []string
+	errE := x.UnmarshalWithoutUnknownFields(data, &props)
+	if errE != nil {
+		return errE
+	}
+	return n.fromStrings(props)
+}
+
+func (n *NameProperties) UnmarshalYAML(value *yaml.Node) error {
+	var props []string
+	err := value.Decode(&props)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return n.fromStrings(props)
+}
+
+// Validate validates name properties.
+func (n NameProperties) Validate() errors.E {
+	if len(n) > MaxNameProperties {
+		errE := errors.New("too many name properties")
+		errors.Details(errE)["count"] = len(n)
+		errors.Details(errE)["max"] = MaxNameProperties
+		return errE
+	}
+	seen := map[identifier.Identifier]bool{}
+	for _, prop := range n {
+		if seen[prop] {
+			errE := errors.New("duplicate property")
+			errors.Details(errE)["prop"] = prop.String()
+			return errE
+		}
+		seen[prop] = true
+	}
+	return nil
+}
+
+// nameTerms returns terms of the search query if it consists only of plain terms,
+// without any operators, phrases, or other syntax. Otherwise it returns nil.
+func nameTerms(searchQuery string) []string {
+	tokens, warnings := tokenizeQuery([]rune(searchQuery))
+	if len(warnings) > 0 {
+		return nil
+	}
+	terms := []string{}
+	for _, t := range tokens {
+		switch t.Kind { //nolint:exhaustive
+		case queryTokenSpace:
+			continue
+		case queryTokenTerm:
+			if strings.ContainsAny(t.Text, `\*~`) {
+				return nil
+			}
+			terms = append(terms, t.Text)
+		default:
+			return nil
+		}
+	}
+	if len(terms) == 0 || len(terms) > maxNameTerms {
+		return nil
+	}
+	return terms
+}
+
+// nameInitial returns the letter if the term is an initial (e.g., "L." or "L").
+func nameInitial(term string) (string, bool) {
+	initial := strings.TrimSuffix(term, ".")
+	r, size := utf8.DecodeRuneInString(initial)
+	if size == 0 || size != len(initial) || !unicode.IsLetter(r) {
+		return "", false
+	}
+	return initial, true
+}
+
+// nameQuery returns a query which matches documents where every term of the search query
+// matches some claim of name properties. It returns nil if there are no name properties
+// or if the search query is not a plain list of terms.
+func nameQuery(searchQuery string, asOf *document.Timestamp, names NameProperties) elastic.Query { //nolint:ireturn
+	if len(names) == 0 {
+		return nil
+	}
+	terms := nameTerms(searchQuery)
+	if terms == nil {
+		return nil
+	}
+
+	values := make([]interface{}, len(names))
+	for i, prop := range names {
+		values[i] = prop
+	}
+
+	bq := elastic.NewBoolQuery()
+	for _, term := range terms {
+		termQuery := elastic.NewBoolQuery()
+		for _, field := range []field{
+			{"claims.string", "string.name"},
+			{"claims.text", "html.en.name"},
+		} {
+			var q elastic.Query
+			if initial, ok := nameInitial(term); ok {
+				q = elastic.NewMatchPhrasePrefixQuery(field.Prefix+"."+field.Field, initial)
+			} else {
+				q = elastic.NewMatchQuery(field.Prefix+"."+field.Field, term).Operator("AND")
+			}
+			termQuery.Should(nestedQuery(field.Prefix, asOf, elastic.NewBoolQuery().Must(q).Filter(elastic.NewTermsQuery(field.Prefix+".prop.id", values...))))
+		}
+		bq.Must(termQuery)
+	}
+	return bq
+}
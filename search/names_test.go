@@ -0,0 +1,67 @@
+package search_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestNameProperties(t *testing.T) {
+	t.Parallel()
+
+	var names search.NameProperties
+	err := yaml.Unmarshal([]byte("- NAME\n- "+document.GetCorePropertyID("DESCRIPTION").String()+"\n"), &names)
+	require.NoError(t, err)
+	assert.Equal(t, search.NameProperties{document.GetCorePropertyID("NAME"), document.GetCorePropertyID("DESCRIPTION")}, names)
+	assert.NoError(t, names.Validate())
+
+	err = json.Unmarshal([]byte(`["invalid-property"]`), &names)
+	assert.Error(t, err)
+
+	names = search.NameProperties{document.GetCorePropertyID("NAME"), document.GetCorePropertyID("NAME")}
+	assert.Error(t, names.Validate())
+}
+
+func TestNameQuery(t *testing.T) {
+	t.Parallel()
+
+	name := document.GetCorePropertyID("NAME")
+	names := search.NameProperties{name}
+
+	queryJSON := func(t *testing.T, query string, names search.NameProperties) string {
+		t.Helper()
+
+		sh := &search.State{SearchQuery: query} //nolint:exhaustruct
+		source, err := sh.WeightedQuery(nil, names).Source()
+		require.NoError(t, err)
+		data, err := json.Marshal(source)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// Without name properties there is no name query.
+	assert.NotContains(t, queryJSON(t, "Ludwig Mies van der Rohe", nil), "string.name")
+
+	data := queryJSON(t, "Ludwig Mies van der Rohe", names)
+	// Every term is matched separately against claims of name properties.
+	assert.Equal(t, 5, strings.Count(data, `"claims.string.string.name":{"operator":"AND"`))
+	assert.Equal(t, 5, strings.Count(data, `"claims.text.html.en.name":{"operator":"AND"`))
+	assert.Contains(t, data, `{"terms":{"claims.string.prop.id":["`+name.String()+`"]}}`)
+
+	// Initials match name parts starting with the letter.
+	data = queryJSON(t, "L. Mies", names)
+	assert.Contains(t, data, `{"match_phrase_prefix":{"claims.string.string.name":{"query":"L"}}}`)
+	assert.Equal(t, 1, strings.Count(data, `"claims.string.string.name":{"operator":"AND"`))
+
+	// Queries with operators or phrases are not names.
+	assert.NotContains(t, queryJSON(t, `"Mies van der Rohe" Ludwig`, names), "string.name")
+	assert.NotContains(t, queryJSON(t, "Ludwig | Mies", names), "string.name")
+	assert.NotContains(t, queryJSON(t, "Mies*", names), "string.name")
+}
@@ -0,0 +1,147 @@
+package search
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	// MaxNarrowerDepth is the maximum depth of the hierarchy followed when expanding
+	// a relation filter value with narrower documents.
+	MaxNarrowerDepth = 10
+	// MaxNarrowerValues is the maximum number of narrower documents a relation filter value
+	// is expanded with.
+	MaxNarrowerValues = 1000
+)
+
+// narrowerValues returns IDs of documents which are transitively narrower than the document
+// with ID value: they have a BROADER relation claim pointing to it or to a document narrower
+// than it (e.g., subcategories of a category). At most MaxNarrowerValues IDs are returned.
+func narrowerValues(
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), value identifier.Identifier,
+) ([]identifier.Identifier, errors.E) {
+	seen := map[identifier.Identifier]bool{value: true}
+	result := []identifier.Identifier{}
+	frontier := []interface{}{value.String()}
+	for depth := 0; depth < MaxNarrowerDepth && len(frontier) > 0 && len(result) < MaxNarrowerValues; depth++ {
+		query := elastic.NewNestedQuery("claims.rel",
+			elastic.NewBoolQuery().Must(
+				elastic.NewTermQuery("claims.rel.prop.id", document.GetCorePropertyID("BROADER")),
+				elastic.NewTermsQuery("claims.rel.to.id", frontier...),
+			),
+		)
+		searchService, _ := getSearchService()
+		res, err := searchService.From(0).Size(MaxNarrowerValues - len(result)).Query(query).
+			SortBy(IDSorter()).FetchSource(false).Do(ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		frontier = []interface{}{}
+		for _, hit := range res.Hits.Hits {
+			id, errE := identifier.FromString(hit.Id)
+			if errE != nil {
+				return nil, errE
+			}
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			result = append(result, id)
+			frontier = append(frontier, id.String())
+		}
+	}
+	return result, nil
+}
+
+// expandNarrower expands values of relation filters with documents narrower than them
+// (see narrowerValues), so that filtering by a category also matches documents in its
+// subcategories.
+func (f *filters) expandNarrower(ctx context.Context, getSearchService func() (*elastic.SearchService, int64)) errors.E {
+	for i := range f.And {
+		errE := f.And[i].expandNarrower(ctx, getSearchService)
+		if errE != nil {
+			return errE
+		}
+	}
+	for i := range f.Or {
+		errE := f.Or[i].expandNarrower(ctx, getSearchService)
+		if errE != nil {
+			return errE
+		}
+	}
+	if f.Not != nil {
+		errE := f.Not.expandNarrower(ctx, getSearchService)
+		if errE != nil {
+			return errE
+		}
+	}
+	if f.Rel != nil {
+		return f.Rel.expandNarrower(ctx, getSearchService)
+	}
+	return nil
+}
+
+func (f *relFilter) expandNarrower(ctx context.Context, getSearchService func() (*elastic.SearchService, int64)) errors.E {
+	if f.Value == nil {
+		return nil
+	}
+	values, errE := narrowerValues(ctx, getSearchService, *f.Value)
+	if errE != nil {
+		errors.Details(errE)["value"] = f.Value.String()
+		return errE
+	}
+	f.narrower = values
+	return nil
+}
+
+// ExpandNarrower expands values of relation predicates of the structured query with
+// documents narrower than them (e.g., subcategories of a category).
+func (n *QueryNode) ExpandNarrower(ctx context.Context, getSearchService func() (*elastic.SearchService, int64)) errors.E {
+	for i := range n.And {
+		errE := n.And[i].ExpandNarrower(ctx, getSearchService)
+		if errE != nil {
+			return errE
+		}
+	}
+	for i := range n.Or {
+		errE := n.Or[i].ExpandNarrower(ctx, getSearchService)
+		if errE != nil {
+			return errE
+		}
+	}
+	if n.Not != nil {
+		errE := n.Not.ExpandNarrower(ctx, getSearchService)
+		if errE != nil {
+			return errE
+		}
+	}
+	if n.Rel != nil {
+		return n.Rel.expandNarrower(ctx, getSearchService)
+	}
+	return nil
+}
+
+// filtersEqual returns true if filters a and b are equal, ignoring narrower documents
+// they have been expanded with.
+func filtersEqual(a, b *filters) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	aJSON, errE := x.MarshalWithoutEscapeHTML(a)
+	if errE != nil {
+		return false
+	}
+	bJSON, errE := x.MarshalWithoutEscapeHTML(b)
+	if errE != nil {
+		return false
+	}
+	return bytes.Equal(aJSON, bJSON)
+}
@@ -0,0 +1,39 @@
+//nolint:testpackage
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+)
+
+func TestRelFilterNarrower(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	value := identifier.New()
+	narrower := identifier.New()
+
+	f, errE := parseFilters(`{"rel":{"prop":"` + prop.String() + `","value":"` + value.String() + `"}}`)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	parsed, errE := parseFilters(`{"rel":{"prop":"` + prop.String() + `","value":"` + value.String() + `"}}`)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	f.Rel.narrower = []identifier.Identifier{narrower}
+
+	source, err := f.ToQuery(nil).Source()
+	require.NoError(t, err)
+	data, err := json.Marshal(source)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"nested":{"path":"claims.rel","query":{"bool":{"must":[`+
+		`{"term":{"claims.rel.prop.id":"`+prop.String()+`"}},`+
+		`{"terms":{"claims.rel.to.id":["`+value.String()+`","`+narrower.String()+`"]}}]}}}}`, string(data))
+
+	// Narrower documents are not part of the filters' JSON.
+	assert.True(t, filtersEqual(f, parsed))
+	assert.False(t, filtersEqual(f, nil))
+}
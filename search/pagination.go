@@ -0,0 +1,316 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+)
+
+const (
+	DefaultPaginationKeepAlive = 5 * time.Minute
+
+	// DefaultPointInTimeReuse is the default duration for which a point in time is reused by new pagination sessions.
+	DefaultPointInTimeReuse = 5 * time.Second
+
+	// MaxPageSize is the maximum number of results in one page.
+	MaxPageSize = MaxResultsCount
+)
+
+// ErrSessionExpired is returned when the pagination session does not exist anymore.
+var ErrSessionExpired = errors.Base("session expired")
+
+// session is the state of a pagination session, encoded into a session token.
+type session struct {
+	// Index is the index the session is for.
+	Index string `json:"i"`
+
+	// State is the ID of the search state the session is for.
+	State string `json:"s"`
+
+	// PIT is the ID of the ElasticSearch point in time.
+	PIT string `json:"pit"`
+
+	// After are sort values of the last result of the previous page.
+	After []interface{} `json:"after"`
+
+	// Sorts are sort specifications the session was started with.
+	Sorts []Sort `json:"sort,omitempty"`
+
+	// Weights are field weights the session was started with.
+	Weights FieldWeights `json:"weights,omitempty"`
+
+	// TieBreakers are tie-breakers the session was started with.
+	TieBreakers TieBreakers `json:"tieBreakers,omitempty"`
+
+	// Now is the Unix time when the session started. It is used as "now" for
+	// scoring functions so that scores do not change between pages.
+	Now int64 `json:"now,omitempty"`
+}
+
+// sessionSignature returns the signature of encoded session data.
+func sessionSignature(data string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// token returns the session encoded as a token and signed with secret,
+// so that clients cannot change it (e.g., the point in time ID).
+func (s *session) token(secret []byte) (string, errors.E) {
+	data, errE := x.MarshalWithoutEscapeHTML(s)
+	if errE != nil {
+		return "", errE
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded + "." + sessionSignature(encoded, secret), nil
+}
+
+func parseSessionToken(token string, secret []byte) (*session, errors.E) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errors.WithMessage(ErrInvalidArgument, "unsigned session")
+	}
+	if !hmac.Equal([]byte(signature), []byte(sessionSignature(encoded, secret))) {
+		return nil, errors.WithMessage(ErrInvalidArgument, "invalid session signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.WrapWith(err, ErrInvalidArgument)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	// Sort values have to be passed back to ElasticSearch exactly as they were returned.
+	decoder.UseNumber()
+	var s session
+	err = decoder.Decode(&s)
+	if err != nil {
+		return nil, errors.WrapWith(err, ErrInvalidArgument)
+	}
+	if s.PIT == "" || len(s.After) == 0 {
+		return nil, errors.WithMessage(ErrInvalidArgument, "invalid session")
+	}
+	return &s, nil
+}
+
+// PointsInTime shares ElasticSearch points in time of an index between pagination sessions.
+//
+// A point in time opened for a new pagination session is reused by pagination sessions started
+// within the Reuse duration after it, so the number of open points in time per index is bounded
+// by the keep alive duration divided by Reuse, no matter how many pagination sessions are started.
+// New pagination sessions see the index as it was at most Reuse ago.
+type PointsInTime struct {
+	// Reuse is for how long a point in time is reused. It is capped to half of the keep alive duration.
+	Reuse time.Duration
+
+	mu   sync.Mutex
+	pits map[string]pointInTime
+}
+
+type pointInTime struct {
+	id     string
+	opened time.Time
+}
+
+// Open returns the ID of a point in time for the index. The point in time is opened
+// using openPointInTime, unless one has been opened for the index within the Reuse duration.
+func (p *PointsInTime) Open(
+	ctx context.Context, index string, openPointInTime func() *elastic.OpenPointInTimeService, keepAlive time.Duration,
+) (string, errors.E) {
+	keepAliveString := fmt.Sprintf("%dms", keepAlive.Milliseconds())
+
+	if p == nil {
+		res, err := openPointInTime().KeepAlive(keepAliveString).Do(ctx)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		return res.Id, nil
+	}
+
+	// We hold the lock while opening so that concurrent pagination sessions do not open more points in time.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if pit, ok := p.pits[index]; ok && now.Sub(pit.opened) < min(p.Reuse, keepAlive/2) { //nolint:mnd
+		return pit.id, nil
+	}
+
+	res, err := openPointInTime().KeepAlive(keepAliveString).Do(ctx)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if p.pits == nil {
+		p.pits = map[string]pointInTime{}
+	}
+	p.pits[index] = pointInTime{id: res.Id, opened: now}
+	return res.Id, nil
+}
+
+// Page is one page of search results of a pagination session.
+type Page struct {
+	Hits  []*elastic.SearchHit
+	Total *elastic.TotalHits
+
+	// TimedOut is true when the search timed out and hits are partial.
+	TimedOut bool
+
+	// Took is how long the search took inside ElasticSearch.
+	Took time.Duration
+
+	// Session is the token to obtain the next page. It is empty when there are no more results.
+	Session string
+
+	// Aggregations are aggregations requested by the search service returned by getSearchService.
+	Aggregations elastic.Aggregations
+}
+
+// Paginate returns one page of results of the search state. Pages are returned from a point
+// in time (PIT) of the index, so that all pages of a pagination session see the same snapshot
+// of the index even if the index changes in the meantime.
+//
+// When sessionToken is empty, a new pagination session is started and the first page is returned.
+// Otherwise the page following the one which returned sessionToken is returned. Sessions expire
+// if they are not used for longer than keepAlive. Points in time are opened through pits
+// (see PointsInTime), which can be nil to open a new point in time for every pagination session.
+// Because points in time can be shared, they are not closed but expire.
+//
+// Session tokens are signed with secret and are valid only for the index (which
+// openPointInTime should open the point in time for) and the search state they were
+// started for. The secret should be the same for all instances serving the index.
+//
+// Results are sorted by sorts, if any, by score, and then by tie-breakers and document ID (see Sorters).
+// For search states parsed from a prompt, hits report which parts of the search state they matched
+// (see State.NamedQuery).
+//
+// getSearchService should return a search service which is not bound to any index, because
+// the index is determined by the point in time. Scoring functions, field weights, and name properties should be validated.
+func Paginate(
+	ctx context.Context, getSearchService func() *elastic.SearchService,
+	pits *PointsInTime, openPointInTime func() *elastic.OpenPointInTimeService,
+	sh *State, scoring []ScoringFunction, weights FieldWeights, names NameProperties, sorts []Sort, tieBreakers TieBreakers, size int,
+	index string, secret []byte, sessionToken string, keepAlive time.Duration,
+) (*Page, errors.E) {
+	if size <= 0 || size > MaxPageSize {
+		errE := errors.WithMessage(ErrInvalidArgument, "size out of range")
+		errors.Details(errE)["size"] = size
+		errors.Details(errE)["max"] = MaxPageSize
+		return nil, errE
+	}
+
+	keepAliveString := fmt.Sprintf("%dms", keepAlive.Milliseconds())
+
+	var s *session
+	if sessionToken == "" {
+		pit, errE := pits.Open(ctx, index, openPointInTime, keepAlive)
+		if errE != nil {
+			return nil, errE
+		}
+		s = &session{
+			Index:       index,
+			State:       sh.ID.String(),
+			PIT:         pit,
+			After:       nil,
+			Sorts:       sorts,
+			Weights:     weights,
+			TieBreakers: tieBreakers,
+			Now:         time.Now().Unix(),
+		}
+	} else {
+		var errE errors.E
+		s, errE = parseSessionToken(sessionToken, secret)
+		if errE != nil {
+			return nil, errE
+		}
+		if s.Index != index {
+			errE := errors.WithMessage(ErrInvalidArgument, "session is for a different index")
+			errors.Details(errE)["session"] = s.Index
+			errors.Details(errE)["index"] = index
+			return nil, errE
+		}
+		if s.State != sh.ID.String() {
+			errE := errors.WithMessage(ErrInvalidArgument, "session is for a different search state")
+			errors.Details(errE)["session"] = s.State
+			errors.Details(errE)["s"] = sh.ID.String()
+			return nil, errE
+		}
+		if !reflect.DeepEqual(s.Sorts, sorts) {
+			return nil, errors.WithMessage(ErrInvalidArgument, "session is for a different sort")
+		}
+		if len(s.Weights) != 0 || len(weights) != 0 {
+			if !reflect.DeepEqual(s.Weights, weights) {
+				return nil, errors.WithMessage(ErrInvalidArgument, "session is for different weights")
+			}
+		}
+		if len(s.TieBreakers) != 0 || len(tieBreakers) != 0 {
+			if !reflect.DeepEqual(s.TieBreakers, tieBreakers) {
+				return nil, errors.WithMessage(ErrInvalidArgument, "session is for different tie-breakers")
+			}
+		}
+	}
+
+	now := time.Now()
+	if s.Now != 0 {
+		now = time.Unix(s.Now, 0)
+	}
+
+	searchService := getSearchService().Query(ScoredQuery(sh.NamedQuery(weights, names), scoring, now)).Size(size).
+		PointInTime(elastic.NewPointInTimeWithKeepAlive(s.PIT, keepAliveString)).
+		// We sort by sort specifications, by score, and then by tie-breakers and document ID,
+		// so that the order is total and search_after does not skip or duplicate results.
+		SortBy(Sorters(sorts, tieBreakers, sh.AsOf)...)
+	if len(sorts) > 0 {
+		// Scores are not computed by default when sorting by fields.
+		searchService = searchService.TrackScores(true)
+	}
+	if len(s.After) > 0 {
+		searchService = searchService.SearchAfter(s.After...)
+	}
+
+	res, err := searchService.Do(ctx)
+	if elastic.IsNotFound(err) {
+		// Point in time expired.
+		return nil, errors.WrapWith(err, ErrSessionExpired)
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	page := &Page{
+		Hits:         res.Hits.Hits,
+		Total:        res.Hits.TotalHits,
+		TimedOut:     res.TimedOut,
+		Took:         time.Duration(res.TookInMillis) * time.Millisecond,
+		Session:      "",
+		Aggregations: res.Aggregations,
+	}
+
+	if len(res.Hits.Hits) < size {
+		// There are no more results.
+		return page, nil
+	}
+
+	// ElasticSearch can return an updated point in time ID, which should be used for subsequent requests.
+	if res.PitId != "" {
+		s.PIT = res.PitId
+	}
+	s.After = res.Hits.Hits[len(res.Hits.Hits)-1].Sort
+
+	token, errE := s.token(secret)
+	if errE != nil {
+		return nil, errE
+	}
+	page.Session = token
+
+	return page, nil
+}
@@ -0,0 +1,125 @@
+package search_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+var testSecret = []byte("secret") //nolint:gochecknoglobals
+
+// signedSession returns data encoded as a session token signed with secret.
+func signedSession(data string, secret []byte) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(data))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestPaginateInvalid(t *testing.T) {
+	t.Parallel()
+
+	sh := &search.State{ID: identifier.New()} //nolint:exhaustruct
+	other := identifier.New()
+
+	getSearchService := func() *elastic.SearchService {
+		panic(errors.New("should not be called"))
+	}
+	openPointInTime := func() *elastic.OpenPointInTimeService {
+		panic(errors.New("should not be called"))
+	}
+
+	for _, tt := range []struct {
+		name    string
+		size    int
+		session string
+	}{
+		{"zero size", 0, ""},
+		{"too large size", search.MaxPageSize + 1, ""},
+		{"unsigned", 10, base64.RawURLEncoding.EncodeToString([]byte(`{"i":"index","s":"` + sh.ID.String() + `","pit":"x","after":[1]}`))},
+		{"invalid signature", 10, signedSession(`{"i":"index","s":"`+sh.ID.String()+`","pit":"x","after":[1]}`, []byte("other"))},
+		{"not base64", 10, "!!!." + base64.RawURLEncoding.EncodeToString([]byte("foo"))},
+		{"not JSON", 10, signedSession("foo", testSecret)},
+		{"unknown field", 10, signedSession(`{"i":"index","s":"`+sh.ID.String()+`","pit":"x","after":[1],"foo":1}`, testSecret)},
+		{"missing after", 10, signedSession(`{"i":"index","s":"`+sh.ID.String()+`","pit":"x","after":[]}`, testSecret)},
+		{"different index", 10, signedSession(`{"i":"other","s":"`+sh.ID.String()+`","pit":"x","after":[1]}`, testSecret)},
+		{"different state", 10, signedSession(`{"i":"index","s":"`+other.String()+`","pit":"x","after":[1]}`, testSecret)},
+		{"different sort", 10, signedSession(`{"i":"index","s":"`+sh.ID.String()+`","pit":"x","after":[1],"sort":[{"prop":"`+other.String()+`"}]}`, testSecret)},
+		{"different weights", 10, signedSession(`{"i":"index","s":"`+sh.ID.String()+`","pit":"x","after":[1],"weights":{"NAME":2}}`, testSecret)},
+		{"different tie-breakers", 10, signedSession(`{"i":"index","s":"`+sh.ID.String()+`","pit":"x","after":[1],"tieBreakers":[{"prop":"`+other.String()+`"}]}`, testSecret)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, errE := search.Paginate(
+				context.Background(), getSearchService, nil, openPointInTime,
+				sh, nil, nil, nil, nil, nil, tt.size,
+				"index", testSecret, tt.session, search.DefaultPaginationKeepAlive,
+			)
+			assert.ErrorIs(t, errE, search.ErrInvalidArgument)
+		})
+	}
+}
+
+func TestPointsInTime(t *testing.T) {
+	t.Parallel()
+
+	var opened atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.True(t, strings.HasSuffix(req.URL.Path, "/_pit"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"pit-%d"}`, opened.Add(1))
+	}))
+	t.Cleanup(ts.Close)
+
+	client, err := elastic.NewClient(elastic.SetURL(ts.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	require.NoError(t, err)
+	openPointInTime := func(index string) func() *elastic.OpenPointInTimeService {
+		return func() *elastic.OpenPointInTimeService {
+			return client.OpenPointInTime(index)
+		}
+	}
+
+	ctx := context.Background()
+	pits := &search.PointsInTime{Reuse: time.Hour} //nolint:exhaustruct
+
+	// Points in time are reused for the same index.
+	for range 3 {
+		pit, errE := pits.Open(ctx, "index", openPointInTime("index"), search.DefaultPaginationKeepAlive)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, "pit-1", pit)
+	}
+	pit, errE := pits.Open(ctx, "other", openPointInTime("other"), search.DefaultPaginationKeepAlive)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "pit-2", pit)
+
+	// Reuse is capped to half of the keep alive duration, so the point in time does not expire while being reused.
+	time.Sleep(time.Millisecond)
+	pit, errE = pits.Open(ctx, "index", openPointInTime("index"), time.Millisecond)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "pit-3", pit)
+
+	// Without sharing, a point in time is opened every time.
+	var noPits *search.PointsInTime
+	pit, errE = noPits.Open(ctx, "index", openPointInTime("index"), search.DefaultPaginationKeepAlive)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "pit-4", pit)
+	assert.Equal(t, int64(4), opened.Load())
+}
@@ -0,0 +1,243 @@
+package search
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	// maxRecentInteractions is the maximum number of recently viewed types and properties kept per user.
+	maxRecentInteractions = 20
+	// DefaultPersonalizationTTL is the default duration after which interaction history of an inactive user is forgotten.
+	DefaultPersonalizationTTL = 30 * 24 * time.Hour
+
+	personalizationTypeBoost     = 2.0
+	personalizationPropertyBoost = 0.5
+)
+
+// claimTypePaths are nested paths of claim types which have a property.
+//
+//nolint:gochecknoglobals
+var claimTypePaths = []string{
+	"claims.id",
+	"claims.ref",
+	"claims.text",
+	"claims.string",
+	"claims.amount",
+	"claims.amountRange",
+	"claims.rel",
+	"claims.file",
+	"claims.none",
+	"claims.unknown",
+	"claims.time",
+	"claims.timeRange",
+}
+
+// Profile is a personalization profile of a user.
+type Profile struct {
+	// Types of recently viewed documents, most recent first.
+	Types []identifier.Identifier `json:"types"`
+	// Properties of claims of recently viewed documents, most recent first.
+	Properties []identifier.Identifier `json:"properties"`
+}
+
+// Empty returns true if the profile has nothing to personalize with.
+func (p Profile) Empty() bool {
+	return len(p.Types) == 0 && len(p.Properties) == 0
+}
+
+type interactionHistory struct {
+	Profile
+
+	LastSeen time.Time
+}
+
+// Personalization tracks interaction history per user and provides profiles
+// used to boost search results.
+//
+// History is kept in memory only and only recently viewed types and properties
+// are kept, not which documents have been viewed. History of users inactive
+// for TTL is forgotten.
+type Personalization struct {
+	// TTL is the duration after which history of an inactive user is forgotten.
+	// Zero means DefaultPersonalizationTTL.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	history map[string]*interactionHistory
+}
+
+func (p *Personalization) ttl() time.Duration {
+	if p.TTL == 0 {
+		return DefaultPersonalizationTTL
+	}
+	return p.TTL
+}
+
+// addRecent moves ids to the front of recent, removing duplicates and limiting its length.
+func addRecent(recent []identifier.Identifier, ids []identifier.Identifier) []identifier.Identifier {
+	result := make([]identifier.Identifier, 0, len(recent)+len(ids))
+	for _, id := range ids {
+		if !slices.Contains(result, id) {
+			result = append(result, id)
+		}
+	}
+	for _, id := range recent {
+		if !slices.Contains(result, id) {
+			result = append(result, id)
+		}
+	}
+	if len(result) > maxRecentInteractions {
+		result = result[:maxRecentInteractions]
+	}
+	return result
+}
+
+// documentTypesAndProperties returns types of the document (targets of its TYPE claims)
+// and properties of its claims.
+func documentTypesAndProperties(doc *document.D) ([]identifier.Identifier, []identifier.Identifier) {
+	typeProp := document.GetCorePropertyID("TYPE")
+	types := []identifier.Identifier{}
+	props := []identifier.Identifier{}
+	for _, claim := range doc.AllClaims() {
+		var prop *identifier.Identifier
+		switch c := claim.(type) {
+		case *document.IdentifierClaim:
+			prop = c.Prop.ID
+		case *document.ReferenceClaim:
+			prop = c.Prop.ID
+		case *document.TextClaim:
+			prop = c.Prop.ID
+		case *document.StringClaim:
+			prop = c.Prop.ID
+		case *document.AmountClaim:
+			prop = c.Prop.ID
+		case *document.AmountRangeClaim:
+			prop = c.Prop.ID
+		case *document.RelationClaim:
+			prop = c.Prop.ID
+			if prop != nil && *prop == typeProp && c.To.ID != nil {
+				types = append(types, *c.To.ID)
+				// We do not count TYPE itself as a viewed property.
+				continue
+			}
+		case *document.FileClaim:
+			prop = c.Prop.ID
+		case *document.NoValueClaim:
+			prop = c.Prop.ID
+		case *document.UnknownValueClaim:
+			prop = c.Prop.ID
+		case *document.TimeClaim:
+			prop = c.Prop.ID
+		case *document.TimeRangeClaim:
+			prop = c.Prop.ID
+		}
+		if prop != nil {
+			props = append(props, *prop)
+		}
+	}
+	return types, props
+}
+
+// RecordView records that the user viewed the document.
+func (p *Personalization) RecordView(user string, doc *document.D) {
+	types, props := documentTypesAndProperties(doc)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.expire(now)
+
+	if p.history == nil {
+		p.history = map[string]*interactionHistory{}
+	}
+	history, ok := p.history[user]
+	if !ok {
+		history = &interactionHistory{} //nolint:exhaustruct
+		p.history[user] = history
+	}
+	history.Types = addRecent(history.Types, types)
+	history.Properties = addRecent(history.Properties, props)
+	history.LastSeen = now
+}
+
+// Forget removes interaction history of the user.
+func (p *Personalization) Forget(user string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.history, user)
+}
+
+// Profile returns the personalization profile of the user.
+func (p *Personalization) Profile(user string) Profile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(time.Now())
+
+	history, ok := p.history[user]
+	if !ok {
+		return Profile{Types: []identifier.Identifier{}, Properties: []identifier.Identifier{}}
+	}
+	return Profile{
+		Types:      slices.Clone(history.Types),
+		Properties: slices.Clone(history.Properties),
+	}
+}
+
+// expire removes history of inactive users. It must be called with the mutex held.
+func (p *Personalization) expire(now time.Time) {
+	ttl := p.ttl()
+	for user, history := range p.history {
+		if now.Sub(history.LastSeen) > ttl {
+			delete(p.history, user)
+		}
+	}
+}
+
+// PersonalizedQuery wraps the query so that documents of types and with properties
+// from the profile are rescored higher. The query matches the same documents as the
+// wrapped query.
+func PersonalizedQuery(query elastic.Query, profile Profile) elastic.Query { //nolint:ireturn
+	if profile.Empty() {
+		return query
+	}
+
+	boolQuery := elastic.NewBoolQuery().Must(query)
+	if len(profile.Types) > 0 {
+		types := make([]interface{}, len(profile.Types))
+		for i, t := range profile.Types {
+			types[i] = t.String()
+		}
+		boolQuery.Should(
+			elastic.NewNestedQuery("claims.rel",
+				elastic.NewBoolQuery().Must(
+					elastic.NewTermQuery("claims.rel.prop.id", document.GetCorePropertyID("TYPE")),
+					elastic.NewTermsQuery("claims.rel.to.id", types...),
+				),
+			).ScoreMode("max").Boost(personalizationTypeBoost),
+		)
+	}
+	if len(profile.Properties) > 0 {
+		props := make([]interface{}, len(profile.Properties))
+		for i, p := range profile.Properties {
+			props[i] = p.String()
+		}
+		for _, path := range claimTypePaths {
+			boolQuery.Should(
+				elastic.NewNestedQuery(path,
+					elastic.NewTermsQuery(path+".prop.id", props...),
+				).ScoreMode("max").Boost(personalizationPropertyBoost),
+			)
+		}
+	}
+	return boolQuery
+}
@@ -0,0 +1,107 @@
+package search_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func testDocument(t *testing.T, typ, prop identifier.Identifier) *document.D {
+	t.Helper()
+
+	doc := &document.D{
+		CoreDocument: document.CoreDocument{
+			ID:    identifier.New(),
+			Score: document.HighConfidence,
+		},
+	}
+	errE := doc.Add(&document.RelationClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop: document.GetCorePropertyReference("TYPE"),
+		To:   document.Reference{ID: &typ},
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = doc.Add(&document.StringClaim{
+		CoreClaim: document.CoreClaim{
+			ID:         identifier.New(),
+			Confidence: document.HighConfidence,
+		},
+		Prop:   document.Reference{ID: &prop},
+		String: "foobar",
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	return doc
+}
+
+func TestPersonalization(t *testing.T) {
+	t.Parallel()
+
+	p := &search.Personalization{} //nolint:exhaustruct
+
+	assert.True(t, p.Profile("user").Empty())
+
+	type1 := identifier.New()
+	type2 := identifier.New()
+	prop := identifier.New()
+
+	p.RecordView("user", testDocument(t, type1, prop))
+	p.RecordView("user", testDocument(t, type2, prop))
+
+	assert.Equal(t, search.Profile{
+		Types:      []identifier.Identifier{type2, type1},
+		Properties: []identifier.Identifier{prop},
+	}, p.Profile("user"))
+	assert.True(t, p.Profile("other").Empty())
+
+	p.Forget("user")
+	assert.True(t, p.Profile("user").Empty())
+
+	p = &search.Personalization{TTL: time.Nanosecond} //nolint:exhaustruct
+	p.RecordView("user", testDocument(t, type1, prop))
+	time.Sleep(time.Millisecond)
+	assert.True(t, p.Profile("user").Empty())
+}
+
+func TestPersonalizedQuery(t *testing.T) {
+	t.Parallel()
+
+	query := elastic.NewMatchAllQuery()
+	assert.Equal(t, query, search.PersonalizedQuery(query, search.Profile{})) //nolint:exhaustruct
+
+	typ := identifier.New()
+	source, err := search.PersonalizedQuery(query, search.Profile{
+		Types:      []identifier.Identifier{typ},
+		Properties: nil,
+	}).Source()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": map[string]interface{}{"match_all": map[string]interface{}{}},
+			"should": map[string]interface{}{
+				"nested": map[string]interface{}{
+					"boost": 2.0,
+					"path":  "claims.rel",
+					"query": map[string]interface{}{
+						"bool": map[string]interface{}{
+							"must": []interface{}{
+								map[string]interface{}{"term": map[string]interface{}{"claims.rel.prop.id": document.GetCorePropertyID("TYPE")}},
+								map[string]interface{}{"terms": map[string]interface{}{"claims.rel.to.id": []interface{}{typ.String()}}},
+							},
+						},
+					},
+					"score_mode": "max",
+				},
+			},
+		},
+	}, source)
+}
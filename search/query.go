@@ -0,0 +1,259 @@
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// Codes of query warnings.
+const (
+	QueryWarningUnbalancedQuote       = "unbalancedQuote"
+	QueryWarningUnbalancedParenthesis = "unbalancedParenthesis"
+	QueryWarningDanglingOperator      = "danglingOperator"
+)
+
+// ErrMalformedQuery is returned for malformed search queries in strict mode.
+var ErrMalformedQuery = errors.BaseWrap(ErrInvalidArgument, "malformed query")
+
+// QueryWarning describes a malformed part of the search query.
+//
+// Position is the 0-based offset (in characters) of the malformed part in the query.
+type QueryWarning struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Position int    `json:"pos"`
+}
+
+type queryTokenKind int
+
+const (
+	queryTokenSpace queryTokenKind = iota
+	queryTokenTerm
+	queryTokenPhrase
+	queryTokenOpen
+	queryTokenClose
+	queryTokenAnd
+	queryTokenOr
+	queryTokenNot
+)
+
+type queryToken struct {
+	Kind     queryTokenKind
+	Text     string
+	Position int
+	Removed  bool
+}
+
+// isOperand returns true if the token can be an operand of an operator.
+func (t queryToken) isOperand() bool {
+	return t.Kind == queryTokenTerm || t.Kind == queryTokenPhrase || t.Kind == queryTokenOpen || t.Kind == queryTokenNot
+}
+
+// isOperandEnd returns true if the token can end an operand of an operator.
+func (t queryToken) isOperandEnd() bool {
+	return t.Kind == queryTokenTerm || t.Kind == queryTokenPhrase || t.Kind == queryTokenClose
+}
+
+func isQuerySpecial(r rune) bool {
+	switch r {
+	case '"', '(', ')', '|', '+':
+		return true
+	}
+	return isQuerySpace(r)
+}
+
+func isQuerySpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+func tokenizeQuery(query []rune) ([]queryToken, []QueryWarning) {
+	tokens := []queryToken{}
+	warnings := []QueryWarning{}
+
+	for i := 0; i < len(query); {
+		start := i
+		r := query[i]
+		switch {
+		case isQuerySpace(r):
+			for i < len(query) && isQuerySpace(query[i]) {
+				i++
+			}
+			tokens = append(tokens, queryToken{Kind: queryTokenSpace, Text: string(query[start:i]), Position: start, Removed: false})
+		case r == '"':
+			i++
+			for i < len(query) && query[i] != '"' {
+				if query[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			text := string(query[start:min(i, len(query))])
+			if i >= len(query) {
+				warnings = append(warnings, QueryWarning{
+					Code:     QueryWarningUnbalancedQuote,
+					Message:  "quote is not closed",
+					Position: start,
+				})
+				// We close the phrase.
+				text += `"`
+			} else {
+				i++
+				text += `"`
+			}
+			// Fuzziness or slop.
+			for i < len(query) && !isQuerySpecial(query[i]) {
+				text += string(query[i])
+				i++
+			}
+			tokens = append(tokens, queryToken{Kind: queryTokenPhrase, Text: text, Position: start, Removed: false})
+		case r == '(':
+			i++
+			tokens = append(tokens, queryToken{Kind: queryTokenOpen, Text: "(", Position: start, Removed: false})
+		case r == ')':
+			i++
+			tokens = append(tokens, queryToken{Kind: queryTokenClose, Text: ")", Position: start, Removed: false})
+		case r == '+':
+			i++
+			tokens = append(tokens, queryToken{Kind: queryTokenAnd, Text: "+", Position: start, Removed: false})
+		case r == '|':
+			i++
+			tokens = append(tokens, queryToken{Kind: queryTokenOr, Text: "|", Position: start, Removed: false})
+		case r == '-':
+			i++
+			tokens = append(tokens, queryToken{Kind: queryTokenNot, Text: "-", Position: start, Removed: false})
+		default:
+			for i < len(query) && !isQuerySpecial(query[i]) {
+				if query[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			tokens = append(tokens, queryToken{Kind: queryTokenTerm, Text: string(query[start:min(i, len(query))]), Position: start, Removed: false})
+		}
+	}
+
+	return tokens, warnings
+}
+
+// balanceParentheses removes closing parentheses without matching opening parenthesis
+// and returns the number of opening parentheses which are not closed.
+func balanceParentheses(tokens []queryToken) (int, []QueryWarning) {
+	warnings := []QueryWarning{}
+	open := []int{}
+	for i := range tokens {
+		switch tokens[i].Kind { //nolint:exhaustive
+		case queryTokenOpen:
+			open = append(open, i)
+		case queryTokenClose:
+			if len(open) == 0 {
+				tokens[i].Removed = true
+				warnings = append(warnings, QueryWarning{
+					Code:     QueryWarningUnbalancedParenthesis,
+					Message:  "closing parenthesis without opening parenthesis",
+					Position: tokens[i].Position,
+				})
+			} else {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	for _, i := range open {
+		warnings = append(warnings, QueryWarning{
+			Code:     QueryWarningUnbalancedParenthesis,
+			Message:  "parenthesis is not closed",
+			Position: tokens[i].Position,
+		})
+	}
+	return len(open), warnings
+}
+
+// removeDanglingOperators removes operators which are missing an operand.
+func removeDanglingOperators(tokens []queryToken) []QueryWarning {
+	warnings := []QueryWarning{}
+	dangling := func(t *queryToken) {
+		t.Removed = true
+		warnings = append(warnings, QueryWarning{
+			Code:     QueryWarningDanglingOperator,
+			Message:  `operator "` + t.Text + `" is missing an operand`,
+			Position: t.Position,
+		})
+	}
+
+	// All operators have to be followed by an operand.
+	var next *queryToken
+	for i := len(tokens) - 1; i >= 0; i-- {
+		t := &tokens[i]
+		if t.Removed || t.Kind == queryTokenSpace {
+			continue
+		}
+		switch t.Kind { //nolint:exhaustive
+		case queryTokenAnd, queryTokenOr, queryTokenNot:
+			if next == nil || !next.isOperand() || (t.Kind == queryTokenNot && tokens[i+1].Kind == queryTokenSpace) {
+				dangling(t)
+				continue
+			}
+		}
+		next = t
+	}
+
+	// OR operator has to be preceded by an operand as well.
+	var previous *queryToken
+	for i := range tokens {
+		t := &tokens[i]
+		if t.Removed || t.Kind == queryTokenSpace {
+			continue
+		}
+		if t.Kind == queryTokenOr && (previous == nil || !previous.isOperandEnd()) {
+			dangling(t)
+			continue
+		}
+		previous = t
+	}
+
+	return warnings
+}
+
+// ParseQuery parses the search query in simple query string syntax and returns it
+// with malformed parts fixed, together with warnings describing them.
+//
+// Unclosed quotes and parentheses are closed, while closing parentheses without
+// opening parenthesis and operators missing an operand are removed.
+// If there are no warnings, the query is returned unchanged.
+func ParseQuery(query string) (string, []QueryWarning) {
+	runes := []rune(query)
+	tokens, warnings := tokenizeQuery(runes)
+	unclosed, w := balanceParentheses(tokens)
+	warnings = append(warnings, w...)
+	warnings = append(warnings, removeDanglingOperators(tokens)...)
+
+	if len(warnings) == 0 {
+		return query, nil
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Position < warnings[j].Position
+	})
+
+	var b strings.Builder
+	for _, t := range tokens {
+		if !t.Removed {
+			b.WriteString(t.Text)
+		}
+	}
+	b.WriteString(strings.Repeat(")", unclosed))
+	return strings.TrimSpace(b.String()), warnings
+}
+
+// ValidateQuery returns ErrMalformedQuery error with warnings in its details if
+// the search query is malformed.
+func ValidateQuery(query string) errors.E {
+	_, warnings := ParseQuery(query)
+	if len(warnings) == 0 {
+		return nil
+	}
+	errE := errors.WithStack(ErrMalformedQuery)
+	errors.Details(errE)["warnings"] = warnings
+	return errE
+}
@@ -0,0 +1,63 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestParseQuery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		query    string
+		expected string
+		codes    []string
+		pos      []int
+	}{
+		{`foo bar`, `foo bar`, nil, nil},
+		{`"foo bar"~2 | (baz -qux*)`, `"foo bar"~2 | (baz -qux*)`, nil, nil},
+		{`+foo e-mail \"x`, `+foo e-mail \"x`, nil, nil},
+		{`"foo bar`, `"foo bar"`, []string{search.QueryWarningUnbalancedQuote}, []int{0}},
+		{`foo (bar`, `foo (bar)`, []string{search.QueryWarningUnbalancedParenthesis}, []int{4}},
+		{`foo) bar`, `foo bar`, []string{search.QueryWarningUnbalancedParenthesis}, []int{3}},
+		{`foo |`, `foo`, []string{search.QueryWarningDanglingOperator}, []int{4}},
+		{`| foo`, `foo`, []string{search.QueryWarningDanglingOperator}, []int{0}},
+		{`foo - bar`, `foo  bar`, []string{search.QueryWarningDanglingOperator}, []int{4}},
+		{`foo | -`, `foo`, []string{search.QueryWarningDanglingOperator, search.QueryWarningDanglingOperator}, []int{4, 6}},
+		{`(foo +)`, `(foo )`, []string{search.QueryWarningDanglingOperator}, []int{5}},
+		{`ü "bär`, `ü "bär"`, []string{search.QueryWarningUnbalancedQuote}, []int{2}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			t.Parallel()
+
+			query, warnings := search.ParseQuery(test.query)
+			assert.Equal(t, test.expected, query)
+			codes := []string(nil)
+			pos := []int(nil)
+			for _, w := range warnings {
+				codes = append(codes, w.Code)
+				pos = append(pos, w.Position)
+			}
+			assert.Equal(t, test.codes, codes)
+			assert.Equal(t, test.pos, pos)
+		})
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	t.Parallel()
+
+	errE := search.ValidateQuery(`foo bar`)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = search.ValidateQuery(`foo "bar`)
+	assert.ErrorIs(t, errE, search.ErrMalformedQuery)
+	assert.ErrorIs(t, errE, search.ErrInvalidArgument)
+	assert.Len(t, errors.Details(errE)["warnings"], 1)
+}
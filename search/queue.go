@@ -0,0 +1,108 @@
+package search
+
+import (
+	"context"
+	"sync/atomic"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+const (
+	// DefaultLLMConcurrency is the default number of prompts parsed concurrently.
+	DefaultLLMConcurrency = 4
+	// DefaultExportConcurrency is the default number of concurrent exports.
+	DefaultExportConcurrency = 2
+	// DefaultFiltersConcurrency is the default number of concurrent filter (facet) requests.
+	DefaultFiltersConcurrency = 16
+	// DefaultQueueLength is the default number of operations which can wait for their turn.
+	DefaultQueueLength = 32
+)
+
+// ErrQueueFull is returned when too many operations are already waiting in a work queue.
+var ErrQueueFull = errors.Base("queue full")
+
+// WorkQueue limits the number of concurrently running expensive operations
+// (e.g., parsing prompts with a LLM) to protect ElasticSearch and LLM providers from overload.
+// A bounded number of further operations can wait for their turn, others are rejected.
+//
+// Nil *WorkQueue does not limit anything.
+type WorkQueue struct {
+	slots      chan struct{}
+	maxWaiting int64
+	waiting    atomic.Int64
+}
+
+// NewWorkQueue returns a new WorkQueue which runs at most concurrency operations at once and
+// lets at most maxWaiting operations wait for their turn. It returns nil if concurrency is not positive.
+func NewWorkQueue(concurrency, maxWaiting int) *WorkQueue {
+	if concurrency <= 0 {
+		return nil
+	}
+	return &WorkQueue{
+		slots:      make(chan struct{}, concurrency),
+		maxWaiting: int64(max(maxWaiting, 0)),
+		waiting:    atomic.Int64{},
+	}
+}
+
+// Acquire waits for the operation's turn and returns a function which must be called
+// once the operation finishes.
+//
+// It returns ErrQueueFull if too many operations are already waiting,
+// or context's error if the context is canceled while waiting.
+func (q *WorkQueue) Acquire(ctx context.Context) (func(), errors.E) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	// Fast path when there is a free slot.
+	select {
+	case q.slots <- struct{}{}:
+		return q.release, nil
+	default:
+	}
+
+	if q.waiting.Add(1) > q.maxWaiting {
+		q.waiting.Add(-1)
+		errE := errors.WithStack(ErrQueueFull)
+		errors.Details(errE)["running"] = q.Running()
+		errors.Details(errE)["waiting"] = q.maxWaiting
+		return nil, errE
+	}
+	defer q.waiting.Add(-1)
+
+	select {
+	case q.slots <- struct{}{}:
+		return q.release, nil
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+func (q *WorkQueue) release() {
+	<-q.slots
+}
+
+// Full returns true if an operation would be rejected if it were started now.
+func (q *WorkQueue) Full() bool {
+	if q == nil {
+		return false
+	}
+	return q.Running() == cap(q.slots) && q.Waiting() >= q.maxWaiting
+}
+
+// Running returns the number of currently running operations.
+func (q *WorkQueue) Running() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.slots)
+}
+
+// Waiting returns the number of operations currently waiting for their turn.
+func (q *WorkQueue) Waiting() int64 {
+	if q == nil {
+		return 0
+	}
+	return q.waiting.Load()
+}
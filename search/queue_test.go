@@ -0,0 +1,64 @@
+package search_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestWorkQueue(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, search.NewWorkQueue(0, 10))
+
+	var unlimited *search.WorkQueue
+	release, errE := unlimited.Acquire(context.Background())
+	require.NoError(t, errE, "% -+#.1v", errE)
+	release()
+	assert.False(t, unlimited.Full())
+
+	q := search.NewWorkQueue(1, 1)
+
+	release, errE = q.Acquire(context.Background())
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, 1, q.Running())
+	assert.False(t, q.Full())
+
+	acquired := make(chan struct{})
+	go func() {
+		r, errE := q.Acquire(context.Background())
+		assert.NoError(t, errE, "% -+#.1v", errE)
+		close(acquired)
+		r()
+	}()
+
+	require.Eventually(t, func() bool {
+		return q.Waiting() == 1
+	}, time.Second, time.Millisecond)
+	assert.True(t, q.Full())
+
+	_, errE = q.Acquire(context.Background())
+	assert.ErrorIs(t, errE, search.ErrQueueFull)
+
+	release()
+	<-acquired
+
+	require.Eventually(t, func() bool {
+		return q.Running() == 0 && q.Waiting() == 0
+	}, time.Second, time.Millisecond)
+
+	release, errE = q.Acquire(context.Background())
+	require.NoError(t, errE, "% -+#.1v", errE)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, errE = q.Acquire(ctx)
+	assert.ErrorIs(t, errE, context.Canceled)
+	assert.Equal(t, int64(0), q.Waiting())
+}
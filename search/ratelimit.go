@@ -0,0 +1,82 @@
+package search
+
+import (
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"golang.org/x/time/rate"
+)
+
+// DefaultAnnotationsPerHour is the default number of annotations a client can create per hour.
+const DefaultAnnotationsPerHour = 30
+
+// maxIdleLimiters is the number of per-client limiters after which
+// limiters of clients which have not made requests recently are removed.
+const maxIdleLimiters = 10000
+
+// ErrRateLimited is returned when a client made too many requests.
+var ErrRateLimited = errors.Base("rate limited")
+
+// RateLimiter limits the rate of operations (e.g., creating annotations) per client
+// (e.g., per API key or IP address). Each client can make at most perHour operations
+// per hour, possibly all at once.
+//
+// Nil *RateLimiter does not limit anything.
+type RateLimiter struct {
+	perHour  int
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewRateLimiter returns a new RateLimiter which lets each client make at most perHour operations
+// per hour. It returns nil if perHour is not positive.
+func NewRateLimiter(perHour int) *RateLimiter {
+	if perHour <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		perHour:  perHour,
+		mu:       sync.Mutex{},
+		limiters: map[string]*rate.Limiter{},
+	}
+}
+
+// Allow returns ErrRateLimited if the client has already made too many operations,
+// otherwise it records the operation.
+func (l *RateLimiter) Allow(key string) errors.E {
+	if l == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[key]
+	if !ok {
+		if len(l.limiters) >= maxIdleLimiters {
+			l.removeIdle(now)
+		}
+		limiter = rate.NewLimiter(rate.Every(time.Hour/time.Duration(l.perHour)), l.perHour)
+		l.limiters[key] = limiter
+	}
+
+	if !limiter.AllowN(now, 1) {
+		errE := errors.WithStack(ErrRateLimited)
+		errors.Details(errE)["perHour"] = l.perHour
+		return errE
+	}
+	return nil
+}
+
+// removeIdle removes limiters of clients which could make all operations again,
+// so they behave the same as new limiters.
+func (l *RateLimiter) removeIdle(now time.Time) {
+	for key, limiter := range l.limiters {
+		if limiter.TokensAt(now) >= float64(l.perHour) {
+			delete(l.limiters, key)
+		}
+	}
+}
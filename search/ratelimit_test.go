@@ -0,0 +1,34 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, search.NewRateLimiter(0))
+
+	var unlimited *search.RateLimiter
+	for range 100 {
+		errE := unlimited.Allow("a")
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+
+	l := search.NewRateLimiter(3)
+	for range 3 {
+		errE := l.Allow("a")
+		require.NoError(t, errE, "% -+#.1v", errE)
+	}
+	errE := l.Allow("a")
+	assert.ErrorIs(t, errE, search.ErrRateLimited)
+
+	// Clients are limited separately.
+	errE = l.Allow("b")
+	assert.NoError(t, errE, "% -+#.1v", errE)
+}
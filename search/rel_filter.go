@@ -25,18 +25,19 @@ type searchRelFilterResult struct {
 
 //nolint:dupl
 func RelFilterGet(
-	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id, prop identifier.Identifier,
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id, prop identifier.Identifier, facetSize int,
 ) (interface{}, map[string]interface{}, errors.E) {
 	metrics := waf.MustGetMetrics(ctx)
 
-	m := metrics.Duration(internal.MetricSearchState).Start()
-	ss, ok := searches.Load(id)
-	m.Stop()
-	if !ok {
-		// Something was not OK, so we return not found.
-		return nil, nil, errors.WithStack(ErrNotFound)
+	errE := CheckRestricted(ctx, prop)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	sh, errE := loadState(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
 	}
-	sh := ss.(*State) //nolint:errcheck,forcetypeassert
 
 	query := sh.Query()
 
@@ -47,7 +48,7 @@ func RelFilterGet(
 			elastic.NewTermQuery("claims.rel.prop.id", prop),
 		).SubAggregation(
 			"props",
-			elastic.NewTermsAggregation().Field("claims.rel.to.id").Size(MaxResultsCount).OrderByAggregation("docs", false).SubAggregation(
+			elastic.NewTermsAggregation().Field("claims.rel.to.id").Size(facetSize).OrderByAggregation("docs", false).SubAggregation(
 				"docs",
 				elastic.NewReverseNestedAggregation(),
 			),
@@ -60,7 +61,7 @@ func RelFilterGet(
 	)
 	searchService = searchService.Size(0).Query(query).Aggregation("rel", aggregation)
 
-	m = metrics.Duration(internal.MetricElasticSearch).Start()
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
 	res, err := searchService.Do(ctx)
 	m.Stop()
 	if err != nil {
@@ -70,7 +71,7 @@ func RelFilterGet(
 
 	m = metrics.Duration(internal.MetricJSONUnmarshal).Start()
 	var rel filteredTermAggregations
-	errE := x.Unmarshal(res.Aggregations["rel"], &rel)
+	errE = x.Unmarshal(res.Aggregations["rel"], &rel)
 	m.Stop()
 	if errE != nil {
 		return nil, nil, errE
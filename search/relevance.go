@@ -0,0 +1,257 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRelevanceDepth is the default number of top search results evaluated per query.
+const DefaultRelevanceDepth = 10
+
+// RelevanceQuery is a search query together with IDs of documents expected among its top results.
+type RelevanceQuery struct {
+	// Name is an optional name of the query used in reports. Default is the search query.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Query is the search query.
+	Query string `json:"q" yaml:"q"`
+	// Expected are IDs of relevant documents, the most relevant first.
+	Expected []identifier.Identifier `json:"expected" yaml:"expected"`
+}
+
+// RelevanceCorpus is a corpus of queries used to evaluate relevance of search results.
+type RelevanceCorpus struct {
+	Queries []RelevanceQuery `yaml:"queries"`
+}
+
+// Validate validates the corpus.
+func (c *RelevanceCorpus) Validate() errors.E {
+	if len(c.Queries) == 0 {
+		return errors.New("corpus has no queries")
+	}
+	names := map[string]bool{}
+	for i, query := range c.Queries {
+		name := query.name()
+		if name == "" {
+			errE := errors.New("query is empty")
+			errors.Details(errE)["index"] = i
+			return errE
+		}
+		if names[name] {
+			errE := errors.New("duplicate query")
+			errors.Details(errE)["name"] = name
+			return errE
+		}
+		names[name] = true
+		if len(query.Expected) == 0 {
+			errE := errors.New("query has no expected documents")
+			errors.Details(errE)["name"] = name
+			return errE
+		}
+	}
+	return nil
+}
+
+func (q RelevanceQuery) name() string {
+	if q.Name != "" {
+		return q.Name
+	}
+	return q.Query
+}
+
+// LoadRelevanceCorpus loads and validates the corpus from a YAML file.
+func LoadRelevanceCorpus(path string) (*RelevanceCorpus, errors.E) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	var corpus RelevanceCorpus
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&corpus)
+	if err != nil && !errors.Is(err, io.EOF) {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	errE := corpus.Validate()
+	if errE != nil {
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	return &corpus, nil
+}
+
+// RelevanceQueryResult is the evaluation of top search results of one query.
+type RelevanceQueryResult struct {
+	Name     string                  `json:"name"`
+	Expected []identifier.Identifier `json:"expected"`
+	// Results are IDs of top search results.
+	Results []string `json:"results"`
+	// Precision is the fraction of top search results which are expected.
+	Precision float64 `json:"precision"`
+	// Recall is the fraction of expected documents which are among top search results.
+	Recall float64 `json:"recall"`
+	// ReciprocalRank is 1/rank of the first expected document among top search results, or 0.
+	ReciprocalRank float64 `json:"reciprocalRank"`
+}
+
+// RelevanceReport is the evaluation of a corpus against one index.
+type RelevanceReport struct {
+	Index string `json:"index"`
+	Depth int    `json:"depth"`
+	// Precision, Recall, and MeanReciprocalRank are means over all queries.
+	Precision          float64                `json:"precision"`
+	Recall             float64                `json:"recall"`
+	MeanReciprocalRank float64                `json:"meanReciprocalRank"`
+	Queries            []RelevanceQueryResult `json:"queries"`
+}
+
+// evaluateRelevance evaluates top search results of the query.
+func evaluateRelevance(query RelevanceQuery, results []string) RelevanceQueryResult {
+	result := RelevanceQueryResult{
+		Name:           query.name(),
+		Expected:       query.Expected,
+		Results:        results,
+		Precision:      0,
+		Recall:         0,
+		ReciprocalRank: 0,
+	}
+	hits := 0
+	for i, id := range results {
+		if slices.ContainsFunc(query.Expected, func(e identifier.Identifier) bool { return e.String() == id }) {
+			hits++
+			if result.ReciprocalRank == 0 {
+				result.ReciprocalRank = 1 / float64(i+1)
+			}
+		}
+	}
+	if len(results) > 0 {
+		result.Precision = float64(hits) / float64(len(results))
+	}
+	result.Recall = float64(hits) / float64(len(query.Expected))
+	return result
+}
+
+// RunRelevance runs all queries of the corpus against the index and evaluates their
+// top depth search results. Queries are made in the same way as by the search API,
+// with field weights, name properties, and scoring functions applied.
+func RunRelevance(
+	ctx context.Context, esClient *elastic.Client, index string, corpus *RelevanceCorpus,
+	weights FieldWeights, names NameProperties, scoring []ScoringFunction, depth int,
+) (*RelevanceReport, errors.E) {
+	if depth <= 0 || depth > MaxResultsCount {
+		errE := errors.WithMessage(ErrInvalidArgument, "depth out of range")
+		errors.Details(errE)["depth"] = depth
+		errors.Details(errE)["max"] = MaxResultsCount
+		return nil, errE
+	}
+
+	report := &RelevanceReport{
+		Index:              index,
+		Depth:              depth,
+		Precision:          0,
+		Recall:             0,
+		MeanReciprocalRank: 0,
+		Queries:            make([]RelevanceQueryResult, 0, len(corpus.Queries)),
+	}
+	for _, query := range corpus.Queries {
+		state := &State{SearchQuery: query.Query} //nolint:exhaustruct
+		res, err := esClient.Search(index).FetchSource(false).TrackTotalHits(false).From(0).Size(depth).
+			Query(ScoredQuery(state.WeightedQuery(weights, names), scoring, time.Now())).SortBy(Sorters(nil, nil, nil)...).Do(ctx)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["query"] = query.name()
+			return nil, errE
+		}
+		results := make([]string, 0, len(res.Hits.Hits))
+		for _, hit := range res.Hits.Hits {
+			results = append(results, hit.Id)
+		}
+		result := evaluateRelevance(query, results)
+		report.Queries = append(report.Queries, result)
+		report.Precision += result.Precision
+		report.Recall += result.Recall
+		report.MeanReciprocalRank += result.ReciprocalRank
+	}
+	n := float64(len(report.Queries))
+	if n > 0 {
+		report.Precision /= n
+		report.Recall /= n
+		report.MeanReciprocalRank /= n
+	}
+	return report, nil
+}
+
+// RelevanceQueryDelta is the change of evaluation of one query between two reports.
+type RelevanceQueryDelta struct {
+	Name           string  `json:"name"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	ReciprocalRank float64 `json:"reciprocalRank"`
+}
+
+// RelevanceDelta is the change of evaluation between two reports (new minus old).
+type RelevanceDelta struct {
+	Precision          float64 `json:"precision"`
+	Recall             float64 `json:"recall"`
+	MeanReciprocalRank float64 `json:"meanReciprocalRank"`
+	// Queries are only queries in both reports whose evaluation changed.
+	Queries []RelevanceQueryDelta `json:"queries"`
+	// Missing are names of queries only in the old report.
+	Missing []string `json:"missing,omitempty"`
+}
+
+// Regressed returns true if precision or recall decreased by more than tolerance,
+// overall or for any query.
+func (d *RelevanceDelta) Regressed(tolerance float64) bool {
+	if d.Precision < -tolerance || d.Recall < -tolerance {
+		return true
+	}
+	for _, query := range d.Queries {
+		if query.Precision < -tolerance || query.Recall < -tolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareRelevance returns changes of evaluation from the old report to the new report.
+// Reports should be made with the same depth.
+func CompareRelevance(oldReport, newReport *RelevanceReport) *RelevanceDelta {
+	delta := &RelevanceDelta{
+		Precision:          newReport.Precision - oldReport.Precision,
+		Recall:             newReport.Recall - oldReport.Recall,
+		MeanReciprocalRank: newReport.MeanReciprocalRank - oldReport.MeanReciprocalRank,
+		Queries:            []RelevanceQueryDelta{},
+		Missing:            nil,
+	}
+	for _, o := range oldReport.Queries {
+		i := slices.IndexFunc(newReport.Queries, func(n RelevanceQueryResult) bool { return n.Name == o.Name })
+		if i < 0 {
+			delta.Missing = append(delta.Missing, o.Name)
+			continue
+		}
+		n := newReport.Queries[i]
+		d := RelevanceQueryDelta{
+			Name:           o.Name,
+			Precision:      n.Precision - o.Precision,
+			Recall:         n.Recall - o.Recall,
+			ReciprocalRank: n.ReciprocalRank - o.ReciprocalRank,
+		}
+		if d.Precision != 0 || d.Recall != 0 || d.ReciprocalRank != 0 {
+			delta.Queries = append(delta.Queries, d)
+		}
+	}
+	return delta
+}
@@ -0,0 +1,103 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+)
+
+func TestEvaluateRelevance(t *testing.T) {
+	t.Parallel()
+
+	a, b, c := identifier.New(), identifier.New(), identifier.New()
+	query := RelevanceQuery{Name: "", Query: "foo", Expected: []identifier.Identifier{a, b}}
+
+	result := evaluateRelevance(query, []string{c.String(), a.String(), identifier.New().String(), identifier.New().String()})
+	assert.Equal(t, "foo", result.Name)
+	assert.InDelta(t, 0.25, result.Precision, 0.0001)
+	assert.InDelta(t, 0.5, result.Recall, 0.0001)
+	assert.InDelta(t, 0.5, result.ReciprocalRank, 0.0001)
+
+	result = evaluateRelevance(query, []string{})
+	assert.Zero(t, result.Precision)
+	assert.Zero(t, result.Recall)
+	assert.Zero(t, result.ReciprocalRank)
+}
+
+func TestCompareRelevance(t *testing.T) {
+	t.Parallel()
+
+	oldReport := &RelevanceReport{
+		Index: "a", Depth: 10, Precision: 0.5, Recall: 0.75, MeanReciprocalRank: 0.75,
+		Queries: []RelevanceQueryResult{
+			{Name: "foo", Precision: 0.5, Recall: 1, ReciprocalRank: 1},     //nolint:exhaustruct
+			{Name: "bar", Precision: 0.5, Recall: 0.5, ReciprocalRank: 0.5}, //nolint:exhaustruct
+			{Name: "baz", Precision: 0, Recall: 0, ReciprocalRank: 0},       //nolint:exhaustruct
+		},
+	}
+	newReport := &RelevanceReport{
+		Index: "b", Depth: 10, Precision: 0.45, Recall: 0.75, MeanReciprocalRank: 0.5,
+		Queries: []RelevanceQueryResult{
+			{Name: "bar", Precision: 0.5, Recall: 0.5, ReciprocalRank: 0.5}, //nolint:exhaustruct
+			{Name: "foo", Precision: 0.4, Recall: 1, ReciprocalRank: 0.5},   //nolint:exhaustruct
+		},
+	}
+
+	delta := CompareRelevance(oldReport, newReport)
+	assert.InDelta(t, -0.05, delta.Precision, 0.0001)
+	assert.InDelta(t, 0, delta.Recall, 0.0001)
+	assert.InDelta(t, -0.25, delta.MeanReciprocalRank, 0.0001)
+	require.Len(t, delta.Queries, 1)
+	assert.Equal(t, "foo", delta.Queries[0].Name)
+	assert.InDelta(t, -0.1, delta.Queries[0].Precision, 0.0001)
+	assert.Equal(t, []string{"baz"}, delta.Missing)
+
+	assert.True(t, delta.Regressed(0))
+	assert.True(t, delta.Regressed(0.05))
+	assert.False(t, delta.Regressed(0.1))
+
+	// A/A comparison.
+	delta = CompareRelevance(oldReport, oldReport)
+	assert.Empty(t, delta.Queries)
+	assert.False(t, delta.Regressed(0))
+}
+
+func TestLoadRelevanceCorpus(t *testing.T) {
+	t.Parallel()
+
+	id := identifier.New()
+
+	tests := []struct {
+		name   string
+		corpus string
+		err    string
+	}{
+		{"valid", "queries:\n  - q: mona lisa\n    expected: [" + id.String() + "]\n  - name: other\n    q: mona lisa\n    expected: [" + id.String() + "]\n", ""},
+		{"empty", "", "corpus has no queries"},
+		{"no expected", "queries:\n  - q: mona lisa\n", "query has no expected documents"},
+		{"duplicate", "queries:\n  - q: foo\n    expected: [" + id.String() + "]\n  - q: foo\n    expected: [" + id.String() + "]\n", "duplicate query"},
+		{"invalid ID", "queries:\n  - q: foo\n    expected: [bar]\n", "invalid identifier"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "corpus.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(test.corpus), 0o600))
+
+			corpus, errE := LoadRelevanceCorpus(path)
+			if test.err != "" {
+				assert.ErrorContains(t, errE, test.err)
+				return
+			}
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Len(t, corpus.Queries, 2)
+			assert.Equal(t, []identifier.Identifier{id}, corpus.Queries[0].Expected)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package search
+
+import (
+	"context"
+	"slices"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+type restrictedContextKey struct{}
+
+// WithRestricted returns a context in which searches cannot access claims of props:
+// search states, filters, and facets using them are rejected with ErrRestricted and
+// they are not listed among available filters.
+//
+// Claims of restricted properties should not be indexed in the first place
+// (see es.RemoveRestricted), this prevents access to claims indexed before properties
+// became restricted as well.
+func WithRestricted(ctx context.Context, props []identifier.Identifier) context.Context {
+	if len(props) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, restrictedContextKey{}, props)
+}
+
+func restrictedProps(ctx context.Context) []identifier.Identifier {
+	props, _ := ctx.Value(restrictedContextKey{}).([]identifier.Identifier)
+	return props
+}
+
+// CheckRestricted returns ErrRestricted if claims of the property cannot be accessed
+// in the context (see WithRestricted).
+func CheckRestricted(ctx context.Context, prop identifier.Identifier) errors.E {
+	if slices.Contains(restrictedProps(ctx), prop) {
+		errE := errors.WithStack(ErrRestricted)
+		errors.Details(errE)["prop"] = prop.String()
+		return errE
+	}
+	return nil
+}
+
+// CheckRestrictedFilters returns ErrRestricted if filters in JSON use a property whose
+// claims cannot be accessed in the context. Invalid filters are ignored.
+func CheckRestrictedFilters(ctx context.Context, filtersJSON string) errors.E {
+	if filtersJSON == "" || len(restrictedProps(ctx)) == 0 {
+		return nil
+	}
+	var f filters
+	if x.UnmarshalWithoutUnknownFields([]byte(filtersJSON), &f) != nil {
+		return nil
+	}
+	return f.checkRestricted(ctx)
+}
+
+// checkRestricted returns ErrRestricted if any clause of filters uses a property whose
+// claims cannot be accessed in the context.
+func (f filters) checkRestricted(ctx context.Context) errors.E {
+	for _, c := range f.And {
+		errE := c.checkRestricted(ctx)
+		if errE != nil {
+			return errE
+		}
+	}
+	for _, c := range f.Or {
+		errE := c.checkRestricted(ctx)
+		if errE != nil {
+			return errE
+		}
+	}
+	if f.Not != nil {
+		errE := f.Not.checkRestricted(ctx)
+		if errE != nil {
+			return errE
+		}
+	}
+	switch {
+	case f.Rel != nil:
+		return CheckRestricted(ctx, f.Rel.Prop)
+	case f.Amount != nil:
+		if f.Amount.Meta != nil {
+			errE := CheckRestricted(ctx, f.Amount.Meta.Prop)
+			if errE != nil {
+				return errE
+			}
+		}
+		return CheckRestricted(ctx, f.Amount.Prop)
+	case f.Time != nil:
+		return CheckRestricted(ctx, f.Time.Prop)
+	case f.Str != nil:
+		return CheckRestricted(ctx, f.Str.Prop)
+	}
+	return nil
+}
+
+// loadState returns the search state with the ID. It returns ErrNotFound if the search
+// state does not exist and ErrRestricted if its filters use a property whose claims
+// cannot be accessed in the context.
+//
+// All access to search states by ID should go through loadState (or GetState).
+func loadState(ctx context.Context, id identifier.Identifier) (*State, errors.E) {
+	metrics := waf.MustGetMetrics(ctx)
+
+	m := metrics.Duration(internal.MetricSearchState).Start()
+	ss, ok := searches.Load(id)
+	m.Stop()
+	if !ok {
+		// Something was not OK, so we return not found.
+		return nil, errors.WithStack(ErrNotFound)
+	}
+	sh := ss.(*State) //nolint:errcheck,forcetypeassert
+
+	if sh.Filters != nil {
+		errE := sh.Filters.checkRestricted(ctx)
+		if errE != nil {
+			return nil, errE
+		}
+	}
+
+	return sh, nil
+}
+
+// restrictedFilter returns a query matching nested claims at path whose properties
+// can be accessed in the context. Without restricted properties it matches all claims.
+func restrictedFilter(ctx context.Context, path string) *elastic.BoolQuery {
+	query := elastic.NewBoolQuery()
+	props := restrictedProps(ctx)
+	if len(props) == 0 {
+		return query
+	}
+	values := make([]interface{}, 0, len(props))
+	for _, prop := range props {
+		values = append(values, prop.String())
+	}
+	return query.MustNot(elastic.NewTermsQuery(path+".prop.id", values...))
+}
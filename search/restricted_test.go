@@ -0,0 +1,56 @@
+//nolint:testpackage
+package search
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+)
+
+func TestCheckRestrictedFilters(t *testing.T) {
+	t.Parallel()
+
+	public := identifier.New()
+	restricted := identifier.New()
+	value := identifier.New()
+
+	ctx := WithRestricted(context.Background(), []identifier.Identifier{restricted})
+
+	tests := []struct {
+		filters    string
+		restricted bool
+	}{
+		{``, false},
+		{`{"str":{"prop":"` + public.String() + `","str":"foo"}}`, false},
+		{`{"str":{"prop":"` + restricted.String() + `","str":"foo"}}`, true},
+		{`{"and":[{"rel":{"prop":"` + public.String() + `","value":"` + value.String() + `"}},{"not":{"time":{"prop":"` + restricted.String() + `","none":true}}}]}`, true},
+		{`{"or":[{"amount":{"prop":"` + public.String() + `","unit":"1","none":true,"meta":{"prop":"` + restricted.String() + `","value":"` + value.String() + `"}}}]}`, true},
+		// Invalid filters are ignored.
+		{`{"invalid":true}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.filters, func(t *testing.T) {
+			t.Parallel()
+
+			errE := CheckRestrictedFilters(ctx, test.filters)
+			if test.restricted {
+				assert.ErrorIs(t, errE, ErrRestricted)
+			} else {
+				assert.NoError(t, errE, "% -+#.1v", errE)
+			}
+
+			// Without restricted properties in the context, all filters are allowed.
+			errE = CheckRestrictedFilters(context.Background(), test.filters)
+			assert.NoError(t, errE, "% -+#.1v", errE)
+		})
+	}
+
+	errE := CheckRestricted(ctx, restricted)
+	require.ErrorIs(t, errE, ErrRestricted)
+	assert.Equal(t, restricted.String(), errors.Details(errE)["prop"])
+	assert.NoError(t, CheckRestricted(ctx, public))
+}
@@ -0,0 +1,335 @@
+package search
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+const (
+	// ScoringOriginNow is the decay origin which is the time of the query.
+	ScoringOriginNow = "now"
+
+	defaultScoringWeight = 1.0
+	defaultDecay         = 0.5
+)
+
+//nolint:gochecknoglobals
+var (
+	fieldValueFactorFields    = []string{"score", "references"}
+	fieldValueFactorModifiers = []string{"none", "log", "log1p", "log2p", "ln", "ln1p", "ln2p", "square", "sqrt", "reciprocal"}
+	decayFunctions            = []string{"gauss", "exp", "linear"}
+	// scoringDurationUnits maps duration unit suffixes to seconds.
+	scoringDurationUnits = map[string]int64{
+		"s": 1,
+		"m": 60,                 //nolint:mnd
+		"h": 60 * 60,            //nolint:mnd
+		"d": 24 * 60 * 60,       //nolint:mnd
+		"w": 7 * 24 * 60 * 60,   //nolint:mnd
+		"y": 365 * 24 * 60 * 60, //nolint:mnd
+	}
+)
+
+// FieldValueFactor scores documents by a numeric value: amount of an amount claim
+// with the given property or, if property is not set, the document's field.
+type FieldValueFactor struct {
+	// Field is the document's field used when property is not set. One of "score" (default)
+	// and "references" (the number of other documents referencing the document).
+	Field string `json:"field,omitempty" yaml:"field,omitempty"`
+	// Factor multiplies the value. Default is 1.
+	Factor float64 `json:"factor,omitempty" yaml:"factor,omitempty"`
+	// Modifier is applied to the value after multiplying it with factor.
+	// One of "none" (default), "log", "log1p", "log2p", "ln", "ln1p", "ln2p", "square", "sqrt", and "reciprocal".
+	Modifier string `json:"modifier,omitempty" yaml:"modifier,omitempty"`
+	// Missing is the value used for documents without the value.
+	Missing float64 `json:"missing,omitempty" yaml:"missing,omitempty"`
+}
+
+// Decay scores documents by how far the timestamp of a time claim with the given property
+// is from the origin. The score is 1 inside offset from the origin and decays to decay at
+// scale (plus offset) from the origin.
+type Decay struct {
+	// Function is one of "gauss" (default), "exp", and "linear".
+	Function string `json:"function,omitempty" yaml:"function,omitempty"`
+	// Origin is a timestamp or "now" (default) for the time of the query.
+	Origin string `json:"origin,omitempty" yaml:"origin,omitempty"`
+	// Scale is a duration with a unit suffix (one of "s", "m", "h", "d", "w", and "y"), e.g., "30d".
+	Scale string `json:"scale" yaml:"scale"`
+	// Offset is a duration with a unit suffix, e.g., "7d".
+	Offset string `json:"offset,omitempty" yaml:"offset,omitempty"`
+	// Decay is the score at scale from the origin. It must be between 0 and 1. Default is 0.5.
+	Decay float64 `json:"decay,omitempty" yaml:"decay,omitempty"`
+}
+
+// ScoringFunction is an administrator defined function which adjusts relevance
+// of documents. Exactly one of FieldValueFactor and Decay has to be set.
+type ScoringFunction struct {
+	// Prop is the property of amount claims (for field value factor) or time claims (for decay).
+	Prop *identifier.Identifier `json:"prop,omitempty" yaml:"prop,omitempty"`
+	// Weight multiplies the score of the function before it is added to the score
+	// of the query. Default is 1.
+	Weight float64 `json:"weight,omitempty" yaml:"weight,omitempty"`
+
+	FieldValueFactor *FieldValueFactor `json:"fieldValueFactor,omitempty" yaml:"fieldValueFactor,omitempty"`
+	Decay            *Decay            `json:"decay,omitempty"            yaml:"decay,omitempty"`
+}
+
+// Validate validates the scoring function.
+func (f *ScoringFunction) Validate() errors.E {
+	if (f.FieldValueFactor == nil) == (f.Decay == nil) {
+		return errors.New("exactly one of fieldValueFactor and decay is required")
+	}
+	if f.Weight < 0 {
+		errE := errors.New("weight cannot be negative")
+		errors.Details(errE)["weight"] = f.Weight
+		return errE
+	}
+	if f.FieldValueFactor != nil {
+		if f.FieldValueFactor.Modifier != "" && !slices.Contains(fieldValueFactorModifiers, f.FieldValueFactor.Modifier) {
+			errE := errors.New("invalid modifier")
+			errors.Details(errE)["modifier"] = f.FieldValueFactor.Modifier
+			return errE
+		}
+		if f.FieldValueFactor.Field != "" {
+			if f.Prop != nil {
+				return errors.New("field cannot be used with prop")
+			}
+			if !slices.Contains(fieldValueFactorFields, f.FieldValueFactor.Field) {
+				errE := errors.New("invalid field")
+				errors.Details(errE)["field"] = f.FieldValueFactor.Field
+				return errE
+			}
+		}
+		return nil
+	}
+
+	if f.Prop == nil {
+		return errors.New("prop is required for decay")
+	}
+	if f.Decay.Function != "" && !slices.Contains(decayFunctions, f.Decay.Function) {
+		errE := errors.New("invalid decay function")
+		errors.Details(errE)["function"] = f.Decay.Function
+		return errE
+	}
+	if f.Decay.Origin != "" && f.Decay.Origin != ScoringOriginNow {
+		var t document.Timestamp
+		err := t.UnmarshalText([]byte(f.Decay.Origin))
+		if err != nil {
+			errE := errors.WithMessage(err, "invalid origin")
+			errors.Details(errE)["origin"] = f.Decay.Origin
+			return errE
+		}
+	}
+	scale, errE := parseScoringDuration(f.Decay.Scale)
+	if errE != nil {
+		return errors.WithMessage(errE, "invalid scale")
+	}
+	if scale <= 0 {
+		errE := errors.New("scale must be positive")
+		errors.Details(errE)["scale"] = f.Decay.Scale
+		return errE
+	}
+	if f.Decay.Offset != "" {
+		_, errE := parseScoringDuration(f.Decay.Offset)
+		if errE != nil {
+			return errors.WithMessage(errE, "invalid offset")
+		}
+	}
+	if f.Decay.Decay != 0 && (f.Decay.Decay <= 0 || f.Decay.Decay >= 1) {
+		errE := errors.New("decay must be between 0 and 1")
+		errors.Details(errE)["decay"] = f.Decay.Decay
+		return errE
+	}
+	return nil
+}
+
+// ValidateScoringFunctions validates all scoring functions.
+func ValidateScoringFunctions(functions []ScoringFunction) errors.E {
+	for i := range functions {
+		errE := functions[i].Validate()
+		if errE != nil {
+			errors.Details(errE)["index"] = i
+			return errE
+		}
+	}
+	return nil
+}
+
+// parseScoringDuration parses a duration with a unit suffix into seconds.
+func parseScoringDuration(s string) (int64, errors.E) {
+	for unit, seconds := range scoringDurationUnits {
+		number, ok := strings.CutSuffix(s, unit)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			errE := errors.WithMessage(err, "invalid duration")
+			errors.Details(errE)["duration"] = s
+			return 0, errE
+		}
+		if n < 0 {
+			errE := errors.New("negative duration")
+			errors.Details(errE)["duration"] = s
+			return 0, errE
+		}
+		return n * seconds, nil
+	}
+	errE := errors.New("duration without a valid unit")
+	errors.Details(errE)["duration"] = s
+	return 0, errE
+}
+
+func (f *ScoringFunction) weight() float64 {
+	if f.Weight == 0 {
+		return defaultScoringWeight
+	}
+	return f.Weight
+}
+
+// query returns a query which matches all documents and scores them using the scoring
+// function. It assumes the scoring function is valid.
+func (f *ScoringFunction) query(now time.Time) elastic.Query { //nolint:ireturn
+	if f.FieldValueFactor != nil {
+		fieldValueFactor := elastic.NewFieldValueFactorFunction().Missing(f.FieldValueFactor.Missing)
+		if f.FieldValueFactor.Factor != 0 {
+			fieldValueFactor.Factor(f.FieldValueFactor.Factor)
+		}
+		if f.FieldValueFactor.Modifier != "" {
+			fieldValueFactor.Modifier(f.FieldValueFactor.Modifier)
+		}
+		if f.Prop == nil {
+			field := f.FieldValueFactor.Field
+			if field == "" {
+				field = "score"
+			}
+			return elastic.NewFunctionScoreQuery().Query(elastic.NewMatchAllQuery()).
+				AddScoreFunc(fieldValueFactor.Field(field)).BoostMode("replace").Boost(f.weight())
+		}
+		return elastic.NewNestedQuery("claims.amount",
+			elastic.NewFunctionScoreQuery().Query(elastic.NewTermQuery("claims.amount.prop.id", f.Prop.String())).
+				AddScoreFunc(fieldValueFactor.Field("claims.amount.amount")).BoostMode("replace"),
+		).ScoreMode("max").Boost(f.weight())
+	}
+
+	origin := now.Unix()
+	if f.Decay.Origin != "" && f.Decay.Origin != ScoringOriginNow {
+		var t document.Timestamp
+		// Error has been checked during validation.
+		_ = t.UnmarshalText([]byte(f.Decay.Origin))
+		origin = time.Time(t).Unix()
+	}
+	// Errors have been checked during validation.
+	scale, _ := parseScoringDuration(f.Decay.Scale)
+	var offset int64
+	if f.Decay.Offset != "" {
+		offset, _ = parseScoringDuration(f.Decay.Offset)
+	}
+	decay := f.Decay.Decay
+	if decay == 0 {
+		decay = defaultDecay
+	}
+
+	var decayFunction elastic.ScoreFunction
+	switch f.Decay.Function {
+	case "exp":
+		decayFunction = elastic.NewExponentialDecayFunction().FieldName("claims.time.timestampSeconds").
+			Origin(origin).Scale(scale).Offset(offset).Decay(decay)
+	case "linear":
+		decayFunction = elastic.NewLinearDecayFunction().FieldName("claims.time.timestampSeconds").
+			Origin(origin).Scale(scale).Offset(offset).Decay(decay)
+	default:
+		decayFunction = elastic.NewGaussDecayFunction().FieldName("claims.time.timestampSeconds").
+			Origin(origin).Scale(scale).Offset(offset).Decay(decay)
+	}
+	return elastic.NewNestedQuery("claims.time",
+		elastic.NewFunctionScoreQuery().Query(elastic.NewTermQuery("claims.time.prop.id", f.Prop.String())).
+			AddScoreFunc(decayFunction).BoostMode("replace"),
+	).ScoreMode("max").Boost(f.weight())
+}
+
+// ScoredQuery wraps the query so that scores of scoring functions are added to the
+// score of the query. The query matches the same documents as the wrapped query.
+//
+// Scoring functions should be validated before calling ScoredQuery.
+func ScoredQuery(query elastic.Query, functions []ScoringFunction, now time.Time) elastic.Query { //nolint:ireturn
+	if len(functions) == 0 {
+		return query
+	}
+
+	boolQuery := elastic.NewBoolQuery().Must(query)
+	for i := range functions {
+		boolQuery.Should(functions[i].query(now))
+	}
+	return boolQuery
+}
+
+// DefaultScoringPreviewSize is the default number of documents scored by ScoringPreview
+// when no document IDs are given.
+const DefaultScoringPreviewSize = 20
+
+// ScoringPreviewResult is a document with its score from scoring functions.
+type ScoringPreviewResult struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// ScoringPreview scores documents using only scoring functions, without any search query,
+// so that administrators can inspect how scoring functions rank documents. If ids is not
+// empty, documents with those IDs are scored. Otherwise up to size documents with the highest
+// scores are returned. Scoring functions should be validated.
+func ScoringPreview(
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64),
+	functions []ScoringFunction, ids []identifier.Identifier, size int,
+) ([]ScoringPreviewResult, errors.E) {
+	metrics := waf.MustGetMetrics(ctx)
+
+	// Filters do not contribute to the score, so the score is only from scoring functions.
+	baseQuery := elastic.NewBoolQuery()
+	if len(ids) > 0 {
+		values := make([]string, len(ids))
+		for i, id := range ids {
+			values[i] = id.String()
+		}
+		baseQuery.Filter(elastic.NewIdsQuery().Ids(values...))
+		size = len(ids)
+	} else {
+		baseQuery.Filter(elastic.NewMatchAllQuery())
+	}
+	if size <= 0 || size > MaxResultsCount {
+		errE := errors.WithMessage(ErrInvalidArgument, "size out of range")
+		errors.Details(errE)["size"] = size
+		errors.Details(errE)["max"] = MaxResultsCount
+		return nil, errE
+	}
+
+	searchService, _ := getSearchService()
+	searchService = searchService.From(0).Size(size).Query(ScoredQuery(baseQuery, functions, time.Now()))
+
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
+	res, err := searchService.Do(ctx)
+	m.Stop()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	metrics.Duration(internal.MetricElasticSearchInternal).Duration = time.Duration(res.TookInMillis) * time.Millisecond
+
+	results := make([]ScoringPreviewResult, len(res.Hits.Hits))
+	for i, hit := range res.Hits.Hits {
+		results[i] = ScoringPreviewResult{ID: hit.Id, Score: 0}
+		if hit.Score != nil {
+			results[i].Score = *hit.Score
+		}
+	}
+	return results, nil
+}
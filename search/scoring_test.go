@@ -0,0 +1,103 @@
+package search_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestScoringFunctionValidate(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+
+	for _, tt := range []struct {
+		name     string
+		function search.ScoringFunction
+		valid    bool
+	}{
+		{"empty", search.ScoringFunction{}, false}, //nolint:exhaustruct
+		{"both", search.ScoringFunction{FieldValueFactor: &search.FieldValueFactor{}, Decay: &search.Decay{Scale: "1d"}}, false},       //nolint:exhaustruct
+		{"score", search.ScoringFunction{FieldValueFactor: &search.FieldValueFactor{Modifier: "log1p"}}, true},                         //nolint:exhaustruct
+		{"invalid modifier", search.ScoringFunction{FieldValueFactor: &search.FieldValueFactor{Modifier: "foo"}}, false},               //nolint:exhaustruct
+		{"references", search.ScoringFunction{FieldValueFactor: &search.FieldValueFactor{Field: "references"}}, true},                  //nolint:exhaustruct
+		{"invalid field", search.ScoringFunction{FieldValueFactor: &search.FieldValueFactor{Field: "foo"}}, false},                     //nolint:exhaustruct
+		{"field with prop", search.ScoringFunction{Prop: &prop, FieldValueFactor: &search.FieldValueFactor{Field: "score"}}, false},    //nolint:exhaustruct
+		{"negative weight", search.ScoringFunction{Weight: -1, FieldValueFactor: &search.FieldValueFactor{}}, false},                   //nolint:exhaustruct
+		{"decay", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{Scale: "30d", Offset: "7d", Decay: 0.3}}, true},             //nolint:exhaustruct
+		{"decay origin", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{Origin: "2020-01-01T00:00:00Z", Scale: "1y"}}, true}, //nolint:exhaustruct
+		{"decay without prop", search.ScoringFunction{Decay: &search.Decay{Scale: "30d"}}, false},                                      //nolint:exhaustruct
+		{"invalid function", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{Function: "foo", Scale: "30d"}}, false},          //nolint:exhaustruct
+		{"invalid origin", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{Origin: "yesterday", Scale: "30d"}}, false},        //nolint:exhaustruct
+		{"missing scale", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{}}, false},                                          //nolint:exhaustruct
+		{"zero scale", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{Scale: "0d"}}, false},                                  //nolint:exhaustruct
+		{"invalid scale", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{Scale: "30"}}, false},                               //nolint:exhaustruct
+		{"invalid offset", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{Scale: "30d", Offset: "-1d"}}, false},              //nolint:exhaustruct
+		{"invalid decay", search.ScoringFunction{Prop: &prop, Decay: &search.Decay{Scale: "30d", Decay: 1}}, false},                    //nolint:exhaustruct
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			errE := tt.function.Validate()
+			if tt.valid {
+				assert.NoError(t, errE, "% -+#.1v", errE)
+			} else {
+				assert.Error(t, errE)
+			}
+		})
+	}
+}
+
+func TestScoredQuery(t *testing.T) {
+	t.Parallel()
+
+	query := elastic.NewMatchAllQuery()
+	assert.Equal(t, query, search.ScoredQuery(query, nil, time.Now()))
+
+	prop := identifier.New()
+	now := time.Unix(1000000, 0)
+	source, err := search.ScoredQuery(query, []search.ScoringFunction{
+		{Prop: nil, Weight: 0, FieldValueFactor: &search.FieldValueFactor{Field: "", Factor: 2, Modifier: "log1p", Missing: 0}, Decay: nil},
+		{Prop: &prop, Weight: 0.5, FieldValueFactor: nil, Decay: &search.Decay{Function: "exp", Origin: "", Scale: "1d", Offset: "", Decay: 0}},
+	}, now).Source()
+	require.NoError(t, err)
+
+	data, err := json.Marshal(source)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"bool": {
+			"must": {"match_all": {}},
+			"should": [
+				{
+					"function_score": {
+						"boost": 1,
+						"boost_mode": "replace",
+						"functions": [{"field_value_factor": {"factor": 2, "field": "score", "missing": 0, "modifier": "log1p"}}],
+						"query": {"match_all": {}}
+					}
+				},
+				{
+					"nested": {
+						"boost": 0.5,
+						"path": "claims.time",
+						"query": {
+							"function_score": {
+								"boost_mode": "replace",
+								"functions": [{"exp": {"claims.time.timestampSeconds": {"decay": 0.5, "offset": 0, "origin": 1000000, "scale": 86400}}}],
+								"query": {"term": {"claims.time.prop.id": "`+prop.String()+`"}}
+							}
+						},
+						"score_mode": "max"
+					}
+				}
+			]
+		}
+	}`, string(data))
+}
@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/olivere/elastic/v7"
 	"github.com/rs/zerolog"
@@ -15,6 +16,7 @@ import (
 	"gitlab.com/tozd/identifier"
 
 	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
 	"gitlab.com/peerdb/peerdb/internal/types"
 	"gitlab.com/peerdb/peerdb/store"
 )
@@ -27,6 +29,10 @@ type relFilter struct {
 	Prop  identifier.Identifier  `json:"prop"`
 	Value *identifier.Identifier `json:"value,omitempty"`
 	None  bool                   `json:"none,omitempty"`
+
+	// narrower are IDs of documents narrower than Value, which the filter matches as well.
+	// See expandNarrower.
+	narrower []identifier.Identifier
 }
 
 func (f relFilter) Valid() errors.E {
@@ -39,12 +45,39 @@ func (f relFilter) Valid() errors.E {
 	return nil
 }
 
+// MetaRelFilter limits claims to those with a meta relation claim
+// with the property pointing to the value.
+type MetaRelFilter struct {
+	Prop  identifier.Identifier `json:"prop"`
+	Value identifier.Identifier `json:"value"`
+}
+
+func (f *MetaRelFilter) query(field string) elastic.Query { //nolint:ireturn
+	return elastic.NewTermQuery(field, es.MetaRelationValue(f.Prop.String(), f.Value.String()))
+}
+
+// amountQuery returns the query matching amount claims with the property and unit,
+// optionally limited by the meta relation claim.
+func amountQuery(prop identifier.Identifier, unit string, meta *MetaRelFilter) *elastic.BoolQuery {
+	boolQuery := elastic.NewBoolQuery().Must(
+		elastic.NewTermQuery("claims.amount.prop.id", prop),
+		elastic.NewTermQuery("claims.amount.unit", unit),
+	)
+	if meta != nil {
+		boolQuery.Must(meta.query("claims.amount.metaRel"))
+	}
+	return boolQuery
+}
+
 type amountFilter struct {
 	Prop identifier.Identifier `json:"prop"`
 	Unit *document.AmountUnit  `json:"unit,omitempty"`
-	Gte  *float64              `json:"gte,omitempty"`
-	Lte  *float64              `json:"lte,omitempty"`
-	None bool                  `json:"none,omitempty"`
+	// Meta limits amounts to those with a matching meta relation claim
+	// (e.g., amounts per serving instead of per 100 g).
+	Meta *MetaRelFilter `json:"meta,omitempty"`
+	Gte  *float64       `json:"gte,omitempty"`
+	Lte  *float64       `json:"lte,omitempty"`
+	None bool           `json:"none,omitempty"`
 }
 
 func (f amountFilter) Valid() errors.E {
@@ -84,10 +117,22 @@ func (f timeFilter) Valid() errors.E {
 	return nil
 }
 
+// How string filters match values.
+const (
+	// StringMatchExact matches values equal to the string (default).
+	StringMatchExact = "exact"
+	// StringMatchPhrase matches values containing the string as a phrase.
+	StringMatchPhrase = "phrase"
+	// StringMatchPrefix matches values starting with the string.
+	StringMatchPrefix = "prefix"
+)
+
 type stringFilter struct {
 	Prop identifier.Identifier `json:"prop"`
 	Str  string                `json:"str,omitempty"`
-	None bool                  `json:"none,omitempty"`
+	// Match is one of "exact" (default), "phrase", and "prefix".
+	Match string `json:"match,omitempty"`
+	None  bool   `json:"none,omitempty"`
 }
 
 func (f stringFilter) Valid() errors.E {
@@ -97,9 +142,32 @@ func (f stringFilter) Valid() errors.E {
 	if f.Str != "" && f.None {
 		return errors.New("str and none cannot be both set")
 	}
+	if f.Match != "" && f.None {
+		return errors.New("match and none cannot be both set")
+	}
+	switch f.Match {
+	case "", StringMatchExact, StringMatchPhrase, StringMatchPrefix:
+	default:
+		errE := errors.New("invalid match")
+		errors.Details(errE)["match"] = f.Match
+		return errE
+	}
 	return nil
 }
 
+// query returns the query matching string claims with the property and the string.
+func (f stringFilter) query() elastic.Query { //nolint:ireturn
+	switch f.Match {
+	case StringMatchPhrase:
+		// We use the text field because the keyword field is not analyzed.
+		return elastic.NewMatchPhraseQuery("claims.string.string.text", f.Str)
+	case StringMatchPrefix:
+		return elastic.NewPrefixQuery("claims.string.string", f.Str)
+	default:
+		return elastic.NewTermQuery("claims.string.string", f.Str)
+	}
+}
+
 type indexFilter struct {
 	Str string `json:"str"`
 }
@@ -130,16 +198,30 @@ func (f sizeFilter) Valid() errors.E {
 	return nil
 }
 
+// childrenFilter matches documents with embedded children (see document.D)
+// matching the search query.
+type childrenFilter struct {
+	Query string `json:"query"`
+}
+
+func (f childrenFilter) Valid() errors.E {
+	if f.Query == "" {
+		return errors.New("query has to be set")
+	}
+	return nil
+}
+
 type filters struct {
-	And    []filters     `json:"and,omitempty"`
-	Or     []filters     `json:"or,omitempty"`
-	Not    *filters      `json:"not,omitempty"`
-	Rel    *relFilter    `json:"rel,omitempty"`
-	Amount *amountFilter `json:"amount,omitempty"`
-	Time   *timeFilter   `json:"time,omitempty"`
-	Str    *stringFilter `json:"str,omitempty"`
-	Index  *indexFilter  `json:"index,omitempty"`
-	Size   *sizeFilter   `json:"size,omitempty"`
+	And      []filters       `json:"and,omitempty"`
+	Or       []filters       `json:"or,omitempty"`
+	Not      *filters        `json:"not,omitempty"`
+	Rel      *relFilter      `json:"rel,omitempty"`
+	Amount   *amountFilter   `json:"amount,omitempty"`
+	Time     *timeFilter     `json:"time,omitempty"`
+	Str      *stringFilter   `json:"str,omitempty"`
+	Index    *indexFilter    `json:"index,omitempty"`
+	Size     *sizeFilter     `json:"size,omitempty"`
+	Children *childrenFilter `json:"children,omitempty"`
 }
 
 func (f filters) Valid() errors.E {
@@ -211,6 +293,13 @@ func (f filters) Valid() errors.E {
 			return err
 		}
 	}
+	if f.Children != nil {
+		nonEmpty++
+		err := f.Children.Valid()
+		if err != nil {
+			return err
+		}
+	}
 	if nonEmpty > 1 {
 		return errors.New("only one clause can be set")
 	} else if nonEmpty == 0 {
@@ -219,50 +308,53 @@ func (f filters) Valid() errors.E {
 	return nil
 }
 
-func (f filters) ToQuery() elastic.Query { //nolint:ireturn
+// ToQuery returns the ElasticSearch query for filters. If asOf is set,
+// only claims valid at that time are considered.
+func (f filters) ToQuery(asOf *document.Timestamp) elastic.Query { //nolint:ireturn
 	if len(f.And) > 0 {
 		boolQuery := elastic.NewBoolQuery()
 		for _, filter := range f.And {
-			boolQuery.Must(filter.ToQuery())
+			boolQuery.Must(filter.ToQuery(asOf))
 		}
 		return boolQuery
 	}
 	if len(f.Or) > 0 {
 		boolQuery := elastic.NewBoolQuery()
 		for _, filter := range f.Or {
-			boolQuery.Should(filter.ToQuery())
+			boolQuery.Should(filter.ToQuery(asOf))
 		}
 		return boolQuery
 	}
 	if f.Not != nil {
 		boolQuery := elastic.NewBoolQuery()
-		boolQuery.MustNot(f.Not.ToQuery())
+		boolQuery.MustNot(f.Not.ToQuery(asOf))
 		return boolQuery
 	}
 	if f.Rel != nil {
 		if f.Rel.None {
 			return elastic.NewBoolQuery().MustNot(
-				elastic.NewNestedQuery("claims.rel",
+				nestedQuery("claims.rel", asOf,
 					elastic.NewTermQuery("claims.rel.prop.id", f.Rel.Prop),
 				),
 			)
 		}
-		return elastic.NewNestedQuery("claims.rel",
+		values := make([]interface{}, 0, 1+len(f.Rel.narrower))
+		values = append(values, f.Rel.Value.String())
+		for _, value := range f.Rel.narrower {
+			values = append(values, value.String())
+		}
+		return nestedQuery("claims.rel", asOf,
 			elastic.NewBoolQuery().Must(
 				elastic.NewTermQuery("claims.rel.prop.id", f.Rel.Prop),
-				elastic.NewTermQuery("claims.rel.to.id", f.Rel.Value),
+				elastic.NewTermsQuery("claims.rel.to.id", values...),
 			),
 		)
 	}
 	if f.Amount != nil {
+		boolQuery := amountQuery(f.Amount.Prop, f.Amount.Unit.String(), f.Amount.Meta)
 		if f.Amount.None {
 			return elastic.NewBoolQuery().MustNot(
-				elastic.NewNestedQuery("claims.amount",
-					elastic.NewBoolQuery().Must(
-						elastic.NewTermQuery("claims.amount.prop.id", f.Amount.Prop),
-						elastic.NewTermQuery("claims.amount.unit", *f.Amount.Unit),
-					),
-				),
+				nestedQuery("claims.amount", asOf, boolQuery),
 			)
 		}
 		r := elastic.NewRangeQuery("claims.amount.amount")
@@ -272,30 +364,25 @@ func (f filters) ToQuery() elastic.Query { //nolint:ireturn
 		if f.Amount.Gte != nil {
 			r.Gte(*f.Amount.Gte)
 		}
-		return elastic.NewNestedQuery("claims.amount",
-			elastic.NewBoolQuery().Must(
-				elastic.NewTermQuery("claims.amount.prop.id", f.Amount.Prop),
-				elastic.NewTermQuery("claims.amount.unit", *f.Amount.Unit),
-				r,
-			),
-		)
+		return nestedQuery("claims.amount", asOf, boolQuery.Must(r))
 	}
 	if f.Time != nil {
 		if f.Time.None {
 			return elastic.NewBoolQuery().MustNot(
-				elastic.NewNestedQuery("claims.time",
+				nestedQuery("claims.time", asOf,
 					elastic.NewTermQuery("claims.time.prop.id", f.Time.Prop),
 				),
 			)
 		}
-		r := elastic.NewRangeQuery("claims.time.timestamp")
+		// We use the numeric field because ElasticSearch date fields cannot represent all timestamps.
+		r := elastic.NewRangeQuery("claims.time.timestampSeconds")
 		if f.Time.Lte != nil {
-			r.Lte(f.Time.Lte.String())
+			r.Lte(time.Time(*f.Time.Lte).Unix())
 		}
 		if f.Time.Gte != nil {
-			r.Gte(f.Time.Gte.String())
+			r.Gte(time.Time(*f.Time.Gte).Unix())
 		}
-		return elastic.NewNestedQuery("claims.time",
+		return nestedQuery("claims.time", asOf,
 			elastic.NewBoolQuery().Must(
 				elastic.NewTermQuery("claims.time.prop.id", f.Time.Prop),
 				r,
@@ -305,15 +392,15 @@ func (f filters) ToQuery() elastic.Query { //nolint:ireturn
 	if f.Str != nil {
 		if f.Str.None {
 			return elastic.NewBoolQuery().MustNot(
-				elastic.NewNestedQuery("claims.string",
+				nestedQuery("claims.string", asOf,
 					elastic.NewTermQuery("claims.string.prop.id", f.Str.Prop),
 				),
 			)
 		}
-		return elastic.NewNestedQuery("claims.string",
+		return nestedQuery("claims.string", asOf,
 			elastic.NewBoolQuery().Must(
 				elastic.NewTermQuery("claims.string.prop.id", f.Str.Prop),
-				elastic.NewTermQuery("claims.string.string", f.Str.Str),
+				f.Str.query(),
 			),
 		)
 	}
@@ -335,6 +422,9 @@ func (f filters) ToQuery() elastic.Query { //nolint:ireturn
 		}
 		return r
 	}
+	if f.Children != nil {
+		return childrenTextSearchQuery(f.Children.Query, asOf)
+	}
 	panic(errors.New("invalid filters"))
 }
 
@@ -345,11 +435,15 @@ type State struct {
 	SearchQuery string                 `json:"q"`
 	Prompt      string                 `json:"p,omitempty"`
 	Filters     *filters               `json:"filters,omitempty"`
+	AsOf        *document.Timestamp    `json:"asOf,omitempty"`
 	ParentID    *identifier.Identifier `json:"-"`
 	RootID      identifier.Identifier  `json:"-"`
 	PromptDone  bool                   `json:"promptDone,omitempty"`
 	PromptCalls []fun.TextRecorderCall `json:"promptCalls,omitempty"`
 	PromptError bool                   `json:"promptError,omitempty"`
+	// PromptProvider is the LLM provider which parsed the prompt.
+	PromptProvider string         `json:"promptProvider,omitempty"`
+	Warnings       []QueryWarning `json:"warnings,omitempty"`
 }
 
 // Values returns search state as query string values.
@@ -360,6 +454,9 @@ func (s *State) Values() url.Values {
 	} else {
 		values.Set("q", s.SearchQuery)
 	}
+	if s.AsOf != nil {
+		values.Set("asOf", s.AsOf.String())
+	}
 	return values
 }
 
@@ -373,38 +470,144 @@ func (s *State) ValuesWithAt(at string) url.Values {
 	return values
 }
 
-func documentTextSearchQuery(searchQuery, defaultOperator string) elastic.Query { //nolint:ireturn
+// validAtQuery returns a query which matches nested claims at path valid at the given time.
+// Claims without a validity period are always valid.
+func validAtQuery(path string, asOf document.Timestamp) elastic.Query { //nolint:ireturn
+	t := time.Time(asOf).Unix()
+	return elastic.NewBoolQuery().MustNot(
+		elastic.NewRangeQuery(path+".validFromSeconds").Gt(t),
+		elastic.NewRangeQuery(path+".validToSeconds").Lt(t),
+	)
+}
+
+// nestedQuery returns a nested query at path. If asOf is set, only claims valid at that time match.
+func nestedQuery(path string, asOf *document.Timestamp, query elastic.Query) *elastic.NestedQuery {
+	return elastic.NewNestedQuery(path, nestedFilter(path, asOf, query))
+}
+
+// documentValidAtQuery returns a query which matches documents valid at the given time.
+// Documents without a validity period are always valid.
+func documentValidAtQuery(asOf document.Timestamp) elastic.Query { //nolint:ireturn
+	return elastic.NewBoolQuery().MustNot(
+		elastic.NewNestedQuery("claims.timeRange",
+			elastic.NewBoolQuery().Must(
+				elastic.NewTermQuery("claims.timeRange.prop.id", document.GetCorePropertyID("VALIDITY")),
+			).MustNot(
+				validAtQuery("claims.timeRange", asOf),
+			),
+		),
+	)
+}
+
+// documentTextSearchQuery returns a query which matches the search query against claims of documents.
+// Matches in claims of properties with a weight in weights have their score multiplied by the weight.
+// The search query is also matched against claims of all name properties together (see NameProperties).
+func documentTextSearchQuery( //nolint:ireturn
+	searchQuery, defaultOperator string, asOf *document.Timestamp, weights FieldWeights, names NameProperties,
+) elastic.Query {
 	bq := elastic.NewBoolQuery()
 
 	if searchQuery != "" {
 		bq.Should(elastic.NewTermQuery("id", searchQuery))
+		props := weights.props()
 		for _, field := range []field{
-			{"claims.id", "id"},
+			{"claims.id", "value"},
 			{"claims.ref", "iri"},
 			{"claims.text", "html.en"},
 			{"claims.string", "string"},
 		} {
 			// TODO: Can we use simple query for keyword fields? Which analyzer is used?
 			q := elastic.NewSimpleQueryStringQuery(searchQuery).Field(field.Prefix + "." + field.Field).DefaultOperator(defaultOperator)
-			bq.Should(elastic.NewNestedQuery(field.Prefix, q))
+			if len(props) == 0 {
+				bq.Should(nestedQuery(field.Prefix, asOf, q))
+				continue
+			}
+			// Claims of properties without a weight.
+			values := make([]interface{}, len(props))
+			for i, prop := range props {
+				values[i] = prop
+			}
+			bq.Should(nestedQuery(field.Prefix, asOf, elastic.NewBoolQuery().Must(q).MustNot(elastic.NewTermsQuery(field.Prefix+".prop.id", values...))))
+			// Claims of properties with a weight.
+			for _, prop := range props {
+				bq.Should(nestedQuery(field.Prefix, asOf, elastic.NewBoolQuery().Must(q).Filter(elastic.NewTermQuery(field.Prefix+".prop.id", prop))).
+					Boost(weights[prop]))
+			}
+		}
+		if q := nameQuery(searchQuery, asOf, names); q != nil {
+			bq.Should(q)
 		}
 	}
 
 	return bq
 }
 
+// childrenTextSearchQuery returns a query which matches documents with embedded
+// children whose claims match the search query.
+func childrenTextSearchQuery(searchQuery string, asOf *document.Timestamp) elastic.Query { //nolint:ireturn
+	// Malformed parts of the query are fixed. See ParseQuery.
+	searchQuery, _ = ParseQuery(searchQuery)
+
+	bq := elastic.NewBoolQuery().Should(elastic.NewTermQuery("children.id", searchQuery))
+	for _, field := range []field{
+		{"children.claims.id", "value"},
+		{"children.claims.ref", "iri"},
+		{"children.claims.text", "html.en"},
+		{"children.claims.string", "string"},
+	} {
+		q := elastic.NewSimpleQueryStringQuery(searchQuery).Field(field.Prefix + "." + field.Field).DefaultOperator("AND")
+		bq.Should(nestedQuery(field.Prefix, asOf, q))
+	}
+
+	return elastic.NewNestedQuery("children", bq)
+}
+
 // TODO: Determine which operator should be the default?
 // TODO: Make sure right analyzers are used for all fields.
 // TODO: Limit allowed syntax for simple queries (disable fuzzy matching).
 func (s *State) Query() elastic.Query { //nolint:ireturn
+	return s.WeightedQuery(nil, nil)
+}
+
+// WeightedQuery is like Query, but matches of the search query in claims
+// of properties with a weight have their score multiplied by the weight and
+// the search query is matched against claims of name properties together.
+// Weights and name properties should be validated.
+func (s *State) WeightedQuery(weights FieldWeights, names NameProperties) elastic.Query { //nolint:ireturn
+	return s.query(weights, names, false, false)
+}
+
+// query returns the query for the search state. If named is true,
+// parts of the search state are named. See NamedQuery. If fuzzy is true,
+// any term of the search query matches, with fuzziness. See Fallback.
+func (s *State) query(weights FieldWeights, names NameProperties, named, fuzzy bool) elastic.Query { //nolint:ireturn
 	boolQuery := elastic.NewBoolQuery()
 
 	if s.SearchQuery != "" {
-		boolQuery.Must(documentTextSearchQuery(s.SearchQuery, "AND"))
+		// Malformed parts of the query are fixed. See ParseQuery.
+		searchQuery, _ := ParseQuery(s.SearchQuery)
+		defaultOperator := "AND"
+		if fuzzy {
+			searchQuery = fuzzyQuery(searchQuery)
+			defaultOperator = "OR"
+		}
+		query := documentTextSearchQuery(searchQuery, defaultOperator, s.AsOf, weights, names)
+		if named {
+			query = elastic.NewBoolQuery().Must(query).QueryName(matchedQueryName)
+		}
+		boolQuery.Must(query)
 	}
 
 	if s.Filters != nil {
-		boolQuery.Must(s.Filters.ToQuery())
+		if named {
+			boolQuery.Must(s.Filters.namedQuery(s.AsOf, matchedFiltersName))
+		} else {
+			boolQuery.Must(s.Filters.ToQuery(s.AsOf))
+		}
+	}
+
+	if s.AsOf != nil {
+		boolQuery.Filter(documentValidAtQuery(*s.AsOf))
 	}
 
 	return boolQuery
@@ -414,9 +617,13 @@ func (s *State) Ready() bool {
 	return s.Prompt == "" || s.PromptCalls != nil || s.PromptError
 }
 
+// ParsePrompt parses the prompt using a LLM and updates the search state with the
+// resulting query and filters. Calls made to the LLM are passed to recordUsage (if set)
+// once parsing finishes. The prompt is parsed with LLM providers llm (see LLMProviders),
+// and the provider which parsed it is recorded in the search state.
 func (s *State) ParsePrompt(
 	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	getSearchService func() (*elastic.SearchService, int64),
+	getSearchService func() (*elastic.SearchService, int64), recordUsage func([]fun.TextRecorderCall), llm *LLMProviders,
 ) {
 	ctx = fun.WithTextRecorder(ctx)
 	c := make(chan []fun.TextRecorderCall)
@@ -434,7 +641,7 @@ func (s *State) ParsePrompt(
 		}
 	}()
 
-	output, errE := parsePrompt(ctx, store, getSearchService, s.Prompt)
+	output, provider, errE := llm.parsePrompt(ctx, store, getSearchService, s.Prompt)
 
 	close(c)
 	wg.Wait()
@@ -442,16 +649,18 @@ func (s *State) ParsePrompt(
 	s.PromptDone = true
 	s.PromptCalls = fun.GetTextRecorder(ctx).Calls()
 
+	if recordUsage != nil {
+		recordUsage(s.PromptCalls)
+	}
+
 	if errE != nil {
-		zerolog.Ctx(ctx).Error().Err(errE).Str("prompt", s.Prompt).Interface("calls", s.PromptCalls).Msg("prompt parsing failed")
-		// We reuse the prompt as the search query in this case.
-		s.SearchQuery = s.Prompt
-		s.PromptError = true
-		searches.Store(s.ID, s)
+		s.promptFailed(ctx, errE)
 		return
 	}
 
+	s.PromptProvider = provider
 	s.SearchQuery = output.Query
+	_, s.Warnings = ParseQuery(s.SearchQuery)
 	s.Filters, errE = output.Filters()
 	if errE != nil {
 		zerolog.Ctx(ctx).Error().Err(errE).Interface("output", output).Interface("calls", s.PromptCalls).Msg("prompt filters conversion failed")
@@ -459,6 +668,12 @@ func (s *State) ParsePrompt(
 		searches.Store(s.ID, s)
 		return
 	}
+	if s.Filters != nil {
+		errE = s.Filters.expandNarrower(ctx, getSearchService)
+		if errE != nil {
+			zerolog.Ctx(ctx).Warn().Err(errE).Msg("expanding filters with narrower documents failed")
+		}
+	}
 
 	searches.Store(s.ID, s)
 }
@@ -474,11 +689,35 @@ type field struct {
 
 // TODO: Return (and log) and error on invalid search requests (e.g., filters).
 
+// parseAsOf parses the "as of" time. Invalid time is ignored.
+func parseAsOf(asOf string) *document.Timestamp {
+	if asOf == "" {
+		return nil
+	}
+	t, errE := document.ParsePartialTimestamp(asOf)
+	if errE != nil {
+		return nil
+	}
+	return &t
+}
+
+// promptFailed marks parsing of the prompt as failed.
+func (s *State) promptFailed(ctx context.Context, errE errors.E) {
+	zerolog.Ctx(ctx).Error().Err(errE).Str("prompt", s.Prompt).Interface("calls", s.PromptCalls).Msg("prompt parsing failed")
+	s.PromptDone = true
+	// We reuse the prompt as the search query in this case.
+	s.SearchQuery = s.Prompt
+	s.PromptError = true
+	searches.Store(s.ID, s)
+}
+
 // CreateState creates a new search state given optional existing state
-// (can be an empty string) and new query/filters.
+// (can be an empty string) and new query/filters/"as of" time. See ParsePrompt for recordUsage and llm.
+// The prompt is parsed once it is its turn in the queue (nil queue does not limit parsing).
 func CreateState(
 	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	getSearchService func() (*elastic.SearchService, int64), s string, searchQuery, filtersJSON string, isPrompt bool,
+	getSearchService func() (*elastic.SearchService, int64), recordUsage func([]fun.TextRecorderCall), queue *WorkQueue, llm *LLMProviders,
+	s string, searchQuery, filtersJSON, asOf string, isPrompt bool,
 ) *State {
 	var parentSearchID *identifier.Identifier
 	if id, errE := identifier.FromString(s); errE == nil {
@@ -488,10 +727,17 @@ func CreateState(
 	var fs *filters
 	if filtersJSON != "" {
 		var f filters
-		if x.UnmarshalWithoutUnknownFields([]byte(filtersJSON), &f) == nil && f.Valid() == nil {
+		// Filters using restricted properties are ignored as well.
+		if x.UnmarshalWithoutUnknownFields([]byte(filtersJSON), &f) == nil && f.Valid() == nil && f.checkRestricted(ctx) == nil {
 			fs = &f
 		}
 	}
+	if fs != nil {
+		errE := fs.expandNarrower(ctx, getSearchService)
+		if errE != nil {
+			zerolog.Ctx(ctx).Warn().Err(errE).Msg("expanding filters with narrower documents failed")
+		}
+	}
 
 	id := identifier.New()
 	rootID := id
@@ -517,23 +763,36 @@ func CreateState(
 		searchQuery = ""
 	}
 
+	_, warnings := ParseQuery(searchQuery)
+
 	sh := &State{
-		ID:          id,
-		SearchQuery: searchQuery,
-		Prompt:      prompt,
-		Filters:     fs,
-		ParentID:    parentSearchID,
-		RootID:      rootID,
-		PromptDone:  false,
-		PromptCalls: nil,
-		PromptError: false,
+		ID:             id,
+		SearchQuery:    searchQuery,
+		Prompt:         prompt,
+		Filters:        fs,
+		AsOf:           parseAsOf(asOf),
+		ParentID:       parentSearchID,
+		RootID:         rootID,
+		PromptDone:     false,
+		PromptCalls:    nil,
+		PromptError:    false,
+		PromptProvider: "",
+		Warnings:       warnings,
 	}
 	searches.Store(sh.ID, sh)
 
 	if isPrompt {
-		// We start parsing the prompt.
-		// TODO: We should push parsing prompt into a proper work queue and not just make a goroutine.
-		go sh.ParsePrompt(context.WithoutCancel(ctx), store, getSearchService)
+		// We start parsing the prompt once it is its turn in the queue.
+		go func() {
+			ctx := context.WithoutCancel(ctx)
+			release, errE := queue.Acquire(ctx)
+			if errE != nil {
+				sh.promptFailed(ctx, errE)
+				return
+			}
+			defer release()
+			sh.ParsePrompt(ctx, store, getSearchService, recordUsage, llm)
+		}()
 	} else { //nolint:revive,staticcheck
 		// TODO: Should we already do the query, to warm up ES cache?
 		//       Maybe we should cache response ourselves so that we do not hit store twice?
@@ -544,7 +803,8 @@ func CreateState(
 
 func createStateFromGetOrCreateState(
 	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	getSearchService func() (*elastic.SearchService, int64), s string, searchQuery, filtersJSON *string, isPrompt bool,
+	getSearchService func() (*elastic.SearchService, int64), recordUsage func([]fun.TextRecorderCall), queue *WorkQueue, llm *LLMProviders,
+	s string, searchQuery, filtersJSON, asOf *string, isPrompt bool,
 ) (*State, bool) {
 	if searchQuery == nil {
 		q := ""
@@ -554,25 +814,30 @@ func createStateFromGetOrCreateState(
 		f := ""
 		filtersJSON = &f
 	}
+	if asOf == nil {
+		a := ""
+		asOf = &a
+	}
 	// TODO: How to prevent that CreateState unmarshals filtersJSON again?
 	// TODO: How to prevent that CreateState calls searches.Load again?
-	return CreateState(ctx, store, getSearchService, s, *searchQuery, *filtersJSON, isPrompt), false
+	return CreateState(ctx, store, getSearchService, recordUsage, queue, llm, s, *searchQuery, *filtersJSON, *asOf, isPrompt), false
 }
 
 // GetOrCreateState resolves an existing search state if possible and validates that
-// optional query/filters match those in the search state. If not, it creates a new search state.
+// optional query/filters/"as of" time match those in the search state. If not, it creates a new search state.
 func GetOrCreateState(
 	ctx context.Context, store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
-	getSearchService func() (*elastic.SearchService, int64), s string, searchQuery, filtersJSON *string, isPrompt bool,
+	getSearchService func() (*elastic.SearchService, int64), recordUsage func([]fun.TextRecorderCall), queue *WorkQueue, llm *LLMProviders,
+	s string, searchQuery, filtersJSON, asOf *string, isPrompt bool,
 ) (*State, bool) {
 	searchID, errE := identifier.FromString(s)
 	if errE != nil {
-		return createStateFromGetOrCreateState(ctx, store, getSearchService, s, searchQuery, filtersJSON, isPrompt)
+		return createStateFromGetOrCreateState(ctx, store, getSearchService, recordUsage, queue, llm, s, searchQuery, filtersJSON, asOf, isPrompt)
 	}
 
-	sh, ok := searches.Load(searchID)
-	if !ok {
-		return createStateFromGetOrCreateState(ctx, store, getSearchService, s, searchQuery, filtersJSON, isPrompt)
+	ss, errE := loadState(ctx, searchID)
+	if errE != nil {
+		return createStateFromGetOrCreateState(ctx, store, getSearchService, recordUsage, queue, llm, s, searchQuery, filtersJSON, asOf, isPrompt)
 	}
 
 	var fs *filters
@@ -582,34 +847,36 @@ func GetOrCreateState(
 			fs = &f
 		} else {
 			// filtersJSON was invalid, so we pass nil instead.
-			return createStateFromGetOrCreateState(ctx, store, getSearchService, s, searchQuery, nil, isPrompt)
+			return createStateFromGetOrCreateState(ctx, store, getSearchService, recordUsage, queue, llm, s, searchQuery, nil, asOf, isPrompt)
 		}
 	}
 
-	ss := sh.(*State) //nolint:errcheck,forcetypeassert
-
 	if !isPrompt && searchQuery != nil && ss.SearchQuery != *searchQuery {
-		return createStateFromGetOrCreateState(ctx, store, getSearchService, s, searchQuery, filtersJSON, isPrompt)
+		return createStateFromGetOrCreateState(ctx, store, getSearchService, recordUsage, queue, llm, s, searchQuery, filtersJSON, asOf, isPrompt)
 	}
 	if isPrompt && searchQuery != nil && ss.Prompt != *searchQuery {
-		return createStateFromGetOrCreateState(ctx, store, getSearchService, s, searchQuery, filtersJSON, isPrompt)
+		return createStateFromGetOrCreateState(ctx, store, getSearchService, recordUsage, queue, llm, s, searchQuery, filtersJSON, asOf, isPrompt)
+	}
+	if filtersJSON != nil && !filtersEqual(ss.Filters, fs) {
+		return createStateFromGetOrCreateState(ctx, store, getSearchService, recordUsage, queue, llm, s, searchQuery, filtersJSON, asOf, isPrompt)
 	}
-	if filtersJSON != nil && !reflect.DeepEqual(ss.Filters, fs) {
-		return createStateFromGetOrCreateState(ctx, store, getSearchService, s, searchQuery, filtersJSON, isPrompt)
+	if asOf != nil && !reflect.DeepEqual(ss.AsOf, parseAsOf(*asOf)) {
+		return createStateFromGetOrCreateState(ctx, store, getSearchService, recordUsage, queue, llm, s, searchQuery, filtersJSON, asOf, isPrompt)
 	}
 
 	return ss, true
 }
 
 // GetState resolves an existing search state if possible.
-func GetState(s string) *State {
+// Search states using restricted properties (see WithRestricted) are not resolved.
+func GetState(ctx context.Context, s string) *State {
 	searchID, errE := identifier.FromString(s)
 	if errE != nil {
 		return nil
 	}
-	sh, ok := searches.Load(searchID)
-	if !ok {
+	sh, errE := loadState(ctx, searchID)
+	if errE != nil {
 		return nil
 	}
-	return sh.(*State) //nolint:forcetypeassert
+	return sh
 }
@@ -0,0 +1,251 @@
+package search
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	// SharedStateVersion is the current version of the shared search state encoding.
+	// It has to be increased (and a migration from the previous version added to
+	// migrateSharedState) whenever the shared state or filters change incompatibly.
+	SharedStateVersion = 1
+
+	// MaxSharedStateTokenLength is the maximum length of a shared search state token.
+	MaxSharedStateTokenLength = 4096
+
+	// maxSharedStateSize is the maximum size of decompressed JSON of a shared search state.
+	maxSharedStateSize = 64 * 1024
+
+	// MaxFacets is the maximum number of facets in a shared search state.
+	MaxFacets = 100
+)
+
+// SharedState is the full state of a search which can be shared as a compact URL-safe token.
+//
+// Unlike State, it does not have an ID and it does not exist only in memory of the instance
+// which created it, so it can be used to recreate the search anywhere. Prompts are not shared,
+// only the search query and filters they have been parsed into.
+type SharedState struct {
+	SearchQuery string              `json:"q,omitempty"`
+	Filters     *filters            `json:"filters,omitempty"`
+	AsOf        *document.Timestamp `json:"asOf,omitempty"`
+	Sorts       []Sort              `json:"sort,omitempty"`
+
+	// Facets are filters shown (expanded) in the UI, in order. Each facet is in the form
+	// "rel/<prop>", "amount/<prop>/<unit>", "time/<prop>", "string/<prop>", "index", or "size".
+	Facets []string `json:"facets,omitempty"`
+}
+
+// FiltersJSON returns filters of the shared search state as JSON, or an empty string if there are no filters.
+func (s *SharedState) FiltersJSON() (string, errors.E) {
+	if s.Filters == nil {
+		return "", nil
+	}
+	data, errE := x.MarshalWithoutEscapeHTML(s.Filters)
+	if errE != nil {
+		return "", errE
+	}
+	return string(data), nil
+}
+
+// Valid returns an error if the shared search state is not valid.
+func (s *SharedState) Valid() errors.E {
+	if s.Filters != nil {
+		errE := s.Filters.Valid()
+		if errE != nil {
+			return errors.WrapWith(errE, ErrInvalidArgument)
+		}
+	}
+	if len(s.Sorts) > MaxSorts {
+		errE := errors.WithMessage(ErrInvalidArgument, "too many sort specifications")
+		errors.Details(errE)["count"] = len(s.Sorts)
+		errors.Details(errE)["max"] = MaxSorts
+		return errE
+	}
+	for i, sort := range s.Sorts {
+		errE := sort.Valid()
+		if errE != nil {
+			errE = errors.WrapWith(errE, ErrInvalidArgument)
+			errors.Details(errE)["sort"] = i
+			return errE
+		}
+	}
+	if len(s.Facets) > MaxFacets {
+		errE := errors.WithMessage(ErrInvalidArgument, "too many facets")
+		errors.Details(errE)["count"] = len(s.Facets)
+		errors.Details(errE)["max"] = MaxFacets
+		return errE
+	}
+	for _, facet := range s.Facets {
+		errE := validFacet(facet)
+		if errE != nil {
+			return errE
+		}
+	}
+	return nil
+}
+
+// validFacet returns an error if facet is not in one of the supported forms.
+func validFacet(facet string) errors.E {
+	parts := strings.Split(facet, "/")
+	var props []string
+	switch {
+	case len(parts) == 1 && (parts[0] == "index" || parts[0] == "size"):
+	case len(parts) == 2 && (parts[0] == "rel" || parts[0] == "time" || parts[0] == "string"):
+		props = parts[1:]
+	case len(parts) == 3 && parts[0] == "amount":
+		props = parts[1:2]
+		if !document.ValidAmountUnit(parts[2]) || parts[2] == "@" {
+			errE := errors.WithMessage(ErrInvalidArgument, "invalid facet unit")
+			errors.Details(errE)["facet"] = facet
+			return errE
+		}
+	default:
+		errE := errors.WithMessage(ErrInvalidArgument, "invalid facet")
+		errors.Details(errE)["facet"] = facet
+		return errE
+	}
+	for _, prop := range props {
+		_, errE := identifier.FromString(prop)
+		if errE != nil {
+			errE = errors.WrapWith(errE, ErrInvalidArgument)
+			errors.Details(errE)["facet"] = facet
+			return errE
+		}
+	}
+	return nil
+}
+
+// Token encodes the shared search state into a compact URL-safe token.
+//
+// The token is the encoding version, followed by a dot and by deflate compressed
+// JSON of the shared search state, encoded with URL-safe base64 without padding.
+func (s *SharedState) Token() (string, errors.E) {
+	errE := s.Valid()
+	if errE != nil {
+		return "", errE
+	}
+	data, errE := x.MarshalWithoutEscapeHTML(s)
+	if errE != nil {
+		return "", errE
+	}
+	var buffer bytes.Buffer
+	writer, err := flate.NewWriter(&buffer, flate.BestCompression)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	_, err = writer.Write(data)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	token := strconv.Itoa(SharedStateVersion) + "." + base64.RawURLEncoding.EncodeToString(buffer.Bytes())
+	if len(token) > MaxSharedStateTokenLength {
+		errE := errors.WithMessage(ErrInvalidArgument, "shared search state too large")
+		errors.Details(errE)["length"] = len(token)
+		errors.Details(errE)["max"] = MaxSharedStateTokenLength
+		return "", errE
+	}
+	return token, nil
+}
+
+// ParseSharedStateToken decodes and validates a shared search state token.
+// Tokens of older versions are migrated to the current version.
+func ParseSharedStateToken(token string) (*SharedState, errors.E) {
+	if len(token) > MaxSharedStateTokenLength {
+		errE := errors.WithMessage(ErrInvalidArgument, "token too long")
+		errors.Details(errE)["length"] = len(token)
+		errors.Details(errE)["max"] = MaxSharedStateTokenLength
+		return nil, errE
+	}
+	v, encoded, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errors.WithMessage(ErrInvalidArgument, "invalid token")
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 || version > SharedStateVersion {
+		errE := errors.WithMessage(ErrInvalidArgument, "unsupported token version")
+		errors.Details(errE)["version"] = v
+		return nil, errE
+	}
+	compressed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.WrapWith(err, ErrInvalidArgument)
+	}
+	reader := flate.NewReader(bytes.NewReader(compressed))
+	defer reader.Close()
+	// We limit the size of decompressed data to protect against decompression bombs.
+	data, err := io.ReadAll(io.LimitReader(reader, maxSharedStateSize+1))
+	if err != nil {
+		return nil, errors.WrapWith(err, ErrInvalidArgument)
+	}
+	if len(data) > maxSharedStateSize {
+		return nil, errors.WithMessage(ErrInvalidArgument, "shared search state too large")
+	}
+
+	data, errE := migrateSharedState(version, data)
+	if errE != nil {
+		return nil, errE
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var s SharedState
+	err = decoder.Decode(&s)
+	if err != nil {
+		return nil, errors.WrapWith(err, ErrInvalidArgument)
+	}
+	errE = s.Valid()
+	if errE != nil {
+		return nil, errE
+	}
+	return &s, nil
+}
+
+// migrateSharedState migrates JSON of a shared search state of the given version
+// to the current version.
+func migrateSharedState(version int, data []byte) ([]byte, errors.E) {
+	// There is only one version so far. Migrations should be applied here
+	// one version after another, until the current version is reached.
+	if version != SharedStateVersion {
+		errE := errors.WithMessage(ErrInvalidArgument, "unsupported token version")
+		errors.Details(errE)["version"] = version
+		return nil, errE
+	}
+	return data, nil
+}
+
+// SharedStateFromState returns shared search state for the search state.
+// The search state has to be ready.
+func SharedStateFromState(sh *State, sorts []Sort, facets []string) (*SharedState, errors.E) {
+	if !sh.Ready() {
+		return nil, errors.WithStack(ErrNotReady)
+	}
+	s := &SharedState{
+		SearchQuery: sh.SearchQuery,
+		Filters:     sh.Filters,
+		AsOf:        sh.AsOf,
+		Sorts:       sorts,
+		Facets:      facets,
+	}
+	errE := s.Valid()
+	if errE != nil {
+		return nil, errE
+	}
+	return s, nil
+}
@@ -0,0 +1,108 @@
+package search
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestSharedStateToken(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	value := identifier.New()
+	asOf, errE := document.ParsePartialTimestamp("2020-01-01")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	shared := &SharedState{
+		SearchQuery: "foo bar",
+		Filters: &filters{ //nolint:exhaustruct
+			And: []filters{
+				{Rel: &relFilter{Prop: prop, Value: &value}},                                 //nolint:exhaustruct
+				{Not: &filters{Str: &stringFilter{Prop: prop, Str: "test", Match: "exact"}}}, //nolint:exhaustruct
+			},
+		},
+		AsOf:   &asOf,
+		Sorts:  []Sort{{Prop: prop, Meta: nil, To: nil, Desc: true}},
+		Facets: []string{"rel/" + prop.String(), "amount/" + prop.String() + "/m", "index"},
+	}
+
+	token, errE := shared.Token()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.True(t, strings.HasPrefix(token, "1."))
+	assert.NotContains(t, token, "/")
+	assert.NotContains(t, token, "+")
+
+	decoded, errE := ParseSharedStateToken(token)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, shared, decoded)
+
+	filtersJSON, errE := decoded.FiltersJSON()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Contains(t, filtersJSON, prop.String())
+}
+
+func TestSharedStateTokenInvalid(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+
+	encode := func(data string) string {
+		var buffer bytes.Buffer
+		writer, err := flate.NewWriter(&buffer, flate.BestCompression)
+		require.NoError(t, err)
+		_, err = writer.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		return base64.RawURLEncoding.EncodeToString(buffer.Bytes())
+	}
+
+	for _, token := range []string{
+		"",
+		"1",
+		"0." + encode(`{}`),
+		"2." + encode(`{}`),
+		"x." + encode(`{}`),
+		"1.!!!",
+		"1." + base64.RawURLEncoding.EncodeToString([]byte("not compressed")),
+		"1." + encode(`{"unknown":true}`),
+		"1." + encode(`{"filters":{}}`),
+		"1." + encode(`{"facets":["rel/invalid"]}`),
+		"1." + encode(`{"facets":["amount/`+prop.String()+`/@"]}`),
+		"1." + encode(`{"facets":["other"]}`),
+		"1." + encode(`{"sort":[{"prop":"`+prop.String()+`","to":"`+prop.String()+`"}]}`),
+		"1." + encode(`{"q":"`+strings.Repeat("a", 2*maxSharedStateSize)+`"}`),
+		"1." + strings.Repeat("a", MaxSharedStateTokenLength),
+	} {
+		_, errE := ParseSharedStateToken(token)
+		assert.ErrorIs(t, errE, ErrInvalidArgument, token)
+	}
+
+	// An empty shared state is valid.
+	shared, errE := ParseSharedStateToken("1." + encode(`{}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, &SharedState{}, shared) //nolint:exhaustruct
+}
+
+func TestSharedStateFromState(t *testing.T) {
+	t.Parallel()
+
+	sh := &State{ID: identifier.New(), Prompt: "test"} //nolint:exhaustruct
+	_, errE := SharedStateFromState(sh, nil, nil)
+	assert.ErrorIs(t, errE, ErrNotReady)
+
+	sh.PromptError = true
+	sh.SearchQuery = "parsed"
+	shared, errE := SharedStateFromState(sh, nil, []string{"size"})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "parsed", shared.SearchQuery)
+	assert.Equal(t, []string{"size"}, shared.Facets)
+}
@@ -32,14 +32,10 @@ func SizeFilterGet(
 ) (interface{}, map[string]interface{}, errors.E) {
 	metrics := waf.MustGetMetrics(ctx)
 
-	m := metrics.Duration(internal.MetricSearchState).Start()
-	ss, ok := searches.Load(id)
-	m.Stop()
-	if !ok {
-		// Something was not OK, so we return not found.
-		return nil, nil, errors.WithStack(ErrNotFound)
+	sh, errE := loadState(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
 	}
-	sh := ss.(*State) //nolint:errcheck,forcetypeassert
 
 	query := sh.Query()
 
@@ -48,7 +44,7 @@ func SizeFilterGet(
 	maxAggregation := elastic.NewMaxAggregation().Field("_size")
 	minMaxSearchService = minMaxSearchService.Size(0).Query(query).Aggregation("min", minAggregation).Aggregation("max", maxAggregation)
 
-	m = metrics.Duration(internal.MetricElasticSearch1).Start()
+	m := metrics.Duration(internal.MetricElasticSearch1).Start()
 	res, err := minMaxSearchService.Do(ctx)
 	m.Stop()
 	if err != nil {
@@ -58,7 +54,7 @@ func SizeFilterGet(
 
 	m = metrics.Duration(internal.MetricJSONUnmarshal1).Start()
 	var minSize floatValueAggregation
-	errE := x.Unmarshal(res.Aggregations["min"], &minSize)
+	errE = x.Unmarshal(res.Aggregations["min"], &minSize)
 	if errE != nil {
 		m.Stop()
 		return nil, nil, errE
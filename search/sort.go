@@ -0,0 +1,188 @@
+package search
+
+import (
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+)
+
+// MaxSorts is the maximum number of sort specifications in one request.
+const MaxSorts = 5
+
+// Sort is a specification how to sort search results by values of claims.
+//
+// When Meta is not set, results are sorted by timestamps of time claims with property Prop.
+// When Meta is set, results are sorted by timestamps of meta time claims with property Meta
+// of relation claims with property Prop (e.g., artworks by the date since when they are
+// in a collection). Relation claims can be further limited to those pointing to To.
+//
+// When Amount is set, results are sorted by amounts of amount claims with property Prop
+// instead (e.g., by completeness scores of documents). Meta cannot be set then.
+//
+// Documents without a matching claim are sorted last. If a document has multiple matching
+// claims, the earliest timestamp (smallest amount) is used for ascending order and the latest
+// (largest amount) for descending.
+type Sort struct {
+	Prop   identifier.Identifier  `json:"prop"             yaml:"prop"`
+	Meta   *identifier.Identifier `json:"meta,omitempty"   yaml:"meta,omitempty"`
+	To     *identifier.Identifier `json:"to,omitempty"     yaml:"to,omitempty"`
+	Amount bool                   `json:"amount,omitempty" yaml:"amount,omitempty"`
+	Desc   bool                   `json:"desc,omitempty"   yaml:"desc,omitempty"`
+}
+
+func (s Sort) Valid() errors.E {
+	if s.To != nil && s.Meta == nil {
+		return errors.New("to cannot be set without meta")
+	}
+	if s.Amount && s.Meta != nil {
+		return errors.New("meta cannot be set with amount")
+	}
+	return nil
+}
+
+// ParseSorts parses JSON with an array of sort specifications.
+// An empty string means no sort specifications.
+func ParseSorts(data string) ([]Sort, errors.E) {
+	if data == "" {
+		return nil, nil
+	}
+	var sorts []Sort
+	errE := x.UnmarshalWithoutUnknownFields([]byte(data), &sorts)
+	if errE != nil {
+		return nil, errors.WrapWith(errE, ErrInvalidArgument)
+	}
+	if len(sorts) > MaxSorts {
+		errE := errors.WithMessage(ErrInvalidArgument, "too many sort specifications")
+		errors.Details(errE)["count"] = len(sorts)
+		errors.Details(errE)["max"] = MaxSorts
+		return nil, errE
+	}
+	for i, s := range sorts {
+		errE := s.Valid()
+		if errE != nil {
+			errE = errors.WrapWith(errE, ErrInvalidArgument)
+			errors.Details(errE)["sort"] = i
+			return nil, errE
+		}
+	}
+	return sorts, nil
+}
+
+// Sorter returns the ElasticSearch sorter for the sort specification.
+// If asOf is set, only claims valid at that time are considered.
+//
+// When asOf and To are not set, materialized sort keys are used (see es.SortKeyField)
+// instead of nested claims, which is much faster. Existing documents indexed before sort
+// keys were materialized have to be reindexed (with "sort-keys" command) to be sorted correctly.
+//
+// Amounts are not materialized, so nested claims are always used when sorting by amounts.
+func (s Sort) Sorter(asOf *document.Timestamp) *elastic.FieldSort {
+	if s.Amount {
+		nested := elastic.NewNestedSort("claims.amount").Filter(
+			nestedFilter("claims.amount", asOf, elastic.NewTermQuery("claims.amount.prop.id", s.Prop)),
+		)
+		sorter := elastic.NewFieldSort("claims.amount.amount").Nested(nested).Missing("_last")
+		if s.Desc {
+			return sorter.Desc().SortMode("max")
+		}
+		return sorter.Asc().SortMode("min")
+	}
+
+	if asOf == nil && s.To == nil {
+		metaID := ""
+		if s.Meta != nil {
+			metaID = s.Meta.String()
+		}
+		// Sort keys are added to the mapping dynamically, so the field might not be mapped yet.
+		sorter := elastic.NewFieldSort(es.SortKeyField(s.Prop.String(), metaID, s.Desc)).Missing("_last").UnmappedType("long")
+		if s.Desc {
+			return sorter.Desc()
+		}
+		return sorter.Asc()
+	}
+
+	var field string
+	var nested *elastic.NestedSort
+	if s.Meta == nil {
+		field = "claims.time.timestampSeconds"
+		nested = elastic.NewNestedSort("claims.time").Filter(
+			nestedFilter("claims.time", asOf, elastic.NewTermQuery("claims.time.prop.id", s.Prop)),
+		)
+	} else {
+		query := elastic.NewBoolQuery().Must(elastic.NewTermQuery("claims.rel.prop.id", s.Prop))
+		if s.To != nil {
+			query.Must(elastic.NewTermQuery("claims.rel.to.id", s.To))
+		}
+		field = "claims.rel.metaTime.timestampSeconds"
+		nested = elastic.NewNestedSort("claims.rel").Filter(nestedFilter("claims.rel", asOf, query)).NestedSort(
+			elastic.NewNestedSort("claims.rel.metaTime").Filter(elastic.NewTermQuery("claims.rel.metaTime.prop.id", s.Meta)),
+		)
+	}
+	sorter := elastic.NewFieldSort(field).Nested(nested).Missing("_last")
+	if s.Desc {
+		return sorter.Desc().SortMode("max")
+	}
+	return sorter.Asc().SortMode("min")
+}
+
+// nestedFilter returns query limited to claims at path valid at asOf, if it is set.
+func nestedFilter(path string, asOf *document.Timestamp, query elastic.Query) elastic.Query { //nolint:ireturn
+	if asOf == nil {
+		return query
+	}
+	return elastic.NewBoolQuery().Must(query).Filter(validAtQuery(path, *asOf))
+}
+
+// TieBreakers are secondary sort specifications which order search results with equal
+// values of requested sort specifications and equal scores. After them, results are always
+// ordered by document ID, so that equal-score results are returned in the same order
+// across shards and requests.
+type TieBreakers []Sort
+
+// Validate validates tie-breakers.
+func (t TieBreakers) Validate() errors.E {
+	if len(t) > MaxSorts {
+		errE := errors.New("too many tie-breakers")
+		errors.Details(errE)["count"] = len(t)
+		errors.Details(errE)["max"] = MaxSorts
+		return errE
+	}
+	for i, s := range t {
+		errE := s.Valid()
+		if errE != nil {
+			errors.Details(errE)["tieBreaker"] = i
+			return errE
+		}
+	}
+	return nil
+}
+
+// IDSorter returns the ElasticSearch sorter by document ID, the final tie-breaker.
+func IDSorter() *elastic.FieldSort {
+	return elastic.NewFieldSort("id").Asc()
+}
+
+// Sorters returns ElasticSearch sorters for sort specifications, followed by sorting by score,
+// by tie-breakers, and finally by document ID. The order is thus total and deterministic.
+func Sorters(sorts []Sort, tieBreakers TieBreakers, asOf *document.Timestamp) []elastic.Sorter {
+	result := make([]elastic.Sorter, 0, len(sorts)+len(tieBreakers)+2) //nolint:mnd
+	for _, s := range sorts {
+		result = append(result, s.Sorter(asOf))
+	}
+	result = append(result, elastic.NewScoreSort())
+	for _, s := range tieBreakers {
+		result = append(result, s.Sorter(asOf))
+	}
+	return append(result, IDSorter())
+}
+
+// SortedSearch configures searchService to sort by sort specifications and by score,
+// with ties broken by tie-breakers and document ID (see Sorters).
+func SortedSearch(searchService *elastic.SearchService, sorts []Sort, tieBreakers TieBreakers, asOf *document.Timestamp) *elastic.SearchService {
+	// Scores are not computed by default when sorting by fields.
+	return searchService.SortBy(Sorters(sorts, tieBreakers, asOf)...).TrackScores(true)
+}
@@ -0,0 +1,174 @@
+package search_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestParseSorts(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	meta := identifier.New()
+
+	sorts, errE := search.ParseSorts("")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Nil(t, sorts)
+
+	sorts, errE = search.ParseSorts(`[{"prop":"` + prop.String() + `","meta":"` + meta.String() + `","desc":true}]`)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []search.Sort{{Prop: prop, Meta: &meta, To: nil, Amount: false, Desc: true}}, sorts)
+
+	for _, data := range []string{
+		`{}`,
+		`[{"prop":"` + prop.String() + `","foo":1}]`,
+		`[{"prop":"` + prop.String() + `","to":"` + meta.String() + `"}]`,
+		`[{"prop":"` + prop.String() + `","meta":"` + meta.String() + `","amount":true}]`,
+		"[" + strings.Repeat(`{"prop":"`+prop.String()+`"},`, search.MaxSorts) + `{"prop":"` + prop.String() + `"}]`,
+	} {
+		_, errE := search.ParseSorts(data)
+		assert.ErrorIs(t, errE, search.ErrInvalidArgument, data)
+	}
+}
+
+func TestSortSorter(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	meta := identifier.New()
+	to := identifier.New()
+
+	// Materialized sort keys are used when possible.
+	source, err := search.Sort{Prop: prop, Meta: nil, To: nil, Amount: false, Desc: false}.Sorter(nil).Source()
+	require.NoError(t, err)
+	data, err := json.Marshal(source)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"sortKeys.`+prop.String()+`_min": {
+			"missing": "_last",
+			"order": "asc",
+			"unmapped_type": "long"
+		}
+	}`, string(data))
+
+	source, err = search.Sort{Prop: prop, Meta: &meta, To: nil, Amount: false, Desc: true}.Sorter(nil).Source()
+	require.NoError(t, err)
+	data, err = json.Marshal(source)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"sortKeys.`+prop.String()+`_`+meta.String()+`_max": {
+			"missing": "_last",
+			"order": "desc",
+			"unmapped_type": "long"
+		}
+	}`, string(data))
+
+	// Validity of claims is not materialized, so nested claims are used.
+	asOf, errE := document.ParsePartialTimestamp("2020-01-01")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	source, err = search.Sort{Prop: prop, Meta: nil, To: nil, Amount: false, Desc: false}.Sorter(&asOf).Source()
+	require.NoError(t, err)
+	assert.Contains(t, source, "claims.time.timestampSeconds")
+
+	source, err = search.Sort{Prop: prop, Meta: &meta, To: &to, Amount: false, Desc: true}.Sorter(nil).Source()
+	require.NoError(t, err)
+	data, err = json.Marshal(source)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"claims.rel.metaTime.timestampSeconds": {
+			"missing": "_last",
+			"mode": "max",
+			"nested": {
+				"filter": {
+					"bool": {
+						"must": [
+							{"term": {"claims.rel.prop.id": "`+prop.String()+`"}},
+							{"term": {"claims.rel.to.id": "`+to.String()+`"}}
+						]
+					}
+				},
+				"nested": {
+					"filter": {"term": {"claims.rel.metaTime.prop.id": "`+meta.String()+`"}},
+					"path": "claims.rel.metaTime"
+				},
+				"path": "claims.rel"
+			},
+			"order": "desc"
+		}
+	}`, string(data))
+
+	// Amounts are not materialized, so nested claims are used.
+	source, err = search.Sort{Prop: prop, Meta: nil, To: nil, Amount: true, Desc: true}.Sorter(nil).Source()
+	require.NoError(t, err)
+	data, err = json.Marshal(source)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"claims.amount.amount": {
+			"missing": "_last",
+			"mode": "max",
+			"nested": {
+				"filter": {"term": {"claims.amount.prop.id": "`+prop.String()+`"}},
+				"path": "claims.amount"
+			},
+			"order": "desc"
+		}
+	}`, string(data))
+}
+
+func TestSorters(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	tieBreaker := identifier.New()
+
+	sorters := search.Sorters(
+		[]search.Sort{{Prop: prop, Meta: nil, To: nil, Amount: false, Desc: true}},
+		search.TieBreakers{{Prop: tieBreaker, Meta: nil, To: nil, Amount: false, Desc: false}},
+		nil,
+	)
+	sources := make([]interface{}, len(sorters))
+	for i, sorter := range sorters {
+		source, err := sorter.Source()
+		require.NoError(t, err)
+		sources[i] = source
+	}
+	data, err := json.Marshal(sources)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"sortKeys.`+prop.String()+`_max": {"missing": "_last", "order": "desc", "unmapped_type": "long"}},
+		{"_score": {"order": "desc"}},
+		{"sortKeys.`+tieBreaker.String()+`_min": {"missing": "_last", "order": "asc", "unmapped_type": "long"}},
+		{"id": {"order": "asc"}}
+	]`, string(data))
+
+	// Without sort specifications results are still ordered deterministically.
+	assert.Len(t, search.Sorters(nil, nil, nil), 2)
+}
+
+func TestTieBreakersValidate(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+	meta := identifier.New()
+
+	assert.NoError(t, search.TieBreakers{}.Validate())
+	assert.NoError(t, search.TieBreakers{{Prop: prop, Meta: &meta, To: &meta, Amount: false, Desc: false}}.Validate())
+
+	errE := search.TieBreakers{{Prop: prop, Meta: nil, To: &meta, Amount: false, Desc: false}}.Validate()
+	assert.EqualError(t, errE, "to cannot be set without meta")
+
+	tieBreakers := search.TieBreakers{}
+	for range search.MaxSorts + 1 {
+		tieBreakers = append(tieBreakers, search.Sort{Prop: prop, Meta: nil, To: nil, Amount: false, Desc: false})
+	}
+	errE = tieBreakers.Validate()
+	assert.EqualError(t, errE, "too many tie-breakers")
+}
@@ -21,18 +21,19 @@ type searchStringFilterResult struct {
 
 //nolint:dupl
 func StringFilterGet(
-	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id, prop identifier.Identifier,
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id, prop identifier.Identifier, facetSize int,
 ) (interface{}, map[string]interface{}, errors.E) {
 	metrics := waf.MustGetMetrics(ctx)
 
-	m := metrics.Duration(internal.MetricSearchState).Start()
-	ss, ok := searches.Load(id)
-	m.Stop()
-	if !ok {
-		// Something was not OK, so we return not found.
-		return nil, nil, errors.WithStack(ErrNotFound)
+	errE := CheckRestricted(ctx, prop)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	sh, errE := loadState(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
 	}
-	sh := ss.(*State) //nolint:errcheck,forcetypeassert
 
 	query := sh.Query()
 
@@ -43,7 +44,7 @@ func StringFilterGet(
 			elastic.NewTermQuery("claims.string.prop.id", prop),
 		).SubAggregation(
 			"props",
-			elastic.NewTermsAggregation().Field("claims.string.string").Size(MaxResultsCount).OrderByAggregation("docs", false).SubAggregation(
+			elastic.NewTermsAggregation().Field("claims.string.string").Size(facetSize).OrderByAggregation("docs", false).SubAggregation(
 				"docs",
 				elastic.NewReverseNestedAggregation(),
 			),
@@ -56,7 +57,7 @@ func StringFilterGet(
 	)
 	searchService = searchService.Size(0).Query(query).Aggregation("string", aggregation)
 
-	m = metrics.Duration(internal.MetricElasticSearch).Start()
+	m := metrics.Duration(internal.MetricElasticSearch).Start()
 	res, err := searchService.Do(ctx)
 	m.Stop()
 	if err != nil {
@@ -66,7 +67,7 @@ func StringFilterGet(
 
 	m = metrics.Duration(internal.MetricJSONUnmarshal).Start()
 	var str filteredTermAggregations
-	errE := x.Unmarshal(res.Aggregations["string"], &str)
+	errE = x.Unmarshal(res.Aggregations["string"], &str)
 	m.Stop()
 	if errE != nil {
 		return nil, nil, errE
@@ -0,0 +1,49 @@
+//nolint:testpackage
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+)
+
+func TestStringFilterMatch(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+
+	for _, tt := range []struct {
+		match string
+		query string
+	}{
+		{"", `{"term":{"claims.string.string":"Painting"}}`},
+		{StringMatchExact, `{"term":{"claims.string.string":"Painting"}}`},
+		{StringMatchPhrase, `{"match_phrase":{"claims.string.string.text":{"query":"Painting"}}}`},
+		{StringMatchPrefix, `{"prefix":{"claims.string.string":"Painting"}}`},
+	} {
+		t.Run(tt.match, func(t *testing.T) {
+			t.Parallel()
+
+			f, errE := parseFilters(`{"str":{"prop":"` + prop.String() + `","str":"Painting","match":"` + tt.match + `"}}`)
+			require.NoError(t, errE, "% -+#.1v", errE)
+
+			source, err := f.ToQuery(nil).Source()
+			require.NoError(t, err)
+			data, err := json.Marshal(source)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"nested":{"path":"claims.string","query":{"bool":{"must":[`+
+				`{"term":{"claims.string.prop.id":"`+prop.String()+`"}},`+tt.query+`]}}}}`, string(data))
+		})
+	}
+
+	for _, filtersJSON := range []string{
+		`{"str":{"prop":"` + prop.String() + `","str":"Painting","match":"fuzzy"}}`,
+		`{"str":{"prop":"` + prop.String() + `","none":true,"match":"phrase"}}`,
+	} {
+		_, errE := parseFilters(filtersJSON)
+		assert.ErrorIs(t, errE, ErrInvalidArgument, filtersJSON)
+	}
+}
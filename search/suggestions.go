@@ -0,0 +1,262 @@
+package search
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	// MaxPropertySuggestions is the maximum number of properties suggested in a validation error.
+	MaxPropertySuggestions = 5
+
+	// Minimal similarity of names of properties to be suggested.
+	minNameSimilarity = 0.5
+	// Minimal similarity of IDs of properties to be suggested. IDs are random,
+	// so only IDs with few typos (e.g., when copied by hand) are similar enough.
+	minIDSimilarity = 0.8
+	// Minimal length of a name contained in another name for them to be similar.
+	minContainedLength = 3
+)
+
+// PropertyName has the mnemonic and the English name of a property.
+type PropertyName struct {
+	// Mnemonic is set for core properties.
+	Mnemonic string
+	Name     string
+}
+
+// PropertyNames maps property IDs to their names. It is used to suggest
+// properties when filters use an unknown property or a property of an incompatible type.
+type PropertyNames map[identifier.Identifier]PropertyName
+
+// NewPropertyNames returns names of the given property documents.
+func NewPropertyNames(properties map[identifier.Identifier]document.D) PropertyNames {
+	n := PropertyNames{}
+	for _, property := range properties {
+		n.Add(&property)
+	}
+	return n
+}
+
+// Add adds the name of the property document.
+func (n PropertyNames) Add(property *document.D) {
+	name := PropertyName{
+		Mnemonic: string(property.Mnemonic),
+		Name:     "",
+	}
+	for _, claim := range property.Get(document.GetCorePropertyID("NAME")) {
+		if c, ok := claim.(*document.TextClaim); ok && c.HTML["en"] != "" {
+			name.Name = html.UnescapeString(c.HTML["en"])
+			break
+		}
+	}
+	n[property.ID] = name
+}
+
+// PropertySuggestion is a property suggested instead of the one used by a filter.
+type PropertySuggestion struct {
+	ID       string `json:"id"`
+	Mnemonic string `json:"mnemonic,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// normalizePropertyName makes names and mnemonics comparable (e.g., "DATE_OF_BIRTH" and "date of birth").
+func normalizePropertyName(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-'
+	}), " ")
+}
+
+// levenshtein returns the edit distance between strings a and b, in runes.
+func levenshtein(a, b string) int {
+	ar := []rune(a)
+	br := []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := range ar {
+		current[0] = i + 1
+		for j := range br {
+			cost := 1
+			if ar[i] == br[j] {
+				cost = 0
+			}
+			current[j+1] = min(previous[j+1]+1, current[j]+1, previous[j]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
+
+// similarity returns similarity between 0 and 1 of strings a and b based on their edit distance.
+// A string containing the other (e.g., "birth" and "date of birth") is considered similar.
+func similarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	aLength := utf8.RuneCountInString(a)
+	bLength := utf8.RuneCountInString(b)
+	s := 1 - float64(levenshtein(a, b))/float64(max(aLength, bLength))
+	if min(aLength, bLength) >= minContainedLength && (strings.Contains(a, b) || strings.Contains(b, a)) {
+		s = max(s, minNameSimilarity)
+	}
+	return s
+}
+
+// Suggest returns up to MaxPropertySuggestions properties accepted by accept (if set) and
+// most similar to the query by their mnemonic or name, or by their ID if the query is an ID.
+func (n PropertyNames) Suggest(query string, accept func(identifier.Identifier) bool) []PropertySuggestion {
+	type scored struct {
+		PropertySuggestion
+
+		score float64
+	}
+
+	isID := identifier.Valid(query)
+	normalized := normalizePropertyName(query)
+	candidates := []scored{}
+	for id, name := range n {
+		if accept != nil && !accept(id) {
+			continue
+		}
+		var score float64
+		if isID {
+			score = similarity(query, id.String())
+			if score < minIDSimilarity {
+				continue
+			}
+		} else {
+			score = max(similarity(normalized, normalizePropertyName(name.Mnemonic)), similarity(normalized, normalizePropertyName(name.Name)))
+			if score < minNameSimilarity {
+				continue
+			}
+		}
+		candidates = append(candidates, scored{
+			PropertySuggestion: PropertySuggestion{
+				ID:       id.String(),
+				Mnemonic: name.Mnemonic,
+				Name:     name.Name,
+			},
+			score: score,
+		})
+	}
+
+	slices.SortFunc(candidates, func(a, b scored) int {
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	suggestions := make([]PropertySuggestion, 0, min(len(candidates), MaxPropertySuggestions))
+	for _, c := range candidates[:min(len(candidates), MaxPropertySuggestions)] {
+		suggestions = append(suggestions, c.PropertySuggestion)
+	}
+	return suggestions
+}
+
+// suggestFor returns properties similar to the property prop by name (or by ID if
+// the property has no name) which are accepted by accept. The property itself is not suggested.
+func (n PropertyNames) suggestFor(prop identifier.Identifier, accept func(identifier.Identifier) bool) []PropertySuggestion {
+	query := prop.String()
+	if name, ok := n[prop]; ok {
+		query = name.Name
+		if query == "" {
+			query = name.Mnemonic
+		}
+	}
+	if query == "" {
+		return nil
+	}
+	return n.Suggest(query, func(id identifier.Identifier) bool {
+		return id != prop && (accept == nil || accept(id))
+	})
+}
+
+// acceptClaimType returns a function accepting properties which are declared
+// in the registry to be used with the claim type.
+func acceptClaimType(registry document.PropertyRegistry, claimType string) func(identifier.Identifier) bool {
+	return func(id identifier.Identifier) bool {
+		dataType, ok := registry.Lookup(id)
+		return ok && dataType.ClaimTypes[claimType]
+	}
+}
+
+// knownProperty returns true if the property is in the registry or has a name.
+func knownProperty(registry document.PropertyRegistry, names PropertyNames, prop identifier.Identifier) bool {
+	if _, ok := registry.Lookup(prop); ok {
+		return true
+	}
+	_, ok := names[prop]
+	return ok
+}
+
+// unknownPropertyError returns ErrUnknownProperty for the property (which might not be a valid ID)
+// with suggestions of properties with similar names or IDs.
+func unknownPropertyError(names PropertyNames, prop string) errors.E {
+	errE := errors.WithStack(ErrUnknownProperty)
+	errors.Details(errE)["prop"] = prop
+	if suggestions := names.Suggest(prop, nil); len(suggestions) > 0 {
+		errors.Details(errE)["suggestions"] = suggestions
+	}
+	return errE
+}
+
+// checkFilterProps returns ErrUnknownProperty for the first "prop" value of JSON filters
+// which is not a known property. Invalid JSON is not checked.
+func checkFilterProps(filtersJSON string, registry document.PropertyRegistry, names PropertyNames) errors.E {
+	var value interface{}
+	if x.Unmarshal([]byte(filtersJSON), &value) != nil {
+		return nil
+	}
+	return checkFilterPropsValue(value, registry, names)
+}
+
+func checkFilterPropsValue(value interface{}, registry document.PropertyRegistry, names PropertyNames) errors.E {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, e := range v {
+			errE := checkFilterPropsValue(e, registry, names)
+			if errE != nil {
+				return errE
+			}
+		}
+	case map[string]interface{}:
+		// We iterate in sorted order so that the same error is returned every time.
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			if prop, ok := v[key].(string); ok && key == "prop" {
+				id, errE := identifier.FromString(prop)
+				if errE != nil || !knownProperty(registry, names, id) {
+					return unknownPropertyError(names, prop)
+				}
+				continue
+			}
+			errE := checkFilterPropsValue(v[key], registry, names)
+			if errE != nil {
+				return errE
+			}
+		}
+	}
+	return nil
+}
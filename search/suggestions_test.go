@@ -0,0 +1,99 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestPropertyNamesSuggest(t *testing.T) {
+	t.Parallel()
+
+	names := search.NewPropertyNames(document.CoreProperties)
+	mediaType := document.GetCorePropertyID("MEDIA_TYPE")
+
+	for _, query := range []string{"MEDIA_TYP", "media type", "Media-Type"} {
+		suggestions := names.Suggest(query, nil)
+		require.NotEmpty(t, suggestions, query)
+		assert.LessOrEqual(t, len(suggestions), search.MaxPropertySuggestions)
+		assert.Equal(t, search.PropertySuggestion{ID: mediaType.String(), Mnemonic: "MEDIA_TYPE", Name: "media type"}, suggestions[0], query)
+	}
+
+	// An ID with a typo.
+	id := []byte(mediaType.String())
+	id[3] = '1'
+	if string(id) == mediaType.String() {
+		id[3] = '2'
+	}
+	suggestions := names.Suggest(string(id), nil)
+	require.NotEmpty(t, suggestions)
+	assert.Equal(t, mediaType.String(), suggestions[0].ID)
+
+	assert.Empty(t, names.Suggest("zzzzzzzzzzzzzzzzzz", nil))
+	assert.Empty(t, names.Suggest("media type", func(identifier.Identifier) bool { return false }))
+}
+
+func TestCheckFilterPropertiesSuggestions(t *testing.T) {
+	t.Parallel()
+
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+	names := search.NewPropertyNames(document.CoreProperties)
+	mediaType := document.GetCorePropertyID("MEDIA_TYPE")
+
+	errE := search.CheckFilterProperties(`{"str": {"prop": "`+mediaType.String()+`", "str": "image/png"}}`, registry, names)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = search.CheckFilterProperties(`{"and": [{"index": {"str": "foo"}}, {"str": {"prop": "MEDIA_TYP", "str": "image/png"}}]}`, registry, names)
+	require.ErrorIs(t, errE, search.ErrUnknownProperty)
+	details := errors.AllDetails(errE)
+	assert.Equal(t, "MEDIA_TYP", details["prop"])
+	suggestions, ok := details["suggestions"].([]search.PropertySuggestion)
+	require.True(t, ok)
+	require.NotEmpty(t, suggestions)
+	assert.Equal(t, mediaType.String(), suggestions[0].ID)
+
+	unknown := identifier.New()
+	errE = search.CheckFilterProperties(`{"not": {"rel": {"prop": "`+unknown.String()+`", "none": true}}}`, registry, names)
+	assert.ErrorIs(t, errE, search.ErrUnknownProperty)
+	// Without names properties are not checked to be known.
+	errE = search.CheckFilterProperties(`{"not": {"rel": {"prop": "`+unknown.String()+`", "none": true}}}`, registry, nil)
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	// A time filter on a property with string claims suggests properties with time claims and a similar name.
+	errE = search.CheckFilterProperties(`{"time": {"prop": "`+mediaType.String()+`", "none": true}}`, registry, names)
+	require.ErrorIs(t, errE, search.ErrFilterDataType)
+	suggestions, _ = errors.AllDetails(errE)["suggestions"].([]search.PropertySuggestion)
+	for _, suggestion := range suggestions {
+		id := identifier.MustFromString(suggestion.ID)
+		dataType, ok := registry.Lookup(id)
+		require.True(t, ok)
+		assert.True(t, dataType.ClaimTypes["time"], suggestion.Name)
+		assert.NotEqual(t, mediaType, id)
+	}
+}
+
+func TestQueryNodeCheckSuggestions(t *testing.T) {
+	t.Parallel()
+
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+	names := search.NewPropertyNames(document.CoreProperties)
+
+	// A property without a declared data type is known when it has a name.
+	custom := identifier.New()
+	names[custom] = search.PropertyName{Mnemonic: "", Name: "custom"}
+	node, errE := search.ParseQueryNode([]byte(`{"str": {"prop": "` + custom.String() + `", "none": true}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = node.Check(registry, names, search.Limits{}) //nolint:exhaustruct
+	assert.NoError(t, errE, "% -+#.1v", errE)
+
+	node, errE = search.ParseQueryNode([]byte(`{"rel": {"prop": "` + identifier.New().String() + `", "none": true}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	errE = node.Check(registry, names, search.Limits{}) //nolint:exhaustruct
+	assert.ErrorIs(t, errE, search.ErrUnknownProperty)
+}
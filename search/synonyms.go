@@ -0,0 +1,67 @@
+package search
+
+import (
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+)
+
+// ErrInvalidSynonyms is returned when a synonym set is invalid.
+var ErrInvalidSynonyms = errors.Base("invalid synonyms")
+
+// SynonymSet is a named set of synonym rules applied to search queries.
+//
+// Rules are in Solr format: either a comma-separated list of equivalent terms
+// (e.g., "NYC, New York City") or an explicit mapping (e.g., "NYC => New York City").
+type SynonymSet struct {
+	Name  string   `json:"name"`
+	Rules []string `json:"rules"`
+}
+
+func validateTerms(terms string) bool {
+	for _, term := range strings.Split(terms, ",") {
+		if strings.TrimSpace(term) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate validates the synonym set and normalizes whitespace in its rules.
+func (s *SynonymSet) Validate() errors.E {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return errors.WithMessage(ErrInvalidSynonyms, "missing name")
+	}
+
+	for i, rule := range s.Rules {
+		rule = strings.TrimSpace(rule)
+		s.Rules[i] = rule
+
+		valid := !strings.ContainsAny(rule, "\n\r")
+		if left, right, ok := strings.Cut(rule, "=>"); ok {
+			valid = valid && !strings.Contains(right, "=>") && validateTerms(left) && validateTerms(right)
+		} else {
+			valid = valid && strings.Contains(rule, ",") && validateTerms(rule)
+		}
+		if !valid {
+			errE := errors.WithMessage(ErrInvalidSynonyms, "invalid rule")
+			errors.Details(errE)["rule"] = rule
+			errors.Details(errE)["index"] = i
+			return errE
+		}
+	}
+
+	return nil
+}
+
+// SynonymRules returns rules of all synonym sets, sorted and without duplicates.
+func SynonymRules(sets []SynonymSet) []string {
+	rules := []string{}
+	for _, set := range sets {
+		rules = append(rules, set.Rules...)
+	}
+	slices.Sort(rules)
+	return slices.Compact(rules)
+}
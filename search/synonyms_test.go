@@ -0,0 +1,55 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestSynonymSetValidate(t *testing.T) {
+	t.Parallel()
+
+	set := search.SynonymSet{
+		Name: " places ",
+		Rules: []string{
+			" NYC, New York City ",
+			"LA, L.A. => Los Angeles",
+		},
+	}
+	errE := set.Validate()
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, search.SynonymSet{
+		Name: "places",
+		Rules: []string{
+			"NYC, New York City",
+			"LA, L.A. => Los Angeles",
+		},
+	}, set)
+
+	for _, set := range []search.SynonymSet{
+		{Name: "", Rules: []string{"a, b"}},
+		{Name: "x", Rules: []string{"a"}},
+		{Name: "x", Rules: []string{"a,"}},
+		{Name: "x", Rules: []string{"a, , b"}},
+		{Name: "x", Rules: []string{"a =>"}},
+		{Name: "x", Rules: []string{"=> b"}},
+		{Name: "x", Rules: []string{"a => b => c"}},
+		{Name: "x", Rules: []string{"a, b\nc, d"}},
+	} {
+		errE := set.Validate()
+		assert.ErrorIs(t, errE, search.ErrInvalidSynonyms, set.Rules)
+	}
+}
+
+func TestSynonymRules(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{}, search.SynonymRules(nil))
+	assert.Equal(t, []string{"NYC, New York City", "UK, United Kingdom"}, search.SynonymRules([]search.SynonymSet{
+		{Name: "a", Rules: []string{"UK, United Kingdom", "NYC, New York City"}},
+		{Name: "b", Rules: []string{"NYC, New York City"}},
+	}))
+}
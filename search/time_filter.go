@@ -2,6 +2,7 @@ package search
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
@@ -16,21 +17,38 @@ import (
 	internal "gitlab.com/peerdb/peerdb/internal/store"
 )
 
+// Timestamps are aggregated using numeric fields with seconds since Unix epoch
+// and years because ElasticSearch date fields cannot represent all timestamps.
+
 //nolint:tagliatelle
 type minMaxTimeAggregations struct {
 	Filter struct {
 		Count int64 `json:"doc_count"`
 		Min   struct {
-			Value document.Timestamp `json:"value_as_string"`
+			Value float64 `json:"value"`
 		} `json:"min"`
 		Max struct {
-			Value document.Timestamp `json:"value_as_string"`
+			Value float64 `json:"value"`
 		} `json:"max"`
 	} `json:"filter"`
 }
 
 //nolint:tagliatelle
 type histogramTimeAggregations struct {
+	Filter struct {
+		Hist struct {
+			Buckets []struct {
+				Key  float64 `json:"key"`
+				Docs struct {
+					Count int64 `json:"doc_count"`
+				} `json:"docs"`
+			} `json:"buckets"`
+		} `json:"hist"`
+	} `json:"filter"`
+}
+
+//nolint:tagliatelle
+type dateHistogramTimeAggregations struct {
 	Filter struct {
 		Hist struct {
 			Buckets []struct {
@@ -43,24 +61,302 @@ type histogramTimeAggregations struct {
 	} `json:"filter"`
 }
 
+//nolint:tagliatelle
+type rangeTimeAggregations struct {
+	Filter struct {
+		Hist struct {
+			Buckets []struct {
+				From float64 `json:"from"`
+				Docs struct {
+					Count int64 `json:"doc_count"`
+				} `json:"docs"`
+			} `json:"buckets"`
+		} `json:"hist"`
+	} `json:"filter"`
+}
+
 type histogramTimeResult struct {
 	Min   document.Timestamp `json:"min"`
 	Count int64              `json:"count"`
 }
 
+// Intervals supported by TimeFilterGet.
+const (
+	TimeIntervalAuto    = "auto"
+	TimeIntervalEqual   = "equal"
+	TimeIntervalMonth   = "month"
+	TimeIntervalYear    = "year"
+	TimeIntervalDecade  = "decade"
+	TimeIntervalCentury = "century"
+)
+
+// maxCalendarBuckets is the maximum number of monthly or yearly buckets
+// we let ElasticSearch compute for a calendar histogram.
+const maxCalendarBuckets = 100 * histogramBins
+
+// autoTimeInterval selects the calendar interval based on the range of data so that
+// the histogram has at most histogramBins bins. It returns TimeIntervalEqual
+// if the range is too large for any of the calendar intervals.
+func autoTimeInterval(minTime, maxTime time.Time) string {
+	years := int64(maxTime.Year()) - int64(minTime.Year()) + 1
+	switch {
+	case years*12 <= histogramBins: //nolint:mnd
+		return TimeIntervalMonth
+	case years <= histogramBins:
+		return TimeIntervalYear
+	case years <= 10*histogramBins: //nolint:mnd
+		return TimeIntervalDecade
+	case years <= 100*histogramBins: //nolint:mnd
+		return TimeIntervalCentury
+	default:
+		return TimeIntervalEqual
+	}
+}
+
+// Range of years for which ElasticSearch date fields are used. Timestamps outside of it
+// (e.g., BCE years) cannot be parsed with the format of date fields and are not indexed in them.
+const (
+	minDateFieldYear = 1
+	maxDateFieldYear = 9999
+)
+
+// inDateFieldRange returns true if the timestamp is indexed in ElasticSearch date fields.
+func inDateFieldRange(timestamp document.Timestamp) bool {
+	year := time.Time(timestamp).Year()
+	return year >= minDateFieldYear && year <= maxDateFieldYear
+}
+
+// floorYear returns the first year of the period of width years which contains the year.
+func floorYear(year, width int) int {
+	m := year % width
+	if m < 0 {
+		m += width
+	}
+	return year - m
+}
+
+// timeHistogram is a histogram of time claims between minTime and maxTime.
+type timeHistogram struct {
+	minTime document.Timestamp
+	maxTime document.Timestamp
+
+	// interval is TimeIntervalEqual or a calendar interval.
+	interval string
+
+	// minValue and seconds are the start and the width of bins of equal duration.
+	// We use int64 and not time.Duration because it cannot hold durations we need.
+	// time.Duration stores durations as nanosecond, but we want seconds here.
+	// See: https://github.com/elastic/elasticsearch/issues/83101
+	minValue int64
+	seconds  int64
+
+	// width is the number of years in a bin of yearly and longer calendar intervals.
+	width int
+
+	// monthRanges is true when bins of the monthly interval are computed from the numeric seconds field
+	// because the range of data is not supported by the date field.
+	monthRanges bool
+}
+
+// newTimeHistogram returns a histogram with the interval for time claims between minTime and maxTime.
+// Interval TimeIntervalAuto selects the interval based on the range of data (see autoTimeInterval).
+func newTimeHistogram(minTime, maxTime document.Timestamp, interval string) (*timeHistogram, errors.E) {
+	if interval == TimeIntervalAuto {
+		interval = autoTimeInterval(time.Time(minTime), time.Time(maxTime))
+	}
+
+	h := &timeHistogram{
+		minTime:     minTime,
+		maxTime:     maxTime,
+		interval:    interval,
+		minValue:    0,
+		seconds:     0,
+		width:       1,
+		monthRanges: interval == TimeIntervalMonth && (!inDateFieldRange(minTime) || !inDateFieldRange(maxTime)),
+	}
+
+	if interval == TimeIntervalEqual {
+		h.minValue = time.Time(minTime).Unix()
+		if minTime == maxTime {
+			h.seconds = 1
+		} else {
+			maxValue := time.Time(maxTime).Unix() + 1
+			h.seconds = (maxValue - h.minValue) / histogramBins
+			seconds2 := (time.Time(maxTime).Unix() - h.minValue) / histogramBins
+			if h.seconds == seconds2 {
+				h.seconds = seconds2 + 1
+			}
+		}
+		return h, nil
+	}
+
+	buckets := int64(time.Time(maxTime).Year()) - int64(time.Time(minTime).Year()) + 1
+	switch interval {
+	case TimeIntervalMonth:
+		buckets *= 12
+	case TimeIntervalDecade:
+		h.width = 10
+	case TimeIntervalCentury:
+		h.width = 100
+	}
+	if buckets > maxCalendarBuckets {
+		errE := errors.WithMessage(ErrInvalidArgument, "interval too small for the range of data")
+		errors.Details(errE)["interval"] = interval
+		return nil, errE
+	}
+
+	return h, nil
+}
+
+// aggregation returns the aggregation computing the histogram of time claims with the property.
+func (h *timeHistogram) aggregation(prop string) elastic.Aggregation {
+	var hist elastic.Aggregation
+	switch h.interval {
+	case TimeIntervalEqual:
+		hist = elastic.NewHistogramAggregation().Field("claims.time.timestampSeconds").
+			Offset(float64(floorMod(h.minValue, h.seconds))).Interval(float64(h.seconds)).SubAggregation(
+			"docs",
+			elastic.NewReverseNestedAggregation(),
+		)
+	case TimeIntervalMonth:
+		if h.monthRanges {
+			// Outside of years supported by the date field, we compute monthly bins
+			// from the numeric seconds field, one range for every month.
+			ranges := elastic.NewRangeAggregation().Field("claims.time.timestampSeconds")
+			minTime := time.Time(h.minTime)
+			start := time.Date(minTime.Year(), minTime.Month(), 1, 0, 0, 0, 0, time.UTC)
+			for !start.After(time.Time(h.maxTime)) {
+				end := start.AddDate(0, 1, 0)
+				ranges = ranges.AddRange(start.Unix(), end.Unix())
+				start = end
+			}
+			hist = ranges.SubAggregation(
+				"docs",
+				elastic.NewReverseNestedAggregation(),
+			)
+			break
+		}
+		// Otherwise monthly intervals use the date field.
+		hist = elastic.NewDateHistogramAggregation().Field("claims.time.timestamp").CalendarInterval("1M").SubAggregation(
+			"docs",
+			elastic.NewReverseNestedAggregation(),
+		)
+	default:
+		// Yearly and longer intervals use the numeric year field so that they work for all years.
+		hist = elastic.NewHistogramAggregation().Field("claims.time.timestampYear").Interval(float64(h.width)).SubAggregation(
+			"docs",
+			elastic.NewReverseNestedAggregation(),
+		)
+	}
+
+	return elastic.NewNestedAggregation().Path("claims.time").SubAggregation(
+		"filter",
+		elastic.NewFilterAggregation().Filter(
+			elastic.NewTermQuery("claims.time.prop.id", prop),
+		).SubAggregation(
+			"hist",
+			hist,
+		),
+	)
+}
+
+// results returns histogram bins from the result of the aggregation.
+func (h *timeHistogram) results(data json.RawMessage) ([]histogramTimeResult, errors.E) {
+	results := []histogramTimeResult{}
+	if h.monthRanges {
+		var histogram rangeTimeAggregations
+		errE := x.Unmarshal(data, &histogram)
+		if errE != nil {
+			return nil, errE
+		}
+		for _, bucket := range histogram.Filter.Hist.Buckets {
+			results = append(results, histogramTimeResult{
+				Min:   secondsToTimestamp(bucket.From),
+				Count: bucket.Docs.Count,
+			})
+		}
+		return results, nil
+	}
+	if h.interval == TimeIntervalMonth {
+		var histogram dateHistogramTimeAggregations
+		errE := x.Unmarshal(data, &histogram)
+		if errE != nil {
+			return nil, errE
+		}
+		for _, bucket := range histogram.Filter.Hist.Buckets {
+			results = append(results, histogramTimeResult{
+				Min:   bucket.Key,
+				Count: bucket.Docs.Count,
+			})
+		}
+		return results, nil
+	}
+
+	var histogram histogramTimeAggregations
+	errE := x.Unmarshal(data, &histogram)
+	if errE != nil {
+		return nil, errE
+	}
+	for _, bucket := range histogram.Filter.Hist.Buckets {
+		var minTime document.Timestamp
+		if h.interval == TimeIntervalEqual {
+			minTime = secondsToTimestamp(bucket.Key)
+		} else {
+			// Histogram keys are already multiples of width, but we floor them
+			// to be sure negative years are handled correctly.
+			year := floorYear(int(bucket.Key), h.width)
+			minTime = document.Timestamp(time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC))
+		}
+		results = append(results, histogramTimeResult{
+			Min:   minTime,
+			Count: bucket.Docs.Count,
+		})
+	}
+	return results, nil
+}
+
+// intervalString returns the interval of the histogram as reported to clients: the calendar
+// interval or the duration of bins of equal duration. It is empty when all time claims are equal.
+func (h *timeHistogram) intervalString() string {
+	if h.interval != TimeIntervalEqual {
+		return h.interval
+	}
+	if h.minTime == h.maxTime {
+		return ""
+	}
+	return fmt.Sprintf("%ds", h.seconds)
+}
+
+// TimeFilterGet returns a histogram of time claims with the given property for documents matching the search.
+//
+// Interval can be TimeIntervalEqual for a histogram with at most histogramBins bins of equal duration, or
+// a calendar interval (month, year, decade, or century). Interval TimeIntervalAuto (the default when interval
+// is empty) selects a calendar interval based on the range of data, falling back to bins of equal duration
+// when the range is too large.
 func TimeFilterGet(
-	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id, prop identifier.Identifier,
+	ctx context.Context, getSearchService func() (*elastic.SearchService, int64), id, prop identifier.Identifier, interval string,
 ) (interface{}, map[string]interface{}, errors.E) {
+	switch interval {
+	case "":
+		interval = TimeIntervalAuto
+	case TimeIntervalAuto, TimeIntervalEqual, TimeIntervalMonth, TimeIntervalYear, TimeIntervalDecade, TimeIntervalCentury:
+	default:
+		errE := errors.WithMessage(ErrInvalidArgument, "unknown interval")
+		errors.Details(errE)["interval"] = interval
+		return nil, nil, errE
+	}
+
 	metrics := waf.MustGetMetrics(ctx)
 
-	m := metrics.Duration(internal.MetricSearchState).Start()
-	ss, ok := searches.Load(id)
-	m.Stop()
-	if !ok {
-		// Something was not OK, so we return not found.
-		return nil, nil, errors.WithStack(ErrNotFound)
+	errE := CheckRestricted(ctx, prop)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	sh, errE := loadState(ctx, id)
+	if errE != nil {
+		return nil, nil, errE
 	}
-	sh := ss.(*State) //nolint:errcheck,forcetypeassert
 
 	query := sh.Query()
 
@@ -71,15 +367,15 @@ func TimeFilterGet(
 			elastic.NewTermQuery("claims.time.prop.id", prop),
 		).SubAggregation(
 			"min",
-			elastic.NewMinAggregation().Field("claims.time.timestamp"),
+			elastic.NewMinAggregation().Field("claims.time.timestampSeconds"),
 		).SubAggregation(
 			"max",
-			elastic.NewMaxAggregation().Field("claims.time.timestamp"),
+			elastic.NewMaxAggregation().Field("claims.time.timestampSeconds"),
 		),
 	)
 	minMaxSearchService = minMaxSearchService.Size(0).Query(query).Aggregation("minMax", minMaxAggregation)
 
-	m = metrics.Duration(internal.MetricElasticSearch1).Start()
+	m := metrics.Duration(internal.MetricElasticSearch1).Start()
 	res, err := minMaxSearchService.Do(ctx)
 	m.Stop()
 	if err != nil {
@@ -89,49 +385,28 @@ func TimeFilterGet(
 
 	m = metrics.Duration(internal.MetricJSONUnmarshal1).Start()
 	var minMax minMaxTimeAggregations
-	errE := x.Unmarshal(res.Aggregations["minMax"], &minMax)
+	errE = x.Unmarshal(res.Aggregations["minMax"], &minMax)
 	m.Stop()
 	if errE != nil {
 		return nil, nil, errE
 	}
 
-	// We use int64 and not time.Duration because it cannot hold durations we need.
-	// time.Duration stores durations as nanosecond, but we want seconds here.
-	// See: https://github.com/elastic/elasticsearch/issues/83101
-	var minValue, interval int64
 	if minMax.Filter.Count == 0 {
 		return make([]histogramTimeResult, 0), map[string]interface{}{
 			"total": 0,
 		}, nil
-	} else if minMax.Filter.Min.Value == minMax.Filter.Max.Value {
-		minValue = time.Time(minMax.Filter.Min.Value).Unix()
-		interval = 1
-	} else {
-		minValue = time.Time(minMax.Filter.Min.Value).Unix()
-		maxValue := time.Time(minMax.Filter.Max.Value).Unix() + 1
-		interval = (maxValue - minValue) / histogramBins
-		interval2 := (time.Time(minMax.Filter.Max.Value).Unix() - minValue) / histogramBins
-		if interval == interval2 {
-			interval = interval2 + 1
-		}
 	}
 
-	offsetString := fmt.Sprintf("%ds", minValue)
-	intervalString := fmt.Sprintf("%ds", interval)
+	minTime := secondsToTimestamp(minMax.Filter.Min.Value)
+	maxTime := secondsToTimestamp(minMax.Filter.Max.Value)
+
+	h, errE := newTimeHistogram(minTime, maxTime, interval)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
 	histogramSearchService, _ := getSearchService()
-	histogramAggregation := elastic.NewNestedAggregation().Path("claims.time").SubAggregation(
-		"filter",
-		elastic.NewFilterAggregation().Filter(
-			elastic.NewTermQuery("claims.time.prop.id", prop),
-		).SubAggregation(
-			"hist",
-			elastic.NewDateHistogramAggregation().Field("claims.time.timestamp").Offset(offsetString).FixedInterval(intervalString).SubAggregation(
-				"docs",
-				elastic.NewReverseNestedAggregation(),
-			),
-		),
-	)
-	histogramSearchService = histogramSearchService.Size(0).Query(query).Aggregation("histogram", histogramAggregation)
+	histogramSearchService = histogramSearchService.Size(0).Query(query).Aggregation("histogram", h.aggregation(prop.String()))
 
 	m = metrics.Duration(internal.MetricElasticSearch2).Start()
 	res, err = histogramSearchService.Do(ctx)
@@ -142,32 +417,38 @@ func TimeFilterGet(
 	metrics.Duration(internal.MetricElasticSearchInternal2).Duration = time.Duration(res.TookInMillis) * time.Millisecond
 
 	m = metrics.Duration(internal.MetricJSONUnmarshal2).Start()
-	var histogram histogramTimeAggregations
-	errE = x.Unmarshal(res.Aggregations["histogram"], &histogram)
+	results, errE := h.results(res.Aggregations["histogram"])
 	m.Stop()
 	if errE != nil {
 		return nil, nil, errE
 	}
 
-	results := make([]histogramTimeResult, len(histogram.Filter.Hist.Buckets))
-	for i, bucket := range histogram.Filter.Hist.Buckets {
-		results[i] = histogramTimeResult{
-			Min:   bucket.Key,
-			Count: bucket.Docs.Count,
-		}
-	}
-
 	total := strconv.Itoa(len(results))
 
 	metadata := map[string]interface{}{
 		"total": total,
-		"min":   minMax.Filter.Min.Value.String(),
-		"max":   minMax.Filter.Max.Value.String(),
+		"min":   minTime.String(),
+		"max":   maxTime.String(),
 	}
 
-	if minMax.Filter.Min.Value != minMax.Filter.Max.Value {
-		metadata["interval"] = intervalString
+	if i := h.intervalString(); i != "" {
+		metadata["interval"] = i
 	}
 
 	return results, metadata, nil
 }
+
+// secondsToTimestamp converts seconds since Unix epoch (as returned by ElasticSearch
+// aggregations on numeric fields) to a timestamp.
+func secondsToTimestamp(seconds float64) document.Timestamp {
+	return document.Timestamp(time.Unix(int64(seconds), 0).UTC())
+}
+
+// floorMod returns the remainder of a divided by b which is always non-negative.
+func floorMod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
@@ -0,0 +1,104 @@
+//nolint:testpackage
+package search
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+func TestTimeHistogram(t *testing.T) {
+	t.Parallel()
+
+	timestamp := func(year int, month time.Month) document.Timestamp {
+		return document.Timestamp(time.Date(year, month, 1, 0, 0, 0, 0, time.UTC))
+	}
+
+	for _, tt := range []struct {
+		name     string
+		min      document.Timestamp
+		max      document.Timestamp
+		interval string
+		expected string
+	}{
+		{"months", timestamp(2020, time.January), timestamp(2024, time.June), TimeIntervalAuto, TimeIntervalMonth},
+		{"years", timestamp(1950, time.January), timestamp(2024, time.June), TimeIntervalAuto, TimeIntervalYear},
+		{"decades", timestamp(1500, time.January), timestamp(2024, time.June), TimeIntervalAuto, TimeIntervalDecade},
+		{"centuries", timestamp(-3000, time.January), timestamp(2024, time.June), TimeIntervalAuto, TimeIntervalCentury},
+		{"equal", timestamp(-30000, time.January), timestamp(2024, time.June), TimeIntervalAuto, TimeIntervalEqual},
+		{"explicit", timestamp(2020, time.January), timestamp(2024, time.June), TimeIntervalYear, TimeIntervalYear},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h, errE := newTimeHistogram(tt.min, tt.max, tt.interval)
+			require.NoError(t, errE, "% -+#.1v", errE)
+			assert.Equal(t, tt.expected, h.interval)
+		})
+	}
+
+	_, errE := newTimeHistogram(timestamp(-30000, time.January), timestamp(2024, time.June), TimeIntervalMonth)
+	assert.ErrorIs(t, errE, ErrInvalidArgument)
+
+	h, errE := newTimeHistogram(timestamp(2020, time.January), timestamp(2020, time.January), TimeIntervalEqual)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Empty(t, h.intervalString())
+
+	h, errE = newTimeHistogram(timestamp(1500, time.January), timestamp(2024, time.June), TimeIntervalAuto)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, TimeIntervalDecade, h.intervalString())
+	results, errE := h.results([]byte(`{"filter":{"hist":{"buckets":[{"key":1500,"docs":{"doc_count":2}},{"key":2020,"docs":{"doc_count":3}}]}}}`))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []histogramTimeResult{
+		{Min: timestamp(1500, time.January), Count: 2},
+		{Min: timestamp(2020, time.January), Count: 3},
+	}, results)
+
+	// Monthly intervals within years supported by the date field use it.
+	h, errE = newTimeHistogram(timestamp(2020, time.January), timestamp(2020, time.June), TimeIntervalMonth)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.False(t, h.monthRanges)
+
+	// Otherwise, monthly bins are computed from the seconds field.
+	for _, tt := range []struct {
+		min  document.Timestamp
+		max  document.Timestamp
+		bins int
+	}{
+		{timestamp(-44, time.January), timestamp(-44, time.June), 6},
+		{timestamp(0, time.November), timestamp(1, time.February), 4},
+		{timestamp(9999, time.November), timestamp(10000, time.February), 4},
+	} {
+		h, errE = newTimeHistogram(tt.min, tt.max, TimeIntervalMonth)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.True(t, h.monthRanges)
+		source, err := h.aggregation("prop").Source()
+		require.NoError(t, err)
+		ranges := source.(map[string]interface{})["aggregations"].(map[string]interface{})["filter"].(map[string]interface{})["aggregations"].(map[string]interface{})["hist"].(map[string]interface{})["range"].(map[string]interface{}) //nolint:forcetypeassert,errcheck
+		assert.Equal(t, "claims.time.timestampSeconds", ranges["field"])
+		bins := ranges["ranges"].([]interface{}) //nolint:forcetypeassert,errcheck
+		require.Len(t, bins, tt.bins)
+		assert.Equal(t, time.Time(tt.min).Unix(), bins[0].(map[string]interface{})["from"])
+		assert.Equal(t, time.Time(tt.max).AddDate(0, 1, 0).Unix(), bins[tt.bins-1].(map[string]interface{})["to"])
+	}
+
+	h, errE = newTimeHistogram(timestamp(-44, time.January), timestamp(-44, time.February), TimeIntervalMonth)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, TimeIntervalMonth, h.intervalString())
+	january := time.Time(timestamp(-44, time.January)).Unix()
+	february := time.Time(timestamp(-44, time.February)).Unix()
+	march := time.Time(timestamp(-44, time.March)).Unix()
+	results, errE = h.results([]byte(fmt.Sprintf(`{"filter":{"hist":{"buckets":[`+
+		`{"key":"a","from":%d,"to":%d,"docs":{"doc_count":2}},`+
+		`{"key":"b","from":%d,"to":%d,"docs":{"doc_count":0}}]}}}`, january, february, february, march)))
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []histogramTimeResult{
+		{Min: timestamp(-44, time.January), Count: 2},
+		{Min: timestamp(-44, time.February), Count: 0},
+	}, results)
+}
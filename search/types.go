@@ -0,0 +1,100 @@
+package search
+
+import (
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	// MaxTypeCounts is the maximum number of types for which counts of documents are returned.
+	MaxTypeCounts = 20
+
+	typeCountsAggregation = "types"
+	typeCountsCacheSize   = 10000
+	// Type counts are cached only briefly so that they do not diverge much from the total.
+	typeCountsCacheTTL = time.Minute
+)
+
+// TypeCount is the number of documents matching a search state which have a TYPE claim to the type.
+type TypeCount struct {
+	ID    string `json:"id"`
+	Count int64  `json:"count"`
+}
+
+//nolint:gochecknoglobals
+var typeCountsCache = expirable.NewLRU[string, []TypeCount](typeCountsCacheSize, nil, typeCountsCacheTTL)
+
+func typeCountsKey(index string, sh *State) string {
+	return index + "/" + sh.ID.String()
+}
+
+// CachedTypeCounts returns counts of documents per type for the search state on the index,
+// if they have been recently obtained using TypeCounts.
+func CachedTypeCounts(index string, sh *State) ([]TypeCount, bool) {
+	return typeCountsCache.Get(typeCountsKey(index, sh))
+}
+
+// WithTypeCounts adds to the search service an aggregation counting matching documents
+// per type, so that counts are obtained together with search results.
+func WithTypeCounts(searchService *elastic.SearchService) *elastic.SearchService {
+	aggregation := elastic.NewNestedAggregation().Path("claims.rel").SubAggregation(
+		"filter",
+		elastic.NewFilterAggregation().Filter(
+			elastic.NewTermQuery("claims.rel.prop.id", document.GetCorePropertyID("TYPE")),
+		).SubAggregation(
+			"props",
+			elastic.NewTermsAggregation().Field("claims.rel.to.id").Size(MaxTypeCounts).OrderByAggregation("docs", false).SubAggregation(
+				"docs",
+				elastic.NewReverseNestedAggregation(),
+			),
+		),
+	)
+	return searchService.Aggregation(typeCountsAggregation, aggregation)
+}
+
+// TypeCounts returns counts of documents per type from aggregations of the response to a search
+// request with the aggregation added using WithTypeCounts, and caches them for the search state
+// on the index. Types with the most documents are returned first.
+func TypeCounts(index string, sh *State, aggregations elastic.Aggregations, timedOut bool) ([]TypeCount, errors.E) {
+	raw, ok := aggregations[typeCountsAggregation]
+	if !ok {
+		return nil, errors.New("type counts aggregation missing")
+	}
+
+	var types filteredTermAggregations
+	errE := x.Unmarshal(raw, &types)
+	if errE != nil {
+		return nil, errE
+	}
+
+	counts := make([]TypeCount, len(types.Filter.Props.Buckets))
+	for i, bucket := range types.Filter.Props.Buckets {
+		counts[i] = TypeCount{ID: bucket.Key, Count: bucket.Docs.Count}
+	}
+
+	// We do not cache partial counts.
+	if !timedOut {
+		typeCountsCache.Add(typeCountsKey(index, sh), counts)
+	}
+
+	return counts, nil
+}
+
+// TypeCountsMetadata adds counts of documents per type to metadata of the response:
+// "types" is the list of type IDs and "types-count" the list of corresponding counts.
+func TypeCountsMetadata(counts []TypeCount, metadata map[string]interface{}) {
+	ids := make([]string, len(counts))
+	values := make([]int64, len(counts))
+	for i, count := range counts {
+		ids[i] = count.ID
+		values[i] = count.Count
+	}
+	metadata["types"] = ids
+	metadata["types-count"] = values
+}
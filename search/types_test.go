@@ -0,0 +1,58 @@
+package search_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestTypeCounts(t *testing.T) {
+	t.Parallel()
+
+	aggregations := elastic.Aggregations{
+		"types": json.RawMessage(`{"doc_count": 10, "filter": {"doc_count": 5, "props": {"buckets": [
+			{"key": "type1", "doc_count": 4, "docs": {"doc_count": 3}},
+			{"key": "type2", "doc_count": 1, "docs": {"doc_count": 1}}
+		]}}}`),
+	}
+
+	sh := &search.State{ID: identifier.New()} //nolint:exhaustruct
+
+	_, ok := search.CachedTypeCounts("index", sh)
+	assert.False(t, ok)
+
+	counts, errE := search.TypeCounts("index", sh, aggregations, false)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []search.TypeCount{{ID: "type1", Count: 3}, {ID: "type2", Count: 1}}, counts)
+
+	cached, ok := search.CachedTypeCounts("index", sh)
+	assert.True(t, ok)
+	assert.Equal(t, counts, cached)
+
+	// Counts are cached per index.
+	_, ok = search.CachedTypeCounts("other", sh)
+	assert.False(t, ok)
+
+	// Partial counts are not cached.
+	other := &search.State{ID: identifier.New()} //nolint:exhaustruct
+	_, errE = search.TypeCounts("index", other, aggregations, true)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	_, ok = search.CachedTypeCounts("index", other)
+	assert.False(t, ok)
+
+	_, errE = search.TypeCounts("index", other, elastic.Aggregations{}, false)
+	assert.Error(t, errE)
+
+	metadata := map[string]interface{}{}
+	search.TypeCountsMetadata(counts, metadata)
+	assert.Equal(t, map[string]interface{}{
+		"types":       []string{"type1", "type2"},
+		"types-count": []int64{3, 1},
+	}, metadata)
+}
@@ -0,0 +1,163 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/identifier"
+)
+
+const (
+	// DefaultViewsWindow is the default sliding window over which trending documents are computed.
+	DefaultViewsWindow = 24 * time.Hour
+	// MaxTrendingDocuments is the maximum number of trending documents returned.
+	MaxTrendingDocuments = 20
+	// DefaultViewsPerHour is the default number of views a client can record per hour.
+	DefaultViewsPerHour = 600
+
+	// viewsBuckets is the number of buckets the window is split into. The window slides by one bucket at a time.
+	viewsBuckets = 24
+)
+
+// TrendingDocument is a document with the number of its views within the window.
+type TrendingDocument struct {
+	ID    identifier.Identifier `json:"id"`
+	Views int64                 `json:"views"`
+}
+
+type viewsBucket struct {
+	Start  time.Time
+	Counts map[identifier.Identifier]int64
+}
+
+type sessionViews struct {
+	// Documents recently viewed in the session, most recent first.
+	Documents []identifier.Identifier
+
+	LastSeen time.Time
+}
+
+// Views tracks document views to provide trending documents (the most viewed
+// documents within a sliding window) and recently viewed documents per session.
+//
+// Views are kept in memory only, counted per window bucket, so they are not shared
+// between instances and are lost on restart. Repeated views of a recently viewed
+// document within the same session are counted once. Sessions inactive for the
+// window are forgotten.
+type Views struct {
+	// Window is the sliding window over which trending documents are computed.
+	// Zero means DefaultViewsWindow.
+	Window time.Duration
+
+	mu       sync.Mutex
+	buckets  []viewsBucket
+	sessions map[string]*sessionViews
+}
+
+func (v *Views) window() time.Duration {
+	if v.Window == 0 {
+		return DefaultViewsWindow
+	}
+	return v.Window
+}
+
+// RecordView records that the document was viewed in the session.
+func (v *Views) RecordView(session string, id identifier.Identifier) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	v.expire(now)
+
+	if v.sessions == nil {
+		v.sessions = map[string]*sessionViews{}
+	}
+	views, ok := v.sessions[session]
+	if !ok {
+		views = &sessionViews{} //nolint:exhaustruct
+		v.sessions[session] = views
+	}
+	repeated := slices.Contains(views.Documents, id)
+	views.Documents = addRecent(views.Documents, []identifier.Identifier{id})
+	views.LastSeen = now
+
+	if repeated {
+		return
+	}
+
+	bucketDuration := v.window() / viewsBuckets
+	if len(v.buckets) == 0 || now.Sub(v.buckets[len(v.buckets)-1].Start) >= bucketDuration {
+		v.buckets = append(v.buckets, viewsBucket{
+			Start:  now,
+			Counts: map[identifier.Identifier]int64{},
+		})
+	}
+	v.buckets[len(v.buckets)-1].Counts[id]++
+}
+
+// Recent returns documents recently viewed in the session, most recent first.
+func (v *Views) Recent(session string) []identifier.Identifier {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.expire(time.Now())
+
+	views, ok := v.sessions[session]
+	if !ok {
+		return []identifier.Identifier{}
+	}
+	return slices.Clone(views.Documents)
+}
+
+// Trending returns up to MaxTrendingDocuments documents with the most views within
+// the window, the most viewed first. Documents with the same number of views are
+// sorted by their ID.
+func (v *Views) Trending() []TrendingDocument {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.expire(time.Now())
+
+	counts := map[identifier.Identifier]int64{}
+	for _, bucket := range v.buckets {
+		for id, count := range bucket.Counts {
+			counts[id] += count
+		}
+	}
+
+	trending := make([]TrendingDocument, 0, len(counts))
+	for id, count := range counts {
+		trending = append(trending, TrendingDocument{ID: id, Views: count})
+	}
+	slices.SortFunc(trending, func(a, b TrendingDocument) int {
+		if a.Views != b.Views {
+			if a.Views > b.Views {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	if len(trending) > MaxTrendingDocuments {
+		trending = trending[:MaxTrendingDocuments]
+	}
+	return trending
+}
+
+// expire removes buckets which are outside of the window and inactive sessions.
+// It must be called with the mutex held.
+func (v *Views) expire(now time.Time) {
+	window := v.window()
+	i := 0
+	for i < len(v.buckets) && now.Sub(v.buckets[i].Start) > window {
+		i++
+	}
+	v.buckets = v.buckets[i:]
+	for session, views := range v.sessions {
+		if now.Sub(views.LastSeen) > window {
+			delete(v.sessions, session)
+		}
+	}
+}
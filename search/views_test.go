@@ -0,0 +1,49 @@
+package search_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestViews(t *testing.T) {
+	t.Parallel()
+
+	doc1 := identifier.New()
+	doc2 := identifier.New()
+	doc3 := identifier.New()
+
+	v := &search.Views{} //nolint:exhaustruct
+	assert.Empty(t, v.Trending())
+	assert.Empty(t, v.Recent("session1"))
+
+	v.RecordView("session1", doc1)
+	v.RecordView("session1", doc2)
+	// Repeated view within the same session is counted once.
+	v.RecordView("session1", doc1)
+	v.RecordView("session2", doc2)
+	v.RecordView("session3", doc2)
+	v.RecordView("session3", doc3)
+
+	assert.Equal(t, []identifier.Identifier{doc1, doc2}, v.Recent("session1"))
+	assert.Equal(t, []identifier.Identifier{doc3, doc2}, v.Recent("session3"))
+	assert.Empty(t, v.Recent("other"))
+
+	trending := v.Trending()
+	if assert.Len(t, trending, 3) {
+		assert.Equal(t, search.TrendingDocument{ID: doc2, Views: 3}, trending[0])
+		assert.Equal(t, int64(1), trending[1].Views)
+		assert.Equal(t, int64(1), trending[2].Views)
+		assert.Less(t, trending[1].ID.String(), trending[2].ID.String())
+	}
+
+	v = &search.Views{Window: time.Nanosecond} //nolint:exhaustruct
+	v.RecordView("session1", doc1)
+	time.Sleep(time.Millisecond)
+	assert.Empty(t, v.Trending())
+	assert.Empty(t, v.Recent("session1"))
+}
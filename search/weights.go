@@ -0,0 +1,149 @@
+package search
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+const (
+	// MaxFieldWeights is the maximum number of properties with a weight.
+	MaxFieldWeights = 20
+
+	// MaxFieldWeight is the maximum weight of a property.
+	MaxFieldWeight = 100
+)
+
+//nolint:gochecknoglobals
+var mnemonicRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// FieldWeights are weights of properties used when matching the search query against
+// claims of documents. Matches in claims of properties without a weight have weight 1.
+// Weight 0 makes matches in claims of the property not contribute to relevance.
+//
+// In JSON and YAML, properties are keyed by their IDs or by mnemonics of core properties
+// (e.g., {"NAME": 5, "DESCRIPTION": 1}).
+type FieldWeights map[identifier.Identifier]float64
+
+// parseProperty parses a property ID or a mnemonic of a core property.
+func parseProperty(key string) (identifier.Identifier, errors.E) {
+	id, errE := identifier.FromString(key)
+	if errE == nil {
+		return id, nil
+	}
+	if !mnemonicRegexp.MatchString(key) {
+		errE := errors.New("invalid property")
+		errors.Details(errE)["prop"] = key
+		return identifier.Identifier{}, errE
+	}
+	return document.GetCorePropertyID(key), nil
+}
+
+func (w *FieldWeights) fromStrings(weights map[string]float64) errors.E {
+	result := FieldWeights{}
+	for key, weight := range weights {
+		id, errE := parseProperty(key)
+		if errE != nil {
+			return errE
+		}
+		if _, ok := result[id]; ok {
+			errE := errors.New("duplicate property")
+			errors.Details(errE)["prop"] = key
+			return errE
+		}
+		result[id] = weight
+	}
+	*w = result
+	return nil
+}
+
+func (w *FieldWeights) UnmarshalJSON(data []byte) error {
+	var weights map[string]float64
+	errE := x.UnmarshalWithoutUnknownFields(data, &weights)
+	if errE != nil {
+		return errE
+	}
+	return w.fromStrings(weights)
+}
+
+func (w *FieldWeights) UnmarshalYAML(value *yaml.Node) error {
+	var weights map[string]float64
+	err := value.Decode(&weights)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return w.fromStrings(weights)
+}
+
+// Validate validates field weights.
+func (w FieldWeights) Validate() errors.E {
+	if len(w) > MaxFieldWeights {
+		errE := errors.New("too many field weights")
+		errors.Details(errE)["count"] = len(w)
+		errors.Details(errE)["max"] = MaxFieldWeights
+		return errE
+	}
+	for prop, weight := range w {
+		if weight < 0 || weight > MaxFieldWeight {
+			errE := errors.New("weight out of range")
+			errors.Details(errE)["prop"] = prop.String()
+			errors.Details(errE)["weight"] = weight
+			errors.Details(errE)["max"] = MaxFieldWeight
+			return errE
+		}
+	}
+	return nil
+}
+
+// Merge returns field weights with overrides applied on top of w.
+// Neither w nor overrides are modified.
+func (w FieldWeights) Merge(overrides FieldWeights) FieldWeights {
+	if len(overrides) == 0 {
+		return w
+	}
+	result := make(FieldWeights, len(w)+len(overrides))
+	for prop, weight := range w {
+		result[prop] = weight
+	}
+	for prop, weight := range overrides {
+		result[prop] = weight
+	}
+	return result
+}
+
+// props returns properties with a weight, in deterministic order.
+func (w FieldWeights) props() []identifier.Identifier {
+	props := make([]identifier.Identifier, 0, len(w))
+	for prop := range w {
+		props = append(props, prop)
+	}
+	slices.SortFunc(props, func(a, b identifier.Identifier) int {
+		return slices.Compare(a[:], b[:])
+	})
+	return props
+}
+
+// ParseFieldWeights parses JSON with field weights.
+// An empty string means no field weights.
+func ParseFieldWeights(data string) (FieldWeights, errors.E) {
+	if data == "" {
+		return nil, nil //nolint:nilnil
+	}
+	var weights FieldWeights
+	err := json.Unmarshal([]byte(data), &weights)
+	if err != nil {
+		return nil, errors.WrapWith(err, ErrInvalidArgument)
+	}
+	errE := weights.Validate()
+	if errE != nil {
+		return nil, errors.WrapWith(errE, ErrInvalidArgument)
+	}
+	return weights, nil
+}
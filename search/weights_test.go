@@ -0,0 +1,105 @@
+package search_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestParseFieldWeights(t *testing.T) {
+	t.Parallel()
+
+	prop := identifier.New()
+
+	weights, errE := search.ParseFieldWeights("")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Nil(t, weights)
+
+	weights, errE = search.ParseFieldWeights(`{"NAME":5,"DESCRIPTION":1,"` + prop.String() + `":0}`)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, search.FieldWeights{
+		document.GetCorePropertyID("NAME"):        5,
+		document.GetCorePropertyID("DESCRIPTION"): 1,
+		prop: 0,
+	}, weights)
+
+	for _, data := range []string{
+		`[]`,
+		`{"NAME":"5"}`,
+		`{"name":5}`,
+		`{"NAME":-1}`,
+		`{"NAME":1000}`,
+		`{"NAME":1,"` + document.GetCorePropertyID("NAME").String() + `":2}`,
+	} {
+		_, errE := search.ParseFieldWeights(data)
+		assert.ErrorIs(t, errE, search.ErrInvalidArgument, data)
+	}
+
+	tooMany := []string{}
+	for range search.MaxFieldWeights + 1 {
+		tooMany = append(tooMany, `"`+identifier.New().String()+`":1`)
+	}
+	_, errE = search.ParseFieldWeights(`{` + strings.Join(tooMany, ",") + `}`)
+	assert.ErrorIs(t, errE, search.ErrInvalidArgument)
+}
+
+func TestFieldWeightsYAML(t *testing.T) {
+	t.Parallel()
+
+	var weights search.FieldWeights
+	err := yaml.Unmarshal([]byte("NAME: 3\nDESCRIPTION: 0.5\n"), &weights)
+	require.NoError(t, err)
+	assert.Equal(t, search.FieldWeights{
+		document.GetCorePropertyID("NAME"):        3,
+		document.GetCorePropertyID("DESCRIPTION"): 0.5,
+	}, weights)
+
+	err = yaml.Unmarshal([]byte("invalid-property: 3\n"), &weights)
+	assert.Error(t, err)
+}
+
+func TestFieldWeightsMerge(t *testing.T) {
+	t.Parallel()
+
+	name := document.GetCorePropertyID("NAME")
+	description := document.GetCorePropertyID("DESCRIPTION")
+
+	defaults := search.FieldWeights{name: 2, description: 1}
+	merged := defaults.Merge(search.FieldWeights{name: 5})
+	assert.Equal(t, search.FieldWeights{name: 5, description: 1}, merged)
+	// Defaults are not modified.
+	assert.Equal(t, search.FieldWeights{name: 2, description: 1}, defaults)
+
+	assert.Equal(t, defaults, defaults.Merge(nil))
+	assert.Equal(t, search.FieldWeights{name: 5}, search.FieldWeights(nil).Merge(search.FieldWeights{name: 5}))
+}
+
+func TestWeightedQuery(t *testing.T) {
+	t.Parallel()
+
+	name := document.GetCorePropertyID("NAME")
+
+	sh := &search.State{SearchQuery: "foo"} //nolint:exhaustruct
+
+	source, err := sh.Query().Source()
+	require.NoError(t, err)
+	data, err := json.Marshal(source)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "boost")
+
+	source, err = sh.WeightedQuery(search.FieldWeights{name: 5}, nil).Source()
+	require.NoError(t, err)
+	data, err = json.Marshal(source)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"boost":5`)
+	assert.Contains(t, string(data), `{"term":{"claims.text.prop.id":"`+name.String()+`"}}`)
+	assert.Contains(t, string(data), `{"terms":{"claims.text.prop.id":["`+name.String()+`"]}}`)
+}
@@ -0,0 +1,51 @@
+package peerdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+)
+
+func TestSearchTimeoutQuery(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		query   string
+		timeout string
+		err     string
+	}{
+		{"", "", ""},
+		{"timeoutMs=1", "1ms", ""},
+		{"timeoutMs=500", "500ms", ""},
+		{"timeoutMs=60000", "60000ms", ""},
+		{"timeoutMs=", "", `"timeoutMs" is not a valid integer`},
+		{"timeoutMs=abc", "", `"timeoutMs" is not a valid integer`},
+		{"timeoutMs=1.5", "", `"timeoutMs" is not a valid integer`},
+		{"timeoutMs=0", "", `"timeoutMs" is out of range`},
+		{"timeoutMs=-1", "", `"timeoutMs" is out of range`},
+		{"timeoutMs=60001", "", `"timeoutMs" is out of range`},
+		{"timeoutMs=99999999999999999999", "", `"timeoutMs" is not a valid integer`},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/api/s/foo?"+tt.query, nil)
+			require.NoError(t, req.ParseForm())
+			timeout, errE := searchTimeoutQuery(req)
+			if tt.err != "" {
+				require.Error(t, errE)
+				assert.ErrorContains(t, errE, tt.err)
+				if tt.err == `"timeoutMs" is out of range` {
+					assert.Equal(t, int64(60000), errors.AllDetails(errE)["max"])
+				}
+			} else {
+				assert.NoError(t, errE, "% -+#.1v", errE)
+			}
+			assert.Equal(t, tt.timeout, timeout)
+		})
+	}
+}
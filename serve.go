@@ -1,23 +1,33 @@
 package peerdb
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"embed"
 	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/alecthomas/kong"
 	"github.com/hashicorp/go-cleanhttp"
 	"github.com/olivere/elastic/v7"
+	"github.com/santhosh-tekuri/jsonschema/v6"
 	"gitlab.com/tozd/go/cli"
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/x"
 	"gitlab.com/tozd/waf"
 
+	"gitlab.com/peerdb/peerdb/annotations"
 	"gitlab.com/peerdb/peerdb/internal/es"
 	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/tasks"
 )
 
 //go:embed routes.json
@@ -30,9 +40,81 @@ type Service struct {
 	waf.Service[*Site]
 
 	esClient *elastic.Client
+	// synonymsDir is the directory into which synonym files are written (see es.UpdateSynonyms).
+	synonymsDir string
+
+	paginationKeepAlive time.Duration
+	// pointsInTime are shared by pagination sessions (see search.PointsInTime).
+	pointsInTime *search.PointsInTime
+	// secret is used to sign pagination session tokens (see search.Paginate) and personalization cookies.
+	secret []byte
+
+	// llm are LLM providers used to parse prompts.
+	llm *search.LLMProviders
+
+	// personalization is nil when personalization is disabled.
+	personalization *search.Personalization
+
+	// Work queues limiting concurrency of expensive operations. They are nil when disabled.
+	llmQueue     *search.WorkQueue
+	exportQueue  *search.WorkQueue
+	filtersQueue *search.WorkQueue
+
+	// annotationsLimiter limits the rate of creating annotations per caller. It is nil when disabled.
+	annotationsLimiter *search.RateLimiter
+	// viewsLimiter limits the rate of tracking document views per caller. It is nil when disabled.
+	viewsLimiter *search.RateLimiter
+
+	devServer *devServer
+
+	router *waf.Router
+
+	// synonymsMu serializes updates of synonym rules of indices.
+	synonymsMu sync.Mutex
+	// redirectsMu serializes changes of redirects.
+	redirectsMu sync.Mutex
+
+	// configPath is the path to the configuration file which is read again on reload.
+	configPath string
+	// llmAPIKeyFile is the path to the file with the LLM API key which is read again on reload.
+	llmAPIKeyFile string
+	// reloadMu serializes reloads of the configuration.
+	reloadMu sync.Mutex
+
+	apiSchemas map[string]*jsonschema.Schema
+	openAPI    []byte
+
+	// readOnly is true when running as a read-only search replica.
+	readOnly bool
 }
 
 // Init is used primarily in tests. Use Run otherwise.
+// secretSize is the size of the randomly generated secret.
+const secretSize = 32
+
+// loadSecret reads the secret used to sign pagination session tokens and personalization
+// cookies from the file at path. If path is empty, a random secret is generated.
+func loadSecret(path string) ([]byte, errors.E) {
+	if path == "" {
+		secret := make([]byte, secretSize)
+		_, err := rand.Read(secret)
+		return secret, errors.WithStack(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		errE := errors.WithStack(err)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	secret := bytes.TrimSpace(data)
+	if len(secret) == 0 {
+		errE := errors.Errorf("%w: empty secret", errInvalidConfig)
+		errors.Details(errE)["path"] = path
+		return nil, errE
+	}
+	return secret, nil
+}
+
 func (c *ServeCommand) Init(ctx context.Context, globals *Globals, files fs.ReadFileFS) (http.Handler, *Service, errors.E) {
 	// Routes come from a single source of truth, e.g., a file.
 	var routesConfig struct {
@@ -62,10 +144,19 @@ func (c *ServeCommand) Init(ctx context.Context, globals *Globals, files fs.Read
 			Schema:          globals.Postgres.Schema,
 			Title:           c.Title,
 			SizeField:       globals.Elastic.SizeField,
+			Sitemap:         c.Sitemap,
 			store:           nil,
 			coordinator:     nil,
 			storage:         nil,
 			esProcessor:     nil,
+			references:      nil,
+			generations:     nil,
+			synonyms:        nil,
+			redirects:       nil,
+			redirectMap:     nil,
+			sitemaps:        nil,
+			slowQueries:     nil,
+			reloadable:      nil,
 			propertiesTotal: 0,
 		}
 	}
@@ -84,6 +175,7 @@ func (c *ServeCommand) Init(ctx context.Context, globals *Globals, files fs.Read
 			site.Schema = globals.Postgres.Schema
 			site.Title = c.Title
 			site.SizeField = globals.Elastic.SizeField
+			site.Sitemap = c.Sitemap
 		}
 	}
 
@@ -104,6 +196,28 @@ func (c *ServeCommand) Init(ctx context.Context, globals *Globals, files fs.Read
 		return nil, nil, errors.WithStack(err)
 	}
 
+	if c.ReadOnly {
+		// Nothing is written to the database nor the index, they have to be initialized by another instance.
+		ctx = internal.WithReadOnly(ctx)
+	}
+
+	if c.LLMAPIKeyFile != "" {
+		errE = loadLLMAPIKey(c.LLMAPIKeyFile)
+		if errE != nil {
+			return nil, nil, errE
+		}
+	}
+
+	secret, errE := loadSecret(c.SecretFile)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	llm, errE := c.llmProviders()
+	if errE != nil {
+		return nil, nil, errE
+	}
+
 	dbpool, errE := internal.InitPostgres(ctx, string(globals.Postgres.URL), globals.Logger, getRequestWithFallback(globals.Logger))
 	if errE != nil {
 		return nil, nil, errE
@@ -119,7 +233,7 @@ func (c *ServeCommand) Init(ctx context.Context, globals *Globals, files fs.Read
 		siteCtx := context.WithValue(ctx, requestIDContextKey, "serve")
 		siteCtx = context.WithValue(siteCtx, schemaContextKey, site.Schema)
 
-		store, coordinator, storage, esProcessor, errE := es.InitForSite(siteCtx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField) //nolint:govet
+		store, coordinator, storage, esProcessor, references, generations, _, errE := es.InitForSite(siteCtx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField, site.RestrictedProperties) //nolint:govet
 		if errE != nil {
 			return nil, nil, errE
 		}
@@ -128,6 +242,61 @@ func (c *ServeCommand) Init(ctx context.Context, globals *Globals, files fs.Read
 		site.coordinator = coordinator
 		site.storage = storage
 		site.esProcessor = esProcessor
+		site.references = references
+		site.generations = generations
+		site.initSettings()
+		if c.ReadOnly && site.Quota != nil && (site.Quota.MaxDocuments != 0 || site.Quota.MaxBytes != 0) {
+			errE := errors.New("quota cannot be used in read-only mode")
+			errors.Details(errE)["site"] = site.Domain
+			return nil, nil, errE
+		}
+		if site.Quota == nil {
+			// We use a quota without limits so that usage is available in any case.
+			site.Quota = &es.Quota{} //nolint:exhaustruct
+		}
+		errE = site.Quota.Init(globals.Logger, cleanhttp.DefaultPooledClient(), esClient, site.Index)
+		if errE != nil {
+			errors.Details(errE)["site"] = site.Domain
+			return nil, nil, errE
+		}
+		if site.Sitemap {
+			site.sitemaps = newSitemapsHolder()
+		}
+		site.slowQueries = newSlowQueries(c.SlowQueries)
+		site.views = &search.Views{Window: c.TrendingWindow} //nolint:exhaustruct
+
+		errE = initSynonyms(siteCtx, dbpool, esClient, site, c.SynonymsDir)
+		if errE != nil {
+			return nil, nil, errE
+		}
+
+		errE = initRedirects(siteCtx, dbpool, site)
+		if errE != nil {
+			return nil, nil, errE
+		}
+
+		site.annotations = &annotations.Annotations{Prefix: ""}
+		errE = site.annotations.Init(siteCtx, dbpool)
+		if errE != nil {
+			return nil, nil, errE
+		}
+
+		site.tasks = &tasks.Tasks{Prefix: "", Workers: c.TaskWorkers}
+		errE = site.tasks.Init(siteCtx, dbpool)
+		if errE != nil {
+			return nil, nil, errE
+		}
+
+		site.llmBudget = &search.LLMBudget{ //nolint:exhaustruct
+			Monthly:       c.LLMMonthlyBudget,
+			PromptPrice:   c.LLMPromptPrice,
+			ResponsePrice: c.LLMResponsePrice,
+			Prefix:        "",
+		}
+		errE = site.llmBudget.Init(siteCtx, dbpool)
+		if errE != nil {
+			return nil, nil, errE
+		}
 	}
 
 	service := &Service{ //nolint:forcetypeassert
@@ -159,7 +328,61 @@ func (c *ServeCommand) Init(ctx context.Context, globals *Globals, files fs.Read
 				}
 			},
 		},
-		esClient: esClient,
+		esClient:            esClient,
+		synonymsDir:         c.SynonymsDir,
+		paginationKeepAlive: c.PaginationKeepAlive,
+		pointsInTime:        &search.PointsInTime{Reuse: search.DefaultPointInTimeReuse}, //nolint:exhaustruct
+		secret:              secret,
+		llm:                 llm,
+		personalization:     nil,
+		llmQueue:            search.NewWorkQueue(c.LLMConcurrency, c.QueueLength),
+		exportQueue:         search.NewWorkQueue(c.ExportConcurrency, c.QueueLength),
+		filtersQueue:        search.NewWorkQueue(c.FiltersConcurrency, c.QueueLength),
+		annotationsLimiter:  search.NewRateLimiter(c.AnnotationsPerHour),
+		viewsLimiter:        search.NewRateLimiter(c.ViewsPerHour),
+		devServer:           nil,
+		router:              nil,
+		synonymsMu:          sync.Mutex{},
+		redirectsMu:         sync.Mutex{},
+		configPath:          "",
+		llmAPIKeyFile:       c.LLMAPIKeyFile,
+		reloadMu:            sync.Mutex{},
+		apiSchemas:          nil,
+		openAPI:             nil,
+		readOnly:            c.ReadOnly,
+	}
+
+	if globals.Config != "" {
+		service.configPath = kong.ExpandPath(string(globals.Config))
+	}
+
+	// CORS middleware is first so that CORS headers are set also on rejected requests.
+	service.Middleware = []func(http.Handler) http.Handler{service.corsMiddleware, service.roleMiddleware}
+	if c.ReadOnly {
+		service.Middleware = append(service.Middleware, service.readOnlyMiddleware)
+	}
+
+	if service.paginationKeepAlive == 0 {
+		service.paginationKeepAlive = search.DefaultPaginationKeepAlive
+	}
+
+	if c.Personalization {
+		service.personalization = &search.Personalization{} //nolint:exhaustruct
+	}
+
+	if service.ProxyStaticTo != "" {
+		service.devServer = newDevServer(service.ProxyStaticTo)
+		go service.devServer.watch(ctx, service.Logger)
+	}
+
+	service.apiSchemas, errE = compileAPISchemas()
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	service.openAPI, errE = generateOpenAPI(service, routesConfig.Routes)
+	if errE != nil {
+		return nil, nil, errE
 	}
 
 	errE = service.populatePropertiesTotal(ctx)
@@ -167,9 +390,31 @@ func (c *ServeCommand) Init(ctx context.Context, globals *Globals, files fs.Read
 		return nil, nil, errE
 	}
 
+	errE = service.populatePropertyRegistries(ctx)
+	if errE != nil {
+		return nil, nil, errE
+	}
+
+	sitemapInterval := c.SitemapInterval
+	if sitemapInterval == 0 {
+		sitemapInterval = DefaultSitemapInterval
+	}
+	for _, site := range sites {
+		if site.Sitemap {
+			// We set fallback context values which are used to set application name on PostgreSQL connections.
+			siteCtx := context.WithValue(ctx, requestIDContextKey, "sitemap")
+			siteCtx = context.WithValue(siteCtx, schemaContextKey, site.Schema)
+
+			go service.regenerateSitemaps(siteCtx, site, sitemapInterval)
+		}
+	}
+
+	// Configuration can be reloaded without restarting the server.
+	go service.reloadOnSignal(ctx)
+
 	// Construct the main handler for the service using the router.
-	router := new(waf.Router)
-	handler, errE := service.RouteWith(service, router)
+	service.router = new(waf.Router)
+	handler, errE := service.RouteWith(service, service.router)
 	if errE != nil {
 		return nil, nil, errE
 	}
@@ -182,11 +427,28 @@ func (c *ServeCommand) Run(globals *Globals) errors.E {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	handler, _, errE := c.Init(ctx, globals, files)
+	handler, service, errE := c.Init(ctx, globals, files)
 	if errE != nil {
 		return errE
 	}
 
+	if c.GRPCListen != "" {
+		listener, err := net.Listen("tcp", c.GRPCListen)
+		if err != nil {
+			errE := errors.WithStack(err)
+			errors.Details(errE)["addr"] = c.GRPCListen
+			return errE
+		}
+		grpcServer := service.GRPCServer()
+		context.AfterFunc(ctx, grpcServer.GracefulStop)
+		go func() {
+			err := grpcServer.Serve(listener)
+			if err != nil {
+				globals.Logger.Error().Err(err).Msg("gRPC server error")
+			}
+		}()
+	}
+
 	// It returns only on error or if the server is gracefully shut down using ctrl-c.
 	return c.Server.Run(ctx, handler)
 }
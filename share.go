@@ -0,0 +1,137 @@
+package peerdb
+
+import (
+	"net/http"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/waf"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+type searchShareCreateResponse struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// SearchShareCreatePost is a POST HTTP request handler which encodes the full state of the
+// search state "s" into a compact token, which can be used to share the search as a short URL
+// (returned as well). Optional "sort" parameter is JSON with sort specifications (see search.ParseSorts)
+// and optional "facets" parameter can be repeated to list filters shown in the UI (see search.SharedState).
+func (s *Service) SearchShareCreatePost(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	metrics := waf.MustGetMetrics(req.Context())
+
+	m := metrics.Duration(internal.MetricSearchState).Start()
+	sh := search.GetState(req.Context(), req.Form.Get("s"))
+	m.Stop()
+	if sh == nil {
+		s.NotFound(w, req)
+		return
+	}
+
+	sorts, errE := search.ParseSorts(req.Form.Get("sort"))
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	shared, errE := search.SharedStateFromState(sh, sorts, req.Form["facets"])
+	if errors.Is(errE, search.ErrNotReady) {
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errors.Is(errE, search.ErrInvalidArgument) {
+		s.BadRequestWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	token, errE := shared.Token()
+	if errors.Is(errE, search.ErrInvalidArgument) {
+		s.BadRequestWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	path, errE := s.Reverse("SearchShared", waf.Params{"token": token}, nil)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, searchShareCreateResponse{Token: token, URL: path}, nil)
+}
+
+// SearchShareGetGet is a GET/HEAD HTTP request handler which decodes and validates
+// the shared search state token and returns the shared search state.
+func (s *Service) SearchShareGetGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	shared, errE := search.ParseSharedStateToken(params["token"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	// Tokens never change.
+	w.Header().Set("Cache-Control", "max-age=604800")
+
+	s.WriteJSON(w, req, shared, nil)
+}
+
+// SearchShared is a GET/HEAD HTTP request handler which decodes the shared search state token,
+// creates a new search state from it, and redirects to its search results. Sort specifications
+// and facets of the shared search state are passed on as "sort" and "facets" query string parameters.
+func (s *Service) SearchShared(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	shared, errE := search.ParseSharedStateToken(params["token"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	filtersJSON, errE := shared.FiltersJSON()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	asOf := ""
+	if shared.AsOf != nil {
+		asOf = shared.AsOf.String()
+	}
+
+	site := waf.MustGetSite[*Site](ctx)
+
+	m := metrics.Duration(internal.MetricSearchState).Start()
+	sh := search.CreateState(
+		ctx, site.store, s.getSearchServiceClosure(req), s.recordLLMUsageClosure(req), s.llmQueue, s.llm, "", shared.SearchQuery, filtersJSON, asOf, false,
+	)
+	m.Stop()
+
+	values := sh.Values()
+	if len(shared.Sorts) > 0 {
+		data, errE := x.MarshalWithoutEscapeHTML(shared.Sorts)
+		if errE != nil {
+			s.InternalServerErrorWithError(w, req, errE)
+			return
+		}
+		values.Set("sort", string(data))
+	}
+	for _, facet := range shared.Facets {
+		values.Add("facets", facet)
+	}
+
+	path, errE := s.Reverse("SearchResults", waf.Params{"s": sh.ID.String()}, values)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+	w.Header().Set("Location", path)
+	w.WriteHeader(http.StatusSeeOther)
+}
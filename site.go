@@ -4,18 +4,24 @@ import (
 	"encoding/json"
 	"io"
 	"strings"
+	"sync/atomic"
 
 	"github.com/alecthomas/kong"
 	"github.com/olivere/elastic/v7"
 	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
 	"gitlab.com/tozd/waf"
 	"gopkg.in/yaml.v3"
 
+	"gitlab.com/peerdb/peerdb/annotations"
 	"gitlab.com/peerdb/peerdb/coordinator"
 	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
 	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/search"
 	"gitlab.com/peerdb/peerdb/storage"
 	"gitlab.com/peerdb/peerdb/store"
+	"gitlab.com/peerdb/peerdb/tasks"
 )
 
 type Build struct {
@@ -35,11 +41,65 @@ type Site struct {
 
 	SizeField bool `json:"-" yaml:"sizeField,omitempty"`
 
+	// RestrictedProperties are properties whose claims are accessible only with RoleElevated.
+	RestrictedProperties []identifier.Identifier `json:"-" yaml:"restrictedProperties,omitempty"`
+	// ElevatedTokens are bearer tokens which grant RoleElevated.
+	ElevatedTokens []string `json:"-" yaml:"elevatedTokens,omitempty"`
+	// ModeratorTokens are bearer tokens which grant RoleModerator.
+	ModeratorTokens []string `json:"-" yaml:"moderatorTokens,omitempty"`
+	// CORS configures cross-origin access to the embeddable API and embedding of the search widget.
+	CORS *CORSConfig `json:"-" yaml:"cors,omitempty"`
+	// Scoring are scoring functions whose scores are added to scores of search results.
+	Scoring []search.ScoringFunction `json:"-" yaml:"scoring,omitempty"`
+	// FieldWeights are default weights of properties used when matching search queries.
+	FieldWeights search.FieldWeights `json:"-" yaml:"fieldWeights,omitempty"`
+	// NameProperties are properties whose claims are matched together against search queries, as parts of names.
+	NameProperties search.NameProperties `json:"-" yaml:"nameProperties,omitempty"`
+	// Sitemap enables generation of sitemaps for all documents of the site.
+	Sitemap bool `json:"-" yaml:"sitemap,omitempty"`
+	// Quota limits the size of the index. When exceeded, new writes are rejected.
+	Quota *es.Quota `json:"-" yaml:"quota,omitempty"`
+	// Limits are maximums enforced on search requests.
+	Limits search.Limits `json:"-" yaml:"limits,omitempty"`
+	// Fallbacks are strategies applied in order to relax searches which yield no results.
+	Fallbacks search.Fallbacks `json:"-" yaml:"fallbacks,omitempty"`
+	// TieBreakers are secondary sort specifications ordering search results with equal scores,
+	// before they are ordered by document ID.
+	TieBreakers search.TieBreakers `json:"-" yaml:"tieBreakers,omitempty"`
+	// IdentifierSchemes map names of identifier schemes usable in document paths
+	// (e.g., /d/isbn/<value>) to identifier properties.
+	IdentifierSchemes map[string]identifier.Identifier `json:"-" yaml:"identifierSchemes,omitempty"`
+	// Theme configures the appearance of the frontend.
+	Theme *SiteTheme `json:"-" yaml:"theme,omitempty"`
+	// Languages are languages supported by the site, the first one being the default.
+	// Default is DefaultLanguage.
+	Languages []string `json:"-" yaml:"languages,omitempty"`
+	// DefaultFacets are properties whose filters the frontend shows before other filters.
+	DefaultFacets []identifier.Identifier `json:"-" yaml:"defaultFacets,omitempty"`
+
 	// Data for Store is on purpose not document.D so that we can serve it directly without doing first JSON unmarshal just to marshal it again immediately.
 	store       *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes]
 	coordinator *coordinator.Coordinator[json.RawMessage, *types.DocumentBeginMetadata, *types.DocumentEndMetadata, *types.DocumentChangeMetadata]
 	storage     *storage.Storage
 	esProcessor *elastic.BulkProcessor
+	references  *es.References
+	generations *es.Generations
+	synonyms    *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, store.None]
+	redirects   *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, store.None]
+	redirectMap *redirectMap
+	annotations *annotations.Annotations
+	tasks       *tasks.Tasks
+	llmBudget   *search.LLMBudget
+	sitemaps    *sitemapsHolder
+	slowQueries *slowQueries
+	views       *search.Views
+	// reloadable holds the current reloadable settings. Use settings() to access them.
+	reloadable *atomic.Pointer[siteSettings]
+
+	// loadedProperties holds the current data types and names of core properties and properties in the index.
+	// They are loaded at initialization and on configuration reload, and properties added to the index
+	// in between are added when they are first used. Use properties() to access them.
+	loadedProperties *atomic.Pointer[siteProperties]
 
 	// TODO: How to keep propertiesTotal in sync with the number of properties available, if they are added or removed after initialization?
 	propertiesTotal int64
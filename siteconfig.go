@@ -0,0 +1,133 @@
+package peerdb
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/document"
+)
+
+// DefaultLanguage is the language of sites which do not configure their languages.
+const DefaultLanguage = "en"
+
+var themeColorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// filterClaimTypes are claim types for which search filters exist.
+var filterClaimTypes = []string{"relation", "amount", "time", "string"} //nolint:gochecknoglobals
+
+// SiteTheme is per-site configuration of the appearance of the frontend.
+type SiteTheme struct {
+	// PrimaryColor is the main color of the frontend, as a hex color (e.g., "#1d4ed8").
+	PrimaryColor string `json:"primaryColor,omitempty" yaml:"primaryColor,omitempty"`
+	// Logo is the URL of the logo shown instead of the title.
+	Logo string `json:"logo,omitempty" yaml:"logo,omitempty"`
+}
+
+// Validate validates the theme configuration.
+func (t *SiteTheme) Validate() error {
+	if t.PrimaryColor != "" && !themeColorRegexp.MatchString(t.PrimaryColor) {
+		return errors.Errorf(`primary color "%s" is not a hex color`, t.PrimaryColor)
+	}
+	return nil
+}
+
+type siteConfigProperty struct {
+	ID         identifier.Identifier `json:"id"`
+	ClaimTypes []string              `json:"claimTypes"`
+	Unit       *document.AmountUnit  `json:"unit,omitempty"`
+}
+
+type siteConfigFeatures struct {
+	LLMSearch       bool `json:"llmSearch"`
+	SemanticSearch  bool `json:"semanticSearch"`
+	Personalization bool `json:"personalization"`
+}
+
+type siteConfigResponse struct {
+	Title         string                  `json:"title"`
+	Theme         *SiteTheme              `json:"theme,omitempty"`
+	Languages     []string                `json:"languages"`
+	Properties    []siteConfigProperty    `json:"properties"`
+	DefaultFacets []identifier.Identifier `json:"defaultFacets"`
+	FacetSize     int                     `json:"facetSize"`
+	Features      siteConfigFeatures      `json:"features"`
+}
+
+// filterProperties returns properties in the registry which can be used in search filters,
+// together with claim types of filters they can be used in. Restricted properties are skipped
+// when the caller does not have the elevated role.
+func (s *Site) filterProperties(ctx context.Context) []siteConfigProperty {
+	properties := []siteConfigProperty{}
+	for id, dataType := range s.properties().registry {
+		if s.isRestricted(ctx, id) {
+			continue
+		}
+		claimTypes := []string{}
+		for _, claimType := range filterClaimTypes {
+			if dataType.ClaimTypes[claimType] {
+				claimTypes = append(claimTypes, claimType)
+			}
+		}
+		if len(claimTypes) == 0 {
+			continue
+		}
+		properties = append(properties, siteConfigProperty{
+			ID:         id,
+			ClaimTypes: claimTypes,
+			Unit:       dataType.Unit,
+		})
+	}
+	slices.SortFunc(properties, func(a, b siteConfigProperty) int {
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return properties
+}
+
+// siteConfig returns configuration of the site needed by the frontend to bootstrap itself.
+func (s *Service) siteConfig(ctx context.Context, site *Site) siteConfigResponse {
+	languages := site.Languages
+	if len(languages) == 0 {
+		languages = []string{DefaultLanguage}
+	}
+
+	defaultFacets := []identifier.Identifier{}
+	for _, id := range site.DefaultFacets {
+		if !site.isRestricted(ctx, id) {
+			defaultFacets = append(defaultFacets, id)
+		}
+	}
+
+	return siteConfigResponse{
+		Title:         site.Title,
+		Theme:         site.Theme,
+		Languages:     languages,
+		Properties:    site.filterProperties(ctx),
+		DefaultFacets: defaultFacets,
+		FacetSize:     site.settings().Limits.FacetSize(),
+		Features: siteConfigFeatures{
+			// Parsing of prompts reads API keys from environment variables.
+			LLMSearch: s.llm.Available(),
+			// TODO: Enable once search supports embeddings.
+			SemanticSearch:  false,
+			Personalization: s.personalization != nil,
+		},
+	}
+}
+
+// SiteConfigGet is a GET/HEAD HTTP request handler which returns configuration of the site:
+// its title and theme, supported languages, properties which can be used in search filters,
+// default facets, and which optional features are enabled.
+func (s *Service) SiteConfigGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	site := waf.MustGetSite[*Site](req.Context())
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, s.siteConfig(req.Context(), site), nil)
+}
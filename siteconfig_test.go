@@ -0,0 +1,81 @@
+package peerdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+func TestSiteConfig(t *testing.T) {
+	t.Parallel()
+
+	restricted := identifier.New()
+	site := &Site{ //nolint:exhaustruct
+		Title:                "Test",
+		Theme:                &SiteTheme{PrimaryColor: "#1d4ed8", Logo: "/logo.svg"},
+		DefaultFacets:        []identifier.Identifier{document.GetCorePropertyID("TYPE"), restricted},
+		RestrictedProperties: []identifier.Identifier{restricted},
+	}
+	registry := document.NewPropertyRegistry(document.CoreProperties)
+	unit := document.AmountUnitMetre
+	registry[restricted] = document.PropertyDataType{ //nolint:exhaustruct
+		ClaimTypes: map[string]bool{"amount": true},
+		Unit:       &unit,
+	}
+	site.setProperties(registry, search.NewPropertyNames(document.CoreProperties))
+
+	service := &Service{} //nolint:exhaustruct
+
+	config := service.siteConfig(context.WithValue(context.Background(), roleContextKey, RolePublic), site)
+	assert.Equal(t, "Test", config.Title)
+	assert.Equal(t, []string{DefaultLanguage}, config.Languages)
+	assert.Equal(t, []identifier.Identifier{document.GetCorePropertyID("TYPE")}, config.DefaultFacets)
+	assert.Equal(t, search.MaxResultsCount, config.FacetSize)
+	assert.False(t, config.Features.SemanticSearch)
+	assert.False(t, config.Features.Personalization)
+	assert.NotEmpty(t, config.Properties)
+	for _, property := range config.Properties {
+		assert.NotEqual(t, restricted, property.ID)
+		assert.NotEmpty(t, property.ClaimTypes)
+	}
+
+	config = service.siteConfig(context.WithValue(context.Background(), roleContextKey, RoleElevated), site)
+	assert.Contains(t, config.Properties, siteConfigProperty{ID: restricted, ClaimTypes: []string{"amount"}, Unit: &unit})
+	assert.Len(t, config.DefaultFacets, 2)
+
+	// Response has to match its schema.
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft2019)
+	for _, name := range []string{"api.json", "definitions.json"} {
+		data, err := schemaFiles.ReadFile("schema/" + name)
+		require.NoError(t, err)
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.NoError(t, compiler.AddResource(schemaBaseURL+name, doc))
+	}
+	schema, err := compiler.Compile(schemaBaseURL + "api.json#/$defs/siteConfig")
+	require.NoError(t, err)
+	data, err := json.Marshal(config)
+	require.NoError(t, err)
+	value, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.NoError(t, schema.Validate(value))
+}
+
+func TestSiteThemeValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, (&SiteTheme{PrimaryColor: "#1D4ed8", Logo: ""}).Validate())
+	assert.NoError(t, (&SiteTheme{PrimaryColor: "", Logo: "/logo.svg"}).Validate())
+	assert.Error(t, (&SiteTheme{PrimaryColor: "blue", Logo: ""}).Validate())
+	assert.Error(t, (&SiteTheme{PrimaryColor: "#fff", Logo: ""}).Validate())
+}
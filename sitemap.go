@@ -0,0 +1,343 @@
+package peerdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+const (
+	// DefaultSitemapInterval is the default interval at which sitemaps are regenerated.
+	DefaultSitemapInterval = 24 * time.Hour
+
+	// sitemapMaxURLs is the maximum number of URLs in one sitemap file, as limited by the sitemaps protocol.
+	sitemapMaxURLs = 50000
+
+	sitemapNamespace  = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	sitemapFileSuffix = ".xml.gz"
+)
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// sitemaps are generated sitemaps of a site.
+type sitemaps struct {
+	// Index is the sitemap index XML.
+	Index []byte
+	// Files are gzipped sitemap XML files, each with up to sitemapMaxURLs URLs.
+	Files     [][]byte
+	Generated time.Time
+}
+
+// sitemapsHolder holds the latest generated sitemaps of a site. Methods can be called
+// on nil *sitemapsHolder which never has any sitemaps.
+type sitemapsHolder struct {
+	mu       sync.RWMutex
+	sitemaps *sitemaps
+}
+
+func newSitemapsHolder() *sitemapsHolder {
+	return &sitemapsHolder{
+		mu:       sync.RWMutex{},
+		sitemaps: nil,
+	}
+}
+
+// get returns the latest generated sitemaps or nil if they have not been generated yet.
+func (h *sitemapsHolder) get() *sitemaps {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.sitemaps
+}
+
+func (h *sitemapsHolder) set(s *sitemaps) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sitemaps = s
+}
+
+func marshalSitemapXML(v interface{}) ([]byte, errors.E) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// buildSitemaps shards URLs into gzipped sitemap files with up to maxURLs URLs each
+// and builds the sitemap index listing all of them. fileURL returns the URL of the i-th file.
+func buildSitemaps(urls []sitemapURL, maxURLs int, fileURL func(i int) (string, errors.E), now time.Time) (*sitemaps, errors.E) {
+	result := &sitemaps{
+		Index:     nil,
+		Files:     [][]byte{},
+		Generated: now,
+	}
+	index := sitemapIndex{
+		XMLName:  xml.Name{}, //nolint:exhaustruct
+		Xmlns:    sitemapNamespace,
+		Sitemaps: []sitemapURL{},
+	}
+
+	for start := 0; start < len(urls); start += maxURLs {
+		end := min(start+maxURLs, len(urls))
+
+		data, errE := marshalSitemapXML(sitemapURLSet{
+			XMLName: xml.Name{}, //nolint:exhaustruct
+			Xmlns:   sitemapNamespace,
+			URLs:    urls[start:end],
+		})
+		if errE != nil {
+			return nil, errE
+		}
+
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		_, err := writer.Write(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = writer.Close()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		loc, errE := fileURL(len(result.Files))
+		if errE != nil {
+			return nil, errE
+		}
+
+		// Last modification of the file is the last modification of any of its URLs.
+		lastMod := ""
+		for _, u := range urls[start:end] {
+			// Timestamps are all in UTC and in the same format, so they can be compared as strings.
+			if u.LastMod > lastMod {
+				lastMod = u.LastMod
+			}
+		}
+
+		result.Files = append(result.Files, buf.Bytes())
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     loc,
+			LastMod: lastMod,
+		})
+	}
+
+	var errE errors.E
+	result.Index, errE = marshalSitemapXML(index)
+	if errE != nil {
+		return nil, errE
+	}
+
+	return result, nil
+}
+
+// siteBaseURL returns the base URL of the site used for absolute URLs.
+func siteBaseURL(site *Site) string {
+	return "https://" + site.Domain
+}
+
+// canonicalDocumentURL returns the absolute URL of the HTML frontend for the document.
+func (s *Service) canonicalDocumentURL(site *Site, id identifier.Identifier) (string, errors.E) {
+	path, errE := s.Reverse("DocumentGet", waf.Params{"id": id.String()}, nil)
+	if errE != nil {
+		return "", errE
+	}
+	return siteBaseURL(site) + path, nil
+}
+
+// setCanonicalHeaders sets Link header with the canonical URL of the document
+// and, if lastModified is provided, Last-Modified header.
+func (s *Service) setCanonicalHeaders(w http.ResponseWriter, site *Site, id identifier.Identifier, lastModified *time.Time) errors.E {
+	u, errE := s.canonicalDocumentURL(site, id)
+	if errE != nil {
+		return errE
+	}
+	w.Header().Add("Link", "<"+u+`>; rel="canonical"`)
+	if lastModified != nil && !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	return nil
+}
+
+// generateSitemaps generates sitemaps for all documents of the site. Deleted and
+// redirected documents are skipped. Last modification of a document is the time
+// of its latest version.
+func (s *Service) generateSitemaps(ctx context.Context, site *Site) (*sitemaps, errors.E) {
+	urls := []sitemapURL{}
+
+	var after *identifier.Identifier
+	for {
+		ids, errE := site.store.List(ctx, after)
+		if errE != nil {
+			return nil, errE
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		for _, id := range ids {
+			if _, redirected := site.redirectMap.resolve(id); redirected {
+				continue
+			}
+
+			// TODO: Add API to store to get only metadata of the latest version.
+			_, metadata, _, errE := site.store.GetLatest(ctx, id)
+			if errors.Is(errE, store.ErrValueDeleted) {
+				continue
+			} else if errE != nil {
+				errors.Details(errE)["doc"] = id.String()
+				return nil, errE
+			}
+
+			loc, errE := s.canonicalDocumentURL(site, id)
+			if errE != nil {
+				return nil, errE
+			}
+
+			lastMod := ""
+			if metadata != nil && !time.Time(metadata.At).IsZero() {
+				lastMod = time.Time(metadata.At).UTC().Format(time.RFC3339)
+			}
+
+			urls = append(urls, sitemapURL{
+				Loc:     loc,
+				LastMod: lastMod,
+			})
+		}
+
+		after = &ids[len(ids)-1]
+	}
+
+	return buildSitemaps(urls, sitemapMaxURLs, func(i int) (string, errors.E) {
+		path, errE := s.Reverse("SitemapFile", waf.Params{"file": strconv.Itoa(i+1) + sitemapFileSuffix}, nil)
+		if errE != nil {
+			return "", errE
+		}
+		return siteBaseURL(site) + path, nil
+	}, time.Now())
+}
+
+// regenerateSitemaps generates sitemaps of the site at startup and then
+// regenerates them at the interval, until the context is canceled.
+func (s *Service) regenerateSitemaps(ctx context.Context, site *Site, interval time.Duration) {
+	for {
+		start := time.Now()
+		result, errE := s.generateSitemaps(ctx, site)
+		if ctx.Err() != nil {
+			return
+		}
+		if errE != nil {
+			s.Logger.Error().Err(errE).Str("domain", site.Domain).Msg("sitemaps generation failed")
+		} else {
+			site.sitemaps.set(result)
+			s.Logger.Info().Str("domain", site.Domain).Int("files", len(result.Files)).
+				Dur("duration", time.Since(start)).Msg("sitemaps generated")
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Service) serveSitemap(w http.ResponseWriter, req *http.Request, mediaType string, generated time.Time, data []byte) {
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, req, "", generated, bytes.NewReader(data))
+}
+
+// getSitemaps returns generated sitemaps of the site or replies with
+// an error and returns nil if sitemaps are disabled or not yet generated.
+func (s *Service) getSitemaps(w http.ResponseWriter, req *http.Request) *sitemaps {
+	site := waf.MustGetSite[*Site](req.Context())
+
+	if !site.Sitemap {
+		s.NotFoundWithError(w, req, errors.New("sitemaps are disabled"))
+		return nil
+	}
+
+	result := site.sitemaps.get()
+	if result == nil {
+		w.Header().Set("Retry-After", "60")
+		s.replyWithError(w, req, http.StatusServiceUnavailable, errors.New("sitemaps have not yet been generated"))
+		return nil
+	}
+
+	return result
+}
+
+// Sitemap is a GET/HEAD HTTP request handler which returns the sitemap index
+// listing all sitemap files of the site.
+func (s *Service) Sitemap(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	result := s.getSitemaps(w, req)
+	if result == nil {
+		return
+	}
+
+	s.serveSitemap(w, req, "application/xml; charset=utf-8", result.Generated, result.Index)
+}
+
+// SitemapFile is a GET/HEAD HTTP request handler which returns a gzipped sitemap file
+// given its name as a parameter.
+func (s *Service) SitemapFile(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	result := s.getSitemaps(w, req)
+	if result == nil {
+		return
+	}
+
+	number, ok := strings.CutSuffix(params["file"], sitemapFileSuffix)
+	if !ok {
+		s.NotFound(w, req)
+		return
+	}
+	i, err := strconv.Atoi(number)
+	if err != nil || i < 1 || i > len(result.Files) {
+		s.NotFound(w, req)
+		return
+	}
+
+	s.serveSitemap(w, req, "application/gzip", result.Generated, result.Files[i-1])
+}
@@ -0,0 +1,64 @@
+package peerdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+)
+
+func TestBuildSitemaps(t *testing.T) {
+	t.Parallel()
+
+	urls := []sitemapURL{
+		{Loc: "https://example.com/d/1", LastMod: "2024-01-02T00:00:00Z"},
+		{Loc: "https://example.com/d/2", LastMod: "2024-03-04T00:00:00Z"},
+		{Loc: "https://example.com/d/3", LastMod: ""},
+	}
+	fileURL := func(i int) (string, errors.E) {
+		return "https://example.com/sitemap/" + strconv.Itoa(i+1) + ".xml.gz", nil
+	}
+	now := time.Now()
+
+	result, errE := buildSitemaps(urls, 2, fileURL, now)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, now, result.Generated)
+	require.Len(t, result.Files, 2)
+
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+
+		`<sitemap><loc>https://example.com/sitemap/1.xml.gz</loc><lastmod>2024-03-04T00:00:00Z</lastmod></sitemap>`+
+		`<sitemap><loc>https://example.com/sitemap/2.xml.gz</loc></sitemap>`+
+		`</sitemapindex>`, string(result.Index))
+
+	for i, expected := range []string{
+		`<url><loc>https://example.com/d/1</loc><lastmod>2024-01-02T00:00:00Z</lastmod></url>` +
+			`<url><loc>https://example.com/d/2</loc><lastmod>2024-03-04T00:00:00Z</lastmod></url>`,
+		`<url><loc>https://example.com/d/3</loc></url>`,
+	} {
+		reader, err := gzip.NewReader(bytes.NewReader(result.Files[i]))
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`+expected+`</urlset>`, string(data))
+	}
+}
+
+func TestBuildSitemapsEmpty(t *testing.T) {
+	t.Parallel()
+
+	result, errE := buildSitemaps(nil, sitemapMaxURLs, func(_ int) (string, errors.E) {
+		return "", errors.New("unexpected")
+	}, time.Now())
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Empty(t, result.Files)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"></sitemapindex>`, string(result.Index))
+}
@@ -0,0 +1,157 @@
+package peerdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/search"
+)
+
+// DefaultSlowQueries is the default number of slowest search requests kept per site.
+const DefaultSlowQueries = 20
+
+// slowQuery describes one search request.
+//
+// Durations are in milliseconds.
+type slowQuery struct {
+	Time      time.Time       `json:"time"`
+	RequestID string          `json:"requestId"`
+	State     string          `json:"s,omitempty"`
+	Query     string          `json:"q"`
+	Prompt    string          `json:"p,omitempty"`
+	Filters   json.RawMessage `json:"filters,omitempty"`
+	Duration  int64           `json:"duration"`
+	Elastic   int64           `json:"elastic"`
+	LLM       int64           `json:"llm,omitempty"`
+	ESQuery   json.RawMessage `json:"esQuery,omitempty"`
+}
+
+// slowQueries keeps the slowest search requests of a site, the slowest first.
+// Methods can be called on nil *slowQueries which never keeps any requests.
+type slowQueries struct {
+	mu      sync.Mutex
+	size    int
+	queries []slowQuery
+}
+
+func newSlowQueries(size int) *slowQueries {
+	if size <= 0 {
+		return nil
+	}
+	return &slowQueries{
+		mu:      sync.Mutex{},
+		size:    size,
+		queries: make([]slowQuery, 0, size),
+	}
+}
+
+// slower returns true if a request with the duration would be kept.
+func (s *slowQueries) slower(duration int64) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queries) < s.size || duration > s.queries[len(s.queries)-1].Duration
+}
+
+// Add adds the request if it is slower than the fastest request kept,
+// which is then dropped when there are already size requests kept.
+func (s *slowQueries) Add(query slowQuery) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, _ := slices.BinarySearchFunc(s.queries, query.Duration, func(q slowQuery, duration int64) int {
+		// Sorted in descending order. Among equal durations, the newer request comes last.
+		if q.Duration >= duration {
+			return -1
+		}
+		return 1
+	})
+	if i >= s.size {
+		return
+	}
+	if len(s.queries) == s.size {
+		s.queries = s.queries[:len(s.queries)-1]
+	}
+	s.queries = slices.Insert(s.queries, i, query)
+}
+
+// Get returns a copy of kept requests, the slowest first.
+func (s *slowQueries) Get() []slowQuery {
+	if s == nil {
+		return []slowQuery{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.queries)
+}
+
+// recordSlowQuery records the search request among the slowest requests of the site,
+// if it is slow enough. The ElasticSearch query is serialized only in that case.
+func recordSlowQuery(req *http.Request, start time.Time, sh *search.State, took time.Duration, query elastic.Query) {
+	ctx := req.Context()
+	site := waf.MustGetSite[*Site](ctx)
+
+	duration := time.Since(start).Milliseconds()
+	if !site.slowQueries.slower(duration) {
+		return
+	}
+
+	q := slowQuery{
+		Time:      start.UTC(),
+		RequestID: waf.MustRequestID(ctx).String(),
+		State:     "",
+		Query:     "",
+		Prompt:    "",
+		Filters:   nil,
+		Duration:  duration,
+		Elastic:   took.Milliseconds(),
+		LLM:       0,
+		ESQuery:   nil,
+	}
+	// Structured search queries do not have a search state.
+	if sh != nil {
+		var llm time.Duration
+		for i := range sh.PromptCalls {
+			llm += time.Duration(sh.PromptCalls[i].Duration)
+		}
+		q.State = sh.ID.String()
+		q.Query = sh.SearchQuery
+		q.Prompt = sh.Prompt
+		q.LLM = llm.Milliseconds()
+		if sh.Filters != nil {
+			// Errors are ignored because only a description of the request is recorded.
+			q.Filters, _ = x.MarshalWithoutEscapeHTML(sh.Filters)
+		}
+	}
+	if source, err := query.Source(); err == nil {
+		q.ESQuery, _ = x.MarshalWithoutEscapeHTML(source)
+	}
+
+	site.slowQueries.Add(q)
+}
+
+// AdminSlowQueriesGet is a GET/HEAD HTTP request handler which returns the slowest recent
+// search requests of the site (slowest first), together with their durations and generated
+// ElasticSearch queries. It requires the elevated role.
+func (s *Service) AdminSlowQueriesGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	site := waf.MustGetSite[*Site](req.Context())
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, site.slowQueries.Get(), nil)
+}
@@ -0,0 +1,33 @@
+package peerdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowQueries(t *testing.T) {
+	t.Parallel()
+
+	var disabled *slowQueries
+	assert.Nil(t, newSlowQueries(0))
+	assert.False(t, disabled.slower(1000))
+	disabled.Add(slowQuery{Duration: 1000}) //nolint:exhaustruct
+	assert.Empty(t, disabled.Get())
+
+	s := newSlowQueries(3)
+	for i, duration := range []int64{5, 1, 7, 3, 7, 2} {
+		if s.slower(duration) {
+			s.Add(slowQuery{RequestID: string(rune('a' + i)), Duration: duration}) //nolint:exhaustruct
+		}
+	}
+	assert.False(t, s.slower(5))
+	assert.True(t, s.slower(6))
+
+	ids := []string{}
+	for _, q := range s.Get() {
+		ids = append(ids, q.RequestID)
+	}
+	// Among equal durations, the older request comes first.
+	assert.Equal(t, []string{"c", "e", "a"}, ids)
+}
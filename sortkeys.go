@@ -0,0 +1,146 @@
+package peerdb
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	"gitlab.com/peerdb/peerdb/document"
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// SortKeysCommand backfills materialized sort keys of existing documents of all sites.
+//
+// Documents indexed before sort keys were materialized (see es.PrepareDocument) do not
+// have them and are sorted last when sorting by them. The command updates the mapping of
+// ElasticSearch indices and reindexes the latest version of all documents.
+type SortKeysCommand struct{}
+
+func (c *SortKeysCommand) Run(globals *Globals) errors.E {
+	// We stop gracefully on ctrl-c and TERM signal.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbpool, errE := internal.InitPostgres(ctx, string(globals.Postgres.URL), globals.Logger, getRequestWithFallback(globals.Logger))
+	if errE != nil {
+		return errE
+	}
+
+	esClient, errE := es.GetClient(cleanhttp.DefaultPooledClient(), globals.Logger, globals.Elastic.URL)
+	if errE != nil {
+		return errE
+	}
+
+	for _, site := range backupSites(globals) {
+		// We set fallback context values which are used to set application name on PostgreSQL connections.
+		siteCtx := context.WithValue(ctx, requestIDContextKey, "sort-keys")
+		siteCtx = context.WithValue(siteCtx, schemaContextKey, site.Schema)
+
+		s, _, _, esProcessor, references, generations, _, errE := es.InitForSite(siteCtx, globals.Logger, dbpool, esClient, site.Schema, site.Index, site.SizeField, site.RestrictedProperties)
+		if errE != nil {
+			return errE
+		}
+
+		errE = es.UpdateSortKeysMapping(siteCtx, esClient, site.Index, site.SizeField)
+		if errE != nil {
+			esProcessor.Close()
+			return errE
+		}
+
+		count, errE := reindexDocuments(siteCtx, s, esProcessor, references, generations, site.Index, nil)
+		if errE == nil {
+			errE = errors.WithStack(esProcessor.Flush())
+		}
+		esProcessor.Close()
+		if errE != nil {
+			errors.Details(errE)["schema"] = site.Schema
+			return errE
+		}
+
+		globals.Logger.Info().Str("schema", site.Schema).Str("index", site.Index).Int64("count", count).Msg("reindexed documents")
+	}
+
+	globals.Logger.Info().Msg("Done.")
+
+	return nil
+}
+
+// reindexDocuments reindexes the latest version of all documents in the store into the index,
+// which is the index itself or the concrete index of the generation being built (see es.Generations).
+// If progress is provided, it is called with the number of documents reindexed so far.
+func reindexDocuments(
+	ctx context.Context,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	esProcessor *elastic.BulkProcessor, references *es.References, generations *es.Generations, index string,
+	progress func(count int64),
+) (int64, errors.E) {
+	count := int64(0)
+
+	var after *identifier.Identifier
+	for {
+		ids, errE := s.List(ctx, after)
+		if errE != nil {
+			return count, errE
+		}
+		if len(ids) == 0 {
+			return count, nil
+		}
+
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return count, errors.WithStack(ctx.Err())
+			}
+
+			reindexed, errE := reindexDocument(ctx, s, esProcessor, references, generations, index, id)
+			if errE != nil {
+				return count, errE
+			}
+			if reindexed {
+				count++
+			}
+		}
+
+		if progress != nil {
+			progress(count)
+		}
+
+		after = &ids[len(ids)-1]
+	}
+}
+
+// reindexDocument reindexes the latest version of the document into the index.
+// It returns false if the document has been deleted.
+func reindexDocument(
+	ctx context.Context,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	esProcessor *elastic.BulkProcessor, references *es.References, generations *es.Generations, index string,
+	id identifier.Identifier,
+) (bool, errors.E) {
+	data, _, version, errE := s.GetLatest(ctx, id)
+	if errors.Is(errE, store.ErrValueDeleted) {
+		return false, nil
+	} else if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		return false, errE
+	}
+
+	// References of the document are already up to date, so no other documents have to be reindexed.
+	data, _, errE = references.PrepareDocument(ctx, id, data)
+	if errE != nil {
+		errors.Details(errE)["doc"] = id.String()
+		return false, errE
+	}
+
+	esProcessor.Add(generations.IndexRequest(index, id, version.Revision, data))
+	return true, nil
+}
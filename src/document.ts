@@ -99,6 +99,7 @@ class AmountClaim extends CoreClaim {
   prop!: DocumentReference
   amount!: number
   unit!: AmountUnit
+  decimal?: string
 
   constructor(obj: object) {
     super()
@@ -142,6 +143,8 @@ class FileClaim extends CoreClaim {
   mediaType!: string
   url!: string
   preview?: string[]
+  blurhash?: string
+  checksum?: string
 
   constructor(obj: object) {
     super()
@@ -307,12 +310,16 @@ export class PeerDBDocument implements ClaimsContainer {
   scores?: Record<string, number>
   mnemonic?: string
   claims?: ClaimTypes
+  children?: PeerDBDocument[]
 
   constructor(obj: object) {
     Object.assign(this, obj)
     if (typeof this.claims !== "undefined") {
       this.claims = new ClaimTypes(this.claims)
     }
+    if (typeof this.children !== "undefined") {
+      this.children = this.children.map((child) => new PeerDBDocument(child))
+    }
   }
 
   GetID(): string {
@@ -856,6 +863,8 @@ export class FileClaimPatch implements ClaimPatch {
   mediaType?: string
   url?: string
   preview?: string[]
+  blurhash?: string
+  checksum?: string
 
   constructor(obj: object) {
     if ("type" in obj && obj.type !== "file") {
@@ -879,11 +888,20 @@ export class FileClaimPatch implements ClaimPatch {
       mediaType: this.mediaType,
       url: this.url,
       preview: this.preview,
+      blurhash: this.blurhash,
+      checksum: this.checksum,
     })
   }
 
   Apply(claim: Claim): void {
-    if (typeof this.prop === "undefined" && typeof this.mediaType === "undefined" && typeof this.url === "undefined" && typeof this.preview === "undefined") {
+    if (
+      typeof this.prop === "undefined" &&
+      typeof this.mediaType === "undefined" &&
+      typeof this.url === "undefined" &&
+      typeof this.preview === "undefined" &&
+      typeof this.blurhash === "undefined" &&
+      typeof this.checksum === "undefined"
+    ) {
       throw new Error("empty patch")
     }
 
@@ -903,6 +921,12 @@ export class FileClaimPatch implements ClaimPatch {
     if (typeof this.preview !== "undefined") {
       claim.preview = this.preview
     }
+    if (typeof this.blurhash !== "undefined") {
+      claim.blurhash = this.blurhash
+    }
+    if (typeof this.checksum !== "undefined") {
+      claim.checksum = this.checksum
+    }
   }
 }
 
@@ -738,6 +738,8 @@ export function useTimeHistogramValues(
             s: s.value,
             prop: r.id,
           },
+          // The histogram is rendered with bins of equal duration.
+          query: encodeQuery({ interval: "equal" }),
         }).href
       } else {
         throw new Error(`unexpected type "${r.type}" for property "${r.id}"`)
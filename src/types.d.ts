@@ -3,7 +3,7 @@ import type { NONE } from "@/symbols"
 
 type TranslatableHTMLString = Record<string, string>
 
-type AmountUnit = "@" | "1" | "/" | "kg/kg" | "kg" | "kg/m³" | "m" | "m²" | "m/s" | "V" | "W" | "Pa" | "C" | "J" | "°C" | "rad" | "Hz" | "$" | "B" | "px" | "s"
+type AmountUnit = "@" | "1" | "/" | "kg/kg" | "kg" | "kg/m³" | "m" | "m²" | "m/s" | "V" | "W" | "Pa" | "C" | "J" | "°C" | "rad" | "Hz" | "$" | "B" | "px" | "s" | "€" | "£" | "¥"
 
 type TimePrecision = "G" | "100M" | "10M" | "M" | "100k" | "10k" | "k" | "100y" | "10y" | "y" | "m" | "d" | "h" | "min" | "s"
 
@@ -24,6 +24,8 @@ export type TimeSearchResult = {
   id: string
   count: number
   type: "time"
+  interval?: string
+  histogram?: TimeValuesResult[]
 }
 
 export type StringSearchResult = {
@@ -115,6 +117,7 @@ export type TimeNoneFilter = {
 export type StringFilter = {
   prop: string
   str: string
+  match?: "exact" | "phrase" | "prefix"
 }
 
 export type StringNoneFilter = {
@@ -181,6 +184,7 @@ export type ServerSearchState = {
   promptDone?: boolean
   promptCalls?: object[]
   promptError?: boolean
+  promptProvider?: string
 }
 
 export type ClientSearchState = {
@@ -206,6 +210,27 @@ export type SiteContext = {
   title: string
 }
 
+export type SiteConfig = {
+  title: string
+  theme?: {
+    primaryColor?: string
+    logo?: string
+  }
+  languages: string[]
+  properties: {
+    id: string
+    claimTypes: ("relation" | "amount" | "time" | "string")[]
+    unit?: string
+  }[]
+  defaultFacets: string[]
+  facetSize: number
+  features: {
+    llmSearch: boolean
+    semanticSearch: boolean
+    personalization: boolean
+  }
+}
+
 // Symbol is not generated by the server side, but we can easily support it here.
 type ItemTypes = BareItem | ItemTypes[]
 
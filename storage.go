@@ -42,8 +42,18 @@ func (s *Service) StorageBeginUploadPost(w http.ResponseWriter, req *http.Reques
 
 	ctx := req.Context()
 
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	if !s.validateJSON(w, req, "storageBeginUploadRequest", buffer) {
+		return
+	}
+
 	var payload storageBeginUploadRequest
-	errE := x.DecodeJSONWithoutUnknownFields(req.Body, &payload)
+	errE := x.UnmarshalWithoutUnknownFields(buffer, &payload)
 	if errE != nil {
 		s.BadRequestWithError(w, req, errE)
 		return
@@ -186,8 +196,6 @@ func (s *Service) StorageGetChunkGet(w http.ResponseWriter, req *http.Request, p
 	s.WriteJSON(w, req, storageGetChunkResponse{Start: start, Length: length}, nil)
 }
 
-type emptyRequest struct{}
-
 func (s *Service) StorageEndUploadPost(w http.ResponseWriter, req *http.Request, params waf.Params) {
 	defer req.Body.Close()
 	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
@@ -200,10 +208,7 @@ func (s *Service) StorageEndUploadPost(w http.ResponseWriter, req *http.Request,
 		return
 	}
 
-	var ea emptyRequest
-	errE = x.DecodeJSONWithoutUnknownFields(req.Body, &ea)
-	if errE != nil {
-		s.BadRequestWithError(w, req, errE)
+	if !s.validateEmptyRequest(w, req) {
 		return
 	}
 
@@ -239,10 +244,7 @@ func (s *Service) StorageDiscardUploadPost(w http.ResponseWriter, req *http.Requ
 		return
 	}
 
-	var ea emptyRequest
-	errE = x.DecodeJSONWithoutUnknownFields(req.Body, &ea)
-	if errE != nil {
-		s.BadRequestWithError(w, req, errE)
+	if !s.validateEmptyRequest(w, req) {
 		return
 	}
 
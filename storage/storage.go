@@ -268,3 +268,23 @@ func (s *Storage) DiscardUpload(ctx context.Context, session identifier.Identifi
 	_, errE := s.coordinator.End(ctx, session, metadata)
 	return errE
 }
+
+// Put stores data as a new file in one upload and returns its ID.
+func (s *Storage) Put(ctx context.Context, data []byte, mediaType, filename string) (identifier.Identifier, errors.E) {
+	session, errE := s.BeginUpload(ctx, int64(len(data)), mediaType, filename)
+	if errE != nil {
+		return identifier.Identifier{}, errE
+	}
+
+	errE = s.UploadChunk(ctx, session, data, 0)
+	if errE != nil {
+		return identifier.Identifier{}, errors.Join(errE, s.DiscardUpload(ctx, session))
+	}
+
+	errE = s.EndUpload(ctx, session)
+	if errE != nil {
+		return identifier.Identifier{}, errE
+	}
+
+	return session, nil
+}
@@ -173,3 +173,19 @@ func TestErrors(t *testing.T) {
 	errE = s.DiscardUpload(ctx, session)
 	assert.NoError(t, errE, "% -+#.1v", errE)
 }
+
+func TestPut(t *testing.T) {
+	t.Parallel()
+
+	ctx, s, _ := initDatabase(t)
+
+	id, errE := s.Put(ctx, []byte("foobar"), "text/plain", "test.txt")
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	data, metadata, _, errE := s.Store().GetLatest(ctx, id)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, []byte("foobar"), data)
+	assert.Equal(t, int64(6), metadata.Size)
+	assert.Equal(t, "text/plain", metadata.MediaType)
+	assert.Equal(t, "test.txt", metadata.Filename)
+}
@@ -450,6 +450,8 @@ func (s *Store[Data, Metadata, CreateViewMetadata, ReleaseViewMetadata, CommitMe
 				// Nothing.
 			case internal.ErrorCodeDuplicateTable:
 				// Nothing.
+			case internal.ErrorCodeReadOnlyTransaction:
+				// In read-only mode, tables have to be created by another instance.
 			default:
 				return errE
 			}
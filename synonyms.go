@@ -0,0 +1,342 @@
+package peerdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/olivere/elastic/v7"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/internal/types"
+	"gitlab.com/peerdb/peerdb/search"
+	"gitlab.com/peerdb/peerdb/store"
+	"gitlab.com/peerdb/peerdb/tasks"
+)
+
+// synonymSet is a synonym set together with its ID, as returned by the admin API.
+type synonymSet struct {
+	ID identifier.Identifier `json:"id"`
+
+	search.SynonymSet
+}
+
+type synonymSetCreateResponse struct {
+	ID   identifier.Identifier `json:"id"`
+	Task identifier.Identifier `json:"task"`
+}
+
+// initSynonyms initializes the store of site's synonym sets and makes sure
+// that the site's index uses synonym rules from stored synonym sets.
+func initSynonyms(ctx context.Context, dbpool *pgxpool.Pool, esClient *elastic.Client, site *Site, synonymsDir string) errors.E {
+	site.synonyms = &store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, store.None]{
+		Prefix:       "synonyms",
+		Committed:    nil,
+		DataType:     "jsonb",
+		MetadataType: "jsonb",
+		PatchType:    "",
+	}
+	errE := site.synonyms.Init(ctx, dbpool)
+	if errE != nil {
+		return errE
+	}
+
+	if internal.IsReadOnly(ctx) {
+		// In read-only mode, synonym rules of the index are kept up to date by another instance.
+		return nil
+	}
+
+	return updateSynonyms(ctx, esClient, site, synonymsDir)
+}
+
+// listSynonymSets returns all synonym sets of the site, ordered by their IDs.
+func listSynonymSets(ctx context.Context, site *Site) ([]synonymSet, errors.E) {
+	sets := []synonymSet{}
+	var after *identifier.Identifier
+	for {
+		ids, errE := site.synonyms.List(ctx, after)
+		if errE != nil {
+			return nil, errE
+		}
+		if len(ids) == 0 {
+			return sets, nil
+		}
+
+		for _, id := range ids {
+			data, _, _, errE := site.synonyms.GetLatest(ctx, id)
+			if errors.Is(errE, store.ErrValueDeleted) {
+				continue
+			} else if errE != nil {
+				errors.Details(errE)["id"] = id.String()
+				return nil, errE
+			}
+			set := synonymSet{ID: id} //nolint:exhaustruct
+			errE = x.UnmarshalWithoutUnknownFields(data, &set.SynonymSet)
+			if errE != nil {
+				errors.Details(errE)["id"] = id.String()
+				return nil, errE
+			}
+			sets = append(sets, set)
+		}
+
+		after = &ids[len(ids)-1]
+	}
+}
+
+// synonymRules returns synonym rules from all stored synonym sets of the site.
+func synonymRules(ctx context.Context, site *Site) ([]string, errors.E) {
+	sets, errE := listSynonymSets(ctx, site)
+	if errE != nil {
+		return nil, errE
+	}
+	s := make([]search.SynonymSet, 0, len(sets))
+	for _, set := range sets {
+		s = append(s, set.SynonymSet)
+	}
+	return search.SynonymRules(s), nil
+}
+
+// updateSynonyms updates synonym rules used by the site's index to rules from all stored synonym sets.
+// See es.UpdateSynonyms for synonymsDir.
+func updateSynonyms(ctx context.Context, esClient *elastic.Client, site *Site, synonymsDir string) errors.E {
+	rules, errE := synonymRules(ctx, site)
+	if errE != nil {
+		return errE
+	}
+	return es.UpdateSynonyms(ctx, esClient, site.Index, rules, synonymsDir)
+}
+
+// updateSynonymsAfterChange updates synonym rules of the site's index after synonym sets changed.
+// Updates are serialized so that the index ends up with rules from the latest synonym sets.
+func (s *Service) updateSynonymsAfterChange(ctx context.Context, site *Site) errors.E {
+	s.synonymsMu.Lock()
+	defer s.synonymsMu.Unlock()
+
+	return updateSynonyms(ctx, s.esClient, site, s.synonymsDir)
+}
+
+// updateSynonymsTask returns a task function which updates synonym rules of the site's index.
+func (s *Service) updateSynonymsTask(site *Site) tasks.Func {
+	return func(ctx context.Context, progress tasks.Progress) (string, errors.E) {
+		errE := s.updateSynonymsAfterChange(ctx, site)
+		if errE != nil {
+			return "", errE
+		}
+		progress(1, 1)
+		return "", nil
+	}
+}
+
+// readSynonymSet reads and validates the synonym set from the request body.
+func (s *Service) readSynonymSet(w http.ResponseWriter, req *http.Request) (json.RawMessage, bool) {
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return nil, false
+	}
+
+	if !s.validateJSON(w, req, "synonymSet", buffer) {
+		return nil, false
+	}
+
+	var set search.SynonymSet
+	errE := x.UnmarshalWithoutUnknownFields(buffer, &set)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return nil, false
+	}
+
+	errE = set.Validate()
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return nil, false
+	}
+
+	data, errE := x.MarshalWithoutEscapeHTML(set)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return nil, false
+	}
+
+	return data, true
+}
+
+// AdminSynonymsGet is a GET/HEAD HTTP request handler which returns all synonym sets
+// of the site. It requires the elevated role.
+func (s *Service) AdminSynonymsGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	sets, errE := listSynonymSets(req.Context(), waf.MustGetSite[*Site](req.Context()))
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, sets, nil)
+}
+
+// AdminSynonymsPost is a POST HTTP request handler which creates a new synonym set and
+// applies it to search queries. Synonym rules of the index are updated by a task whose ID
+// is returned. It requires the elevated role.
+func (s *Service) AdminSynonymsPost(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	data, ok := s.readSynonymSet(w, req)
+	if !ok {
+		return
+	}
+
+	ctx := req.Context()
+	site := waf.MustGetSite[*Site](ctx)
+
+	id := identifier.New()
+	_, errE := site.synonyms.Insert(ctx, id, data, &types.DocumentMetadata{
+		At: types.Time(time.Now().UTC()),
+	}, &types.NoMetadata{})
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	task, errE := site.tasks.Enqueue(ctx, "synonyms", s.updateSynonymsTask(site))
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, synonymSetCreateResponse{ID: id, Task: task.ID}, nil)
+}
+
+// getSynonymSetVersion returns the ID and the latest version of the synonym set
+// given its ID as a parameter, or replies with an error.
+func (s *Service) getSynonymSetVersion(
+	w http.ResponseWriter, req *http.Request, params waf.Params,
+) (identifier.Identifier, json.RawMessage, store.Version, bool) {
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"id" is not a valid identifier`))
+		return identifier.Identifier{}, nil, store.Version{}, false
+	}
+
+	data, _, version, errE := waf.MustGetSite[*Site](req.Context()).synonyms.GetLatest(req.Context(), id)
+	if errors.Is(errE, store.ErrValueNotFound) || errors.Is(errE, store.ErrValueDeleted) {
+		s.NotFoundWithError(w, req, errE)
+		return identifier.Identifier{}, nil, store.Version{}, false
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return identifier.Identifier{}, nil, store.Version{}, false
+	}
+
+	return id, data, version, true
+}
+
+// AdminSynonymSetGet is a GET/HEAD HTTP request handler which returns the synonym set
+// given its ID as a parameter. It requires the elevated role.
+func (s *Service) AdminSynonymSetGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	id, data, _, ok := s.getSynonymSetVersion(w, req, params)
+	if !ok {
+		return
+	}
+
+	set := synonymSet{ID: id} //nolint:exhaustruct
+	errE := x.UnmarshalWithoutUnknownFields(data, &set.SynonymSet)
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, set, nil)
+}
+
+// AdminSynonymSetPut is a PUT HTTP request handler which replaces the synonym set
+// given its ID as a parameter and applies it to search queries. Synonym rules of the index
+// are updated by a task whose ID is returned. It requires the elevated role.
+func (s *Service) AdminSynonymSetPut(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	id, _, version, ok := s.getSynonymSetVersion(w, req, params)
+	if !ok {
+		return
+	}
+
+	data, ok := s.readSynonymSet(w, req)
+	if !ok {
+		return
+	}
+
+	ctx := req.Context()
+	site := waf.MustGetSite[*Site](ctx)
+
+	_, errE := site.synonyms.Replace(ctx, id, version.Changeset, data, &types.DocumentMetadata{
+		At: types.Time(time.Now().UTC()),
+	}, &types.NoMetadata{})
+	if errors.Is(errE, store.ErrConflict) || errors.Is(errE, store.ErrParentInvalid) {
+		// The synonym set has been changed concurrently.
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.startTask(w, req, "synonyms", s.updateSynonymsTask(site))
+}
+
+// AdminSynonymSetDelete is a DELETE HTTP request handler which deletes the synonym set
+// given its ID as a parameter and stops applying it to search queries. Synonym rules of the index
+// are updated by a task whose ID is returned. It requires the elevated role.
+func (s *Service) AdminSynonymSetDelete(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	id, _, version, ok := s.getSynonymSetVersion(w, req, params)
+	if !ok {
+		return
+	}
+
+	ctx := req.Context()
+	site := waf.MustGetSite[*Site](ctx)
+
+	_, errE := site.synonyms.Delete(ctx, id, version.Changeset, &types.DocumentMetadata{
+		At: types.Time(time.Now().UTC()),
+	}, &types.NoMetadata{})
+	if errors.Is(errE, store.ErrConflict) || errors.Is(errE, store.ErrParentInvalid) {
+		// The synonym set has been changed concurrently.
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.startTask(w, req, "synonyms", s.updateSynonymsTask(site))
+}
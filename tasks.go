@@ -0,0 +1,212 @@
+package peerdb
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	"gitlab.com/peerdb/peerdb/internal/es"
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/tasks"
+)
+
+type taskResponse struct {
+	Success bool                  `json:"success"`
+	Task    identifier.Identifier `json:"task"`
+}
+
+// startTask enqueues a task of the type running fn and replies with its ID, or replies with an error.
+func (s *Service) startTask(w http.ResponseWriter, req *http.Request, taskType string, fn tasks.Func) {
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	task, errE := waf.MustGetSite[*Site](ctx).tasks.Enqueue(ctx, taskType, fn)
+	m.Stop()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, taskResponse{Success: true, Task: task.ID}, nil)
+}
+
+// TaskGet is a GET/HEAD HTTP request handler which returns the status and progress of the task
+// with the ID given as a parameter. It requires the elevated role.
+func (s *Service) TaskGet(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"id" is not a valid identifier`))
+		return
+	}
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	task, errE := waf.MustGetSite[*Site](ctx).tasks.Get(ctx, id)
+	m.Stop()
+	if errors.Is(errE, tasks.ErrNotFound) {
+		s.NotFoundWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, task, nil)
+}
+
+// TaskCancelPost is a POST HTTP request handler which requests cancellation of the queued or running task
+// with the ID given as a parameter and returns its status. It requires the elevated role.
+func (s *Service) TaskCancelPost(w http.ResponseWriter, req *http.Request, params waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	if !s.validateEmptyRequest(w, req) {
+		return
+	}
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	id, errE := identifier.FromString(params["id"])
+	if errE != nil {
+		s.BadRequestWithError(w, req, errors.WithMessage(errE, `"id" is not a valid identifier`))
+		return
+	}
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	task, errE := waf.MustGetSite[*Site](ctx).tasks.Cancel(ctx, id)
+	m.Stop()
+	if errors.Is(errE, tasks.ErrNotFound) {
+		s.NotFoundWithError(w, req, errE)
+		return
+	} else if errors.Is(errE, tasks.ErrAlreadyFinished) {
+		s.replyWithError(w, req, http.StatusConflict, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	s.WriteJSON(w, req, task, nil)
+}
+
+// AdminTasksGet is a GET/HEAD HTTP request handler which returns tasks of the site, newest first.
+// Optional "status" parameter limits tasks to those with the status. It requires the elevated role.
+func (s *Service) AdminTasksGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	status, errE := tasks.ParseStatus(req.Form.Get("status"))
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	list, errE := waf.MustGetSite[*Site](ctx).tasks.List(ctx, status)
+	m.Stop()
+	if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, list, nil)
+}
+
+// cutover applies current synonym rules to the concrete index of the next generation of the site's
+// index and switches reads to it. Synonym rules of the site's index are not updated meanwhile, so
+// synonym sets changed while the next generation was being built are applied to it, too.
+func (s *Service) cutover(ctx context.Context, site *Site, index string) errors.E {
+	s.synonymsMu.Lock()
+	defer s.synonymsMu.Unlock()
+
+	rules, errE := synonymRules(ctx, site)
+	if errE != nil {
+		return errE
+	}
+	errE = es.UpdateSynonyms(ctx, s.esClient, index, rules, s.synonymsDir)
+	if errE != nil {
+		return errE
+	}
+
+	// Pagination sessions started before cutover can continue until they expire.
+	return site.generations.Cutover(ctx, s.paginationKeepAlive)
+}
+
+// AdminReindexPost is a POST HTTP request handler which starts a task reindexing the latest
+// version of all documents of the site into a new generation of the site's index, created with
+// the current mapping (e.g., to backfill materialized sort keys). Reads use the previous generation
+// until the new generation is populated, when reads are switched to it. It requires the elevated role.
+func (s *Service) AdminReindexPost(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	if !s.requireElevated(w, req) {
+		return
+	}
+
+	if !s.validateEmptyRequest(w, req) {
+		return
+	}
+
+	ctx := req.Context()
+	site := waf.MustGetSite[*Site](ctx)
+
+	s.startTask(w, req, "reindex", func(ctx context.Context, progress tasks.Progress) (string, errors.E) {
+		// The total is an estimate because documents can be added or removed while reindexing.
+		total, err := s.esClient.Count(site.Index).Do(ctx)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		rules, errE := synonymRules(ctx, site)
+		if errE != nil {
+			return "", errE
+		}
+
+		index, errE := site.generations.Begin(ctx, site.SizeField, rules, s.synonymsDir)
+		if errE != nil {
+			return "", errE
+		}
+
+		_, errE = reindexDocuments(ctx, site.store, site.esProcessor, site.references, site.generations, index, func(count int64) {
+			progress(count, total)
+		})
+		if errE == nil {
+			errE = errors.WithStack(site.esProcessor.Flush())
+		}
+		if errE == nil {
+			errE = s.cutover(ctx, site, index)
+		}
+		if errE != nil {
+			// We use a background context because ctx might be canceled already.
+			errE2 := site.generations.Abort(context.Background()) //nolint:contextcheck
+			return "", errors.Join(errE, errE2)
+		}
+
+		return "", nil
+	})
+}
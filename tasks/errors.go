@@ -0,0 +1,10 @@
+package tasks
+
+import "gitlab.com/tozd/go/errors"
+
+var (
+	ErrInvalidArgument = errors.Base("invalid argument")
+	ErrNotFound        = errors.Base("task not found")
+	ErrAlreadyFinished = errors.Base("task already finished")
+	ErrCanceled        = errors.Base("task canceled")
+)
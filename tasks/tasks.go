@@ -0,0 +1,423 @@
+// Package tasks provides asynchronous execution of long-running operations with their
+// state and progress stored in PostgreSQL, so that callers can poll their status
+// (possibly through another instance) and cancel them.
+package tasks
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+)
+
+const (
+	// DefaultWorkers is the default number of tasks run concurrently by an instance.
+	DefaultWorkers = 2
+
+	// MaxListSize is the maximum number of tasks returned by List.
+	MaxListSize = 1000
+
+	// heartbeatInterval is how often state of a queued or running task is stored.
+	heartbeatInterval = 5 * time.Second
+
+	// staleAfter is the duration after which a queued or running task whose state has not been stored
+	// is considered interrupted (e.g., because the instance running it has been stopped).
+	// It is used in SQL queries.
+	staleAfter = "1 minute"
+
+	// errorInterrupted is the error of interrupted tasks.
+	errorInterrupted = "task interrupted"
+)
+
+// Status is the status of a task.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// ParseStatus parses the status, which can be empty (all tasks).
+func ParseStatus(s string) (Status, errors.E) {
+	switch status := Status(s); status {
+	case "", StatusQueued, StatusRunning, StatusSucceeded, StatusFailed, StatusCanceled:
+		return status, nil
+	default:
+		errE := errors.WithMessage(ErrInvalidArgument, "unknown status")
+		errors.Details(errE)["status"] = s
+		return "", errE
+	}
+}
+
+// Task is the state of an asynchronous operation.
+type Task struct {
+	ID identifier.Identifier `json:"id"`
+	// Type describes the operation (e.g., "reindex").
+	Type   string `json:"type"`
+	Status Status `json:"status"`
+
+	// Done and Total are progress of the task, in units specific to the type of the task.
+	// Total is zero if it is not known.
+	Done  int64 `json:"done"`
+	Total int64 `json:"total,omitempty"`
+
+	// Error is set when the task failed.
+	Error string `json:"error,omitempty"`
+
+	// Result is set when the task succeeded and produced a result,
+	// in a format specific to the type of the task (e.g., ID of an exported file).
+	Result string `json:"result,omitempty"`
+
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Progress reports progress of a running task. Total is zero if it is not known.
+type Progress func(done, total int64)
+
+// Func is the operation run by a task. It should stop when ctx is canceled.
+// It returns the result of the task, which can be empty.
+type Func func(ctx context.Context, progress Progress) (string, errors.E)
+
+// Tasks runs tasks and stores their state in PostgreSQL.
+//
+// Tasks are run by the instance which enqueued them, at most Workers at a time,
+// and others wait in the queue. Tasks run by an instance which has been stopped
+// are reported as failed once their state is not stored for some time.
+//
+// Times are taken from the database so that clocks of instances do not have to be in sync.
+type Tasks struct {
+	// Prefix to use when initializing PostgreSQL objects used by tasks.
+	Prefix string
+
+	// Workers is the number of tasks run concurrently. If zero, DefaultWorkers is used.
+	Workers int
+
+	dbpool  *pgxpool.Pool
+	workers chan struct{}
+	mu      sync.Mutex
+	running map[identifier.Identifier]context.CancelFunc
+}
+
+// Init initializes the Tasks.
+//
+// It creates and configures the PostgreSQL table if it does not already exist.
+func (t *Tasks) Init(ctx context.Context, dbpool *pgxpool.Pool) errors.E {
+	if t.dbpool != nil {
+		return errors.New("already initialized")
+	}
+
+	if t.Workers == 0 {
+		t.Workers = DefaultWorkers
+	}
+
+	// TODO: Use schema management/migration instead.
+	errE := internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		_, err := tx.Exec(ctx, `
+			CREATE TABLE "`+t.Prefix+`Tasks" (
+				"id" text STORAGE PLAIN COLLATE "C" NOT NULL,
+				"type" text NOT NULL,
+				"status" text NOT NULL,
+				"done" bigint NOT NULL,
+				"total" bigint NOT NULL,
+				"error" text,
+				"result" text,
+				"created" timestamptz NOT NULL,
+				"started" timestamptz,
+				"finished" timestamptz,
+				-- When was the state of the task last stored.
+				"updated" timestamptz NOT NULL,
+				-- Has cancellation of the task been requested.
+				"cancel" boolean NOT NULL,
+				PRIMARY KEY ("id")
+			);
+			CREATE INDEX ON "`+t.Prefix+`Tasks" USING btree ("created", "id");
+		`)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+
+		return nil
+	}, nil)
+	if errE != nil {
+		var pgError *pgconn.PgError
+		if errors.As(errE, &pgError) {
+			switch pgError.Code {
+			case internal.ErrorCodeUniqueViolation:
+				// Nothing.
+			case internal.ErrorCodeDuplicateTable:
+				// Nothing.
+			case internal.ErrorCodeReadOnlyTransaction:
+				// In read-only mode, tables have to be created by another instance.
+			default:
+				return errE
+			}
+		} else {
+			return errE
+		}
+	}
+
+	t.dbpool = dbpool
+	t.workers = make(chan struct{}, t.Workers)
+	t.running = map[identifier.Identifier]context.CancelFunc{}
+
+	return nil
+}
+
+// stale is an SQL condition which is true for queued or running tasks which have been interrupted.
+const stale = `("status" IN ('queued', 'running') AND "updated" < now() - interval '` + staleAfter + `')`
+
+// columns are columns of a task in the order expected by scanTask. Interrupted tasks are reported as failed.
+const columns = `"id", "type", CASE WHEN ` + stale + ` THEN 'failed' ELSE "status" END, "done", "total", ` +
+	`CASE WHEN ` + stale + ` THEN '` + errorInterrupted + `' ELSE "error" END, "result", "created", "started", "finished"`
+
+func scanTask(row pgx.Row) (Task, error) {
+	var task Task
+	var id, status string
+	var e, result *string
+	err := row.Scan(&id, &task.Type, &status, &task.Done, &task.Total, &e, &result, &task.Created, &task.Started, &task.Finished)
+	if err != nil {
+		return task, err
+	}
+	task.ID = identifier.MustFromString(id)
+	task.Status = Status(status)
+	if e != nil {
+		task.Error = *e
+	}
+	if result != nil {
+		task.Result = *result
+	}
+	return task, nil
+}
+
+// Enqueue creates a new task of the type and runs it in the background once a worker is available.
+//
+// The task runs with a context which has values of ctx but which is not canceled when ctx is.
+// Its state is stored periodically and when it finishes.
+func (t *Tasks) Enqueue(ctx context.Context, taskType string, fn Func) (*Task, errors.E) {
+	id := identifier.New()
+	var task Task
+	errE := internal.RetryTransaction(ctx, t.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		var err error
+		task, err = scanTask(tx.QueryRow(ctx, `
+			INSERT INTO "`+t.Prefix+`Tasks" VALUES ($1, $2, 'queued', 0, 0, NULL, NULL, now(), NULL, NULL, now(), FALSE)
+				RETURNING `+columns+`
+		`, id.String(), taskType))
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["type"] = taskType
+		return nil, errE
+	}
+
+	taskCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	t.mu.Lock()
+	t.running[id] = cancel
+	t.mu.Unlock()
+
+	go t.run(taskCtx, cancel, id, fn) //nolint:contextcheck
+
+	return &task, nil
+}
+
+// run waits for a worker, runs the task, and stores its state.
+func (t *Tasks) run(ctx context.Context, cancel context.CancelFunc, id identifier.Identifier, fn Func) {
+	defer func() {
+		t.mu.Lock()
+		delete(t.running, id)
+		t.mu.Unlock()
+		cancel()
+	}()
+
+	var done, total atomic.Int64
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+	go t.heartbeat(ctx, cancel, id, &done, &total, heartbeatDone)
+
+	var errE errors.E
+	var result string
+	select {
+	case <-ctx.Done():
+		errE = errors.WithStack(ErrCanceled)
+	case t.workers <- struct{}{}:
+		defer func() { <-t.workers }()
+		errE = t.update(ctx, id, `"status"='running', "started"=now()`)
+		if errE == nil {
+			result, errE = fn(ctx, func(d, n int64) {
+				done.Store(d)
+				total.Store(n)
+			})
+		}
+	}
+
+	// The context of the task is canceled only when cancellation of the task has been requested.
+	canceled := ctx.Err() != nil
+	// We store the final state even if the task has been canceled.
+	ctx = context.WithoutCancel(ctx)
+	status := StatusSucceeded
+	var e, r *string
+	if errE != nil {
+		if canceled {
+			status = StatusCanceled
+		} else {
+			status = StatusFailed
+		}
+		m := errE.Error()
+		e = &m
+		zerolog.Ctx(ctx).Error().Err(errE).Str("task", id.String()).Msg("task failed")
+	} else if result != "" {
+		r = &result
+	}
+	errE = t.update(
+		ctx, id, `"status"=$2, "error"=$3, "result"=$4, "done"=$5, "total"=$6, "finished"=now()`,
+		string(status), e, r, done.Load(), total.Load(),
+	)
+	if errE != nil {
+		zerolog.Ctx(ctx).Error().Err(errE).Str("task", id.String()).Msg("unable to store task state")
+	}
+}
+
+// heartbeat periodically stores progress of the task and cancels it if cancellation has been requested.
+func (t *Tasks) heartbeat(
+	ctx context.Context, cancel context.CancelFunc, id identifier.Identifier, done, total *atomic.Int64, stop <-chan struct{},
+) {
+	// We continue to store progress while a canceled task is stopping.
+	ctx = context.WithoutCancel(ctx)
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		var c bool
+		errE := internal.RetryTransaction(ctx, t.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+			err := tx.QueryRow(ctx, `
+				UPDATE "`+t.Prefix+`Tasks" SET "updated"=now(), "done"=$2, "total"=$3 WHERE "id"=$1 RETURNING "cancel"
+			`, id.String(), done.Load(), total.Load()).Scan(&c)
+			return internal.WithPgxError(err)
+		}, nil)
+		if errE != nil {
+			zerolog.Ctx(ctx).Error().Err(errE).Str("task", id.String()).Msg("unable to store task state")
+			continue
+		}
+		if c {
+			cancel()
+		}
+	}
+}
+
+// update updates the task with the SQL assignments. $1 is the task ID and other
+// parameters are provided as arguments.
+func (t *Tasks) update(ctx context.Context, id identifier.Identifier, assignments string, arguments ...any) errors.E {
+	return internal.RetryTransaction(ctx, t.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		_, err := tx.Exec(ctx, `UPDATE "`+t.Prefix+`Tasks" SET "updated"=now(), `+assignments+` WHERE "id"=$1`, append([]any{id.String()}, arguments...)...)
+		return internal.WithPgxError(err)
+	}, nil)
+}
+
+// Get returns the task with the ID.
+func (t *Tasks) Get(ctx context.Context, id identifier.Identifier) (*Task, errors.E) {
+	var task Task
+	errE := internal.RetryTransaction(ctx, t.dbpool, pgx.ReadOnly, func(ctx context.Context, tx pgx.Tx) errors.E {
+		var err error
+		task, err = scanTask(tx.QueryRow(ctx, `SELECT `+columns+` FROM "`+t.Prefix+`Tasks" WHERE "id"=$1`, id.String()))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.WithStack(ErrNotFound)
+		}
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["id"] = id.String()
+		return nil, errE
+	}
+	return &task, nil
+}
+
+// List returns up to MaxListSize tasks with the status (all tasks if status is empty), newest first.
+func (t *Tasks) List(ctx context.Context, status Status) ([]Task, errors.E) {
+	sql := `SELECT ` + columns + ` FROM "` + t.Prefix + `Tasks"`
+	arguments := []any{}
+	if status != "" {
+		arguments = append(arguments, string(status))
+		sql += ` WHERE CASE WHEN ` + stale + ` THEN 'failed' ELSE "status" END=$1`
+	}
+	arguments = append(arguments, MaxListSize)
+	sql += ` ORDER BY "created" DESC, "id" DESC LIMIT $` + strconv.Itoa(len(arguments))
+
+	var tasks []Task
+	errE := internal.RetryTransaction(ctx, t.dbpool, pgx.ReadOnly, func(ctx context.Context, tx pgx.Tx) errors.E {
+		rows, err := tx.Query(ctx, sql, arguments...)
+		if err != nil {
+			return internal.WithPgxError(err)
+		}
+		tasks, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Task, error) {
+			return scanTask(row)
+		})
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		return nil, errE
+	}
+	if tasks == nil {
+		tasks = []Task{}
+	}
+	return tasks, nil
+}
+
+// Cancel requests cancellation of the queued or running task. Tasks run by this instance
+// are canceled immediately, those run by other instances when they next store their state.
+// It returns ErrAlreadyFinished if the task has already finished.
+func (t *Tasks) Cancel(ctx context.Context, id identifier.Identifier) (*Task, errors.E) {
+	var task Task
+	errE := internal.RetryTransaction(ctx, t.dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		var err error
+		task, err = scanTask(tx.QueryRow(ctx, `
+			UPDATE "`+t.Prefix+`Tasks" SET "cancel"=TRUE
+				WHERE "id"=$1 AND "status" IN ('queued', 'running') AND NOT `+stale+`
+				RETURNING `+columns+`
+		`, id.String()))
+		if errors.Is(err, pgx.ErrNoRows) {
+			var exists bool
+			err = tx.QueryRow(ctx, `SELECT TRUE FROM "`+t.Prefix+`Tasks" WHERE "id"=$1`, id.String()).Scan(&exists)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return errors.WithStack(ErrNotFound)
+			} else if err != nil {
+				return internal.WithPgxError(err)
+			}
+			return errors.WithStack(ErrAlreadyFinished)
+		}
+		return internal.WithPgxError(err)
+	}, nil)
+	if errE != nil {
+		errors.Details(errE)["id"] = id.String()
+		return nil, errE
+	}
+
+	t.mu.Lock()
+	cancel, ok := t.running[id]
+	t.mu.Unlock()
+	if ok {
+		cancel()
+	}
+
+	return &task, nil
+}
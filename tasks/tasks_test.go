@@ -0,0 +1,157 @@
+package tasks_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/identifier"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/tasks"
+)
+
+func initDatabase(t *testing.T) (context.Context, *pgxpool.Pool, string) {
+	t.Helper()
+
+	if os.Getenv("POSTGRES") == "" {
+		t.Skip("POSTGRES is not available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	logger := zerolog.New(zerolog.NewTestWriter(t)).With().Timestamp().Logger()
+	schema := identifier.New().String()
+	prefix := identifier.New().String() + "_"
+
+	dbpool, errE := internal.InitPostgres(ctx, os.Getenv("POSTGRES"), logger, func(context.Context) (string, string) {
+		return schema, "tests"
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	errE = internal.RetryTransaction(ctx, dbpool, pgx.ReadWrite, func(ctx context.Context, tx pgx.Tx) errors.E {
+		return internal.EnsureSchema(ctx, tx, schema)
+	}, nil)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	return ctx, dbpool, prefix
+}
+
+func waitFinished(ctx context.Context, t *testing.T, ts *tasks.Tasks, id identifier.Identifier) *tasks.Task {
+	t.Helper()
+
+	var task *tasks.Task
+	require.Eventually(t, func() bool {
+		var errE errors.E
+		task, errE = ts.Get(ctx, id)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		return task.Finished != nil
+	}, 10*time.Second, 10*time.Millisecond)
+	return task
+}
+
+func TestParseStatus(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"", "queued", "running", "succeeded", "failed", "canceled"} {
+		status, errE := tasks.ParseStatus(s)
+		require.NoError(t, errE, "% -+#.1v", errE)
+		assert.Equal(t, tasks.Status(s), status)
+	}
+
+	_, errE := tasks.ParseStatus("done")
+	assert.ErrorIs(t, errE, tasks.ErrInvalidArgument)
+}
+
+func TestTasks(t *testing.T) {
+	t.Parallel()
+
+	ctx, dbpool, prefix := initDatabase(t)
+
+	ts := &tasks.Tasks{Prefix: prefix, Workers: 1}
+	errE := ts.Init(ctx, dbpool)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	succeeded, errE := ts.Enqueue(ctx, "test", func(_ context.Context, progress tasks.Progress) (string, errors.E) {
+		progress(3, 3)
+		return "result", nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, "test", succeeded.Type)
+	assert.Equal(t, tasks.StatusQueued, succeeded.Status)
+
+	task := waitFinished(ctx, t, ts, succeeded.ID)
+	assert.Equal(t, tasks.StatusSucceeded, task.Status)
+	assert.Equal(t, int64(3), task.Done)
+	assert.Equal(t, int64(3), task.Total)
+	assert.NotNil(t, task.Started)
+	assert.Empty(t, task.Error)
+	assert.Equal(t, "result", task.Result)
+
+	failed, errE := ts.Enqueue(ctx, "test", func(_ context.Context, _ tasks.Progress) (string, errors.E) {
+		return "ignored", errors.New("test error")
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	task = waitFinished(ctx, t, ts, failed.ID)
+	assert.Equal(t, tasks.StatusFailed, task.Status)
+	assert.Equal(t, "test error", task.Error)
+	assert.Empty(t, task.Result)
+
+	started := make(chan struct{})
+	canceled, errE := ts.Enqueue(ctx, "test", func(ctx context.Context, _ tasks.Progress) (string, errors.E) {
+		close(started)
+		<-ctx.Done()
+		return "", errors.WithStack(ctx.Err())
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+	// With one worker, this task waits in the queue until the previous one finishes.
+	queued, errE := ts.Enqueue(ctx, "test", func(_ context.Context, _ tasks.Progress) (string, errors.E) {
+		return "", nil
+	})
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	<-started
+
+	task, errE = ts.Get(ctx, queued.ID)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	assert.Equal(t, tasks.StatusQueued, task.Status)
+
+	list, errE := ts.List(ctx, tasks.StatusRunning)
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, list, 1)
+	assert.Equal(t, canceled.ID, list[0].ID)
+
+	_, errE = ts.Cancel(ctx, canceled.ID)
+	require.NoError(t, errE, "% -+#.1v", errE)
+
+	task = waitFinished(ctx, t, ts, canceled.ID)
+	assert.Equal(t, tasks.StatusCanceled, task.Status)
+
+	task = waitFinished(ctx, t, ts, queued.ID)
+	assert.Equal(t, tasks.StatusSucceeded, task.Status)
+
+	_, errE = ts.Cancel(ctx, succeeded.ID)
+	assert.ErrorIs(t, errE, tasks.ErrAlreadyFinished)
+
+	_, errE = ts.Cancel(ctx, identifier.New())
+	assert.ErrorIs(t, errE, tasks.ErrNotFound)
+
+	_, errE = ts.Get(ctx, identifier.New())
+	assert.ErrorIs(t, errE, tasks.ErrNotFound)
+
+	list, errE = ts.List(ctx, "")
+	require.NoError(t, errE, "% -+#.1v", errE)
+	require.Len(t, list, 4)
+	// Newest first.
+	assert.Equal(t, queued.ID, list[0].ID)
+	assert.Equal(t, succeeded.ID, list[3].ID)
+}
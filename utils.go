@@ -60,6 +60,8 @@ func InsertOrReplaceDocument(
 	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	doc *document.D,
 ) errors.E {
+	// Elements of lists are stored in order.
+	doc.SortLists()
 	data, errE := x.MarshalWithoutEscapeHTML(doc)
 	if errE != nil {
 		return errE
@@ -74,18 +76,71 @@ func UpdateDocument(
 	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
 	doc *document.D, version store.Version,
 ) errors.E {
+	// TODO: Set patch. Or update revision?
+	//       Especially if this is done while preparing for a commit a changeset of multiple changes? But then we should not be calling store.Update but changeset.Update.
+	return UpdateDocumentWithChanges(ctx, store, doc, version, document.Changes{})
+}
+
+// UpdateDocumentWithChanges is like UpdateDocument, but it also records changes which have been
+// applied to the document since the version was fetched.
+func UpdateDocumentWithChanges(
+	ctx context.Context,
+	store *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	doc *document.D, version store.Version, changes document.Changes,
+) errors.E {
+	// Elements of lists are stored in order.
+	doc.SortLists()
 	data, errE := x.MarshalWithoutEscapeHTML(doc)
 	if errE != nil {
 		return errE
 	}
 
 	// Store does not allow multiple latest versions so if document has been updated in meantime it cannot be updated again and the call will fail.
-	// TODO: Set patch. Or update revision?
-	//       Especially if this is done while preparing for a commit a changeset of multiple changes? But then we should not be calling store.Update but changeset.Update.
-	_, errE = store.Update(ctx, doc.ID, version.Changeset, data, document.Changes{}, &types.DocumentMetadata{At: types.Time(time.Now().UTC())}, &types.NoMetadata{})
+	_, errE = store.Update(ctx, doc.ID, version.Changeset, data, changes, &types.DocumentMetadata{At: types.Time(time.Now().UTC())}, &types.NoMetadata{})
 	return errE
 }
 
+// upsertDocument inserts the document if it does not yet exist, or updates its latest version otherwise.
+// If the latest version has the same content as the document, it is not updated and false is returned.
+func upsertDocument(
+	ctx context.Context,
+	s *store.Store[json.RawMessage, *types.DocumentMetadata, *types.NoMetadata, *types.NoMetadata, *types.NoMetadata, document.Changes],
+	doc *document.D,
+) (bool, errors.E) {
+	data, _, version, errE := s.GetLatest(ctx, doc.ID)
+	if errors.Is(errE, store.ErrValueNotFound) {
+		return true, InsertOrReplaceDocument(ctx, s, doc)
+	} else if errE != nil {
+		return false, errE
+	}
+	unchanged, errE := documentUnchanged(data, doc)
+	if errE != nil {
+		return false, errE
+	}
+	if unchanged {
+		return false, nil
+	}
+	return true, UpdateDocument(ctx, s, doc, version)
+}
+
+// documentUnchanged returns true if the JSON of the existing document has the same content as the document.
+func documentUnchanged(data json.RawMessage, doc *document.D) (bool, errors.E) {
+	var existing document.D
+	errE := x.UnmarshalWithoutUnknownFields(data, &existing)
+	if errE != nil {
+		return false, errE
+	}
+	existingHash, errE := document.Hash(&existing)
+	if errE != nil {
+		return false, errE
+	}
+	hash, errE := document.Hash(doc)
+	if errE != nil {
+		return false, errE
+	}
+	return existingHash == hash, nil
+}
+
 func getRequestWithFallback(logger zerolog.Logger) func(context.Context) (string, string) {
 	return func(ctx context.Context) (string, string) {
 		var requestID string
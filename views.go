@@ -0,0 +1,145 @@
+package peerdb
+
+import (
+	"io"
+	"net/http"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/x"
+	"gitlab.com/tozd/identifier"
+	"gitlab.com/tozd/waf"
+
+	internal "gitlab.com/peerdb/peerdb/internal/store"
+	"gitlab.com/peerdb/peerdb/store"
+)
+
+// viewsCookie is the name of the cookie identifying views sessions of callers without an API key.
+const viewsCookie = "views"
+
+type trackViewRequest struct {
+	ID identifier.Identifier `json:"id"`
+}
+
+// viewsSession returns the views session of the caller of the request and true, if it has one.
+//
+// Callers with an API key have a session per API key (see apiKey). Other callers are identified
+// by a session ID stored in a signed views cookie. If w is not nil and such a caller does not have
+// a valid cookie, a new session is started and the cookie is set on the response.
+func (s *Service) viewsSession(w http.ResponseWriter, req *http.Request) (string, bool) {
+	if key := apiKey(req); key != publicAPIKey {
+		return key, true
+	}
+
+	if cookie, err := req.Cookie(viewsCookie); err == nil {
+		if value, ok := s.verifyCookieValue(cookie.Value); ok {
+			if session, errE := identifier.FromString(value); errE == nil {
+				return "session-" + session.String(), true
+			}
+		}
+	}
+
+	if w == nil {
+		return "", false
+	}
+
+	session := identifier.New()
+	// The cookie is a browser session cookie, views sessions themselves are forgotten after inactivity.
+	http.SetCookie(w, &http.Cookie{ //nolint:exhaustruct
+		Name:     viewsCookie,
+		Value:    s.signCookieValue(session.String()),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	// Responses setting the cookie must not be stored by shared caches.
+	w.Header().Set("Cache-Control", "private, no-cache")
+	return "session-" + session.String(), true
+}
+
+// TrackViewPost is a POST HTTP request handler which records that the document was viewed
+// in the caller's views session (see viewsSession), for trending and recently viewed documents.
+// The number of views a caller can track is rate limited. Views are not recorded when the caller
+// opted out of tracking.
+func (s *Service) TrackViewPost(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	defer req.Body.Close()
+	defer io.Copy(io.Discard, req.Body) //nolint:errcheck
+
+	ctx := req.Context()
+	metrics := waf.MustGetMetrics(ctx)
+
+	buffer, err := io.ReadAll(io.LimitReader(req.Body, maxPayloadSize))
+	if err != nil {
+		s.BadRequestWithError(w, req, errors.WithStack(err))
+		return
+	}
+
+	if !s.validateJSON(w, req, "trackViewRequest", buffer) {
+		return
+	}
+
+	var r trackViewRequest
+	errE := x.UnmarshalWithoutUnknownFields(buffer, &r)
+	if errE != nil {
+		s.BadRequestWithError(w, req, errE)
+		return
+	}
+
+	if personalizationOptOut(req) {
+		s.WriteJSON(w, req, []byte(`{"success":true}`), nil)
+		return
+	}
+
+	errE = s.viewsLimiter.Allow(clientKey(req))
+	if errE != nil {
+		s.replyWithError(w, req, http.StatusTooManyRequests, errE)
+		return
+	}
+
+	site := waf.MustGetSite[*Site](ctx)
+
+	// We check that the document exists so that only existing documents can be trending.
+	m := metrics.Duration(internal.MetricDatabase).Start()
+	_, _, _, errE = site.store.GetLatest(ctx, r.ID) //nolint:dogsled
+	m.Stop()
+	if errors.Is(errE, store.ErrValueNotFound) || errors.Is(errE, store.ErrValueDeleted) {
+		s.NotFoundWithError(w, req, errE)
+		return
+	} else if errE != nil {
+		s.InternalServerErrorWithError(w, req, errE)
+		return
+	}
+
+	session, _ := s.viewsSession(w, req)
+	site.views.RecordView(session, r.ID)
+
+	s.WriteJSON(w, req, []byte(`{"success":true}`), nil)
+}
+
+// TrendingGet is a GET/HEAD HTTP request handler which returns documents with the most views
+// within the sliding window, the most viewed first, together with their numbers of views.
+func (s *Service) TrendingGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	site := waf.MustGetSite[*Site](req.Context())
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.WriteJSON(w, req, site.views.Trending(), nil)
+}
+
+// RecentGet is a GET/HEAD HTTP request handler which returns documents recently viewed
+// in the caller's views session (see viewsSession), the most recent first.
+func (s *Service) RecentGet(w http.ResponseWriter, req *http.Request, _ waf.Params) {
+	site := waf.MustGetSite[*Site](req.Context())
+
+	results := []searchResult{}
+	if session, ok := s.viewsSession(nil, req); ok {
+		for _, id := range site.views.Recent(session) {
+			results = append(results, searchResult{ID: id.String(), Matched: nil})
+		}
+	}
+
+	// Responses depend on the caller, so they must not be stored by shared caches.
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	s.WriteJSON(w, req, results, nil)
+}
@@ -0,0 +1,53 @@
+package peerdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViewsSession(t *testing.T) {
+	t.Parallel()
+
+	s := &Service{secret: []byte("secret")} //nolint:exhaustruct
+
+	// Callers without an API key and a cookie obtain a new session.
+	req := httptest.NewRequest(http.MethodPost, "/api/track/view", nil)
+	w := httptest.NewRecorder()
+	session, ok := s.viewsSession(w, req)
+	require.True(t, ok)
+	cookies := responseCookies(t, w)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, viewsCookie, cookies[0].Name)
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+
+	// The session is identified by the cookie, also without a response.
+	req = httptest.NewRequest(http.MethodGet, "/api/recent", nil)
+	req.AddCookie(cookies[0])
+	cookieSession, ok := s.viewsSession(nil, req)
+	require.True(t, ok)
+	assert.Equal(t, session, cookieSession)
+
+	// Without a response, new sessions are not started.
+	req = httptest.NewRequest(http.MethodGet, "/api/recent", nil)
+	_, ok = s.viewsSession(nil, req)
+	assert.False(t, ok)
+
+	// Cookies with an invalid signature are ignored.
+	req = httptest.NewRequest(http.MethodGet, "/api/recent", nil)
+	req.AddCookie(&http.Cookie{Name: viewsCookie, Value: cookies[0].Value + "x"}) //nolint:exhaustruct
+	_, ok = s.viewsSession(nil, req)
+	assert.False(t, ok)
+
+	// Callers with an API key are identified by it.
+	req = httptest.NewRequest(http.MethodPost, "/api/track/view", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	w = httptest.NewRecorder()
+	keySession, ok := s.viewsSession(w, req)
+	require.True(t, ok)
+	assert.Equal(t, apiKey(req), keySession)
+	assert.Empty(t, responseCookies(t, w))
+}